@@ -1,13 +1,21 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/syncore/a2sapi/src/config"
 	"github.com/syncore/a2sapi/src/constants"
 	"github.com/syncore/a2sapi/src/db"
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/models"
 	"github.com/syncore/a2sapi/src/steam"
 	"github.com/syncore/a2sapi/src/steam/filters"
 	"github.com/syncore/a2sapi/src/util"
@@ -18,12 +26,22 @@ var (
 	doConfig       bool
 	useDebugConfig bool
 	runSilent      bool
+	profile        string
+	replayFile     string
+	runOnce        bool
+	toStdout       bool
+	directHosts    string
 )
 
 const (
-	configFlag = "config"
-	debugFlag  = "debug"
-	silentFlag = "silent"
+	configFlag  = "config"
+	debugFlag   = "debug"
+	silentFlag  = "silent"
+	profileFlag = "profile"
+	replayFlag  = "replay"
+	onceFlag    = "once"
+	stdoutFlag  = "stdout"
+	hostsFlag   = "hosts"
 )
 
 func init() {
@@ -31,11 +49,46 @@ func init() {
 	flag.BoolVar(&useDebugConfig, debugFlag, false, "Use debug mode configuration file")
 	flag.BoolVar(&runSilent, silentFlag, false,
 		"Launch without displaying startup information")
+	flag.StringVar(&profile, profileFlag, "", fmt.Sprintf(
+		"Use the named configuration profile (i.e: dev); can also be set with %s",
+		constants.ProfileEnvVar))
+	flag.StringVar(&replayFile, replayFlag, "",
+		"Build the server list from a raw cycle recording instead of querying servers")
+	flag.BoolVar(&runOnce, onceFlag, false,
+		"Perform a single retrieval (or direct query of --hosts) and exit")
+	flag.BoolVar(&toStdout, stdoutFlag, false,
+		"With --once, write the server list as JSON to stdout")
+	flag.StringVar(&directHosts, hostsFlag, "",
+		"With --once --stdout, query these comma-separated hosts (ip:port) directly")
 }
 
 func main() {
 	flag.Parse()
 
+	if flag.Arg(0) == diffCommand {
+		os.Exit(runDiff(flag.Args()[1:]))
+	}
+
+	if profile == "" {
+		profile = os.Getenv(constants.ProfileEnvVar)
+	}
+	if profile != "" {
+		if !constants.IsValidProfileName(profile) {
+			fmt.Printf("Invalid profile name '%s'. Profile names may only contain letters, numbers, dashes, and underscores.\n",
+				profile)
+			os.Exit(1)
+		}
+		constants.Profile = profile
+	}
+
+	// after the profile is set, since the database files are profile-qualified
+	if flag.Arg(0) == restoreCommand {
+		os.Exit(runRestore(flag.Args()[1:]))
+	}
+	if flag.Arg(0) == analyzeCommand {
+		os.Exit(runAnalyze(flag.Args()[1:]))
+	}
+
 	if doConfig {
 		if !util.FileExists(constants.GameFileFullPath) {
 			filters.DumpDefaultGames()
@@ -58,59 +111,164 @@ func launch(isDebug bool) {
 		filters.DumpDefaultGames()
 	}
 	if !isDebug {
-		if !util.FileExists(constants.ConfigFilePath) {
+		if !util.FileExists(constants.GetCfgPath()) {
 			fmt.Printf("Could not read configuration file '%s' in the '%s' directory.\n",
-				constants.ConfigFilename, constants.ConfigDirectory)
-			fmt.Printf("You must generate the configuration file with: %s --%s\n",
-				os.Args[0], configFlag)
+				constants.ProfileFilename(constants.ConfigFilename),
+				constants.ConfigDirectory)
+			if constants.Profile != "" {
+				fmt.Printf("You must generate the configuration file with: %s --%s --%s %s\n",
+					os.Args[0], configFlag, profileFlag, constants.Profile)
+			} else {
+				fmt.Printf("You must generate the configuration file with: %s --%s\n",
+					os.Args[0], configFlag)
+			}
 			os.Exit(1)
 		}
 	}
 	// Initialize the application-wide configuration
 	config.InitConfig()
+	models.SetCompactGameLists(config.Get().SteamConfig.CompactServerLists)
+	if err := models.SetJSONEncoding(models.JSONEncoding{
+		EscapeHTML: config.Get().WebConfig.JSONEscapeHTML,
+		EmptyLists: config.Get().WebConfig.JSONEmptyLists,
+		EmptyInfo:  config.Get().WebConfig.JSONEmptyInfo,
+	}); err != nil {
+		logger.LogAppErrorf("%s; using the default", err)
+	}
 	// Initialize the application-wide database connections (panic on failure)
 	db.InitDBs()
 
+	// cancelled on interrupt or termination, which shuts everything down
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt,
+		syscall.SIGTERM)
+	go func() {
+		// a second signal terminates immediately, without waiting for shutdown
+		<-ctx.Done()
+		stop()
+	}()
+
+	if runOnce {
+		status := launchOnce(ctx)
+		closeOutputsAndDBs()
+		os.Exit(status)
+	}
+
 	if !runSilent {
 		printStartInfo()
 	}
+	if secs := config.Get().SteamConfig.GamesFileReloadInterval; secs > 0 {
+		go steam.WatchGamesFile(ctx, time.Duration(secs)*time.Second)
+	}
+	if secs := config.Get().SteamConfig.GeoIPReloadInterval; secs > 0 {
+		go steam.WatchGeoIPFiles(ctx, time.Duration(secs)*time.Second)
+	}
+	if mins := config.Get().AdminConfig.DBBackupInterval; mins > 0 {
+		go steam.ScheduleDBBackups(ctx, time.Duration(mins)*time.Minute)
+	}
+	if config.Get().SteamConfig.RecordPlayerHistory {
+		// downsample and delete old player counts
+		go steam.ScheduleHistoryRetention(ctx, time.Hour)
+	}
+	if !isDebug {
+		// reload the configuration file on SIGHUP, and when it changes
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go steam.WatchConfigFile(ctx, time.Duration(
+			config.Get().AdminConfig.ConfigFileReloadInterval)*time.Second, hup)
+	}
 
-	if config.Config.SteamConfig.AutoQueryMaster {
-		autoQueryGame := filters.GetGameByName(
-			config.Config.SteamConfig.AutoQueryGame)
-		if autoQueryGame == filters.GameUnspecified {
-			fmt.Println("Invalid game specified for automatic timed query!")
-			fmt.Printf(
-				"You may need to delete: '%s' and/or recreate the config with: %s --%s",
-				constants.GameFileFullPath, os.Args[0], configFlag)
+	status := 0
+	if replayFile != "" {
+		// HTTP server + API serving the replayed list; no network queries
+		if _, err := steam.ReplayCycle(replayFile); err != nil {
+			fmt.Printf("Unable to replay '%s': %s\n", replayFile, err)
 			os.Exit(1)
 		}
-		// HTTP server + API + Steam auto-querier
-		go web.Start(runSilent)
-		filter := filters.NewFilter(autoQueryGame, filters.SrAll, nil)
-		stop := make(chan bool, 1)
-		go steam.StartMasterRetrieval(stop, filter, 7,
-			config.Config.SteamConfig.TimeBetweenMasterQueries)
-		<-stop
+		web.Start(ctx, runSilent)
+	} else if config.Get().SteamConfig.AutoQueryMaster {
+		autoQueryGames := getTimedQueryGames()
+		// serve the lists saved on the last shutdown until the first retrieval
+		if n, err := steam.RestoreState(config.Get().SteamConfig.TimedQueryGames()); err == nil && n > 0 && !runSilent {
+			fmt.Printf("Restored %d server lists from the last shutdown\n", n)
+		}
+		// HTTP server + API + Steam auto-querier (one per game)
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			web.Start(ctx, runSilent)
+		}()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			steam.RunTimedRetrievals(ctx, autoQueryGames)
+		}()
+		wg.Wait()
+		// save the lists so that they can be restored on the next startup
+		if err := steam.SaveState(); err != nil {
+			status = 1
+		}
 	} else {
 		// HTTP server + API standalone
-		web.Start(runSilent)
+		web.Start(ctx, runSilent)
+	}
+	closeOutputsAndDBs()
+	logger.LogAppInfo("Shutdown complete")
+	os.Exit(status)
+}
+
+// closeOutputsAndDBs finishes writing the outputs and closes the databases on
+// shutdown.
+func closeOutputsAndDBs() {
+	steam.CloseOutputs()
+	db.CloseDBs()
+}
+
+// getTimedQueryGames returns the games configured for the timed query, exiting
+// if any of them is invalid.
+func getTimedQueryGames() []filters.Game {
+	var games []filters.Game
+	for _, name := range config.Get().SteamConfig.TimedQueryGames() {
+		game := filters.GetGameByName(name)
+		if game == filters.GameUnspecified {
+			fmt.Fprintf(os.Stderr,
+				"Invalid game '%s' specified for automatic timed query!\n", name)
+			fmt.Fprintf(os.Stderr,
+				"You may need to delete: '%s' and/or recreate the config with: %s --%s\n",
+				constants.GameFileFullPath, os.Args[0], configFlag)
+			os.Exit(1)
+		}
+		games = append(games, game)
 	}
+	return games
 }
 
 func printStartInfo() {
 	fmt.Printf("%s\n", constants.AppInfo)
 	if useDebugConfig {
 		fmt.Println("NOTE: We're currently using debug the configuration!")
+	} else if constants.Profile != "" {
+		fmt.Printf("Using configuration profile: %s\n", constants.Profile)
 	}
-	if config.Config.SteamConfig.AutoQueryMaster {
+	if config.Get().SteamConfig.AutoQueryMaster {
 		fmt.Println("Automatic timed master server queries: enabled")
 		fmt.Printf("Automatic timed master server queries every %d seconds\n",
-			config.Config.SteamConfig.TimeBetweenMasterQueries)
-		fmt.Printf("Automatic timed master server query game: %s\n",
-			config.Config.SteamConfig.AutoQueryGame)
+			config.Get().SteamConfig.TimeBetweenMasterQueries)
+		fmt.Printf("Automatic timed master server query games: %s\n",
+			strings.Join(config.Get().SteamConfig.TimedQueryGames(), ", "))
 		fmt.Printf("Automatic timed master server query max hosts to receive: %d\n",
-			config.Config.SteamConfig.MaximumHostsToReceive)
+			config.Get().SteamConfig.MaximumHostsToReceive)
+		for _, g := range config.Get().SteamConfig.TimedQueryGames() {
+			s := config.Get().SteamConfig
+			if s.GameTimeBetweenQueries(g) == s.TimeBetweenMasterQueries &&
+				s.GameMaxHostsToReceive(g) == s.MaximumHostsToReceive &&
+				s.GameMasterFilter(g) == "" {
+				continue
+			}
+			fmt.Printf("Automatic timed master server query for %s: every %d seconds, max hosts: %d, filter: '%s'\n",
+				g, s.GameTimeBetweenQueries(g), s.GameMaxHostsToReceive(g),
+				s.GameMasterFilter(g))
+		}
 	} else {
 		fmt.Println("Automatic timed master server queries: disabled")
 	}
@@ -0,0 +1,118 @@
+package main
+
+// analyze.go - The analyze command, which summarizes a server's uptime, player
+// counts, and maps from the player count and map change history stored in the
+// application database.
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/constants"
+	"github.com/syncore/a2sapi/src/db"
+	"github.com/syncore/a2sapi/src/util"
+)
+
+const analyzeCommand = "analyze"
+
+// parseAnalyzePeriod parses an analysis period: a number of days (i.e. 30d) or
+// a duration (i.e. 12h).
+func parseAnalyzePeriod(val string) (time.Duration, error) {
+	var d time.Duration
+	var err error
+	if strings.HasSuffix(val, "d") {
+		var days int
+		days, err = strconv.Atoi(strings.TrimSuffix(val, "d"))
+		d = time.Duration(days) * 24 * time.Hour
+	} else {
+		d, err = time.ParseDuration(val)
+	}
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf(
+			"the period must be a number of days (i.e. 30d) or hours (i.e. 12h)")
+	}
+	return d, nil
+}
+
+// runAnalyze runs the analyze command with the given arguments and returns the
+// exit status: 0 on success and 1 on error.
+func runAnalyze(args []string) int {
+	fs := flag.NewFlagSet(analyzeCommand, flag.ContinueOnError)
+	id := fs.Int64("server-id", 0, "The ID of the server to analyze")
+	period := fs.String("period", "30d",
+		"The period of history to analyze, in days (i.e. 30d) or hours (i.e. 12h)")
+	format := fs.String("format", "json", "The output format: json or csv")
+	tz := fs.String("timezone", "UTC",
+		"The time zone of the busiest hours (i.e. Europe/Berlin)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr,
+			"Usage: %s %s --server-id id [--period 30d] [--format json|csv] [--timezone UTC]\n",
+			os.Args[0], analyzeCommand)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	if *id <= 0 || fs.NArg() != 0 || (*format != "json" && *format != "csv") {
+		fs.Usage()
+		return 1
+	}
+	d, err := parseAnalyzePeriod(*period)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid period: %s\n", err)
+		return 1
+	}
+	loc, err := time.LoadLocation(*tz)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid time zone: %s\n", err)
+		return 1
+	}
+	if !util.FileExists(constants.GetAppDBPath()) {
+		fmt.Fprintf(os.Stderr, "There is no application database at %s\n",
+			constants.GetAppDBPath())
+		return 1
+	}
+	// the configuration is needed to open an encrypted app DB
+	if util.FileExists(constants.GetCfgPath()) {
+		config.InitConfig()
+	}
+	adb, err := db.OpenAppDB()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to open the application database: %s\n", err)
+		return 1
+	}
+	defer adb.Close()
+
+	now := time.Now()
+	a, err := adb.GetServerAnalysis(*id, *period, now.Add(-d).Unix(), now.Unix(),
+		loc)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to analyze server %d: %s\n", *id, err)
+		return 1
+	}
+	if a.Samples == 0 {
+		fmt.Fprintf(os.Stderr,
+			"No player history is recorded for server %d in the last %s\n", *id,
+			*period)
+		return 1
+	}
+	var out []byte
+	if *format == "csv" {
+		out, err = a.CSV()
+	} else {
+		out, err = json.MarshalIndent(a, "", "  ")
+		out = append(out, '\n')
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to write the analysis: %s\n", err)
+		return 1
+	}
+	os.Stdout.Write(out)
+	return 0
+}
@@ -0,0 +1,110 @@
+package config
+
+// adminconfig.go - Options for the administrative listener; not user-selectable
+
+const (
+	defaultEnableAdminListener = false
+	defaultAdminListenAddress  = "127.0.0.1:40090"
+	defaultAdminAPIKey         = ""
+	defaultRequireAPIKeys      = false
+	defaultOIDCIssuer          = ""
+	defaultOIDCAudience        = ""
+	defaultOIDCJWKSURL         = ""
+	defaultOIDCJWKSCacheTime   = 3600
+	defaultEncryptAppDB        = false
+	defaultAppDBKeyEnv         = "A2SAPI_APPDB_KEY"
+	defaultServerDBDriver      = "sqlite"
+	defaultServerDBDSN         = ""
+	defaultGeoIPProvider       = "maxmind"
+	defaultGeoIPCityDBFile     = ""
+	defaultGeoIPASNDBFile      = ""
+	// minutes between database backups; 0 disables them
+	defaultDBBackupInterval  = 0
+	defaultDBBackupDirectory = "db/backups"
+	defaultDBBackupRetention = 7
+	defaultDBBackupTarget    = ""
+	// seconds between checks of the configuration file for changes
+	defaultConfigFileReloadInterval = 10
+	// direct query quotas; 0 for no limit
+	defaultKeyHourlyQueryQuota       = 0
+	defaultKeyDailyQueryQuota        = 0
+	defaultAnonymousHourlyQueryQuota = 0
+	defaultAnonymousDailyQueryQuota  = 0
+)
+
+// defaultAnonymousScopes are the scopes granted to requests without an API key
+// when keys are required: reading the server lists, but not direct queries.
+var defaultAnonymousScopes = []string{"read:list"}
+
+// CfgAdmin represents options for the administrative (diagnostics) listener.
+type CfgAdmin struct {
+	// start the separate admin-only HTTP listener
+	EnableAdminListener bool `json:"enableAdminListener"`
+	// host:port the admin listener binds to; should not be publicly reachable
+	AdminListenAddress string `json:"adminListenAddress"`
+	// key that must be sent as a bearer token with every admin request
+	AdminAPIKey string `json:"adminAPIKey"`
+	// require an API key, with the scope needed by the endpoint, for requests to
+	// the API (i.e: read:list, query:direct, write:claims)
+	RequireAPIKeys bool `json:"requireAPIKeys"`
+	// scopes granted to requests without an API key when keys are required
+	AnonymousScopes []string `json:"anonymousScopes"`
+	// issuer of the JWTs that are accepted in place of API keys; JWT validation is
+	// disabled when empty
+	OIDCIssuer string `json:"oidcIssuer"`
+	// audience (aud) that accepted JWTs must be issued for; required with an
+	// issuer
+	OIDCAudience string `json:"oidcAudience"`
+	// URL of the issuer's signing keys; discovered from the issuer when empty
+	OIDCJWKSURL string `json:"oidcJWKSURL"`
+	// time in seconds to cache the issuer's signing keys
+	OIDCJWKSCacheTime int `json:"oidcJWKSCacheSecs"`
+	// encrypt the application database (API keys, audit log, server claims);
+	// requires a build with the sqlcipher tag
+	EncryptAppDB bool `json:"encryptAppDB"`
+	// environment variable that holds the application database key
+	AppDBKeyEnv string `json:"appDBKeyEnv"`
+	// command (and arguments) whose output is the application database key, i.e.
+	// a KMS decrypt call; used instead of appDBKeyEnv if set
+	AppDBKeyCommand []string `json:"appDBKeyCommand"`
+	// maximum direct query requests per hour and per day for each API key, unless
+	// set for the key; 0 for no limit
+	KeyHourlyQueryQuota int `json:"keyHourlyQueryQuota"`
+	KeyDailyQueryQuota  int `json:"keyDailyQueryQuota"`
+	// maximum direct query requests per hour and per day for each IP address
+	// that sends requests without an API key; 0 for no limit
+	AnonymousHourlyQueryQuota int `json:"anonymousHourlyQueryQuota"`
+	AnonymousDailyQueryQuota  int `json:"anonymousDailyQueryQuota"`
+	// IP addresses and CIDR ranges (i.e. load balancer health checks and internal
+	// monitors) whose requests bypass the request limits and quotas
+	TrustedIPs []string `json:"trustedIPs"`
+	// IP addresses and CIDR ranges of the reverse proxies in front of the API,
+	// whose X-Forwarded-For headers identify the clients
+	TrustedProxies []string `json:"trustedProxies"`
+	// storage for the server ID database: "sqlite" (a local file) or "postgres"
+	// (so that multiple instances can share the same server IDs)
+	ServerDBDriver string `json:"serverDBDriver"`
+	// PostgreSQL connection string for the server ID database
+	ServerDBDSN string `json:"serverDBDSN"`
+	// source of the servers' locations: "maxmind" (GeoLite2 .mmdb files) or
+	// "none" (no lookups; every location is unknown)
+	GeoIPProvider string `json:"geoIPProvider"`
+	// MaxMind city database file; db/GeoLite2-City.mmdb when empty
+	GeoIPCityDBFile string `json:"geoIPCityDBFile"`
+	// MaxMind ASN database file; db/GeoLite2-ASN.mmdb (if it exists) when empty
+	GeoIPASNDBFile string `json:"geoIPASNDBFile"`
+	// minutes between backups of the server ID and application databases; 0
+	// disables scheduled backups
+	DBBackupInterval int `json:"dbBackupIntervalMins"`
+	// directory that the backups are made in
+	DBBackupDirectory string `json:"dbBackupDirectory"`
+	// number of backups to keep in the backup directory
+	DBBackupRetention int `json:"dbBackupRetention"`
+	// output sink (a directory, s3://bucket/prefix, or gcs://bucket/prefix) that
+	// each backup is also uploaded to; none if empty
+	DBBackupTarget string `json:"dbBackupTarget"`
+	// seconds between checks of the configuration file for changes, whose
+	// reloadable options are applied without restarting; 0 to only reload the
+	// file on SIGHUP
+	ConfigFileReloadInterval int `json:"configFileReloadSecs"`
+}
@@ -5,12 +5,14 @@ package config
 import (
 	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"runtime"
+	"sync/atomic"
 
 	"github.com/syncore/a2sapi/src/constants"
-	"github.com/syncore/a2sapi/src/steam/filters"
 	"github.com/syncore/a2sapi/src/util"
 
 	"github.com/fatih/color"
@@ -20,15 +22,31 @@ var promptColor = color.New(color.FgHiGreen).SprintfFunc()
 var errorColor = color.New(color.FgHiRed).PrintlnFunc()
 var newline = getNewLineForOS()
 
-// Config represents the application-wide configuration.
-var Config *Cfg
+// current holds the application-wide configuration (a *Cfg); replaced as a
+// whole when the configuration file is reloaded, so readers never see a partial
+// update
+var current atomic.Value
+
+// Get returns the application-wide configuration, or nil if it hasn't been set.
+// It must not be modified; use Set to replace it.
+func Get() *Cfg {
+	cfg, _ := current.Load().(*Cfg)
+	return cfg
+}
+
+// Set replaces the application-wide configuration.
+func Set(cfg *Cfg) {
+	current.Store(cfg)
+}
 
 // Cfg represents logging, steam-related, and API-related options.
 type Cfg struct {
-	LogConfig   CfgLog   `json:"logConfig"`
-	SteamConfig CfgSteam `json:"steamConfig"`
-	WebConfig   CfgWeb   `json:"webConfig"`
-	DebugConfig CfgDebug `json:"debugConfig"`
+	LogConfig    CfgLog    `json:"logConfig"`
+	SteamConfig  CfgSteam  `json:"steamConfig"`
+	WebConfig    CfgWeb    `json:"webConfig"`
+	DebugConfig  CfgDebug  `json:"debugConfig"`
+	AdminConfig  CfgAdmin  `json:"adminConfig"`
+	OutputConfig CfgOutput `json:"outputConfig"`
 }
 
 func getNewLineForOS() string {
@@ -39,29 +57,70 @@ func getNewLineForOS() string {
 }
 
 // InitConfig reads the configuration file from disk and if successful, sets the
-// application wide-configuration. Otherwise, it will panic.
+// application wide-configuration. Options that are missing from the file (i.e.
+// those added after it was created) have their default values, and deprecated
+// or unknown options are warned about. If the file can't be read, it will panic.
 func InitConfig() {
-	if Config != nil {
+	if Get() != nil {
 		return
 	}
 
-	f, err := os.Open(constants.GetCfgPath())
+	data, err := ioutil.ReadFile(constants.GetCfgPath())
 	if err != nil {
 		panic(fmt.Sprintf(`
 "Error reading config file. You might need to recreate it by using
 the --config switch. Error: %s`, err))
 	}
-	defer f.Close()
-	r := bufio.NewReader(f)
-	d := json.NewDecoder(r)
-	cfg := &Cfg{}
-	if err := d.Decode(cfg); err != nil {
+	cfg := defaultConfig()
+	if err := json.Unmarshal(data, cfg); err != nil {
 		panic(fmt.Sprintf(`
 "Error decoding config file. You might need to recreate it by using
 the --config switch. Error: %s`, err))
 	}
+	if err := validateConfig(cfg); err != nil {
+		panic(fmt.Sprintf(`
+"Invalid config file. Error: %s`, err))
+	}
+	Warnings = checkConfigKeys(data)
+	for _, w := range Warnings {
+		warnColor(w)
+	}
 	// Set the configuration which will live throughout the application's lifetime
-	Config = cfg
+	Set(cfg)
+}
+
+// validateConfig returns an error if the configuration has values that can't
+// be used.
+func validateConfig(cfg *Cfg) error {
+	if cfg.AdminConfig.OIDCIssuer != "" && cfg.AdminConfig.OIDCAudience == "" {
+		return errors.New(
+			"oidcAudience is required with oidcIssuer, so that tokens issued for the identity provider's other clients aren't accepted")
+	}
+	for _, o := range []struct {
+		name  string
+		value int
+	}{
+		{"maxRestoredStateAgeSecs", cfg.SteamConfig.MaxRestoredStateAge},
+		{"staleServerAgeSecs", cfg.WebConfig.StaleServerAge},
+		{"oidcJWKSCacheSecs", cfg.AdminConfig.OIDCJWKSCacheTime},
+	} {
+		if o.value <= 0 {
+			return fmt.Errorf("%s must be greater than 0", o.name)
+		}
+	}
+	if cfg.OutputConfig.EnablePerGameFiles &&
+		cfg.OutputConfig.PerGameFileDirectory == "" {
+		return errors.New("perGameFileDirectory is required with enablePerGameFiles")
+	}
+	if cfg.OutputConfig.TimeSeriesExporter != "" &&
+		cfg.OutputConfig.TimeSeriesTable == "" {
+		return errors.New("timeSeriesTable is required with timeSeriesExporter")
+	}
+	if cfg.AdminConfig.EncryptAppDB && len(cfg.AdminConfig.AppDBKeyCommand) == 0 &&
+		cfg.AdminConfig.AppDBKeyEnv == "" {
+		return errors.New("appDBKeyEnv or appDBKeyCommand is required with encryptAppDB")
+	}
+	return nil
 }
 
 func getBoolString(b bool) string {
@@ -76,12 +135,7 @@ func getBoolString(b bool) string {
 // to disk if successful, otherwise panics.
 func CreateConfig() {
 	reader := bufio.NewReader(os.Stdin)
-	cfg := &Cfg{
-		LogConfig:   CfgLog{},
-		SteamConfig: CfgSteam{},
-		WebConfig:   CfgWeb{},
-		DebugConfig: CfgDebug{},
-	}
+	cfg := defaultConfig()
 	color.Set(color.FgHiYellow)
 	fmt.Printf(`
 %s - configuration file creation
@@ -89,6 +143,10 @@ Type a value and press 'ENTER'. Leave a value empty and press 'ENTER' to use the
 default value.
 
 `, constants.AppInfo)
+	if constants.Profile != "" {
+		fmt.Printf("Creating the configuration for the '%s' profile.\n\n",
+			constants.Profile)
+	}
 	color.Unset()
 
 	// Logging configuration
@@ -101,9 +159,6 @@ default value.
 		cfg.LogConfig.EnableWebLogging {
 		cfg.LogConfig.MaximumLogSize = configureMaxLogSize(reader)
 		cfg.LogConfig.MaximumLogCount = configureMaxLogCount(reader)
-	} else {
-		cfg.LogConfig.MaximumLogSize = defaultMaxLogSize
-		cfg.LogConfig.MaximumLogCount = defaultMaxLogCount
 	}
 
 	// Steam configuration
@@ -123,10 +178,6 @@ default value.
 			cfg.SteamConfig.AutoQueryGame)
 		// Maximum # of servers to retrieve from Steam Master server
 		cfg.SteamConfig.MaximumHostsToReceive = configureMaxServersToRetrieve(reader)
-	} else {
-		cfg.SteamConfig.AutoQueryGame = filters.GameQuakeLive.Name
-		cfg.SteamConfig.TimeBetweenMasterQueries = defaultTimeBetweenMasterQueries
-		cfg.SteamConfig.MaximumHostsToReceive = defaultMaxHostsToReceive
 	}
 
 	// Web API configuration
@@ -142,18 +193,11 @@ default value.
 	// Enable or disable gzip compression of responses
 	cfg.WebConfig.CompressResponses = configureResponseCompression(reader)
 
-	// Debug configuration (not user-selectable. for debug/development purposes)
-	// Print a few "debug" messages to stdout
-	cfg.DebugConfig.EnableDebugMessages = defaultEnableDebugMessages
-	// Dump the retrieved server information to a JSON file on disk
-	cfg.DebugConfig.EnableServerDump = defaultEnableServerDump
-	// Use a pre-defined JSON file as disk as the master server list for the API
-	cfg.DebugConfig.ServerDumpFileAsMasterList = defaultServerDumpFileAsMasterList
-	// Name of the pre-defined JSON file to use as the master server list for API
-	cfg.DebugConfig.ServerDumpFilename = defaultServerDumpFile
-
+	// All other options (A2S concurrency, unix domain socket, debug, admin, and
+	// output configuration) are not user-selectable and have their default values;
+	// edit the config file to change them.
 	if err := util.WriteJSONConfig(cfg, constants.ConfigDirectory,
-		constants.ConfigFilePath); err != nil {
+		constants.GetCfgPath()); err != nil {
 		panic(err)
 	}
 }
@@ -161,57 +205,56 @@ default value.
 // CreateDebugConfig creates the configuration file that is used when running the
 // applciation in debug mode.
 func CreateDebugConfig() {
-	cfg := &Cfg{}
+	cfg := defaultConfig()
 	cfg.LogConfig.EnableAppLogging = true
-	cfg.LogConfig.EnableSteamLogging = false // even in debug mode; disable
 	cfg.LogConfig.EnableWebLogging = true
-	cfg.LogConfig.MaximumLogCount = defaultMaxLogCount
-	cfg.LogConfig.MaximumLogSize = defaultMaxLogSize
 	cfg.SteamConfig.AutoQueryMaster = false
 	cfg.SteamConfig.SteamWebAPIKey = "none"
-	cfg.SteamConfig.UseWebServerList = defaultUseWebServerList
-	cfg.SteamConfig.AutoQueryGame = "QuakeLive"
-	cfg.SteamConfig.TimeBetweenMasterQueries = defaultTimeBetweenMasterQueries
-	cfg.SteamConfig.MaximumHostsToReceive = defaultMaxHostsToReceive
+	cfg.SteamConfig.RecordPlayerHistory = true
 	cfg.WebConfig.AllowDirectUserQueries = true
-	cfg.WebConfig.APIWebPort = defaultAPIWebPort
-	cfg.WebConfig.APIWebTimeout = defaultAPIWebTimeout
-	cfg.WebConfig.CompressResponses = defaultCompressResponses
-	cfg.WebConfig.MaximumHostsPerAPIQuery = defaultMaxHostsPerAPIQuery
+	cfg.WebConfig.EnableServerClaims = true
+	cfg.WebConfig.EnableEventFeed = true
+	cfg.WebConfig.EnableMetrics = true
+	cfg.WebConfig.EnableServerRefresh = true
+	cfg.WebConfig.ResponseCacheTTLs = map[string]int{"GetServerIDs": 10}
 	cfg.DebugConfig.EnableDebugMessages = true
 	cfg.DebugConfig.EnableServerDump = true
 	cfg.DebugConfig.ServerDumpFileAsMasterList = true
-	cfg.DebugConfig.ServerDumpFilename = defaultServerDumpFile
+	cfg.AdminConfig.EnableAdminListener = true
+	cfg.AdminConfig.AdminAPIKey = "debug"
+	// the debug configuration isn't read from the configuration file
+	cfg.AdminConfig.ConfigFileReloadInterval = 0
+	cfg.OutputConfig.EnableLatestStateTable = true
 	if err := util.WriteJSONConfig(cfg, constants.ConfigDirectory,
 		constants.DebugConfigFilePath); err != nil {
 		panic(err)
 	}
 	// Set the configuration which will live throughout the application's lifetime
-	Config = cfg
+	Set(cfg)
 }
 
 // CreateTestConfig creates the configuration that is used when running automated
 // testing.
 func CreateTestConfig() {
-	// boolean values intentionally default to false and are omitted unless
-	// otherwise specified, which is different from the normal configuration
-	cfg := &Cfg{}
-	cfg.LogConfig.MaximumLogCount = defaultMaxLogCount
-	cfg.LogConfig.MaximumLogSize = defaultMaxLogSize
-	cfg.SteamConfig.AutoQueryGame = "QuakeLive"
-	cfg.SteamConfig.TimeBetweenMasterQueries = defaultTimeBetweenMasterQueries
-	cfg.SteamConfig.MaximumHostsToReceive = defaultMaxHostsToReceive
+	cfg := defaultConfig()
+	cfg.SteamConfig.AutoQueryMaster = false
+	cfg.SteamConfig.UseWebServerList = false
+	// a fixed limit, so that tests don't depend on the failure rates they cause
+	cfg.SteamConfig.AutoTuneConcurrency = false
 	cfg.WebConfig.AllowDirectUserQueries = true
+	// the tests directly query servers on the loopback address
+	cfg.WebConfig.DirectQueryDeniedNetworks = []string{}
 	cfg.WebConfig.APIWebPort = 40081
-	cfg.WebConfig.APIWebTimeout = defaultAPIWebTimeout
-	cfg.WebConfig.CompressResponses = defaultCompressResponses
-	cfg.WebConfig.MaximumHostsPerAPIQuery = defaultMaxHostsPerAPIQuery
+	cfg.WebConfig.EnableMetrics = true
+	cfg.WebConfig.GameMetadataCacheMins = 0
 	cfg.DebugConfig.ServerDumpFileAsMasterList = true
 	cfg.DebugConfig.ServerDumpFilename = "test-api-servers.json"
+	// the test configuration isn't read from the configuration file
+	cfg.AdminConfig.ConfigFileReloadInterval = 0
 	if err := util.WriteJSONConfig(cfg, constants.TestTempDirectory,
 		constants.TestConfigFilePath); err != nil {
 		panic(err)
 	}
 	// Set the configuration which will live throughout the application's lifetime
-	Config = cfg
+	Set(cfg)
 }
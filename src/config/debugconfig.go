@@ -7,6 +7,7 @@ const (
 	defaultEnableServerDump           = false
 	defaultServerDumpFileAsMasterList = false
 	defaultServerDumpFile             = "serverdump.json"
+	defaultRecordRawCycles            = false
 )
 
 // CfgDebug represents options for debugging and development.
@@ -19,4 +20,6 @@ type CfgDebug struct {
 	ServerDumpFileAsMasterList bool `json:"useServerDumpAsMaster"`
 	// name of the pre-defined server JSON file to use as master list
 	ServerDumpFilename string `json:"serverDumpFilename"`
+	// record raw A2S responses of each timed retrieval to disk (for --replay)
+	RecordRawCycles bool `json:"recordRawCycles"`
 }
@@ -0,0 +1,255 @@
+package config
+
+// defaults.go - Default configuration values, applied to options that are
+// missing from the configuration file, and checking of the file's keys.
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/syncore/a2sapi/src/steam/filters"
+)
+
+// maskedValue replaces secrets in the configuration exposed for debugging.
+const maskedValue = "********"
+
+var warnColor = color.New(color.FgHiYellow).PrintlnFunc()
+
+// Warnings are the problems found with the configuration file when it was read
+// (i.e. deprecated or unknown keys).
+var Warnings []string
+
+// deprecatedKeys are the configuration keys (as section.key) that are still read
+// but will be removed, with what should be used instead.
+var deprecatedKeys = map[string]string{}
+
+// defaultConfig returns the configuration with the default value of every
+// option.
+func defaultConfig() *Cfg {
+	cfg := &Cfg{}
+
+	cfg.LogConfig.EnableAppLogging = defaultEnableAppLogging
+	cfg.LogConfig.EnableSteamLogging = defaultEnableSteamLogging
+	cfg.LogConfig.EnableWebLogging = defaultEnableWebLogging
+	cfg.LogConfig.MaximumLogSize = defaultMaxLogSize
+	cfg.LogConfig.MaximumLogCount = defaultMaxLogCount
+	cfg.LogConfig.HostErrorLogSamples = defaultHostErrorLogSamples
+	cfg.LogConfig.HostErrorLogWindow = defaultHostErrorLogWindow
+
+	cfg.SteamConfig.AutoQueryMaster = defaultAutoQueryMaster
+	cfg.SteamConfig.UseWebServerList = defaultUseWebServerList
+	cfg.SteamConfig.AutoQueryGame = filters.GameQuakeLive.Name
+	cfg.SteamConfig.TimeBetweenMasterQueries = defaultTimeBetweenMasterQueries
+	cfg.SteamConfig.MaximumHostsToReceive = defaultMaxHostsToReceive
+	cfg.SteamConfig.MaxConcurrentQueries = defaultMaxConcurrentQueries
+	cfg.SteamConfig.MinConcurrentQueries = defaultMinConcurrentQueries
+	cfg.SteamConfig.AutoTuneConcurrency = defaultAutoTuneConcurrency
+	cfg.SteamConfig.QueryWorkers = defaultQueryWorkers
+	cfg.SteamConfig.UDPSocketPoolSize = defaultUDPSocketPoolSize
+	cfg.SteamConfig.ExcludeLANServers = defaultExcludeLANServers
+	cfg.SteamConfig.LenientParsing = defaultLenientParsing
+	cfg.SteamConfig.AdditionalAutoQueryGames = []string{}
+	cfg.SteamConfig.TimedQueryGameSettings = map[string]TimedQueryGame{}
+	cfg.SteamConfig.SupplementalHostLists = map[string][]string{}
+	cfg.SteamConfig.SupplementalListCacheTime = defaultSupplementalListCacheTime
+	cfg.SteamConfig.RedactedRules = append([]string{}, defaultRedactedRules...)
+	cfg.SteamConfig.MaxRulesPerServer = defaultMaxRulesPerServer
+	cfg.SteamConfig.MaxRulesBytesPerServer = defaultMaxRulesBytesPerServer
+	cfg.SteamConfig.PersistState = defaultPersistState
+	cfg.SteamConfig.MaxRestoredStateAge = defaultMaxRestoredStateAge
+	cfg.SteamConfig.WarmUpHosts = defaultWarmUpHosts
+	cfg.SteamConfig.AdaptiveQueryInterval = defaultAdaptiveQueryInterval
+	cfg.SteamConfig.MinServerQueryInterval = defaultMinServerQueryInterval
+	cfg.SteamConfig.MaxServerQueryInterval = defaultMaxServerQueryInterval
+	cfg.SteamConfig.DetectMapChanges = defaultDetectMapChanges
+	cfg.SteamConfig.MapChangeConfirmations = defaultMapChangeConfirmations
+	cfg.SteamConfig.HostQueryBudget = defaultHostQueryBudget
+	cfg.SteamConfig.RecordPlayerHistory = defaultRecordPlayerHistory
+	cfg.SteamConfig.PlayerHistoryRetention = defaultPlayerHistoryRetention
+	cfg.SteamConfig.PlayerHistoryHourlyRetention = defaultPlayerHistoryHourlyRetention
+	cfg.SteamConfig.PlayerHistoryDailyRetention = defaultPlayerHistoryDailyRetention
+	cfg.SteamConfig.CompactServerLists = defaultCompactServerLists
+	cfg.SteamConfig.VirtualServers = []VirtualServer{}
+	cfg.SteamConfig.GamesFileReloadInterval = defaultGamesFileReloadInterval
+	cfg.SteamConfig.GeoIPReloadInterval = defaultGeoIPReloadInterval
+	cfg.SteamConfig.IdentifyBySteamID = defaultIdentifyBySteamID
+	cfg.SteamConfig.AdaptiveQueryTimeouts = defaultAdaptiveQueryTimeouts
+	cfg.SteamConfig.MinQueryTimeout = defaultMinQueryTimeout
+	cfg.SteamConfig.MaxQueryTimeout = defaultMaxQueryTimeout
+
+	cfg.WebConfig.AllowDirectUserQueries = defaultAllowDirectUserQueries
+	cfg.WebConfig.MaximumHostsPerAPIQuery = defaultMaxHostsPerAPIQuery
+	cfg.WebConfig.APIWebTimeout = defaultAPIWebTimeout
+	cfg.WebConfig.APIWebPort = defaultAPIWebPort
+	cfg.WebConfig.CompressResponses = defaultCompressResponses
+	cfg.WebConfig.APIWebUnixSocketMode = defaultAPIWebUnixSocketMode
+	cfg.WebConfig.EnableServerClaims = defaultEnableServerClaims
+	cfg.WebConfig.MaxConcurrentRequests = defaultMaxConcurrentRequests
+	cfg.WebConfig.RouteConcurrencyLimits = make(map[string]int,
+		len(defaultRouteConcurrencyLimits))
+	for r, l := range defaultRouteConcurrencyLimits {
+		cfg.WebConfig.RouteConcurrencyLimits[r] = l
+	}
+	cfg.WebConfig.RefreshStaleServers = defaultRefreshStaleServers
+	cfg.WebConfig.StaleServerAge = defaultStaleServerAge
+	cfg.WebConfig.StaleRefreshBudget = defaultStaleRefreshBudget
+	cfg.WebConfig.MaxStaleRefreshServers = defaultMaxStaleRefreshServers
+	cfg.WebConfig.CountryFlagURLTemplate = defaultCountryFlagURLTemplate
+	cfg.WebConfig.DirectQueryCacheTime = defaultDirectQueryCacheTime
+	cfg.WebConfig.EnableEventFeed = defaultEnableEventFeed
+	cfg.WebConfig.EnableMetrics = defaultEnableMetrics
+	cfg.WebConfig.RateLimitPerSecond = defaultRateLimitPerSecond
+	cfg.WebConfig.RateLimitBurst = defaultRateLimitBurst
+	cfg.WebConfig.DirectQueryAllowedNetworks = []string{}
+	cfg.WebConfig.DirectQueryDeniedNetworks = append([]string{},
+		defaultDirectQueryDeniedNetworks...)
+	cfg.WebConfig.EnableServerRefresh = defaultEnableServerRefresh
+	cfg.WebConfig.ServerRefreshInterval = defaultServerRefreshInterval
+	cfg.WebConfig.ResponseCacheTTLs = map[string]int{}
+	cfg.WebConfig.ResponseCacheBackend = defaultResponseCacheBackend
+	cfg.WebConfig.ResponseCacheRedisAddress = defaultResponseCacheRedisAddr
+	cfg.WebConfig.ResponseCacheMaxEntries = defaultResponseCacheEntries
+	cfg.WebConfig.JSONEscapeHTML = defaultJSONEscapeHTML
+	cfg.WebConfig.JSONSortKeys = defaultJSONSortKeys
+	cfg.WebConfig.JSONEmptyLists = defaultJSONEmptyLists
+	cfg.WebConfig.JSONEmptyInfo = defaultJSONEmptyInfo
+	cfg.WebConfig.IngestServers = []IngestServer{}
+	cfg.WebConfig.IngestMinInterval = defaultIngestMinInterval
+	cfg.WebConfig.IngestMaxAge = defaultIngestMaxAge
+	cfg.WebConfig.GameMetadataCacheMins = defaultGameMetadataCacheMins
+	cfg.WebConfig.GRPCPort = defaultGRPCPort
+	cfg.WebConfig.WebSocketAllowedOrigins = []string{}
+
+	cfg.DebugConfig.EnableDebugMessages = defaultEnableDebugMessages
+	cfg.DebugConfig.EnableServerDump = defaultEnableServerDump
+	cfg.DebugConfig.ServerDumpFileAsMasterList = defaultServerDumpFileAsMasterList
+	cfg.DebugConfig.ServerDumpFilename = defaultServerDumpFile
+	cfg.DebugConfig.RecordRawCycles = defaultRecordRawCycles
+
+	cfg.AdminConfig.EnableAdminListener = defaultEnableAdminListener
+	cfg.AdminConfig.AdminListenAddress = defaultAdminListenAddress
+	cfg.AdminConfig.AdminAPIKey = defaultAdminAPIKey
+	cfg.AdminConfig.RequireAPIKeys = defaultRequireAPIKeys
+	cfg.AdminConfig.AnonymousScopes = append([]string{}, defaultAnonymousScopes...)
+	cfg.AdminConfig.OIDCIssuer = defaultOIDCIssuer
+	cfg.AdminConfig.OIDCAudience = defaultOIDCAudience
+	cfg.AdminConfig.OIDCJWKSURL = defaultOIDCJWKSURL
+	cfg.AdminConfig.OIDCJWKSCacheTime = defaultOIDCJWKSCacheTime
+	cfg.AdminConfig.EncryptAppDB = defaultEncryptAppDB
+	cfg.AdminConfig.AppDBKeyEnv = defaultAppDBKeyEnv
+	cfg.AdminConfig.AppDBKeyCommand = []string{}
+	cfg.AdminConfig.KeyHourlyQueryQuota = defaultKeyHourlyQueryQuota
+	cfg.AdminConfig.KeyDailyQueryQuota = defaultKeyDailyQueryQuota
+	cfg.AdminConfig.AnonymousHourlyQueryQuota = defaultAnonymousHourlyQueryQuota
+	cfg.AdminConfig.AnonymousDailyQueryQuota = defaultAnonymousDailyQueryQuota
+	cfg.AdminConfig.TrustedIPs = []string{}
+	cfg.AdminConfig.TrustedProxies = []string{}
+	cfg.AdminConfig.ServerDBDriver = defaultServerDBDriver
+	cfg.AdminConfig.ServerDBDSN = defaultServerDBDSN
+	cfg.AdminConfig.GeoIPProvider = defaultGeoIPProvider
+	cfg.AdminConfig.GeoIPCityDBFile = defaultGeoIPCityDBFile
+	cfg.AdminConfig.GeoIPASNDBFile = defaultGeoIPASNDBFile
+	cfg.AdminConfig.DBBackupInterval = defaultDBBackupInterval
+	cfg.AdminConfig.DBBackupDirectory = defaultDBBackupDirectory
+	cfg.AdminConfig.ConfigFileReloadInterval = defaultConfigFileReloadInterval
+	cfg.AdminConfig.DBBackupRetention = defaultDBBackupRetention
+	cfg.AdminConfig.DBBackupTarget = defaultDBBackupTarget
+
+	cfg.OutputConfig.EnableLatestStateTable = defaultEnableLatestStateTable
+	cfg.OutputConfig.LatestStateDBFile = defaultLatestStateDBFile
+	cfg.OutputConfig.TimeSeriesExporter = defaultTimeSeriesExporter
+	cfg.OutputConfig.TimeSeriesDSN = defaultTimeSeriesDSN
+	cfg.OutputConfig.TimeSeriesTable = defaultTimeSeriesTable
+	cfg.OutputConfig.EnablePerGameFiles = defaultEnablePerGameFiles
+	cfg.OutputConfig.PerGameFileDirectory = defaultPerGameFileDirectory
+	cfg.OutputConfig.PerGameFileFormats = append([]string{},
+		defaultPerGameFileFormats...)
+	cfg.OutputConfig.OutputSinks = []string{}
+	cfg.OutputConfig.Webhooks = []Webhook{}
+	cfg.OutputConfig.WebhookTimeout = defaultWebhookTimeout
+	cfg.OutputConfig.EnableCycleSnapshots = defaultEnableCycleSnapshots
+	cfg.OutputConfig.CycleSnapshotRetention = defaultCycleSnapshotRetention
+	return cfg
+}
+
+// jsonKeys returns the JSON keys of a struct type's fields.
+func jsonKeys(t reflect.Type) map[string]reflect.Type {
+	keys := make(map[string]reflect.Type, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		keys[name] = f.Type
+	}
+	return keys
+}
+
+// checkConfigKeys returns warnings for the deprecated and unknown keys in the
+// configuration file's sections.
+func checkConfigKeys(data []byte) []string {
+	var file map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil
+	}
+	sections := jsonKeys(reflect.TypeOf(Cfg{}))
+	var warnings []string
+	for section, keys := range file {
+		st, ok := sections[section]
+		if !ok {
+			warnings = append(warnings, fmt.Sprintf(
+				"Unknown configuration section: %s", section))
+			continue
+		}
+		known := jsonKeys(st)
+		for key := range keys {
+			name := section + "." + key
+			if msg, ok := deprecatedKeys[name]; ok {
+				warnings = append(warnings, fmt.Sprintf(
+					"Deprecated configuration option %s: %s", name, msg))
+				continue
+			}
+			if _, ok := known[key]; !ok {
+				warnings = append(warnings, fmt.Sprintf(
+					"Unknown configuration option %s (ignored)", name))
+			}
+		}
+	}
+	sort.Strings(warnings)
+	return warnings
+}
+
+func mask(s string) string {
+	if s == "" {
+		return ""
+	}
+	return maskedValue
+}
+
+// Masked returns a copy of the configuration with its secrets (keys and
+// connection strings) masked, for debugging.
+func (c Cfg) Masked() Cfg {
+	c.SteamConfig.SteamWebAPIKey = mask(c.SteamConfig.SteamWebAPIKey)
+	c.AdminConfig.AdminAPIKey = mask(c.AdminConfig.AdminAPIKey)
+	c.AdminConfig.ServerDBDSN = mask(c.AdminConfig.ServerDBDSN)
+	c.OutputConfig.TimeSeriesDSN = mask(c.OutputConfig.TimeSeriesDSN)
+	// webhook URLs (i.e. Discord's) often contain their own token
+	webhooks := make([]Webhook, len(c.OutputConfig.Webhooks))
+	for i, w := range c.OutputConfig.Webhooks {
+		w.URL, w.Secret = mask(w.URL), mask(w.Secret)
+		webhooks[i] = w
+	}
+	c.OutputConfig.Webhooks = webhooks
+	ingest := make([]IngestServer, len(c.WebConfig.IngestServers))
+	for i, s := range c.WebConfig.IngestServers {
+		s.Token = mask(s.Token)
+		ingest[i] = s
+	}
+	c.WebConfig.IngestServers = ingest
+	return c
+}
@@ -11,11 +11,13 @@ import (
 )
 
 const (
-	defaultEnableAppLogging   = false
-	defaultEnableSteamLogging = false
-	defaultEnableWebLogging   = false
-	defaultMaxLogSize         = 5120
-	defaultMaxLogCount        = 5
+	defaultEnableAppLogging    = false
+	defaultEnableSteamLogging  = false
+	defaultEnableWebLogging    = false
+	defaultMaxLogSize          = 5120
+	defaultMaxLogCount         = 5
+	defaultHostErrorLogSamples = 3
+	defaultHostErrorLogWindow  = 60
 )
 
 // CfgLog represents logging-related configuration options.
@@ -25,6 +27,13 @@ type CfgLog struct {
 	EnableWebLogging   bool  `json:"enableWebLogging"`
 	MaximumLogSize     int64 `json:"maxLogFilesize"`
 	MaximumLogCount    int   `json:"maxLogCount"`
+	// errors of querying individual hosts that are logged in full per class of
+	// error (i.e. timeouts) in each window; the rest are summarized at the end of
+	// the window or retrieval; 0 to log every error in full
+	HostErrorLogSamples int `json:"hostErrorLogSamples"`
+	// seconds after which the summaries of host errors are logged, when there is
+	// no retrieval that ends before then
+	HostErrorLogWindow int `json:"hostErrorLogWindowSecs"`
 }
 
 func configureLoggingEnable(reader *bufio.Reader, logt constants.LogType) bool {
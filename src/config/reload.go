@@ -0,0 +1,158 @@
+package config
+
+// reload.go - Reloading of the configuration file without restarting: the
+// options that can be changed at runtime (the timed retrieval's games,
+// intervals, maximum hosts, and timeouts, and the API's limits) are applied,
+// and changes to the other options are reported as requiring a restart.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/syncore/a2sapi/src/constants"
+)
+
+// reloadableOptions are the options (section.key) whose changes are applied
+// when the configuration file is reloaded.
+var reloadableOptions = map[string]bool{
+	"steamConfig.gameForTimedMasterQuery":            true,
+	"steamConfig.additionalGamesForTimedMasterQuery": true,
+	"steamConfig.timeBetweenMasterQueries":           true,
+	"steamConfig.maxHostsToReceive":                  true,
+	"steamConfig.timedQueryGameSettings":             true,
+	"steamConfig.hostQueryBudgetSecs":                true,
+	"steamConfig.adaptiveQueryTimeouts":              true,
+	"steamConfig.minQueryTimeoutMs":                  true,
+	"steamConfig.maxQueryTimeoutMs":                  true,
+	"steamConfig.playerHistoryRetentionDays":         true,
+	"steamConfig.playerHistoryHourlyRetentionDays":   true,
+	"steamConfig.playerHistoryDailyRetentionDays":    true,
+	"webConfig.maxHostsPerAPIQuery":                  true,
+	"webConfig.maxConcurrentRequests":                true,
+	"webConfig.routeConcurrencyLimits":               true,
+	"webConfig.rateLimitPerSecond":                   true,
+	"webConfig.rateLimitBurst":                       true,
+}
+
+var (
+	reloadMu    sync.Mutex
+	reloadHooks []func(old, cfg *Cfg)
+)
+
+// OnReload registers fn to be called, with the previous and the new
+// configuration, after the configuration file is reloaded with changes to any
+// of the reloadable options.
+func OnReload(fn func(old, cfg *Cfg)) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	reloadHooks = append(reloadHooks, fn)
+}
+
+// changedOptions returns the options (section.key) whose values differ between
+// two configurations, sorted.
+func changedOptions(old, cfg *Cfg) []string {
+	var changed []string
+	ov, nv := reflect.ValueOf(old).Elem(), reflect.ValueOf(cfg).Elem()
+	forEachOption(ov.Type(), func(name string, section, field int) {
+		if !reflect.DeepEqual(ov.Field(section).Field(field).Interface(),
+			nv.Field(section).Field(field).Interface()) {
+			changed = append(changed, name)
+		}
+	})
+	sort.Strings(changed)
+	return changed
+}
+
+// forEachOption calls fn with the name (section.key) and field indexes of each
+// option of the configuration type t.
+func forEachOption(t reflect.Type, fn func(name string, section, field int)) {
+	jsonName := func(f reflect.StructField) string {
+		return strings.Split(f.Tag.Get("json"), ",")[0]
+	}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if jsonName(sf) == "" || sf.Type.Kind() != reflect.Struct {
+			continue
+		}
+		for j := 0; j < sf.Type.NumField(); j++ {
+			if name := jsonName(sf.Type.Field(j)); name != "" && name != "-" {
+				fn(jsonName(sf)+"."+name, i, j)
+			}
+		}
+	}
+}
+
+// validateReload returns an error if the reloadable options of a reloaded
+// configuration can't be applied.
+func validateReload(cfg *Cfg) error {
+	if err := validateConfig(cfg); err != nil {
+		return err
+	}
+	if cfg.SteamConfig.TimeBetweenMasterQueries <= 0 {
+		return errors.New("timeBetweenMasterQueries must be greater than 0")
+	}
+	if cfg.SteamConfig.AutoQueryGame == "" {
+		return errors.New("gameForTimedMasterQuery must not be empty")
+	}
+	return nil
+}
+
+// ReloadConfig re-reads the configuration file and applies the changes to its
+// reloadable options. The running values of the other options are kept; their
+// changes take effect on the next restart. It returns the applied options and
+// those that require a restart, or an error (with the running configuration
+// unchanged) if the file can't be read or decoded, or has invalid values.
+func ReloadConfig() (applied []string, restart []string, err error) {
+	data, err := ioutil.ReadFile(constants.GetCfgPath())
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read configuration file: %s", err)
+	}
+	cfg := defaultConfig()
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, nil, fmt.Errorf("unable to decode configuration file: %s", err)
+	}
+	if err := validateReload(cfg); err != nil {
+		return nil, nil, err
+	}
+	return applyReload(cfg)
+}
+
+// applyReload applies the reloadable options of a reloaded configuration.
+func applyReload(cfg *Cfg) (applied []string, restart []string, err error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	old := Get()
+	next := *old
+	nv, rv := reflect.ValueOf(&next).Elem(), reflect.ValueOf(cfg).Elem()
+	changed := make(map[string]bool)
+	for _, name := range changedOptions(old, cfg) {
+		changed[name] = true
+	}
+	forEachOption(nv.Type(), func(name string, section, field int) {
+		if !changed[name] {
+			return
+		}
+		if !reloadableOptions[name] {
+			restart = append(restart, name)
+			return
+		}
+		nv.Field(section).Field(field).Set(rv.Field(section).Field(field))
+		applied = append(applied, name)
+	})
+	sort.Strings(applied)
+	sort.Strings(restart)
+	if len(applied) == 0 {
+		return nil, restart, nil
+	}
+	Set(&next)
+	for _, fn := range reloadHooks {
+		fn(old, &next)
+	}
+	return applied, restart, nil
+}
@@ -18,8 +18,61 @@ const (
 	defaultUseWebServerList         = true
 	// defaultTimeForHighServerCount: not used in JSON, only in the config dialog
 	defaultTimeForHighServerCount = 120
+	// concurrency options are not user-selectable in the config dialog
+	defaultMaxConcurrentQueries = 2000
+	defaultMinConcurrentQueries = 50
+	defaultAutoTuneConcurrency  = true
+	// 0: as many workers as the in-flight limit
+	defaultQueryWorkers = 0
+	// idle UDP sockets kept for reuse by A2S requests
+	defaultUDPSocketPoolSize = 500
+	defaultExcludeLANServers = false
+	// A2S_RULES kept per server; 0 for no limit
+	defaultMaxRulesPerServer      = 1000
+	defaultMaxRulesBytesPerServer = 65536
+	defaultLenientParsing         = false
+	// time to reuse a remote supplemental host list before fetching it again
+	defaultSupplementalListCacheTime = 600
+	defaultPersistState              = true
+	// snapshots older than this (in seconds) are not restored on startup
+	defaultMaxRestoredStateAge = 3600
+	// number of hosts queried by the warm-up retrieval made at startup
+	defaultWarmUpHosts           = 500
+	defaultAdaptiveQueryInterval = false
+	// bounds (in seconds) of the learned per-server query intervals
+	defaultMinServerQueryInterval = 90
+	defaultMaxServerQueryInterval = 1800
+	defaultDetectMapChanges       = false
+	// consecutive retrievals a new map must be seen in before it is a map change
+	defaultMapChangeConfirmations = 2
+	// total seconds of A2S requests (including retries) allowed per host in a
+	// retrieval
+	defaultHostQueryBudget     = 10
+	defaultRecordPlayerHistory = false
+	// days of player count history to keep at full resolution
+	defaultPlayerHistoryRetention = 7
+	// days of hourly averages of the player count history to keep
+	defaultPlayerHistoryHourlyRetention = 30
+	// days of daily averages of the player count history to keep
+	defaultPlayerHistoryDailyRetention = 365
+	defaultCompactServerLists          = false
+	// seconds between checks of the games file for changes
+	defaultGamesFileReloadInterval = 10
+	// seconds between checks of the GeoIP database files for updates
+	defaultGeoIPReloadInterval = 60
+	// follow servers across address changes by their Steam IDs
+	defaultIdentifyBySteamID = true
+	// learn per-host request timeouts from response times
+	defaultAdaptiveQueryTimeouts = false
+	// bounds (in milliseconds) of the learned request timeouts
+	defaultMinQueryTimeout = 300
+	defaultMaxQueryTimeout = 5000
 )
 
+// defaultRedactedRules are the A2S_RULES keys whose values are redacted by
+// default: rcon passwords that misconfigured servers leak as cvars.
+var defaultRedactedRules = []string{"*rcon_password*"}
+
 // CfgSteam represents Steam-related configuration options.
 type CfgSteam struct {
 	AutoQueryMaster          bool   `json:"timedMasterServerQuery"`
@@ -28,6 +81,192 @@ type CfgSteam struct {
 	AutoQueryGame            string `json:"gameForTimedMasterQuery"`
 	TimeBetweenMasterQueries int    `json:"timeBetweenMasterQueries"`
 	MaximumHostsToReceive    int    `json:"maxHostsToReceive"`
+	// maximum number of A2S requests in flight at once; 0 means no limit
+	MaxConcurrentQueries int `json:"maxConcurrentQueries"`
+	// lower bound that auto-tuning will not reduce the in-flight limit below
+	MinConcurrentQueries int `json:"minConcurrentQueries"`
+	// adjust the in-flight limit (AIMD) based on observed failure rates
+	AutoTuneConcurrency bool `json:"autoTuneConcurrency"`
+	// goroutines that each batch of hosts is queried from; 0 uses the
+	// maxConcurrentQueries limit
+	QueryWorkers int `json:"queryWorkers"`
+	// idle UDP sockets kept open for reuse by later A2S requests; 0 opens (and
+	// closes) a socket for every request
+	UDPSocketPoolSize int `json:"udpSocketPoolSize"`
+	// leave servers with private, loopback, or link-local addresses out of lists
+	ExcludeLANServers bool `json:"excludeLANServers"`
+	// salvage what can be parsed from malformed A2S responses instead of
+	// discarding the server; problems are reported in the server's parseWarnings
+	LenientParsing bool `json:"lenientParsing"`
+	// further games to retrieve on the same timer, each with its own list
+	AdditionalAutoQueryGames []string `json:"additionalGamesForTimedMasterQuery"`
+	// game name to settings that override the master server filter, the time
+	// between queries, and the maximum hosts to receive for that game
+	TimedQueryGameSettings map[string]TimedQueryGame `json:"timedQueryGameSettings"`
+	// game name to files or http(s) URLs listing hosts that are not on the master
+	// server; these are merged into the game's master server results each cycle
+	SupplementalHostLists map[string][]string `json:"supplementalHostLists"`
+	// seconds to cache remote supplemental host lists for
+	SupplementalListCacheTime int `json:"supplementalListCacheSecs"`
+	// A2S_RULES keys (exact, or patterns with * and ?) whose values are replaced
+	// with "<redacted>" before servers are stored or returned; case-insensitive
+	RedactedRules []string `json:"redactedRules"`
+	// the most A2S_RULES (and their total bytes, keys and values) kept for each
+	// server; the rest are dropped and the server's rules are marked partial.
+	// Games can set their own limits in the games file; 0 for no limit
+	MaxRulesPerServer      int `json:"maxRulesPerServer"`
+	MaxRulesBytesPerServer int `json:"maxRulesBytesPerServer"`
+	// save the latest server lists and query state on shutdown and restore them
+	// on startup, so that the API has data before the first retrieval completes
+	PersistState bool `json:"persistState"`
+	// seconds after which a saved state is considered too old to be restored
+	MaxRestoredStateAge int `json:"maxRestoredStateAgeSecs"`
+	// number of hosts to query in a quick warm-up retrieval at startup, so that
+	// the API has data before the first full retrieval; 0 disables the warm-up
+	WarmUpHosts int `json:"warmUpHosts"`
+	// query servers that have been empty for a while less often than busy ones,
+	// carrying their last data over in the retrievals in between
+	AdaptiveQueryInterval bool `json:"adaptiveQueryInterval"`
+	// bounds, in seconds, of how often each server is queried when adaptive
+	MinServerQueryInterval int `json:"minServerQueryIntervalSecs"`
+	MaxServerQueryInterval int `json:"maxServerQueryIntervalSecs"`
+	// record map changes in the app DB and notify the owners of claimed servers
+	DetectMapChanges bool `json:"detectMapChanges"`
+	// consecutive retrievals that a server must report a new map in before the
+	// change is accepted, so that servers that flap between maps are ignored
+	MapChangeConfirmations int `json:"mapChangeConfirmations"`
+	// total seconds that the info, players, and rules requests to a host,
+	// including retries, may take in a retrieval; 0 for no limit
+	HostQueryBudget int `json:"hostQueryBudgetSecs"`
+	// record each server's player counts in the app DB after every timed
+	// retrieval, for the server history endpoint
+	RecordPlayerHistory bool `json:"recordPlayerHistory"`
+	// days after which recorded player counts are downsampled to hourly
+	// averages, or deleted if playerHistoryHourlyRetentionDays is 0
+	PlayerHistoryRetention int `json:"playerHistoryRetentionDays"`
+	// days after which the hourly averages are downsampled to daily averages; 0
+	// to delete the player counts instead of downsampling them
+	PlayerHistoryHourlyRetention int `json:"playerHistoryHourlyRetentionDays"`
+	// days after which the daily averages are deleted; 0 to keep them
+	PlayerHistoryDailyRetention int `json:"playerHistoryDailyRetentionDays"`
+	// keep the retrieved server lists packed in a compact binary form, decoding
+	// them for each request, to reduce the memory used by very large lists
+	CompactServerLists bool `json:"compactServerLists"`
+	// logical servers that run on several hosts, whose stats are merged by the
+	// virtual servers endpoint; their hosts are queried along with the game's
+	VirtualServers []VirtualServer `json:"virtualServers"`
+	// seconds between checks of the games file for changes, which are reloaded
+	// without restarting; 0 to disable
+	GamesFileReloadInterval int `json:"gamesFileReloadSecs"`
+	// seconds between checks of the GeoIP database files for updates, after
+	// which the locations of the current servers are looked up again; 0 to
+	// disable
+	GeoIPReloadInterval int `json:"geoIPReloadSecs"`
+	// identify servers by their persistent Steam IDs as well as their addresses,
+	// so that servers keep their IDs when their addresses change
+	IdentifyBySteamID bool `json:"identifyServersBySteamID"`
+	// time requests to each host out based on its response times in previous
+	// requests instead of the fixed request timeout, and retry hosts that keep
+	// failing less
+	AdaptiveQueryTimeouts bool `json:"adaptiveQueryTimeouts"`
+	// bounds, in milliseconds, of the learned request timeouts
+	MinQueryTimeout int `json:"minQueryTimeoutMs"`
+	MaxQueryTimeout int `json:"maxQueryTimeoutMs"`
+}
+
+// TimedQueryGame holds the timed master server query settings of one game; zero
+// values use the global settings.
+type TimedQueryGame struct {
+	// master server filter sent along with the game's appid filter (i.e:
+	// \dedicated\1\secure\1)
+	MasterFilter             string `json:"masterFilter"`
+	TimeBetweenMasterQueries int    `json:"timeBetweenMasterQueries"`
+	MaximumHostsToReceive    int    `json:"maxHostsToReceive"`
+}
+
+// timedQueryGame returns the timed query settings configured for a game.
+func (c CfgSteam) timedQueryGame(game string) TimedQueryGame {
+	for g, s := range c.TimedQueryGameSettings {
+		if strings.EqualFold(g, game) {
+			return s
+		}
+	}
+	return TimedQueryGame{}
+}
+
+// GameMasterFilter returns the master server filter configured for a game, or
+// an empty string if the game has none.
+func (c CfgSteam) GameMasterFilter(game string) string {
+	return c.timedQueryGame(game).MasterFilter
+}
+
+// GameTimeBetweenQueries returns the seconds between a game's timed master
+// server queries.
+func (c CfgSteam) GameTimeBetweenQueries(game string) int {
+	if t := c.timedQueryGame(game).TimeBetweenMasterQueries; t > 0 {
+		return t
+	}
+	return c.TimeBetweenMasterQueries
+}
+
+// GameMaxHostsToReceive returns the maximum number of hosts to receive from
+// the master server for a game.
+func (c CfgSteam) GameMaxHostsToReceive(game string) int {
+	if m := c.timedQueryGame(game).MaximumHostsToReceive; m > 0 {
+		return m
+	}
+	return c.MaximumHostsToReceive
+}
+
+// VirtualServer is one logical server that runs on several hosts (ip:port),
+// i.e. a lobby and its instances.
+type VirtualServer struct {
+	Name  string   `json:"name"`
+	Game  string   `json:"game"`
+	Hosts []string `json:"hosts"`
+}
+
+// VirtualServerHosts returns the hosts of the virtual servers configured for a
+// game.
+func (c CfgSteam) VirtualServerHosts(game string) []string {
+	var hosts []string
+	for _, v := range c.VirtualServers {
+		if strings.EqualFold(v.Game, game) {
+			hosts = append(hosts, v.Hosts...)
+		}
+	}
+	return hosts
+}
+
+// SupplementalHostSources returns the supplemental host list sources (files or
+// URLs) configured for a game.
+func (c CfgSteam) SupplementalHostSources(game string) []string {
+	var sources []string
+	for g, s := range c.SupplementalHostLists {
+		if strings.EqualFold(g, game) {
+			sources = append(sources, s...)
+		}
+	}
+	return sources
+}
+
+// TimedQueryGames returns the names of all of the games that should be retrieved
+// by the timed master server query, without duplicates.
+func (c CfgSteam) TimedQueryGames() []string {
+	games := []string{c.AutoQueryGame}
+	for _, g := range c.AdditionalAutoQueryGames {
+		dupe := false
+		for _, existing := range games {
+			if strings.EqualFold(g, existing) {
+				dupe = true
+				break
+			}
+		}
+		if !dupe {
+			games = append(games, g)
+		}
+	}
+	return games
 }
 
 func configureTimedMasterQuery(reader *bufio.Reader) bool {
@@ -285,3 +524,22 @@ Steam master server queries will need to be at least %d`, game,
 	}
 	return val
 }
+
+// PlayerHistoryDays returns the number of days that the player count history is
+// kept for, at any resolution; 0 if it is kept indefinitely.
+func (c CfgSteam) PlayerHistoryDays() int {
+	days := c.PlayerHistoryRetention
+	if days <= 0 || c.PlayerHistoryHourlyRetention <= 0 {
+		return days
+	}
+	if c.PlayerHistoryDailyRetention <= 0 {
+		return 0
+	}
+	for _, d := range []int{c.PlayerHistoryHourlyRetention,
+		c.PlayerHistoryDailyRetention} {
+		if d > days {
+			days = d
+		}
+	}
+	return days
+}
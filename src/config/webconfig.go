@@ -3,6 +3,7 @@ package config
 import (
 	"bufio"
 	"fmt"
+	"os"
 	"strconv"
 	"strings"
 
@@ -15,15 +16,158 @@ const (
 	defaultAPIWebTimeout          = 7
 	defaultAPIWebPort             = 40080
 	defaultCompressResponses      = true
+	defaultAPIWebUnixSocketMode   = "0660"
+	defaultEnableServerClaims     = false
+	defaultMaxConcurrentRequests  = 500
+	defaultRefreshStaleServers    = false
+	defaultStaleServerAge         = 120
+	defaultStaleRefreshBudget     = 1500
+	defaultMaxStaleRefreshServers = 12
+	defaultCountryFlagURLTemplate = ""
+	defaultDirectQueryCacheTime   = 5
+	defaultEnableEventFeed        = false
+	defaultEnableMetrics          = false
+	defaultRateLimitPerSecond     = 0
+	defaultRateLimitBurst         = 20
+	defaultEnableServerRefresh    = false
+	defaultServerRefreshInterval  = 10
+	defaultResponseCacheBackend   = "memory"
+	defaultResponseCacheRedisAddr = "127.0.0.1:6379"
+	defaultResponseCacheEntries   = 10000
+	defaultJSONEscapeHTML         = true
+	defaultJSONSortKeys           = false
+	defaultJSONEmptyLists         = "array"
+	defaultJSONEmptyInfo          = "object"
+	defaultIngestMinInterval      = 10
+	defaultIngestMaxAge           = 300
+	defaultGameMetadataCacheMins  = 10
+	defaultGRPCPort               = 0
 )
 
+// defaultDirectQueryDeniedNetworks are the private, loopback, and link-local
+// ranges, so that a public API can't be used to query the host's own networks.
+var defaultDirectQueryDeniedNetworks = []string{"10.0.0.0/8", "127.0.0.0/8",
+	"169.254.0.0/16", "172.16.0.0/12", "192.168.0.0/16", "::1/128", "fc00::/7",
+	"fe80::/10"}
+
+// defaultRouteConcurrencyLimits are the default per-route (by route name) limits
+// on concurrent requests; the query routes generate outbound A2S traffic.
+var defaultRouteConcurrencyLimits = map[string]int{
+	"QueryServerAddr": 20,
+	"QueryServerID":   50,
+	"GetServersLive":  1000,
+	"WatchServers":    1000,
+}
+
 // CfgWeb represents web-related API configuration options.
 type CfgWeb struct {
-	AllowDirectUserQueries  bool `json:"allowDirectUserQueries"`
-	APIWebPort              int  `json:"apiWebPort"`
-	APIWebTimeout           int  `json:"apiWebTimeout"`
-	CompressResponses       bool `json:"compressResponses"`
-	MaximumHostsPerAPIQuery int  `json:"maxHostsPerAPIQuery"`
+	AllowDirectUserQueries bool `json:"allowDirectUserQueries"`
+	// address (IP) on which to listen; empty listens on all interfaces
+	APIWebListenAddress     string `json:"apiWebListenAddress"`
+	APIWebPort              int    `json:"apiWebPort"`
+	APIWebTimeout           int    `json:"apiWebTimeout"`
+	CompressResponses       bool   `json:"compressResponses"`
+	MaximumHostsPerAPIQuery int    `json:"maxHostsPerAPIQuery"`
+	// unix domain socket path to listen on instead of TCP; empty uses TCP
+	APIWebUnixSocket string `json:"apiWebUnixSocket"`
+	// octal file permissions applied to the unix domain socket (i.e: "0660")
+	APIWebUnixSocketMode string `json:"apiWebUnixSocketMode"`
+	// allow server owners to claim their server IDs (requires timed retrieval)
+	EnableServerClaims bool `json:"enableServerClaims"`
+	// maximum number of requests handled at once across all routes; 0 means no limit
+	MaxConcurrentRequests int `json:"maxConcurrentRequests"`
+	// maximum number of requests handled at once for specific routes, by name
+	RouteConcurrencyLimits map[string]int `json:"routeConcurrencyLimits"`
+	// re-query servers whose cached data is stale before responding to /servers
+	RefreshStaleServers bool `json:"refreshStaleServers"`
+	// age in seconds after which a server's cached data is considered stale
+	StaleServerAge int `json:"staleServerAgeSecs"`
+	// extra time in milliseconds a response may wait for fresh data
+	StaleRefreshBudget int `json:"staleRefreshBudgetMs"`
+	// only refresh responses that contain at most this many servers
+	MaxStaleRefreshServers int `json:"maxStaleRefreshServers"`
+	// URL of servers' country flag images, where {code} is replaced by the lower
+	// case and {CODE} by the upper case ISO code; empty omits the flag URL
+	CountryFlagURLTemplate string `json:"countryFlagURLTemplate"`
+	// seconds that direct query results are reused for, per server, across all
+	// clients; 0 disables the cache
+	DirectQueryCacheTime int `json:"directQueryCacheSecs"`
+	// record servers going offline and coming back online, and serve them along
+	// with detected map changes as an Atom feed (requires timed retrieval)
+	EnableEventFeed bool `json:"enableEventFeed"`
+	// serve Prometheus metrics at /metrics on the API listener; they are always
+	// available on the admin listener
+	EnableMetrics bool `json:"enableMetrics"`
+	// requests per second that each client IP address is allowed, on average;
+	// 0 disables rate limiting
+	RateLimitPerSecond float64 `json:"rateLimitPerSecond"`
+	// number of requests a client IP address can make at once before being
+	// limited to rateLimitPerSecond
+	RateLimitBurst int `json:"rateLimitBurst"`
+	// IP addresses and CIDR ranges that direct queries (/query?hosts) are
+	// limited to; empty allows any address that isn't denied
+	DirectQueryAllowedNetworks []string `json:"directQueryAllowedNetworks"`
+	// IP addresses and CIDR ranges that can't be directly queried
+	DirectQueryDeniedNetworks []string `json:"directQueryDeniedNetworks"`
+	// allow servers to be re-queried on demand by ID (POST /servers/{id}/refresh)
+	EnableServerRefresh bool `json:"enableServerRefresh"`
+	// minimum seconds between on-demand refreshes of the same server
+	ServerRefreshInterval int `json:"serverRefreshIntervalSecs"`
+	// seconds that responses are cached for, by route name (i.e. GetServerIDs);
+	// routes that aren't listed are not cached
+	ResponseCacheTTLs map[string]int `json:"responseCacheTTLSecs"`
+	// where cached responses are kept: "memory" or "redis" (shared by instances)
+	ResponseCacheBackend string `json:"responseCacheBackend"`
+	// host:port of the Redis server when the response cache backend is redis
+	ResponseCacheRedisAddress string `json:"responseCacheRedisAddress"`
+	// maximum number of responses kept by the memory backend
+	ResponseCacheMaxEntries int `json:"responseCacheMaxEntries"`
+	// escape <, >, and & in JSON responses (for embedding them in HTML)
+	JSONEscapeHTML bool `json:"jsonEscapeHTML"`
+	// order the keys of JSON responses' objects alphabetically (for diffing them)
+	JSONSortKeys bool `json:"jsonSortKeys"`
+	// how empty lists are encoded in JSON: "array" ([]) or "null"
+	JSONEmptyLists string `json:"jsonEmptyLists"`
+	// how the info of servers without A2S_INFO data is encoded in JSON: "object"
+	// ({}) or "omit"
+	JSONEmptyInfo string `json:"jsonEmptyInfo"`
+	// servers that may push their own state to POST /ingest, i.e. because they
+	// are behind NAT and can't be queried
+	IngestServers []IngestServer `json:"ingestServers"`
+	// minimum seconds between pushes from the same server
+	IngestMinInterval int `json:"ingestMinIntervalSecs"`
+	// seconds after which a pushed state is no longer used
+	IngestMaxAge int `json:"ingestMaxAgeSecs"`
+	// minutes that the games' Steam store details and player totals are cached;
+	// 0 disables retrieving them
+	GameMetadataCacheMins int `json:"gameMetadataCacheMins"`
+	// port of the gRPC API (see a2sapi.proto), on the API's listen address; 0
+	// disables it
+	GRPCPort int `json:"grpcPort"`
+	// origins (i.e. https://example.com) of the web pages that may open WebSocket
+	// connections (/servers/live), or "*" for any; the API's own host and clients
+	// that send no Origin (i.e. other than browsers) are always allowed
+	WebSocketAllowedOrigins []string `json:"webSocketAllowedOrigins"`
+}
+
+// IngestServer is a server that is allowed to push its own state.
+type IngestServer struct {
+	Game string `json:"game"`
+	// ip:port that the server is listed under
+	Address string `json:"address"`
+	// bearer token that the server authenticates its pushes with
+	Token string `json:"token"`
+}
+
+// UnixSocketFileMode returns the file permissions that should be applied to the
+// unix domain socket, falling back to the default permissions if the configured
+// value is missing or is not a valid octal value.
+func (cw CfgWeb) UnixSocketFileMode() os.FileMode {
+	mode, err := strconv.ParseUint(cw.APIWebUnixSocketMode, 8, 32)
+	if err != nil {
+		mode, _ = strconv.ParseUint(defaultAPIWebUnixSocketMode, 8, 32)
+	}
+	return os.FileMode(mode).Perm()
 }
 
 func configureDirectQueries(reader *bufio.Reader, timedEnabled bool) bool {
@@ -2,7 +2,11 @@ package constants
 
 // config_constants.go - Configuration-related constants (and a few variables)
 
-import "path"
+import (
+	"fmt"
+	"path"
+	"strings"
+)
 
 const (
 	// ConfigDirectory specifies the directory in which to store the config file.
@@ -12,6 +16,9 @@ const (
 	// DebugConfigFilename specifies the name of the configuration file to use when
 	// debug mode is set
 	DebugConfigFilename = "debug.conf"
+	// ProfileEnvVar specifies the name of the environment variable that can be
+	// used to select a configuration profile when the --profile flag is not set.
+	ProfileEnvVar = "A2SAPI_PROFILE"
 )
 
 var (
@@ -21,8 +28,11 @@ var (
 	// IsTest will determine whether the test configuration is used when running
 	// tests. This variable is only set when running tests.
 	IsTest = false
-	// ConfigFilePath represents the OS-independent full path to the config file.
-	ConfigFilePath = path.Join(ConfigDirectory, ConfigFilename)
+	// Profile is the name of the configuration profile (i.e: "dev") in use, if
+	// any. This is set on application startup and determines which config file,
+	// server database, and log files are used so that multiple instances can run
+	// side by side.
+	Profile = ""
 	// DebugConfigFilePath represents the OS-independent full path to the debug
 	// configuration file.
 	DebugConfigFilePath = path.Join(ConfigDirectory, DebugConfigFilename)
@@ -36,5 +46,32 @@ func GetCfgPath() string {
 	if IsDebug {
 		return path.Join(ConfigDirectory, DebugConfigFilename)
 	}
-	return path.Join(ConfigDirectory, ConfigFilename)
+	return path.Join(ConfigDirectory, ProfileFilename(ConfigFilename))
+}
+
+// ProfileFilename returns the specified filename qualified with the name of the
+// configuration profile in use (i.e: config.conf becomes config.dev.conf). If no
+// profile is in use, then the filename is returned unchanged.
+func ProfileFilename(filename string) string {
+	if Profile == "" {
+		return filename
+	}
+	ext := path.Ext(filename)
+	return fmt.Sprintf("%s.%s%s", strings.TrimSuffix(filename, ext), Profile, ext)
+}
+
+// IsValidProfileName determines whether the specified profile name is safe to
+// use as part of a filename. Profile names may only contain letters, numbers,
+// dashes, and underscores.
+func IsValidProfileName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') &&
+			r != '-' && r != '_' {
+			return false
+		}
+	}
+	return true
 }
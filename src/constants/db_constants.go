@@ -9,13 +9,27 @@ const (
 	DbDirectory = "db"
 	// ServerDbFilename specifies the name of the server database file.
 	ServerDbFilename = "servers.sqlite"
+	// AppDbFilename specifies the name of the application database file, which
+	// holds operational data (i.e: claims, audit log) rather than query results.
+	AppDbFilename = "app.sqlite"
+	// StateDbFilename specifies the name of the database file that holds the
+	// latest per-server state for SQL consumers.
+	StateDbFilename = "state.sqlite"
+	// StateSnapshotFilename specifies the name of the file that the in-memory
+	// state (i.e: the latest server lists) is saved to on shutdown.
+	StateSnapshotFilename = "snapshot.json"
 	// CountryMMDbFilename specifies the name of geolocation database file.
 	CountryMMDbFilename = "GeoLite2-City.mmdb"
+	// ASNMMDbFilename specifies the name of the (optional) autonomous system
+	// database file.
+	ASNMMDbFilename = "GeoLite2-ASN.mmdb"
 )
 
 var (
 	// CountryDbFilePath represents the OS-independent full path to the geolocation DB file.
 	CountryDbFilePath = path.Join(DbDirectory, CountryMMDbFilename)
+	// ASNDbFilePath represents the OS-independent full path to the ASN DB file.
+	ASNDbFilePath = path.Join(DbDirectory, ASNMMDbFilename)
 	// ServerDbFilePath represents the OS-independent full path to the server DB file.
 	ServerDbFilePath = path.Join(DbDirectory, ServerDbFilename)
 )
@@ -28,5 +42,39 @@ func GetServerDBPath() string {
 	if IsDebug {
 		return path.Join(DbDirectory, ServerDbFilename)
 	}
-	return path.Join(DbDirectory, ServerDbFilename)
+	return path.Join(DbDirectory, ProfileFilename(ServerDbFilename))
+}
+
+// GetAppDBPath returns the full OS-independent path to the application DB file.
+func GetAppDBPath() string {
+	if IsTest {
+		return path.Join(TestTempDirectory, TestAppDbFilename)
+	}
+	if IsDebug {
+		return path.Join(DbDirectory, AppDbFilename)
+	}
+	return path.Join(DbDirectory, ProfileFilename(AppDbFilename))
+}
+
+// GetStateDBPath returns the full OS-independent path to the latest state DB file.
+func GetStateDBPath() string {
+	if IsTest {
+		return path.Join(TestTempDirectory, TestStateDbFilename)
+	}
+	if IsDebug {
+		return path.Join(DbDirectory, StateDbFilename)
+	}
+	return path.Join(DbDirectory, ProfileFilename(StateDbFilename))
+}
+
+// GetStateSnapshotPath returns the full OS-independent path to the state snapshot
+// file.
+func GetStateSnapshotPath() string {
+	if IsTest {
+		return path.Join(TestTempDirectory, TestStateSnapshotFilename)
+	}
+	if IsDebug {
+		return path.Join(DbDirectory, StateSnapshotFilename)
+	}
+	return path.Join(DbDirectory, ProfileFilename(StateSnapshotFilename))
 }
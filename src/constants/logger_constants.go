@@ -2,8 +2,6 @@ package constants
 
 // logger_constants.go - Logger-related constants (and a few variables)
 
-import "path"
-
 const (
 	// LogDirectory specifies the directory in which to store the log files.
 	LogDirectory = "logs"
@@ -28,12 +26,3 @@ const (
 	// LTypeWeb represents the Web-related log type.
 	LTypeWeb
 )
-
-var (
-	// AppLogFilePath represents the OS-independent full path to app log file.
-	AppLogFilePath = path.Join(LogDirectory, AppLogFilename)
-	// SteamLogFilePath represents the OS-independent full path to Steam log file.
-	SteamLogFilePath = path.Join(LogDirectory, SteamLogFilename)
-	// WebLogFilePath represents the OS-independent full path to web log file.
-	WebLogFilePath = path.Join(LogDirectory, WebLogFilename)
-)
@@ -12,6 +12,15 @@ const (
 	// TestServerDbFilename specifies the name of the server database file used in
 	// tests.
 	TestServerDbFilename = "servers_test.sqlite"
+	// TestAppDbFilename specifies the name of the application database file used
+	// in tests.
+	TestAppDbFilename = "app_test.sqlite"
+	// TestStateDbFilename specifies the name of the latest state database file
+	// used in tests.
+	TestStateDbFilename = "state_test.sqlite"
+	// TestStateSnapshotFilename specifies the name of the state snapshot file used
+	// in tests.
+	TestStateSnapshotFilename = "snapshot_test.json"
 )
 
 var (
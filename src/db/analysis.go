@@ -0,0 +1,150 @@
+package db
+
+// analysis.go - Analysis of a server's player count and map history, for the
+// analyze command.
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/syncore/a2sapi/src/models"
+)
+
+// GetServerAnalysis analyzes the player counts and map changes recorded for a
+// server between the given times, with the busiest hours of the day in the
+// given location. The analysis has no samples if the server has no recorded
+// history in that time.
+func (adb *ADB) GetServerAnalysis(id int64, period string, from, to int64,
+	loc *time.Location) (*models.ServerAnalysis, error) {
+	stats, err := adb.GetServerStats(id, from)
+	if err != nil {
+		return nil, err
+	}
+	var retrievals []int64
+	if len(stats) > 0 {
+		retrievals, err = adb.GetRetrievalTimes(stats[0].Game, from)
+		if err != nil {
+			return nil, err
+		}
+	}
+	changes, err := adb.GetMapChanges(id, from)
+	if err != nil {
+		return nil, err
+	}
+	return analyzeServer(id, period, from, to, stats, retrievals, changes, loc),
+		nil
+}
+
+func analyzeServer(id int64, period string, from, to int64,
+	stats []models.DbServerStat, retrievals []int64, changes []models.DbMapChange,
+	loc *time.Location) *models.ServerAnalysis {
+	a := &models.ServerAnalysis{ServerID: id, Period: period, From: from, To: to,
+		BusiestHours: []models.HourActivity{}, Maps: []models.MapShare{}}
+	for _, t := range retrievals {
+		if t >= from && t <= to {
+			a.Retrievals++
+		}
+	}
+	var total int
+	var hourTotals, hourSamples [24]int
+	first := int64(0)
+	for _, s := range stats {
+		if s.Timestamp < from || s.Timestamp > to {
+			continue
+		}
+		if a.Samples == 0 {
+			a.Game = s.Game
+			first = s.Timestamp
+		}
+		a.Samples++
+		humans := s.Players - s.Bots
+		if humans < 0 {
+			humans = 0
+		}
+		total += humans
+		if a.Samples == 1 || humans > a.PeakPlayers {
+			a.PeakPlayers = humans
+			a.PeakPlayersAt = s.Timestamp
+		}
+		h := time.Unix(s.Timestamp, 0).In(loc).Hour()
+		hourTotals[h] += humans
+		hourSamples[h]++
+	}
+	if a.Samples == 0 {
+		return a
+	}
+	a.AveragePlayers = round2(float64(total) / float64(a.Samples))
+	if a.Retrievals > 0 {
+		a.Uptime = round2(math.Min(100,
+			float64(a.Samples)*100/float64(a.Retrievals)))
+	}
+	for h := range hourSamples {
+		if hourSamples[h] == 0 {
+			continue
+		}
+		a.BusiestHours = append(a.BusiestHours, models.HourActivity{Hour: h,
+			AveragePlayers: round2(float64(hourTotals[h]) / float64(hourSamples[h])),
+			Samples:        hourSamples[h]})
+	}
+	sort.SliceStable(a.BusiestHours, func(i, j int) bool {
+		return a.BusiestHours[i].AveragePlayers > a.BusiestHours[j].AveragePlayers
+	})
+	a.Maps = mapShares(changes, first, to)
+	return a
+}
+
+// mapShares returns the time spent on each map between the given times (the
+// first sample and the end of the period), most played first, from the map
+// changes. The map before the first change is its from map, or the to map of
+// a change before the start.
+func mapShares(changes []models.DbMapChange, start, end int64) []models.MapShare {
+	seconds := make(map[string]int64)
+	current := ""
+	t := start
+	for _, c := range changes {
+		if c.Timestamp <= start {
+			current = c.ToMap
+			continue
+		}
+		if c.Timestamp > end {
+			break
+		}
+		if current == "" {
+			current = c.FromMap
+		}
+		seconds[current] += c.Timestamp - t
+		t = c.Timestamp
+		current = c.ToMap
+	}
+	if current != "" {
+		seconds[current] += end - t
+	}
+	var total int64
+	for _, s := range seconds {
+		total += s
+	}
+	shares := []models.MapShare{}
+	if total == 0 {
+		return shares
+	}
+	for m, s := range seconds {
+		if s == 0 {
+			continue
+		}
+		shares = append(shares, models.MapShare{Map: m, Seconds: s,
+			Share: round2(float64(s) * 100 / float64(total))})
+	}
+	sort.Slice(shares, func(i, j int) bool {
+		if shares[i].Seconds != shares[j].Seconds {
+			return shares[i].Seconds > shares[j].Seconds
+		}
+		return shares[i].Map < shares[j].Map
+	})
+	return shares
+}
+
+// round2 rounds f to two decimal places.
+func round2(f float64) float64 {
+	return math.Round(f*100) / 100
+}
@@ -0,0 +1,79 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/syncore/a2sapi/src/models"
+)
+
+func TestAnalyzeServer(t *testing.T) {
+	base := time.Date(2016, 1, 2, 0, 0, 0, 0, time.UTC).Unix()
+	hour := int64(3600)
+	stats := []models.DbServerStat{
+		{ID: 42, Game: "QuakeLive", Timestamp: base, Players: 2, MaxPlayers: 16},
+		{ID: 42, Game: "QuakeLive", Timestamp: base + hour, Players: 10, Bots: 2,
+			MaxPlayers: 16},
+		{ID: 42, Game: "QuakeLive", Timestamp: base + 25*hour, Players: 4,
+			MaxPlayers: 16},
+	}
+	retrievals := []int64{base, base + hour, base + 2*hour, base + 25*hour}
+	changes := []models.DbMapChange{
+		{ID: 42, FromMap: "campgrounds", ToMap: "bloodrun", Timestamp: base - hour},
+		{ID: 42, FromMap: "bloodrun", ToMap: "toxicity", Timestamp: base + 6*hour},
+	}
+	a := analyzeServer(42, "30d", base-hour, base+30*hour, stats, retrievals,
+		changes, time.UTC)
+
+	if a.Game != "QuakeLive" || a.Samples != 3 || a.Retrievals != 4 ||
+		a.Uptime != 75 {
+		t.Fatalf("Expected 3 of 4 QuakeLive samples (75%% uptime), got: %+v", a)
+	}
+	if a.PeakPlayers != 8 || a.PeakPlayersAt != base+hour {
+		t.Fatalf("Expected a peak of 8 human players at %d, got: %d at %d",
+			base+hour, a.PeakPlayers, a.PeakPlayersAt)
+	}
+	if a.AveragePlayers != 4.67 {
+		t.Fatalf("Expected an average of 4.67 players, got: %v", a.AveragePlayers)
+	}
+	expectedHours := []models.HourActivity{
+		{Hour: 1, AveragePlayers: 6, Samples: 2},
+		{Hour: 0, AveragePlayers: 2, Samples: 1},
+	}
+	if !reflect.DeepEqual(a.BusiestHours, expectedHours) {
+		t.Fatalf("Expected busiest hours %+v, got: %+v", expectedHours,
+			a.BusiestHours)
+	}
+	// bloodrun from the first sample until the change 6 hours later, then
+	// toxicity for the remaining 24 hours of the period
+	expectedMaps := []models.MapShare{
+		{Map: "toxicity", Seconds: 24 * hour, Share: 80},
+		{Map: "bloodrun", Seconds: 6 * hour, Share: 20},
+	}
+	if !reflect.DeepEqual(a.Maps, expectedMaps) {
+		t.Fatalf("Expected map shares %+v, got: %+v", expectedMaps, a.Maps)
+	}
+}
+
+func TestAnalyzeServerNoHistory(t *testing.T) {
+	a := analyzeServer(42, "7d", 0, 100, nil, nil, nil, time.UTC)
+	if a.Samples != 0 || a.Uptime != 0 || len(a.BusiestHours) != 0 ||
+		len(a.Maps) != 0 {
+		t.Fatalf("Expected an empty analysis, got: %+v", a)
+	}
+}
+
+func TestMapSharesWithoutEarlierChange(t *testing.T) {
+	changes := []models.DbMapChange{
+		{FromMap: "cp_badlands", ToMap: "cp_granary", Timestamp: 300},
+	}
+	expected := []models.MapShare{
+		{Map: "cp_badlands", Seconds: 200, Share: 66.67},
+		{Map: "cp_granary", Seconds: 100, Share: 33.33},
+	}
+	if shares := mapShares(changes, 100, 400); !reflect.DeepEqual(shares,
+		expected) {
+		t.Fatalf("Expected map shares %+v, got: %+v", expected, shares)
+	}
+}
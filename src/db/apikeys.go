@@ -0,0 +1,116 @@
+package db
+
+// apikeys.go - API keys and their scopes, stored in the application database
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/models"
+)
+
+const apiKeyColumns = `key_id, name, key_hash, scopes, created_at, rotated_at,
+	revoked_at, hourly_quota, daily_quota`
+
+func scanAPIKeys(rows *sql.Rows) ([]models.DbAPIKey, error) {
+	var keys []models.DbAPIKey
+	for rows.Next() {
+		k := models.DbAPIKey{}
+		var scopes string
+		if err := rows.Scan(&k.ID, &k.Name, &k.KeyHash, &scopes, &k.CreatedAt,
+			&k.RotatedAt, &k.RevokedAt, &k.HourlyQuota, &k.DailyQuota); err != nil {
+			return nil, err
+		}
+		k.Scopes = make([]string, 0)
+		if scopes != "" {
+			k.Scopes = strings.Split(scopes, ",")
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// CreateAPIKey stores a new API key, by the hash of the key, with the given
+// scopes and direct query quotas.
+func (adb *ADB) CreateAPIKey(id, name, keyHash string, scopes []string,
+	hourlyQuota, dailyQuota int) error {
+	_, err := adb.db.Exec(`INSERT INTO api_keys (key_id, name, key_hash, scopes,
+	created_at, hourly_quota, daily_quota) VALUES (?, ?, ?, ?, ?, ?, ?)`, id, name,
+		keyHash, strings.Join(scopes, ","), time.Now().Unix(), hourlyQuota,
+		dailyQuota)
+	if err != nil {
+		return logger.LogAppErrorf("CreateAPIKey: Error creating key %s: %s", id, err)
+	}
+	return nil
+}
+
+// GetAPIKey retrieves the API key with the given ID. The returned key is nil if
+// no such key exists.
+func (adb *ADB) GetAPIKey(id string) (*models.DbAPIKey, error) {
+	defer observeDBQuery("app", "GetAPIKey", time.Now())
+	rows, err := adb.db.Query("SELECT "+apiKeyColumns+
+		" FROM api_keys WHERE key_id =? LIMIT 1", id)
+	if err != nil {
+		return nil, logger.LogAppErrorf("GetAPIKey: Error querying key %s: %s", id, err)
+	}
+	defer rows.Close()
+	keys, err := scanAPIKeys(rows)
+	if err != nil {
+		return nil, logger.LogAppErrorf("GetAPIKey: Error querying key %s: %s", id, err)
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	return &keys[0], nil
+}
+
+// GetAPIKeys retrieves all of the API keys, including revoked ones.
+func (adb *ADB) GetAPIKeys() ([]models.DbAPIKey, error) {
+	rows, err := adb.db.Query("SELECT " + apiKeyColumns +
+		" FROM api_keys ORDER BY created_at")
+	if err != nil {
+		return nil, logger.LogAppErrorf("GetAPIKeys: Error querying keys: %s", err)
+	}
+	defer rows.Close()
+	keys, err := scanAPIKeys(rows)
+	if err != nil {
+		return nil, logger.LogAppErrorf("GetAPIKeys: Error querying keys: %s", err)
+	}
+	return keys, nil
+}
+
+// RotateAPIKey replaces the hash of an API key that has not been revoked,
+// returning false if there is no such key.
+func (adb *ADB) RotateAPIKey(id, keyHash string) (bool, error) {
+	res, err := adb.db.Exec(`UPDATE api_keys SET key_hash =?, rotated_at =?
+	WHERE key_id =? AND revoked_at = 0`, keyHash, time.Now().Unix(), id)
+	if err != nil {
+		return false, logger.LogAppErrorf("RotateAPIKey: Error rotating key %s: %s",
+			id, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, logger.LogAppErrorf("RotateAPIKey: Error rotating key %s: %s",
+			id, err)
+	}
+	return n > 0, nil
+}
+
+// RevokeAPIKey revokes an API key, returning false if there is no such key or it
+// was already revoked.
+func (adb *ADB) RevokeAPIKey(id string) (bool, error) {
+	res, err := adb.db.Exec(`UPDATE api_keys SET revoked_at =? WHERE key_id =?
+	AND revoked_at = 0`, time.Now().Unix(), id)
+	if err != nil {
+		return false, logger.LogAppErrorf("RevokeAPIKey: Error revoking key %s: %s",
+			id, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, logger.LogAppErrorf("RevokeAPIKey: Error revoking key %s: %s",
+			id, err)
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,313 @@
+package db
+
+// appdb.go - Application database, which holds operational data (server claims,
+// audit log, server health, API keys, etc.) separately from the query-derived server ID database, and
+// manages its schema with numbered migrations.
+
+import (
+	"database/sql"
+	"path"
+	"time"
+
+	"github.com/syncore/a2sapi/src/constants"
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/util"
+)
+
+// ADB represents the application database.
+type ADB struct {
+	db *sql.DB
+}
+
+// migration represents a single, numbered change to the application database's
+// schema. Migrations are applied in order and must never be edited once they
+// have been released; add a new migration instead.
+type migration struct {
+	version     int
+	description string
+	statements  []string
+}
+
+var appMigrations = []migration{
+	migration{
+		version:     1,
+		description: "server ownership claims",
+		statements: []string{
+			`CREATE TABLE claims (
+			server_id INTEGER NOT NULL,
+			token TEXT NOT NULL,
+			owner_key_hash TEXT NOT NULL,
+			verified INTEGER NOT NULL DEFAULT 0,
+			verified_at INTEGER NOT NULL DEFAULT 0,
+			alias TEXT NOT NULL DEFAULT '',
+			description TEXT NOT NULL DEFAULT '',
+			notify_url TEXT NOT NULL DEFAULT '',
+			created_at INTEGER NOT NULL,
+			PRIMARY KEY(server_id)
+			)`,
+		},
+	},
+	migration{
+		version:     2,
+		description: "audit log",
+		statements: []string{
+			`CREATE TABLE audit_log (
+			entry_id INTEGER NOT NULL,
+			created_at INTEGER NOT NULL,
+			actor TEXT NOT NULL,
+			action TEXT NOT NULL,
+			target TEXT NOT NULL DEFAULT '',
+			detail TEXT NOT NULL DEFAULT '',
+			PRIMARY KEY(entry_id)
+			)`,
+			"CREATE INDEX audit_log_created_at ON audit_log (created_at)",
+		},
+	},
+	migration{
+		version:     3,
+		description: "server health",
+		statements: []string{
+			`CREATE TABLE server_health (
+			host TEXT NOT NULL,
+			game TEXT NOT NULL,
+			consecutive_failures INTEGER NOT NULL DEFAULT 0,
+			last_seen_online INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY(host, game)
+			)`,
+		},
+	},
+	migration{
+		version:     4,
+		description: "api keys",
+		statements: []string{
+			`CREATE TABLE api_keys (
+			key_id TEXT NOT NULL,
+			name TEXT NOT NULL DEFAULT '',
+			key_hash TEXT NOT NULL,
+			scopes TEXT NOT NULL DEFAULT '',
+			created_at INTEGER NOT NULL,
+			rotated_at INTEGER NOT NULL DEFAULT 0,
+			revoked_at INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY(key_id)
+			)`,
+		},
+	},
+	migration{
+		version:     5,
+		description: "server query cadence",
+		statements: []string{
+			`CREATE TABLE server_cadence (
+			host TEXT NOT NULL,
+			game TEXT NOT NULL,
+			interval_secs INTEGER NOT NULL DEFAULT 0,
+			last_queried INTEGER NOT NULL DEFAULT 0,
+			last_active INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY(host, game)
+			)`,
+		},
+	},
+	migration{
+		version:     6,
+		description: "map changes",
+		statements: []string{
+			`CREATE TABLE map_changes (
+			change_id INTEGER NOT NULL,
+			server_id INTEGER NOT NULL DEFAULT 0,
+			host TEXT NOT NULL,
+			game TEXT NOT NULL,
+			from_map TEXT NOT NULL,
+			to_map TEXT NOT NULL,
+			changed_at INTEGER NOT NULL,
+			PRIMARY KEY(change_id)
+			)`,
+			"CREATE INDEX map_changes_host ON map_changes (host, game, changed_at)",
+		},
+	},
+	migration{
+		version:     7,
+		description: "direct query quotas",
+		statements: []string{
+			"ALTER TABLE api_keys ADD COLUMN hourly_quota INTEGER NOT NULL DEFAULT 0",
+			"ALTER TABLE api_keys ADD COLUMN daily_quota INTEGER NOT NULL DEFAULT 0",
+			`CREATE TABLE api_usage (
+			identity TEXT NOT NULL,
+			period TEXT NOT NULL,
+			period_start INTEGER NOT NULL,
+			requests INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY(identity, period, period_start)
+			)`,
+		},
+	},
+	migration{
+		version:     8,
+		description: "server events",
+		statements: []string{
+			`CREATE TABLE server_events (
+			event_id INTEGER NOT NULL,
+			server_id INTEGER NOT NULL DEFAULT 0,
+			host TEXT NOT NULL,
+			game TEXT NOT NULL,
+			event TEXT NOT NULL,
+			detail TEXT NOT NULL DEFAULT '',
+			created_at INTEGER NOT NULL,
+			PRIMARY KEY(event_id)
+			)`,
+			"CREATE INDEX server_events_created ON server_events (created_at)",
+			"CREATE INDEX map_changes_created ON map_changes (changed_at)",
+		},
+	},
+	migration{
+		version:     9,
+		description: "player count history",
+		statements: []string{
+			`CREATE TABLE server_stats (
+			server_id INTEGER NOT NULL,
+			game TEXT NOT NULL,
+			players INTEGER NOT NULL,
+			bots INTEGER NOT NULL,
+			max_players INTEGER NOT NULL,
+			recorded_at INTEGER NOT NULL
+			)`,
+			"CREATE INDEX server_stats_server ON server_stats (server_id, recorded_at)",
+			"CREATE INDEX server_stats_recorded ON server_stats (recorded_at)",
+		},
+	},
+	migration{
+		version:     10,
+		description: "retrieval cycle snapshots",
+		statements: []string{
+			`CREATE TABLE cycle_snapshots (
+			snapshot_id INTEGER NOT NULL,
+			game TEXT NOT NULL,
+			cycle_id TEXT NOT NULL DEFAULT '',
+			server_count INTEGER NOT NULL,
+			data BLOB NOT NULL,
+			created_at INTEGER NOT NULL,
+			PRIMARY KEY(snapshot_id)
+			)`,
+			"CREATE INDEX cycle_snapshots_game ON cycle_snapshots (game, created_at)",
+			"CREATE INDEX cycle_snapshots_created ON cycle_snapshots (created_at)",
+		},
+	},
+	migration{
+		version:     11,
+		description: "downsampled player count history",
+		statements: []string{
+			`CREATE TABLE server_stat_rollups (
+			server_id INTEGER NOT NULL,
+			game TEXT NOT NULL,
+			resolution INTEGER NOT NULL,
+			period_start INTEGER NOT NULL,
+			samples INTEGER NOT NULL,
+			players_avg REAL NOT NULL,
+			players_min INTEGER NOT NULL,
+			players_max INTEGER NOT NULL,
+			bots_avg REAL NOT NULL,
+			max_players INTEGER NOT NULL,
+			PRIMARY KEY(server_id, resolution, period_start)
+			)`,
+			"CREATE INDEX server_stat_rollups_period ON server_stat_rollups (resolution, period_start)",
+		},
+	},
+}
+
+// OpenAppDB opens a database connection to the application database file,
+// creating it if necessary, and brings its schema up to date.
+func OpenAppDB() (*ADB, error) {
+	if err := util.CreateDirectory(path.Dir(constants.GetAppDBPath())); err != nil {
+		return nil, logger.LogAppErrorf("Unable to create app DB directory: %s", err)
+	}
+	dsn, err := appDBDataSource(constants.GetAppDBPath())
+	if err != nil {
+		return nil, logger.LogAppErrorf("Unable to open app DB: %s", err)
+	}
+	conn, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, logger.LogAppError(err)
+	}
+	adb := &ADB{db: conn}
+	if err := adb.migrate(appMigrations); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return adb, nil
+}
+
+// Close closes the application database's underlying connection.
+func (adb *ADB) Close() {
+	err := adb.db.Close()
+	if err != nil {
+		logger.LogAppErrorf("Error closing application DB: %s", err)
+	}
+}
+
+func (adb *ADB) schemaVersion() (int, error) {
+	if _, err := adb.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INTEGER NOT NULL,
+	description TEXT NOT NULL,
+	applied_at INTEGER NOT NULL,
+	PRIMARY KEY(version)
+	)`); err != nil {
+		return 0, logger.LogAppErrorf("Unable to create schema migrations table: %s",
+			err)
+	}
+	var version sql.NullInt64
+	if err := adb.db.QueryRow(
+		"SELECT MAX(version) FROM schema_migrations").Scan(&version); err != nil &&
+		err != sql.ErrNoRows {
+		return 0, logger.LogAppErrorf("Unable to determine app DB schema version: %s",
+			err)
+	}
+	return int(version.Int64), nil
+}
+
+// migrate applies, each in its own transaction, any migrations that are newer
+// than the database's current schema version.
+func (adb *ADB) migrate(migrations []migration) error {
+	current, err := adb.schemaVersion()
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		tx, err := adb.db.Begin()
+		if err != nil {
+			return logger.LogAppErrorf("App DB migration %d error creating tx: %s",
+				m.version, err)
+		}
+		for _, stmt := range m.statements {
+			if _, err := tx.Exec(stmt); err != nil {
+				tx.Rollback()
+				return logger.LogAppErrorf("App DB migration %d (%s) failed: %s",
+					m.version, m.description, err)
+			}
+		}
+		if _, err := tx.Exec(
+			"INSERT INTO schema_migrations (version, description, applied_at) VALUES (?, ?, ?)",
+			m.version, m.description, time.Now().Unix()); err != nil {
+			tx.Rollback()
+			return logger.LogAppErrorf("App DB migration %d error recording version: %s",
+				m.version, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return logger.LogAppErrorf("App DB migration %d error committing tx: %s",
+				m.version, err)
+		}
+		logger.LogAppInfo("Applied app DB migration %d: %s", m.version, m.description)
+	}
+	return nil
+}
+
+// AddAuditEntry records an action taken by actor (i.e: a remote address) on
+// target in the audit log.
+func (adb *ADB) AddAuditEntry(actor, action, target, detail string) {
+	_, err := adb.db.Exec(
+		"INSERT INTO audit_log (created_at, actor, action, target, detail) VALUES (?, ?, ?, ?, ?)",
+		time.Now().Unix(), actor, action, target, detail)
+	if err != nil {
+		logger.LogAppErrorf("AddAuditEntry: Error recording %s on %s: %s", action,
+			target, err)
+	}
+}
@@ -0,0 +1,27 @@
+package db
+
+import "testing"
+
+func TestOpenAppDB(t *testing.T) {
+	adb, err := OpenAppDB()
+	if err != nil {
+		t.Fatalf("Error opening application database: %s", err)
+	}
+	defer adb.Close()
+	// already up to date; should be a no-op
+	if err := adb.migrate(appMigrations); err != nil {
+		t.Fatalf("Error re-applying application database migrations: %s", err)
+	}
+}
+
+func TestAppMigrationsOrdered(t *testing.T) {
+	for i, m := range appMigrations {
+		if m.version != i+1 {
+			t.Fatalf("Expected migration %d to have version %d, got: %d", i,
+				i+1, m.version)
+		}
+		if len(m.statements) == 0 {
+			t.Fatalf("Migration %d has no statements", m.version)
+		}
+	}
+}
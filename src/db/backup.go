@@ -0,0 +1,184 @@
+package db
+
+// backup.go - Backups of the SQLite server ID and application databases, which
+// hold data that can't be retrieved again (server IDs, claims, API keys, and
+// history), and restoring them.
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/syncore/a2sapi/src/constants"
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/util"
+)
+
+const (
+	backupPrefix     = "backup-"
+	backupTimeFormat = "20060102-150405"
+)
+
+// backupName returns the name of the directory of a backup made at t. It is
+// qualified with the configuration profile, so that the profiles' backups can
+// share a directory.
+func backupName(t time.Time) string {
+	return constants.ProfileFilename(backupPrefix + t.UTC().Format(backupTimeFormat))
+}
+
+// isBackupName determines whether name is the name of a backup directory of the
+// configuration profile in use.
+func isBackupName(name string) bool {
+	if !strings.HasPrefix(name, backupPrefix) {
+		return false
+	}
+	stamp := strings.TrimPrefix(name, backupPrefix)
+	if constants.Profile != "" {
+		if !strings.HasSuffix(stamp, "."+constants.Profile) {
+			return false
+		}
+		stamp = strings.TrimSuffix(stamp, "."+constants.Profile)
+	}
+	_, err := time.Parse(backupTimeFormat, stamp)
+	return err == nil
+}
+
+// BackupDBs copies the server ID and application databases, while in use, to a
+// new backup directory in dir, and returns its path. The server ID database is
+// left out when it is stored in PostgreSQL, which has its own backup tools.
+func BackupDBs(dir string) (string, error) {
+	defer observeDBQuery("app", "BackupDBs", time.Now())
+	dest := filepath.Join(dir, backupName(time.Now()))
+	if err := util.CreateDirectory(dest); err != nil {
+		return "", logger.LogAppErrorf("Unable to create backup directory %s: %s",
+			dest, err)
+	}
+	if ServerDB != nil {
+		if d, ok := ServerDB.driver.(sqliteServerDriver); ok {
+			if err := backupSQLite(ServerDB.db, filepath.Join(dest,
+				filepath.Base(d.file))); err != nil {
+				return "", logger.LogAppErrorf("Unable to back up server DB: %s", err)
+			}
+		}
+	}
+	if AppDB != nil {
+		if err := backupSQLite(AppDB.db, filepath.Join(dest,
+			filepath.Base(constants.GetAppDBPath()))); err != nil {
+			return "", logger.LogAppErrorf("Unable to back up app DB: %s", err)
+		}
+	}
+	return dest, nil
+}
+
+// backupSQLite writes a consistent copy of an open SQLite database to file.
+// Unlike copying the database file, this is safe while the database is written to.
+func backupSQLite(conn *sql.DB, file string) error {
+	_, err := conn.Exec("VACUUM INTO $1", file)
+	return err
+}
+
+// ListBackups returns the paths of the backups of the configuration profile in
+// use in dir, from oldest to newest.
+func ListBackups(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var backups []string
+	for _, e := range entries {
+		if e.IsDir() && isBackupName(e.Name()) {
+			backups = append(backups, filepath.Join(dir, e.Name()))
+		}
+	}
+	// the names sort by their (UTC) timestamps
+	sort.Strings(backups)
+	return backups, nil
+}
+
+// PruneBackups deletes all but the newest keep backups in dir.
+func PruneBackups(dir string, keep int) error {
+	backups, err := ListBackups(dir)
+	if err != nil {
+		return err
+	}
+	for len(backups) > keep {
+		if err := os.RemoveAll(backups[0]); err != nil {
+			return err
+		}
+		logger.WriteDebug("Removed old backup %s", backups[0])
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// RestoreBackup replaces the server ID and application database files with
+// their copies in the backup directory, and returns the paths of the files that
+// were replaced. The current files are kept alongside, with a .pre-restore
+// suffix. It must not be used while the databases are open.
+func RestoreBackup(backup string) ([]string, error) {
+	var restored []string
+	for _, p := range []string{constants.GetServerDBPath(),
+		constants.GetAppDBPath()} {
+		src := filepath.Join(backup, filepath.Base(p))
+		if !util.FileExists(src) {
+			continue
+		}
+		if err := restoreFile(src, p); err != nil {
+			return restored, fmt.Errorf("unable to restore %s: %s", p, err)
+		}
+		restored = append(restored, p)
+	}
+	if len(restored) == 0 {
+		return nil, fmt.Errorf("%s does not contain a backup of the databases",
+			backup)
+	}
+	return restored, nil
+}
+
+func restoreFile(src, dest string) error {
+	if err := util.CreateDirectory(filepath.Dir(dest)); err != nil {
+		return err
+	}
+	tmp := dest + ".restore"
+	if err := copyFile(src, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if util.FileExists(dest) {
+		if err := os.Rename(dest, dest+".pre-restore"); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+	// journals of the replaced database would be applied to the restored one
+	for _, suffix := range []string{"-journal", "-wal", "-shm"} {
+		os.Remove(dest + suffix)
+	}
+	return os.Rename(tmp, dest)
+}
+
+func copyFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,94 @@
+package db
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/syncore/a2sapi/src/constants"
+)
+
+func TestBackupNames(t *testing.T) {
+	defer func(p string) { constants.Profile = p }(constants.Profile)
+	at := time.Date(2026, 10, 15, 12, 30, 0, 0, time.UTC)
+	constants.Profile = ""
+	if n := backupName(at); n != "backup-20261015-123000" || !isBackupName(n) {
+		t.Fatalf("Unexpected backup name: %s", n)
+	}
+	constants.Profile = "dev"
+	n := backupName(at)
+	if n != "backup-20261015-123000.dev" || !isBackupName(n) {
+		t.Fatalf("Unexpected profile backup name: %s", n)
+	}
+	for _, other := range []string{"backup-20261015-123000",
+		"backup-20261015-123000.prod", "backup-latest.dev", "servers.sqlite"} {
+		if isBackupName(other) {
+			t.Fatalf("Expected %s not to be a backup of the dev profile", other)
+		}
+	}
+}
+
+func TestPruneBackups(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		if err := os.Mkdir(filepath.Join(dir,
+			backupName(start.Add(time.Duration(i)*time.Hour))), 0755); err != nil {
+			t.Fatalf("Unable to create backup dir: %s", err)
+		}
+	}
+	// not a backup; must be kept
+	if err := os.Mkdir(filepath.Join(dir, "other"), 0755); err != nil {
+		t.Fatalf("Unable to create dir: %s", err)
+	}
+	if err := PruneBackups(dir, 2); err != nil {
+		t.Fatalf("Unexpected error pruning backups: %s", err)
+	}
+	backups, err := ListBackups(dir)
+	if err != nil {
+		t.Fatalf("Unexpected error listing backups: %s", err)
+	}
+	if len(backups) != 2 ||
+		filepath.Base(backups[0]) != backupName(start.Add(3*time.Hour)) ||
+		filepath.Base(backups[1]) != backupName(start.Add(4*time.Hour)) {
+		t.Fatalf("Expected the 2 newest backups to be kept, got: %v", backups)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "other")); err != nil {
+		t.Fatalf("Expected other directory to be kept: %s", err)
+	}
+	if backups, err := ListBackups(filepath.Join(dir, "missing")); err != nil ||
+		len(backups) != 0 {
+		t.Fatalf("Expected no backups in a missing dir, got: %v (%v)", backups, err)
+	}
+}
+
+func TestRestoreFile(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "backup.sqlite")
+	dest := filepath.Join(dir, "db", "servers.sqlite")
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		t.Fatalf("Unable to create dir: %s", err)
+	}
+	for file, data := range map[string]string{src: "backup", dest: "current",
+		dest + "-wal": "journal"} {
+		if err := ioutil.WriteFile(file, []byte(data), 0644); err != nil {
+			t.Fatalf("Unable to write %s: %s", file, err)
+		}
+	}
+	if err := restoreFile(src, dest); err != nil {
+		t.Fatalf("Unexpected error restoring file: %s", err)
+	}
+	for file, expected := range map[string]string{dest: "backup",
+		dest + ".pre-restore": "current"} {
+		b, err := ioutil.ReadFile(file)
+		if err != nil || string(b) != expected {
+			t.Fatalf("Expected %s to contain %q, got: %q (%v)", file, expected, b,
+				err)
+		}
+	}
+	if _, err := os.Stat(dest + "-wal"); !os.IsNotExist(err) {
+		t.Fatalf("Expected the replaced database's journal to be removed")
+	}
+}
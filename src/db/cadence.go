@@ -0,0 +1,57 @@
+package db
+
+// cadence.go - Per-server query intervals learned from the servers' activity,
+// stored in the application database.
+
+import (
+	"time"
+
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/models"
+)
+
+// GetServerCadences retrieves the learned query intervals of all of a game's
+// servers, by host.
+func (adb *ADB) GetServerCadences(game string) (map[string]models.DbServerCadence,
+	error) {
+	defer observeDBQuery("app", "GetServerCadences", time.Now())
+	rows, err := adb.db.Query(`SELECT host, interval_secs, last_queried, last_active
+	FROM server_cadence WHERE game =?`, game)
+	if err != nil {
+		return nil, logger.LogAppErrorf("GetServerCadences query error: %s", err)
+	}
+	defer rows.Close()
+	cadences := make(map[string]models.DbServerCadence)
+	for rows.Next() {
+		c := models.DbServerCadence{Game: game}
+		if err := rows.Scan(&c.Host, &c.Interval, &c.LastQueried,
+			&c.LastActive); err != nil {
+			return nil, logger.LogAppErrorf("GetServerCadences scan error: %s", err)
+		}
+		cadences[c.Host] = c
+	}
+	return cadences, rows.Err()
+}
+
+// UpdateServerCadences stores the learned query intervals of the given servers.
+func (adb *ADB) UpdateServerCadences(cadences []models.DbServerCadence) error {
+	defer observeDBQuery("app", "UpdateServerCadences", time.Now())
+	tx, err := adb.db.Begin()
+	if err != nil {
+		return logger.LogAppErrorf("UpdateServerCadences error creating tx: %s", err)
+	}
+	for _, c := range cadences {
+		if _, err := tx.Exec(`INSERT OR REPLACE INTO server_cadence (host, game,
+		interval_secs, last_queried, last_active) VALUES (?, ?, ?, ?, ?)`, c.Host,
+			c.Game, c.Interval, c.LastQueried, c.LastActive); err != nil {
+			tx.Rollback()
+			return logger.LogAppErrorf(
+				"UpdateServerCadences exec error for host %s: %s", c.Host, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return logger.LogAppErrorf("UpdateServerCadences error committing tx: %s",
+			err)
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package db
+
+// claims.go - server ownership claims, stored in the application database
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/models"
+)
+
+// pendingClaimLifetime is how long an unverified claim blocks new claims for
+// the same server.
+const pendingClaimLifetime = 24 * time.Hour
+
+const claimColumns = `server_id, verified, verified_at, alias, description, token,
+	owner_key_hash, notify_url`
+
+func scanClaims(rows *sql.Rows) ([]models.DbServerClaim, error) {
+	var claims []models.DbServerClaim
+	for rows.Next() {
+		c := models.DbServerClaim{}
+		var verified int
+		if err := rows.Scan(&c.ID, &verified, &c.VerifiedAt, &c.Alias,
+			&c.Description, &c.Token, &c.OwnerKeyHash, &c.NotifyURL); err != nil {
+			return nil, err
+		}
+		c.Verified = verified == 1
+		claims = append(claims, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// host & game live in the server ID database
+	if ServerDB != nil {
+		for i := range claims {
+			host, game, err := ServerDB.getHostAndGame(
+				strconv.FormatInt(claims[i].ID, 10))
+			if err != nil {
+				return nil, err
+			}
+			claims[i].Host, claims[i].Game = host, game
+		}
+	}
+	return claims, nil
+}
+
+// GetClaim retrieves the claim for a given server ID. The returned claim is nil
+// if the server has not been claimed.
+func (adb *ADB) GetClaim(id int64) (*models.DbServerClaim, error) {
+	defer observeDBQuery("app", "GetClaim", time.Now())
+	rows, err := adb.db.Query("SELECT "+claimColumns+
+		" FROM claims WHERE server_id =? LIMIT 1", id)
+	if err != nil {
+		return nil, logger.LogAppErrorf(
+			"GetClaim: Error querying database for id %d: %s", id, err)
+	}
+	defer rows.Close()
+	claims, err := scanClaims(rows)
+	if err != nil {
+		return nil, logger.LogAppErrorf(
+			"GetClaim: Error querying database for id %d: %s", id, err)
+	}
+	if len(claims) == 0 {
+		return nil, nil
+	}
+	return &claims[0], nil
+}
+
+// ClaimAuditTarget returns the audit log target of the claim for a given server
+// ID.
+func ClaimAuditTarget(id int64) string {
+	return fmt.Sprintf("server:%d", id)
+}
+
+// StartClaim stores a new, unverified claim for a given server ID, replacing
+// an unverified claim for that server that has expired. It returns false if
+// the server has been claimed or has a pending claim that hasn't expired.
+func (adb *ADB) StartClaim(id int64, token, ownerKeyHash string) (bool, error) {
+	now := time.Now()
+	res, err := adb.db.Exec(
+		`INSERT INTO claims (server_id, token, owner_key_hash, created_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (server_id) DO UPDATE SET token = excluded.token,
+		owner_key_hash = excluded.owner_key_hash, created_at = excluded.created_at
+		WHERE claims.verified = 0 AND claims.created_at <?`, id, token, ownerKeyHash, now.Unix(),
+		now.Add(-pendingClaimLifetime).Unix())
+	if err != nil {
+		return false, logger.LogAppErrorf(
+			"StartClaim: Error creating claim for id %d: %s", id, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, logger.LogAppErrorf(
+			"StartClaim: Error creating claim for id %d: %s", id, err)
+	}
+	return n > 0, nil
+}
+
+// GetPendingClaims retrieves all of the claims that have yet to be verified.
+func (adb *ADB) GetPendingClaims() ([]models.DbServerClaim, error) {
+	return adb.getClaimsByVerified(false)
+}
+
+// GetVerifiedClaims retrieves all of the claims that have been verified.
+func (adb *ADB) GetVerifiedClaims() ([]models.DbServerClaim, error) {
+	return adb.getClaimsByVerified(true)
+}
+
+func (adb *ADB) getClaimsByVerified(verified bool) ([]models.DbServerClaim, error) {
+	v := 0
+	if verified {
+		v = 1
+	}
+	rows, err := adb.db.Query("SELECT "+claimColumns+
+		" FROM claims WHERE verified =?", v)
+	if err != nil {
+		return nil, logger.LogAppErrorf(
+			"getClaimsByVerified: Error querying database for claims: %s", err)
+	}
+	defer rows.Close()
+	claims, err := scanClaims(rows)
+	if err != nil {
+		return nil, logger.LogAppErrorf(
+			"getClaimsByVerified: Error querying database for claims: %s", err)
+	}
+	return claims, nil
+}
+
+// SetClaimVerified marks the claim for a given server ID as verified.
+func (adb *ADB) SetClaimVerified(id int64) error {
+	_, err := adb.db.Exec(
+		"UPDATE claims SET verified = 1, verified_at =? WHERE server_id =?",
+		time.Now().Unix(), id)
+	if err != nil {
+		return logger.LogAppErrorf(
+			"SetClaimVerified: Error verifying claim for id %d: %s", id, err)
+	}
+	return nil
+}
+
+// UpdateClaimMetadata sets the owner-editable metadata for a verified claim.
+func (adb *ADB) UpdateClaimMetadata(id int64, alias, description,
+	notifyURL string) error {
+	_, err := adb.db.Exec(
+		`UPDATE claims SET alias =?, description =?, notify_url =?
+		WHERE server_id =? AND verified = 1`, alias, description, notifyURL, id)
+	if err != nil {
+		return logger.LogAppErrorf(
+			"UpdateClaimMetadata: Error updating claim for id %d: %s", id, err)
+	}
+	return nil
+}
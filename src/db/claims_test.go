@@ -0,0 +1,52 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStartClaim(t *testing.T) {
+	adb, err := OpenAppDB()
+	if err != nil {
+		t.Fatalf("Error opening application database: %s", err)
+	}
+	defer adb.Close()
+	id := time.Now().UnixNano()
+	defer adb.db.Exec("DELETE FROM claims WHERE server_id =?", id)
+
+	if started, err := adb.StartClaim(id, "a2sapi-first", "hash1"); err != nil ||
+		!started {
+		t.Fatalf("Expected claim to be started, got: %v (%v)", started, err)
+	}
+	// a pending claim can't be replaced until it expires
+	if started, err := adb.StartClaim(id, "a2sapi-second", "hash2"); err != nil ||
+		started {
+		t.Fatalf("Expected pending claim to be kept, got: %v (%v)", started, err)
+	}
+	var token string
+	if err := adb.db.QueryRow("SELECT token FROM claims WHERE server_id =?",
+		id).Scan(&token); err != nil || token != "a2sapi-first" {
+		t.Fatalf("Expected token a2sapi-first, got: %s (%v)", token, err)
+	}
+	if _, err := adb.db.Exec("UPDATE claims SET created_at =? WHERE server_id =?",
+		time.Now().Add(-pendingClaimLifetime-time.Minute).Unix(), id); err != nil {
+		t.Fatalf("Error expiring claim: %s", err)
+	}
+	if started, err := adb.StartClaim(id, "a2sapi-third", "hash3"); err != nil ||
+		!started {
+		t.Fatalf("Expected expired claim to be replaced, got: %v (%v)", started,
+			err)
+	}
+	// verified claims are never replaced
+	if err := adb.SetClaimVerified(id); err != nil {
+		t.Fatalf("Error verifying claim: %s", err)
+	}
+	if _, err := adb.db.Exec("UPDATE claims SET created_at =? WHERE server_id =?",
+		time.Now().Add(-pendingClaimLifetime-time.Minute).Unix(), id); err != nil {
+		t.Fatalf("Error expiring claim: %s", err)
+	}
+	if started, err := adb.StartClaim(id, "a2sapi-fourth", "hash4"); err != nil ||
+		started {
+		t.Fatalf("Expected verified claim to be kept, got: %v (%v)", started, err)
+	}
+}
@@ -6,31 +6,21 @@ import (
 	"fmt"
 	"net"
 	"runtime"
+	"strings"
+	"sync"
+	"time"
 
-	"github.com/syncore/a2sapi/src/constants"
+	"github.com/syncore/a2sapi/src/config"
 	"github.com/syncore/a2sapi/src/logger"
 	"github.com/syncore/a2sapi/src/models"
-
-	"github.com/oschwald/maxminddb-golang"
+	"github.com/syncore/a2sapi/src/util"
 )
 
 // CDB represents a database containing geolocation information.
 type CDB struct {
-	db *maxminddb.Reader
-}
-
-// This is an intermediate struct to represent the MaxMind DB format, not for JSON
-type mmdbformat struct {
-	Country struct {
-		IsoCode string            `maxminddb:"iso_code"`
-		Names   map[string]string `maxminddb:"names"`
-	} `maxminddb:"country"`
-	Continent struct {
-		Names map[string]string `maxminddb:"names"`
-	} `maxminddb:"continent"`
-	Subdivisions []struct {
-		IsoCode string `maxminddb:"iso_code"`
-	} `maxminddb:"subdivisions"`
+	// the provider is replaced when the database is reloaded
+	mu       sync.RWMutex
+	provider geoIPProvider
 }
 
 func getDefaultCountryData() models.DbCountry {
@@ -42,11 +32,37 @@ func getDefaultCountryData() models.DbCountry {
 	}
 }
 
+func getLANCountryData() models.DbCountry {
+	return models.DbCountry{
+		CountryName: "LAN",
+		CountryCode: "LAN",
+		Continent:   "LAN",
+		State:       "LAN",
+	}
+}
+
+// getFlagEmoji returns the flag emoji for a two-letter ISO 3166-1 country code,
+// which is the pair of regional indicator symbols for its letters, or an empty
+// string if the code is not valid.
+func getFlagEmoji(isocode string) string {
+	if len(isocode) != 2 {
+		return ""
+	}
+	var flag []rune
+	for _, c := range strings.ToUpper(isocode) {
+		if c < 'A' || c > 'Z' {
+			return ""
+		}
+		flag = append(flag, 0x1F1E6+(c-'A'))
+	}
+	return string(flag)
+}
+
 // OpenCountryDB opens the country lookup database for reading. The caller of
 // this function will be responsinble for calling .Close().
 func OpenCountryDB() (*CDB, error) {
 	// Note: the caller of this function needs to handle .Close()
-	conn, err := maxminddb.Open(constants.CountryDbFilePath)
+	provider, err := newConfiguredGeoIPProvider()
 	if err != nil {
 		dir := "build/nix"
 		if runtime.GOOS == "windows" {
@@ -59,14 +75,47 @@ func OpenCountryDB() (*CDB, error) {
 directory to get the country DB file, or download it from:
 http://geolite.maxmind.com/download/geoip/database/GeoLite2-City.mmdb.gz and
 extract the "GeoLite2-City.mmdb" file into a directory called "db" in the same
-directory as the a2sapi executable. Error: %s`, dir, err))
+directory as the a2sapi executable, or set geoIPProvider to "none" in the
+configuration file to run without locations. Error: %s`, dir, err))
+	}
+	return &CDB{provider: provider}, nil
+}
+
+// newConfiguredGeoIPProvider returns the GeoIP provider set in the
+// configuration.
+func newConfiguredGeoIPProvider() (geoIPProvider, error) {
+	kind, cityFile, asnFile := "", "", ""
+	if config.Get() != nil {
+		cfg := config.Get().AdminConfig
+		kind, cityFile, asnFile = cfg.GeoIPProvider, cfg.GeoIPCityDBFile,
+			cfg.GeoIPASNDBFile
+	}
+	return newGeoIPProvider(kind, cityFile, asnFile)
+}
+
+// Reload re-opens the geolocation database files, i.e. after they have been
+// updated. The previous database is kept if the files cannot be opened.
+func (cdb *CDB) Reload() error {
+	provider, err := newConfiguredGeoIPProvider()
+	if err != nil {
+		return logger.LogAppErrorf(
+			"Unable to reload country database, keeping the previous one: %s", err)
+	}
+	cdb.mu.Lock()
+	old := cdb.provider
+	cdb.provider = provider
+	cdb.mu.Unlock()
+	if err := old.close(); err != nil {
+		logger.LogAppErrorf("Error closing previous country database: %s", err)
 	}
-	return &CDB{db: conn}, nil
+	return nil
 }
 
 // Close closes the country geolocation database.
 func (cdb *CDB) Close() {
-	err := cdb.db.Close()
+	cdb.mu.Lock()
+	defer cdb.mu.Unlock()
+	err := cdb.provider.close()
 	if err != nil {
 		logger.LogAppErrorf("Error closing country database: %s", err)
 	}
@@ -75,31 +124,19 @@ func (cdb *CDB) Close() {
 // GetCountryInfo attempts to retrieve the country information for a given IP,
 // returning the result as a country model object over the corresponding result channel.
 func (cdb *CDB) GetCountryInfo(ch chan<- models.DbCountry, ipstr string) {
-	ip := net.ParseIP(ipstr)
-	c := &mmdbformat{}
-	err := cdb.db.Lookup(ip, c)
-	if err != nil {
-		ch <- getDefaultCountryData()
+	defer observeDBQuery("country", "GetCountryInfo", time.Now())
+	// Private & loopback addresses would produce bogus lookups
+	if util.IsLANAddress(ipstr) {
+		ch <- getLANCountryData()
 		return
 	}
-	if c.Country.Names["en"] == "" || c.Country.IsoCode == "" {
+	cdb.mu.RLock()
+	countrydata, ok := cdb.provider.lookup(net.ParseIP(ipstr))
+	cdb.mu.RUnlock()
+	if !ok {
 		ch <- getDefaultCountryData()
 		return
 	}
-
-	countrydata := models.DbCountry{
-		CountryName: c.Country.Names["en"],
-		CountryCode: c.Country.IsoCode,
-		Continent:   c.Continent.Names["en"],
-	}
-	if c.Country.IsoCode == "US" {
-		if len(c.Subdivisions) > 0 {
-			countrydata.State = c.Subdivisions[0].IsoCode
-		} else {
-			countrydata.State = "Unknown"
-		}
-	} else {
-		countrydata.State = "None"
-	}
+	countrydata.FlagEmoji = getFlagEmoji(countrydata.CountryCode)
 	ch <- countrydata
 }
@@ -45,3 +45,55 @@ func TestGetCountryInfo(t *testing.T) {
 			ip, cinfo.CountryCode)
 	}
 }
+
+func TestGetCountryInfoLAN(t *testing.T) {
+	cdb, err := OpenCountryDB()
+	if err != nil {
+		t.Fatalf("Error opening country database: %s", err)
+	}
+	defer cdb.Close()
+	c := make(chan models.DbCountry, 1)
+	for _, ip := range []string{"192.168.1.10", "10.0.0.5", "172.20.1.1",
+		"127.0.0.1", "fd00::1"} {
+		go cdb.GetCountryInfo(c, ip)
+		cinfo := <-c
+		if cinfo.CountryCode != "LAN" {
+			t.Fatalf("Expected country code to be LAN for IP: %s, got: %s",
+				ip, cinfo.CountryCode)
+		}
+	}
+}
+
+func TestGetFlagEmoji(t *testing.T) {
+	if flag := getFlagEmoji("de"); flag != "\U0001F1E9\U0001F1EA" {
+		t.Fatalf("Expected German flag emoji, got: %q", flag)
+	}
+	for _, code := range []string{"", "Unknown", "LAN", "1A"} {
+		if flag := getFlagEmoji(code); flag != "" {
+			t.Fatalf("Expected no flag emoji for %q, got: %q", code, flag)
+		}
+	}
+}
+
+func TestGeoIPProviders(t *testing.T) {
+	p, err := newGeoIPProvider(GeoIPNone, "", "")
+	if err != nil {
+		t.Fatalf("Error creating GeoIP provider: %s", err)
+	}
+	cdb := &CDB{provider: p}
+	defer cdb.Close()
+	c := make(chan models.DbCountry, 1)
+	go cdb.GetCountryInfo(c, "89.20.244.197")
+	if cinfo := <-c; cinfo.CountryCode != "Unknown" || cinfo.City != "" ||
+		cinfo.ASN != 0 {
+		t.Fatalf("Expected unknown location without a provider, got: %+v", cinfo)
+	}
+	// LAN addresses are never looked up
+	go cdb.GetCountryInfo(c, "192.168.1.10")
+	if cinfo := <-c; cinfo.CountryCode != "LAN" {
+		t.Fatalf("Expected LAN location, got: %+v", cinfo)
+	}
+	if _, err := newGeoIPProvider("ip2location", "", ""); err == nil {
+		t.Fatalf("Expected error for unknown GeoIP provider")
+	}
+}
@@ -0,0 +1,107 @@
+package db
+
+// cyclesnapshots.go - Full server lists of past retrieval cycles, stored
+// compressed in the application database so that the list can be retrieved as
+// it was at a point in time.
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"time"
+
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/models"
+)
+
+// encodeCycleSnapshot returns the server list as gzipped JSON.
+func encodeCycleSnapshot(sl *models.APIServerList) ([]byte, error) {
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	if err := json.NewEncoder(zw).Encode(sl); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// decodeCycleSnapshot returns the server list from its gzipped JSON.
+func decodeCycleSnapshot(data []byte) (*models.APIServerList, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	b, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return nil, err
+	}
+	sl := &models.APIServerList{}
+	if err := json.Unmarshal(b, sl); err != nil {
+		return nil, err
+	}
+	return sl, nil
+}
+
+// AddCycleSnapshot stores a game's server list from a retrieval cycle made at
+// the given time.
+func (adb *ADB) AddCycleSnapshot(game string, sl *models.APIServerList,
+	at int64) error {
+	defer observeDBQuery("app", "AddCycleSnapshot", time.Now())
+	data, err := encodeCycleSnapshot(sl)
+	if err != nil {
+		return logger.LogAppErrorf("AddCycleSnapshot error encoding %s list: %s",
+			game, err)
+	}
+	if _, err := adb.db.Exec(`INSERT INTO cycle_snapshots (game, cycle_id,
+	server_count, data, created_at) VALUES (?, ?, ?, ?, ?)`, game, sl.CycleID,
+		len(sl.Servers), data, at); err != nil {
+		return logger.LogAppErrorf("AddCycleSnapshot exec error for %s: %s", game,
+			err)
+	}
+	return nil
+}
+
+// GetCycleSnapshots retrieves each game's latest server list stored at or
+// before the given time, or only the given game's if game is not empty. Games
+// without a list stored by that time are not included.
+func (adb *ADB) GetCycleSnapshots(game string,
+	at int64) (map[string]*models.APIServerList, error) {
+	defer observeDBQuery("app", "GetCycleSnapshots", time.Now())
+	rows, err := adb.db.Query(`SELECT game, data FROM cycle_snapshots WHERE
+	snapshot_id IN (SELECT MAX(snapshot_id) FROM cycle_snapshots WHERE
+	created_at <=? AND (? = '' OR game =?) GROUP BY game)`, at, game, game)
+	if err != nil {
+		return nil, logger.LogAppErrorf("GetCycleSnapshots query error: %s", err)
+	}
+	defer rows.Close()
+	lists := make(map[string]*models.APIServerList)
+	for rows.Next() {
+		var g string
+		var data []byte
+		if err := rows.Scan(&g, &data); err != nil {
+			return nil, logger.LogAppErrorf("GetCycleSnapshots scan error: %s", err)
+		}
+		sl, err := decodeCycleSnapshot(data)
+		if err != nil {
+			return nil, logger.LogAppErrorf(
+				"GetCycleSnapshots error decoding %s list: %s", g, err)
+		}
+		lists[g] = sl
+	}
+	return lists, rows.Err()
+}
+
+// PruneCycleSnapshots deletes the server lists stored before the given time,
+// returning the number deleted.
+func (adb *ADB) PruneCycleSnapshots(before int64) (int64, error) {
+	res, err := adb.db.Exec("DELETE FROM cycle_snapshots WHERE created_at < ?",
+		before)
+	if err != nil {
+		return 0, logger.LogAppErrorf("PruneCycleSnapshots error: %s", err)
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,41 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/syncore/a2sapi/src/models"
+)
+
+func TestEncodeCycleSnapshot(t *testing.T) {
+	sl := models.GetDefaultServerList()
+	sl.CycleID = "4d1c1f0e-5b5e-4a8e-9a4e-2f1f6c1e7d3a"
+	sl.Servers = append(sl.Servers, models.APIServer{ID: 7, Host: "10.0.0.1:27960",
+		Game: "QuakeLive", Info: models.SteamServerInfo{Map: "bloodrun",
+			Players: 3, MaxPlayers: 8}, Rules: map[string]string{"g_gametype": "4"},
+		Tags: []string{"ca"}})
+	sl.ServerCount = 1
+
+	data, err := encodeCycleSnapshot(sl)
+	if err != nil {
+		t.Fatalf("Error encoding snapshot: %s", err)
+	}
+	decoded, err := decodeCycleSnapshot(data)
+	if err != nil {
+		t.Fatalf("Error decoding snapshot: %s", err)
+	}
+	if decoded.CycleID != sl.CycleID ||
+		decoded.RetrievedTimeStamp != sl.RetrievedTimeStamp ||
+		decoded.ServerCount != 1 || len(decoded.Servers) != 1 {
+		t.Fatalf("Expected the decoded snapshot %+v, got: %+v", sl, decoded)
+	}
+	s := decoded.Servers[0]
+	if s.ID != 7 || s.Host != "10.0.0.1:27960" || s.Info.Map != "bloodrun" ||
+		s.Info.Players != 3 || !reflect.DeepEqual(s.Rules, sl.Servers[0].Rules) ||
+		!reflect.DeepEqual(s.Tags, sl.Servers[0].Tags) {
+		t.Fatalf("Expected the decoded server %+v, got: %+v", sl.Servers[0], s)
+	}
+	if _, err := decodeCycleSnapshot([]byte("{}")); err == nil {
+		t.Fatalf("Expected an error decoding an uncompressed snapshot")
+	}
+}
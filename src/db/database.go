@@ -4,6 +4,7 @@ package db
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/syncore/a2sapi/src/constants"
 	"github.com/syncore/a2sapi/src/logger"
@@ -20,10 +21,24 @@ var CountryDB *CDB
 // server lists.
 var ServerDB *SDB
 
-// InitDBs initializes the geolocation and server information databases for
-// re-use across server list builds. Panics on failure to initialize.
+// AppDB is a package-level variable that contains the application database
+// connection, which holds operational data such as server claims.
+var AppDB *ADB
+
+var dbQueryDurationMetric = util.NewHistogram("a2sapi_db_query_duration_seconds",
+	"Duration of database operations, by database and operation.",
+	util.DurationBuckets, "db", "operation")
+
+// observeDBQuery records the duration of a database operation that started at
+// start; i.e: defer observeDBQuery("server", "GetIDsAPIQuery", time.Now())
+func observeDBQuery(db, op string, start time.Time) {
+	dbQueryDurationMetric.Observe(time.Since(start).Seconds(), db, op)
+}
+
+// InitDBs initializes the geolocation, server information, and application
+// databases for re-use. Panics on failure to initialize.
 func InitDBs() {
-	if CountryDB != nil && ServerDB != nil {
+	if CountryDB != nil && ServerDB != nil && AppDB != nil {
 		return
 	}
 
@@ -37,9 +52,32 @@ func InitDBs() {
 		panic(fmt.Sprintf(
 			"Unable to initialize server information database connection: %s", err))
 	}
+	adb, err := OpenAppDB()
+	if err != nil {
+		panic(fmt.Sprintf(
+			"Unable to initialize application database connection: %s", err))
+	}
 	// Set package-level variables
 	CountryDB = cdb
 	ServerDB = sdb
+	AppDB = adb
+}
+
+// CloseDBs closes the database connections that were opened by InitDBs. It is
+// called on shutdown, once nothing else will use them.
+func CloseDBs() {
+	if CountryDB != nil {
+		CountryDB.Close()
+		CountryDB = nil
+	}
+	if ServerDB != nil {
+		ServerDB.Close()
+		ServerDB = nil
+	}
+	if AppDB != nil {
+		AppDB.Close()
+		AppDB = nil
+	}
 }
 
 func verifyServerDbPath() error {
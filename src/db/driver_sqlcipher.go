@@ -0,0 +1,16 @@
+//go:build sqlcipher
+// +build sqlcipher
+
+package db
+
+// driver_sqlcipher.go - SQLCipher driver (go build -tags sqlcipher), which is
+// SQLite with transparent encryption; registered under the same sqlite3 name
+
+import (
+	// blank import for sqlcipher driver
+	_ "github.com/mutecomm/go-sqlcipher"
+)
+
+// sqlCipherSupported is true if the application was built with SQLCipher, which
+// is required to encrypt the application database.
+const sqlCipherSupported = true
@@ -0,0 +1,16 @@
+//go:build !sqlcipher
+// +build !sqlcipher
+
+package db
+
+// driver_sqlite.go - Standard SQLite driver, used unless built with the sqlcipher
+// tag
+
+import (
+	// blank import for sqlite3 driver
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqlCipherSupported is true if the application was built with SQLCipher, which
+// is required to encrypt the application database.
+const sqlCipherSupported = false
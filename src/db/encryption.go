@@ -0,0 +1,60 @@
+package db
+
+// encryption.go - At-rest encryption of the application database (API keys,
+// audit log, and claimed server owners) with SQLCipher
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/syncore/a2sapi/src/config"
+)
+
+// getAppDBKey returns the application database's encryption key, from the
+// output of the configured key command (i.e: a KMS decrypt call) if there is one,
+// otherwise from the configured environment variable.
+func getAppDBKey() (string, error) {
+	cfg := config.Get().AdminConfig
+	if len(cfg.AppDBKeyCommand) > 0 {
+		var stderr bytes.Buffer
+		cmd := exec.Command(cfg.AppDBKeyCommand[0], cfg.AppDBKeyCommand[1:]...)
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("app DB key command failed: %s: %s", err,
+				strings.TrimSpace(stderr.String()))
+		}
+		key := strings.TrimSpace(string(out))
+		if key == "" {
+			return "", fmt.Errorf("app DB key command returned an empty key")
+		}
+		return key, nil
+	}
+	key := os.Getenv(cfg.AppDBKeyEnv)
+	if key == "" {
+		return "", fmt.Errorf("app DB encryption is enabled but %s is not set",
+			cfg.AppDBKeyEnv)
+	}
+	return key, nil
+}
+
+// appDBDataSource returns the data source name used to open the application
+// database file, which includes the encryption key if encryption is enabled.
+func appDBDataSource(dbfile string) (string, error) {
+	if config.Get() == nil || !config.Get().AdminConfig.EncryptAppDB {
+		return dbfile, nil
+	}
+	if !sqlCipherSupported {
+		return "", fmt.Errorf(
+			"app DB encryption is enabled but this build does not include SQLCipher (build with -tags sqlcipher)")
+	}
+	key, err := getAppDBKey()
+	if err != nil {
+		return "", err
+	}
+	return dbfile + "?_pragma_key=" + url.QueryEscape(key), nil
+}
@@ -0,0 +1,48 @@
+package db
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/syncore/a2sapi/src/config"
+)
+
+func TestAppDBDataSource(t *testing.T) {
+	prev := config.Get().AdminConfig
+	defer func() { config.Get().AdminConfig = prev }()
+
+	config.Get().AdminConfig.EncryptAppDB = false
+	dsn, err := appDBDataSource("app.db")
+	if err != nil || dsn != "app.db" {
+		t.Fatalf("Expected unencrypted data source app.db, got: %s (%v)", dsn, err)
+	}
+
+	config.Get().AdminConfig.EncryptAppDB = true
+	config.Get().AdminConfig.AppDBKeyEnv = "A2SAPI_TEST_APPDB_KEY"
+	config.Get().AdminConfig.AppDBKeyCommand = nil
+	os.Setenv("A2SAPI_TEST_APPDB_KEY", "s3cret&key")
+	defer os.Unsetenv("A2SAPI_TEST_APPDB_KEY")
+	dsn, err = appDBDataSource("app.db")
+	if !sqlCipherSupported {
+		if err == nil {
+			t.Fatalf("Expected error enabling encryption without SQLCipher")
+		}
+		return
+	}
+	if err != nil || dsn != "app.db?_pragma_key=s3cret%26key" {
+		t.Fatalf("Expected keyed data source, got: %s (%v)", dsn, err)
+	}
+
+	config.Get().AdminConfig.AppDBKeyCommand = []string{"echo", "fromkms"}
+	dsn, err = appDBDataSource("app.db")
+	if err != nil || !strings.HasSuffix(dsn, "_pragma_key=fromkms") {
+		t.Fatalf("Expected key from command, got: %s (%v)", dsn, err)
+	}
+
+	os.Unsetenv("A2SAPI_TEST_APPDB_KEY")
+	config.Get().AdminConfig.AppDBKeyCommand = nil
+	if _, err := appDBDataSource("app.db"); err == nil {
+		t.Fatalf("Expected error when no key is available")
+	}
+}
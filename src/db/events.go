@@ -0,0 +1,60 @@
+package db
+
+// events.go - History of server events (servers going offline and coming back
+// online), stored in the application database.
+
+import (
+	"time"
+
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/models"
+)
+
+// AddServerEvents records the given server events.
+func (adb *ADB) AddServerEvents(events []models.DbServerEvent) error {
+	defer observeDBQuery("app", "AddServerEvents", time.Now())
+	tx, err := adb.db.Begin()
+	if err != nil {
+		return logger.LogAppErrorf("AddServerEvents error creating tx: %s", err)
+	}
+	for _, e := range events {
+		if _, err := tx.Exec(`INSERT INTO server_events (server_id, host, game,
+		event, detail, created_at) VALUES (?, ?, ?, ?, ?, ?)`, e.ID, e.Host, e.Game,
+			e.Event, e.Detail, e.Timestamp); err != nil {
+			tx.Rollback()
+			return logger.LogAppErrorf("AddServerEvents exec error for host %s: %s",
+				e.Host, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return logger.LogAppErrorf("AddServerEvents error committing tx: %s", err)
+	}
+	return nil
+}
+
+// GetServerEvents retrieves the most recent server events, including the map
+// change history, newest first. An empty game retrieves the events of all games.
+func (adb *ADB) GetServerEvents(game string, limit int) ([]models.DbServerEvent,
+	error) {
+	defer observeDBQuery("app", "GetServerEvents", time.Now())
+	rows, err := adb.db.Query(`SELECT server_id, host, game, event, detail,
+	created_at FROM server_events WHERE (? = '' OR game = ? COLLATE NOCASE)
+	UNION ALL SELECT server_id, host, game, ?, from_map || ' -> ' || to_map,
+	changed_at FROM map_changes WHERE (? = '' OR game = ? COLLATE NOCASE)
+	ORDER BY created_at DESC LIMIT ?`, game, game, models.ServerEventMapChange,
+		game, game, limit)
+	if err != nil {
+		return nil, logger.LogAppErrorf("GetServerEvents query error: %s", err)
+	}
+	defer rows.Close()
+	var events []models.DbServerEvent
+	for rows.Next() {
+		e := models.DbServerEvent{}
+		if err := rows.Scan(&e.ID, &e.Host, &e.Game, &e.Event, &e.Detail,
+			&e.Timestamp); err != nil {
+			return nil, logger.LogAppErrorf("GetServerEvents scan error: %s", err)
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
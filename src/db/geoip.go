@@ -0,0 +1,177 @@
+package db
+
+// geoip.go - GeoIP providers for the country database: MaxMind GeoLite2 .mmdb
+// files (city, and optionally ASN), or none.
+
+import (
+	"net"
+	"strings"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/constants"
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/models"
+	"github.com/syncore/a2sapi/src/util"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// GeoIP providers
+const (
+	GeoIPMaxMind = "maxmind"
+	GeoIPNone    = "none"
+)
+
+// geoIPProvider looks up the location of IP addresses.
+type geoIPProvider interface {
+	// lookup returns the location of a (public) IP, and false if it is unknown.
+	// The flag emoji and URL are set by the caller.
+	lookup(ip net.IP) (models.DbCountry, bool)
+	close() error
+}
+
+// newGeoIPProvider returns the GeoIP provider of the given kind. The MaxMind
+// city database is required; the ASN database is optional unless its file is
+// set explicitly.
+func newGeoIPProvider(kind, cityFile, asnFile string) (geoIPProvider, error) {
+	switch strings.ToLower(kind) {
+	case "", GeoIPMaxMind:
+		return newMaxMindProvider(cityFile, asnFile)
+	case GeoIPNone:
+		return noGeoIPProvider{}, nil
+	default:
+		return nil, logger.LogAppErrorf("Unknown GeoIP provider: '%s'", kind)
+	}
+}
+
+// GeoIPFiles returns the database files of the configured GeoIP provider, i.e.
+// to check them for updates.
+func GeoIPFiles() []string {
+	cfg := config.Get().AdminConfig
+	if strings.EqualFold(cfg.GeoIPProvider, GeoIPNone) {
+		return nil
+	}
+	files := []string{cfg.GeoIPCityDBFile, cfg.GeoIPASNDBFile}
+	if files[0] == "" {
+		files[0] = constants.CountryDbFilePath
+	}
+	if files[1] == "" {
+		files[1] = constants.ASNDbFilePath
+	}
+	return files
+}
+
+// This is an intermediate struct to represent the MaxMind DB format, not for JSON
+type mmdbformat struct {
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Country struct {
+		IsoCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+	Continent struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"continent"`
+	Location struct {
+		Latitude  float64 `maxminddb:"latitude"`
+		Longitude float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+	Subdivisions []struct {
+		IsoCode string `maxminddb:"iso_code"`
+	} `maxminddb:"subdivisions"`
+}
+
+// Intermediate struct for the MaxMind ASN DB format
+type mmdbASNFormat struct {
+	Number       uint   `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+// maxMindProvider looks up locations in the MaxMind GeoLite2 (or GeoIP2) city
+// database, and networks in the ASN database if there is one.
+type maxMindProvider struct {
+	city *maxminddb.Reader
+	asn  *maxminddb.Reader
+}
+
+func newMaxMindProvider(cityFile, asnFile string) (*maxMindProvider, error) {
+	if cityFile == "" {
+		cityFile = constants.CountryDbFilePath
+	}
+	city, err := maxminddb.Open(cityFile)
+	if err != nil {
+		return nil, err
+	}
+	p := &maxMindProvider{city: city}
+	if asnFile == "" {
+		// optional unless set explicitly
+		if !util.FileExists(constants.ASNDbFilePath) {
+			return p, nil
+		}
+		asnFile = constants.ASNDbFilePath
+	}
+	p.asn, err = maxminddb.Open(asnFile)
+	if err != nil {
+		city.Close()
+		return nil, logger.LogAppErrorf("Unable to open ASN database %s: %s",
+			asnFile, err)
+	}
+	return p, nil
+}
+
+func (p *maxMindProvider) lookup(ip net.IP) (models.DbCountry, bool) {
+	c := &mmdbformat{}
+	if err := p.city.Lookup(ip, c); err != nil {
+		return models.DbCountry{}, false
+	}
+	if c.Country.Names["en"] == "" || c.Country.IsoCode == "" {
+		return models.DbCountry{}, false
+	}
+	countrydata := models.DbCountry{
+		CountryName: c.Country.Names["en"],
+		CountryCode: c.Country.IsoCode,
+		Continent:   c.Continent.Names["en"],
+		City:        c.City.Names["en"],
+		Latitude:    c.Location.Latitude,
+		Longitude:   c.Location.Longitude,
+	}
+	if c.Country.IsoCode == "US" {
+		if len(c.Subdivisions) > 0 {
+			countrydata.State = c.Subdivisions[0].IsoCode
+		} else {
+			countrydata.State = "Unknown"
+		}
+	} else {
+		countrydata.State = "None"
+	}
+	if p.asn != nil {
+		a := &mmdbASNFormat{}
+		if err := p.asn.Lookup(ip, a); err == nil {
+			countrydata.ASN = a.Number
+			countrydata.ASNOrg = a.Organization
+		}
+	}
+	return countrydata, true
+}
+
+func (p *maxMindProvider) close() error {
+	if p.asn != nil {
+		if err := p.asn.Close(); err != nil {
+			return err
+		}
+	}
+	return p.city.Close()
+}
+
+// noGeoIPProvider is used when no GeoIP database is available or wanted;
+// every location is unknown.
+type noGeoIPProvider struct{}
+
+func (noGeoIPProvider) lookup(ip net.IP) (models.DbCountry, bool) {
+	return models.DbCountry{}, false
+}
+
+func (noGeoIPProvider) close() error {
+	return nil
+}
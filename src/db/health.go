@@ -0,0 +1,93 @@
+package db
+
+// health.go - Per-server consecutive failure counts and last online times,
+// stored in the application database, so that servers that go down can be
+// reported instead of silently disappearing from lists.
+
+import (
+	"time"
+
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/models"
+)
+
+// UpdateServerHealth records the outcome of a retrieval for a given game: the
+// online hosts are marked as seen at the given time, and the consecutive failure
+// count of every other known host of the game (whether it failed to respond or
+// is no longer listed by the master server) is incremented. It returns the
+// offline hosts that were last seen online since the given time, along with the
+// hosts that failed during this retrieval.
+func (adb *ADB) UpdateServerHealth(game string, online, failed []string,
+	at, since time.Time) ([]models.APIOfflineServer, error) {
+	defer observeDBQuery("app", "UpdateServerHealth", time.Now())
+	tx, err := adb.db.Begin()
+	if err != nil {
+		return nil, logger.LogAppErrorf("UpdateServerHealth error creating tx: %s", err)
+	}
+	if _, err := tx.Exec(`UPDATE server_health SET consecutive_failures =
+	consecutive_failures + 1 WHERE game =?`, game); err != nil {
+		tx.Rollback()
+		return nil, logger.LogAppErrorf("UpdateServerHealth error updating failures: %s",
+			err)
+	}
+	for _, host := range failed {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO server_health (host, game,
+		consecutive_failures) VALUES (?, ?, 1)`, host, game); err != nil {
+			tx.Rollback()
+			return nil, logger.LogAppErrorf(
+				"UpdateServerHealth exec error for failed host %s: %s", host, err)
+		}
+	}
+	for _, host := range online {
+		if _, err := tx.Exec(`INSERT OR REPLACE INTO server_health (host, game,
+		consecutive_failures, last_seen_online) VALUES (?, ?, 0, ?)`, host, game,
+			at.Unix()); err != nil {
+			tx.Rollback()
+			return nil, logger.LogAppErrorf(
+				"UpdateServerHealth exec error for online host %s: %s", host, err)
+		}
+	}
+
+	offline := make([]models.APIOfflineServer, 0, len(failed))
+	seen := make(map[string]bool, len(failed))
+	rows, err := tx.Query(`SELECT host, consecutive_failures, last_seen_online FROM
+	server_health WHERE game =? AND consecutive_failures > 0 AND last_seen_online >=?`,
+		game, since.Unix())
+	if err != nil {
+		tx.Rollback()
+		return nil, logger.LogAppErrorf("UpdateServerHealth error querying offline hosts: %s",
+			err)
+	}
+	for rows.Next() {
+		s := models.APIOfflineServer{Game: game}
+		if err := rows.Scan(&s.Host, &s.ConsecutiveFailures,
+			&s.LastSeenOnline); err != nil {
+			rows.Close()
+			tx.Rollback()
+			return nil, logger.LogAppErrorf(
+				"UpdateServerHealth error scanning offline hosts: %s", err)
+		}
+		seen[s.Host] = true
+		offline = append(offline, s)
+	}
+	rows.Close()
+	for _, host := range failed {
+		if seen[host] {
+			continue
+		}
+		s := models.APIOfflineServer{Host: host, Game: game}
+		if err := tx.QueryRow(`SELECT consecutive_failures, last_seen_online FROM
+		server_health WHERE host =? AND game =?`, host, game).Scan(
+			&s.ConsecutiveFailures, &s.LastSeenOnline); err != nil {
+			tx.Rollback()
+			return nil, logger.LogAppErrorf(
+				"UpdateServerHealth error retrieving health of host %s: %s", host, err)
+		}
+		offline = append(offline, s)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, logger.LogAppErrorf("UpdateServerHealth error committing tx: %s",
+			err)
+	}
+	return offline, nil
+}
@@ -0,0 +1,59 @@
+package db
+
+// mapchanges.go - History of detected map changes, stored in the application
+// database.
+
+import (
+	"time"
+
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/models"
+)
+
+// AddMapChanges records the given map changes in the map change history.
+func (adb *ADB) AddMapChanges(changes []models.DbMapChange) error {
+	defer observeDBQuery("app", "AddMapChanges", time.Now())
+	tx, err := adb.db.Begin()
+	if err != nil {
+		return logger.LogAppErrorf("AddMapChanges error creating tx: %s", err)
+	}
+	for _, c := range changes {
+		if _, err := tx.Exec(`INSERT INTO map_changes (server_id, host, game,
+		from_map, to_map, changed_at) VALUES (?, ?, ?, ?, ?, ?)`, c.ID, c.Host,
+			c.Game, c.FromMap, c.ToMap, c.Timestamp); err != nil {
+			tx.Rollback()
+			return logger.LogAppErrorf("AddMapChanges exec error for host %s: %s",
+				c.Host, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return logger.LogAppErrorf("AddMapChanges error committing tx: %s", err)
+	}
+	return nil
+}
+
+// GetMapChanges retrieves the map changes recorded for a server since the given
+// time, oldest first, preceded by the last change before that time (if any) so
+// that the map the server was on at the start is known.
+func (adb *ADB) GetMapChanges(id int64, since int64) ([]models.DbMapChange,
+	error) {
+	defer observeDBQuery("app", "GetMapChanges", time.Now())
+	rows, err := adb.db.Query(`SELECT server_id, host, game, from_map, to_map,
+	changed_at FROM map_changes WHERE server_id =? AND (changed_at >=? OR
+	change_id = (SELECT MAX(change_id) FROM map_changes WHERE server_id =? AND
+	changed_at <?)) ORDER BY changed_at, change_id`, id, since, id, since)
+	if err != nil {
+		return nil, logger.LogAppErrorf("GetMapChanges query error: %s", err)
+	}
+	defer rows.Close()
+	var changes []models.DbMapChange
+	for rows.Next() {
+		c := models.DbMapChange{}
+		if err := rows.Scan(&c.ID, &c.Host, &c.Game, &c.FromMap, &c.ToMap,
+			&c.Timestamp); err != nil {
+			return nil, logger.LogAppErrorf("GetMapChanges scan error: %s", err)
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
@@ -0,0 +1,104 @@
+package db
+
+// serverdriver.go - Storage drivers for the server ID database: a local SQLite
+// file, or a PostgreSQL database that multiple instances can share.
+
+import (
+	"database/sql"
+	"strings"
+
+	// PostgreSQL driver
+	_ "github.com/lib/pq"
+	"github.com/syncore/a2sapi/src/constants"
+	"github.com/syncore/a2sapi/src/logger"
+)
+
+// Server ID database drivers
+const (
+	ServerDBSQLite   = "sqlite"
+	ServerDBPostgres = "postgres"
+)
+
+// serverDBDriver is the storage backend of the server ID database. The queries
+// themselves are shared; they use $n placeholders, which both backends accept.
+type serverDBDriver interface {
+	// open returns a connection to the database, creating the servers table if
+	// it does not already exist.
+	open() (*sql.DB, error)
+	// insertServer returns the statement that adds a host ($1), game ($2), and
+	// Steam ID ($3, 0 if unknown).
+	insertServer() string
+}
+
+// newServerDBDriver returns the server ID database driver of the given kind.
+func newServerDBDriver(kind, dsn string) (serverDBDriver, error) {
+	switch strings.ToLower(kind) {
+	case "", ServerDBSQLite:
+		return sqliteServerDriver{file: constants.GetServerDBPath()}, nil
+	case ServerDBPostgres:
+		if dsn == "" {
+			return nil, logger.LogAppErrorf(
+				"A connection string (serverDBDSN) is required for the PostgreSQL server DB")
+		}
+		return postgresServerDriver{dsn: dsn}, nil
+	default:
+		return nil, logger.LogAppErrorf("Unknown server DB driver: '%s'", kind)
+	}
+}
+
+// sqliteServerDriver stores the server IDs in a file local to the instance.
+type sqliteServerDriver struct {
+	file string
+}
+
+func (d sqliteServerDriver) open() (*sql.DB, error) {
+	if err := verifyServerDbPath(); err != nil {
+		return nil, err
+	}
+	return sql.Open("sqlite3", d.file)
+}
+
+func (d sqliteServerDriver) insertServer() string {
+	return "INSERT INTO servers (host, game, steam_id) VALUES ($1, $2, $3)"
+}
+
+// postgresServerDriver stores the server IDs in a PostgreSQL database, so that
+// every instance returns the same ID for a server. Since instances may add the
+// same server at once, hosts and games are unique and duplicates are ignored.
+type postgresServerDriver struct {
+	dsn string
+}
+
+func (d postgresServerDriver) open() (*sql.DB, error) {
+	conn, err := sql.Open("postgres", d.dsn)
+	if err != nil {
+		return nil, logger.LogAppErrorf("Unable to open PostgreSQL server DB: %s", err)
+	}
+	_, err = conn.Exec(`CREATE TABLE IF NOT EXISTS servers (
+	server_id BIGSERIAL PRIMARY KEY,
+	host TEXT NOT NULL,
+	game TEXT NOT NULL,
+	steam_id BIGINT NOT NULL DEFAULT 0,
+	UNIQUE (host, game)
+	)`)
+	if err == nil {
+		// added to tables created before servers were identified by Steam ID
+		_, err = conn.Exec(
+			"ALTER TABLE servers ADD COLUMN IF NOT EXISTS steam_id BIGINT NOT NULL DEFAULT 0")
+	}
+	if err == nil {
+		_, err = conn.Exec(
+			"CREATE INDEX IF NOT EXISTS servers_steam_id ON servers (steam_id, game)")
+	}
+	if err != nil {
+		conn.Close()
+		return nil, logger.LogAppErrorf(
+			"Unable to create servers table in PostgreSQL server DB: %s", err)
+	}
+	return conn, nil
+}
+
+func (d postgresServerDriver) insertServer() string {
+	return `INSERT INTO servers (host, game, steam_id) VALUES ($1, $2, $3)
+	ON CONFLICT (host, game) DO NOTHING`
+}
@@ -0,0 +1,153 @@
+package db
+
+// serveridentity.go - Identification of servers by their Steam IDs in addition
+// to their addresses, so that a server keeps its server ID (and with it, its
+// history, claim, and other data) when it moves to another IP address or port.
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/models"
+)
+
+// serverRow is a server in the server database.
+type serverRow struct {
+	id      int64
+	host    string
+	steamID uint64
+}
+
+// steamIDAction is the change to the server database that reconciles a server's
+// address with its Steam ID.
+type steamIDAction int
+
+const (
+	// the server is already known at its address by its Steam ID, or its
+	// address belongs to a server with another Steam ID that may still move
+	steamIDKeep steamIDAction = iota
+	// the server is new; it is added along with its Steam ID
+	steamIDInsert
+	// the server is known at its address, but not yet by its Steam ID
+	steamIDRecord
+	// the server moved to an address that is not in the server database
+	steamIDMove
+	// the server moved to the address of another known server, which is given
+	// the server's previous address (i.e. two servers swapped addresses)
+	steamIDSwap
+)
+
+// resolveSteamID determines how to reconcile a server at host with Steam ID
+// steamID, given the rows of its game that have either that address or that
+// Steam ID. It returns the action along with the row at the server's address and
+// the row with the server's Steam ID, if any. A row found by its Steam ID always
+// wins over one found by its address, since addresses can be reused by other
+// servers but persistent Steam IDs can't.
+func resolveSteamID(host string, steamID uint64,
+	rows []serverRow) (action steamIDAction, byHost, bySteamID *serverRow) {
+	for i := range rows {
+		if rows[i].host == host && byHost == nil {
+			byHost = &rows[i]
+		}
+		if rows[i].steamID == steamID && bySteamID == nil {
+			bySteamID = &rows[i]
+		}
+	}
+	switch {
+	case bySteamID == nil && byHost == nil:
+		return steamIDInsert, nil, nil
+	case bySteamID == nil && byHost.steamID == 0:
+		return steamIDRecord, byHost, nil
+	case bySteamID == nil:
+		// another server had this address; this one keeps the address's ID until
+		// that server is seen elsewhere and its ID moves with it
+		return steamIDKeep, byHost, nil
+	case bySteamID.host == host:
+		return steamIDKeep, byHost, bySteamID
+	case byHost == nil:
+		return steamIDMove, nil, bySteamID
+	}
+	return steamIDSwap, byHost, bySteamID
+}
+
+// ResolveSteamIDs reconciles the servers' addresses in the server database with
+// their persistent Steam IDs: new servers are added along with their Steam IDs,
+// and a server whose Steam ID is known at another address is moved to its new
+// address, so that it keeps its server ID. Servers must have a host, game, and
+// Steam ID; servers without a persistent Steam ID are only identified by their
+// address, with AddServersToDB.
+func (sdb *SDB) ResolveSteamIDs(servers []models.DbServer) {
+	defer observeDBQuery("server", "ResolveSteamIDs", time.Now())
+	for _, s := range servers {
+		if err := sdb.resolveSteamID(s); err != nil {
+			logger.LogAppErrorf("ResolveSteamIDs error for host %s and game %s: %s",
+				s.Host, s.Game, err)
+		}
+	}
+}
+
+func (sdb *SDB) resolveSteamID(s models.DbServer) error {
+	rows, err := sdb.db.Query(`SELECT server_id, host, steam_id FROM servers
+	WHERE game = $1 AND (host = $2 OR steam_id = $3)`, s.Game, s.Host,
+		int64(s.SteamID))
+	if err != nil {
+		return err
+	}
+	var found []serverRow
+	for rows.Next() {
+		var r serverRow
+		var steamID int64
+		if err := rows.Scan(&r.id, &r.host, &steamID); err != nil {
+			rows.Close()
+			return err
+		}
+		r.steamID = uint64(steamID)
+		found = append(found, r)
+	}
+	rows.Close()
+
+	action, byHost, bySteamID := resolveSteamID(s.Host, s.SteamID, found)
+	switch action {
+	case steamIDInsert:
+		_, err = sdb.db.Exec(sdb.driver.insertServer(), s.Host, s.Game,
+			int64(s.SteamID))
+	case steamIDRecord:
+		_, err = sdb.db.Exec("UPDATE servers SET steam_id = $1 WHERE server_id = $2",
+			int64(s.SteamID), byHost.id)
+	case steamIDMove:
+		logger.LogAppInfo("Server %d (Steam ID %d) moved from %s to %s",
+			bySteamID.id, s.SteamID, bySteamID.host, s.Host)
+		_, err = sdb.db.Exec("UPDATE servers SET host = $1 WHERE server_id = $2",
+			s.Host, bySteamID.id)
+	case steamIDSwap:
+		logger.LogAppInfo(
+			"Server %d (Steam ID %d) moved from %s to %s, the address of server %d; server %d now has %s",
+			bySteamID.id, s.SteamID, bySteamID.host, s.Host, byHost.id, byHost.id,
+			bySteamID.host)
+		err = sdb.swapHosts(*bySteamID, *byHost)
+	}
+	return err
+}
+
+// swapHosts swaps the addresses of two servers of the same game. A temporary
+// address is used in between, since a game's addresses may be unique.
+func (sdb *SDB) swapHosts(a, b serverRow) error {
+	tx, err := sdb.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, u := range []struct {
+		host string
+		id   int64
+	}{{"swap:" + a.host, a.id}, {a.host, b.id}, {b.host, a.id}} {
+		if _, err = tx.Exec("UPDATE servers SET host = $1 WHERE server_id = $2",
+			u.host, u.id); err != nil {
+			if rerr := tx.Rollback(); rerr != nil && rerr != sql.ErrTxDone {
+				logger.LogAppErrorf("swapHosts error rolling back tx: %s", rerr)
+			}
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -5,19 +5,20 @@ package db
 import (
 	"database/sql"
 	"fmt"
+	"strconv"
+	"time"
 
-	"github.com/syncore/a2sapi/src/constants"
+	"github.com/syncore/a2sapi/src/config"
 	"github.com/syncore/a2sapi/src/logger"
 	"github.com/syncore/a2sapi/src/models"
 	"github.com/syncore/a2sapi/src/steam/filters"
 	"github.com/syncore/a2sapi/src/util"
-	// blank import for sqlite3 driver
-	_ "github.com/mattn/go-sqlite3"
 )
 
 // SDB represents a database containing the server ID and game information.
 type SDB struct {
-	db *sql.DB
+	db     *sql.DB
+	driver serverDBDriver
 }
 
 func createServerDBtable(dbfile string) error {
@@ -25,6 +26,7 @@ func createServerDBtable(dbfile string) error {
 	server_id INTEGER NOT NULL,
 	host TEXT NOT NULL,
 	game TEXT NOT NULL,
+	steam_id INTEGER NOT NULL DEFAULT 0,
 	PRIMARY KEY(server_id)
 	)`
 
@@ -46,8 +48,12 @@ func createServerDBtable(dbfile string) error {
 			}
 		case err != nil:
 			return logger.LogAppErrorf("Server DB table verification error: %s", err)
+		default:
+			if err = addSteamIDColumn(db); err != nil {
+				return err
+			}
 		}
-		return nil
+		return createSteamIDIndex(db)
 	}
 
 	err := util.CreateEmptyFile(dbfile, true)
@@ -66,12 +72,41 @@ func createServerDBtable(dbfile string) error {
 		return logger.LogAppErrorf("Unable to create servers table in servers DB: %s",
 			err)
 	}
+	return createSteamIDIndex(db)
+}
+
+// addSteamIDColumn adds the steam_id column to a servers table created before
+// servers were identified by their Steam IDs.
+func addSteamIDColumn(db *sql.DB) error {
+	var n int
+	err := db.QueryRow(
+		"SELECT COUNT(*) FROM pragma_table_info('servers') WHERE name = 'steam_id'").Scan(&n)
+	if err != nil && err != sql.ErrNoRows {
+		return logger.LogAppErrorf("Server DB table verification error: %s", err)
+	}
+	if n > 0 {
+		return nil
+	}
+	if _, err = db.Exec(
+		"ALTER TABLE servers ADD COLUMN steam_id INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return logger.LogAppErrorf("Unable to add steam_id column to servers table: %s",
+			err)
+	}
+	return nil
+}
+
+func createSteamIDIndex(db *sql.DB) error {
+	if _, err := db.Exec(
+		"CREATE INDEX IF NOT EXISTS servers_steam_id ON servers (steam_id, game)"); err != nil {
+		return logger.LogAppErrorf("Unable to create Steam ID index in servers DB: %s",
+			err)
+	}
 	return nil
 }
 
 func (sdb *SDB) serverExists(host string, game string) (bool, error) {
 	rows, err := sdb.db.Query(
-		"SELECT host, game FROM servers WHERE host =? AND GAME =? LIMIT 1",
+		"SELECT host, game FROM servers WHERE host = $1 AND game = $2 LIMIT 1",
 		host, game)
 	if err != nil {
 		return false, logger.LogAppErrorf(
@@ -95,7 +130,7 @@ func (sdb *SDB) serverExists(host string, game string) (bool, error) {
 }
 
 func (sdb *SDB) getHostAndGame(id string) (host, game string, err error) {
-	rows, err := sdb.db.Query("SELECT host, game FROM servers WHERE server_id =? LIMIT 1",
+	rows, err := sdb.db.Query("SELECT host, game FROM servers WHERE server_id = $1 LIMIT 1",
 		id)
 	if err != nil {
 		return host, game,
@@ -113,18 +148,41 @@ func (sdb *SDB) getHostAndGame(id string) (host, game string, err error) {
 	return host, game, nil
 }
 
-// OpenServerDB Opens a database connection to the server database file or if
-// that file does not exists, creates it and then opens a database connection to it.
+// ServerIDExists returns true if a given server ID is in the server database.
+func (sdb *SDB) ServerIDExists(id int64) (bool, error) {
+	defer observeDBQuery("server", "ServerIDExists", time.Now())
+	host, game, err := sdb.getHostAndGame(strconv.FormatInt(id, 10))
+	if err != nil {
+		return false, err
+	}
+	return host != "" && game != "", nil
+}
+
+// GetHostAndGame returns the host and game name of a server ID, or empty
+// strings if the ID is not in the server database.
+func (sdb *SDB) GetHostAndGame(id int64) (host, game string, err error) {
+	defer observeDBQuery("server", "GetHostAndGame", time.Now())
+	return sdb.getHostAndGame(strconv.FormatInt(id, 10))
+}
+
+// OpenServerDB Opens a database connection to the server database, using the
+// configured driver. For the default SQLite driver, if the database file does not
+// exist, it is created and then a database connection is opened to it.
 func OpenServerDB() (*SDB, error) {
-	if err := verifyServerDbPath(); err != nil {
-		// will panic if not verified
-		return nil, logger.LogAppError(err)
+	kind, dsn := "", ""
+	if config.Get() != nil {
+		kind = config.Get().AdminConfig.ServerDBDriver
+		dsn = config.Get().AdminConfig.ServerDBDSN
+	}
+	driver, err := newServerDBDriver(kind, dsn)
+	if err != nil {
+		return nil, err
 	}
-	conn, err := sql.Open("sqlite3", constants.GetServerDBPath())
+	conn, err := driver.open()
 	if err != nil {
 		return nil, logger.LogAppError(err)
 	}
-	return &SDB{db: conn}, nil
+	return &SDB{db: conn, driver: driver}, nil
 }
 
 // Close closes the server database's underlying connection.
@@ -138,6 +196,7 @@ func (sdb *SDB) Close() {
 // AddServersToDB inserts a specified host and port with its game name into the
 // server database.
 func (sdb *SDB) AddServersToDB(hostsgames map[string]string) {
+	defer observeDBQuery("server", "AddServersToDB", time.Now())
 	toInsert := make(map[string]string, len(hostsgames))
 	for host, game := range hostsgames {
 		// If direct queries are enabled, don't add 'Unspecified' game to server DB
@@ -160,8 +219,7 @@ func (sdb *SDB) AddServersToDB(hostsgames map[string]string) {
 	}
 	var txexecerr error
 	for host, game := range toInsert {
-		_, txexecerr = tx.Exec("INSERT INTO servers (host, game) VALUES ($1, $2)",
-			host, game)
+		_, txexecerr = tx.Exec(sdb.driver.insertServer(), host, game, 0)
 		if txexecerr != nil {
 			logger.LogAppErrorf(
 				"AddServersToDB exec error for host %s and game %s: %s", host, game, err)
@@ -187,30 +245,23 @@ func (sdb *SDB) AddServersToDB(hostsgames map[string]string) {
 // a host to id mapping.
 func (sdb *SDB) GetIDsForServerList(result chan map[string]int64,
 	hosts map[string]string) {
+	defer observeDBQuery("server", "GetIDsForServerList", time.Now())
 	m := make(map[string]int64, len(hosts))
+	// callers wait on the result, so always send whatever was retrieved
+	defer func() { result <- m }()
 	for host, game := range hosts {
-		rows, err := sdb.db.Query(
-			"SELECT server_id FROM servers WHERE host =? AND game =? LIMIT 1",
-			host, game)
-		if err != nil {
+		var id int64
+		err := sdb.db.QueryRow(
+			"SELECT server_id FROM servers WHERE host = $1 AND game = $2 LIMIT 1",
+			host, game).Scan(&id)
+		if err != nil && err != sql.ErrNoRows {
 			logger.LogAppErrorf(
 				"GetIDsForServerList: Error querying database to retrieve ID for host %s and game %s: %s",
 				host, game, err)
 			return
 		}
-		defer rows.Close()
-		var id int64
-		for rows.Next() {
-			if err := rows.Scan(&id); err != nil {
-				logger.LogAppErrorf(
-					"GetIDsForServerList: Error querying database to retrieve ID for host %s: %s",
-					host, err)
-				return
-			}
-		}
 		m[host] = id
 	}
-	result <- m
 }
 
 // GetIDsAPIQuery Retrieves the server ID numbers, hosts, and game name for a given
@@ -218,11 +269,12 @@ func (sdb *SDB) GetIDsForServerList(result chan map[string]int64,
 // file in response to a query from the API. Sends the results over a DbServerID
 // channel for consumption.
 func (sdb *SDB) GetIDsAPIQuery(result chan *models.DbServerID, hosts []string) {
+	defer observeDBQuery("server", "GetIDsAPIQuery", time.Now())
 	m := &models.DbServerID{}
 	for _, h := range hosts {
 		logger.WriteDebug("DB: GetIDsAPIQuery, host: %s", h)
 		rows, err := sdb.db.Query(
-			"SELECT server_id, host, game FROM servers WHERE host LIKE ?",
+			"SELECT server_id, host, game FROM servers WHERE host LIKE $1",
 			fmt.Sprintf("%%%s%%", h))
 		if err != nil {
 			logger.LogAppErrorf(
@@ -258,8 +310,14 @@ func (sdb *SDB) GetIDsAPIQuery(result chan *models.DbServerID, hosts []string) {
 // host to game name string mapping.
 func (sdb *SDB) GetHostsAndGameFromIDAPIQuery(result chan map[string]string,
 	ids []string) {
+	defer observeDBQuery("server", "GetHostsAndGameFromIDAPIQuery", time.Now())
 	hosts := make(map[string]string, len(ids))
 	for _, id := range ids {
+		// IDs are numeric; anything else can't match (and is an error for the
+		// PostgreSQL driver)
+		if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+			continue
+		}
 		host, game, err := sdb.getHostAndGame(id)
 		if err != nil {
 			logger.LogAppErrorf("Error getting host from ID for API query: %s", err)
@@ -272,3 +330,36 @@ func (sdb *SDB) GetHostsAndGameFromIDAPIQuery(result chan map[string]string,
 	}
 	result <- hosts
 }
+
+// GetServersForIPsAPIQuery retrieves the server ID numbers, hosts, and game names
+// of all of the known servers (on any port) at a given set of IP addresses from
+// the server database file in response to a query from the API. Sends the
+// results over a DbServer slice channel for consumption.
+func (sdb *SDB) GetServersForIPsAPIQuery(result chan []models.DbServer,
+	ips []string) {
+	defer observeDBQuery("server", "GetServersForIPsAPIQuery", time.Now())
+	var servers []models.DbServer
+	// callers wait on the result, so always send whatever was retrieved
+	defer func() { result <- servers }()
+	for _, ip := range ips {
+		rows, err := sdb.db.Query(
+			"SELECT server_id, host, game FROM servers WHERE host LIKE $1 ORDER BY host",
+			ip+":%")
+		if err != nil {
+			logger.LogAppErrorf(
+				"GetServersForIPsAPIQuery: Error querying database for IP %s: %s", ip, err)
+			return
+		}
+		for rows.Next() {
+			s := models.DbServer{}
+			if err := rows.Scan(&s.ID, &s.Host, &s.Game); err != nil {
+				rows.Close()
+				logger.LogAppErrorf(
+					"GetServersForIPsAPIQuery: Error querying database for IP %s: %s", ip, err)
+				return
+			}
+			servers = append(servers, s)
+		}
+		rows.Close()
+	}
+}
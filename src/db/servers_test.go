@@ -6,8 +6,6 @@ import (
 
 	"github.com/syncore/a2sapi/src/constants"
 	"github.com/syncore/a2sapi/src/models"
-
-	_ "github.com/mattn/go-sqlite3"
 )
 
 var testData map[string]string
@@ -102,3 +100,93 @@ func TestGetHostsAndGameFromIDAPIQuery(t *testing.T) {
 		t.Fatalf("Expected result QuakeLive, got: %v", result["1172.16.0.1"])
 	}
 }
+
+func TestGetServersForIPsAPIQuery(t *testing.T) {
+	c := make(chan []models.DbServer, 1)
+	db, err := OpenServerDB()
+	if err != nil {
+		t.Fatalf("Unable to open test database: %s", err)
+	}
+	defer db.Close()
+	db.AddServersToDB(map[string]string{
+		"10.0.0.20:27960":  "QuakeLive",
+		"10.0.0.20:27961":  "QuakeLive",
+		"10.0.0.200:27960": "Reflex",
+	})
+	db.GetServersForIPsAPIQuery(c, []string{"10.0.0.20"})
+	result := <-c
+	if len(result) != 2 {
+		t.Fatalf("Expected 2 servers, got: %d", len(result))
+	}
+	if result[0].Host != "10.0.0.20:27960" || result[1].Host != "10.0.0.20:27961" {
+		t.Fatalf("Expected servers 10.0.0.20:27960 and 10.0.0.20:27961, got: %v",
+			result)
+	}
+}
+
+func TestNewServerDBDriver(t *testing.T) {
+	for _, kind := range []string{"", "sqlite", "SQLite"} {
+		d, err := newServerDBDriver(kind, "")
+		if err != nil {
+			t.Fatalf("Unexpected error for driver '%s': %s", kind, err)
+		}
+		if _, ok := d.(sqliteServerDriver); !ok {
+			t.Fatalf("Expected SQLite driver for '%s', got: %T", kind, d)
+		}
+	}
+	d, err := newServerDBDriver("postgres", "postgres://localhost/a2sapi")
+	if err != nil {
+		t.Fatalf("Unexpected error for PostgreSQL driver: %s", err)
+	}
+	if !strings.Contains(d.insertServer(), "ON CONFLICT") {
+		t.Fatalf("Expected PostgreSQL inserts to ignore duplicates, got: %s",
+			d.insertServer())
+	}
+	if _, err := newServerDBDriver("postgres", ""); err == nil {
+		t.Fatalf("Expected error for PostgreSQL driver without a connection string")
+	}
+	if _, err := newServerDBDriver("mysql", ""); err == nil {
+		t.Fatalf("Expected error for unknown driver")
+	}
+}
+
+func TestResolveSteamID(t *testing.T) {
+	const steamID = 85568392920040000
+	tests := []struct {
+		name     string
+		rows     []serverRow
+		expected steamIDAction
+		byHost   int64
+		bySteam  int64
+	}{
+		{"new server", nil, steamIDInsert, 0, 0},
+		{"known by address only", []serverRow{{id: 1, host: "10.0.0.1:27960"}},
+			steamIDRecord, 1, 0},
+		{"known", []serverRow{{id: 1, host: "10.0.0.1:27960", steamID: steamID}},
+			steamIDKeep, 1, 1},
+		{"address of another server",
+			[]serverRow{{id: 1, host: "10.0.0.1:27960", steamID: steamID + 1}},
+			steamIDKeep, 1, 0},
+		{"moved", []serverRow{{id: 2, host: "10.0.0.2:27960", steamID: steamID}},
+			steamIDMove, 0, 2},
+		{"moved to a known address", []serverRow{
+			{id: 1, host: "10.0.0.1:27960", steamID: steamID + 1},
+			{id: 2, host: "10.0.0.2:27960", steamID: steamID}},
+			steamIDSwap, 1, 2},
+	}
+	for _, tt := range tests {
+		action, byHost, bySteam := resolveSteamID("10.0.0.1:27960", steamID, tt.rows)
+		if action != tt.expected {
+			t.Fatalf("%s: expected action %d, got: %d", tt.name, tt.expected, action)
+		}
+		if (byHost == nil && tt.byHost != 0) || (byHost != nil && byHost.id != tt.byHost) {
+			t.Fatalf("%s: expected server %d at the address, got: %+v", tt.name,
+				tt.byHost, byHost)
+		}
+		if (bySteam == nil && tt.bySteam != 0) ||
+			(bySteam != nil && bySteam.id != tt.bySteam) {
+			t.Fatalf("%s: expected server %d with the Steam ID, got: %+v", tt.name,
+				tt.bySteam, bySteam)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package db
+
+// serverstatrollups.go - Downsampling of the player count history: player
+// counts older than the full resolution retention are replaced with hourly
+// averages, and those with daily averages, so that long-term trends remain
+// available without the history growing without bounds.
+
+import (
+	"database/sql"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/models"
+)
+
+// mergeServerStatRollup merges a downsampled period into the existing one for
+// the same server and period, if any, weighting the averages by their samples.
+const mergeServerStatRollup = `ON CONFLICT (server_id, resolution, period_start)
+	DO UPDATE SET
+	players_avg = (players_avg * samples + excluded.players_avg * excluded.samples)
+		/ (samples + excluded.samples),
+	bots_avg = (bots_avg * samples + excluded.bots_avg * excluded.samples)
+		/ (samples + excluded.samples),
+	players_min = MIN(players_min, excluded.players_min),
+	players_max = MAX(players_max, excluded.players_max),
+	max_players = MAX(max_players, excluded.max_players),
+	samples = samples + excluded.samples`
+
+// periodStart returns the start of the period of the given length (in seconds)
+// that t is in.
+func periodStart(t, resolution int64) int64 {
+	return t - t%resolution
+}
+
+// downsample inserts the aggregated periods selected by insert and deletes the
+// rows that were aggregated with del, in a single transaction, returning the
+// number of rows deleted.
+func (adb *ADB) downsample(op, insert string, insertArgs []interface{},
+	del string, delArgs ...interface{}) (int64, error) {
+	defer observeDBQuery("app", op, time.Now())
+	tx, err := adb.db.Begin()
+	if err != nil {
+		return 0, logger.LogAppErrorf("%s error creating tx: %s", op, err)
+	}
+	if _, err := tx.Exec(insert, insertArgs...); err != nil {
+		tx.Rollback()
+		return 0, logger.LogAppErrorf("%s insert error: %s", op, err)
+	}
+	res, err := tx.Exec(del, delArgs...)
+	if err != nil {
+		tx.Rollback()
+		return 0, logger.LogAppErrorf("%s delete error: %s", op, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, logger.LogAppErrorf("%s error committing tx: %s", op, err)
+	}
+	return res.RowsAffected()
+}
+
+// DownsampleServerStats replaces the player counts recorded in the hours that
+// ended before the given time with each server's hourly averages, returning the
+// number of player counts replaced.
+func (adb *ADB) DownsampleServerStats(before int64) (int64, error) {
+	hour := int64(models.HistoryResolutionHour)
+	before = periodStart(before, hour)
+	return adb.downsample("DownsampleServerStats",
+		`INSERT INTO server_stat_rollups (server_id, game, resolution,
+	period_start, samples, players_avg, players_min, players_max, bots_avg,
+	max_players) SELECT server_id, MAX(game), ?, recorded_at - recorded_at % ?,
+	COUNT(*), AVG(players), MIN(players), MAX(players), AVG(bots),
+	MAX(max_players) FROM server_stats WHERE recorded_at < ?
+	GROUP BY server_id, recorded_at - recorded_at % ? `+mergeServerStatRollup,
+		[]interface{}{hour, hour, before, hour},
+		"DELETE FROM server_stats WHERE recorded_at < ?", before)
+}
+
+// DownsampleHourlyServerStats replaces the hourly averages of the days that
+// ended before the given time with each server's daily averages, returning the
+// number of hourly averages replaced.
+func (adb *ADB) DownsampleHourlyServerStats(before int64) (int64, error) {
+	hour, day := int64(models.HistoryResolutionHour),
+		int64(models.HistoryResolutionDay)
+	before = periodStart(before, day)
+	return adb.downsample("DownsampleHourlyServerStats",
+		`INSERT INTO server_stat_rollups (server_id, game, resolution,
+	period_start, samples, players_avg, players_min, players_max, bots_avg,
+	max_players) SELECT server_id, MAX(game), ?, period_start - period_start % ?,
+	SUM(samples), SUM(players_avg * samples) / SUM(samples), MIN(players_min),
+	MAX(players_max), SUM(bots_avg * samples) / SUM(samples), MAX(max_players)
+	FROM server_stat_rollups WHERE resolution = ? AND period_start < ?
+	GROUP BY server_id, period_start - period_start % ? `+mergeServerStatRollup,
+		[]interface{}{day, day, hour, before, day},
+		`DELETE FROM server_stat_rollups WHERE resolution =? AND
+	period_start < ?`, hour, before)
+}
+
+// PruneServerStatRollups deletes the downsampled periods of the given
+// resolution that started before the given time, returning the number deleted.
+func (adb *ADB) PruneServerStatRollups(resolution, before int64) (int64, error) {
+	res, err := adb.db.Exec(`DELETE FROM server_stat_rollups WHERE resolution =?
+	AND period_start < ?`, resolution, before)
+	if err != nil {
+		return 0, logger.LogAppErrorf("PruneServerStatRollups error: %s", err)
+	}
+	return res.RowsAffected()
+}
+
+// GetServerStatRollups retrieves the downsampled periods of a server's player
+// count history that started since the given time, oldest first.
+func (adb *ADB) GetServerStatRollups(id int64,
+	since int64) ([]models.DbServerStat, error) {
+	defer observeDBQuery("app", "GetServerStatRollups", time.Now())
+	rows, err := adb.db.Query(`SELECT server_id, game, resolution, period_start,
+	samples, players_avg, players_min, players_max, bots_avg, max_players
+	FROM server_stat_rollups WHERE server_id =? AND period_start >=?
+	ORDER BY period_start`, id, since)
+	if err != nil {
+		return nil, logger.LogAppErrorf("GetServerStatRollups query error: %s", err)
+	}
+	defer rows.Close()
+	var stats []models.DbServerStat
+	for rows.Next() {
+		s, err := scanServerStatRollup(rows)
+		if err != nil {
+			return nil, logger.LogAppErrorf("GetServerStatRollups scan error: %s",
+				err)
+		}
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
+
+// scanServerStatRollup scans a downsampled period as a player count history
+// point with the rounded averages.
+func scanServerStatRollup(rows *sql.Rows) (models.DbServerStat, error) {
+	s := models.DbServerStat{Downsampled: &models.DbServerStatPeriod{}}
+	var botsAvg float64
+	err := rows.Scan(&s.ID, &s.Game, &s.Downsampled.Resolution, &s.Timestamp,
+		&s.Downsampled.Samples, &s.Downsampled.AvgPlayers,
+		&s.Downsampled.MinPlayers, &s.Downsampled.PeakPlayers, &botsAvg,
+		&s.MaxPlayers)
+	s.Players = int(math.Round(s.Downsampled.AvgPlayers))
+	s.Bots = int(math.Round(botsAvg))
+	return s, err
+}
+
+// GetServerHistory retrieves a server's player count history since the given
+// time, oldest first: the downsampled periods followed by the player counts
+// that are still at full resolution.
+func (adb *ADB) GetServerHistory(id int64, since int64) ([]models.DbServerStat,
+	error) {
+	rollups, err := adb.GetServerStatRollups(id, since)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := adb.GetServerStats(id, since)
+	if err != nil {
+		return nil, err
+	}
+	return mergeServerHistory(rollups, stats), nil
+}
+
+// mergeServerHistory returns the downsampled periods and the full resolution
+// player counts in a single series, oldest first.
+func mergeServerHistory(rollups,
+	stats []models.DbServerStat) []models.DbServerStat {
+	history := append(rollups, stats...)
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].Timestamp < history[j].Timestamp
+	})
+	return history
+}
@@ -0,0 +1,44 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/syncore/a2sapi/src/models"
+)
+
+func TestPeriodStart(t *testing.T) {
+	// 2026-03-31 12:30:15 UTC
+	ts := int64(1774960215)
+	if s := periodStart(ts, models.HistoryResolutionHour); s != 1774958400 {
+		t.Fatalf("Expected the hour to start at 1774958400, got: %d", s)
+	}
+	if s := periodStart(ts, models.HistoryResolutionDay); s != 1774915200 {
+		t.Fatalf("Expected the day to start at 1774915200, got: %d", s)
+	}
+	if s := periodStart(1774958400, models.HistoryResolutionHour); s != 1774958400 {
+		t.Fatalf("Expected a period's start to be its own start, got: %d", s)
+	}
+}
+
+func TestMergeServerHistory(t *testing.T) {
+	day := &models.DbServerStatPeriod{Resolution: models.HistoryResolutionDay,
+		Samples: 288, AvgPlayers: 4.4, MinPlayers: 0, PeakPlayers: 12}
+	hour := &models.DbServerStatPeriod{Resolution: models.HistoryResolutionHour,
+		Samples: 12, AvgPlayers: 7.6, MinPlayers: 5, PeakPlayers: 9}
+	rollups := []models.DbServerStat{
+		{ID: 1, Timestamp: 1774915200, Players: 4, Downsampled: day},
+		{ID: 1, Timestamp: 1775001600, Players: 8, Downsampled: hour},
+	}
+	stats := []models.DbServerStat{
+		{ID: 1, Timestamp: 1775005200, Players: 6},
+		{ID: 1, Timestamp: 1775005500, Players: 7},
+	}
+	expected := []models.DbServerStat{rollups[0], rollups[1], stats[0], stats[1]}
+	if h := mergeServerHistory(rollups, stats); !reflect.DeepEqual(h, expected) {
+		t.Fatalf("Expected history %+v, got: %+v", expected, h)
+	}
+	if h := mergeServerHistory(nil, stats); !reflect.DeepEqual(h, stats) {
+		t.Fatalf("Expected only the full resolution history, got: %+v", h)
+	}
+}
@@ -0,0 +1,95 @@
+package db
+
+// serverstats.go - History of the servers' player counts, recorded after each
+// timed retrieval and stored in the application database.
+
+import (
+	"time"
+
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/models"
+)
+
+// AddServerStats records the given player counts.
+func (adb *ADB) AddServerStats(stats []models.DbServerStat) error {
+	defer observeDBQuery("app", "AddServerStats", time.Now())
+	tx, err := adb.db.Begin()
+	if err != nil {
+		return logger.LogAppErrorf("AddServerStats error creating tx: %s", err)
+	}
+	stmt, err := tx.Prepare(`INSERT INTO server_stats (server_id, game, players,
+	bots, max_players, recorded_at) VALUES (?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		tx.Rollback()
+		return logger.LogAppErrorf("AddServerStats error preparing insert: %s", err)
+	}
+	defer stmt.Close()
+	for _, s := range stats {
+		if _, err := stmt.Exec(s.ID, s.Game, s.Players, s.Bots, s.MaxPlayers,
+			s.Timestamp); err != nil {
+			tx.Rollback()
+			return logger.LogAppErrorf("AddServerStats exec error for server %d: %s",
+				s.ID, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return logger.LogAppErrorf("AddServerStats error committing tx: %s", err)
+	}
+	return nil
+}
+
+// PruneServerStats deletes the player counts recorded before the given time,
+// returning the number deleted.
+func (adb *ADB) PruneServerStats(before int64) (int64, error) {
+	res, err := adb.db.Exec("DELETE FROM server_stats WHERE recorded_at < ?",
+		before)
+	if err != nil {
+		return 0, logger.LogAppErrorf("PruneServerStats error: %s", err)
+	}
+	return res.RowsAffected()
+}
+
+// GetServerStats retrieves the player counts recorded for a server since the
+// given time, oldest first.
+func (adb *ADB) GetServerStats(id int64, since int64) ([]models.DbServerStat,
+	error) {
+	defer observeDBQuery("app", "GetServerStats", time.Now())
+	rows, err := adb.db.Query(`SELECT server_id, game, players, bots, max_players,
+	recorded_at FROM server_stats WHERE server_id =? AND recorded_at >=?
+	ORDER BY recorded_at`, id, since)
+	if err != nil {
+		return nil, logger.LogAppErrorf("GetServerStats query error: %s", err)
+	}
+	defer rows.Close()
+	var stats []models.DbServerStat
+	for rows.Next() {
+		s := models.DbServerStat{}
+		if err := rows.Scan(&s.ID, &s.Game, &s.Players, &s.Bots, &s.MaxPlayers,
+			&s.Timestamp); err != nil {
+			return nil, logger.LogAppErrorf("GetServerStats scan error: %s", err)
+		}
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
+
+// GetRetrievalTimes retrieves the times of the timed retrievals that recorded
+// player counts for the given game since the given time, oldest first.
+func (adb *ADB) GetRetrievalTimes(game string, since int64) ([]int64, error) {
+	defer observeDBQuery("app", "GetRetrievalTimes", time.Now())
+	rows, err := adb.db.Query(`SELECT DISTINCT recorded_at FROM server_stats
+	WHERE game =? AND recorded_at >=? ORDER BY recorded_at`, game, since)
+	if err != nil {
+		return nil, logger.LogAppErrorf("GetRetrievalTimes query error: %s", err)
+	}
+	defer rows.Close()
+	var times []int64
+	for rows.Next() {
+		var t int64
+		if err := rows.Scan(&t); err != nil {
+			return nil, logger.LogAppErrorf("GetRetrievalTimes scan error: %s", err)
+		}
+		times = append(times, t)
+	}
+	return times, rows.Err()
+}
@@ -0,0 +1,157 @@
+package db
+
+// state.go - Latest per-server state table, kept up to date after each
+// retrieval for downstream tools that would rather use SQL than parse JSON.
+
+import (
+	"database/sql"
+	"path"
+	"time"
+
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/models"
+	"github.com/syncore/a2sapi/src/util"
+)
+
+// STDB represents a database containing the latest state of each server.
+type STDB struct {
+	db *sql.DB
+}
+
+const createLatestStateTable = `CREATE TABLE IF NOT EXISTS latest_state (
+	host TEXT NOT NULL,
+	game TEXT NOT NULL,
+	server_id INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	map TEXT NOT NULL,
+	game_type TEXT NOT NULL,
+	players INTEGER NOT NULL,
+	max_players INTEGER NOT NULL,
+	bots INTEGER NOT NULL,
+	filtered_players INTEGER NOT NULL,
+	server_type TEXT NOT NULL,
+	environment TEXT NOT NULL,
+	private INTEGER NOT NULL,
+	anti_cheat INTEGER NOT NULL,
+	version TEXT NOT NULL,
+	keywords TEXT NOT NULL,
+	ping INTEGER NOT NULL,
+	country_code TEXT NOT NULL,
+	country_name TEXT NOT NULL,
+	region TEXT NOT NULL,
+	state TEXT NOT NULL,
+	online INTEGER NOT NULL,
+	updated_at INTEGER NOT NULL,
+	cycle_id TEXT NOT NULL DEFAULT '',
+	PRIMARY KEY(host, game)
+	)`
+
+// addStateCycleIDColumn adds the cycle_id column to tables created before it
+// existed.
+func addStateCycleIDColumn(conn *sql.DB) error {
+	rows, err := conn.Query("PRAGMA table_info(latest_state)")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cid, notnull, pk int
+		var name, ctype string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &ctype, &notnull, &dflt, &pk); err != nil {
+			return err
+		}
+		if name == "cycle_id" {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_, err = conn.Exec(
+		"ALTER TABLE latest_state ADD COLUMN cycle_id TEXT NOT NULL DEFAULT ''")
+	return err
+}
+
+// OpenStateDB opens a database connection to the latest state database file at
+// dbfile, creating the file and its table if necessary.
+func OpenStateDB(dbfile string) (*STDB, error) {
+	if err := util.CreateDirectory(path.Dir(dbfile)); err != nil {
+		return nil, logger.LogAppErrorf("Unable to create state DB directory: %s", err)
+	}
+	conn, err := sql.Open("sqlite3", dbfile)
+	if err != nil {
+		return nil, logger.LogAppError(err)
+	}
+	if _, err := conn.Exec(createLatestStateTable); err != nil {
+		conn.Close()
+		return nil, logger.LogAppErrorf("Unable to create latest state table: %s", err)
+	}
+	if err := addStateCycleIDColumn(conn); err != nil {
+		conn.Close()
+		return nil, logger.LogAppErrorf("Unable to add cycle_id to latest state table: %s",
+			err)
+	}
+	return &STDB{db: conn}, nil
+}
+
+// Close closes the latest state database's underlying connection.
+func (stdb *STDB) Close() {
+	err := stdb.db.Close()
+	if err != nil {
+		logger.LogAppErrorf("Error closing state DB: %s", err)
+	}
+}
+
+// UpdateLatestState upserts the state of each server in the list for the given
+// game. Servers of that game that were not in the list (i.e. weren't written
+// with its cycle ID) are marked as offline.
+func (stdb *STDB) UpdateLatestState(game string, sl *models.APIServerList) error {
+	defer observeDBQuery("state", "UpdateLatestState", time.Now())
+	now := time.Now().Unix()
+	cycleID := sl.CycleID
+	if cycleID == "" {
+		// lists that weren't retrieved in a cycle still need their own ID to
+		// tell their servers apart from the missing ones
+		cycleID = util.NewUUID()
+	}
+	tx, err := stdb.db.Begin()
+	if err != nil {
+		return logger.LogAppErrorf("UpdateLatestState error creating tx: %s", err)
+	}
+	stmt, err := tx.Prepare(`INSERT OR REPLACE INTO latest_state (host, game,
+	server_id, name, map, game_type, players, max_players, bots, filtered_players,
+	server_type, environment, private, anti_cheat, version, keywords, ping,
+	country_code, country_name, region, state, online, updated_at, cycle_id)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 1, ?, ?)`)
+	if err != nil {
+		tx.Rollback()
+		return logger.LogAppErrorf("UpdateLatestState error preparing statement: %s",
+			err)
+	}
+	defer stmt.Close()
+	for _, s := range sl.Servers {
+		if _, err := stmt.Exec(s.Host, game, s.ID, s.Info.Name, s.Info.Map,
+			s.Info.GameTypeShort, s.Info.Players, s.Info.MaxPlayers, s.Info.Bots,
+			s.FilteredPlayers.FilteredPlayerCount, s.Info.ServerType,
+			s.Info.Environment, s.Info.Visibility, s.Info.VAC, s.Info.Version,
+			s.Info.ExtraData.Keywords, s.Info.Ping, s.CountryInfo.CountryCode,
+			s.CountryInfo.CountryName, s.CountryInfo.Continent, s.CountryInfo.State,
+			now, cycleID); err != nil {
+			tx.Rollback()
+			return logger.LogAppErrorf("UpdateLatestState exec error for host %s: %s",
+				s.Host, err)
+		}
+	}
+	if _, err := tx.Exec(
+		"UPDATE latest_state SET online = 0 WHERE game =? AND cycle_id <>?",
+		game, cycleID); err != nil {
+		tx.Rollback()
+		return logger.LogAppErrorf("UpdateLatestState error marking offline servers: %s",
+			err)
+	}
+	if err := tx.Commit(); err != nil {
+		return logger.LogAppErrorf("UpdateLatestState error committing tx: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/syncore/a2sapi/src/constants"
+	"github.com/syncore/a2sapi/src/models"
+)
+
+func TestUpdateLatestState(t *testing.T) {
+	sdb, err := OpenStateDB(constants.GetStateDBPath())
+	if err != nil {
+		t.Fatalf("Error opening state database: %s", err)
+	}
+	defer sdb.Close()
+	srv := func(id int64, host string) models.APIServer {
+		return models.APIServer{
+			ID:   id,
+			Host: host,
+			Game: "QuakeLive",
+			Info: models.SteamServerInfo{Name: "test", Map: "campgrounds", Players: 4,
+				MaxPlayers: 16},
+			CountryInfo: getLANCountryData(),
+		}
+	}
+	online := func(host string) int {
+		var o int
+		if err := sdb.db.QueryRow(
+			"SELECT online FROM latest_state WHERE host =? AND game =?", host,
+			"QuakeLive").Scan(&o); err != nil {
+			t.Fatalf("Error reading latest state of %s: %s", host, err)
+		}
+		return o
+	}
+	sl := models.GetDefaultServerList()
+	sl.CycleID = "cycle-1"
+	sl.Servers = append(sl.Servers, srv(1, "10.0.0.1:27960"),
+		srv(2, "10.0.0.2:27960"))
+	if err := sdb.UpdateLatestState("QuakeLive", sl); err != nil {
+		t.Fatalf("Error updating latest state: %s", err)
+	}
+	// a server missing from the next list (retrieved within the same second) is
+	// marked offline
+	sl = models.GetDefaultServerList()
+	sl.CycleID = "cycle-2"
+	sl.Servers = append(sl.Servers, srv(1, "10.0.0.1:27960"))
+	if err := sdb.UpdateLatestState("QuakeLive", sl); err != nil {
+		t.Fatalf("Error updating latest state: %s", err)
+	}
+	if o := online("10.0.0.1:27960"); o != 1 {
+		t.Fatalf("Expected listed server to be online, got: %d", o)
+	}
+	if o := online("10.0.0.2:27960"); o != 0 {
+		t.Fatalf("Expected missing server to be offline, got: %d", o)
+	}
+	// no servers: existing ones are marked offline
+	if err := sdb.UpdateLatestState("QuakeLive",
+		models.GetDefaultServerList()); err != nil {
+		t.Fatalf("Error updating latest state with empty list: %s", err)
+	}
+	if o := online("10.0.0.1:27960"); o != 0 {
+		t.Fatalf("Expected server to be offline after empty list, got: %d", o)
+	}
+}
@@ -0,0 +1,244 @@
+package db
+
+// timeseries.go - Exporters that write a per-server row for every retrieval to
+// an external time-series database (ClickHouse or TimescaleDB) for long-term
+// analytics.
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/models"
+	// blank import for postgres (TimescaleDB) driver
+	_ "github.com/lib/pq"
+)
+
+const (
+	// TimeSeriesClickHouse is the name of the ClickHouse time-series exporter.
+	TimeSeriesClickHouse = "clickhouse"
+	// TimeSeriesTimescaleDB is the name of the TimescaleDB time-series exporter.
+	TimeSeriesTimescaleDB = "timescaledb"
+)
+
+var validTableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// TimeSeriesExporter writes snapshots of the server list to a time-series store.
+type TimeSeriesExporter interface {
+	Export(game string, sl *models.APIServerList, at time.Time) error
+	Close()
+}
+
+// NewTimeSeriesExporter returns the exporter of the given kind, which writes to
+// table at the database specified by dsn (an HTTP URL for ClickHouse, and a
+// connection string for TimescaleDB), creating the table if necessary.
+func NewTimeSeriesExporter(kind, dsn, table string) (TimeSeriesExporter, error) {
+	if !validTableName.MatchString(table) {
+		return nil, logger.LogAppErrorf("Invalid time-series table name: '%s'", table)
+	}
+	switch strings.ToLower(kind) {
+	case TimeSeriesClickHouse:
+		return newClickHouseExporter(dsn, table)
+	case TimeSeriesTimescaleDB:
+		return newTimescaleExporter(dsn, table)
+	default:
+		return nil, logger.LogAppErrorf("Unknown time-series exporter: '%s'", kind)
+	}
+}
+
+// clickHouseExporter writes to ClickHouse over its HTTP interface.
+type clickHouseExporter struct {
+	baseURL string
+	table   string
+	client  *http.Client
+}
+
+// clickHouseRow is a single row in ClickHouse's JSONEachRow format.
+type clickHouseRow struct {
+	Timestamp  string `json:"ts"`
+	Game       string `json:"game"`
+	Host       string `json:"host"`
+	ServerID   int64  `json:"server_id"`
+	Players    int16  `json:"players"`
+	MaxPlayers int16  `json:"max_players"`
+	Map        string `json:"map"`
+	Ping       int    `json:"ping"`
+	CycleID    string `json:"cycle_id"`
+}
+
+func newClickHouseExporter(baseURL, table string) (*clickHouseExporter, error) {
+	e := &clickHouseExporter{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		table:   table,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+	create := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	ts DateTime,
+	game LowCardinality(String),
+	host String,
+	server_id Int64,
+	players UInt16,
+	max_players UInt16,
+	map LowCardinality(String),
+	ping UInt32,
+	cycle_id String
+	) ENGINE = MergeTree PARTITION BY toYYYYMM(ts) ORDER BY (game, host, ts)`, table)
+	if err := e.exec(create, nil); err != nil {
+		return nil, logger.LogAppErrorf("Unable to create ClickHouse table %s: %s",
+			table, err)
+	}
+	// tables created before cycle IDs were exported
+	if err := e.exec(fmt.Sprintf(
+		"ALTER TABLE %s ADD COLUMN IF NOT EXISTS cycle_id String", table),
+		nil); err != nil {
+		return nil, logger.LogAppErrorf("Unable to add cycle_id to ClickHouse table %s: %s",
+			table, err)
+	}
+	return e, nil
+}
+
+// exec sends a query, with an optional body of data, to ClickHouse.
+func (e *clickHouseExporter) exec(query string, data io.Reader) error {
+	u := fmt.Sprintf("%s/?query=%s", e.baseURL, url.QueryEscape(query))
+	body := data
+	if body == nil {
+		body = strings.NewReader("")
+	}
+	resp, err := e.client.Post(u, "text/plain", body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("ClickHouse returned status %d: %s", resp.StatusCode,
+			strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func (e *clickHouseExporter) Export(game string, sl *models.APIServerList,
+	at time.Time) error {
+	defer observeDBQuery("timeseries", "Export", time.Now())
+	if len(sl.Servers) == 0 {
+		return nil
+	}
+	ts := at.UTC().Format("2006-01-02 15:04:05")
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, s := range sl.Servers {
+		if err := enc.Encode(clickHouseRow{
+			Timestamp:  ts,
+			Game:       game,
+			Host:       s.Host,
+			ServerID:   s.ID,
+			Players:    s.Info.Players,
+			MaxPlayers: s.Info.MaxPlayers,
+			Map:        s.Info.Map,
+			Ping:       s.Info.Ping,
+			CycleID:    sl.CycleID,
+		}); err != nil {
+			return logger.LogAppErrorf("Error encoding ClickHouse row: %s", err)
+		}
+	}
+	if err := e.exec(fmt.Sprintf("INSERT INTO %s FORMAT JSONEachRow", e.table),
+		&buf); err != nil {
+		return logger.LogAppErrorf("Error exporting %d servers to ClickHouse: %s",
+			len(sl.Servers), err)
+	}
+	return nil
+}
+
+func (e *clickHouseExporter) Close() {}
+
+// timescaleExporter writes to a TimescaleDB (PostgreSQL) hypertable.
+type timescaleExporter struct {
+	db    *sql.DB
+	table string
+}
+
+func newTimescaleExporter(dsn, table string) (*timescaleExporter, error) {
+	conn, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, logger.LogAppErrorf("Unable to open TimescaleDB connection: %s", err)
+	}
+	create := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	ts TIMESTAMPTZ NOT NULL,
+	game TEXT NOT NULL,
+	host TEXT NOT NULL,
+	server_id BIGINT NOT NULL,
+	players INTEGER NOT NULL,
+	max_players INTEGER NOT NULL,
+	map TEXT NOT NULL,
+	ping INTEGER NOT NULL,
+	cycle_id TEXT NOT NULL DEFAULT ''
+	)`, table)
+	if _, err := conn.Exec(create); err != nil {
+		conn.Close()
+		return nil, logger.LogAppErrorf("Unable to create TimescaleDB table %s: %s",
+			table, err)
+	}
+	// tables created before cycle IDs were exported
+	if _, err := conn.Exec(fmt.Sprintf(
+		"ALTER TABLE %s ADD COLUMN IF NOT EXISTS cycle_id TEXT NOT NULL DEFAULT ''",
+		table)); err != nil {
+		conn.Close()
+		return nil, logger.LogAppErrorf("Unable to add cycle_id to TimescaleDB table %s: %s",
+			table, err)
+	}
+	if _, err := conn.Exec("SELECT create_hypertable($1, 'ts', if_not_exists => TRUE)",
+		table); err != nil {
+		conn.Close()
+		return nil, logger.LogAppErrorf("Unable to create TimescaleDB hypertable %s: %s",
+			table, err)
+	}
+	return &timescaleExporter{db: conn, table: table}, nil
+}
+
+func (e *timescaleExporter) Export(game string, sl *models.APIServerList,
+	at time.Time) error {
+	defer observeDBQuery("timeseries", "Export", time.Now())
+	if len(sl.Servers) == 0 {
+		return nil
+	}
+	tx, err := e.db.Begin()
+	if err != nil {
+		return logger.LogAppErrorf("TimescaleDB export error creating tx: %s", err)
+	}
+	stmt, err := tx.Prepare(fmt.Sprintf(`INSERT INTO %s (ts, game, host, server_id,
+	players, max_players, map, ping, cycle_id)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`, e.table))
+	if err != nil {
+		tx.Rollback()
+		return logger.LogAppErrorf("TimescaleDB export error preparing statement: %s",
+			err)
+	}
+	defer stmt.Close()
+	for _, s := range sl.Servers {
+		if _, err := stmt.Exec(at, game, s.Host, s.ID, s.Info.Players,
+			s.Info.MaxPlayers, s.Info.Map, s.Info.Ping, sl.CycleID); err != nil {
+			tx.Rollback()
+			return logger.LogAppErrorf("TimescaleDB export error for host %s: %s",
+				s.Host, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return logger.LogAppErrorf("TimescaleDB export error committing tx: %s", err)
+	}
+	return nil
+}
+
+func (e *timescaleExporter) Close() {
+	if err := e.db.Close(); err != nil {
+		logger.LogAppErrorf("Error closing TimescaleDB connection: %s", err)
+	}
+}
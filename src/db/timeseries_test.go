@@ -0,0 +1,70 @@
+package db
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/syncore/a2sapi/src/models"
+)
+
+func TestClickHouseExporter(t *testing.T) {
+	var queries []string
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		queries = append(queries, r.URL.Query().Get("query"))
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+	}))
+	defer srv.Close()
+
+	e, err := NewTimeSeriesExporter("clickhouse", srv.URL, "snapshots")
+	if err != nil {
+		t.Fatalf("Error creating ClickHouse exporter: %s", err)
+	}
+	defer e.Close()
+	if len(queries) != 2 || !strings.HasPrefix(queries[0],
+		"CREATE TABLE IF NOT EXISTS snapshots") ||
+		!strings.Contains(queries[1], "ADD COLUMN IF NOT EXISTS cycle_id") {
+		t.Fatalf("Expected table creation queries, got: %v", queries)
+	}
+
+	sl := models.GetDefaultServerList()
+	sl.CycleID = "4d1c1f0e-5b5e-4a8e-9a4e-2f1f6c1e7d3a"
+	sl.Servers = append(sl.Servers, models.APIServer{ID: 7, Host: "10.0.0.1:27960",
+		Info: models.SteamServerInfo{Map: "bloodrun", Players: 3, MaxPlayers: 8,
+			Ping: 42}})
+	at := time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := e.Export("QuakeLive", sl, at); err != nil {
+		t.Fatalf("Error exporting to ClickHouse: %s", err)
+	}
+	if queries[2] != "INSERT INTO snapshots FORMAT JSONEachRow" {
+		t.Fatalf("Expected insert query, got: %s", queries[2])
+	}
+	row := clickHouseRow{}
+	if err := json.Unmarshal([]byte(body), &row); err != nil {
+		t.Fatalf("Unable to decode exported row: %s", err)
+	}
+	expected := clickHouseRow{Timestamp: "2016-01-02 03:04:05", Game: "QuakeLive",
+		Host: "10.0.0.1:27960", ServerID: 7, Players: 3, MaxPlayers: 8,
+		Map: "bloodrun", Ping: 42, CycleID: sl.CycleID}
+	if row != expected {
+		t.Fatalf("Expected exported row %+v, got: %+v", expected, row)
+	}
+}
+
+func TestTimeSeriesExporterValidation(t *testing.T) {
+	if _, err := NewTimeSeriesExporter("clickhouse", "http://localhost:8123",
+		"snapshots; DROP TABLE x"); err == nil {
+		t.Fatalf("Expected invalid table name to be rejected")
+	}
+	if _, err := NewTimeSeriesExporter("influx", "http://localhost:8086",
+		"snapshots"); err == nil {
+		t.Fatalf("Expected unknown exporter to be rejected")
+	}
+}
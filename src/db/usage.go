@@ -0,0 +1,74 @@
+package db
+
+// usage.go - Usage of the direct query quotas of API keys and anonymous
+// clients, stored in the application database.
+
+import (
+	"time"
+
+	"github.com/syncore/a2sapi/src/logger"
+)
+
+// UseQuota records a request by identity (an API key or an anonymous client's
+// IP) in the hour and day periods starting at the given times, unless the
+// request would exceed the hourly or daily limit (0 for no limit). It returns
+// the number of requests made in each period, including this request if it was
+// allowed, and whether it was allowed.
+func (adb *ADB) UseQuota(identity string, hour, day int64, hourLimit,
+	dayLimit int) (int, int, bool, error) {
+	defer observeDBQuery("app", "UseQuota", time.Now())
+	tx, err := adb.db.Begin()
+	if err != nil {
+		return 0, 0, false, logger.LogAppErrorf("UseQuota error creating tx: %s", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM api_usage WHERE identity =? AND
+	((period = 'hour' AND period_start <?) OR (period = 'day' AND period_start <?))`,
+		identity, hour, day); err != nil {
+		tx.Rollback()
+		return 0, 0, false, logger.LogAppErrorf("UseQuota error removing old usage: %s",
+			err)
+	}
+	var used [2]int
+	periods := []struct {
+		name  string
+		start int64
+		limit int
+	}{{"hour", hour, hourLimit}, {"day", day, dayLimit}}
+	allowed := true
+	for i, p := range periods {
+		if err := tx.QueryRow(`SELECT COALESCE(SUM(requests), 0) FROM api_usage
+		WHERE identity =? AND period =? AND period_start =?`, identity, p.name,
+			p.start).Scan(&used[i]); err != nil {
+			tx.Rollback()
+			return 0, 0, false, logger.LogAppErrorf("UseQuota error querying usage: %s",
+				err)
+		}
+		if p.limit > 0 && used[i] >= p.limit {
+			allowed = false
+		}
+	}
+	if !allowed {
+		tx.Rollback()
+		return used[0], used[1], false, nil
+	}
+	for i, p := range periods {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO api_usage (identity, period,
+		period_start) VALUES (?, ?, ?)`, identity, p.name, p.start); err != nil {
+			tx.Rollback()
+			return 0, 0, false, logger.LogAppErrorf("UseQuota error recording usage: %s",
+				err)
+		}
+		if _, err := tx.Exec(`UPDATE api_usage SET requests = requests + 1 WHERE
+		identity =? AND period =? AND period_start =?`, identity, p.name,
+			p.start); err != nil {
+			tx.Rollback()
+			return 0, 0, false, logger.LogAppErrorf("UseQuota error recording usage: %s",
+				err)
+		}
+		used[i]++
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, 0, false, logger.LogAppErrorf("UseQuota error committing tx: %s", err)
+	}
+	return used[0], used[1], true, nil
+}
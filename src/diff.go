@@ -0,0 +1,92 @@
+package main
+
+// diff.go - The diff command, which compares two server list files (i.e: the
+// per-game output files or server dumps) and prints the differences.
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/syncore/a2sapi/src/models"
+)
+
+const diffCommand = "diff"
+
+func readServerListFile(file string) (*models.APIServerList, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	sl := &models.APIServerList{}
+	if err := json.Unmarshal(b, sl); err != nil {
+		return nil, fmt.Errorf("%s is not a server list: %s", file, err)
+	}
+	return sl, nil
+}
+
+// runDiff runs the diff command with the given arguments and returns the exit
+// status: 0 if the lists are the same, 1 if they differ, and 2 on error.
+func runDiff(args []string) int {
+	fs := flag.NewFlagSet(diffCommand, flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "Print the differences as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s [--json] old.json new.json\n",
+			os.Args[0], diffCommand)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+	older, err := readServerListFile(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to read server list: %s\n", err)
+		return 2
+	}
+	newer, err := readServerListFile(fs.Arg(1))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to read server list: %s\n", err)
+		return 2
+	}
+	d := models.DiffServerLists(older, newer)
+
+	if *asJSON {
+		j, err := json.MarshalIndent(d, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error marshaling differences: %s\n", err)
+			return 2
+		}
+		fmt.Println(string(j))
+	} else {
+		printDiff(d)
+	}
+	if len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0 {
+		return 0
+	}
+	return 1
+}
+
+func printDiff(d *models.APIServerListDiff) {
+	for _, s := range d.Added {
+		fmt.Printf("+ %s (%s) %s [%s, %d/%d]\n", s.Host, s.Game, s.Info.Name,
+			s.Info.Map, s.Info.Players, s.Info.MaxPlayers)
+	}
+	for _, s := range d.Removed {
+		fmt.Printf("- %s (%s) %s [%s, %d/%d]\n", s.Host, s.Game, s.Info.Name,
+			s.Info.Map, s.Info.Players, s.Info.MaxPlayers)
+	}
+	for _, c := range d.Changed {
+		fmt.Printf("~ %s (%s)\n", c.Host, c.Game)
+		for _, f := range c.Fields {
+			fmt.Printf("    %s: %q -> %q\n", f.Field, f.Old, f.New)
+		}
+	}
+	fmt.Printf("%d added, %d removed, %d changed\n", len(d.Added), len(d.Removed),
+		len(d.Changed))
+}
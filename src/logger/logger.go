@@ -26,28 +26,19 @@ const (
 )
 
 func getLogPath(lt constants.LogType) string {
-	switch lt {
-	case constants.LTypeApp:
-		return constants.AppLogFilePath
-	case constants.LTypeSteam:
-		return constants.SteamLogFilePath
-	case constants.LTypeWeb:
-		return constants.WebLogFilePath
-	default:
-		return constants.AppLogFilePath
-	}
+	return path.Join(constants.LogDirectory, getLogFilenameFromType(lt))
 }
 
 func getLogFilenameFromType(lt constants.LogType) string {
 	switch lt {
 	case constants.LTypeApp:
-		return constants.AppLogFilename
+		return constants.ProfileFilename(constants.AppLogFilename)
 	case constants.LTypeSteam:
-		return constants.SteamLogFilename
+		return constants.ProfileFilename(constants.SteamLogFilename)
 	case constants.LTypeWeb:
-		return constants.WebLogFilename
+		return constants.ProfileFilename(constants.WebLogFilename)
 	default:
-		return constants.AppLogFilename
+		return constants.ProfileFilename(constants.AppLogFilename)
 	}
 }
 
@@ -69,7 +60,7 @@ func isMaxLogSizeExceeded(lt constants.LogType) bool {
 	if err != nil {
 		return false
 	}
-	return f.Size() > config.Config.LogConfig.MaximumLogSize*1024
+	return f.Size() > config.Get().LogConfig.MaximumLogSize*1024
 }
 
 func logDirNeedsCleaning(lt constants.LogType) bool {
@@ -90,7 +81,7 @@ func logDirNeedsCleaning(lt constants.LogType) bool {
 		}
 		logCount++
 	}
-	return logCount > config.Config.LogConfig.MaximumLogCount
+	return logCount > config.Get().LogConfig.MaximumLogCount
 }
 
 func getLogFiles(lt constants.LogType) ([]string, error) {
@@ -229,13 +220,13 @@ func verifyLogSettings(lt constants.LogType) error {
 
 func writeLogEntry(lt constants.LogType, loglevel logLevel, msg string,
 	text ...interface{}) error {
-	if lt == constants.LTypeApp && !config.Config.LogConfig.EnableAppLogging {
+	if lt == constants.LTypeApp && !config.Get().LogConfig.EnableAppLogging {
 		return nil
-	} else if lt == constants.LTypeDebug && !config.Config.DebugConfig.EnableDebugMessages {
+	} else if lt == constants.LTypeDebug && !config.Get().DebugConfig.EnableDebugMessages {
 		return nil
-	} else if lt == constants.LTypeSteam && !config.Config.LogConfig.EnableSteamLogging {
+	} else if lt == constants.LTypeSteam && !config.Get().LogConfig.EnableSteamLogging {
 		return nil
-	} else if lt == constants.LTypeWeb && !config.Config.LogConfig.EnableWebLogging {
+	} else if lt == constants.LTypeWeb && !config.Get().LogConfig.EnableWebLogging {
 		return nil
 	}
 
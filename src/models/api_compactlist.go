@@ -0,0 +1,78 @@
+package models
+
+// api_compactlist.go - Model for the minimal server list presented to
+// bandwidth-constrained clients (i.e: mobile server browsers)
+
+import (
+	"net"
+	"strconv"
+)
+
+// APICompactServerList represents a server detail list that has been reduced to
+// the minimal, flat, per-server information needed to display a server browser.
+type APICompactServerList struct {
+	CycleID            string             `json:"cycleID,omitempty"`
+	CycleIDs           map[string]string  `json:"cycleIDs,omitempty"`
+	RetrievedTimeStamp int64              `json:"timestamp"`
+	ServerCount        int                `json:"serverCount"`
+	Servers            []APICompactServer `json:"servers"`
+	TotalCount         int                `json:"totalCount,omitempty"`
+	NextCursor         string             `json:"nextCursor,omitempty"`
+	NextPage           string             `json:"nextPage,omitempty"`
+}
+
+// APICompactServer represents the minimal information for an individual server.
+type APICompactServer struct {
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	Map         string `json:"map"`
+	Players     int16  `json:"players"`
+	MaxPlayers  int16  `json:"maxPlayers"`
+	CountryCode string `json:"country"`
+	Ping        int    `json:"ping"`
+	Address     string `json:"connect"`
+	Status      string `json:"status,omitempty"`
+}
+
+// Compact returns the compact representation of the server list.
+func (sl *APIServerList) Compact() *APICompactServerList {
+	c := &APICompactServerList{
+		CycleID:            sl.CycleID,
+		CycleIDs:           sl.CycleIDs,
+		RetrievedTimeStamp: sl.RetrievedTimeStamp,
+		ServerCount:        len(sl.Servers),
+		TotalCount:         sl.TotalCount,
+		NextCursor:         sl.NextCursor,
+		NextPage:           sl.NextPage,
+		Servers:            make([]APICompactServer, 0, len(sl.Servers)),
+	}
+	for _, s := range sl.Servers {
+		name := s.Info.Name
+		if s.Alias != "" {
+			name = s.Alias
+		}
+		c.Servers = append(c.Servers, APICompactServer{
+			ID:          s.ID,
+			Name:        name,
+			Map:         s.Info.Map,
+			Players:     s.Info.Players,
+			MaxPlayers:  s.Info.MaxPlayers,
+			CountryCode: s.CountryInfo.CountryCode,
+			Ping:        s.Info.Ping,
+			Address:     s.ConnectAddress(),
+			Status:      s.Status,
+		})
+	}
+	return c
+}
+
+// ConnectAddress returns the address that game clients should connect to, which
+// is the game port reported by A2S_INFO if present; otherwise, the query address.
+func (s APIServer) ConnectAddress() string {
+	// ports above 32767 wrap around in the (signed) A2S_INFO model
+	port := uint16(s.Info.ExtraData.Port)
+	if port > 0 && s.IP != "" {
+		return net.JoinHostPort(s.IP, strconv.Itoa(int(port)))
+	}
+	return s.Host
+}
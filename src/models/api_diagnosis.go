@@ -0,0 +1,44 @@
+package models
+
+// api_diagnosis.go - Model for the diagnosis of a server that isn't answering
+// queries or isn't listed
+
+// APIDiagnosis represents the result of probing a host with each kind of A2S
+// request, along with the findings drawn from the probes.
+type APIDiagnosis struct {
+	Host   string `json:"host"`
+	Status string `json:"status"`
+	// whether the host is in the current server list, and the game it is
+	// listed for
+	Listed     bool             `json:"listed"`
+	ListedGame string           `json:"listedGame,omitempty"`
+	Info       *SteamServerInfo `json:"info,omitempty"`
+	// human-readable explanations, most important first
+	Findings []string            `json:"findings"`
+	Probes   []APIDiagnosisProbe `json:"probes"`
+}
+
+// APIDiagnosisProbe represents a single request made to diagnose a host.
+type APIDiagnosisProbe struct {
+	Name      string `json:"name"`
+	Host      string `json:"host"`
+	TimeoutMs int    `json:"timeoutMs"`
+	Responded bool   `json:"responded"`
+	// the server replied with a challenge (S2C_CHALLENGE) to A2S_INFO
+	Challenged bool   `json:"challenged,omitempty"`
+	Ping       int    `json:"ping,omitempty"`
+	Detail     string `json:"detail,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Diagnosis statuses.
+const (
+	// the server answered every request
+	DiagnosisOK = "ok"
+	// the server answered A2S_INFO, but not A2S_PLAYER or A2S_RULES
+	DiagnosisPartial = "partial"
+	// the server only answered A2S_INFO with the longer timeout
+	DiagnosisSlow = "slow"
+	// the server did not answer A2S_INFO
+	DiagnosisUnreachable = "unreachable"
+)
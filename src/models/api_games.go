@@ -0,0 +1,19 @@
+package models
+
+// api_games.go - Model for the list of games that the API knows about
+
+// APIGameList represents the games defined in the games file.
+type APIGameList struct {
+	GameCount int       `json:"gameCount"`
+	Games     []APIGame `json:"games"`
+}
+
+// APIGame represents a game, with the number of its servers and players in the
+// current server list and, if available, its details from Steam.
+type APIGame struct {
+	Name        string            `json:"name"`
+	AppID       uint64            `json:"appID"`
+	ServerCount int               `json:"serverCount"`
+	PlayerCount int               `json:"playerCount"`
+	Steam       *SteamAppMetadata `json:"steam,omitempty"`
+}
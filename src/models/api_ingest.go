@@ -0,0 +1,27 @@
+package models
+
+// api_ingest.go - Model for the state that game servers push to the API
+
+// APIIngestState represents the state that a game server pushes to the ingest
+// endpoint, in place of (or in addition to) its A2S responses.
+type APIIngestState struct {
+	Name       string            `json:"name"`
+	Map        string            `json:"map"`
+	GameDir    string            `json:"gameDir"`
+	Players    int               `json:"players"`
+	MaxPlayers int               `json:"maxPlayers"`
+	Bots       int               `json:"bots"`
+	Password   bool              `json:"password"`
+	VAC        bool              `json:"vac"`
+	Version    string            `json:"version"`
+	Keywords   string            `json:"keywords"`
+	PlayerList []APIIngestPlayer `json:"playerList"`
+	Rules      map[string]string `json:"rules"`
+}
+
+// APIIngestPlayer represents a player in the state that a game server pushes.
+type APIIngestPlayer struct {
+	Name          string  `json:"name"`
+	Score         int32   `json:"score"`
+	SecsConnected float32 `json:"secsConnected"`
+}
@@ -0,0 +1,389 @@
+package models
+
+// api_serverarena.go - Compact binary representation of server lists, used to
+// keep very large retrieved lists in memory as a few flat byte slices instead of
+// hundreds of thousands of small objects. Lists are decoded for each request.
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+)
+
+// serverArena is a packed server list. Fields are stored in data as varints, in
+// a fixed order; strings are stored once each, in strs, and referred to by their
+// index. Slices and maps are stored with their length + 1, with 0 for nil, so
+// that lists are decoded exactly as they were packed.
+type serverArena struct {
+	data []byte
+	strs []byte
+	// end offset in strs of each string
+	ends []uint32
+}
+
+type arenaWriter struct {
+	a       *serverArena
+	strings map[string]uint64
+	scratch [binary.MaxVarintLen64]byte
+}
+
+func (w *arenaWriter) uint(v uint64) {
+	n := binary.PutUvarint(w.scratch[:], v)
+	w.a.data = append(w.a.data, w.scratch[:n]...)
+}
+
+func (w *arenaWriter) int(v int64) {
+	n := binary.PutVarint(w.scratch[:], v)
+	w.a.data = append(w.a.data, w.scratch[:n]...)
+}
+
+func (w *arenaWriter) bool(v bool) {
+	if v {
+		w.uint(1)
+	} else {
+		w.uint(0)
+	}
+}
+
+func (w *arenaWriter) str(s string) {
+	i, ok := w.strings[s]
+	if !ok {
+		i = uint64(len(w.a.ends))
+		w.a.strs = append(w.a.strs, s...)
+		w.a.ends = append(w.a.ends, uint32(len(w.a.strs)))
+		w.strings[s] = i
+	}
+	w.uint(i)
+}
+
+// len writes the length of a slice or map; 0 for nil.
+func (w *arenaWriter) len(n int, isNil bool) {
+	if isNil {
+		w.uint(0)
+	} else {
+		w.uint(uint64(n) + 1)
+	}
+}
+
+func (w *arenaWriter) strSlice(s []string) {
+	w.len(len(s), s == nil)
+	for _, v := range s {
+		w.str(v)
+	}
+}
+
+func (w *arenaWriter) strMap(m map[string]string) {
+	w.len(len(m), m == nil)
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		w.str(k)
+		w.str(m[k])
+	}
+}
+
+func (w *arenaWriter) players(p []SteamPlayerInfo) {
+	w.len(len(p), p == nil)
+	for _, v := range p {
+		w.str(v.Name)
+		w.str(v.CleanName)
+		w.int(int64(v.Score))
+		w.uint(uint64(math.Float32bits(v.TimeConnectedSecs)))
+		w.str(v.TimeConnectedTot)
+		w.int(v.TimeConnectedRaw)
+		w.str(v.TimeConnectedISO)
+		w.str(v.TimeConnectedLong)
+		w.strMap(v.Extra)
+	}
+}
+
+func (w *arenaWriter) info(i SteamServerInfo) {
+	w.int(int64(i.Protocol))
+	w.str(i.Name)
+	w.str(i.Map)
+	w.str(i.Folder)
+	w.str(i.Game)
+	w.str(i.GameTypeShort)
+	w.str(i.GameTypeFull)
+	w.int(int64(i.ID))
+	w.int(int64(i.Players))
+	w.int(int64(i.MaxPlayers))
+	w.int(int64(i.Bots))
+	w.str(i.ServerType)
+	w.str(i.Environment)
+	w.int(int64(i.Visibility))
+	w.int(int64(i.VAC))
+	w.str(i.Version)
+	w.int(int64(i.Ping))
+	w.int(int64(i.ExtraData.Port))
+	w.uint(i.ExtraData.SteamID)
+	w.int(int64(i.ExtraData.SourceTVPort))
+	w.str(i.ExtraData.SourceTVName)
+	w.str(i.ExtraData.Keywords)
+	w.uint(i.ExtraData.GameID)
+}
+
+func (w *arenaWriter) server(s *APIServer) {
+	w.int(s.ID)
+	w.str(s.Alias)
+	w.str(s.Host)
+	w.str(s.Game)
+	w.str(s.IP)
+	w.int(int64(s.Port))
+	w.str(s.CountryInfo.CountryName)
+	w.str(s.CountryInfo.CountryCode)
+	w.str(s.CountryInfo.Continent)
+	w.str(s.CountryInfo.State)
+	w.str(s.CountryInfo.FlagEmoji)
+	w.str(s.CountryInfo.FlagURL)
+	w.str(s.CountryInfo.City)
+	w.uint(math.Float64bits(s.CountryInfo.Latitude))
+	w.uint(math.Float64bits(s.CountryInfo.Longitude))
+	w.uint(uint64(s.CountryInfo.ASN))
+	w.str(s.CountryInfo.ASNOrg)
+	w.info(s.Info)
+	w.players(s.Players)
+	w.int(int64(s.FilteredPlayers.FilteredPlayerCount))
+	w.players(s.FilteredPlayers.FilteredPlayers)
+	w.strMap(s.Rules)
+	w.strSlice(s.Tags)
+	w.int(int64(s.FreeSlots))
+	w.bool(s.PasswordProtected)
+	if s.Spectators != nil {
+		w.int(int64(*s.Spectators))
+	} else {
+		w.int(-1)
+	}
+	w.strSlice(s.ParseWarnings)
+	w.strSlice(s.PartialFields)
+	w.int(s.RefreshedTimeStamp)
+	w.bool(s.Pushed)
+	w.str(s.Status)
+	w.int(int64(s.ConsecutiveFailures))
+	w.int(s.LastSeenOnline)
+}
+
+// packServerList returns the packed form of a server list.
+func packServerList(sl *APIServerList) *serverArena {
+	w := &arenaWriter{a: &serverArena{}, strings: make(map[string]uint64)}
+	w.str(sl.CycleID)
+	w.strMap(sl.CycleIDs)
+	w.str(sl.RetrievedAt)
+	w.int(sl.RetrievedTimeStamp)
+	w.int(int64(sl.ServerCount))
+	w.len(len(sl.Servers), sl.Servers == nil)
+	for i := range sl.Servers {
+		w.server(&sl.Servers[i])
+	}
+	w.int(int64(sl.FailedCount))
+	w.strSlice(sl.FailedServers)
+	w.len(len(sl.OfflineServers), sl.OfflineServers == nil)
+	for _, o := range sl.OfflineServers {
+		w.str(o.Status)
+		w.int(o.ID)
+		w.str(o.Host)
+		w.str(o.Game)
+		w.int(int64(o.ConsecutiveFailures))
+		w.int(o.LastSeenOnline)
+	}
+	w.int(int64(sl.TotalCount))
+	w.str(sl.NextCursor)
+	w.str(sl.NextPage)
+	w.bool(sl.WarmUp)
+	w.bool(sl.PartialMasterList)
+	// the packed list is kept for a long time, so don't hold on to spare capacity
+	a := w.a
+	a.data = append([]byte(nil), a.data...)
+	a.strs = append([]byte(nil), a.strs...)
+	a.ends = append([]uint32(nil), a.ends...)
+	return a
+}
+
+type arenaReader struct {
+	a   *serverArena
+	pos int
+}
+
+func (r *arenaReader) uint() uint64 {
+	v, n := binary.Uvarint(r.a.data[r.pos:])
+	r.pos += n
+	return v
+}
+
+func (r *arenaReader) int() int64 {
+	v, n := binary.Varint(r.a.data[r.pos:])
+	r.pos += n
+	return v
+}
+
+func (r *arenaReader) bool() bool {
+	return r.uint() != 0
+}
+
+func (r *arenaReader) str() string {
+	i := r.uint()
+	var start uint32
+	if i > 0 {
+		start = r.a.ends[i-1]
+	}
+	return string(r.a.strs[start:r.a.ends[i]])
+}
+
+// len reads the length of a slice or map, returning false for nil.
+func (r *arenaReader) len() (int, bool) {
+	n := r.uint()
+	if n == 0 {
+		return 0, false
+	}
+	return int(n - 1), true
+}
+
+func (r *arenaReader) strSlice() []string {
+	n, ok := r.len()
+	if !ok {
+		return nil
+	}
+	s := make([]string, n)
+	for i := range s {
+		s[i] = r.str()
+	}
+	return s
+}
+
+func (r *arenaReader) strMap() map[string]string {
+	n, ok := r.len()
+	if !ok {
+		return nil
+	}
+	m := make(map[string]string, n)
+	for i := 0; i < n; i++ {
+		k := r.str()
+		m[k] = r.str()
+	}
+	return m
+}
+
+func (r *arenaReader) players() []SteamPlayerInfo {
+	n, ok := r.len()
+	if !ok {
+		return nil
+	}
+	p := make([]SteamPlayerInfo, n)
+	for i := range p {
+		p[i].Name = r.str()
+		p[i].CleanName = r.str()
+		p[i].Score = int32(r.int())
+		p[i].TimeConnectedSecs = math.Float32frombits(uint32(r.uint()))
+		p[i].TimeConnectedTot = r.str()
+		p[i].TimeConnectedRaw = r.int()
+		p[i].TimeConnectedISO = r.str()
+		p[i].TimeConnectedLong = r.str()
+		p[i].Extra = r.strMap()
+	}
+	return p
+}
+
+func (r *arenaReader) info(i *SteamServerInfo) {
+	i.Protocol = int(r.int())
+	i.Name = r.str()
+	i.Map = r.str()
+	i.Folder = r.str()
+	i.Game = r.str()
+	i.GameTypeShort = r.str()
+	i.GameTypeFull = r.str()
+	i.ID = int16(r.int())
+	i.Players = int16(r.int())
+	i.MaxPlayers = int16(r.int())
+	i.Bots = int16(r.int())
+	i.ServerType = r.str()
+	i.Environment = r.str()
+	i.Visibility = int16(r.int())
+	i.VAC = int16(r.int())
+	i.Version = r.str()
+	i.Ping = int(r.int())
+	i.ExtraData.Port = int16(r.int())
+	i.ExtraData.SteamID = r.uint()
+	i.ExtraData.SourceTVPort = int16(r.int())
+	i.ExtraData.SourceTVName = r.str()
+	i.ExtraData.Keywords = r.str()
+	i.ExtraData.GameID = r.uint()
+}
+
+func (r *arenaReader) server(s *APIServer) {
+	s.ID = r.int()
+	s.Alias = r.str()
+	s.Host = r.str()
+	s.Game = r.str()
+	s.IP = r.str()
+	s.Port = int(r.int())
+	s.CountryInfo.CountryName = r.str()
+	s.CountryInfo.CountryCode = r.str()
+	s.CountryInfo.Continent = r.str()
+	s.CountryInfo.State = r.str()
+	s.CountryInfo.FlagEmoji = r.str()
+	s.CountryInfo.FlagURL = r.str()
+	s.CountryInfo.City = r.str()
+	s.CountryInfo.Latitude = math.Float64frombits(r.uint())
+	s.CountryInfo.Longitude = math.Float64frombits(r.uint())
+	s.CountryInfo.ASN = uint(r.uint())
+	s.CountryInfo.ASNOrg = r.str()
+	r.info(&s.Info)
+	s.Players = r.players()
+	s.FilteredPlayers.FilteredPlayerCount = int(r.int())
+	s.FilteredPlayers.FilteredPlayers = r.players()
+	s.Rules = r.strMap()
+	s.Tags = r.strSlice()
+	s.FreeSlots = int(r.int())
+	s.PasswordProtected = r.bool()
+	if n := int(r.int()); n >= 0 {
+		s.Spectators = &n
+	}
+	s.ParseWarnings = r.strSlice()
+	s.PartialFields = r.strSlice()
+	s.RefreshedTimeStamp = r.int()
+	s.Pushed = r.bool()
+	s.Status = r.str()
+	s.ConsecutiveFailures = int(r.int())
+	s.LastSeenOnline = r.int()
+}
+
+// unpack decodes the packed server list. Each call returns a new list, which the
+// caller is free to modify.
+func (a *serverArena) unpack() *APIServerList {
+	r := &arenaReader{a: a}
+	sl := &APIServerList{}
+	sl.CycleID = r.str()
+	sl.CycleIDs = r.strMap()
+	sl.RetrievedAt = r.str()
+	sl.RetrievedTimeStamp = r.int()
+	sl.ServerCount = int(r.int())
+	if n, ok := r.len(); ok {
+		sl.Servers = make([]APIServer, n)
+		for i := range sl.Servers {
+			r.server(&sl.Servers[i])
+		}
+	}
+	sl.FailedCount = int(r.int())
+	sl.FailedServers = r.strSlice()
+	if n, ok := r.len(); ok {
+		sl.OfflineServers = make([]APIOfflineServer, n)
+		for i := range sl.OfflineServers {
+			o := &sl.OfflineServers[i]
+			o.Status = r.str()
+			o.ID = r.int()
+			o.Host = r.str()
+			o.Game = r.str()
+			o.ConsecutiveFailures = int(r.int())
+			o.LastSeenOnline = r.int()
+		}
+	}
+	sl.TotalCount = int(r.int())
+	sl.NextCursor = r.str()
+	sl.NextPage = r.str()
+	sl.WarmUp = r.bool()
+	sl.PartialMasterList = r.bool()
+	return sl
+}
@@ -0,0 +1,34 @@
+package models
+
+// api_servercount.go - Model for the counts of servers matching a server list
+// request, for clients (i.e: widgets) that only display numbers
+
+// APIServerCount represents the number of servers, and of the players on them,
+// that match a server list request, without the servers themselves.
+type APIServerCount struct {
+	CycleID            string            `json:"cycleID,omitempty"`
+	CycleIDs           map[string]string `json:"cycleIDs,omitempty"`
+	RetrievedAt        string            `json:"retrievalDate"`
+	RetrievedTimeStamp int64             `json:"timestamp"`
+	ServerCount        int               `json:"serverCount"`
+	PlayerCount        int               `json:"playerCount"`
+	BotCount           int               `json:"botCount"`
+	MaxPlayerCount     int               `json:"maxPlayerCount"`
+}
+
+// Counts returns the server and player counts of the server list.
+func (sl *APIServerList) Counts() *APIServerCount {
+	c := &APIServerCount{
+		CycleID:            sl.CycleID,
+		CycleIDs:           sl.CycleIDs,
+		RetrievedAt:        sl.RetrievedAt,
+		RetrievedTimeStamp: sl.RetrievedTimeStamp,
+		ServerCount:        len(sl.Servers),
+	}
+	for _, s := range sl.Servers {
+		c.PlayerCount += int(s.Info.Players)
+		c.BotCount += int(s.Info.Bots)
+		c.MaxPlayerCount += int(s.Info.MaxPlayers)
+	}
+	return c
+}
@@ -0,0 +1,121 @@
+package models
+
+// api_serverdiff.go - Model for the differences between two server lists, i.e:
+// two retrievals of the same game's servers
+
+import (
+	"sort"
+	"strconv"
+)
+
+// APIServerListDiff represents the servers that were added to, removed from,
+// or changed between two server lists.
+type APIServerListDiff struct {
+	Added   []APIServer       `json:"added"`
+	Removed []APIServer       `json:"removed"`
+	Changed []APIServerChange `json:"changed"`
+}
+
+// APIServerChange represents the changed fields of a server that is in both of
+// two server lists.
+type APIServerChange struct {
+	ID     int64            `json:"serverID"`
+	Host   string           `json:"address"`
+	Game   string           `json:"game"`
+	Fields []APIFieldChange `json:"fields"`
+}
+
+// APIFieldChange represents the old and new values of a changed server field.
+// Changed rules are named rules.<key>.
+type APIFieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+func diffKey(s APIServer) string {
+	return s.Host + "|" + s.Game
+}
+
+// DiffServerLists returns the servers that were added, removed, or changed in
+// the newer of two server lists. Servers are matched by address and game, and
+// ping is not compared. The results are sorted by address.
+func DiffServerLists(older, newer *APIServerList) *APIServerListDiff {
+	d := &APIServerListDiff{
+		Added:   make([]APIServer, 0),
+		Removed: make([]APIServer, 0),
+		Changed: make([]APIServerChange, 0),
+	}
+	prev := make(map[string]APIServer)
+	next := make(map[string]APIServer)
+	var keys []string
+	if older != nil {
+		for _, s := range older.Servers {
+			if _, ok := prev[diffKey(s)]; !ok {
+				keys = append(keys, diffKey(s))
+			}
+			prev[diffKey(s)] = s
+		}
+	}
+	if newer != nil {
+		for _, s := range newer.Servers {
+			_, inOld := prev[diffKey(s)]
+			if _, ok := next[diffKey(s)]; !ok && !inOld {
+				keys = append(keys, diffKey(s))
+			}
+			next[diffKey(s)] = s
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		o, inOld := prev[k]
+		n, inNew := next[k]
+		switch {
+		case !inNew:
+			d.Removed = append(d.Removed, o)
+		case !inOld:
+			d.Added = append(d.Added, n)
+		default:
+			if fields := diffServer(o, n); len(fields) > 0 {
+				d.Changed = append(d.Changed, APIServerChange{ID: n.ID, Host: n.Host,
+					Game: n.Game, Fields: fields})
+			}
+		}
+	}
+	return d
+}
+
+// diffServer returns the fields that differ between two versions of a server.
+func diffServer(o, n APIServer) []APIFieldChange {
+	var fields []APIFieldChange
+	add := func(field, ov, nv string) {
+		if ov != nv {
+			fields = append(fields, APIFieldChange{Field: field, Old: ov, New: nv})
+		}
+	}
+	itoa := func(i int16) string { return strconv.Itoa(int(i)) }
+	add("alias", o.Alias, n.Alias)
+	add("info.serverName", o.Info.Name, n.Info.Name)
+	add("info.map", o.Info.Map, n.Info.Map)
+	add("info.gameTypeShort", o.Info.GameTypeShort, n.Info.GameTypeShort)
+	add("info.players", itoa(o.Info.Players), itoa(n.Info.Players))
+	add("info.maxPlayers", itoa(o.Info.MaxPlayers), itoa(n.Info.MaxPlayers))
+	add("info.bots", itoa(o.Info.Bots), itoa(n.Info.Bots))
+	add("info.serverVersion", o.Info.Version, n.Info.Version)
+	add("status", o.Status, n.Status)
+
+	keys := make([]string, 0, len(o.Rules)+len(n.Rules))
+	for k := range o.Rules {
+		keys = append(keys, k)
+	}
+	for k := range n.Rules {
+		if _, ok := o.Rules[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		add("rules."+k, o.Rules[k], n.Rules[k])
+	}
+	return fields
+}
@@ -0,0 +1,218 @@
+package models
+
+// api_serverexport.go - Server list formats for server browsers, launchers,
+// and spreadsheets that don't read JSON: plain address lists, qstat-compatible
+// XML and raw output, and CSV
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// csvColumns are the columns of the CSV server list, named as the JSON fields.
+var csvColumns = []string{"serverID", "alias", "address", "game", "status",
+	"name", "map", "gametype", "players", "maxPlayers", "bots", "freeSlots",
+	"spectators", "passwordProtected", "antiCheat", "serverType", "os",
+	"version", "ping", "country", "region", "tags"}
+
+// qstatServer represents a server in qstat's XML output (qstat -xml).
+type qstatServer struct {
+	XMLName       xml.Name      `xml:"server"`
+	Type          string        `xml:"type,attr"`
+	Address       string        `xml:"address,attr"`
+	Status        string        `xml:"status,attr"`
+	Hostname      string        `xml:"hostname"`
+	Name          string        `xml:"name"`
+	GameType      string        `xml:"gametype"`
+	Map           string        `xml:"map"`
+	NumPlayers    int16         `xml:"numplayers"`
+	MaxPlayers    int16         `xml:"maxplayers"`
+	NumSpectators int           `xml:"numspectators"`
+	MaxSpectators int           `xml:"maxspectators"`
+	Ping          int           `xml:"ping"`
+	Retries       int           `xml:"retries"`
+	Rules         []qstatRule   `xml:"rules>rule"`
+	Players       []qstatPlayer `xml:"players>player"`
+}
+
+type qstatRule struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:",chardata"`
+}
+
+type qstatPlayer struct {
+	Name  string `xml:"name"`
+	Score int32  `xml:"score"`
+	Time  string `xml:"time"`
+}
+
+// HostList returns the addresses (ip:port) of the servers in the list, one per
+// line. Offline and timed out servers are left out.
+func (sl *APIServerList) HostList() []byte {
+	var b bytes.Buffer
+	for _, s := range sl.Servers {
+		if s.Status == ServerStatusOffline || s.Status == ServerStatusTimedOut {
+			continue
+		}
+		b.WriteString(s.Host)
+		b.WriteByte('\n')
+	}
+	return b.Bytes()
+}
+
+// CSV returns the servers in the list as CSV, for spreadsheets: a header row
+// followed by a row per server with its ID, address, location, and the main
+// fields of its A2S_INFO. Servers with an unknown spectator count have an empty
+// spectators column.
+func (sl *APIServerList) CSV() ([]byte, error) {
+	var b bytes.Buffer
+	cw := csv.NewWriter(&b)
+	if err := cw.Write(csvColumns); err != nil {
+		return nil, err
+	}
+	for _, s := range sl.Servers {
+		spectators := ""
+		if s.Spectators != nil {
+			spectators = strconv.Itoa(*s.Spectators)
+		}
+		if err := cw.Write([]string{
+			strconv.FormatInt(s.ID, 10),
+			csvText(s.Alias),
+			s.Host,
+			s.Game,
+			s.Status,
+			csvText(s.Info.Name),
+			csvText(s.Info.Map),
+			csvText(s.Info.GameTypeShort),
+			strconv.Itoa(int(s.Info.Players)),
+			strconv.Itoa(int(s.Info.MaxPlayers)),
+			strconv.Itoa(int(s.Info.Bots)),
+			strconv.Itoa(s.FreeSlots),
+			spectators,
+			strconv.FormatBool(s.PasswordProtected),
+			strconv.FormatBool(s.Info.VAC == 1),
+			s.Info.ServerType,
+			s.Info.Environment,
+			csvText(s.Info.Version),
+			strconv.Itoa(s.Info.Ping),
+			s.CountryInfo.CountryCode,
+			s.CountryInfo.Continent,
+			csvText(strings.Join(s.Tags, ",")),
+		}); err != nil {
+			return nil, err
+		}
+	}
+	cw.Flush()
+	return b.Bytes(), cw.Error()
+}
+
+// csvText returns a server-controlled value for a CSV cell. Values that
+// spreadsheets would read as formulas (i.e. a server named =HYPERLINK(...)) are
+// prefixed with a quote, so that they are shown as text instead.
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// qstatStatus returns the status of a server as qstat reports it.
+func qstatStatus(s APIServer) string {
+	switch s.Status {
+	case ServerStatusOffline:
+		return "DOWN"
+	case ServerStatusTimedOut:
+		return "TIMEOUT"
+	}
+	return "UP"
+}
+
+// sortedRuleKeys returns the names of the server's rules in order.
+func sortedRuleKeys(s APIServer) []string {
+	keys := make([]string, 0, len(s.Rules))
+	for k := range s.Rules {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// QStatRaw returns the servers in the list in the format that qstat outputs
+// with -raw delim: a line per server with its type, address, name, map, maximum
+// players, players, ping, and retries; followed, if requested, by a line with
+// its rules (as name=value) and a line per player with their name, score, and
+// time connected. Servers are separated by a blank line when rules or players
+// are included. Servers that are not up have only their type, address, and
+// status.
+func (sl *APIServerList) QStatRaw(delim string, rules, players bool) []byte {
+	var b bytes.Buffer
+	for i, s := range sl.Servers {
+		if i > 0 && (rules || players) {
+			b.WriteByte('\n')
+		}
+		if status := qstatStatus(s); status != "UP" {
+			fmt.Fprintf(&b, "A2S%s%s%s%s\n", delim, s.Host, delim, status)
+			continue
+		}
+		fmt.Fprintf(&b, "A2S%s%s%s%s%s%s%s%d%s%d%s%d%s0\n", delim, s.Host, delim,
+			s.Info.Name, delim, s.Info.Map, delim, s.Info.MaxPlayers, delim,
+			s.Info.Players, delim, s.Info.Ping, delim)
+		if rules {
+			for j, k := range sortedRuleKeys(s) {
+				if j > 0 {
+					b.WriteString(delim)
+				}
+				fmt.Fprintf(&b, "%s=%s", k, s.Rules[k])
+			}
+			b.WriteByte('\n')
+		}
+		if players {
+			for _, p := range s.Players {
+				fmt.Fprintf(&b, "%s%s%d%s%ds\n", p.Name, delim, p.Score, delim,
+					p.TimeConnectedRaw)
+			}
+		}
+	}
+	return b.Bytes()
+}
+
+// QStatXML returns the servers in the list in the XML format that qstat
+// outputs with -xml, for tools that read qstat's output.
+func (sl *APIServerList) QStatXML() ([]byte, error) {
+	servers := make([]qstatServer, 0, len(sl.Servers))
+	for _, s := range sl.Servers {
+		qs := qstatServer{
+			Type:       "A2S",
+			Address:    s.Host,
+			Status:     qstatStatus(s),
+			Hostname:   s.Host,
+			Name:       s.Info.Name,
+			GameType:   s.Info.Folder,
+			Map:        s.Info.Map,
+			NumPlayers: s.Info.Players,
+			MaxPlayers: s.Info.MaxPlayers,
+			Ping:       s.Info.Ping,
+		}
+		for _, k := range sortedRuleKeys(s) {
+			qs.Rules = append(qs.Rules, qstatRule{Name: k, Value: s.Rules[k]})
+		}
+		for _, p := range s.Players {
+			qs.Players = append(qs.Players, qstatPlayer{Name: p.Name,
+				Score: p.Score, Time: strconv.FormatInt(p.TimeConnectedRaw, 10) + "s"})
+		}
+		servers = append(servers, qs)
+	}
+	out, err := xml.MarshalIndent(struct {
+		XMLName xml.Name      `xml:"qstat"`
+		Servers []qstatServer `xml:"server"`
+	}{Servers: servers}, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(out, '\n')...), nil
+}
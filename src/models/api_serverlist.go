@@ -2,24 +2,50 @@ package models
 
 // api_serverlist.go - Model for building list of server details
 
-import "time"
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
 
 // APIServerList represents the server detail list returned in response to
 // building the master list or in response to building the list of server details
 // via a user's API request.
 type APIServerList struct {
-	RetrievedAt        string      `json:"retrievalDate"`
-	RetrievedTimeStamp int64       `json:"timestamp"`
-	ServerCount        int         `json:"serverCount"`
-	Servers            []APIServer `json:"servers"`
-	FailedCount        int         `json:"failedCount"`
-	FailedServers      []string    `json:"failedServers"`
+	// unique ID of the retrieval cycle that produced the list; lists combined from
+	// several games' retrievals have each game's cycle ID in CycleIDs instead
+	CycleID            string            `json:"cycleID,omitempty"`
+	CycleIDs           map[string]string `json:"cycleIDs,omitempty"`
+	RetrievedAt        string            `json:"retrievalDate"`
+	RetrievedTimeStamp int64             `json:"timestamp"`
+	ServerCount        int               `json:"serverCount"`
+	Servers            []APIServer       `json:"servers"`
+	FailedCount        int               `json:"failedCount"`
+	FailedServers      []string          `json:"failedServers"`
+	// known servers that failed or were no longer listed during the retrieval;
+	// only included (as offline servers) in responses if requested
+	OfflineServers []APIOfflineServer `json:"offlineServers,omitempty"`
+	// set on paginated responses: the number of servers matching the request
+	// across all pages, and the cursor and link of the next page, if any
+	TotalCount int    `json:"totalCount,omitempty"`
+	NextCursor string `json:"nextCursor,omitempty"`
+	NextPage   string `json:"nextPage,omitempty"`
+	// true if the list is from the reduced warm-up retrieval made at startup and
+	// may not contain all servers yet
+	WarmUp bool `json:"warmUp,omitempty"`
+	// true if the master server stopped responding before the end of its list, so
+	// that the list may not contain all servers
+	PartialMasterList bool `json:"partialMasterList,omitempty"`
 }
 
 // APIServer represents an individual game server's information, including its
 // A2S information as well as its geographical data. if available.
 type APIServer struct {
 	ID              int64              `json:"serverID"`
+	Alias           string             `json:"alias,omitempty"`
 	Host            string             `json:"address"`
 	Game            string             `json:"game"`
 	IP              string             `json:"ip"`
@@ -29,12 +55,236 @@ type APIServer struct {
 	Players         []SteamPlayerInfo  `json:"players"`
 	FilteredPlayers FilteredPlayerInfo `json:"filteredPlayers"`
 	Rules           map[string]string  `json:"rules"`
+	// the server's keywords, merged with its sv_tags rule for games that
+	// truncate their keywords
+	Tags []string `json:"tags"`
+	// derived from the server's info: its slots not taken by human players, and
+	// whether it is password protected
+	FreeSlots         int  `json:"freeSlots"`
+	PasswordProtected bool `json:"passwordProtected"`
+	// the number of spectators, for games that expose it (i.e: Quake Live team
+	// gametypes); omitted when unknown
+	Spectators *int `json:"spectators,omitempty"`
+	// set when lenient parsing salvaged a nonconforming server's A2S responses
+	ParseWarnings []string `json:"parseWarnings,omitempty"`
+	PartialFields []string `json:"partialFields,omitempty"`
+	// set when stale data was re-queried at API time after the list was retrieved
+	RefreshedTimeStamp int64 `json:"refreshedTimestamp,omitempty"`
+	// set when some of the server's data was pushed by the server itself rather
+	// than retrieved with A2S queries
+	Pushed bool `json:"pushed,omitempty"`
+	// one of the ServerStatus values; offline and timed out servers (only present
+	// when requested) also have their failure count and when last seen online
+	Status              string `json:"status"`
+	ConsecutiveFailures int    `json:"consecutiveFailures,omitempty"`
+	LastSeenOnline      int64  `json:"lastSeenOnline,omitempty"`
+}
+
+// Server statuses.
+const (
+	// all of the server's A2S data was retrieved
+	ServerStatusOnline = "online"
+	// some of the server's A2S data is missing or could only be partially parsed
+	ServerStatusPartial = "partial"
+	// the server's data is older than the configured stale server age
+	ServerStatusStale = "stale"
+	// the server did not respond during the last retrieval
+	ServerStatusTimedOut = "timed_out"
+	// the server is no longer listed by the master server
+	ServerStatusOffline = "offline"
+)
+
+// APIOfflineServer represents a known server (one with a server ID) that failed
+// to respond during a retrieval or was no longer listed, along with how long it
+// has been offline.
+type APIOfflineServer struct {
+	// ServerStatusTimedOut or ServerStatusOffline
+	Status              string `json:"status"`
+	ID                  int64  `json:"serverID"`
+	Host                string `json:"address"`
+	Game                string `json:"game"`
+	ConsecutiveFailures int    `json:"consecutiveFailures"`
+	// unix timestamp; 0 if the server has never been seen online
+	LastSeenOnline int64 `json:"lastSeenOnline"`
 }
 
 // MasterList represents the list of all servers returned from the master server
 // and directly exposed to the user via queries if timed auto queries are enabled.
+// It is not kept when the game lists are compact; use GetMasterList instead.
 var MasterList *APIServerList
 
+var (
+	gameListsMu sync.RWMutex
+	gameLists   = make(map[string]*APIServerList)
+	// the packed lists of each game, used instead of gameLists when compact
+	gameArenas   = make(map[string]*serverArena)
+	compactLists bool
+	// channels that are sent the name of each game whose list is replaced
+	gameListSubs = make(map[chan string]bool)
+)
+
+// gameListSubBuffer is the number of pending updates a subscriber can have before
+// further updates are dropped.
+const gameListSubBuffer = 16
+
+// SubscribeGameLists returns a channel that is sent the name of each game whose
+// server list is replaced (or removed) from then on. Updates are dropped for
+// subscribers that fall too far behind. The channel must be passed to
+// UnsubscribeGameLists when no longer needed.
+func SubscribeGameLists() chan string {
+	ch := make(chan string, gameListSubBuffer)
+	gameListsMu.Lock()
+	gameListSubs[ch] = true
+	gameListsMu.Unlock()
+	return ch
+}
+
+// UnsubscribeGameLists stops sending updates to a channel returned by
+// SubscribeGameLists.
+func UnsubscribeGameLists(ch chan string) {
+	gameListsMu.Lock()
+	delete(gameListSubs, ch)
+	gameListsMu.Unlock()
+}
+
+// SetCompactGameLists sets whether the game lists are stored packed in a compact
+// binary form and decoded for each use, which greatly reduces the memory used by
+// very large lists at the cost of decoding them for each request. It must be set
+// before any lists are stored.
+func SetCompactGameLists(compact bool) {
+	gameListsMu.Lock()
+	compactLists = compact
+	gameListsMu.Unlock()
+}
+
+// SetGameList stores the server list retrieved for a game in that game's own
+// cache, and rebuilds MasterList from the lists of all retrieved games. A nil
+// list removes the game's cached list.
+func SetGameList(game string, sl *APIServerList) {
+	gameListsMu.RLock()
+	compact := compactLists
+	gameListsMu.RUnlock()
+	// pack outside of the lock; it takes a while for large lists
+	var packed *serverArena
+	if compact && sl != nil {
+		packed = packServerList(sl)
+	}
+	gameListsMu.Lock()
+	defer gameListsMu.Unlock()
+	key := strings.ToLower(game)
+	switch {
+	case sl == nil:
+		delete(gameLists, key)
+		delete(gameArenas, key)
+	case packed != nil:
+		gameArenas[key] = packed
+	default:
+		gameLists[key] = sl
+	}
+	if !compactLists {
+		MasterList = CombineGameLists(gameLists)
+	}
+	for ch := range gameListSubs {
+		select {
+		case ch <- game:
+		default:
+		}
+	}
+}
+
+// GetGameList returns the cached server list for a game, or nil if the game has
+// not been retrieved.
+func GetGameList(game string) *APIServerList {
+	gameListsMu.RLock()
+	defer gameListsMu.RUnlock()
+	if compactLists {
+		if a := gameArenas[strings.ToLower(game)]; a != nil {
+			return a.unpack()
+		}
+		return nil
+	}
+	return gameLists[strings.ToLower(game)]
+}
+
+// GameLists returns the cached server lists of all retrieved games, by lowercase
+// game name.
+func GameLists() map[string]*APIServerList {
+	gameListsMu.RLock()
+	defer gameListsMu.RUnlock()
+	return gameListsLocked()
+}
+
+// gameListsLocked returns the cached server lists of all retrieved games,
+// decoding them if compact. The caller must hold gameListsMu.
+func gameListsLocked() map[string]*APIServerList {
+	if compactLists {
+		m := make(map[string]*APIServerList, len(gameArenas))
+		for g, a := range gameArenas {
+			m[g] = a.unpack()
+		}
+		return m
+	}
+	m := make(map[string]*APIServerList, len(gameLists))
+	for g, sl := range gameLists {
+		m[g] = sl
+	}
+	return m
+}
+
+// GetMasterList returns the combined list of all games' servers: MasterList, or
+// when the game lists are compact, the list decoded from them.
+func GetMasterList() *APIServerList {
+	gameListsMu.RLock()
+	defer gameListsMu.RUnlock()
+	if !compactLists {
+		return MasterList
+	}
+	return CombineGameLists(gameListsLocked())
+}
+
+// CombineGameLists returns the combined list of the games' servers; if there is
+// only one game then its list is used as-is.
+func CombineGameLists(gameLists map[string]*APIServerList) *APIServerList {
+	switch len(gameLists) {
+	case 0:
+		return nil
+	case 1:
+		for _, sl := range gameLists {
+			return sl
+		}
+	}
+	games := make([]string, 0, len(gameLists))
+	for g := range gameLists {
+		games = append(games, g)
+	}
+	sort.Strings(games)
+	combined := &APIServerList{
+		CycleIDs:      make(map[string]string, len(games)),
+		Servers:       make([]APIServer, 0),
+		FailedServers: make([]string, 0),
+	}
+	for _, g := range games {
+		sl := gameLists[g]
+		if sl.CycleID != "" {
+			combined.CycleIDs[g] = sl.CycleID
+		}
+		combined.WarmUp = combined.WarmUp || sl.WarmUp
+		combined.PartialMasterList = combined.PartialMasterList ||
+			sl.PartialMasterList
+		if sl.RetrievedTimeStamp >= combined.RetrievedTimeStamp {
+			combined.RetrievedAt = sl.RetrievedAt
+			combined.RetrievedTimeStamp = sl.RetrievedTimeStamp
+		}
+		combined.Servers = append(combined.Servers, sl.Servers...)
+		combined.FailedServers = append(combined.FailedServers, sl.FailedServers...)
+		combined.OfflineServers = append(combined.OfflineServers,
+			sl.OfflineServers...)
+	}
+	combined.ServerCount = len(combined.Servers)
+	combined.FailedCount = len(combined.FailedServers)
+	return combined
+}
+
 // GetDefaultServerList Returns a default, empty, server list with the current
 // date and time in response to a server detail list request that failed for
 // whatever reason.
@@ -48,3 +298,24 @@ func GetDefaultServerList() *APIServerList {
 		FailedServers:      make([]string, 0),
 	}
 }
+
+// Server returns the offline or timed out server as a server list entry.
+func (o APIOfflineServer) Server() APIServer {
+	s := APIServer{
+		ID:      o.ID,
+		Host:    o.Host,
+		Game:    o.Game,
+		Players: make([]SteamPlayerInfo, 0),
+		FilteredPlayers: FilteredPlayerInfo{
+			FilteredPlayers: make([]SteamPlayerInfo, 0)},
+		Rules:               make(map[string]string),
+		Status:              o.Status,
+		ConsecutiveFailures: o.ConsecutiveFailures,
+		LastSeenOnline:      o.LastSeenOnline,
+	}
+	if ip, port, err := net.SplitHostPort(o.Host); err == nil {
+		s.IP = ip
+		s.Port, _ = strconv.Atoi(port)
+	}
+	return s
+}
@@ -0,0 +1,79 @@
+package models
+
+// api_virtualserver.go - Model for virtual servers: logical servers that run on
+// several hosts (i.e: a lobby and its instances), with their hosts' stats merged
+
+// APIVirtualServerList represents the virtual servers returned by the API.
+type APIVirtualServerList struct {
+	RetrievedAt        string             `json:"retrievalDate"`
+	RetrievedTimeStamp int64              `json:"timestamp"`
+	VirtualServerCount int                `json:"virtualServerCount"`
+	VirtualServers     []APIVirtualServer `json:"virtualServers"`
+}
+
+// APIVirtualServer represents a virtual server, with the player counts and
+// players of its hosts merged.
+type APIVirtualServer struct {
+	Name string `json:"name"`
+	Game string `json:"game"`
+	// ServerStatusOnline if all of the hosts are in the server list,
+	// ServerStatusPartial if only some are, and ServerStatusOffline if none are
+	Status         string                   `json:"status"`
+	OnlineCount    int                      `json:"onlineCount"`
+	PlayerCount    int                      `json:"playerCount"`
+	BotCount       int                      `json:"botCount"`
+	MaxPlayerCount int                      `json:"maxPlayerCount"`
+	Players        []SteamPlayerInfo        `json:"players"`
+	Members        []APIVirtualServerMember `json:"members"`
+}
+
+// APIVirtualServerMember represents one of the hosts of a virtual server.
+type APIVirtualServerMember struct {
+	ID          int64  `json:"serverID,omitempty"`
+	Host        string `json:"address"`
+	Status      string `json:"status"`
+	Map         string `json:"map,omitempty"`
+	PlayerCount int    `json:"playerCount"`
+	MaxPlayers  int    `json:"maxPlayerCount"`
+}
+
+// NewAPIVirtualServer returns a virtual server without any hosts.
+func NewAPIVirtualServer(name, game string) *APIVirtualServer {
+	return &APIVirtualServer{Name: name, Game: game, Status: ServerStatusOffline,
+		Players: make([]SteamPlayerInfo, 0), Members: make([]APIVirtualServerMember, 0)}
+}
+
+// AddServer adds a host that is in the server list, merging its stats.
+func (v *APIVirtualServer) AddServer(s *APIServer) {
+	v.Members = append(v.Members, APIVirtualServerMember{ID: s.ID, Host: s.Host,
+		Status: s.Status, Map: s.Info.Map, PlayerCount: int(s.Info.Players),
+		MaxPlayers: int(s.Info.MaxPlayers)})
+	v.OnlineCount++
+	v.PlayerCount += int(s.Info.Players)
+	v.BotCount += int(s.Info.Bots)
+	v.MaxPlayerCount += int(s.Info.MaxPlayers)
+	v.Players = append(v.Players, s.Players...)
+	v.updateStatus()
+}
+
+// AddMissingHost adds a host that is not in the server list, with its status
+// (ServerStatusTimedOut or ServerStatusOffline) if known.
+func (v *APIVirtualServer) AddMissingHost(host, status string) {
+	if status == "" {
+		status = ServerStatusOffline
+	}
+	v.Members = append(v.Members, APIVirtualServerMember{Host: host,
+		Status: status})
+	v.updateStatus()
+}
+
+func (v *APIVirtualServer) updateStatus() {
+	switch {
+	case v.OnlineCount == 0:
+		v.Status = ServerStatusOffline
+	case v.OnlineCount < len(v.Members):
+		v.Status = ServerStatusPartial
+	default:
+		v.Status = ServerStatusOnline
+	}
+}
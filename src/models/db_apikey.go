@@ -0,0 +1,32 @@
+package models
+
+// db_apikey.go - Model for API keys returned by the application DB
+
+// DbAPIKey represents an API key and the scopes that it grants. The key itself
+// is never stored; only its hash is.
+type DbAPIKey struct {
+	ID        string   `json:"keyID"`
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	CreatedAt int64    `json:"createdTimestamp"`
+	RotatedAt int64    `json:"rotatedTimestamp"`
+	RevokedAt int64    `json:"revokedTimestamp"`
+	// direct query quotas; 0 uses the configured default and -1 is unlimited
+	HourlyQuota int `json:"hourlyQuota"`
+	DailyQuota  int `json:"dailyQuota"`
+	// not displayed via the API
+	KeyHash string `json:"-"`
+}
+
+// Revoked returns true if the API key has been revoked.
+func (k *DbAPIKey) Revoked() bool {
+	return k.RevokedAt != 0
+}
+
+// APIKeyToken represents the one-time information returned when an API key is
+// created or rotated.
+type APIKeyToken struct {
+	ID     string   `json:"keyID"`
+	Key    string   `json:"key"`
+	Scopes []string `json:"scopes"`
+}
@@ -0,0 +1,16 @@
+package models
+
+// db_cadence.go - Model for the learned per-server query intervals stored in
+// the app DB
+
+// DbServerCadence represents how often a server is queried, as learned from its
+// activity.
+type DbServerCadence struct {
+	Host string
+	Game string
+	// seconds to wait after the server was last queried before querying it again
+	Interval    int64
+	LastQueried int64
+	// the last time the server was seen with human players on it
+	LastActive int64
+}
@@ -0,0 +1,28 @@
+package models
+
+// db_claim.go - Model for server ownership claims returned by server DB
+
+// DbServerClaim represents a server owner's claim on a server ID, along with
+// the metadata that the verified owner has set for the server.
+type DbServerClaim struct {
+	ID          int64  `json:"serverID"`
+	Host        string `json:"host"`
+	Game        string `json:"game"`
+	Verified    bool   `json:"verified"`
+	VerifiedAt  int64  `json:"verifiedTimestamp"`
+	Alias       string `json:"alias"`
+	Description string `json:"description"`
+	// not displayed via the API
+	Token        string `json:"-"`
+	OwnerKeyHash string `json:"-"`
+	NotifyURL    string `json:"-"`
+}
+
+// ServerClaimToken represents the one-time information returned to a server
+// owner when a claim is started.
+type ServerClaimToken struct {
+	ID           int64  `json:"serverID"`
+	Token        string `json:"token"`
+	OwnerKey     string `json:"ownerKey"`
+	Instructions string `json:"instructions"`
+}
@@ -8,4 +8,15 @@ type DbCountry struct {
 	CountryCode string `json:"countryCode"`
 	Continent   string `json:"region"`
 	State       string `json:"state"`
+	// regional indicator emoji of the country's flag; empty if unknown
+	FlagEmoji string `json:"flagEmoji"`
+	// flag image URL from the configured template; omitted if not configured
+	FlagURL string `json:"flagURL,omitempty"`
+	// city, coordinates, and autonomous system (network operator) of the IP;
+	// omitted if unknown or not provided by the configured GeoIP provider
+	City      string  `json:"city,omitempty"`
+	Latitude  float64 `json:"latitude,omitempty"`
+	Longitude float64 `json:"longitude,omitempty"`
+	ASN       uint    `json:"asn,omitempty"`
+	ASNOrg    string  `json:"asnOrg,omitempty"`
 }
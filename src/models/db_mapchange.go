@@ -0,0 +1,13 @@
+package models
+
+// db_mapchange.go - Model for detected map changes stored in the app DB
+
+// DbMapChange represents a server changing from one map to another.
+type DbMapChange struct {
+	ID        int64  `json:"serverID"`
+	Host      string `json:"address"`
+	Game      string `json:"game"`
+	FromMap   string `json:"from"`
+	ToMap     string `json:"to"`
+	Timestamp int64  `json:"timestamp"`
+}
@@ -0,0 +1,79 @@
+package models
+
+// db_serveranalysis.go - Model for the analysis of a server's stored history
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strconv"
+)
+
+// ServerAnalysis represents a summary of a server's player count and map
+// history over a period of time, as reported by the analyze command.
+type ServerAnalysis struct {
+	ServerID       int64          `json:"serverID"`
+	Game           string         `json:"game"`
+	Period         string         `json:"period"`
+	From           int64          `json:"from"`
+	To             int64          `json:"to"`
+	Samples        int            `json:"samples"`
+	Retrievals     int            `json:"retrievals"`
+	Uptime         float64        `json:"uptime"`
+	PeakPlayers    int            `json:"peakPlayers"`
+	PeakPlayersAt  int64          `json:"peakPlayersAt"`
+	AveragePlayers float64        `json:"averagePlayers"`
+	BusiestHours   []HourActivity `json:"busiestHours"`
+	Maps           []MapShare     `json:"maps"`
+}
+
+// HourActivity represents the average number of human players on a server
+// during an hour of the day.
+type HourActivity struct {
+	Hour           int     `json:"hour"`
+	AveragePlayers float64 `json:"averagePlayers"`
+	Samples        int     `json:"samples"`
+}
+
+// MapShare represents the time a server spent on a map.
+type MapShare struct {
+	Map     string  `json:"map"`
+	Seconds int64   `json:"seconds"`
+	Share   float64 `json:"share"`
+}
+
+// CSV returns the analysis as CSV: a header row followed by section, key, and
+// value rows for the summary, each hour of the day, and each map.
+func (a *ServerAnalysis) CSV() ([]byte, error) {
+	var b bytes.Buffer
+	cw := csv.NewWriter(&b)
+	rows := [][]string{
+		{"section", "key", "value"},
+		{"summary", "serverID", strconv.FormatInt(a.ServerID, 10)},
+		{"summary", "game", a.Game},
+		{"summary", "period", a.Period},
+		{"summary", "from", strconv.FormatInt(a.From, 10)},
+		{"summary", "to", strconv.FormatInt(a.To, 10)},
+		{"summary", "samples", strconv.Itoa(a.Samples)},
+		{"summary", "retrievals", strconv.Itoa(a.Retrievals)},
+		{"summary", "uptime", formatCSVFloat(a.Uptime)},
+		{"summary", "peakPlayers", strconv.Itoa(a.PeakPlayers)},
+		{"summary", "peakPlayersAt", strconv.FormatInt(a.PeakPlayersAt, 10)},
+		{"summary", "averagePlayers", formatCSVFloat(a.AveragePlayers)},
+	}
+	for _, h := range a.BusiestHours {
+		rows = append(rows, []string{"hour", strconv.Itoa(h.Hour),
+			formatCSVFloat(h.AveragePlayers)})
+	}
+	for _, m := range a.Maps {
+		rows = append(rows, []string{"map", csvText(m.Map),
+			formatCSVFloat(m.Share)})
+	}
+	if err := cw.WriteAll(rows); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func formatCSVFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,26 @@
+package models
+
+// db_serverevent.go - Model for server events stored in the app DB
+
+const (
+	// ServerEventOffline is recorded when a known server stops responding
+	ServerEventOffline = "offline"
+	// ServerEventOnline is recorded when a server that went offline responds again
+	ServerEventOnline = "online"
+	// ServerEventMapChange is reported for the servers' detected map changes
+	ServerEventMapChange = "mapChange"
+	// ServerEventPlayerThreshold is posted to webhooks when a server's human
+	// players go above or drop below the webhook's threshold
+	ServerEventPlayerThreshold = "playerThreshold"
+)
+
+// DbServerEvent represents a change in a server's state: going offline, coming
+// back online, or changing maps.
+type DbServerEvent struct {
+	ID        int64  `json:"serverID"`
+	Host      string `json:"address"`
+	Game      string `json:"game"`
+	Event     string `json:"event"`
+	Detail    string `json:"detail"`
+	Timestamp int64  `json:"timestamp"`
+}
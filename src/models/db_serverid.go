@@ -7,6 +7,8 @@ type DbServer struct {
 	ID   int64  `json:"serverID"`
 	Game string `json:"game"`
 	Host string `json:"host"`
+	// persistent Steam ID of the server, if known
+	SteamID uint64 `json:"steamID,omitempty"`
 }
 
 // DbServerID represents the outer struct that is retrieved from the server ID
@@ -0,0 +1,44 @@
+package models
+
+// db_serverstat.go - Model for the player count history stored in the app DB
+
+// DbServerStat represents a server's player counts at the time of a timed
+// retrieval or, once the history has been downsampled, its rounded average
+// player counts over the hour or day that starts at the timestamp.
+type DbServerStat struct {
+	ID         int64  `json:"-"`
+	Game       string `json:"-"`
+	Timestamp  int64  `json:"timestamp"`
+	Players    int    `json:"players"`
+	Bots       int    `json:"bots"`
+	MaxPlayers int    `json:"maxPlayers"`
+	// set if the player counts are the averages of a downsampled period
+	Downsampled *DbServerStatPeriod `json:"downsampled,omitempty"`
+}
+
+// DbServerStatPeriod represents the player counts of the retrievals in a
+// downsampled period of a server's history.
+type DbServerStatPeriod struct {
+	// length of the period in seconds
+	Resolution  int64   `json:"resolution"`
+	Samples     int     `json:"samples"`
+	AvgPlayers  float64 `json:"avgPlayers"`
+	MinPlayers  int     `json:"minPlayers"`
+	PeakPlayers int     `json:"peakPlayers"`
+}
+
+// Resolutions of the downsampled player count history, in seconds.
+const (
+	HistoryResolutionHour = 3600
+	HistoryResolutionDay  = 86400
+)
+
+// ServerHistory represents a server's player count time series over a range of
+// time, oldest first.
+type ServerHistory struct {
+	ServerID int64          `json:"serverID"`
+	Range    string         `json:"range"`
+	From     int64          `json:"from"`
+	To       int64          `json:"to"`
+	Points   []DbServerStat `json:"points"`
+}
@@ -0,0 +1,228 @@
+package models
+
+// jsonencoding.go - Consistent JSON encoding of the models' empty lists (slices
+// and maps), which are otherwise encoded as [] or null depending on how each list
+// happened to be built, of missing server info, and of HTML characters.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync/atomic"
+)
+
+// Encodings of empty lists
+const (
+	// empty lists are encoded as [] (or {} for maps)
+	JSONEmptyListsArray = "array"
+	// empty lists are encoded as null
+	JSONEmptyListsNull = "null"
+)
+
+// Encodings of the info of servers without A2S_INFO data (i.e. offline servers,
+// or games that don't answer A2S_INFO)
+const (
+	// the info is encoded as {}
+	JSONEmptyInfoObject = "object"
+	// the info is left out
+	JSONEmptyInfoOmit = "omit"
+)
+
+// JSONEncoding holds the options of the models' JSON encoding.
+type JSONEncoding struct {
+	// escape <, >, and & in strings
+	EscapeHTML bool
+	// JSONEmptyListsArray or JSONEmptyListsNull; lists that are omitted when
+	// empty (omitempty) are always omitted
+	EmptyLists string
+	// JSONEmptyInfoObject or JSONEmptyInfoOmit
+	EmptyInfo string
+}
+
+var (
+	// 1 if empty lists are encoded as null
+	emptyListsNull int32
+	// 1 if <, >, and & are not escaped in strings
+	noEscapeHTML int32
+	// 1 if missing server info is left out
+	emptyInfoOmit int32
+)
+
+// SetJSONEncoding sets the options of the models' JSON encoding. Unknown
+// encodings of empty lists or info are reported, and the defaults,
+// JSONEmptyListsArray and JSONEmptyInfoObject, are used instead.
+func SetJSONEncoding(e JSONEncoding) error {
+	if e.EscapeHTML {
+		atomic.StoreInt32(&noEscapeHTML, 0)
+	} else {
+		atomic.StoreInt32(&noEscapeHTML, 1)
+	}
+	var err error
+	switch strings.ToLower(e.EmptyLists) {
+	case "", JSONEmptyListsArray:
+		atomic.StoreInt32(&emptyListsNull, 0)
+	case JSONEmptyListsNull:
+		atomic.StoreInt32(&emptyListsNull, 1)
+	default:
+		atomic.StoreInt32(&emptyListsNull, 0)
+		err = fmt.Errorf("Unknown encoding of empty lists: '%s'", e.EmptyLists)
+	}
+	switch strings.ToLower(e.EmptyInfo) {
+	case "", JSONEmptyInfoObject:
+		atomic.StoreInt32(&emptyInfoOmit, 0)
+	case JSONEmptyInfoOmit:
+		atomic.StoreInt32(&emptyInfoOmit, 1)
+	default:
+		atomic.StoreInt32(&emptyInfoOmit, 0)
+		err = fmt.Errorf("Unknown encoding of empty server info: '%s'", e.EmptyInfo)
+	}
+	return err
+}
+
+// APIServerListDiff has no MarshalJSON method: it is embedded in the messages
+// of live clients, which would then be encoded as the diff alone.
+
+// marshalNormalized encodes the struct that v points to with its empty lists
+// normalized. v must not be a type with a MarshalJSON method.
+func marshalNormalized(v interface{}) ([]byte, error) {
+	normalizeEmptyLists(v)
+	return marshalModel(v)
+}
+
+// marshalModel encodes v, escaping HTML characters as configured.
+func marshalModel(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(atomic.LoadInt32(&noEscapeHTML) == 0)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// normalizeEmptyLists sets the empty slice and map fields of the struct that v
+// points to, that are not omitted when empty, to either empty or nil lists.
+func normalizeEmptyLists(v interface{}) {
+	null := atomic.LoadInt32(&emptyListsNull) == 1
+	s := reflect.ValueOf(v).Elem()
+	t := s.Type()
+	for i := 0; i < s.NumField(); i++ {
+		f := s.Field(i)
+		if f.Kind() != reflect.Slice && f.Kind() != reflect.Map {
+			continue
+		}
+		if strings.Contains(t.Field(i).Tag.Get("json"), "omitempty") {
+			continue
+		}
+		switch {
+		case null && f.Len() == 0:
+			f.Set(reflect.Zero(f.Type()))
+		case !null && f.IsNil() && f.Kind() == reflect.Slice:
+			f.Set(reflect.MakeSlice(f.Type(), 0, 0))
+		case !null && f.IsNil():
+			f.Set(reflect.MakeMap(f.Type()))
+		}
+	}
+}
+
+// MarshalJSON encodes the server list with its empty lists normalized.
+func (sl APIServerList) MarshalJSON() ([]byte, error) {
+	type serverList APIServerList
+	c := serverList(sl)
+	return marshalNormalized(&c)
+}
+
+// MarshalJSON encodes the server with its empty lists normalized, and without
+// its info if it has none and missing info is left out.
+func (s APIServer) MarshalJSON() ([]byte, error) {
+	type server APIServer
+	c := struct {
+		server
+		// replaces the server's Info, so that it can be omitted
+		Info *SteamServerInfo `json:"info,omitempty"`
+	}{server: server(s), Info: &s.Info}
+	normalizeEmptyLists(&c.server)
+	if s.Info.empty() && atomic.LoadInt32(&emptyInfoOmit) == 1 {
+		c.Info = nil
+	}
+	return marshalModel(&c)
+}
+
+// MarshalJSON encodes the server info as {} if there is none (the server did not
+// answer A2S_INFO).
+func (i SteamServerInfo) MarshalJSON() ([]byte, error) {
+	if i.empty() {
+		return []byte("{}"), nil
+	}
+	type serverInfo SteamServerInfo
+	return marshalModel(serverInfo(i))
+}
+
+func (i SteamServerInfo) empty() bool {
+	return i == SteamServerInfo{}
+}
+
+// MarshalJSON encodes the filtered players with an empty list normalized.
+func (p FilteredPlayerInfo) MarshalJSON() ([]byte, error) {
+	type filteredPlayers FilteredPlayerInfo
+	c := filteredPlayers(p)
+	return marshalNormalized(&c)
+}
+
+// MarshalJSON encodes the compact server list with an empty list normalized.
+func (sl APICompactServerList) MarshalJSON() ([]byte, error) {
+	type compactList APICompactServerList
+	c := compactList(sl)
+	return marshalNormalized(&c)
+}
+
+// MarshalJSON encodes the server change with an empty list normalized.
+func (sc APIServerChange) MarshalJSON() ([]byte, error) {
+	type change APIServerChange
+	c := change(sc)
+	return marshalNormalized(&c)
+}
+
+// MarshalJSON encodes the virtual server list with an empty list normalized.
+func (vl APIVirtualServerList) MarshalJSON() ([]byte, error) {
+	type virtualList APIVirtualServerList
+	c := virtualList(vl)
+	return marshalNormalized(&c)
+}
+
+// MarshalJSON encodes the virtual server with its empty lists normalized.
+func (vs APIVirtualServer) MarshalJSON() ([]byte, error) {
+	type virtualServer APIVirtualServer
+	c := virtualServer(vs)
+	return marshalNormalized(&c)
+}
+
+// MarshalJSON encodes the API key with an empty list normalized.
+func (k DbAPIKey) MarshalJSON() ([]byte, error) {
+	type apiKey DbAPIKey
+	c := apiKey(k)
+	return marshalNormalized(&c)
+}
+
+// MarshalJSON encodes the API key token with an empty list normalized.
+func (k APIKeyToken) MarshalJSON() ([]byte, error) {
+	type apiKeyToken APIKeyToken
+	c := apiKeyToken(k)
+	return marshalNormalized(&c)
+}
+
+// MarshalJSON encodes the server IDs with an empty list normalized.
+func (ids DbServerID) MarshalJSON() ([]byte, error) {
+	type serverID DbServerID
+	c := serverID(ids)
+	return marshalNormalized(&c)
+}
+
+// MarshalJSON encodes the server history with an empty list normalized.
+func (h ServerHistory) MarshalJSON() ([]byte, error) {
+	type history ServerHistory
+	c := history(h)
+	return marshalNormalized(&c)
+}
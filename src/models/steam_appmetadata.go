@@ -0,0 +1,15 @@
+package models
+
+// steam_appmetadata.go - Model for a game's details from Steam's public store
+// and Web API endpoints
+
+// SteamAppMetadata represents a game's store details and its current number of
+// players on Steam (in all modes, not only on servers).
+type SteamAppMetadata struct {
+	StoreName      string `json:"storeName,omitempty"`
+	HeaderImage    string `json:"headerImage,omitempty"`
+	CapsuleImage   string `json:"capsuleImage,omitempty"`
+	CurrentPlayers int    `json:"currentPlayers"`
+	// Unix timestamp of when the details were retrieved
+	UpdatedTimeStamp int64 `json:"updatedTimestamp"`
+}
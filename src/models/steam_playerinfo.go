@@ -4,10 +4,21 @@ package models
 
 // SteamPlayerInfo represents a player returned by a Steam A2S_PLAYER query
 type SteamPlayerInfo struct {
-	Name              string  `json:"name"`
+	Name string `json:"name"`
+	// the name decoded by the game's player name decoder, i.e. without color codes
+	CleanName         string  `json:"cleanName"`
 	Score             int32   `json:"score"`
 	TimeConnectedSecs float32 `json:"secsConnected"`
 	TimeConnectedTot  string  `json:"totalConnected"`
+	// whole seconds connected, for sorting
+	TimeConnectedRaw int64 `json:"rawSecsConnected"`
+	// ISO 8601 duration (i.e: P1DT2H3M4S)
+	TimeConnectedISO string `json:"isoConnected"`
+	// day-aware formatting for long sessions (i.e: 1d 2h 3m 4s)
+	TimeConnectedLong string `json:"totalConnectedLong"`
+	// per-game information that is not part of A2S_PLAYER (i.e: the player's team
+	// in Quake Live), taken from the server's rules
+	Extra map[string]string `json:"extra,omitempty"`
 }
 
 // FilteredPlayerInfo is a collection of all players on a server that actually
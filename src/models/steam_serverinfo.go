@@ -21,6 +21,7 @@ type SteamServerInfo struct {
 	Visibility    int16          `json:"private"`
 	VAC           int16          `json:"antiCheat"`
 	Version       string         `json:"serverVersion"`
+	Ping          int            `json:"ping"` // custom field: A2S_INFO round trip (ms) from API host
 	ExtraData     SteamExtraData `json:"extra"`
 }
 
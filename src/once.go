@@ -0,0 +1,71 @@
+package main
+
+// once.go - Single retrieval mode (--once), which retrieves the server list (or
+// directly queries a batch of hosts) without starting the HTTP server and then
+// exits, for use in cron jobs and shell pipelines.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/models"
+	"github.com/syncore/a2sapi/src/steam"
+)
+
+// launchOnce performs the single retrieval or direct query and returns the exit
+// status: 0 on success and 1 on error. With --stdout, the server list is written
+// to stdout as JSON; otherwise the retrieval only writes the enabled outputs.
+func launchOnce(ctx context.Context) int {
+	if directHosts != "" && !toStdout {
+		fmt.Fprintf(os.Stderr, "--%s can only be used along with --%s\n",
+			hostsFlag, stdoutFlag)
+		return 1
+	}
+	if toStdout {
+		// debug messages are written to stdout, so would corrupt the JSON
+		cfg := *config.Get()
+		cfg.DebugConfig.EnableDebugMessages = false
+		config.Set(&cfg)
+	}
+
+	var sl *models.APIServerList
+	if directHosts != "" {
+		var hosts []string
+		for _, h := range strings.Split(directHosts, ",") {
+			if h = strings.TrimSpace(h); h != "" {
+				hosts = append(hosts, h)
+			}
+		}
+		if len(hosts) == 0 {
+			fmt.Fprintf(os.Stderr, "No hosts specified with --%s\n", hostsFlag)
+			return 1
+		}
+		var err error
+		if sl, err = steam.DirectQuery(hosts); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to query hosts: %s\n", err)
+			return 1
+		}
+	} else {
+		if err := steam.RetrieveOnce(ctx, getTimedQueryGames()); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to retrieve servers: %s\n", err)
+			return 1
+		}
+		sl = models.GetMasterList()
+	}
+
+	if !toStdout {
+		return 0
+	}
+	if sl == nil {
+		sl = models.GetDefaultServerList()
+	}
+	if err := json.NewEncoder(os.Stdout).Encode(sl); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to write server list: %s\n", err)
+		return 1
+	}
+	return 0
+}
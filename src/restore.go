@@ -0,0 +1,55 @@
+package main
+
+// restore.go - The restore command, which replaces the server ID and
+// application databases with one of the backups made by the scheduled database
+// backups.
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/syncore/a2sapi/src/db"
+)
+
+const restoreCommand = "restore"
+
+// runRestore runs the restore command with the given arguments and returns the
+// exit status: 0 on success and 1 on error.
+func runRestore(args []string) int {
+	fs := flag.NewFlagSet(restoreCommand, flag.ContinueOnError)
+	list := fs.Bool("list", false,
+		"List the backups in the directory instead of restoring one")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s backup-dir\n       %s %s --list backups-dir\n",
+			os.Args[0], restoreCommand, os.Args[0], restoreCommand)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 1
+	}
+	if *list {
+		backups, err := db.ListBackups(fs.Arg(0))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to list backups: %s\n", err)
+			return 1
+		}
+		for _, b := range backups {
+			fmt.Println(b)
+		}
+		return 0
+	}
+	restored, err := db.RestoreBackup(fs.Arg(0))
+	for _, p := range restored {
+		fmt.Printf("Restored %s (the replaced file is %s.pre-restore)\n", p, p)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to restore backup: %s\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,178 @@
+package steam
+
+// appmetadata.go - Games' details (store name and images, and current number of
+// players) from Steam's public store and Web API endpoints, cached for the
+// configured time, so that frontends can render nicer game selectors.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/models"
+)
+
+var steamStoreAppDetailsURL = func(appid uint64) string {
+	return fmt.Sprintf("https://store.steampowered.com/api/appdetails?appids=%d",
+		appid)
+}
+
+var steamCurrentPlayersURL = func(appid uint64) string {
+	return fmt.Sprintf("https://api.steampowered.com/ISteamUserStats/GetNumberOfCurrentPlayers/v1/?appid=%d",
+		appid)
+}
+
+var appMetadataClient = &http.Client{Timeout: 10 * time.Second}
+
+// appMetadataEntry is a game's cached details. While the details are being
+// retrieved, fetching is closed when they have been.
+type appMetadataEntry struct {
+	meta     models.SteamAppMetadata
+	ok       bool
+	at       time.Time
+	fetching chan struct{}
+}
+
+var appMetadata = struct {
+	sync.Mutex
+	apps map[uint64]appMetadataEntry
+}{
+	apps: make(map[uint64]appMetadataEntry),
+}
+
+// storeAppDetails represents the response returned from the Steam store's
+// appdetails endpoint, keyed by app ID.
+type storeAppDetails map[string]struct {
+	Success bool `json:"success"`
+	Data    struct {
+		Name         string `json:"name"`
+		HeaderImage  string `json:"header_image"`
+		CapsuleImage string `json:"capsule_image"`
+	} `json:"data"`
+}
+
+// currentPlayers represents the response returned from the Steam Web API's
+// GetNumberOfCurrentPlayers endpoint.
+type currentPlayers struct {
+	Response struct {
+		PlayerCount int `json:"player_count"`
+		Result      int `json:"result"`
+	} `json:"response"`
+}
+
+// getAppMetadataJSON requests url and decodes its JSON response into v.
+func getAppMetadataJSON(url string, v interface{}) error {
+	resp, err := appMetadataClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// requestAppMetadata retrieves a game's store details and current number of
+// players. Either may be missing; an error is only returned if both are.
+func requestAppMetadata(appid uint64) (models.SteamAppMetadata, error) {
+	meta := models.SteamAppMetadata{UpdatedTimeStamp: time.Now().Unix()}
+	details := storeAppDetails{}
+	derr := getAppMetadataJSON(steamStoreAppDetailsURL(appid), &details)
+	if d, ok := details[strconv.FormatUint(appid, 10)]; derr == nil && ok &&
+		d.Success {
+		meta.StoreName = d.Data.Name
+		meta.HeaderImage = d.Data.HeaderImage
+		meta.CapsuleImage = d.Data.CapsuleImage
+	} else if derr == nil {
+		derr = errors.New("no store details")
+	}
+	players := currentPlayers{}
+	perr := getAppMetadataJSON(steamCurrentPlayersURL(appid), &players)
+	if perr == nil && players.Response.Result != 1 {
+		perr = fmt.Errorf("result %d", players.Response.Result)
+	}
+	if perr == nil {
+		meta.CurrentPlayers = players.Response.PlayerCount
+	}
+	if derr != nil && perr != nil {
+		return meta, fmt.Errorf("store details: %s, current players: %s", derr,
+			perr)
+	}
+	return meta, nil
+}
+
+// fetchAppMetadata retrieves and caches a game's details, keeping its previous
+// details if they can't be retrieved.
+func fetchAppMetadata(appid uint64, done chan struct{}) {
+	defer close(done)
+	meta, err := requestAppMetadata(appid)
+	if err != nil {
+		logger.LogSteamErrorf("Unable to retrieve Steam details of app %d: %s",
+			appid, err)
+	}
+	appMetadata.Lock()
+	defer appMetadata.Unlock()
+	e := appMetadata.apps[appid]
+	e.fetching = nil
+	e.at = time.Now()
+	if err == nil {
+		e.meta = meta
+		e.ok = true
+	}
+	appMetadata.apps[appid] = e
+}
+
+// GetAppMetadata returns the Steam details of the games with the given app IDs,
+// retrieving those that aren't cached or whose cached details are older than
+// the configured time. It waits for them until ctx is done; games whose details
+// have never been retrieved are missing from the result. Nothing is returned if
+// game metadata is disabled.
+func GetAppMetadata(ctx context.Context,
+	appids []uint64) map[uint64]models.SteamAppMetadata {
+	ttl := time.Duration(config.Get().WebConfig.GameMetadataCacheMins) *
+		time.Minute
+	if ttl <= 0 {
+		return nil
+	}
+	var wait []chan struct{}
+	appMetadata.Lock()
+	for _, id := range appids {
+		e := appMetadata.apps[id]
+		if e.fetching == nil && time.Since(e.at) > ttl {
+			e.fetching = make(chan struct{})
+			appMetadata.apps[id] = e
+			go fetchAppMetadata(id, e.fetching)
+		}
+		if e.fetching != nil {
+			wait = append(wait, e.fetching)
+		}
+	}
+	appMetadata.Unlock()
+
+waiting:
+	for _, done := range wait {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			break waiting
+		}
+	}
+
+	m := make(map[uint64]models.SteamAppMetadata, len(appids))
+	appMetadata.Lock()
+	defer appMetadata.Unlock()
+	for _, id := range appids {
+		if e := appMetadata.apps[id]; e.ok {
+			m[id] = e.meta
+		}
+	}
+	return m
+}
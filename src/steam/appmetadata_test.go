@@ -0,0 +1,81 @@
+package steam
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/syncore/a2sapi/src/config"
+)
+
+func TestGetAppMetadata(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		appid := r.URL.Query().Get("appid")
+		switch {
+		case r.URL.Path == "/appdetails" && r.URL.Query().Get("appids") == "282440":
+			fmt.Fprint(w, `{"282440":{"success":true,"data":{"name":"Quake Live",
+				"header_image":"https://example.org/header.jpg",
+				"capsule_image":"https://example.org/capsule.jpg"}}}`)
+		case r.URL.Path == "/appdetails":
+			fmt.Fprint(w, `{"730":{"success":false}}`)
+		case r.URL.Path == "/players" && appid == "282440":
+			fmt.Fprint(w, `{"response":{"player_count":1234,"result":1}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	origDetails, origPlayers := steamStoreAppDetailsURL, steamCurrentPlayersURL
+	defer func() {
+		steamStoreAppDetailsURL, steamCurrentPlayersURL = origDetails, origPlayers
+	}()
+	steamStoreAppDetailsURL = func(appid uint64) string {
+		return fmt.Sprintf("%s/appdetails?appids=%d", srv.URL, appid)
+	}
+	steamCurrentPlayersURL = func(appid uint64) string {
+		return fmt.Sprintf("%s/players?appid=%d", srv.URL, appid)
+	}
+	orig := config.Get().WebConfig.GameMetadataCacheMins
+	defer func() { config.Get().WebConfig.GameMetadataCacheMins = orig }()
+	appMetadata.apps = make(map[uint64]appMetadataEntry)
+
+	config.Get().WebConfig.GameMetadataCacheMins = 0
+	if m := GetAppMetadata(context.Background(), []uint64{282440}); m != nil {
+		t.Fatalf("Expected no details when disabled, got: %+v", m)
+	}
+
+	config.Get().WebConfig.GameMetadataCacheMins = 10
+	m := GetAppMetadata(context.Background(), []uint64{282440, 730})
+	meta, ok := m[282440]
+	if !ok || meta.StoreName != "Quake Live" || meta.CurrentPlayers != 1234 ||
+		meta.HeaderImage != "https://example.org/header.jpg" ||
+		meta.CapsuleImage != "https://example.org/capsule.jpg" ||
+		meta.UpdatedTimeStamp == 0 {
+		t.Fatalf("Expected the app's details, got: %+v", m)
+	}
+	if _, ok := m[730]; ok {
+		t.Fatalf("Expected no details for an app without any, got: %+v", m[730])
+	}
+	n := atomic.LoadInt32(&requests)
+	m = GetAppMetadata(context.Background(), []uint64{282440, 730})
+	if atomic.LoadInt32(&requests) != n {
+		t.Fatalf("Expected cached details to be used")
+	}
+	if m[282440] != meta {
+		t.Fatalf("Expected the cached details %+v, got: %+v", meta, m[282440])
+	}
+
+	// stale details are kept if they can't be retrieved again
+	appMetadata.apps[282440] = appMetadataEntry{meta: meta, ok: true}
+	srv.Close()
+	m = GetAppMetadata(context.Background(), []uint64{282440})
+	if m[282440] != meta {
+		t.Fatalf("Expected the stale details %+v, got: %+v", meta, m[282440])
+	}
+}
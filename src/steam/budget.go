@@ -0,0 +1,69 @@
+package steam
+
+// budget.go - Per-host time budget for the A2S requests of a retrieval, so that
+// a slow or unresponsive host can't hold up a retrieval for the request timeout
+// times the retries of each of the info, players, and rules requests.
+
+import (
+	"sync"
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/logger"
+)
+
+// minBudgetTimeout is the least time worth making a request with; hosts with
+// less of their budget left are not queried again.
+const minBudgetTimeout = 250 * time.Millisecond
+
+// queryBudget tracks the time spent on each host's A2S requests, including
+// retries, across the info, players, and rules queries of a single retrieval.
+// A nil queryBudget has no limit.
+type queryBudget struct {
+	mu    sync.Mutex
+	limit time.Duration
+	spent map[string]time.Duration
+}
+
+// newQueryBudget returns the budget for a retrieval, using the configured time
+// per host, or nil if there is no limit.
+func newQueryBudget() *queryBudget {
+	if config.Get() == nil || config.Get().SteamConfig.HostQueryBudget <= 0 {
+		return nil
+	}
+	return &queryBudget{
+		limit: time.Duration(config.Get().SteamConfig.HostQueryBudget) * time.Second,
+		spent: make(map[string]time.Duration),
+	}
+}
+
+// timeout returns the timeout for the next request to the host: the request
+// timeout (see hostTimeout), or the remainder of the host's budget if that is
+// less. It returns false if the host's budget has been used up.
+func (b *queryBudget) timeout(host string) (time.Duration, bool) {
+	timeout := hostTimeout(host)
+	if b == nil {
+		return timeout, true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	left := b.limit - b.spent[host]
+	if left < minBudgetTimeout {
+		logger.WriteDebug("query budget for %s used up; not querying again", host)
+		return 0, false
+	}
+	if left < timeout {
+		return left, true
+	}
+	return timeout, true
+}
+
+// spend charges the duration of a request to the host's budget.
+func (b *queryBudget) spend(host string, d time.Duration) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.spent[host] += d
+	b.mu.Unlock()
+}
@@ -0,0 +1,102 @@
+package steam
+
+// cadence.go - Activity-aware scheduling of server queries: servers that have
+// been empty for a while are queried less often than busy servers, and their
+// last data is carried over in the retrievals in between.
+
+import (
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/db"
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/models"
+)
+
+// emptyIntervalDivisor relates the time a server has been empty to how long is
+// waited between its queries: a server that has been empty for a day is queried
+// about once an hour (within the configured bounds).
+const emptyIntervalDivisor = 24
+
+// scheduleHosts splits a game's hosts into the hosts that are due to be queried
+// and the servers, from the game's current list, whose data is carried over
+// because they are not yet due.
+func scheduleHosts(game string, hosts []string, now time.Time) ([]string,
+	[]models.APIServer) {
+	prev := models.GetGameList(game)
+	if db.AppDB == nil || prev == nil {
+		return hosts, nil
+	}
+	cadences, err := db.AppDB.GetServerCadences(game)
+	if err != nil {
+		return hosts, nil
+	}
+	listed := make(map[string]models.APIServer, len(prev.Servers))
+	for _, s := range prev.Servers {
+		listed[s.Host] = s
+	}
+	due := make([]string, 0, len(hosts))
+	var carried []models.APIServer
+	for _, h := range hosts {
+		c, ok := cadences[h]
+		s, inList := listed[h]
+		if ok && inList && now.Unix() < c.LastQueried+c.Interval {
+			carried = append(carried, s)
+			continue
+		}
+		due = append(due, h)
+	}
+	if len(carried) > 0 {
+		logger.LogSteamInfo("Querying %d/%d %s servers; %d are not due yet",
+			len(due), len(hosts), game, len(carried))
+	}
+	return due, carried
+}
+
+// learnCadences updates the query intervals of the servers that responded
+// during a retrieval, based on whether they have human players.
+func learnCadences(game string, servers []models.APIServer, now time.Time) {
+	if db.AppDB == nil || len(servers) == 0 {
+		return
+	}
+	cadences, err := db.AppDB.GetServerCadences(game)
+	if err != nil {
+		return
+	}
+	min := time.Duration(config.Get().SteamConfig.MinServerQueryInterval) *
+		time.Second
+	max := time.Duration(config.Get().SteamConfig.MaxServerQueryInterval) *
+		time.Second
+	updated := make([]models.DbServerCadence, 0, len(servers))
+	for _, s := range servers {
+		c, ok := cadences[s.Host]
+		if !ok {
+			c = models.DbServerCadence{Host: s.Host, Game: game, LastActive: now.Unix()}
+		}
+		updated = append(updated, nextCadence(c, s.Info.Players-s.Info.Bots > 0, now,
+			min, max))
+	}
+	if err := db.AppDB.UpdateServerCadences(updated); err != nil {
+		logger.LogAppError(err)
+	}
+}
+
+// nextCadence returns a server's cadence after it has been queried at the given
+// time: busy servers are queried as often as allowed, and empty servers less
+// often the longer they have been empty, up to the maximum interval.
+func nextCadence(c models.DbServerCadence, active bool, now time.Time, min,
+	max time.Duration) models.DbServerCadence {
+	c.LastQueried = now.Unix()
+	if active {
+		c.LastActive = now.Unix()
+	}
+	interval := min + now.Sub(time.Unix(c.LastActive, 0))/emptyIntervalDivisor
+	if interval > max {
+		interval = max
+	}
+	if interval < min {
+		interval = min
+	}
+	c.Interval = int64(interval / time.Second)
+	return c
+}
@@ -0,0 +1,36 @@
+package steam
+
+import (
+	"testing"
+	"time"
+
+	"github.com/syncore/a2sapi/src/models"
+)
+
+func TestNextCadence(t *testing.T) {
+	now := time.Now()
+	min, max := 90*time.Second, 30*time.Minute
+	c := models.DbServerCadence{Host: "10.0.0.1:27960", Game: "QuakeLive",
+		Interval: 1800, LastActive: now.Add(-48 * time.Hour).Unix()}
+
+	// busy servers are queried as often as allowed
+	busy := nextCadence(c, true, now, min, max)
+	if busy.Interval != 90 || busy.LastActive != now.Unix() ||
+		busy.LastQueried != now.Unix() {
+		t.Fatalf("Expected busy server to have minimum interval, got: %+v", busy)
+	}
+	// empty for two days: bounded by the maximum interval
+	if e := nextCadence(c, false, now, min, max); e.Interval != 1800 {
+		t.Fatalf("Expected interval to be bounded at 1800, got: %d", e.Interval)
+	}
+	// empty for an hour: 90 secs + 1h/24
+	c.LastActive = now.Add(-time.Hour).Unix()
+	if e := nextCadence(c, false, now, min, max); e.Interval != 240 {
+		t.Fatalf("Expected interval of 240, got: %d", e.Interval)
+	}
+	// just emptied
+	c.LastActive = now.Unix()
+	if e := nextCadence(c, false, now, min, max); e.Interval != 90 {
+		t.Fatalf("Expected interval of 90, got: %d", e.Interval)
+	}
+}
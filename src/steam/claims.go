@@ -0,0 +1,175 @@
+package steam
+
+// claims.go - Verification of server ownership claims and downtime notifications
+// for the owners of claimed servers, performed after each timed retrieval.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/db"
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/models"
+	"github.com/syncore/a2sapi/src/util"
+)
+
+// downtimeNotification is sent to a claimed server's notification URL when the
+// server stops or resumes responding to queries.
+type downtimeNotification struct {
+	ID        int64  `json:"serverID"`
+	Host      string `json:"address"`
+	Game      string `json:"game"`
+	Alias     string `json:"alias"`
+	Status    string `json:"status"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+var (
+	// last known state of claimed servers, true when the server was responding
+	claimedUp    = make(map[int64]bool)
+	claimedUpMu  sync.Mutex
+	notifyClient = &http.Client{Timeout: 10 * time.Second,
+		Transport: &http.Transport{DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second, Control: denyNonPublicAddress}).DialContext}}
+)
+
+// denyNonPublicAddress refuses connections to addresses that aren't public, so
+// that a notification URL whose host resolves to a private address after it
+// was set can't reach the API's own network.
+func denyNonPublicAddress(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !util.IsPublicIP(ip) {
+		return fmt.Errorf("refusing to connect to non-public address %s", host)
+	}
+	return nil
+}
+
+// claimTokenFound returns true if a claim token appears in the server's name or
+// in one of its rule values; otherwise returns false.
+func claimTokenFound(srv models.APIServer, token string) bool {
+	if token == "" {
+		return false
+	}
+	if strings.Contains(srv.Info.Name, token) {
+		return true
+	}
+	for _, v := range srv.Rules {
+		if strings.Contains(v, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// getClaimAliases returns the aliases that verified owners have set for their
+// servers, keyed by server ID.
+func getClaimAliases() map[int64]string {
+	aliases := make(map[int64]string)
+	if !config.Get().WebConfig.EnableServerClaims || db.AppDB == nil {
+		return aliases
+	}
+	verified, err := db.AppDB.GetVerifiedClaims()
+	if err != nil {
+		return aliases
+	}
+	for _, c := range verified {
+		if c.Alias != "" {
+			aliases[c.ID] = c.Alias
+		}
+	}
+	return aliases
+}
+
+// processClaims verifies pending claims for servers in the list and notifies the
+// owners of claimed servers whose availability has changed since the last
+// retrieval of the game.
+func processClaims(game string, sl *models.APIServerList) {
+	if db.AppDB == nil || sl == nil {
+		return
+	}
+	byID := make(map[int64]models.APIServer, len(sl.Servers))
+	for _, s := range sl.Servers {
+		if s.ID != 0 {
+			byID[s.ID] = s
+		}
+	}
+
+	pending, err := db.AppDB.GetPendingClaims()
+	if err != nil {
+		return
+	}
+	for _, c := range pending {
+		srv, ok := byID[c.ID]
+		if !ok || !claimTokenFound(srv, c.Token) {
+			continue
+		}
+		if err := db.AppDB.SetClaimVerified(c.ID); err == nil {
+			logger.LogAppInfo("Verified ownership claim for server ID %d (%s)",
+				c.ID, c.Host)
+			db.AppDB.AddAuditEntry("a2sapi", "claim.verify", db.ClaimAuditTarget(c.ID), c.Host)
+		}
+	}
+
+	verified, err := db.AppDB.GetVerifiedClaims()
+	if err != nil {
+		return
+	}
+	claimedUpMu.Lock()
+	defer claimedUpMu.Unlock()
+	for _, c := range verified {
+		if c.Game != game {
+			continue
+		}
+		_, up := byID[c.ID]
+		wasUp, known := claimedUp[c.ID]
+		claimedUp[c.ID] = up
+		if !known || wasUp == up || c.NotifyURL == "" {
+			continue
+		}
+		status := "down"
+		if up {
+			status = "up"
+		}
+		go sendClaimNotification(c.NotifyURL, c.ID, downtimeNotification{
+			ID:        c.ID,
+			Host:      c.Host,
+			Game:      c.Game,
+			Alias:     c.Alias,
+			Status:    status,
+			Timestamp: time.Now().Unix(),
+		})
+	}
+}
+
+// sendClaimNotification posts a notification about a claimed server to the
+// owner's notification URL.
+func sendClaimNotification(url string, id int64, n interface{}) {
+	j, err := json.Marshal(n)
+	if err != nil {
+		logger.LogAppErrorf("Error marshaling notification: %s", err)
+		return
+	}
+	resp, err := notifyClient.Post(url, "application/json", bytes.NewReader(j))
+	if err != nil {
+		logger.LogAppErrorf("Error sending notification for server ID %d: %s",
+			id, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		logger.LogAppErrorf(
+			"Notification for server ID %d was rejected with status %d",
+			id, resp.StatusCode)
+	}
+}
@@ -0,0 +1,53 @@
+package steam
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/syncore/a2sapi/src/models"
+)
+
+func TestClaimTokenFound(t *testing.T) {
+	token := "a2sapi-0123456789ab"
+	srv := models.APIServer{
+		Info:  models.SteamServerInfo{Name: "Fun Server | a2sapi-0123456789ab"},
+		Rules: map[string]string{"sv_hostname": "Fun Server"},
+	}
+	if !claimTokenFound(srv, token) {
+		t.Fatalf("Expected claim token to be found in server name")
+	}
+	srv.Info.Name = "Fun Server"
+	if claimTokenFound(srv, token) {
+		t.Fatalf("Expected claim token not to be found")
+	}
+	srv.Rules["sv_claim"] = token
+	if !claimTokenFound(srv, token) {
+		t.Fatalf("Expected claim token to be found in server rules")
+	}
+	if claimTokenFound(srv, "") {
+		t.Fatalf("Expected empty claim token never to be found")
+	}
+}
+
+func TestNotifyClientRefusesNonPublicAddresses(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		t.Errorf("Expected no notification to reach a loopback address")
+	}))
+	defer ts.Close()
+	resp, err := notifyClient.Post(ts.URL, "application/json", nil)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatalf("Expected notification to a loopback address to be refused")
+	}
+	for _, addr := range []string{"10.0.0.1:80", "169.254.169.254:80",
+		"[::1]:80", "0.0.0.0:80"} {
+		if err := denyNonPublicAddress("tcp", addr, nil); err == nil {
+			t.Errorf("Expected %s to be refused", addr)
+		}
+	}
+	if err := denyNonPublicAddress("tcp", "8.8.8.8:443", nil); err != nil {
+		t.Errorf("Expected public address to be allowed, got: %s", err)
+	}
+}
@@ -0,0 +1,21 @@
+package steam
+
+// clock.go - Source of the current time for the query engine, which tests can
+// replace in order to control the measured ping and query durations and the
+// time left before a context's deadline without sleeping.
+
+import "time"
+
+// clock provides the current time.
+type clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                  { return time.Now() }
+func (systemClock) Since(t time.Time) time.Duration { return time.Since(t) }
+
+// queryClock is the clock used by the query engine.
+var queryClock clock = systemClock{}
@@ -0,0 +1,277 @@
+package steam
+
+// concurrency.go - Bounds the number of A2S requests that are in flight at once
+// and, if enabled, tunes that bound (AIMD) based on the observed failure rate,
+// and bounds the number of goroutines that hosts are queried from.
+
+import (
+	"context"
+	"expvar"
+	"sync"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/models"
+)
+
+const (
+	// number of completed requests that make up one tuning decision
+	tuneWindowSize = 100
+	// failure rate above the baseline that is treated as a sign of congestion
+	tuneTolerance = 0.05
+	// how quickly the baseline drifts upwards towards the current failure rate
+	tuneBaselineDrift = 0.05
+)
+
+// queryLimiter limits the number of in-flight A2S requests. When auto-tuning is
+// enabled, the limit is additively increased while the failure rate stays near
+// its baseline and multiplicatively decreased when it rises above it, which
+// finds the highest query rate the host's network can sustain.
+type queryLimiter struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	limit    int
+	min      int
+	max      int
+	autotune bool
+	inflight int
+	// requests waiting for the limit
+	waiting int
+	// current tuning window
+	completed int
+	failed    int
+	// lowest windowed failure rate seen (drifting); approximates the share of
+	// hosts that fail because they're dead rather than because of congestion
+	baseline float64
+}
+
+var (
+	limiter     *queryLimiter
+	limiterOnce sync.Once
+)
+
+func newQueryLimiter(max, min int, autotune bool) *queryLimiter {
+	if min <= 0 || min > max {
+		min = max
+	}
+	l := &queryLimiter{
+		limit:    max,
+		min:      min,
+		max:      max,
+		autotune: autotune,
+		baseline: -1,
+	}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// getQueryLimiter returns the application-wide A2S request limiter, creating
+// it from the configuration on first use.
+func getQueryLimiter() *queryLimiter {
+	limiterOnce.Do(func() {
+		limiter = newQueryLimiter(config.Get().SteamConfig.MaxConcurrentQueries,
+			config.Get().SteamConfig.MinConcurrentQueries,
+			config.Get().SteamConfig.AutoTuneConcurrency)
+		expvar.Publish("a2sQueryConcurrency", expvar.Func(func() interface{} {
+			limiter.mu.Lock()
+			defer limiter.mu.Unlock()
+			return map[string]interface{}{
+				"limit":    limiter.limit,
+				"inflight": limiter.inflight,
+				"waiting":  limiter.waiting,
+				"baseline": limiter.baseline,
+			}
+		}))
+	})
+	return limiter
+}
+
+// acquire blocks until another A2S request may be sent. It returns the
+// context's error instead if the context is cancelled, so that no new requests
+// are sent during shutdown.
+func (l *queryLimiter) acquire(ctx context.Context) error {
+	if l.max <= 0 {
+		return ctx.Err()
+	}
+	start := queryClock.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.inflight >= l.limit {
+		l.waiting++
+		for l.inflight >= l.limit && ctx.Err() == nil {
+			l.cond.Wait()
+		}
+		l.waiting--
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	l.inflight++
+	a2sQueueWaitMetric.Observe(queryClock.Since(start).Seconds())
+	return nil
+}
+
+// release marks an A2S request as complete, recording whether it failed.
+func (l *queryLimiter) release(failed bool) {
+	if l.max <= 0 {
+		return
+	}
+	l.mu.Lock()
+	l.inflight--
+	if l.autotune {
+		l.completed++
+		if failed {
+			l.failed++
+		}
+		if l.completed >= tuneWindowSize {
+			l.tune()
+		}
+	}
+	l.mu.Unlock()
+	l.cond.Broadcast()
+}
+
+// tune adjusts the limit based on the failure rate of the current window. The
+// caller must hold the lock.
+func (l *queryLimiter) tune() {
+	rate := float64(l.failed) / float64(l.completed)
+	l.completed, l.failed = 0, 0
+	if l.baseline < 0 || rate < l.baseline {
+		l.baseline = rate
+	} else {
+		l.baseline += (rate - l.baseline) * tuneBaselineDrift
+	}
+	prev := l.limit
+	if rate > l.baseline+tuneTolerance {
+		l.limit = l.limit / 2
+		if l.limit < l.min {
+			l.limit = l.min
+		}
+	} else {
+		step := l.max / 50
+		if step < 1 {
+			step = 1
+		}
+		l.limit += step
+		if l.limit > l.max {
+			l.limit = l.max
+		}
+	}
+	if l.limit != prev {
+		logger.LogSteamInfo(
+			"A2S concurrency limit adjusted from %d to %d (failure rate: %.2f, baseline: %.2f)",
+			prev, l.limit, rate, l.baseline)
+	}
+}
+
+// currentLimit returns the current in-flight limit.
+func (l *queryLimiter) currentLimit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
+
+// queryWorkers returns the number of goroutines to query a batch of hosts from:
+// the configured number of workers, or the in-flight limit if not set, since
+// further goroutines would only wait for the limit.
+func queryWorkers(hosts int) int {
+	n := config.Get().SteamConfig.QueryWorkers
+	if n <= 0 {
+		n = config.Get().SteamConfig.MaxConcurrentQueries
+	}
+	if n <= 0 || n > hosts {
+		n = hosts
+	}
+	return n
+}
+
+// forEachHost calls fn for each of the hosts from a bounded pool of worker
+// goroutines, returning once all of the calls have returned.
+func forEachHost(hosts []string, fn func(host string)) {
+	ch := make(chan string)
+	var wg sync.WaitGroup
+	workers := queryWorkers(len(hosts))
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for h := range ch {
+				fn(h)
+			}
+		}()
+	}
+	for _, h := range hosts {
+		ch <- h
+	}
+	close(ch)
+	wg.Wait()
+}
+
+// limitedInfoQuery performs an A2S_INFO request subject to the request limiter
+// and the host's query budget. No request is sent once ctx is cancelled.
+func limitedInfoQuery(ctx context.Context, host string,
+	budget *queryBudget) (models.SteamServerInfo, error) {
+	timeout, ok := budget.timeout(host)
+	if !ok {
+		return models.SteamServerInfo{}, ErrQueryBudget
+	}
+	l := getQueryLimiter()
+	if err := l.acquire(ctx); err != nil {
+		return models.SteamServerInfo{}, err
+	}
+	start := queryClock.Now()
+	info, err := GetInfoForServer(host, timeout)
+	d := queryClock.Since(start)
+	budget.spend(host, d)
+	a2sDurationMetric.Observe(d.Seconds(), reqTypeInfo)
+	failed := err != nil && err != ErrNoInfo
+	l.release(failed)
+	recordLatency(host, d, failed)
+	return info, err
+}
+
+// limitedPlayerQuery performs an A2S_PLAYER request subject to the request
+// limiter and the host's query budget.
+func limitedPlayerQuery(ctx context.Context, host string,
+	budget *queryBudget) ([]models.SteamPlayerInfo, error) {
+	timeout, ok := budget.timeout(host)
+	if !ok {
+		return nil, ErrQueryBudget
+	}
+	l := getQueryLimiter()
+	if err := l.acquire(ctx); err != nil {
+		return nil, err
+	}
+	start := queryClock.Now()
+	players, err := GetPlayersForServer(host, timeout)
+	d := queryClock.Since(start)
+	budget.spend(host, d)
+	a2sDurationMetric.Observe(d.Seconds(), reqTypePlayers)
+	failed := err != nil && err != ErrNoPlayers
+	l.release(failed)
+	recordLatency(host, d, failed)
+	return players, err
+}
+
+// limitedRulesQuery performs an A2S_RULES request subject to the request
+// limiter and the host's query budget.
+func limitedRulesQuery(ctx context.Context, host string,
+	budget *queryBudget) (map[string]string, error) {
+	timeout, ok := budget.timeout(host)
+	if !ok {
+		return nil, ErrQueryBudget
+	}
+	l := getQueryLimiter()
+	if err := l.acquire(ctx); err != nil {
+		return nil, err
+	}
+	start := queryClock.Now()
+	rules, err := GetRulesForServer(host, timeout)
+	d := queryClock.Since(start)
+	budget.spend(host, d)
+	a2sDurationMetric.Observe(d.Seconds(), reqTypeRules)
+	failed := err != nil && err != ErrNoRules
+	l.release(failed)
+	recordLatency(host, d, failed)
+	return rules, err
+}
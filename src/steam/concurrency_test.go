@@ -0,0 +1,151 @@
+package steam
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+)
+
+func simulateWindow(l *queryLimiter, failures int) {
+	for i := 0; i < tuneWindowSize; i++ {
+		l.acquire(context.Background())
+		l.release(i < failures)
+	}
+}
+
+func TestQueryLimiterTune(t *testing.T) {
+	l := newQueryLimiter(1000, 50, true)
+	// first window establishes a 10% baseline of dead hosts; limit stays at max
+	simulateWindow(l, 10)
+	if l.currentLimit() != 1000 {
+		t.Fatalf("Expected limit to remain at 1000, got: %d", l.currentLimit())
+	}
+	// failure rate well above baseline: multiplicative decrease
+	simulateWindow(l, 40)
+	if l.currentLimit() != 500 {
+		t.Fatalf("Expected limit to be halved to 500, got: %d", l.currentLimit())
+	}
+	simulateWindow(l, 40)
+	simulateWindow(l, 40)
+	simulateWindow(l, 40)
+	simulateWindow(l, 40)
+	if l.currentLimit() != 50 {
+		t.Fatalf("Expected limit to be bounded at minimum of 50, got: %d",
+			l.currentLimit())
+	}
+	// failure rate back at baseline: additive increase
+	simulateWindow(l, 10)
+	if l.currentLimit() != 70 {
+		t.Fatalf("Expected limit to increase additively to 70, got: %d",
+			l.currentLimit())
+	}
+}
+
+func TestQueryLimiterNoTune(t *testing.T) {
+	l := newQueryLimiter(100, 10, false)
+	simulateWindow(l, 90)
+	if l.currentLimit() != 100 {
+		t.Fatalf("Expected limit to be fixed at 100, got: %d", l.currentLimit())
+	}
+	unlimited := newQueryLimiter(0, 0, true)
+	for i := 0; i < 10; i++ {
+		// must not block
+		unlimited.acquire(context.Background())
+	}
+}
+
+func TestForEachHost(t *testing.T) {
+	orig := config.Get().SteamConfig
+	defer func() { config.Get().SteamConfig = orig }()
+	config.Get().SteamConfig.QueryWorkers = 4
+
+	hosts := make([]string, 50)
+	for i := range hosts {
+		hosts[i] = fmt.Sprintf("10.0.0.%d:27960", i)
+	}
+	var mu sync.Mutex
+	seen := make(map[string]int)
+	running, maxRunning := 0, 0
+	forEachHost(hosts, func(host string) {
+		mu.Lock()
+		seen[host]++
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+	})
+	if len(seen) != len(hosts) {
+		t.Fatalf("Expected %d hosts to be visited, got: %d", len(hosts), len(seen))
+	}
+	for h, n := range seen {
+		if n != 1 {
+			t.Fatalf("Expected %s to be visited once, got: %d", h, n)
+		}
+	}
+	if maxRunning > 4 {
+		t.Fatalf("Expected at most 4 workers, got: %d", maxRunning)
+	}
+
+	// without a worker setting, the in-flight limit bounds the workers
+	config.Get().SteamConfig.QueryWorkers = 0
+	config.Get().SteamConfig.MaxConcurrentQueries = 10
+	if n := queryWorkers(len(hosts)); n != 10 {
+		t.Fatalf("Expected 10 workers, got: %d", n)
+	}
+	if n := queryWorkers(3); n != 3 {
+		t.Fatalf("Expected no more workers than hosts, got: %d", n)
+	}
+}
+
+func TestQueryBudget(t *testing.T) {
+	var unlimited *queryBudget
+	if d, ok := unlimited.timeout("10.0.0.1:27960"); !ok || d != QueryTimeout {
+		t.Fatalf("Expected full timeout without a budget, got: %s (%v)", d, ok)
+	}
+	b := &queryBudget{limit: 5 * time.Second, spent: make(map[string]time.Duration)}
+	host := "10.0.0.1:27960"
+	b.spend(host, 2*time.Second)
+	if d, ok := b.timeout(host); !ok || d != QueryTimeout {
+		t.Fatalf("Expected full timeout with 3s left, got: %s (%v)", d, ok)
+	}
+	b.spend(host, 2*time.Second)
+	if d, ok := b.timeout(host); !ok || d != time.Second {
+		t.Fatalf("Expected 1s timeout with 1s left, got: %s (%v)", d, ok)
+	}
+	b.spend(host, 900*time.Millisecond)
+	if _, ok := b.timeout(host); ok {
+		t.Fatalf("Expected budget to be used up")
+	}
+	if _, err := limitedInfoQuery(context.Background(), host, b); err != ErrQueryBudget {
+		t.Fatalf("Expected query budget error, got: %v", err)
+	}
+	// other hosts are unaffected
+	if d, ok := b.timeout("10.0.0.2:27960"); !ok || d != QueryTimeout {
+		t.Fatalf("Expected full timeout for another host, got: %s (%v)", d, ok)
+	}
+}
+
+func TestLimitedQueryCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	b := &queryBudget{limit: 5 * time.Second, spent: make(map[string]time.Duration)}
+	host := "10.0.0.1:27960"
+	if _, err := limitedInfoQuery(ctx, host, b); err != context.Canceled {
+		t.Fatalf("Expected context canceled error, got: %v", err)
+	}
+	if b.spent[host] != 0 {
+		t.Fatalf("Expected no budget to be spent, got: %s", b.spent[host])
+	}
+	if m := RetryFailedInfoReq(ctx, []string{host}, QueryRetryCount, b); len(m) != 0 {
+		t.Fatalf("Expected no retries after cancellation, got: %v", m)
+	}
+}
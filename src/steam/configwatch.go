@@ -0,0 +1,94 @@
+package steam
+
+// configwatch.go - Reloads the configuration file when it changes or when the
+// process receives SIGHUP, so that the timed retrieval's games, intervals,
+// maximum hosts, and timeouts, and the API's limits can be changed without
+// restarting (see config/reload.go).
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/constants"
+	"github.com/syncore/a2sapi/src/logger"
+)
+
+// configReload is closed, and replaced, whenever the configuration is reloaded
+// with changes, which wakes the timed retrievals up to apply them.
+var configReload = struct {
+	sync.Mutex
+	ch chan struct{}
+}{
+	ch: make(chan struct{}),
+}
+
+// configReloaded returns a channel that is closed when the configuration is next
+// reloaded with changes.
+func configReloaded() <-chan struct{} {
+	configReload.Lock()
+	defer configReload.Unlock()
+	return configReload.ch
+}
+
+func notifyConfigReloaded() {
+	configReload.Lock()
+	defer configReload.Unlock()
+	close(configReload.ch)
+	configReload.ch = make(chan struct{})
+}
+
+// reloadConfig reloads the configuration file, keeping the running
+// configuration if the file is invalid, and logs the options that were applied
+// and those whose changes require a restart.
+func reloadConfig() error {
+	applied, restart, err := config.ReloadConfig()
+	if err != nil {
+		return logger.LogAppErrorf(
+			"Unable to reload configuration file %s, keeping the running configuration: %s",
+			constants.GetCfgPath(), err)
+	}
+	if len(restart) > 0 {
+		logger.LogAppInfo(
+			"Configuration options changed that only take effect after a restart: %s",
+			strings.Join(restart, ", "))
+	}
+	if len(applied) > 0 {
+		logger.LogAppInfo("Reloaded configuration options: %s",
+			strings.Join(applied, ", "))
+		notifyConfigReloaded()
+	}
+	return nil
+}
+
+// WatchConfigFile reloads the configuration file whenever a signal is received
+// on reload (i.e. SIGHUP), and, if interval is greater than 0, when the file has
+// changed, which is checked every interval, until ctx is cancelled.
+func WatchConfigFile(ctx context.Context, interval time.Duration,
+	reload <-chan os.Signal) {
+	last := statFile(constants.GetCfgPath())
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-tick:
+			s := statFile(constants.GetCfgPath())
+			if s == last {
+				continue
+			}
+			last = s
+		case <-reload:
+			last = statFile(constants.GetCfgPath())
+		case <-ctx.Done():
+			return
+		}
+		reloadConfig()
+	}
+}
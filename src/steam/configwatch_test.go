@@ -0,0 +1,86 @@
+package steam
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/constants"
+)
+
+func TestWatchConfigFile(t *testing.T) {
+	orig, err := ioutil.ReadFile(constants.GetCfgPath())
+	if err != nil {
+		t.Fatalf("Unable to read configuration file: %s", err)
+	}
+	origCfg := config.Get()
+	defer func() {
+		ioutil.WriteFile(constants.GetCfgPath(), orig, 0644)
+		config.Set(origCfg)
+	}()
+	writeCfg := func(change func(cfg *config.Cfg)) {
+		cfg := *origCfg
+		change(&cfg)
+		data, err := json.Marshal(&cfg)
+		if err != nil {
+			t.Fatalf("Unable to encode configuration: %s", err)
+		}
+		if err := ioutil.WriteFile(constants.GetCfgPath(), data, 0644); err != nil {
+			t.Fatalf("Unable to write configuration file: %s", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hup := make(chan os.Signal, 1)
+	go WatchConfigFile(ctx, 0, hup)
+	// the configuration is read while it is replaced
+	go func() {
+		for ctx.Err() == nil {
+			_ = config.Get().WebConfig.MaximumHostsPerAPIQuery
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	writeCfg(func(cfg *config.Cfg) {
+		cfg.SteamConfig.TimeBetweenMasterQueries = 17
+		cfg.WebConfig.MaximumHostsPerAPIQuery = 9
+		cfg.WebConfig.MaxConcurrentRequests = 7
+		// requires a restart, so isn't applied
+		cfg.WebConfig.APIWebPort = origCfg.WebConfig.APIWebPort + 1
+	})
+	reloaded := configReloaded()
+	hup <- syscall.SIGHUP
+	select {
+	case <-reloaded:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Expected the configuration to be reloaded on SIGHUP")
+	}
+	if config.Get().SteamConfig.TimeBetweenMasterQueries != 17 ||
+		config.Get().WebConfig.MaximumHostsPerAPIQuery != 9 ||
+		config.Get().WebConfig.MaxConcurrentRequests != 7 {
+		t.Fatalf("Expected the reloadable options to be applied, got: %+v, %+v",
+			config.Get().SteamConfig, config.Get().WebConfig)
+	}
+	if config.Get().WebConfig.APIWebPort != origCfg.WebConfig.APIWebPort {
+		t.Fatalf("Expected the web port to require a restart, got: %d",
+			config.Get().WebConfig.APIWebPort)
+	}
+
+	// an invalid file keeps the running configuration
+	writeCfg(func(cfg *config.Cfg) {
+		cfg.SteamConfig.TimeBetweenMasterQueries = 0
+	})
+	if err := reloadConfig(); err == nil {
+		t.Fatalf("Expected an error reloading an invalid configuration")
+	}
+	if config.Get().SteamConfig.TimeBetweenMasterQueries != 17 {
+		t.Fatalf("Expected the running configuration to be kept, got: %d",
+			config.Get().SteamConfig.TimeBetweenMasterQueries)
+	}
+}
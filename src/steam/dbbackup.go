@@ -0,0 +1,81 @@
+package steam
+
+// dbbackup.go - Scheduled backups of the server ID and application databases,
+// optionally uploaded to an output sink so that they survive the loss of the
+// host's disk.
+
+import (
+	"context"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/db"
+	"github.com/syncore/a2sapi/src/logger"
+)
+
+// ScheduleDBBackups backs up the databases every interval, until ctx is
+// cancelled.
+func ScheduleDBBackups(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		if err := backupDBs(); err != nil {
+			logger.LogAppErrorf("Database backup failed: %s", err)
+		}
+	}
+}
+
+// backupDBs makes a backup of the databases in the configured directory,
+// uploads it to the configured target, if any, and deletes the oldest backups
+// beyond the configured number to keep.
+func backupDBs() error {
+	cfg := config.Get().AdminConfig
+	dir, err := db.BackupDBs(cfg.DBBackupDirectory)
+	if err != nil {
+		return err
+	}
+	logger.LogAppInfo("Backed up the databases to %s", dir)
+	if cfg.DBBackupTarget != "" {
+		if err := uploadBackup(cfg.DBBackupTarget, dir); err != nil {
+			return err
+		}
+	}
+	if cfg.DBBackupRetention > 0 {
+		return db.PruneBackups(cfg.DBBackupDirectory, cfg.DBBackupRetention)
+	}
+	return nil
+}
+
+// uploadBackup writes the files of the backup directory to the output sink at
+// target, under the backup's name.
+func uploadBackup(target, dir string) error {
+	sink, err := NewOutputSink(target)
+	if err != nil {
+		return err
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		data, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return err
+		}
+		if err := sink.Write(path.Join(filepath.Base(dir), f.Name()),
+			"application/vnd.sqlite3", data); err != nil {
+			return err
+		}
+	}
+	logger.LogAppInfo("Uploaded database backup %s to %s", filepath.Base(dir),
+		target)
+	return nil
+}
@@ -0,0 +1,64 @@
+package steam
+
+// derived.go - Fields derived from a server's A2S results (i.e: its free slots
+// and spectators), so that API consumers can filter on them directly.
+
+import (
+	"strings"
+
+	"github.com/syncore/a2sapi/src/models"
+	"github.com/syncore/a2sapi/src/steam/filters"
+)
+
+// spectatorCounter returns the number of spectators on a server from its
+// (enriched) players and rules, and whether it could be determined.
+type spectatorCounter func(players []models.SteamPlayerInfo,
+	rules map[string]string) (int, bool)
+
+// spectatorCounters are the counters of the games that expose their
+// spectators, by lower case game name.
+var spectatorCounters = map[string]spectatorCounter{
+	strings.ToLower(filters.GameQuakeLive.Name): countQLSpectators,
+}
+
+// setDerivedFields sets the fields of a server that are derived from its info,
+// players, and rules.
+func setDerivedFields(game filters.Game, srv *models.APIServer) {
+	humans := int(srv.Info.Players) - int(srv.Info.Bots)
+	if humans < 0 {
+		humans = 0
+	}
+	srv.FreeSlots = int(srv.Info.MaxPlayers) - humans
+	if srv.FreeSlots < 0 {
+		srv.FreeSlots = 0
+	}
+	srv.PasswordProtected = srv.Info.Visibility == 1
+	srv.Spectators = nil
+	if count, ok := spectatorCounters[strings.ToLower(game.Name)]; ok {
+		if n, known := count(srv.Players, srv.Rules); known {
+			srv.Spectators = &n
+		}
+	}
+}
+
+// countQLSpectators counts the Quake Live players that are not on a team. The
+// count is only known in team gametypes, whose servers have team rules.
+func countQLSpectators(players []models.SteamPlayerInfo,
+	rules map[string]string) (int, bool) {
+	teamGame := false
+	for rule := range qlTeamRules {
+		if _, ok := rules[rule]; ok {
+			teamGame = true
+		}
+	}
+	if !teamGame {
+		return 0, false
+	}
+	n := 0
+	for _, p := range players {
+		if p.Extra["team"] == "" {
+			n++
+		}
+	}
+	return n, true
+}
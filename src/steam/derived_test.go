@@ -0,0 +1,53 @@
+package steam
+
+import (
+	"testing"
+
+	"github.com/syncore/a2sapi/src/models"
+	"github.com/syncore/a2sapi/src/steam/filters"
+)
+
+func TestSetDerivedFields(t *testing.T) {
+	srv := models.APIServer{Info: models.SteamServerInfo{Players: 10,
+		MaxPlayers: 16, Bots: 4, Visibility: 1}}
+	setDerivedFields(filters.GameTF2, &srv)
+	if srv.FreeSlots != 10 {
+		t.Errorf("Expected 10 free slots, got: %d", srv.FreeSlots)
+	}
+	if !srv.PasswordProtected {
+		t.Errorf("Expected server to be password protected")
+	}
+	if srv.Spectators != nil {
+		t.Errorf("Expected unknown spectators for game without counter, got: %d",
+			*srv.Spectators)
+	}
+
+	// servers reporting more human players than slots have no free slots
+	srv = models.APIServer{Info: models.SteamServerInfo{Players: 18,
+		MaxPlayers: 16}}
+	setDerivedFields(filters.GameTF2, &srv)
+	if srv.FreeSlots != 0 || srv.PasswordProtected {
+		t.Errorf("Expected 0 free slots and no password, got: %d, %v",
+			srv.FreeSlots, srv.PasswordProtected)
+	}
+}
+
+func TestCountQLSpectators(t *testing.T) {
+	players := []models.SteamPlayerInfo{{Name: "alpha"}, {Name: "bravo"},
+		{Name: "charlie"}, {Name: "delta"}}
+	rules := map[string]string{"players_red": "alpha", "players_blue": "bravo"}
+	srv := models.APIServer{Players: enrichPlayers(filters.GameQuakeLive,
+		players, rules), Rules: rules}
+	setDerivedFields(filters.GameQuakeLive, &srv)
+	if srv.Spectators == nil || *srv.Spectators != 2 {
+		t.Fatalf("Expected 2 spectators, got: %v", srv.Spectators)
+	}
+
+	// spectators are unknown in gametypes without teams
+	srv = models.APIServer{Players: players, Rules: map[string]string{}}
+	setDerivedFields(filters.GameQuakeLive, &srv)
+	if srv.Spectators != nil {
+		t.Errorf("Expected unknown spectators without team rules, got: %d",
+			*srv.Spectators)
+	}
+}
@@ -0,0 +1,290 @@
+package steam
+
+// diagnose.go - Diagnosis of why a server isn't answering queries or isn't
+// listed, for server admins: the server is probed with each kind of A2S
+// request, with the normal and a longer timeout, and on its neighbouring ports.
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/syncore/a2sapi/src/models"
+)
+
+// Diagnosis probe names.
+const (
+	probeInfoNoChallenge = "infoNoChallenge"
+	probeInfo            = "info"
+	probeInfoLongTimeout = "infoLongTimeout"
+	probePlayers         = "players"
+	probeRules           = "rules"
+	probeInfoPortBelow   = "infoPortBelow"
+	probeInfoPortAbove   = "infoPortAbove"
+)
+
+// The timeouts of the diagnosis probes: the one that retrievals use, and a
+// longer one that determines whether a server is only slow to respond.
+var (
+	diagnoseTimeout     = QueryTimeout
+	diagnoseLongTimeout = 3 * QueryTimeout
+)
+
+// probeError describes a failed probe's error.
+func probeError(err error, timeout time.Duration) string {
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return fmt.Sprintf("no response within %s", timeout)
+	}
+	return err.Error()
+}
+
+// sendInfoProbe sends an A2S_INFO request to host and, if challenge is true and the
+// server replies with a challenge, re-sends it with the challenge. Host errors
+// are not logged, since the neighbouring ports that are probed are usually not
+// servers.
+func sendInfoProbe(name, host string, timeout time.Duration,
+	challenge bool) (models.APIDiagnosisProbe, *models.SteamServerInfo) {
+	p := models.APIDiagnosisProbe{Name: name, Host: host,
+		TimeoutMs: int(timeout / time.Millisecond)}
+	start := queryClock.Now()
+	conn, err := queryDialer.Dial(host, timeout)
+	if err != nil {
+		p.Error = err.Error()
+		return p, nil
+	}
+	defer conn.Close()
+	conn.SetDeadline(queryClock.Now().Add(timeout))
+
+	var buf [maxPacketSize]byte
+	if _, err = conn.Write(infoChallengeReq); err != nil {
+		p.Error = err.Error()
+		return p, nil
+	}
+	n, err := conn.Read(buf[:])
+	if err != nil {
+		p.Error = probeError(err, timeout)
+		return p, nil
+	}
+	if bytes.HasPrefix(buf[:n], expectedInfoChallengeHeader) {
+		p.Challenged = true
+		if !challenge {
+			p.Responded = true
+			p.Ping = int(queryClock.Since(start) / time.Millisecond)
+			p.Detail = "replied with a challenge"
+			return p, nil
+		}
+		hl := len(expectedInfoChallengeHeader)
+		if n < hl+4 {
+			p.Responded = true
+			p.Error = ErrChallengeResponse.Error()
+			return p, nil
+		}
+		request := append(append([]byte{}, infoChallengeReq...), buf[hl:hl+4]...)
+		if _, err = conn.Write(request); err != nil {
+			p.Error = err.Error()
+			return p, nil
+		}
+		if n, err = conn.Read(buf[:]); err != nil {
+			p.Error = probeError(err, timeout)
+			return p, nil
+		}
+	}
+	p.Responded = true
+	p.Ping = int(queryClock.Since(start) / time.Millisecond)
+	info, _, err := parseServerInfo(buf[:n], true)
+	if err != nil {
+		p.Error = err.Error()
+		return p, nil
+	}
+	p.Detail = fmt.Sprintf("%s: %s, %d/%d players", info.Name, info.Map,
+		info.Players, info.MaxPlayers)
+	return p, &info
+}
+
+// probeRequest makes an A2S_PLAYER or A2S_RULES request with do, which returns
+// a description of the result; empty is the error returned for a server that
+// responded but has nothing to report.
+func probeRequest(name, host string, timeout time.Duration,
+	do func() (string, error), empty error) models.APIDiagnosisProbe {
+	p := models.APIDiagnosisProbe{Name: name, Host: host,
+		TimeoutMs: int(timeout / time.Millisecond)}
+	start := queryClock.Now()
+	detail, err := do()
+	if err != nil && err != empty {
+		p.Error = err.Error()
+		return p
+	}
+	p.Responded = true
+	p.Ping = int(queryClock.Since(start) / time.Millisecond)
+	p.Detail = detail
+	return p
+}
+
+// neighbourPort returns host with its port changed by delta; an empty string if
+// the port would be out of range.
+func neighbourPort(host string, delta int) string {
+	ip, port, err := net.SplitHostPort(host)
+	if err != nil {
+		return ""
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil || p+delta < 1 || p+delta > 65535 {
+		return ""
+	}
+	return net.JoinHostPort(ip, strconv.Itoa(p+delta))
+}
+
+// listedGame returns the game whose current server list includes host.
+func listedGame(host string) (string, bool) {
+	sl := models.GetMasterList()
+	if sl == nil {
+		return "", false
+	}
+	for _, s := range sl.Servers {
+		if s.Host == host {
+			return s.Game, true
+		}
+	}
+	return "", false
+}
+
+// DiagnoseHost probes host (ip:port) with A2S_INFO with and without a
+// challenge, with the normal and a longer timeout, and on the neighbouring
+// ports, and with A2S_PLAYER and A2S_RULES, and returns the probes along with
+// an explanation of why the server may not be answering queries or may not be
+// listed. The probes are made at the same time; ctx only prevents them from
+// being started.
+func DiagnoseHost(ctx context.Context, host string) (*models.APIDiagnosis, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	type probeFunc func() (models.APIDiagnosisProbe, *models.SteamServerInfo)
+	info := func(name, host string, timeout time.Duration,
+		challenge bool) probeFunc {
+		return func() (models.APIDiagnosisProbe, *models.SteamServerInfo) {
+			return sendInfoProbe(name, host, timeout, challenge)
+		}
+	}
+	funcs := []probeFunc{
+		info(probeInfoNoChallenge, host, diagnoseTimeout, false),
+		info(probeInfo, host, diagnoseTimeout, true),
+		info(probeInfoLongTimeout, host, diagnoseLongTimeout, true),
+		func() (models.APIDiagnosisProbe, *models.SteamServerInfo) {
+			return probeRequest(probePlayers, host, diagnoseTimeout,
+				func() (string, error) {
+					players, err := GetPlayersForServer(host, diagnoseTimeout)
+					return fmt.Sprintf("%d players", len(players)), err
+				}, ErrNoPlayers), nil
+		},
+		func() (models.APIDiagnosisProbe, *models.SteamServerInfo) {
+			return probeRequest(probeRules, host, diagnoseTimeout,
+				func() (string, error) {
+					rules, err := GetRulesForServer(host, diagnoseTimeout)
+					return fmt.Sprintf("%d rules", len(rules)), err
+				}, ErrNoRules), nil
+		},
+	}
+	if h := neighbourPort(host, -1); h != "" {
+		funcs = append(funcs, info(probeInfoPortBelow, h, diagnoseTimeout, true))
+	}
+	if h := neighbourPort(host, 1); h != "" {
+		funcs = append(funcs, info(probeInfoPortAbove, h, diagnoseTimeout, true))
+	}
+
+	d := &models.APIDiagnosis{Host: host,
+		Probes: make([]models.APIDiagnosisProbe, len(funcs))}
+	infos := make([]*models.SteamServerInfo, len(funcs))
+	var wg sync.WaitGroup
+	for i, f := range funcs {
+		wg.Add(1)
+		go func(i int, f probeFunc) {
+			defer wg.Done()
+			d.Probes[i], infos[i] = f()
+		}(i, f)
+	}
+	wg.Wait()
+
+	probes := make(map[string]models.APIDiagnosisProbe, len(d.Probes))
+	for i, p := range d.Probes {
+		probes[p.Name] = p
+		if d.Info == nil && infos[i] != nil && p.Host == host {
+			d.Info = infos[i]
+		}
+	}
+	d.ListedGame, d.Listed = listedGame(host)
+	diagnose(d, probes)
+	return d, nil
+}
+
+// answered determines whether a probe received a valid response.
+func answered(p models.APIDiagnosisProbe) bool {
+	return p.Responded && p.Error == ""
+}
+
+// diagnose sets the diagnosis' status and findings from its probes.
+func diagnose(d *models.APIDiagnosis, probes map[string]models.APIDiagnosisProbe) {
+	_, port, _ := net.SplitHostPort(d.Host)
+	short, long := probes[probeInfo], probes[probeInfoLongTimeout]
+	if !answered(short) && !answered(long) {
+		d.Status = models.DiagnosisUnreachable
+		if probes[probeInfoNoChallenge].Responded || short.Responded ||
+			long.Responded {
+			d.Findings = append(d.Findings, fmt.Sprintf(
+				"Something responded on port %s, but not with a valid A2S_INFO response; it may not be the server's query port.",
+				port))
+		} else {
+			d.Findings = append(d.Findings, fmt.Sprintf(
+				"The server did not answer A2S_INFO on port %s, even within %s. It may be offline, or a firewall or NAT may be blocking UDP traffic to its query port.",
+				port, diagnoseLongTimeout))
+		}
+		for _, name := range []string{probeInfoPortBelow, probeInfoPortAbove} {
+			if p, ok := probes[name]; ok && answered(p) {
+				_, other, _ := net.SplitHostPort(p.Host)
+				d.Findings = append(d.Findings, fmt.Sprintf(
+					"A server answered A2S_INFO on port %s. If it is the same server, its query port is %s rather than %s; query %s instead.",
+					other, other, port, p.Host))
+			}
+		}
+	} else {
+		d.Status = models.DiagnosisOK
+		if !answered(short) {
+			d.Status = models.DiagnosisSlow
+			d.Findings = append(d.Findings, fmt.Sprintf(
+				"The server only answered A2S_INFO within the longer %s timeout (in %dms). Retrievals allow %s for each request, so the server may be missing from some lists.",
+				diagnoseLongTimeout, long.Ping, diagnoseTimeout))
+		}
+		if probes[probeInfoNoChallenge].Challenged {
+			d.Findings = append(d.Findings,
+				"The server requires a challenge for A2S_INFO (Valve's 2020 protocol change). This is supported, but older query tools won't see the server.")
+		}
+		for _, r := range []struct{ name, request, missing string }{
+			{probePlayers, "A2S_PLAYER", "player list"},
+			{probeRules, "A2S_RULES", "rules"},
+		} {
+			if p := probes[r.name]; !p.Responded {
+				if d.Status == models.DiagnosisOK {
+					d.Status = models.DiagnosisPartial
+				}
+				d.Findings = append(d.Findings, fmt.Sprintf(
+					"The server did not answer %s (%s), so its %s will be missing. Some games let server admins disable these queries.",
+					r.request, p.Error, r.missing))
+			}
+		}
+		if d.Status == models.DiagnosisOK {
+			d.Findings = append(d.Findings,
+				"The server answered every request; no problems were found with its queries.")
+		}
+	}
+	switch {
+	case d.Listed:
+		d.Findings = append(d.Findings, fmt.Sprintf(
+			"The server is in the current %s server list.", d.ListedGame))
+	case d.Status != models.DiagnosisUnreachable:
+		d.Findings = append(d.Findings,
+			"The server is not in the current server list. It may not be registered with the master server (i.e. it is a LAN server or isn't sending heartbeats), its game may not be retrieved, or the game's filters may exclude it.")
+	}
+}
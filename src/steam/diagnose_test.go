@@ -0,0 +1,136 @@
+package steam
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/syncore/a2sapi/src/models"
+)
+
+func TestDiagnoseHost(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %s", err)
+	}
+	defer conn.Close()
+	challenge := []byte{0x01, 0x02, 0x03, 0x04}
+	info := append(append([]byte{}, expectedInfoRespHeader...), 0x11,
+		'a', 0x00, 'b', 0x00, 'c', 0x00, 'd', 0x00, 0x01, 0x00, 0x03, 0x10, 0x00,
+		'd', 'l', 0x00, 0x00, '1', 0x00, 0x00)
+	playersReq := append([]byte{0xFF, 0xFF, 0xFF, 0xFF, 0x55}, challenge...)
+	go func() {
+		buf := make([]byte, maxPacketSize)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			// requires a challenge for info, answers players, ignores rules
+			switch {
+			case bytes.Equal(buf[:n], infoChallengeReq),
+				bytes.Equal(buf[:n], playerChallengeReq):
+				conn.WriteTo(append(append([]byte{}, expectedInfoChallengeHeader...),
+					challenge...), addr)
+			case bytes.Equal(buf[:n], append(append([]byte{}, infoChallengeReq...),
+				challenge...)):
+				conn.WriteTo(info, addr)
+			case bytes.Equal(buf[:n], playersReq):
+				conn.WriteTo(append(append([]byte{}, expectedPlayerChunkHeader...),
+					0x00), addr)
+			}
+		}
+	}()
+	defer func(short, long time.Duration) {
+		diagnoseTimeout, diagnoseLongTimeout = short, long
+	}(diagnoseTimeout, diagnoseLongTimeout)
+	diagnoseTimeout, diagnoseLongTimeout = 200*time.Millisecond,
+		400*time.Millisecond
+
+	host := conn.LocalAddr().String()
+	d, err := DiagnoseHost(context.Background(), host)
+	if err != nil {
+		t.Fatalf("Unexpected error diagnosing server: %s", err)
+	}
+	if d.Status != models.DiagnosisPartial {
+		t.Fatalf("Expected status %s, got: %+v", models.DiagnosisPartial, d)
+	}
+	if d.Info == nil || d.Info.Map != "b" {
+		t.Fatalf("Expected the server's info, got: %+v", d.Info)
+	}
+	probes := make(map[string]models.APIDiagnosisProbe)
+	for _, p := range d.Probes {
+		probes[p.Name] = p
+	}
+	if p := probes[probeInfoNoChallenge]; !p.Responded || !p.Challenged {
+		t.Fatalf("Expected a challenge without the challenge, got: %+v", p)
+	}
+	for _, name := range []string{probeInfo, probeInfoLongTimeout, probePlayers} {
+		if !answered(probes[name]) {
+			t.Fatalf("Expected probe %s to be answered, got: %+v", name,
+				probes[name])
+		}
+	}
+	if answered(probes[probeRules]) {
+		t.Fatalf("Expected the rules probe to fail, got: %+v", probes[probeRules])
+	}
+	findings := strings.Join(d.Findings, "\n")
+	for _, s := range []string{"requires a challenge", "A2S_RULES",
+		"not in the current server list"} {
+		if !strings.Contains(findings, s) {
+			t.Fatalf("Expected a finding about %q, got: %v", s, d.Findings)
+		}
+	}
+}
+
+func TestDiagnoseUnreachable(t *testing.T) {
+	d := &models.APIDiagnosis{Host: "10.0.0.1:27015"}
+	diagnose(d, map[string]models.APIDiagnosisProbe{
+		probeInfoNoChallenge: {Error: "no response within 2s"},
+		probeInfo:            {Error: "no response within 2s"},
+		probeInfoLongTimeout: {Error: "no response within 6s"},
+		probeInfoPortBelow:   {Host: "10.0.0.1:27014"},
+		probeInfoPortAbove:   {Host: "10.0.0.1:27016", Responded: true},
+	})
+	if d.Status != models.DiagnosisUnreachable || len(d.Findings) != 2 ||
+		!strings.Contains(d.Findings[1], "query 10.0.0.1:27016 instead") {
+		t.Fatalf("Expected the server to be unreachable on a different query port, got: %+v",
+			d)
+	}
+
+	d = &models.APIDiagnosis{Host: "10.0.0.1:27015", Listed: true,
+		ListedGame: "QuakeLive"}
+	diagnose(d, map[string]models.APIDiagnosisProbe{
+		probeInfoNoChallenge: {Responded: true},
+		probeInfo:            {Error: "no response within 2s"},
+		probeInfoLongTimeout: {Responded: true, Ping: 2500},
+		probePlayers:         {Responded: true},
+		probeRules:           {Responded: true},
+	})
+	if d.Status != models.DiagnosisSlow || len(d.Findings) != 2 ||
+		!strings.Contains(d.Findings[1], "current QuakeLive server list") {
+		t.Fatalf("Expected a slow, listed server, got: %+v", d)
+	}
+}
+
+func TestNeighbourPort(t *testing.T) {
+	for _, tt := range []struct {
+		host     string
+		delta    int
+		expected string
+	}{
+		{"10.0.0.1:27015", -1, "10.0.0.1:27014"},
+		{"10.0.0.1:27015", 1, "10.0.0.1:27016"},
+		{"10.0.0.1:1", -1, ""},
+		{"10.0.0.1:65535", 1, ""},
+		{"10.0.0.1", 1, ""},
+	} {
+		if h := neighbourPort(tt.host, tt.delta); h != tt.expected {
+			t.Errorf("Expected %q for %s%+d, got: %q", tt.expected, tt.host,
+				tt.delta, h)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package steam
+
+// errorlog.go - Sampling of the errors of querying individual hosts, so that a
+// retrieval with thousands of dead hosts logs a summary per class of error (i.e.
+// "timeout for 3,201 hosts, examples: ...") instead of thousands of lines.
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/util"
+)
+
+// classes of host errors
+const (
+	hostErrTimeout     = "timeout"
+	hostErrRefused     = "connection refused"
+	hostErrConnection  = "connection error"
+	hostErrTransmit    = "transmission error"
+	hostErrMultiPacket = "multi-packet error"
+	hostErrChallenge   = "invalid challenge response"
+	hostErrHeader      = "invalid packet header"
+	hostErrOther       = "other error"
+)
+
+// hostErrorExamples is the number of hosts listed in an error class' summary.
+const hostErrorExamples = 5
+
+var hostErrorsMetric = util.NewCounter("a2sapi_a2s_host_errors_total",
+	"Errors of A2S queries of individual hosts, by class of error.", "class")
+
+// hostErrorClass holds the errors of one class in the current window.
+type hostErrorClass struct {
+	count    int
+	logged   int
+	examples []string
+}
+
+var hostErrors = struct {
+	sync.Mutex
+	classes map[string]*hostErrorClass
+	// flushes the summaries at the end of the window, if not flushed before
+	flushTimer *time.Timer
+}{classes: make(map[string]*hostErrorClass)}
+
+// classifyHostError returns the class of an error of querying a host.
+func classifyHostError(err error) string {
+	var nerr net.Error
+	if errors.As(err, &nerr) && nerr.Timeout() {
+		return hostErrTimeout
+	}
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "timeout"):
+		return hostErrTimeout
+	case strings.Contains(msg, "connection refused"):
+		return hostErrRefused
+	case strings.Contains(msg, "multi-packet"):
+		return hostErrMultiPacket
+	case strings.Contains(msg, "host connection error"):
+		return hostErrConnection
+	case strings.Contains(msg, "data transmission error"):
+		return hostErrTransmit
+	case err == ErrChallengeResponse:
+		return hostErrChallenge
+	case err == ErrPacketHeader:
+		return hostErrHeader
+	}
+	return hostErrOther
+}
+
+// logHostError logs an error of querying a host. Only the first errors of each
+// class in a window are logged in full; the rest are counted and summarized by
+// flushHostErrors.
+func logHostError(host string, err error) {
+	class := classifyHostError(err)
+	hostErrorsMetric.Inc(class)
+	samples := config.Get().LogConfig.HostErrorLogSamples
+	if samples <= 0 {
+		logger.LogSteamErrorf("%s: %s", host, err)
+		return
+	}
+	hostErrors.Lock()
+	defer hostErrors.Unlock()
+	c := hostErrors.classes[class]
+	if c == nil {
+		c = &hostErrorClass{}
+		hostErrors.classes[class] = c
+	}
+	c.count++
+	if len(c.examples) < hostErrorExamples {
+		c.examples = append(c.examples, host)
+	}
+	if c.logged < samples {
+		c.logged++
+		logger.LogSteamErrorf("%s: %s", host, err)
+		return
+	}
+	if hostErrors.flushTimer == nil {
+		window := time.Duration(config.Get().LogConfig.HostErrorLogWindow) *
+			time.Second
+		if window <= 0 {
+			window = time.Minute
+		}
+		hostErrors.flushTimer = time.AfterFunc(window, flushHostErrors)
+	}
+}
+
+// flushHostErrors logs a summary of each class of host errors that were not
+// all logged in full, and starts a new window.
+func flushHostErrors() {
+	hostErrors.Lock()
+	classes := hostErrors.classes
+	hostErrors.classes = make(map[string]*hostErrorClass)
+	if hostErrors.flushTimer != nil {
+		hostErrors.flushTimer.Stop()
+		hostErrors.flushTimer = nil
+	}
+	hostErrors.Unlock()
+
+	names := make([]string, 0, len(classes))
+	for name, c := range classes {
+		if c.count > c.logged {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c := classes[name]
+		logger.LogSteamErrorf("%s for %s hosts (%d logged), examples: %s", name,
+			formatCount(c.count), c.logged, strings.Join(c.examples, ", "))
+	}
+}
+
+// formatCount formats a count with thousands separators, i.e. 3,201.
+func formatCount(n int) string {
+	s := strconv.Itoa(n)
+	if n < 0 {
+		return "-" + formatCount(-n)
+	}
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
@@ -0,0 +1,71 @@
+package steam
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/syncore/a2sapi/src/config"
+)
+
+func TestClassifyHostError(t *testing.T) {
+	for err, class := range map[error]string{
+		fakeTimeoutError{}: hostErrTimeout,
+		ErrDataTransmit("read udp 10.0.0.1:27960: i/o timeout"):             hostErrTimeout,
+		ErrDataTransmit("read udp 10.0.0.1:27960: connection refused"):      hostErrRefused,
+		ErrHostConnection("dial udp: lookup example.invalid: no such host"): hostErrConnection,
+		ErrDataTransmit(ErrMultiPacketIDMismatch.Error()):                   hostErrMultiPacket,
+		ErrChallengeResponse: hostErrChallenge,
+		ErrPacketHeader:      hostErrHeader,
+		ErrNoInfo:            hostErrOther,
+	} {
+		if c := classifyHostError(err); c != class {
+			t.Errorf("Expected '%s' to be a %s, got: %s", err, class, c)
+		}
+	}
+}
+
+func TestLogHostErrorSampling(t *testing.T) {
+	prev := config.Get().LogConfig
+	defer func() { config.Get().LogConfig = prev }()
+	config.Get().LogConfig.HostErrorLogSamples = 2
+	config.Get().LogConfig.HostErrorLogWindow = 60
+	flushHostErrors()
+
+	for i := 0; i < 3201; i++ {
+		logHostError(fmt.Sprintf("10.0.%d.%d:27960", i/256, i%256), fakeTimeoutError{})
+	}
+	logHostError("10.1.0.1:27960", ErrPacketHeader)
+
+	hostErrors.Lock()
+	timeouts, headers := *hostErrors.classes[hostErrTimeout],
+		*hostErrors.classes[hostErrHeader]
+	timer := hostErrors.flushTimer
+	hostErrors.Unlock()
+	if timeouts.count != 3201 || timeouts.logged != 2 ||
+		len(timeouts.examples) != hostErrorExamples {
+		t.Fatalf("Expected 3201 timeouts with 2 logged in full and %d examples, got: %+v",
+			hostErrorExamples, timeouts)
+	}
+	if headers.count != 1 || headers.logged != 1 {
+		t.Fatalf("Expected 1 header error logged in full, got: %+v", headers)
+	}
+	if timer == nil {
+		t.Fatalf("Expected the summaries to be flushed at the end of the window")
+	}
+
+	flushHostErrors()
+	hostErrors.Lock()
+	defer hostErrors.Unlock()
+	if len(hostErrors.classes) != 0 || hostErrors.flushTimer != nil {
+		t.Fatalf("Expected a new window after flushing")
+	}
+}
+
+func TestFormatCount(t *testing.T) {
+	for n, s := range map[int]string{0: "0", 999: "999", 3201: "3,201",
+		1234567: "1,234,567", -45000: "-45,000"} {
+		if f := formatCount(n); f != s {
+			t.Errorf("Expected %d to be formatted as %s, got: %s", n, s, f)
+		}
+	}
+}
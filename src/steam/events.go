@@ -0,0 +1,63 @@
+package steam
+
+// events.go - Recording of servers going offline and coming back online after
+// each timed retrieval, for the event feed and webhooks.
+
+import (
+	"sync"
+
+	"github.com/syncore/a2sapi/src/db"
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/models"
+)
+
+// offlineEventFailures is the number of consecutive failed retrievals after
+// which a known server is reported as having gone offline; a single missed
+// response is not worth an event.
+const offlineEventFailures = 2
+
+var (
+	// servers (by game and host) that have been reported as offline
+	reportedOffline   = make(map[string]bool)
+	reportedOfflineMu sync.Mutex
+)
+
+// serverEvents returns the events for the servers in a game's list that have
+// gone offline or have come back online since the previous retrievals.
+func serverEvents(game string, sl *models.APIServerList,
+	now int64) []models.DbServerEvent {
+	reportedOfflineMu.Lock()
+	defer reportedOfflineMu.Unlock()
+	var events []models.DbServerEvent
+	for _, s := range sl.OfflineServers {
+		key := game + "|" + s.Host
+		if s.ConsecutiveFailures < offlineEventFailures || reportedOffline[key] {
+			continue
+		}
+		reportedOffline[key] = true
+		events = append(events, models.DbServerEvent{ID: s.ID, Host: s.Host,
+			Game: game, Event: models.ServerEventOffline, Detail: s.Status,
+			Timestamp: now})
+	}
+	for _, s := range sl.Servers {
+		key := game + "|" + s.Host
+		if !reportedOffline[key] {
+			continue
+		}
+		delete(reportedOffline, key)
+		events = append(events, models.DbServerEvent{ID: s.ID, Host: s.Host,
+			Game: game, Event: models.ServerEventOnline, Timestamp: now})
+	}
+	return events
+}
+
+// recordServerEvents records the events of a game's servers for the event feed.
+func recordServerEvents(game string, events []models.DbServerEvent) {
+	if len(events) == 0 || db.AppDB == nil {
+		return
+	}
+	logger.LogSteamInfo("Recorded %d %s server events", len(events), game)
+	if err := db.AppDB.AddServerEvents(events); err != nil {
+		logger.LogAppError(err)
+	}
+}
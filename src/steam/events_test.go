@@ -0,0 +1,44 @@
+package steam
+
+import (
+	"testing"
+
+	"github.com/syncore/a2sapi/src/models"
+)
+
+func TestServerEvents(t *testing.T) {
+	game, host := "QuakeLive", "10.0.0.1:27960"
+	defer func() {
+		reportedOfflineMu.Lock()
+		delete(reportedOffline, game+"|"+host)
+		reportedOfflineMu.Unlock()
+	}()
+	offline := func(failures int) *models.APIServerList {
+		return &models.APIServerList{OfflineServers: []models.APIOfflineServer{
+			{ID: 1, Host: host, Game: game, ConsecutiveFailures: failures,
+				Status: models.ServerStatusOffline}}}
+	}
+	online := &models.APIServerList{Servers: []models.APIServer{{ID: 1, Host: host}}}
+	steps := []struct {
+		sl       *models.APIServerList
+		expected string
+	}{
+		{offline(1), ""},
+		{offline(2), models.ServerEventOffline},
+		{offline(3), ""},
+		{online, models.ServerEventOnline},
+		{online, ""},
+	}
+	for i, s := range steps {
+		events := serverEvents(game, s.sl, 1500000000)
+		if s.expected == "" {
+			if len(events) != 0 {
+				t.Errorf("Step %d: expected no events, got: %v", i, events)
+			}
+			continue
+		}
+		if len(events) != 1 || events[0].Event != s.expected {
+			t.Errorf("Step %d: expected %s event, got: %v", i, s.expected, events)
+		}
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/syncore/a2sapi/src/constants"
 	"github.com/syncore/a2sapi/src/util"
@@ -23,6 +25,19 @@ type Game struct {
 	IgnoreRules   bool `json:"ignoreRules"`
 	IgnorePlayers bool `json:"ignorePlayers"`
 	IgnoreInfo    bool `json:"ignoreInfo"`
+	// Games that run their own (community) master servers can specify the
+	// provider of their server list (see steam/masterprovider.go) and its address;
+	// if empty, the Steam master server or Steam Web API is used
+	MasterProvider string `json:"masterProvider,omitempty"`
+	MasterAddress  string `json:"masterAddress,omitempty"`
+	// decoder of the game's player names into their clean form (see
+	// steam/playernames.go); if empty, the game's default is used
+	PlayerNameDecoder string `json:"playerNameDecoder,omitempty"`
+	// limits on the A2S_RULES kept for each of the game's servers (see
+	// steam/rulelimits.go); if 0, the configured limits are used, and if
+	// negative, there is no limit
+	MaxRules      int `json:"maxRules,omitempty"`
+	MaxRulesBytes int `json:"maxRulesBytes,omitempty"`
 }
 
 // GameList represents the list of games.
@@ -30,6 +45,14 @@ type GameList struct {
 	Games []Game `json:"games"`
 }
 
+var (
+	// the game definitions ([]Game) read from the game file; replaced as a whole
+	// when the file is reloaded, so readers never see a partial update
+	loadedGames atomic.Value
+	// serializes reads of the game file
+	gamesFileMu sync.Mutex
+)
+
 // A few default games, additional games can be added from https://steamdb.info/apps/
 var (
 	// GameAlienSwarm Alien Swarm
@@ -235,29 +258,61 @@ func NewGame(name string, appid uint64, ignoreRules, ignorePlayers,
 	}
 }
 
-// ReadGames reads the game file from disk and returns a slice to a pointer of
-// Game structs if successful, otherwise panics.
+// ReadGames returns the games, which are read from the game file on first use
+// and replaced when the file is reloaded with ReloadGames. The returned slice
+// must not be modified. Panics if the game file can't be read initially.
 func ReadGames() []Game {
-	var f *os.File
-	var err error
-	f, err = os.Open(constants.GameFileFullPath)
-	if err != nil {
+	if games, ok := loadedGames.Load().([]Game); ok {
+		return games
+	}
+	gamesFileMu.Lock()
+	defer gamesFileMu.Unlock()
+	if games, ok := loadedGames.Load().([]Game); ok {
+		return games
+	}
+	if !util.FileExists(constants.GameFileFullPath) {
 		// try to create
 		DumpDefaultGames()
-		// re-open
-		f, err = os.Open(constants.GameFileFullPath)
-		if err != nil {
-			panic(fmt.Sprintf("Error reading games file file: %s\n", err))
-		}
+	}
+	games, err := readGameFile()
+	if err != nil {
+		panic(fmt.Sprintf("Error reading games file: %s\n", err))
+	}
+	loadedGames.Store(games)
+	return games
+}
+
+// ReloadGames reads the game file again and, if it is valid and defines at least
+// one game, atomically replaces the games with its contents. Otherwise the games
+// are left unchanged and the error is returned.
+func ReloadGames() ([]Game, error) {
+	gamesFileMu.Lock()
+	defer gamesFileMu.Unlock()
+	games, err := readGameFile()
+	if err != nil {
+		return nil, err
+	}
+	// most likely a file that is still being written
+	if len(games) == 0 {
+		return nil, fmt.Errorf("no games are defined in the games file")
+	}
+	loadedGames.Store(games)
+	return games, nil
+}
+
+// readGameFile reads and decodes the game file.
+func readGameFile() ([]Game, error) {
+	f, err := os.Open(constants.GameFileFullPath)
+	if err != nil {
+		return nil, err
 	}
 	defer f.Close()
-	r := bufio.NewReader(f)
-	d := json.NewDecoder(r)
+	d := json.NewDecoder(bufio.NewReader(f))
 	games := GameList{}
 	if err := d.Decode(&games); err != nil {
-		panic(fmt.Sprintf("Error decoding games file file: %s\n", err))
+		return nil, fmt.Errorf("unable to decode games file: %s", err)
 	}
-	return games.Games
+	return games.Games, nil
 }
 
 // DumpDefaultGames writes the default struct containing the default games to disk
@@ -0,0 +1,77 @@
+package steam
+
+// gamewatch.go - Reloads the games file when it changes, so that games can be
+// added or changed without restarting.
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/syncore/a2sapi/src/constants"
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/steam/filters"
+)
+
+// fileStamp identifies a version of a file.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// statFile returns the current version of a file; the zero value if it can't
+// be read.
+func statFile(path string) fileStamp {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{modTime: fi.ModTime(), size: fi.Size()}
+}
+
+func gamesFileStamp() fileStamp {
+	return statFile(constants.GameFileFullPath)
+}
+
+// reloadGames reloads the games file, keeping the previous games if the file is
+// invalid.
+func reloadGames() error {
+	games, err := filters.ReloadGames()
+	if err != nil {
+		return logger.LogAppErrorf(
+			"Unable to reload games file %s, keeping the previous games: %s",
+			constants.GameFileFullPath, err)
+	}
+	logger.LogAppInfo("Reloaded %d games from %s", len(games),
+		constants.GameFileFullPath)
+	return nil
+}
+
+// WatchGamesFile checks the games file for changes every interval, and reloads
+// the games when it has changed, until ctx is cancelled. A file that is invalid
+// (i.e. while it is being edited) is logged and reloaded once it changes again.
+func WatchGamesFile(ctx context.Context, interval time.Duration) {
+	last := gamesFileStamp()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		if s := gamesFileStamp(); s != last {
+			last = s
+			reloadGames()
+		}
+	}
+}
+
+// currentGame returns the current definition of a game, which may have been
+// changed by a reload of the games file since the game was looked up.
+func currentGame(game filters.Game) filters.Game {
+	if g := filters.GetGameByName(game.Name); g != filters.GameUnspecified {
+		return g
+	}
+	return game
+}
@@ -0,0 +1,59 @@
+package steam
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/syncore/a2sapi/src/constants"
+	"github.com/syncore/a2sapi/src/steam/filters"
+)
+
+func TestWatchGamesFile(t *testing.T) {
+	filters.ReadGames()
+	orig, err := ioutil.ReadFile(constants.GameFileFullPath)
+	if err != nil {
+		t.Fatalf("Unable to read games file: %s", err)
+	}
+	defer func() {
+		ioutil.WriteFile(constants.GameFileFullPath, orig, 0644)
+		filters.ReloadGames()
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go WatchGamesFile(ctx, 10*time.Millisecond)
+	waitForGame := func(name string, expected bool) {
+		deadline := time.Now().Add(2 * time.Second)
+		for filters.IsValidGame(name) != expected {
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected game %s to be valid: %v", name, expected)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// give the watcher time to take its initial stamp
+	time.Sleep(30 * time.Millisecond)
+	if err := ioutil.WriteFile(constants.GameFileFullPath, []byte(
+		`{"games": [{"name": "ReloadedGame", "appID": 12345}]}`), 0644); err != nil {
+		t.Fatalf("Unable to write games file: %s", err)
+	}
+	waitForGame("ReloadedGame", true)
+	if g := filters.GetGameByAppID(12345); g.Name != "ReloadedGame" {
+		t.Fatalf("Expected reloaded game by app ID, got: %+v", g)
+	}
+
+	// an invalid file keeps the previous games
+	if err := ioutil.WriteFile(constants.GameFileFullPath, []byte(`{"games": [`),
+		0644); err != nil {
+		t.Fatalf("Unable to write games file: %s", err)
+	}
+	if err := reloadGames(); err == nil {
+		t.Fatalf("Expected error reloading invalid games file")
+	}
+	if !filters.IsValidGame("ReloadedGame") {
+		t.Fatalf("Expected previous games to be kept after invalid reload")
+	}
+}
@@ -0,0 +1,172 @@
+package steam
+
+// geoipjob.go - Re-resolves the locations of the current servers when the GeoIP
+// database is updated, instead of waiting for each game's next retrieval.
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/syncore/a2sapi/src/db"
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/models"
+)
+
+// GeoIPJob represents the state of the last GeoIP re-enrichment job.
+type GeoIPJob struct {
+	Running bool `json:"running"`
+	// unix timestamps; 0 if the job has not started or finished
+	Started  int64 `json:"started"`
+	Finished int64 `json:"finished"`
+	// servers whose locations were looked up again, and the games they are in
+	Servers int `json:"servers"`
+	Games   int `json:"games"`
+	// empty unless the job failed
+	Error string `json:"error,omitempty"`
+}
+
+var geoIPJob = struct {
+	sync.Mutex
+	job GeoIPJob
+	// closed when the running job finishes
+	done chan struct{}
+}{}
+
+// GetGeoIPJob returns the state of the running, or last, GeoIP re-enrichment
+// job.
+func GetGeoIPJob() GeoIPJob {
+	geoIPJob.Lock()
+	defer geoIPJob.Unlock()
+	return geoIPJob.job
+}
+
+// StartGeoIPJob reloads the GeoIP database and starts re-resolving the
+// locations of the servers in the current lists in the background. It returns
+// false if the job is already running.
+func StartGeoIPJob() bool {
+	geoIPJob.Lock()
+	defer geoIPJob.Unlock()
+	if geoIPJob.job.Running {
+		return false
+	}
+	geoIPJob.job = GeoIPJob{Running: true, Started: time.Now().Unix()}
+	geoIPJob.done = make(chan struct{})
+	go func(done chan struct{}) {
+		games, servers, err := reenrichLocations()
+		geoIPJob.Lock()
+		geoIPJob.job.Running = false
+		geoIPJob.job.Finished = time.Now().Unix()
+		geoIPJob.job.Games, geoIPJob.job.Servers = games, servers
+		if err != nil {
+			geoIPJob.job.Error = err.Error()
+		}
+		geoIPJob.Unlock()
+		close(done)
+	}(geoIPJob.done)
+	return true
+}
+
+// waitForGeoIPJob waits for the running GeoIP re-enrichment job, if any, to
+// finish.
+func waitForGeoIPJob() {
+	geoIPJob.Lock()
+	done := geoIPJob.done
+	geoIPJob.Unlock()
+	if done != nil {
+		<-done
+	}
+}
+
+// reenrichLocations reloads the GeoIP database and looks up the locations of
+// the servers in each game's current list again. The updated lists replace the
+// current ones and the latest state table, unless a retrieval replaced the list
+// in the meantime (its locations are already from the reloaded database).
+func reenrichLocations() (games, servers int, err error) {
+	if db.CountryDB == nil {
+		return 0, 0, logger.LogAppErrorf("GeoIP re-enrichment: no country database")
+	}
+	if err := db.CountryDB.Reload(); err != nil {
+		return 0, 0, err
+	}
+	for game, sl := range models.GameLists() {
+		if sl == nil || len(sl.Servers) == 0 {
+			continue
+		}
+		updated := *sl
+		updated.Servers = make([]models.APIServer, len(sl.Servers))
+		copy(updated.Servers, sl.Servers)
+		enrichLocations(updated.Servers)
+
+		current := models.GetGameList(game)
+		if current == nil || current.CycleID != sl.CycleID ||
+			current.RetrievedTimeStamp != sl.RetrievedTimeStamp {
+			logger.LogAppInfo(
+				"GeoIP re-enrichment: %s list was replaced while running; skipping", game)
+			continue
+		}
+		models.SetGameList(game, &updated)
+		writeLatestState(game, &updated)
+		games++
+		servers += len(updated.Servers)
+	}
+	logger.LogAppInfo("GeoIP re-enrichment: updated the locations of %d servers in %d games",
+		servers, games)
+	return games, servers, nil
+}
+
+// geoIPFileStamps returns the versions of the GeoIP database files.
+func geoIPFileStamps() []fileStamp {
+	files := db.GeoIPFiles()
+	stamps := make([]fileStamp, len(files))
+	for i, f := range files {
+		if fi, err := os.Stat(f); err == nil {
+			stamps[i] = fileStamp{modTime: fi.ModTime(), size: fi.Size()}
+		}
+	}
+	return stamps
+}
+
+// WatchGeoIPFiles checks the GeoIP database files for updates every interval,
+// and starts the GeoIP re-enrichment job when they have changed, until ctx is
+// cancelled. A file that is being replaced is picked up on the next check once
+// it stops changing.
+func WatchGeoIPFiles(ctx context.Context, interval time.Duration) {
+	last := geoIPFileStamps()
+	var changed []fileStamp
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		s := geoIPFileStamps()
+		if fileStampsEqual(s, last) {
+			continue
+		}
+		// wait for the update (i.e. a download) to finish
+		if !fileStampsEqual(s, changed) {
+			changed = s
+			continue
+		}
+		if StartGeoIPJob() {
+			logger.LogAppInfo("GeoIP database files changed; re-resolving server locations")
+			last, changed = s, nil
+		}
+	}
+}
+
+func fileStampsEqual(a, b []fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,38 @@
+package steam
+
+import (
+	"testing"
+
+	"github.com/syncore/a2sapi/src/models"
+)
+
+func TestGeoIPJob(t *testing.T) {
+	game := "GeoIPJobTest"
+	// TEST-NET address; not in any GeoIP database
+	sl := &models.APIServerList{CycleID: "cycle-1", RetrievedTimeStamp: 1,
+		Servers: []models.APIServer{{Host: "192.0.2.1:27960", IP: "192.0.2.1",
+			CountryInfo: models.DbCountry{CountryCode: "SE", City: "Stockholm"}}}}
+	models.SetGameList(game, sl)
+	defer models.SetGameList(game, nil)
+
+	if !StartGeoIPJob() {
+		t.Fatalf("Expected GeoIP job to start")
+	}
+	waitForGeoIPJob()
+	job := GetGeoIPJob()
+	if job.Running || job.Finished == 0 || job.Error != "" {
+		t.Fatalf("Expected GeoIP job to have finished, got: %+v", job)
+	}
+	updated := models.GetGameList(game)
+	if c := updated.Servers[0].CountryInfo; c.CountryCode != "Unknown" ||
+		c.City != "" {
+		t.Fatalf("Expected location to be looked up again, got: %+v", c)
+	}
+	if updated.CycleID != sl.CycleID {
+		t.Fatalf("Expected the rest of the list to be kept, got cycle: %s",
+			updated.CycleID)
+	}
+	if sl.Servers[0].CountryInfo.CountryCode != "SE" {
+		t.Fatalf("Expected the previous list not to be modified")
+	}
+}
@@ -0,0 +1,63 @@
+package steam
+
+// health.go - Tracking of consecutive failures for known servers, so that
+// servers that go down can be reported instead of silently disappearing.
+
+import (
+	"time"
+
+	"github.com/syncore/a2sapi/src/db"
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/models"
+)
+
+// maxOfflineAge is how long known servers continue to be reported as offline
+// after they were last seen online.
+const maxOfflineAge = 7 * 24 * time.Hour
+
+// trackServerHealth records which of the game's servers responded during a
+// retrieval, returning the known servers (those in the server ID database) that
+// failed or are no longer listed, with their consecutive failure counts.
+func trackServerHealth(game string, sl *models.APIServerList) []models.APIOfflineServer {
+	if db.AppDB == nil || db.ServerDB == nil {
+		return nil
+	}
+	online := make([]string, 0, len(sl.Servers))
+	for _, s := range sl.Servers {
+		online = append(online, s.Host)
+	}
+	now := time.Now()
+	health, err := db.AppDB.UpdateServerHealth(game, online, sl.FailedServers, now,
+		now.Add(-maxOfflineAge))
+	if err != nil {
+		logger.LogAppError(err)
+		return nil
+	}
+	if len(health) == 0 {
+		return nil
+	}
+	hosts := make(map[string]string, len(health))
+	for _, h := range health {
+		hosts[h.Host] = game
+	}
+	result := make(chan map[string]int64, 1)
+	go db.ServerDB.GetIDsForServerList(result, hosts)
+	ids := <-result
+	failed := make(map[string]bool, len(sl.FailedServers))
+	for _, host := range sl.FailedServers {
+		failed[host] = true
+	}
+	offline := make([]models.APIOfflineServer, 0, len(health))
+	for _, h := range health {
+		if ids[h.Host] == 0 {
+			continue
+		}
+		h.ID = ids[h.Host]
+		h.Status = models.ServerStatusOffline
+		if failed[h.Host] {
+			h.Status = models.ServerStatusTimedOut
+		}
+		offline = append(offline, h)
+	}
+	return offline
+}
@@ -0,0 +1,41 @@
+package steam
+
+// history.go - Recording of each server's player counts after every timed
+// retrieval, for the server history endpoint.
+
+import (
+	"time"
+
+	"github.com/syncore/a2sapi/src/db"
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/models"
+)
+
+// serverStats returns the player counts of the servers in a game's list that
+// have a server ID.
+func serverStats(game string, sl *models.APIServerList,
+	now int64) []models.DbServerStat {
+	stats := make([]models.DbServerStat, 0, len(sl.Servers))
+	for _, s := range sl.Servers {
+		if s.ID == 0 {
+			continue
+		}
+		stats = append(stats, models.DbServerStat{ID: s.ID, Game: game,
+			Players: int(s.Info.Players), Bots: int(s.Info.Bots),
+			MaxPlayers: int(s.Info.MaxPlayers), Timestamp: now})
+	}
+	return stats
+}
+
+// recordPlayerHistory records the player counts of the servers in a game's
+// list. The counts are downsampled or deleted by the history retention job (see
+// ScheduleHistoryRetention).
+func recordPlayerHistory(game string, sl *models.APIServerList) {
+	if sl == nil || db.AppDB == nil {
+		return
+	}
+	if err := db.AppDB.AddServerStats(serverStats(game, sl,
+		time.Now().Unix())); err != nil {
+		logger.LogAppError(err)
+	}
+}
@@ -0,0 +1,108 @@
+package steam
+
+// historyretention.go - Background job that applies the player count history's
+// retention: player counts older than the full resolution retention are
+// downsampled to hourly averages, those to daily averages, and the daily
+// averages are eventually deleted.
+
+import (
+	"context"
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/db"
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/models"
+)
+
+// historyCutoffs are the times before which the player count history is
+// downsampled or deleted; 0 if nothing is.
+type historyCutoffs struct {
+	// player counts recorded before this are downsampled to hourly averages,
+	// or deleted if downsample is false
+	raw        int64
+	downsample bool
+	// hourly averages before this are downsampled to daily averages
+	hourly int64
+	// daily averages before this are deleted
+	daily int64
+}
+
+// getHistoryCutoffs returns the cutoffs of the configured history retention at
+// the given time.
+func getHistoryCutoffs(cfg config.CfgSteam, now time.Time) historyCutoffs {
+	c := historyCutoffs{}
+	if cfg.PlayerHistoryRetention <= 0 {
+		return c
+	}
+	c.raw = now.AddDate(0, 0, -cfg.PlayerHistoryRetention).Unix()
+	if cfg.PlayerHistoryHourlyRetention <= 0 {
+		return c
+	}
+	c.downsample = true
+	c.hourly = now.AddDate(0, 0, -cfg.PlayerHistoryHourlyRetention).Unix()
+	if cfg.PlayerHistoryDailyRetention > 0 {
+		c.daily = now.AddDate(0, 0, -cfg.PlayerHistoryDailyRetention).Unix()
+	}
+	return c
+}
+
+// applyHistoryRetention downsamples and deletes the player count history that
+// is older than the configured retention.
+func applyHistoryRetention(now time.Time) error {
+	if db.AppDB == nil {
+		return nil
+	}
+	c := getHistoryCutoffs(config.Get().SteamConfig, now)
+	if c.raw == 0 {
+		return nil
+	}
+	if !c.downsample {
+		pruned, err := db.AppDB.PruneServerStats(c.raw)
+		if err != nil {
+			return err
+		}
+		if pruned > 0 {
+			logger.WriteDebug("Deleted %d player counts older than %d days", pruned,
+				config.Get().SteamConfig.PlayerHistoryRetention)
+		}
+		return nil
+	}
+	hourly, err := db.AppDB.DownsampleServerStats(c.raw)
+	if err != nil {
+		return err
+	}
+	daily, err := db.AppDB.DownsampleHourlyServerStats(c.hourly)
+	if err != nil {
+		return err
+	}
+	var pruned int64
+	if c.daily != 0 {
+		if pruned, err = db.AppDB.PruneServerStatRollups(
+			models.HistoryResolutionDay, c.daily); err != nil {
+			return err
+		}
+	}
+	if hourly > 0 || daily > 0 || pruned > 0 {
+		logger.WriteDebug("Downsampled %d player counts to hourly and %d hourly averages to daily averages, and deleted %d daily averages",
+			hourly, daily, pruned)
+	}
+	return nil
+}
+
+// ScheduleHistoryRetention applies the player count history's retention on
+// startup and then every interval, until ctx is cancelled.
+func ScheduleHistoryRetention(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := applyHistoryRetention(time.Now()); err != nil {
+			logger.LogAppErrorf("Player history retention failed: %s", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package steam
+
+import (
+	"testing"
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+)
+
+func TestGetHistoryCutoffs(t *testing.T) {
+	now := time.Date(2026, 3, 31, 12, 30, 0, 0, time.UTC)
+	days := func(n int) int64 { return now.AddDate(0, 0, -n).Unix() }
+	tests := []struct {
+		raw, hourly, daily int
+		expected           historyCutoffs
+	}{
+		{7, 30, 365, historyCutoffs{raw: days(7), downsample: true,
+			hourly: days(30), daily: days(365)}},
+		// daily averages kept indefinitely
+		{7, 30, 0, historyCutoffs{raw: days(7), downsample: true,
+			hourly: days(30)}},
+		// no downsampling; player counts are deleted
+		{7, 0, 365, historyCutoffs{raw: days(7)}},
+		// player counts kept indefinitely
+		{0, 30, 365, historyCutoffs{}},
+	}
+	for _, tt := range tests {
+		cfg := config.CfgSteam{PlayerHistoryRetention: tt.raw,
+			PlayerHistoryHourlyRetention: tt.hourly,
+			PlayerHistoryDailyRetention:  tt.daily}
+		if c := getHistoryCutoffs(cfg, now); c != tt.expected {
+			t.Fatalf("Expected cutoffs %+v for retention %d/%d/%d, got: %+v",
+				tt.expected, tt.raw, tt.hourly, tt.daily, c)
+		}
+		expectedDays := tt.raw
+		switch {
+		case tt.raw == 0 || tt.hourly == 0:
+		case tt.daily == 0:
+			expectedDays = 0
+		default:
+			expectedDays = tt.daily
+		}
+		if d := cfg.PlayerHistoryDays(); d != expectedDays {
+			t.Fatalf("Expected history to be kept for %d days for retention %d/%d/%d, got: %d",
+				expectedDays, tt.raw, tt.hourly, tt.daily, d)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package steam
+
+// hostlists.go - Supplemental host lists (local files, remote URLs, or servers
+// discovered on the local network) that are merged with a game's master server
+// results, for community and LAN servers that are not listed on the master
+// server.
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/util"
+)
+
+// cachedHostList is a remote host list and when it was last fetched.
+type cachedHostList struct {
+	hosts   []string
+	fetched time.Time
+	etag    string
+}
+
+var (
+	hostListCache   = make(map[string]cachedHostList)
+	hostListCacheMu sync.Mutex
+	hostListClient  = &http.Client{Timeout: 15 * time.Second}
+	hostListStats   = util.RegisterCache("hostLists", func() int {
+		hostListCacheMu.Lock()
+		defer hostListCacheMu.Unlock()
+		return len(hostListCache)
+	}, func() int {
+		hostListCacheMu.Lock()
+		defer hostListCacheMu.Unlock()
+		n := len(hostListCache)
+		hostListCache = make(map[string]cachedHostList)
+		return n
+	})
+)
+
+func isRemoteHostList(source string) bool {
+	s := strings.ToLower(source)
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+func readHostListFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseHostList(io.LimitReader(f, maxHTTPListSize))
+}
+
+// fetchRemoteHostList returns the hosts from a remote host list, which is only
+// fetched again once it is older than cacheTime. If a fetch fails, the last
+// successfully fetched list continues to be used.
+func fetchRemoteHostList(url string, cacheTime time.Duration) ([]string, error) {
+	hostListCacheMu.Lock()
+	cached, ok := hostListCache[url]
+	hostListCacheMu.Unlock()
+	if ok && time.Since(cached.fetched) < cacheTime {
+		hostListStats.Hit()
+		return cached.hosts, nil
+	}
+	hostListStats.Miss()
+	hosts, etag, err := getRemoteHostList(url, cached.etag)
+	if err != nil {
+		if ok {
+			logger.LogSteamInfo("Using cached host list from %s: %s", url, err)
+			return cached.hosts, nil
+		}
+		return nil, err
+	}
+	if hosts == nil {
+		// not modified
+		hosts = cached.hosts
+	}
+	hostListCacheMu.Lock()
+	hostListCache[url] = cachedHostList{hosts: hosts, fetched: time.Now(), etag: etag}
+	hostListCacheMu.Unlock()
+	return hosts, nil
+}
+
+// getRemoteHostList retrieves a remote host list, returning nil hosts if it has
+// not changed since the version with the given etag.
+func getRemoteHostList(url, etag string) ([]string, string, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := hostListClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified && etag != "" {
+		return nil, etag, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("host list URL returned status %d", resp.StatusCode)
+	}
+	hosts, err := parseHostList(io.LimitReader(resp.Body, maxHTTPListSize))
+	if err != nil {
+		return nil, "", err
+	}
+	if hosts == nil {
+		hosts = []string{}
+	}
+	return hosts, resp.Header.Get("ETag"), nil
+}
+
+// getSupplementalHosts returns the hosts from all of the supplemental host lists
+// configured for a game, and the hosts of its virtual servers. Lists that cannot
+// be read are logged and skipped.
+func getSupplementalHosts(game string) []string {
+	var hosts []string
+	cacheTime := time.Duration(
+		config.Get().SteamConfig.SupplementalListCacheTime) * time.Second
+	for _, source := range config.Get().SteamConfig.SupplementalHostSources(game) {
+		var h []string
+		var err error
+		switch {
+		case isRemoteHostList(source):
+			h, err = fetchRemoteHostList(source, cacheTime)
+		case isLANHostList(source):
+			h, err = discoverLANServers(source[len(lanHostListPrefix):])
+		default:
+			h, err = readHostListFile(source)
+		}
+		if err != nil {
+			logger.LogSteamErrorf("Unable to read %s host list '%s': %s", game, source,
+				err)
+			continue
+		}
+		hosts = append(hosts, h...)
+	}
+	// the members of virtual servers, which may not be on the master server
+	hosts = append(hosts, normalizeAddresses(
+		config.Get().SteamConfig.VirtualServerHosts(game))...)
+	return hosts
+}
+
+// mergeHostLists returns the master server hosts followed by any supplemental
+// hosts that are not already in the list.
+func mergeHostLists(master, supplemental []string) []string {
+	seen := make(map[string]bool, len(master)+len(supplemental))
+	merged := make([]string, 0, len(master)+len(supplemental))
+	for _, hosts := range [][]string{master, supplemental} {
+		for _, h := range hosts {
+			if seen[h] {
+				continue
+			}
+			seen[h] = true
+			merged = append(merged, h)
+		}
+	}
+	return merged
+}
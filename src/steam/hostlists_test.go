@@ -0,0 +1,75 @@
+package steam
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/syncore/a2sapi/src/config"
+)
+
+func TestMergeHostLists(t *testing.T) {
+	merged := mergeHostLists([]string{"10.0.0.1:27960", "10.0.0.2:27960"},
+		[]string{"10.0.0.2:27960", "10.0.0.3:27960", "10.0.0.3:27960"})
+	expected := []string{"10.0.0.1:27960", "10.0.0.2:27960", "10.0.0.3:27960"}
+	if !reflect.DeepEqual(merged, expected) {
+		t.Fatalf("Expected merged hosts %v, got: %v", expected, merged)
+	}
+}
+
+func TestGetSupplementalHosts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hostlists")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "community.txt")
+	if err := ioutil.WriteFile(file, []byte("10.0.0.5:27960\n"), 0644); err != nil {
+		t.Fatalf("Unable to write host list: %s", err)
+	}
+
+	hits := 0
+	fail := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		hits++
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, `["10.0.0.6:27960"]`)
+	}))
+	defer srv.Close()
+
+	orig := config.Get().SteamConfig
+	defer func() { config.Get().SteamConfig = orig }()
+	config.Get().SteamConfig.SupplementalHostLists = map[string][]string{
+		"QuakeLive": {file, srv.URL, filepath.Join(dir, "missing.txt")},
+	}
+	config.Get().SteamConfig.SupplementalListCacheTime = 600
+
+	expected := []string{"10.0.0.5:27960", "10.0.0.6:27960"}
+	for i := 0; i < 2; i++ {
+		hosts := getSupplementalHosts("quakelive")
+		if !reflect.DeepEqual(hosts, expected) {
+			t.Fatalf("Expected supplemental hosts %v, got: %v", expected, hosts)
+		}
+	}
+	if hits != 1 {
+		t.Fatalf("Expected remote host list to be fetched once, got: %d", hits)
+	}
+	// expired cache and failed fetch: last good list is still used
+	fail = true
+	if hosts, err := fetchRemoteHostList(srv.URL, 0); err != nil ||
+		!reflect.DeepEqual(hosts, []string{"10.0.0.6:27960"}) {
+		t.Fatalf("Expected cached host list after failed fetch, got: %v, %v", hosts, err)
+	}
+	if hits != 2 {
+		t.Fatalf("Expected remote host list to be fetched again, got: %d fetches", hits)
+	}
+}
@@ -0,0 +1,151 @@
+package steam
+
+// ingest.go - State pushed by cooperating game servers (i.e. ones behind NAT
+// that can't be queried), which is merged with the A2S results of the timed
+// retrievals.
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/models"
+	"github.com/syncore/a2sapi/src/steam/filters"
+)
+
+// ingestedServer is a server's pushed state, converted to its A2S form.
+type ingestedServer struct {
+	info    models.SteamServerInfo
+	players []models.SteamPlayerInfo
+	rules   map[string]string
+	at      time.Time
+}
+
+// ingested holds the latest pushed state of each server, by game (lower case)
+// and host.
+var ingested = struct {
+	sync.Mutex
+	servers map[string]map[string]ingestedServer
+}{
+	servers: make(map[string]map[string]ingestedServer),
+}
+
+// IngestServerState stores the state pushed by a game's server at host, to be
+// merged with the A2S results of the game's next retrieval. If the server
+// pushed its state less than minInterval ago, the state is not stored and the
+// time to wait before the next push is returned.
+func IngestServerState(game, host string, st models.APIIngestState,
+	minInterval time.Duration) time.Duration {
+	now := time.Now()
+	key := strings.ToLower(game)
+	ingested.Lock()
+	defer ingested.Unlock()
+	if prev, ok := ingested.servers[key][host]; ok &&
+		now.Sub(prev.at) < minInterval {
+		return minInterval - now.Sub(prev.at)
+	}
+	if ingested.servers[key] == nil {
+		ingested.servers[key] = make(map[string]ingestedServer)
+	}
+	ingested.servers[key][host] = ingestedFromState(st, now)
+	return 0
+}
+
+// ingestedFromState converts a pushed state to the server's A2S data.
+func ingestedFromState(st models.APIIngestState, at time.Time) ingestedServer {
+	info := models.SteamServerInfo{
+		Name:       st.Name,
+		Map:        st.Map,
+		Folder:     st.GameDir,
+		Players:    int16(st.Players),
+		MaxPlayers: int16(st.MaxPlayers),
+		Bots:       int16(st.Bots),
+		Version:    st.Version,
+		ExtraData:  models.SteamExtraData{Keywords: st.Keywords},
+	}
+	if st.Password {
+		info.Visibility = 1
+	}
+	if st.VAC {
+		info.VAC = 1
+	}
+	players := make([]models.SteamPlayerInfo, 0, len(st.PlayerList))
+	for _, p := range st.PlayerList {
+		secs := int64(p.SecsConnected)
+		players = append(players, models.SteamPlayerInfo{
+			Name:              p.Name,
+			Score:             p.Score,
+			TimeConnectedSecs: p.SecsConnected,
+			TimeConnectedTot:  (time.Duration(secs) * time.Second).String(),
+			TimeConnectedRaw:  secs,
+			TimeConnectedISO:  formatISO8601Duration(secs),
+			TimeConnectedLong: formatLongDuration(secs),
+		})
+	}
+	return ingestedServer{info: info, players: players, rules: copyRules(st.Rules),
+		at: at}
+}
+
+// copyRules returns a copy of a server's rules, since the pushed state is used
+// in every retrieval until it expires.
+func copyRules(rules map[string]string) map[string]string {
+	c := make(map[string]string, len(rules))
+	for k, v := range rules {
+		c[k] = v
+	}
+	return c
+}
+
+// mergeIngested adds the game's recently pushed states to the A2S results,
+// where the servers' own A2S responses are missing, and returns the hosts whose
+// results now include pushed data. Pushed states older than the configured
+// maximum age are discarded.
+func mergeIngested(game filters.Game, data *a2sData) map[string]bool {
+	maxAge := time.Duration(config.Get().WebConfig.IngestMaxAge) * time.Second
+	now := time.Now()
+	key := strings.ToLower(game.Name)
+	ingested.Lock()
+	defer ingested.Unlock()
+	pushed := make(map[string]bool)
+	for host, s := range ingested.servers[key] {
+		if now.Sub(s.at) > maxAge {
+			delete(ingested.servers[key], host)
+			continue
+		}
+		if _, ok := data.HostsGames[host]; !ok {
+			data.HostsGames[host] = game
+		}
+		if _, ok := data.Info[host]; !ok && !game.IgnoreInfo {
+			if data.Info == nil {
+				data.Info = make(map[string]models.SteamServerInfo)
+			}
+			data.Info[host] = s.info
+			pushed[host] = true
+		}
+		if _, ok := data.Players[host]; !ok && !game.IgnorePlayers {
+			if data.Players == nil {
+				data.Players = make(map[string][]models.SteamPlayerInfo)
+			}
+			data.Players[host] = append([]models.SteamPlayerInfo(nil), s.players...)
+			pushed[host] = true
+		}
+		if _, ok := data.Rules[host]; !ok && !game.IgnoreRules {
+			if data.Rules == nil {
+				data.Rules = make(map[string]map[string]string)
+			}
+			data.Rules[host] = copyRules(s.rules)
+			pushed[host] = true
+		}
+	}
+	return pushed
+}
+
+// markPushed marks the servers whose data includes pushed data.
+func markPushed(sl *models.APIServerList, pushed map[string]bool) {
+	for i := range sl.Servers {
+		if pushed[sl.Servers[i].Host] {
+			sl.Servers[i].Pushed = true
+		}
+	}
+}
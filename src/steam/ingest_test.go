@@ -0,0 +1,73 @@
+package steam
+
+import (
+	"testing"
+	"time"
+
+	"github.com/syncore/a2sapi/src/models"
+	"github.com/syncore/a2sapi/src/steam/filters"
+)
+
+func TestIngestServerState(t *testing.T) {
+	defer func() {
+		ingested.Lock()
+		delete(ingested.servers, "ingesttest")
+		ingested.Unlock()
+	}()
+	st := models.APIIngestState{Name: "NAT server", Map: "bloodrun", Players: 3,
+		MaxPlayers: 8, Bots: 1, Password: true,
+		PlayerList: []models.APIIngestPlayer{{Name: "a", Score: 5,
+			SecsConnected: 93784}},
+		Rules: map[string]string{"g_gametype": "4"}}
+	if wait := IngestServerState("IngestTest", "203.0.113.5:27960", st,
+		time.Minute); wait != 0 {
+		t.Fatalf("Expected the first push to be stored, got a wait of %s", wait)
+	}
+	if wait := IngestServerState("IngestTest", "203.0.113.5:27960", st,
+		time.Minute); wait <= 0 || wait > time.Minute {
+		t.Fatalf("Expected a wait before the next push, got: %s", wait)
+	}
+
+	game := filters.Game{Name: "IngestTest"}
+	data := a2sData{
+		HostsGames: map[string]filters.Game{"203.0.113.5:27960": game,
+			"203.0.113.6:27960": game},
+		Info: map[string]models.SteamServerInfo{
+			"203.0.113.6:27960": {Name: "queried"}},
+		Players: map[string][]models.SteamPlayerInfo{},
+	}
+	pushed := mergeIngested(game, &data)
+	if !pushed["203.0.113.5:27960"] || pushed["203.0.113.6:27960"] {
+		t.Fatalf("Expected only the pushed server to be merged, got: %v", pushed)
+	}
+	info := data.Info["203.0.113.5:27960"]
+	if info.Name != "NAT server" || info.Players != 3 || info.Bots != 1 ||
+		info.Visibility != 1 {
+		t.Fatalf("Expected the pushed info, got: %+v", info)
+	}
+	players := data.Players["203.0.113.5:27960"]
+	if len(players) != 1 || players[0].TimeConnectedISO != "P1DT2H3M4S" {
+		t.Fatalf("Expected the pushed players, got: %+v", players)
+	}
+	if data.Rules["203.0.113.5:27960"]["g_gametype"] != "4" {
+		t.Fatalf("Expected the pushed rules, got: %v", data.Rules)
+	}
+	if data.Info["203.0.113.6:27960"].Name != "queried" {
+		t.Fatalf("Expected the queried server's info to be kept")
+	}
+
+	// A2S results take precedence over the pushed state
+	data = a2sData{
+		HostsGames: map[string]filters.Game{"203.0.113.5:27960": game},
+		Info: map[string]models.SteamServerInfo{
+			"203.0.113.5:27960": {Name: "queried"}},
+		Players: map[string][]models.SteamPlayerInfo{"203.0.113.5:27960": {}},
+		Rules: map[string]map[string]string{
+			"203.0.113.5:27960": {"g_gametype": "1"}},
+	}
+	if pushed := mergeIngested(game, &data); len(pushed) != 0 ||
+		data.Info["203.0.113.5:27960"].Name != "queried" {
+		t.Fatalf("Expected the queried results to be kept, got: %v %+v", pushed,
+			data.Info)
+	}
+}
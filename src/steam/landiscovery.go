@@ -0,0 +1,152 @@
+package steam
+
+// landiscovery.go - Discovery of servers on the local network by broadcasting
+// A2S_INFO requests, for LAN deployments where no master server lists the
+// machines. Used by the lan master provider and by lan: supplemental host lists.
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/steam/filters"
+)
+
+const (
+	// lanDiscoveryWait is how long to wait for servers to respond to a broadcast
+	lanDiscoveryWait = 2 * time.Second
+	// maxLANDiscoveryPorts is the maximum number of ports that can be broadcast to
+	maxLANDiscoveryPorts = 1000
+	// lanHostListPrefix marks supplemental host lists that are discovered on the
+	// local network, i.e. lan:27015-27020
+	lanHostListPrefix = "lan:"
+)
+
+// lanBroadcastAddr is the address that discovery requests are broadcast to.
+var lanBroadcastAddr = net.IPv4bcast
+
+// parsePortList parses a comma-separated list of ports and port ranges, i.e.
+// 27015-27020,27960.
+func parsePortList(s string) ([]int, error) {
+	var ports []int
+	seen := make(map[int]bool)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		lo, hi := part, part
+		if i := strings.Index(part, "-"); i != -1 {
+			lo, hi = part[:i], part[i+1:]
+		}
+		first, err := strconv.Atoi(strings.TrimSpace(lo))
+		if err != nil {
+			return nil, fmt.Errorf("invalid port '%s'", part)
+		}
+		last, err := strconv.Atoi(strings.TrimSpace(hi))
+		if err != nil {
+			return nil, fmt.Errorf("invalid port '%s'", part)
+		}
+		if first < 1 || last > 65535 || first > last {
+			return nil, fmt.Errorf("invalid port range '%s'", part)
+		}
+		for p := first; p <= last; p++ {
+			if seen[p] {
+				continue
+			}
+			if len(ports) == maxLANDiscoveryPorts {
+				return nil, fmt.Errorf("more than %d ports", maxLANDiscoveryPorts)
+			}
+			seen[p] = true
+			ports = append(ports, p)
+		}
+	}
+	if len(ports) == 0 {
+		return nil, fmt.Errorf("no ports specified")
+	}
+	return ports, nil
+}
+
+// discoverServers sends an A2S_INFO request to each of the ports at the given
+// (broadcast) address and returns the addresses of the servers that respond
+// within wait.
+func discoverServers(ip net.IP, ports []int, wait time.Duration) ([]string,
+	error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	for _, p := range ports {
+		if _, err := conn.WriteToUDP(infoChallengeReq,
+			&net.UDPAddr{IP: ip, Port: p}); err != nil {
+			return nil, err
+		}
+	}
+	conn.SetReadDeadline(time.Now().Add(wait))
+	seen := make(map[string]bool)
+	var servers []string
+	var buf [maxPacketSize]byte
+	for {
+		n, addr, err := conn.ReadFromUDP(buf[:])
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				break
+			}
+			return servers, err
+		}
+		// servers using the 2020 protocol respond with a challenge instead
+		if !bytes.HasPrefix(buf[:n], expectedInfoRespHeader) &&
+			!bytes.HasPrefix(buf[:n], expectedInfoChallengeHeader) {
+			continue
+		}
+		a := addr.String()
+		if !seen[a] {
+			seen[a] = true
+			servers = append(servers, a)
+		}
+	}
+	return servers, nil
+}
+
+// discoverLANServers broadcasts to the ports in a port list on the local
+// network and returns the addresses of the servers that respond.
+func discoverLANServers(portlist string) ([]string, error) {
+	ports, err := parsePortList(portlist)
+	if err != nil {
+		return nil, err
+	}
+	servers, err := discoverServers(lanBroadcastAddr, ports, lanDiscoveryWait)
+	if err != nil {
+		return nil, err
+	}
+	logger.WriteDebug("Discovered %d servers on the local network (ports: %s)",
+		len(servers), portlist)
+	return servers, nil
+}
+
+func isLANHostList(source string) bool {
+	return strings.HasPrefix(strings.ToLower(source), lanHostListPrefix)
+}
+
+// lanMasterProvider discovers the servers on the local network on the ports in
+// the game's masterAddress (i.e. 27015-27020,27960).
+type lanMasterProvider struct{}
+
+func (lanMasterProvider) GetServers(ctx context.Context,
+	filter filters.Filter) ([]string, error) {
+	// discovery is short (lanDiscoveryWait), so isn't interrupted
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if filter.Game.MasterAddress == "" {
+		return nil, fmt.Errorf("no masterAddress (ports) specified for %s",
+			filter.Game.Name)
+	}
+	return discoverLANServers(filter.Game.MasterAddress)
+}
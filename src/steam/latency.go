@@ -0,0 +1,153 @@
+package steam
+
+// latency.go - Per-host request timeouts and retry counts learned from the
+// hosts' response times across retrievals, so that fast hosts aren't waited on
+// for the full request timeout and slow hosts aren't cut off by it.
+
+import (
+	"sync"
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+)
+
+const (
+	// smoothing of each host's round trip time and its variation, as with TCP's
+	// retransmission timeout (RFC 6298)
+	latencyAlpha = 0.125
+	latencyBeta  = 0.25
+	// consecutive failed requests after which a host is only retried once
+	latencyDeadAfter = 3
+	// hosts that haven't been queried for this long are forgotten
+	latencyMaxAge = 24 * time.Hour
+)
+
+// hostLatency is the response time history of one host.
+type hostLatency struct {
+	// smoothed round trip time and its variation; 0 if the host never responded
+	srtt   time.Duration
+	rttvar time.Duration
+	// consecutive failed requests; each doubles the host's timeout
+	failures int
+	updated  time.Time
+}
+
+// latencyTracker tracks the response times of the hosts that A2S requests are
+// sent to.
+type latencyTracker struct {
+	mu    sync.Mutex
+	hosts map[string]*hostLatency
+}
+
+var latencies = newLatencyTracker()
+
+func newLatencyTracker() *latencyTracker {
+	return &latencyTracker{hosts: make(map[string]*hostLatency)}
+}
+
+// observe records the duration of a request to the host, and whether it failed.
+func (t *latencyTracker) observe(host string, d time.Duration, failed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	h, ok := t.hosts[host]
+	if !ok {
+		h = &hostLatency{}
+		t.hosts[host] = h
+	}
+	h.updated = queryClock.Now()
+	if failed {
+		h.failures++
+		return
+	}
+	h.failures = 0
+	if h.srtt == 0 {
+		h.srtt, h.rttvar = d, d/2
+		return
+	}
+	diff := h.srtt - d
+	if diff < 0 {
+		diff = -diff
+	}
+	h.rttvar += time.Duration(latencyBeta * float64(diff-h.rttvar))
+	h.srtt += time.Duration(latencyAlpha * float64(d-h.srtt))
+}
+
+// timeout returns the timeout for the next request to the host: its smoothed
+// round trip time plus four times its variation, doubled for each consecutive
+// failure, within min and max. Hosts that have never responded get
+// QueryTimeout (within the bounds).
+func (t *latencyTracker) timeout(host string, min, max time.Duration) time.Duration {
+	t.mu.Lock()
+	h, ok := t.hosts[host]
+	var d time.Duration
+	if ok && h.srtt > 0 {
+		d = h.srtt + 4*h.rttvar
+		for i := 0; i < h.failures && d < max; i++ {
+			d *= 2
+		}
+	} else {
+		d = QueryTimeout
+	}
+	t.mu.Unlock()
+	if d < min {
+		return min
+	}
+	if d > max {
+		return max
+	}
+	return d
+}
+
+// retries returns the number of times to retry a failed request to the host:
+// retrycount, or only once if the host has failed too often in a row.
+func (t *latencyTracker) retries(host string, retrycount int) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if h, ok := t.hosts[host]; ok && h.failures >= latencyDeadAfter &&
+		retrycount > 1 {
+		return 1
+	}
+	return retrycount
+}
+
+// prune forgets the hosts that haven't been queried since before.
+func (t *latencyTracker) prune(before time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for host, h := range t.hosts {
+		if h.updated.Before(before) {
+			delete(t.hosts, host)
+		}
+	}
+}
+
+func adaptiveTimeouts() bool {
+	return config.Get() != nil && config.Get().SteamConfig.AdaptiveQueryTimeouts
+}
+
+// hostTimeout returns the time allowed for the next request to the host:
+// QueryTimeout, or the host's learned timeout if adaptive timeouts are enabled.
+func hostTimeout(host string) time.Duration {
+	if !adaptiveTimeouts() {
+		return QueryTimeout
+	}
+	return latencies.timeout(host,
+		time.Duration(config.Get().SteamConfig.MinQueryTimeout)*time.Millisecond,
+		time.Duration(config.Get().SteamConfig.MaxQueryTimeout)*time.Millisecond)
+}
+
+// retryCount returns the number of times to retry a failed request to the host.
+func retryCount(host string, retrycount int) int {
+	if !adaptiveTimeouts() {
+		return retrycount
+	}
+	return latencies.retries(host, retrycount)
+}
+
+// recordLatency records the duration of a request to the host if adaptive
+// timeouts are enabled.
+func recordLatency(host string, d time.Duration, failed bool) {
+	if adaptiveTimeouts() {
+		latencies.observe(host, d, failed)
+	}
+}
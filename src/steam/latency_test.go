@@ -0,0 +1,91 @@
+package steam
+
+import (
+	"testing"
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+)
+
+func TestLatencyTrackerTimeout(t *testing.T) {
+	l := newLatencyTracker()
+	min, max := 300*time.Millisecond, 5*time.Second
+	fast, slow := "10.0.0.1:27960", "10.0.0.2:27960"
+	if d := l.timeout(fast, min, max); d != QueryTimeout {
+		t.Fatalf("Expected %s for an unknown host, got: %s", QueryTimeout, d)
+	}
+	for i := 0; i < 20; i++ {
+		l.observe(fast, 20*time.Millisecond, false)
+		l.observe(slow, 1500*time.Millisecond, false)
+	}
+	if d := l.timeout(fast, min, max); d != min {
+		t.Fatalf("Expected the minimum timeout for a fast host, got: %s", d)
+	}
+	d := l.timeout(slow, min, max)
+	if d <= 1500*time.Millisecond || d >= QueryTimeout*2 {
+		t.Fatalf("Expected a timeout above the slow host's RTT, got: %s", d)
+	}
+	// each failure doubles the timeout, up to the maximum
+	l.observe(slow, QueryTimeout, true)
+	if backoff := l.timeout(slow, min, max); backoff != 2*d {
+		t.Fatalf("Expected timeout to double to %s, got: %s", 2*d, backoff)
+	}
+	for i := 0; i < 5; i++ {
+		l.observe(slow, QueryTimeout, true)
+	}
+	if backoff := l.timeout(slow, min, max); backoff != max {
+		t.Fatalf("Expected the maximum timeout, got: %s", backoff)
+	}
+	l.observe(slow, 1500*time.Millisecond, false)
+	if recovered := l.timeout(slow, min, max); recovered >= 2*d {
+		t.Fatalf("Expected timeout to recover after a response, got: %s",
+			recovered)
+	}
+}
+
+func TestLatencyTrackerRetries(t *testing.T) {
+	l := newLatencyTracker()
+	dead := "10.0.0.3:27960"
+	for i := 0; i < latencyDeadAfter; i++ {
+		if n := l.retries(dead, QueryRetryCount); n != QueryRetryCount {
+			t.Fatalf("Expected %d retries after %d failures, got: %d",
+				QueryRetryCount, i, n)
+		}
+		l.observe(dead, QueryTimeout, true)
+	}
+	if n := l.retries(dead, QueryRetryCount); n != 1 {
+		t.Fatalf("Expected 1 retry for a host that keeps failing, got: %d", n)
+	}
+	// hosts that never responded keep the full request timeout
+	if d := l.timeout(dead, 0, time.Minute); d != QueryTimeout {
+		t.Fatalf("Expected %s for a host that never responded, got: %s",
+			QueryTimeout, d)
+	}
+	l.prune(queryClock.Now().Add(time.Hour))
+	if n := l.retries(dead, QueryRetryCount); n != QueryRetryCount {
+		t.Fatalf("Expected pruned host to be retried %d times, got: %d",
+			QueryRetryCount, n)
+	}
+}
+
+func TestHostTimeoutDisabled(t *testing.T) {
+	orig := config.Get().SteamConfig
+	defer func() { config.Get().SteamConfig = orig }()
+	config.Get().SteamConfig.AdaptiveQueryTimeouts = false
+	host := "10.0.0.4:27960"
+	recordLatency(host, 10*time.Millisecond, false)
+	if d := hostTimeout(host); d != QueryTimeout {
+		t.Fatalf("Expected %s with adaptive timeouts disabled, got: %s",
+			QueryTimeout, d)
+	}
+	config.Get().SteamConfig.AdaptiveQueryTimeouts = true
+	config.Get().SteamConfig.MinQueryTimeout = 100
+	config.Get().SteamConfig.MaxQueryTimeout = 5000
+	defer latencies.prune(queryClock.Now().Add(time.Hour))
+	for i := 0; i < 10; i++ {
+		recordLatency(host, 10*time.Millisecond, false)
+	}
+	if d := hostTimeout(host); d != 100*time.Millisecond {
+		t.Fatalf("Expected the 100ms minimum timeout, got: %s", d)
+	}
+}
@@ -6,17 +6,42 @@ package steam
 
 import (
 	"net"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/syncore/a2sapi/src/config"
 	"github.com/syncore/a2sapi/src/db"
 	"github.com/syncore/a2sapi/src/logger"
 	"github.com/syncore/a2sapi/src/models"
 	"github.com/syncore/a2sapi/src/steam/filters"
+	"github.com/syncore/a2sapi/src/util"
 )
 
-func buildServerList(data a2sData, addtoServerDB bool) (*models.APIServerList,
+// serverDBUpdate is how buildServerList adds the servers that are not yet in
+// the server database to it.
+type serverDBUpdate int
+
+const (
+	// the servers are not added
+	serverDBNone serverDBUpdate = iota
+	// the servers are added before the IDs are assigned, so that new servers
+	// receive their IDs in the same list; used by the timed retrievals
+	serverDBSync
+	// the servers are added in the background, so that direct queries don't
+	// wait on the database; new servers receive their IDs in later lists
+	serverDBAsync
+)
+
+// buildServerList builds the list of servers from the results of the A2S queries.
+// It runs as a pipeline: the results are parsed, the servers' locations are
+// looked up, their IDs are assigned from the server database, and the list is
+// published; each stage works on the servers in parallel. Servers that are not
+// yet in the server database are added to it as specified by dbUpdate.
+func buildServerList(data a2sData, dbUpdate serverDBUpdate) (*models.APIServerList,
 	error) {
 	// Cannot ignore all three requests
 	for _, g := range data.HostsGames {
@@ -24,94 +49,222 @@ func buildServerList(data a2sData, addtoServerDB bool) (*models.APIServerList,
 			return nil, logger.LogAppErrorf("Cannot ignore all three A2S_ requests!")
 		}
 	}
-	successcount := 0
-	var success bool
-	srvDBhosts := make(map[string]string, len(data.HostsGames))
+	servers, failed := parseServerResults(data)
+	enrichLocations(servers)
+	servers = assignServerIDs(servers, dbUpdate)
+
 	sl := &models.APIServerList{
-		Servers:       make([]models.APIServer, 0),
-		FailedServers: make([]string, 0),
+		RetrievedAt:        time.Now().Format("Mon Jan 2 15:04:05 2006 EST"),
+		RetrievedTimeStamp: time.Now().Unix(),
+		ServerCount:        len(servers),
+		Servers:            servers,
+		FailedCount:        len(failed),
+		FailedServers:      failed,
 	}
+	logger.LogAppInfo(
+		"Successfully queried (%d/%d) servers. %d timed out or otherwise failed.",
+		sl.ServerCount, len(data.HostsGames), sl.FailedCount)
+	logger.WriteDebug("Server Queries: Successful: (%d/%d) servers\tFailed: %d servers",
+		sl.ServerCount, len(data.HostsGames), sl.FailedCount)
+	return sl, nil
+}
 
-	for host, game := range data.HostsGames {
-		info, iok := data.Info[host]
-		players, pok := data.Players[host]
-		if players == nil {
-			// return empty array instead of nil pointers (null) in json
-			players = make([]models.SteamPlayerInfo, 0)
-		}
-		rules, rok := data.Rules[host]
-		success = iok && rok && pok
+// parallelize calls fn for each index in [0, n), spread across one worker per CPU.
+func parallelize(n int, fn func(i int)) {
+	workers := runtime.NumCPU()
+	if workers > n {
+		workers = n
+	}
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < n; i += workers {
+				fn(i)
+			}
+		}(w)
+	}
+	wg.Wait()
+}
 
-		if game.IgnoreInfo {
-			success = pok && rok
-		}
-		if game.IgnorePlayers {
-			success = iok && rok
-		}
-		if game.IgnoreRules {
-			rules = make(map[string]string, 0)
-			success = iok && pok
+// parseServerResults builds a server from each host's A2S results, returning the
+// servers (in host order) and the hosts whose required results are missing.
+func parseServerResults(data a2sData) ([]models.APIServer, []string) {
+	hosts := make([]string, 0, len(data.HostsGames))
+	for host := range data.HostsGames {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	built := make([]models.APIServer, len(hosts))
+	ok := make([]bool, len(hosts))
+	excluded := make([]bool, len(hosts))
+	parallelize(len(hosts), func(i int) {
+		built[i], ok[i], excluded[i] = parseServerResult(data, hosts[i])
+	})
+
+	servers := make([]models.APIServer, 0, len(hosts))
+	failed := make([]string, 0)
+	for i, host := range hosts {
+		switch {
+		case excluded[i]:
+		case ok[i]:
+			servers = append(servers, built[i])
+		default:
+			failed = append(failed, host)
 		}
-		if game.IgnoreInfo && game.IgnorePlayers {
-			success = rok
+	}
+	return servers, failed
+}
+
+// parseServerResult builds the server for a host from its A2S results. ok is
+// false if the host has no usable results: its A2S_INFO result is missing or,
+// for games that ignore A2S_INFO, all of its results are missing. Servers with
+// some of their other results missing are built with a partial status. excluded
+// is true if the server is left out of lists as a LAN server.
+func parseServerResult(data a2sData, host string) (srv models.APIServer, ok,
+	excluded bool) {
+	game := data.HostsGames[host]
+	warnings, partial := takeParseWarnings(host)
+	info, iok := data.Info[host]
+	players, pok := data.Players[host]
+	if players == nil {
+		// return empty array instead of nil pointers (null) in json
+		players = make([]models.SteamPlayerInfo, 0)
+	}
+	rules, rok := data.Rules[host]
+	if game.IgnoreRules || rules == nil {
+		rules = make(map[string]string, 0)
+	}
+	players = enrichPlayers(game, players, rules)
+	players = decodePlayerNames(game, players)
+	complete := (iok || game.IgnoreInfo) && (pok || game.IgnorePlayers) &&
+		(rok || game.IgnoreRules)
+	usable := iok
+	if game.IgnoreInfo {
+		usable = (pok && !game.IgnorePlayers) || (rok && !game.IgnoreRules)
+	}
+	if !usable {
+		return srv, false, false
+	}
+	status := models.ServerStatusOnline
+	if !complete || len(partial) > 0 {
+		status = models.ServerStatusPartial
+	}
+
+	srv = models.APIServer{
+		Game:            game.Name,
+		Players:         players,
+		FilteredPlayers: removeBuggedPlayers(players),
+		Rules:           rules,
+		Info:            info,
+		ParseWarnings:   warnings,
+		PartialFields:   partial,
+		Status:          status,
+	}
+	// Gametype support: gametype can be found in rules, info, or not
+	// at all depending on the game (currently just for QuakeLive & Reflex)
+	srv.Info.GameTypeShort, srv.Info.GameTypeFull = getGameType(game, srv)
+	srv.Tags = getServerTags(game, srv)
+	setDerivedFields(game, &srv)
+	// the limits apply after the fields that are derived from the full rules
+	if rules, truncated := limitRules(game, srv.Rules); truncated != nil {
+		srv.Rules = rules
+		markPartial(&srv, *truncated)
+	}
+	srv.Rules = redactRules(srv.Rules)
+
+	ip, port, serr := net.SplitHostPort(host)
+	if serr != nil {
+		return srv, true, false
+	}
+	if config.Get().SteamConfig.ExcludeLANServers && util.IsLANAddress(ip) {
+		logger.WriteDebug("Excluding LAN server %s from list", host)
+		return srv, true, true
+	}
+	srv.IP = ip
+	srv.Host = host
+	if p, perr := strconv.Atoi(port); perr == nil {
+		srv.Port = p
+	}
+	return srv, true, false
+}
+
+// enrichLocations looks up the location of each server with an IP address.
+func enrichLocations(servers []models.APIServer) {
+	parallelize(len(servers), func(i int) {
+		if servers[i].IP == "" {
+			return
 		}
-		if game.IgnoreInfo && game.IgnoreRules {
-			success = pok
+		loc := make(chan models.DbCountry, 1)
+		db.CountryDB.GetCountryInfo(loc, servers[i].IP)
+		servers[i].CountryInfo = <-loc
+		servers[i].CountryInfo.FlagURL = getFlagURL(servers[i].CountryInfo)
+	})
+}
+
+// assignServerIDs sets the ID (and claimed alias) of each server from the server
+// database, adding the servers that are not in it yet as specified by dbUpdate.
+func assignServerIDs(servers []models.APIServer,
+	dbUpdate serverDBUpdate) []models.APIServer {
+	srvDBhosts := make(map[string]string, len(servers))
+	var bySteamID []models.DbServer
+	for _, s := range servers {
+		if s.Host == "" || strings.EqualFold(s.Game,
+			filters.GameUnspecified.String()) {
+			continue
 		}
-		if game.IgnorePlayers && game.IgnoreRules {
-			success = iok
+		srvDBhosts[s.Host] = s.Game
+		if config.Get().SteamConfig.IdentifyBySteamID &&
+			persistentSteamID(s.Info.ExtraData.SteamID) {
+			bySteamID = append(bySteamID, models.DbServer{Host: s.Host, Game: s.Game,
+				SteamID: s.Info.ExtraData.SteamID})
 		}
+	}
+	if len(srvDBhosts) == 0 {
+		return servers
+	}
+	switch dbUpdate {
+	case serverDBSync:
+		addServersToDB(srvDBhosts, bySteamID)
+	case serverDBAsync:
+		go addServersToDB(srvDBhosts, bySteamID)
+	}
+	return setServerIDsForList(servers)
+}
 
-		if success {
-			srv := models.APIServer{
-				Game:            game.Name,
-				Players:         players,
-				FilteredPlayers: removeBuggedPlayers(players),
-				Rules:           rules,
-				Info:            info,
-			}
-			// Gametype support: gametype can be found in rules, info, or not
-			// at all depending on the game (currently just for QuakeLive & Reflex)
-			srv.Info.GameTypeShort, srv.Info.GameTypeFull = getGameType(game, srv)
-
-			ip, port, serr := net.SplitHostPort(host)
-			if serr == nil {
-				srv.IP = ip
-				srv.Host = host
-				p, perr := strconv.Atoi(port)
-				if perr == nil {
-					srv.Port = p
-				}
-				if !strings.EqualFold(game.Name, filters.GameUnspecified.String()) {
-					srvDBhosts[host] = game.Name
-				}
-				loc := make(chan models.DbCountry, 1)
-				go db.CountryDB.GetCountryInfo(loc, ip)
-				srv.CountryInfo = <-loc
-			}
-			sl.Servers = append(sl.Servers, srv)
-			successcount++
-		} else {
-			sl.FailedServers = append(sl.FailedServers, host)
-		}
+// addServersToDB adds the hosts to the server database, first moving the IDs of
+// the servers that are identified by Steam ID and have changed addresses.
+func addServersToDB(hosts map[string]string, bySteamID []models.DbServer) {
+	if len(bySteamID) > 0 {
+		db.ServerDB.ResolveSteamIDs(bySteamID)
 	}
+	db.ServerDB.AddServersToDB(hosts)
+}
 
-	sl.RetrievedAt = time.Now().Format("Mon Jan 2 15:04:05 2006 EST")
-	sl.RetrievedTimeStamp = time.Now().Unix()
-	sl.ServerCount = len(sl.Servers)
-	sl.FailedCount = len(sl.FailedServers)
+// persistentSteamID returns true if the Steam ID belongs to a game server that
+// is logged in with an account (a game server login token), whose Steam ID
+// remains the same across restarts. Anonymous game servers are given a new Steam
+// ID each time they start, so can't be identified by it.
+func persistentSteamID(id uint64) bool {
+	const (
+		universePublic        = 1
+		accountTypeGameServer = 3
+	)
+	return id>>56 == universePublic && (id>>52)&0xf == accountTypeGameServer &&
+		id&0xffffffff != 0
+}
 
-	if len(srvDBhosts) != 0 {
-		go db.ServerDB.AddServersToDB(srvDBhosts)
-		sl.Servers = setServerIDsForList(sl.Servers)
+// getFlagURL returns the URL of the country's flag image based on the configured
+// template, or an empty string if there is no template or the country is unknown.
+func getFlagURL(c models.DbCountry) string {
+	tmpl := config.Get().WebConfig.CountryFlagURLTemplate
+	if tmpl == "" || c.FlagEmoji == "" {
+		return ""
 	}
-
-	logger.LogAppInfo(
-		"Successfully queried (%d/%d) servers. %d timed out or otherwise failed.",
-		successcount, len(data.HostsGames), sl.FailedCount)
-	logger.WriteDebug("Server Queries: Successful: (%d/%d) servers\tFailed: %d servers",
-		successcount, len(data.HostsGames), sl.FailedCount)
-	return sl, nil
+	return strings.NewReplacer("{code}", strings.ToLower(c.CountryCode),
+		"{CODE}", strings.ToUpper(c.CountryCode)).Replace(tmpl)
 }
 
 // removeBuggedPlayers filters the players to remove "bugged" or stuck players
@@ -142,19 +295,36 @@ func removeBuggedPlayers(players []models.SteamPlayerInfo) models.FilteredPlayer
 	return rpi
 }
 
+// idLookupBatchSize is the number of hosts whose IDs are looked up by each of
+// the concurrent server database queries.
+const idLookupBatchSize = 250
+
 func setServerIDsForList(servers []models.APIServer) []models.APIServer {
-	toSet := make(map[string]string, len(servers))
-	for _, s := range servers {
-		toSet[s.Host] = s.Game
+	var batches []map[string]string
+	for i := 0; i < len(servers); i += idLookupBatchSize {
+		end := i + idLookupBatchSize
+		if end > len(servers) {
+			end = len(servers)
+		}
+		toSet := make(map[string]string, end-i)
+		for _, s := range servers[i:end] {
+			toSet[s.Host] = s.Game
+		}
+		batches = append(batches, toSet)
 	}
-	result := make(chan map[string]int64, 1)
-	go db.ServerDB.GetIDsForServerList(result, toSet)
-	m := <-result
-	var srvswithids []models.APIServer
+	results := make([]map[string]int64, len(batches))
+	parallelize(len(batches), func(i int) {
+		result := make(chan map[string]int64, 1)
+		go db.ServerDB.GetIDsForServerList(result, batches[i])
+		results[i] = <-result
+	})
+	aliases := getClaimAliases()
+	srvswithids := make([]models.APIServer, 0, len(servers))
 
-	for _, s := range servers {
-		if m[s.Host] != 0 {
-			s.ID = m[s.Host]
+	for i, s := range servers {
+		if id := results[i/idLookupBatchSize][s.Host]; id != 0 {
+			s.ID = id
+			s.Alias = aliases[s.ID]
 		}
 		srvswithids = append(srvswithids, s)
 	}
@@ -1,9 +1,11 @@
 package steam
 
 import (
+	"fmt"
 	"strings"
 	"testing"
 
+	"github.com/syncore/a2sapi/src/config"
 	"github.com/syncore/a2sapi/src/db"
 	"github.com/syncore/a2sapi/src/models"
 	"github.com/syncore/a2sapi/src/steam/filters"
@@ -159,7 +161,7 @@ func init() {
 }
 
 func TestBuildServerList(t *testing.T) {
-	asl, err := buildServerList(testData, false)
+	asl, err := buildServerList(testData, serverDBNone)
 	if err != nil {
 		t.Fatalf("Unexpected error occurred when building server list.")
 	}
@@ -190,6 +192,167 @@ func TestBuildServerList(t *testing.T) {
 	}
 }
 
+func TestBuildServerListFailed(t *testing.T) {
+	data := a2sData{
+		HostsGames: map[string]filters.Game{
+			"54.172.5.67:25801": filters.GameReflex,
+			"10.0.0.9:27960":    filters.GameQuakeLive,
+		},
+		Info:    testData.Info,
+		Rules:   testData.Rules,
+		Players: testData.Players,
+	}
+	asl, err := buildServerList(data, serverDBNone)
+	if err != nil {
+		t.Fatalf("Unexpected error occurred when building server list.")
+	}
+	if asl.ServerCount != 1 || asl.Servers[0].Host != "54.172.5.67:25801" {
+		t.Fatalf("Expected only the Reflex server, got: %+v", asl.Servers)
+	}
+	if asl.FailedCount != 1 || asl.FailedServers[0] != "10.0.0.9:27960" {
+		t.Fatalf("Expected the server without results to fail, got: %v",
+			asl.FailedServers)
+	}
+}
+
+func TestBuildServerListStatus(t *testing.T) {
+	data := a2sData{
+		HostsGames: map[string]filters.Game{
+			"54.172.5.67:25801":   filters.GameReflex,
+			"192.211.62.11:27960": filters.GameQuakeLive,
+		},
+		Info:    testData.Info,
+		Rules:   testData.Rules,
+		Players: map[string][]models.SteamPlayerInfo{},
+	}
+	for h, p := range testData.Players {
+		if h != "192.211.62.11:27960" {
+			data.Players[h] = p
+		}
+	}
+	asl, err := buildServerList(data, serverDBNone)
+	if err != nil {
+		t.Fatalf("Unexpected error occurred when building server list.")
+	}
+	if asl.ServerCount != 2 || asl.FailedCount != 0 {
+		t.Fatalf("Expected 2 servers and no failures, got: %d and %d",
+			asl.ServerCount, asl.FailedCount)
+	}
+	for _, s := range asl.Servers {
+		expected := models.ServerStatusOnline
+		if s.Host == "192.211.62.11:27960" {
+			// A2S_PLAYER is missing
+			expected = models.ServerStatusPartial
+		}
+		if s.Status != expected {
+			t.Fatalf("Expected %s server to be %s, got: %s", s.Host, expected, s.Status)
+		}
+	}
+}
+
+func TestParseServerResultIgnored(t *testing.T) {
+	const host = "192.211.62.11:27960"
+	tests := []struct {
+		name                   string
+		ignoreInfo, ignorePl   bool
+		ignoreRules            bool
+		hasInfo, hasPl, hasRul bool
+		ok                     bool
+		status                 string
+	}{
+		{"all results", false, false, false, true, true, true, true,
+			models.ServerStatusOnline},
+		{"players missing", false, false, false, true, false, true, true,
+			models.ServerStatusPartial},
+		{"rules missing", false, false, false, true, true, false, true,
+			models.ServerStatusPartial},
+		{"info missing", false, false, false, false, true, true, false, ""},
+		{"ignored rules", false, false, true, true, true, false, true,
+			models.ServerStatusOnline},
+		{"ignored rules, players missing", false, false, true, true, false, false,
+			true, models.ServerStatusPartial},
+		{"ignored players", false, true, false, true, false, true, true,
+			models.ServerStatusOnline},
+		{"ignored players and rules", false, true, true, true, false, false, true,
+			models.ServerStatusOnline},
+		{"ignored info", true, false, false, false, true, true, true,
+			models.ServerStatusOnline},
+		{"ignored info, rules missing", true, false, false, false, true, false,
+			true, models.ServerStatusPartial},
+		{"ignored info, players missing", true, false, false, false, false, true,
+			true, models.ServerStatusPartial},
+		{"ignored info, all missing", true, false, false, false, false, false,
+			false, ""},
+		{"ignored info, only info", true, false, false, true, false, false, false,
+			""},
+		{"ignored info and rules", true, false, true, false, true, false, true,
+			models.ServerStatusOnline},
+		{"ignored info and rules, only rules", true, false, true, false, false,
+			true, false, ""},
+		{"ignored info and players", true, true, false, false, false, true, true,
+			models.ServerStatusOnline},
+		{"ignored info and players, only players", true, true, false, false, true,
+			false, false, ""},
+	}
+	for _, tt := range tests {
+		game := filters.Game{Name: "QuakeLive", AppID: 282440,
+			IgnoreInfo: tt.ignoreInfo, IgnorePlayers: tt.ignorePl,
+			IgnoreRules: tt.ignoreRules}
+		data := a2sData{
+			HostsGames: map[string]filters.Game{host: game},
+			Info:       map[string]models.SteamServerInfo{},
+			Rules:      map[string]map[string]string{},
+			Players:    map[string][]models.SteamPlayerInfo{},
+		}
+		if tt.hasInfo {
+			data.Info[host] = testData.Info[host]
+		}
+		if tt.hasPl {
+			data.Players[host] = testData.Players["54.172.5.67:25801"]
+		}
+		if tt.hasRul {
+			data.Rules[host] = testData.Rules[host]
+		}
+		srv, ok, _ := parseServerResult(data, host)
+		if ok != tt.ok {
+			t.Fatalf("%s: expected ok to be %v, got: %v", tt.name, tt.ok, ok)
+		}
+		if ok && srv.Status != tt.status {
+			t.Fatalf("%s: expected status %s, got: %s", tt.name, tt.status,
+				srv.Status)
+		}
+	}
+}
+
+// benchmarkData returns A2S results for n servers, like those of a large cycle.
+func benchmarkData(n int) a2sData {
+	data := a2sData{
+		HostsGames: make(map[string]filters.Game, n),
+		Info:       make(map[string]models.SteamServerInfo, n),
+		Rules:      make(map[string]map[string]string, n),
+		Players:    make(map[string][]models.SteamPlayerInfo, n),
+	}
+	src := "192.211.62.11:27960"
+	for i := 0; i < n; i++ {
+		host := fmt.Sprintf("192.%d.%d.%d:27960", 16+i/65536, (i/256)%256, i%256)
+		data.HostsGames[host] = filters.GameQuakeLive
+		data.Info[host] = testData.Info[src]
+		data.Rules[host] = testData.Rules[src]
+		data.Players[host] = testData.Players["54.172.5.67:25801"]
+	}
+	return data
+}
+
+func BenchmarkBuildServerList(b *testing.B) {
+	data := benchmarkData(5000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := buildServerList(data, serverDBNone); err != nil {
+			b.Fatalf("Unexpected error building server list: %s", err)
+		}
+	}
+}
+
 func TestRemoveBuggedPlayers(t *testing.T) {
 	buggedRemoved := removeBuggedPlayers(testData.Players["54.172.5.67:25801"])
 	if len(buggedRemoved.FilteredPlayers) != 5 {
@@ -202,3 +365,40 @@ func TestRemoveBuggedPlayers(t *testing.T) {
 		}
 	}
 }
+
+func TestGetFlagURL(t *testing.T) {
+	orig := config.Get().WebConfig.CountryFlagURLTemplate
+	defer func() { config.Get().WebConfig.CountryFlagURLTemplate = orig }()
+	c := models.DbCountry{CountryCode: "SE", FlagEmoji: "\U0001F1F8\U0001F1EA"}
+
+	config.Get().WebConfig.CountryFlagURLTemplate = ""
+	if u := getFlagURL(c); u != "" {
+		t.Fatalf("Expected no flag URL without a template, got: %s", u)
+	}
+	config.Get().WebConfig.CountryFlagURLTemplate = "https://flags.example.com/{code}/{CODE}.png"
+	if u := getFlagURL(c); u != "https://flags.example.com/se/SE.png" {
+		t.Fatalf("Expected flag URL from template, got: %s", u)
+	}
+	if u := getFlagURL(models.DbCountry{CountryCode: "Unknown"}); u != "" {
+		t.Fatalf("Expected no flag URL for unknown country, got: %s", u)
+	}
+}
+
+func TestPersistentSteamID(t *testing.T) {
+	tests := map[uint64]bool{
+		// logged in with a game server login token
+		85568392920040000: true,
+		// anonymous game server
+		90071992547409920 + 1234: false,
+		// individual account
+		76561197960287930: false,
+		// game server without an account ID
+		85568392920039424: false,
+		0:                 false,
+	}
+	for id, expected := range tests {
+		if persistentSteamID(id) != expected {
+			t.Fatalf("Expected persistentSteamID(%d) to be %t", id, expected)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package steam
+
+// mapchange.go - Detection of servers changing maps, performed after each timed
+// retrieval. Changes are recorded in the map change history and sent to the
+// owners of claimed servers.
+
+import (
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/db"
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/models"
+)
+
+// defaultMapChangeConfirmations is used if the option is missing from older
+// configs.
+const defaultMapChangeConfirmations = 2
+
+// mapRuleKeys are the A2S_RULES keys that some games report the loaded map in;
+// these are preferred over the A2S_INFO map, which some games fill with a
+// display name instead.
+var mapRuleKeys = []string{"mapname", "sv_mapname", "map"}
+
+// mapChangeNotification is sent to a claimed server's notification URL when the
+// server changes maps.
+type mapChangeNotification struct {
+	ID        int64  `json:"serverID"`
+	Host      string `json:"address"`
+	Game      string `json:"game"`
+	Alias     string `json:"alias"`
+	Event     string `json:"event"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// serverMap is the last accepted map of a server, along with a new map that has
+// not yet been seen in enough consecutive retrievals to be accepted.
+type serverMap struct {
+	current   string
+	candidate string
+	seen      int
+}
+
+var (
+	serverMaps   = make(map[string]*serverMap)
+	serverMapsMu sync.Mutex
+)
+
+// normalizeMapName returns the bare, lowercase name of a map, without any
+// directory (i.e. workshop/123456/) or file extension.
+func normalizeMapName(name string) string {
+	name = strings.ToLower(strings.TrimSpace(strings.Replace(name, "\\", "/", -1)))
+	name = path.Base(name)
+	if name == "." || name == "/" {
+		return ""
+	}
+	return strings.TrimSuffix(name, path.Ext(name))
+}
+
+// serverMapName returns the normalized name of the map that a server is on.
+func serverMapName(srv models.APIServer) string {
+	for _, rk := range mapRuleKeys {
+		for k, v := range srv.Rules {
+			if strings.EqualFold(k, rk) {
+				if m := normalizeMapName(v); m != "" {
+					return m
+				}
+			}
+		}
+	}
+	return normalizeMapName(srv.Info.Map)
+}
+
+// observeMap records the map that a server was seen on and returns the previous
+// map and true if the server has now changed maps. A new map must be seen in
+// confirmations consecutive observations before the change is accepted.
+func observeMap(key, m string, confirmations int) (string, bool) {
+	if m == "" {
+		return "", false
+	}
+	serverMapsMu.Lock()
+	defer serverMapsMu.Unlock()
+	sm, ok := serverMaps[key]
+	if !ok {
+		serverMaps[key] = &serverMap{current: m}
+		return "", false
+	}
+	if m == sm.current {
+		sm.candidate, sm.seen = "", 0
+		return "", false
+	}
+	if m != sm.candidate {
+		sm.candidate, sm.seen = m, 0
+	}
+	sm.seen++
+	if sm.seen < confirmations {
+		return "", false
+	}
+	from := sm.current
+	sm.current, sm.candidate, sm.seen = m, "", 0
+	return from, true
+}
+
+// detectMapChanges returns the changes of the servers in a game's list that
+// have changed maps since the previous retrievals.
+func detectMapChanges(game string,
+	sl *models.APIServerList) []models.DbMapChange {
+	if sl == nil {
+		return nil
+	}
+	confirmations := config.Get().SteamConfig.MapChangeConfirmations
+	if confirmations <= 0 {
+		confirmations = defaultMapChangeConfirmations
+	}
+	now := time.Now().Unix()
+	var changes []models.DbMapChange
+	for _, s := range sl.Servers {
+		to := serverMapName(s)
+		if from, changed := observeMap(game+"|"+s.Host, to, confirmations); changed {
+			changes = append(changes, models.DbMapChange{ID: s.ID, Host: s.Host,
+				Game: game, FromMap: from, ToMap: to, Timestamp: now})
+		}
+	}
+	return changes
+}
+
+// recordMapChanges records the map changes of a game's servers, and notifies
+// the owners of claimed servers.
+func recordMapChanges(game string, changes []models.DbMapChange) {
+	if len(changes) == 0 || db.AppDB == nil {
+		return
+	}
+	logger.LogSteamInfo("Detected %d %s map changes", len(changes), game)
+	if err := db.AppDB.AddMapChanges(changes); err != nil {
+		logger.LogAppError(err)
+	}
+	if !config.Get().WebConfig.EnableServerClaims {
+		return
+	}
+	verified, err := db.AppDB.GetVerifiedClaims()
+	if err != nil {
+		return
+	}
+	claims := make(map[int64]models.DbServerClaim, len(verified))
+	for _, c := range verified {
+		claims[c.ID] = c
+	}
+	for _, ch := range changes {
+		c, ok := claims[ch.ID]
+		if !ok || ch.ID == 0 || c.NotifyURL == "" {
+			continue
+		}
+		go sendClaimNotification(c.NotifyURL, c.ID, mapChangeNotification{
+			ID:        c.ID,
+			Host:      ch.Host,
+			Game:      game,
+			Alias:     c.Alias,
+			Event:     "mapChange",
+			From:      ch.FromMap,
+			To:        ch.ToMap,
+			Timestamp: ch.Timestamp,
+		})
+	}
+}
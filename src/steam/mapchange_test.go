@@ -0,0 +1,59 @@
+package steam
+
+import (
+	"testing"
+
+	"github.com/syncore/a2sapi/src/models"
+)
+
+func TestServerMapName(t *testing.T) {
+	tests := []struct {
+		srv      models.APIServer
+		expected string
+	}{
+		{models.APIServer{Info: models.SteamServerInfo{Map: "Campgrounds"}},
+			"campgrounds"},
+		{models.APIServer{Info: models.SteamServerInfo{Map: "maps/de_dust2.bsp"}},
+			"de_dust2"},
+		{models.APIServer{Info: models.SteamServerInfo{Map: "Dust II"},
+			Rules: map[string]string{"MapName": "workshop/123456/de_dust2"}},
+			"de_dust2"},
+		{models.APIServer{Info: models.SteamServerInfo{Map: "bloodrun"},
+			Rules: map[string]string{"mapname": " "}}, "bloodrun"},
+	}
+	for _, tt := range tests {
+		if m := serverMapName(tt.srv); m != tt.expected {
+			t.Errorf("Expected map name %s, got: %s", tt.expected, m)
+		}
+	}
+}
+
+func TestObserveMap(t *testing.T) {
+	key := "QuakeLive|10.0.0.1:27960"
+	defer func() {
+		serverMapsMu.Lock()
+		delete(serverMaps, key)
+		serverMapsMu.Unlock()
+	}()
+	steps := []struct {
+		m       string
+		from    string
+		changed bool
+	}{
+		{"bloodrun", "", false},
+		{"campgrounds", "", false},
+		// flapped back before the change was confirmed
+		{"bloodrun", "", false},
+		{"campgrounds", "", false},
+		{"campgrounds", "bloodrun", true},
+		{"campgrounds", "", false},
+		{"", "", false},
+	}
+	for i, s := range steps {
+		from, changed := observeMap(key, s.m, 2)
+		if from != s.from || changed != s.changed {
+			t.Fatalf("Step %d: expected (%s, %v), got: (%s, %v)", i, s.from,
+				s.changed, from, changed)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package steam
+
+// masterprovider.go - Pluggable providers of the list of servers to query for a
+// game: the Steam master server (UDP), the Steam Web API, for games that run
+// their own community master servers, a custom HTTP list or DNS SRV records, and
+// for LAN deployments, discovery on the local network.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/steam/filters"
+)
+
+// Master provider names, as used in a game's masterProvider field.
+const (
+	MasterProviderValve    = "valve"
+	MasterProviderSteamWeb = "steamweb"
+	MasterProviderHTTP     = "http"
+	MasterProviderDNS      = "dns"
+	MasterProviderLAN      = "lan"
+)
+
+// maxHTTPListSize is the maximum size of a custom HTTP server list response.
+const maxHTTPListSize = 8 << 20
+
+// MasterProvider retrieves the addresses (ip:port) of the servers for a game.
+// Retrieval is abandoned if ctx is cancelled.
+type MasterProvider interface {
+	GetServers(ctx context.Context, filter filters.Filter) ([]string, error)
+}
+
+// valveMasterProvider queries the Steam master server over UDP.
+type valveMasterProvider struct{}
+
+func (valveMasterProvider) GetServers(ctx context.Context,
+	filter filters.Filter) ([]string, error) {
+	return getServers(ctx, filter)
+}
+
+// steamWebMasterProvider retrieves the server list from the Steam Web API.
+type steamWebMasterProvider struct{}
+
+func (steamWebMasterProvider) GetServers(ctx context.Context,
+	filter filters.Filter) ([]string, error) {
+	return getServersWeb(ctx, filter)
+}
+
+// httpMasterProvider retrieves the server list from the URL in the game's
+// masterAddress, which must return either a JSON array of addresses or one
+// address per line.
+type httpMasterProvider struct {
+	client *http.Client
+}
+
+func (p httpMasterProvider) GetServers(ctx context.Context,
+	filter filters.Filter) ([]string, error) {
+	if filter.Game.MasterAddress == "" {
+		return nil, fmt.Errorf("no masterAddress (URL) specified for %s",
+			filter.Game.Name)
+	}
+	req, err := http.NewRequest("GET", filter.Game.MasterAddress, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server list URL returned status %d", resp.StatusCode)
+	}
+	return parseHostList(io.LimitReader(resp.Body, maxHTTPListSize))
+}
+
+// parseHostList parses a JSON array of addresses or a newline-separated
+// list of addresses, where blank lines and lines starting with # are skipped.
+func parseHostList(r io.Reader) ([]string, error) {
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	body = bytes.TrimSpace(body)
+	var entries []string
+	if bytes.HasPrefix(body, []byte("[")) {
+		if err := json.Unmarshal(body, &entries); err != nil {
+			return nil, fmt.Errorf("unable to decode server list JSON: %s", err)
+		}
+	} else {
+		for _, line := range strings.Split(string(body), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			entries = append(entries, line)
+		}
+	}
+	return normalizeAddresses(entries), nil
+}
+
+// dnsMasterProvider retrieves the server list from the SRV records of the name
+// in the game's masterAddress (i.e: _a2s._udp.servers.example.org).
+type dnsMasterProvider struct {
+	lookupSRV func(ctx context.Context, name string) ([]*net.SRV, error)
+}
+
+func (p dnsMasterProvider) GetServers(ctx context.Context,
+	filter filters.Filter) ([]string, error) {
+	if filter.Game.MasterAddress == "" {
+		return nil, fmt.Errorf("no masterAddress (DNS name) specified for %s",
+			filter.Game.Name)
+	}
+	records, err := p.lookupSRV(ctx, filter.Game.MasterAddress)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]string, 0, len(records))
+	for _, r := range records {
+		entries = append(entries, net.JoinHostPort(strings.TrimSuffix(r.Target, "."),
+			fmt.Sprintf("%d", r.Port)))
+	}
+	return normalizeAddresses(entries), nil
+}
+
+func lookupSRV(ctx context.Context, name string) ([]*net.SRV, error) {
+	_, records, err := net.DefaultResolver.LookupSRV(ctx, "", "", name)
+	return records, err
+}
+
+// normalizeAddresses resolves each host:port entry to an ip:port address, as
+// used for A2S queries and the server ID database, skipping invalid entries and
+// duplicates.
+func normalizeAddresses(entries []string) []string {
+	seen := make(map[string]bool, len(entries))
+	addrs := make([]string, 0, len(entries))
+	for _, e := range entries {
+		addr, err := net.ResolveUDPAddr("udp4", e)
+		if err != nil || addr.Port == 0 {
+			logger.WriteDebug("Skipping invalid server list entry '%s'", e)
+			continue
+		}
+		a := addr.String()
+		if seen[a] {
+			continue
+		}
+		seen[a] = true
+		addrs = append(addrs, a)
+	}
+	return addrs
+}
+
+var masterProviders = map[string]MasterProvider{
+	MasterProviderValve:    valveMasterProvider{},
+	MasterProviderSteamWeb: steamWebMasterProvider{},
+	MasterProviderHTTP: httpMasterProvider{
+		client: &http.Client{Timeout: 30 * time.Second}},
+	MasterProviderDNS: dnsMasterProvider{lookupSRV: lookupSRV},
+	MasterProviderLAN: lanMasterProvider{},
+}
+
+// getMasterProvider returns the master provider for a game. Games that do not
+// specify a provider use the Steam Web API or the Steam master server, depending
+// on the configuration.
+func getMasterProvider(game filters.Game) (MasterProvider, error) {
+	name := strings.ToLower(game.MasterProvider)
+	if name == "" {
+		name = MasterProviderValve
+		if config.Get().SteamConfig.UseWebServerList {
+			name = MasterProviderSteamWeb
+		}
+	}
+	p, ok := masterProviders[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown master provider '%s' for %s",
+			game.MasterProvider, game.Name)
+	}
+	return p, nil
+}
+
+// NewProviderMasterQuery retrieves the servers for a given filter from the
+// game's master provider, returning a MasterQuery struct containing the hosts
+// retrieved in the event of success or an empty struct and an error in the event
+// of failure, including ctx being cancelled. A list that the Steam master server
+// cut off is returned as a partial list.
+func NewProviderMasterQuery(ctx context.Context, filter filters.Filter) (MasterQuery,
+	error) {
+	p, err := getMasterProvider(filter.Game)
+	if err != nil {
+		return MasterQuery{}, err
+	}
+	sl, partial, err := partialMasterList(p.GetServers(ctx, filter))
+	if err != nil {
+		return MasterQuery{}, err
+	}
+	if max := config.Get().SteamConfig.GameMaxHostsToReceive(
+		filter.Game.Name); max > 0 &&
+		len(sl) > max {
+		sl = sl[:max]
+	}
+	logger.LogSteamInfo("*** Retrieved %d %s servers.", len(sl), filter.Game.Name)
+
+	return MasterQuery{Servers: sl, Partial: partial}, nil
+}
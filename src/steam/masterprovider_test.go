@@ -0,0 +1,173 @@
+package steam
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/steam/filters"
+)
+
+func TestParseHostList(t *testing.T) {
+	expected := []string{"10.0.0.1:27960", "10.0.0.2:27961"}
+	lists := []string{
+		"# community servers\n10.0.0.1:27960\n\n10.0.0.2:27961\r\n10.0.0.1:27960\n",
+		` ["10.0.0.1:27960", "10.0.0.2:27961", "not-an-address"]`,
+	}
+	for _, l := range lists {
+		servers, err := parseHostList(strings.NewReader(l))
+		if err != nil {
+			t.Fatalf("Unexpected error parsing server list: %s", err)
+		}
+		if !reflect.DeepEqual(servers, expected) {
+			t.Fatalf("Expected servers %v, got: %v", expected, servers)
+		}
+	}
+}
+
+func TestHTTPMasterProvider(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		fmt.Fprintln(w, "10.0.0.3:27015")
+	}))
+	defer srv.Close()
+	game := filters.Game{Name: "Community", MasterProvider: "http",
+		MasterAddress: srv.URL}
+	p, err := getMasterProvider(game)
+	if err != nil {
+		t.Fatalf("Unexpected error getting master provider: %s", err)
+	}
+	servers, err := p.GetServers(context.Background(),
+		filters.NewFilter(game, filters.SrAll, nil))
+	if err != nil {
+		t.Fatalf("Unexpected error getting servers: %s", err)
+	}
+	if !reflect.DeepEqual(servers, []string{"10.0.0.3:27015"}) {
+		t.Fatalf("Expected server from HTTP list, got: %v", servers)
+	}
+}
+
+func TestNewProviderMasterQueryGameMaxHosts(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		fmt.Fprintln(w, "10.0.0.3:27015\n10.0.0.3:27016\n10.0.0.3:27017")
+	}))
+	defer srv.Close()
+	orig := config.Get().SteamConfig.TimedQueryGameSettings
+	defer func() { config.Get().SteamConfig.TimedQueryGameSettings = orig }()
+	config.Get().SteamConfig.TimedQueryGameSettings =
+		map[string]config.TimedQueryGame{"community": {MaximumHostsToReceive: 2}}
+
+	game := filters.Game{Name: "Community", MasterProvider: "http",
+		MasterAddress: srv.URL}
+	mq, err := NewProviderMasterQuery(context.Background(),
+		filters.NewFilter(game, filters.SrAll, nil))
+	if err != nil {
+		t.Fatalf("Unexpected error getting servers: %s", err)
+	}
+	if len(mq.Servers) != 2 {
+		t.Fatalf("Expected the game's limit of 2 servers, got: %v", mq.Servers)
+	}
+}
+
+func TestDNSMasterProvider(t *testing.T) {
+	p := dnsMasterProvider{lookupSRV: func(ctx context.Context, name string) ([]*net.SRV,
+		error) {
+		if name != "_a2s._udp.example.org" {
+			return nil, fmt.Errorf("unexpected name %s", name)
+		}
+		return []*net.SRV{&net.SRV{Target: "10.0.0.4.", Port: 27020}}, nil
+	}}
+	game := filters.Game{Name: "Community", MasterAddress: "_a2s._udp.example.org"}
+	servers, err := p.GetServers(context.Background(),
+		filters.NewFilter(game, filters.SrAll, nil))
+	if err != nil {
+		t.Fatalf("Unexpected error getting servers: %s", err)
+	}
+	if !reflect.DeepEqual(servers, []string{"10.0.0.4:27020"}) {
+		t.Fatalf("Expected server from SRV record, got: %v", servers)
+	}
+}
+
+func TestGetMasterProvider(t *testing.T) {
+	orig := config.Get().SteamConfig.UseWebServerList
+	defer func() { config.Get().SteamConfig.UseWebServerList = orig }()
+
+	config.Get().SteamConfig.UseWebServerList = false
+	if p, _ := getMasterProvider(filters.GameQuakeLive); p != (valveMasterProvider{}) {
+		t.Fatalf("Expected Steam master server provider, got: %T", p)
+	}
+	config.Get().SteamConfig.UseWebServerList = true
+	if p, _ := getMasterProvider(filters.GameQuakeLive); p != (steamWebMasterProvider{}) {
+		t.Fatalf("Expected Steam Web API provider, got: %T", p)
+	}
+	if _, err := getMasterProvider(filters.Game{Name: "x",
+		MasterProvider: "gopher"}); err == nil {
+		t.Fatalf("Expected unknown master provider to be rejected")
+	}
+}
+
+func TestParsePortList(t *testing.T) {
+	ports, err := parsePortList("27015-27017, 27960,27016")
+	if err != nil {
+		t.Fatalf("Unexpected error parsing port list: %s", err)
+	}
+	if !reflect.DeepEqual(ports, []int{27015, 27016, 27017, 27960}) {
+		t.Fatalf("Unexpected ports: %v", ports)
+	}
+	for _, invalid := range []string{"", "x", "27020-27010", "0", "70000",
+		"1-2000"} {
+		if _, err := parsePortList(invalid); err == nil {
+			t.Fatalf("Expected error for port list '%s'", invalid)
+		}
+	}
+}
+
+func TestLANMasterProvider(t *testing.T) {
+	orig := lanBroadcastAddr
+	defer func() { lanBroadcastAddr = orig }()
+	lanBroadcastAddr = net.IPv4(127, 0, 0, 1)
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %s", err)
+	}
+	defer conn.Close()
+	go func() {
+		buf := make([]byte, maxPacketSize)
+		for {
+			_, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteTo(append(append([]byte{}, expectedInfoChallengeHeader...),
+				1, 2, 3, 4), addr)
+		}
+	}()
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	start := time.Now()
+	sl, err := (lanMasterProvider{}).GetServers(context.Background(),
+		filters.Filter{Game: filters.Game{Name: "LAN",
+			MasterAddress: fmt.Sprintf("%d-%d", port, port+1)}})
+	if err != nil {
+		t.Fatalf("Unexpected error discovering servers: %s", err)
+	}
+	expected := fmt.Sprintf("127.0.0.1:%d", port)
+	if len(sl) != 1 || sl[0] != expected {
+		t.Fatalf("Expected to discover %s, got: %v", expected, sl)
+	}
+	if time.Since(start) < lanDiscoveryWait {
+		t.Fatalf("Expected to wait for responses for %s", lanDiscoveryWait)
+	}
+	if _, err := (lanMasterProvider{}).GetServers(context.Background(),
+		filters.Filter{Game: filters.Game{Name: "LAN"}}); err == nil {
+		t.Fatalf("Expected error without ports in masterAddress")
+	}
+}
@@ -0,0 +1,98 @@
+package steam
+
+// masterresume.go - Resumption of Steam master server queries that were cut off
+// by Valve's rate limiting: the next retrieval continues from the last address
+// received instead of starting again from 0.0.0.0:0.
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/syncore/a2sapi/src/steam/filters"
+)
+
+// masterResumeMaxAge is the age after which a cut off query is started again
+// from the beginning rather than resumed, since the addresses received before
+// it was cut off are then out of date.
+const masterResumeMaxAge = 30 * time.Minute
+
+// masterResume is the state of a game's master server query that was cut off.
+type masterResume struct {
+	// the query's region and filters, which must match for it to be resumed
+	Filter string `json:"filter"`
+	// the last address received, which the next query starts from
+	Seed string `json:"seed"`
+	// the addresses received before the query was cut off
+	Servers []string  `json:"servers"`
+	SavedAt time.Time `json:"savedAt"`
+}
+
+var (
+	masterResumes   = make(map[string]masterResume)
+	masterResumesMu sync.Mutex
+)
+
+// masterFilterKey returns the region and filters of a master server query.
+func masterFilterKey(filter filters.Filter) string {
+	var b strings.Builder
+	b.Write(filter.Region)
+	for _, f := range filter.Filters {
+		b.Write(f)
+	}
+	return b.String()
+}
+
+// getMasterResume returns the state of the game's master server query if it was
+// cut off recently with the same filter.
+func getMasterResume(filter filters.Filter) (masterResume, bool) {
+	masterResumesMu.Lock()
+	defer masterResumesMu.Unlock()
+	r, ok := masterResumes[strings.ToLower(filter.Game.Name)]
+	if !ok || r.Filter != masterFilterKey(filter) ||
+		time.Since(r.SavedAt) > masterResumeMaxAge {
+		return masterResume{}, false
+	}
+	return r, true
+}
+
+// setMasterResume saves the state of the game's master server query that was
+// cut off after receiving the given servers, the last of which is the seed.
+func setMasterResume(filter filters.Filter, seed string, servers []string) {
+	masterResumesMu.Lock()
+	defer masterResumesMu.Unlock()
+	masterResumes[strings.ToLower(filter.Game.Name)] = masterResume{
+		Filter:  masterFilterKey(filter),
+		Seed:    seed,
+		Servers: append([]string(nil), servers...),
+		SavedAt: time.Now(),
+	}
+}
+
+// clearMasterResume discards the state of the game's master server query once
+// it has received the whole list.
+func clearMasterResume(game string) {
+	masterResumesMu.Lock()
+	defer masterResumesMu.Unlock()
+	delete(masterResumes, strings.ToLower(game))
+}
+
+// copyMasterResumes returns the saved master server query states, for the state
+// snapshot.
+func copyMasterResumes() map[string]masterResume {
+	masterResumesMu.Lock()
+	defer masterResumesMu.Unlock()
+	m := make(map[string]masterResume, len(masterResumes))
+	for g, r := range masterResumes {
+		m[g] = r
+	}
+	return m
+}
+
+// restoreMasterResume restores a game's saved master server query state from
+// the state snapshot.
+func restoreMasterResume(game string, r masterResume) {
+	masterResumesMu.Lock()
+	defer masterResumesMu.Unlock()
+	masterResumes[strings.ToLower(game)] = r
+}
@@ -0,0 +1,65 @@
+package steam
+
+// memstats.go - Memory and garbage collection statistics of each retrieval
+// cycle, to help size instances that track games with many servers.
+
+import "runtime"
+
+// memSample holds the cumulative memory statistics at the start of a cycle that
+// the cycle's statistics are computed from.
+type memSample struct {
+	totalAlloc uint64
+	mallocs    uint64
+	numGC      uint32
+	pauseTotal uint64
+}
+
+// cycleMemory summarizes the memory allocated and the garbage collections made
+// during a cycle. The runtime's statistics are process-wide, so they include the
+// API requests served and any other games' retrievals that overlapped the cycle.
+type cycleMemory struct {
+	AllocatedBytes uint64 `json:"allocatedBytes"`
+	Allocations    uint64 `json:"allocations"`
+	// heap and memory obtained from the OS at the end of the cycle
+	HeapInUseBytes uint64  `json:"heapInUseBytes"`
+	SysBytes       uint64  `json:"sysBytes"`
+	GCs            uint32  `json:"gcs"`
+	GCPauseTotal   float64 `json:"gcPauseTotalMs"`
+	GCPauseMax     float64 `json:"gcPauseMaxMs"`
+}
+
+func readMemSample() memSample {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return sampleOf(&ms)
+}
+
+func sampleOf(ms *runtime.MemStats) memSample {
+	return memSample{totalAlloc: ms.TotalAlloc, mallocs: ms.Mallocs,
+		numGC: ms.NumGC, pauseTotal: ms.PauseTotalNs}
+}
+
+// cycleMemoryStats returns the memory statistics of a cycle that started with
+// the start sample and ended with the given statistics.
+func cycleMemoryStats(start memSample, end *runtime.MemStats) cycleMemory {
+	cm := cycleMemory{
+		AllocatedBytes: end.TotalAlloc - start.totalAlloc,
+		Allocations:    end.Mallocs - start.mallocs,
+		HeapInUseBytes: end.HeapInuse,
+		SysBytes:       end.Sys,
+		GCs:            end.NumGC - start.numGC,
+		GCPauseTotal:   float64(end.PauseTotalNs-start.pauseTotal) / 1e6,
+	}
+	// the runtime keeps the pauses of the most recent 256 collections; the pause
+	// of collection n is at PauseNs[(n+255)%256]
+	first := start.numGC + 1
+	if end.NumGC > 256 && first < end.NumGC-255 {
+		first = end.NumGC - 255
+	}
+	for n := first; n <= end.NumGC && n > start.numGC; n++ {
+		if p := float64(end.PauseNs[(n+255)%256]) / 1e6; p > cm.GCPauseMax {
+			cm.GCPauseMax = p
+		}
+	}
+	return cm
+}
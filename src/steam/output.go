@@ -0,0 +1,217 @@
+package steam
+
+// output.go - Outputs of the retrieved server list other than the API itself.
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/constants"
+	"github.com/syncore/a2sapi/src/db"
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/models"
+)
+
+var (
+	stateDB     *db.STDB
+	stateDBOnce sync.Once
+	tsExporter  db.TimeSeriesExporter
+	// guards tsExporter and serializes the exports
+	tsExporterMu sync.Mutex
+	// time-series exports that are in progress
+	tsExports sync.WaitGroup
+)
+
+func getStateDB() *db.STDB {
+	stateDBOnce.Do(func() {
+		dbfile := config.Get().OutputConfig.LatestStateDBFile
+		if dbfile == "" {
+			dbfile = constants.GetStateDBPath()
+		}
+		sdb, err := db.OpenStateDB(dbfile)
+		if err != nil {
+			logger.LogAppErrorf("Unable to open latest state DB %s: %s", dbfile, err)
+			return
+		}
+		stateDB = sdb
+	})
+	return stateDB
+}
+
+// getTimeSeriesExporter returns the time-series exporter, creating it if it
+// hasn't been yet. If it can't be created (i.e. the database is down), nil is
+// returned and creating it is retried on the next export. tsExporterMu must be
+// held.
+func getTimeSeriesExporter() db.TimeSeriesExporter {
+	if tsExporter != nil {
+		return tsExporter
+	}
+	cfg := config.Get().OutputConfig
+	e, err := db.NewTimeSeriesExporter(cfg.TimeSeriesExporter, cfg.TimeSeriesDSN,
+		cfg.TimeSeriesTable)
+	if err != nil {
+		logger.LogAppErrorf("Unable to start %s time-series export: %s",
+			cfg.TimeSeriesExporter, err)
+		return nil
+	}
+	tsExporter = e
+	return tsExporter
+}
+
+// exportTimeSeries writes a snapshot of the server list to the time-series
+// exporter. Exports are serialized so that a slow export can't pile up.
+func exportTimeSeries(game string, sl *models.APIServerList, at time.Time) {
+	tsExporterMu.Lock()
+	defer tsExporterMu.Unlock()
+	e := getTimeSeriesExporter()
+	if e == nil {
+		return
+	}
+	if err := e.Export(game, sl, at); err != nil {
+		logger.LogAppError(err)
+	}
+}
+
+// gameFiles returns the server list for a game encoded in each configured
+// format, as servers.<game>.json and so on.
+func gameFiles(game string, sl *models.APIServerList) ([]outputFile, error) {
+	formats := config.Get().OutputConfig.PerGameFileFormats
+	if len(formats) == 0 {
+		formats = []string{"json"}
+	}
+	files := make([]outputFile, 0, len(formats))
+	for _, f := range formats {
+		var b []byte
+		var ext, ctype string
+		var err error
+		switch strings.ToLower(f) {
+		case "json":
+			b, err = json.Marshal(sl)
+			ext, ctype = "json", "application/json"
+		case "hosts":
+			b = sl.HostList()
+			ext, ctype = "txt", "text/plain; charset=UTF-8"
+		case "qstat":
+			b, err = sl.QStatXML()
+			ext, ctype = "xml", "application/xml"
+		default:
+			return nil, logger.LogAppErrorf("Unknown per-game file format: %s", f)
+		}
+		if err != nil {
+			return nil, logger.LogAppErrorf("Error encoding %s server list as %s: %s",
+				game, f, err)
+		}
+		files = append(files, outputFile{name: fmt.Sprintf("servers.%s.%s", game, ext),
+			contentType: ctype, data: b})
+	}
+	return files, nil
+}
+
+// writeGameFile writes the server list for a game to servers.<game>.json and
+// to the file of each other configured format in the per-game file directory.
+func writeGameFile(game string, files []outputFile) error {
+	return writeToSink(fileSink{dir: config.Get().OutputConfig.PerGameFileDirectory},
+		game, files)
+}
+
+// writeOutputs writes the server list for a game to any enabled outputs.
+func writeOutputs(game string, sl *models.APIServerList) {
+	cfg := config.Get().OutputConfig
+	if cfg.EnablePerGameFiles || len(cfg.OutputSinks) > 0 {
+		files, err := gameFiles(game, sl)
+		if err != nil {
+			logger.LogAppError(err)
+		} else {
+			if cfg.EnablePerGameFiles {
+				if err := writeGameFile(game, files); err != nil {
+					logger.LogAppError(err)
+				}
+			}
+			if len(cfg.OutputSinks) > 0 {
+				startPublishToSinks(game, files)
+			}
+		}
+	}
+	writeLatestState(game, sl)
+	writeCycleSnapshot(game, sl)
+	if config.Get().OutputConfig.TimeSeriesExporter != "" {
+		tsExports.Add(1)
+		go func(at time.Time) {
+			defer tsExports.Done()
+			exportTimeSeries(game, sl, at)
+		}(time.Now())
+	}
+}
+
+// writeLatestState updates the game's servers in the latest state table, if it
+// is enabled.
+func writeLatestState(game string, sl *models.APIServerList) {
+	if !config.Get().OutputConfig.EnableLatestStateTable {
+		return
+	}
+	if sdb := getStateDB(); sdb != nil {
+		if err := sdb.UpdateLatestState(game, sl); err != nil {
+			logger.LogAppError(err)
+		}
+	}
+}
+
+// writeCycleSnapshot stores the game's server list in the app DB, if cycle
+// snapshots are enabled, and deletes the lists that are older than the
+// configured retention.
+func writeCycleSnapshot(game string, sl *models.APIServerList) {
+	cfg := config.Get().OutputConfig
+	if !cfg.EnableCycleSnapshots || db.AppDB == nil {
+		return
+	}
+	now := time.Now()
+	at := sl.RetrievedTimeStamp
+	if at == 0 {
+		at = now.Unix()
+	}
+	if err := db.AppDB.AddCycleSnapshot(game, sl, at); err != nil {
+		logger.LogAppError(err)
+		return
+	}
+	if cfg.CycleSnapshotRetention <= 0 {
+		return
+	}
+	pruned, err := db.AppDB.PruneCycleSnapshots(now.Add(-time.Duration(
+		cfg.CycleSnapshotRetention) * time.Hour).Unix())
+	if err != nil {
+		logger.LogAppError(err)
+		return
+	}
+	if pruned > 0 {
+		logger.WriteDebug("Deleted %d server list snapshots older than %d hours",
+			pruned, cfg.CycleSnapshotRetention)
+	}
+}
+
+// waitForOutputs waits for the uploads to the output sinks and the time-series
+// exports that are in progress to finish.
+func waitForOutputs() {
+	waitForSinks()
+	tsExports.Wait()
+}
+
+// CloseOutputs waits for the outputs and webhook deliveries that are in progress
+// to finish and then closes the latest state database and the time-series
+// exporter. It is called on shutdown, once no more retrievals will be made.
+func CloseOutputs() {
+	waitForGeoIPJob()
+	waitForOutputs()
+	waitForWebhooks()
+	if stateDB != nil {
+		stateDB.Close()
+	}
+	tsExporterMu.Lock()
+	defer tsExporterMu.Unlock()
+	if tsExporter != nil {
+		tsExporter.Close()
+	}
+}
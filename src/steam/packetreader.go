@@ -0,0 +1,189 @@
+package steam
+
+// packetreader.go - Bounds-checked reading of A2S responses, with a lenient mode
+// that salvages what it can from nonconforming (i.e: modded) servers' responses.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/syncore/a2sapi/src/config"
+)
+
+// parseWarning describes a problem with a single field of an A2S response that
+// was tolerated by the lenient parser.
+type parseWarning struct {
+	field   string
+	message string
+}
+
+func (w parseWarning) String() string {
+	return fmt.Sprintf("%s: %s", w.field, w.message)
+}
+
+// packetReader reads values from an A2S response. In strict mode the first
+// problem is kept as an error and later reads return zero values; in lenient
+// mode problems are recorded as warnings and the read values are salvaged.
+type packetReader struct {
+	b         []byte
+	lenient   bool
+	truncated bool
+	warnings  []parseWarning
+	err       error
+}
+
+func newPacketReader(b []byte, lenient bool) *packetReader {
+	return &packetReader{b: b, lenient: lenient}
+}
+
+func (r *packetReader) fail(field, message string) {
+	if r.lenient {
+		r.warnings = append(r.warnings, parseWarning{field: field, message: message})
+		return
+	}
+	if r.err == nil {
+		r.err = ErrMalformedPacket(fmt.Sprintf("%s: %s", field, message))
+	}
+}
+
+// next returns the next n bytes, or nil if the response is too short, in which
+// case the field and all of the fields after it are missing.
+func (r *packetReader) next(field string, n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.b) < n {
+		if !r.truncated {
+			r.fail(field, "response truncated; this and later fields are missing")
+			r.truncated = true
+		} else if r.lenient {
+			r.warnings = append(r.warnings, parseWarning{field: field,
+				message: "missing"})
+		}
+		r.b = r.b[len(r.b):]
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *packetReader) readByte(field string) byte {
+	if v := r.next(field, 1); v != nil {
+		return v[0]
+	}
+	return 0
+}
+
+func (r *packetReader) readUint16(field string) uint16 {
+	if v := r.next(field, 2); v != nil {
+		return binary.LittleEndian.Uint16(v)
+	}
+	return 0
+}
+
+func (r *packetReader) readUint32(field string) uint32 {
+	if v := r.next(field, 4); v != nil {
+		return binary.LittleEndian.Uint32(v)
+	}
+	return 0
+}
+
+func (r *packetReader) readUint64(field string) uint64 {
+	if v := r.next(field, 8); v != nil {
+		return binary.LittleEndian.Uint64(v)
+	}
+	return 0
+}
+
+// readString reads a null-terminated string. If the terminator is missing, the
+// rest of the response is salvaged as the string in lenient mode.
+func (r *packetReader) readString(field string) string {
+	if r.err != nil {
+		return ""
+	}
+	if len(r.b) == 0 {
+		r.next(field, 1)
+		return ""
+	}
+	nul := bytes.IndexByte(r.b, 0x00)
+	if nul == -1 {
+		r.fail(field, "missing string terminator")
+		s := string(r.b)
+		r.b = r.b[len(r.b):]
+		r.truncated = true
+		return s
+	}
+	s := string(r.b[:nul])
+	r.b = r.b[nul+1:]
+	return s
+}
+
+// warn records a warning for an anomaly that has always been tolerated, even in
+// strict mode, so it is only reported in lenient mode.
+func (r *packetReader) warn(field, message string) {
+	if r.lenient {
+		r.warnings = append(r.warnings, parseWarning{field: field, message: message})
+	}
+}
+
+// checkTrailing warns about unexpected (non-padding) bytes left at the end of
+// the response.
+func (r *packetReader) checkTrailing(field string) {
+	if len(bytes.Trim(r.b, "\x00")) == 0 {
+		return
+	}
+	r.warn(field, fmt.Sprintf("%d unexpected trailing bytes", len(r.b)))
+}
+
+func useLenientParsing() bool {
+	return config.Get() != nil && config.Get().SteamConfig.LenientParsing
+}
+
+// serverParseWarnings holds the lenient parser's warnings for each host until
+// the host's entry in the server list is built.
+var serverParseWarnings = struct {
+	sync.Mutex
+	m map[string][]parseWarning
+}{m: make(map[string][]parseWarning)}
+
+// recordParseWarnings stores the warnings from parsing one of a host's A2S
+// responses, replacing any earlier warnings from the same kind of response.
+func recordParseWarnings(host, kind string, warnings []parseWarning) {
+	serverParseWarnings.Lock()
+	defer serverParseWarnings.Unlock()
+	existing := serverParseWarnings.m[host]
+	kept := make([]parseWarning, 0, len(existing)+len(warnings))
+	for _, w := range existing {
+		if w.field != kind && !strings.HasPrefix(w.field, kind+".") {
+			kept = append(kept, w)
+		}
+	}
+	kept = append(kept, warnings...)
+	if len(kept) == 0 {
+		delete(serverParseWarnings.m, host)
+		return
+	}
+	serverParseWarnings.m[host] = kept
+}
+
+// takeParseWarnings removes and returns the warnings recorded for a host, along
+// with the fields that are partial (missing or salvaged) because of them.
+func takeParseWarnings(host string) (warnings []string, partial []string) {
+	serverParseWarnings.Lock()
+	pw := serverParseWarnings.m[host]
+	delete(serverParseWarnings.m, host)
+	serverParseWarnings.Unlock()
+	seen := make(map[string]bool, len(pw))
+	for _, w := range pw {
+		warnings = append(warnings, w.String())
+		if !seen[w.field] {
+			seen[w.field] = true
+			partial = append(partial, w.field)
+		}
+	}
+	return warnings, partial
+}
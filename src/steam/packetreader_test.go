@@ -0,0 +1,69 @@
+package steam
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseServerInfoLenient(t *testing.T) {
+	// game name is missing its string terminator and the rest is cut off
+	data := append([]byte{0xFF, 0xFF, 0xFF, 0xFF, 0x49, 0x11},
+		[]byte("modded server\x00bloodrun\x00baseq3\x00Quake Live")...)
+	if _, _, err := parseServerInfo(data, false); err == nil {
+		t.Fatalf("Expected malformed server info to be rejected in strict mode")
+	}
+	sinfo, warnings, err := parseServerInfo(data, true)
+	if err != nil {
+		t.Fatalf("Unexpected error when leniently parsing server info: %s", err)
+	}
+	if sinfo.Name != "modded server" || sinfo.Map != "bloodrun" ||
+		sinfo.Game != "Quake Live" {
+		t.Fatalf("Expected salvaged name, map, and game, got: %+v", sinfo)
+	}
+	if len(warnings) == 0 || warnings[0].field != "info.game" {
+		t.Fatalf("Expected first warning to be for info.game, got: %v", warnings)
+	}
+}
+
+func TestParsePlayerInfoLenient(t *testing.T) {
+	// claims 3 players, but the second player's entry is cut off
+	data := []byte{0xFF, 0xFF, 0xFF, 0xFF, 0x44, 0x03,
+		0x00, 0x61, 0x00, 0x05, 0x00, 0x00, 0x00, 0x00, 0x00, 0x20, 0x41,
+		0x01, 0x62, 0x00, 0x07}
+	if _, _, err := parsePlayerInfo(data, false); err == nil {
+		t.Fatalf("Expected truncated player info to be rejected in strict mode")
+	}
+	players, warnings, err := parsePlayerInfo(data, true)
+	if err != nil {
+		t.Fatalf("Unexpected error when leniently parsing player info: %s", err)
+	}
+	if len(players) != 1 || players[0].Name != "a" || players[0].Score != 5 ||
+		players[0].TimeConnectedSecs != 10 {
+		t.Fatalf("Expected the first, complete player to be salvaged, got: %+v",
+			players)
+	}
+	if len(warnings) == 0 {
+		t.Fatalf("Expected warnings for the truncated player list")
+	}
+}
+
+func TestParseWarningsForHost(t *testing.T) {
+	host := "10.0.0.1:27960"
+	recordParseWarnings(host, "info", []parseWarning{
+		parseWarning{field: "info.keywords", message: "missing string terminator"}})
+	recordParseWarnings(host, "rules", []parseWarning{
+		parseWarning{field: "rules", message: "expected 3 rules, got 2"},
+		parseWarning{field: "rules", message: "rule 'x' has no value"}})
+	// a successful retry of the info request replaces its earlier warnings
+	recordParseWarnings(host, "info", nil)
+	warnings, partial := takeParseWarnings(host)
+	if len(warnings) != 2 {
+		t.Fatalf("Expected 2 warnings, got: %v", warnings)
+	}
+	if !reflect.DeepEqual(partial, []string{"rules"}) {
+		t.Fatalf("Expected rules to be the only partial field, got: %v", partial)
+	}
+	if w, _ := takeParseWarnings(host); w != nil {
+		t.Fatalf("Expected warnings to be removed once taken, got: %v", w)
+	}
+}
@@ -0,0 +1,72 @@
+package steam
+
+// playerextra.go - Per-game enrichment of players with extra information (i.e:
+// their team) that some games only expose through A2S_RULES.
+
+import (
+	"strings"
+
+	"github.com/syncore/a2sapi/src/models"
+	"github.com/syncore/a2sapi/src/steam/filters"
+)
+
+// playerEnricher sets the extra fields of a server's players from its rules.
+type playerEnricher func(players []models.SteamPlayerInfo,
+	rules map[string]string)
+
+// playerEnrichers are the enrichers of the games that have extra player info,
+// by lower case game name.
+var playerEnrichers = map[string]playerEnricher{
+	strings.ToLower(filters.GameQuakeLive.Name): enrichQLTeams,
+}
+
+// qlTeamRules are the Quake Live rules that list the (comma-separated) names of
+// the players on each team, and the team they are assigned.
+var qlTeamRules = map[string]string{
+	"players_red":  "red",
+	"players_blue": "blue",
+}
+
+// enrichPlayers returns the players with the game's extra fields set. The
+// players are copied if the game has an enricher, so that the A2S results
+// themselves are not modified.
+func enrichPlayers(game filters.Game, players []models.SteamPlayerInfo,
+	rules map[string]string) []models.SteamPlayerInfo {
+	enrich, ok := playerEnrichers[strings.ToLower(game.Name)]
+	if !ok || len(players) == 0 || len(rules) == 0 {
+		return players
+	}
+	enriched := make([]models.SteamPlayerInfo, len(players))
+	copy(enriched, players)
+	enrich(enriched, rules)
+	return enriched
+}
+
+// setPlayerExtra sets an extra field of a player.
+func setPlayerExtra(p *models.SteamPlayerInfo, key, value string) {
+	if p.Extra == nil {
+		p.Extra = make(map[string]string)
+	}
+	p.Extra[key] = value
+}
+
+// enrichQLTeams assigns Quake Live players to the team whose rule lists their
+// name.
+func enrichQLTeams(players []models.SteamPlayerInfo, rules map[string]string) {
+	teams := make(map[string]string)
+	for rule, team := range qlTeamRules {
+		for _, name := range strings.Split(rules[rule], ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				teams[name] = team
+			}
+		}
+	}
+	if len(teams) == 0 {
+		return
+	}
+	for i := range players {
+		if team, ok := teams[strings.TrimSpace(players[i].Name)]; ok {
+			setPlayerExtra(&players[i], "team", team)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package steam
+
+import (
+	"testing"
+
+	"github.com/syncore/a2sapi/src/models"
+	"github.com/syncore/a2sapi/src/steam/filters"
+)
+
+func TestEnrichPlayers(t *testing.T) {
+	players := []models.SteamPlayerInfo{{Name: "alpha"}, {Name: "bravo"},
+		{Name: "charlie"}}
+	rules := map[string]string{"players_red": "alpha, charlie",
+		"players_blue": "bravo"}
+
+	enriched := enrichPlayers(filters.GameQuakeLive, players, rules)
+	for i, team := range []string{"red", "blue", "red"} {
+		if enriched[i].Extra["team"] != team {
+			t.Errorf("Expected %s to be on team %s, got: %v", enriched[i].Name, team,
+				enriched[i].Extra)
+		}
+	}
+	if players[0].Extra != nil {
+		t.Fatalf("Expected the A2S results not to be modified")
+	}
+
+	// players not on a team, and games without an enricher, have no extra info
+	enriched = enrichPlayers(filters.GameQuakeLive, players,
+		map[string]string{"players_red": "alpha"})
+	if enriched[1].Extra != nil {
+		t.Errorf("Expected no extra info for player without team, got: %v",
+			enriched[1].Extra)
+	}
+	enriched = enrichPlayers(filters.GameReflex, players, rules)
+	if enriched[0].Extra != nil {
+		t.Errorf("Expected no extra info for game without enricher, got: %v",
+			enriched[0].Extra)
+	}
+}
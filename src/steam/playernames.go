@@ -0,0 +1,141 @@
+package steam
+
+// playernames.go - Per-game decoding of player names into a clean, displayable
+// form (i.e: without Quake color codes), which is returned alongside the raw
+// name so that API consumers don't each have to strip names themselves.
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/models"
+	"github.com/syncore/a2sapi/src/steam/filters"
+)
+
+// Player name decoders, as set with playerNameDecoder in the games file.
+const (
+	// only invalid UTF-8, control characters, and surrounding whitespace are
+	// removed; used by games without a decoder
+	NameDecoderPlain = "plain"
+	// Quake color codes (a caret followed by a digit) are also removed
+	NameDecoderQuake = "quake"
+	// invisible formatting characters (i.e: zero-width and bidirectional
+	// overrides) and stacked combining marks, which Source engine players use to
+	// make blank or look-alike names, are also removed
+	NameDecoderSource = "source"
+)
+
+// maxCombiningMarks is the number of combining marks kept on each character by
+// the Source decoder; names with more are usually "zalgo" text.
+const maxCombiningMarks = 2
+
+// playerNameDecoder returns the clean form of a player name.
+type playerNameDecoder func(name string) string
+
+var playerNameDecoders = map[string]playerNameDecoder{
+	NameDecoderPlain:  cleanPlainName,
+	NameDecoderQuake:  cleanQuakeName,
+	NameDecoderSource: cleanSourceName,
+}
+
+// defaultNameDecoders are the decoders of the default games that don't set one,
+// by lower case game name.
+var defaultNameDecoders = map[string]string{
+	strings.ToLower(filters.GameQuakeLive.Name):     NameDecoderQuake,
+	strings.ToLower(filters.GameAlienSwarm.Name):    NameDecoderSource,
+	strings.ToLower(filters.GameCsGo.Name):          NameDecoderSource,
+	strings.ToLower(filters.GameCSSource.Name):      NameDecoderSource,
+	strings.ToLower(filters.GameGarrysMod.Name):     NameDecoderSource,
+	strings.ToLower(filters.GameHL2DM.Name):         NameDecoderSource,
+	strings.ToLower(filters.GameL4D2.Name):          NameDecoderSource,
+	strings.ToLower(filters.GameTF2.Name):           NameDecoderSource,
+	strings.ToLower(filters.GameOpposingForce.Name): NameDecoderSource,
+}
+
+// nameDecoderFor returns the player name decoder of a game: the one set in the
+// games file, the default for the game, or the plain decoder.
+func nameDecoderFor(game filters.Game) playerNameDecoder {
+	name := strings.ToLower(game.PlayerNameDecoder)
+	if name == "" {
+		name = defaultNameDecoders[strings.ToLower(game.Name)]
+	}
+	if name == "" {
+		return cleanPlainName
+	}
+	d, ok := playerNameDecoders[name]
+	if !ok {
+		logger.WriteDebug("Unknown player name decoder '%s' for %s; using %s",
+			game.PlayerNameDecoder, game.Name, NameDecoderPlain)
+		return cleanPlainName
+	}
+	return d
+}
+
+// decodePlayerNames returns the players with their clean names set by the
+// game's decoder. The players are copied, so that the A2S results themselves are
+// not modified.
+func decodePlayerNames(game filters.Game,
+	players []models.SteamPlayerInfo) []models.SteamPlayerInfo {
+	if len(players) == 0 {
+		return players
+	}
+	decode := nameDecoderFor(game)
+	decoded := make([]models.SteamPlayerInfo, len(players))
+	copy(decoded, players)
+	for i := range decoded {
+		decoded[i].CleanName = decode(decoded[i].Name)
+	}
+	return decoded
+}
+
+// cleanPlainName removes invalid UTF-8, control characters, and surrounding
+// whitespace from a name.
+func cleanPlainName(name string) string {
+	return strings.TrimSpace(strings.Map(func(r rune) rune {
+		if r == utf8.RuneError || unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name))
+}
+
+// cleanQuakeName removes Quake color codes from a name, as well as what
+// cleanPlainName removes.
+func cleanQuakeName(name string) string {
+	if strings.Contains(name, "^") {
+		var b strings.Builder
+		for i := 0; i < len(name); i++ {
+			if name[i] == '^' && i+1 < len(name) && name[i+1] >= '0' &&
+				name[i+1] <= '9' {
+				i++
+				continue
+			}
+			b.WriteByte(name[i])
+		}
+		name = b.String()
+	}
+	return cleanPlainName(name)
+}
+
+// cleanSourceName removes invisible formatting characters and stacked
+// combining marks from a name, as well as what cleanPlainName removes.
+func cleanSourceName(name string) string {
+	var b strings.Builder
+	marks := 0
+	for _, r := range name {
+		switch {
+		case unicode.Is(unicode.Cf, r):
+			continue
+		case unicode.Is(unicode.Mn, r):
+			if marks++; marks > maxCombiningMarks {
+				continue
+			}
+		default:
+			marks = 0
+		}
+		b.WriteRune(r)
+	}
+	return cleanPlainName(b.String())
+}
@@ -0,0 +1,55 @@
+package steam
+
+import (
+	"testing"
+
+	"github.com/syncore/a2sapi/src/models"
+	"github.com/syncore/a2sapi/src/steam/filters"
+)
+
+func TestPlayerNameDecoders(t *testing.T) {
+	tests := []struct {
+		decode   playerNameDecoder
+		name     string
+		expected string
+	}{
+		{cleanPlainName, "  player\x00\x07 ", "player"},
+		{cleanPlainName, "bad\xffutf8", "badutf8"},
+		{cleanPlainName, "^1red", "^1red"},
+		{cleanQuakeName, "^1R^7ed ^xPlayer^", "Red ^xPlayer^"},
+		{cleanQuakeName, "^0^1^2", ""},
+		{cleanSourceName, "\u202eemankcin", "emankcin"},
+		{cleanSourceName, "zero\u200bwidth", "zerowidth"},
+		{cleanSourceName, "Z\u0353\u0354\u0351\u0352algo", "Z\u0353\u0354algo"},
+		{cleanSourceName, "Jos\u0301e", "Jos\u0301e"},
+	}
+	for _, tt := range tests {
+		if got := tt.decode(tt.name); got != tt.expected {
+			t.Fatalf("Expected %q to decode to %q, got: %q", tt.name, tt.expected,
+				got)
+		}
+	}
+}
+
+func TestDecodePlayerNames(t *testing.T) {
+	players := []models.SteamPlayerInfo{{Name: "^1Klesk"}, {Name: "^4Xaero "}}
+	decoded := decodePlayerNames(filters.GameQuakeLive, players)
+	if decoded[0].CleanName != "Klesk" || decoded[1].CleanName != "Xaero" {
+		t.Fatalf("Expected Quake Live names without color codes, got: %+v", decoded)
+	}
+	if players[0].CleanName != "" {
+		t.Fatalf("Expected the A2S results not to be modified")
+	}
+	// the games file's decoder overrides the game's default
+	game := filters.GameQuakeLive
+	game.PlayerNameDecoder = NameDecoderPlain
+	if d := decodePlayerNames(game, players); d[0].CleanName != "^1Klesk" {
+		t.Fatalf("Expected the plain decoder to keep color codes, got: %q",
+			d[0].CleanName)
+	}
+	game.PlayerNameDecoder = "unknown"
+	if d := decodePlayerNames(game, players); d[1].CleanName != "^4Xaero" {
+		t.Fatalf("Expected an unknown decoder to fall back to plain, got: %q",
+			d[1].CleanName)
+	}
+}
@@ -4,6 +4,7 @@ package steam
 // for building a list to return to the API
 
 import (
+	"context"
 	"sync"
 
 	"github.com/syncore/a2sapi/src/logger"
@@ -18,101 +19,76 @@ type a2sData struct {
 	Players    map[string][]models.SteamPlayerInfo
 }
 
-func batchInfoQuery(servers []string) map[string]models.SteamServerInfo {
+func batchInfoQuery(ctx context.Context, servers []string, src querySource,
+	budget *queryBudget) map[string]models.SteamServerInfo {
 	m := make(map[string]models.SteamServerInfo)
-	var wg sync.WaitGroup
 	var mut sync.Mutex
 	var failed []string
 
-	for _, h := range servers {
-		wg.Add(1)
-		go func(host string) {
-			serverinfo, err := GetInfoForServer(host, QueryTimeout)
-			if err != nil {
-				mut.Lock()
-				failed = append(failed, host)
-				mut.Unlock()
-				wg.Done()
-				return
-			}
-			mut.Lock()
-			m[host] = serverinfo
-			mut.Unlock()
-			wg.Done()
-		}(h)
-	}
-	wg.Wait()
-	retried := RetryFailedInfoReq(failed, 3)
+	forEachHost(servers, func(host string) {
+		serverinfo, err := limitedInfoQuery(ctx, host, budget)
+		mut.Lock()
+		defer mut.Unlock()
+		if err != nil {
+			failed = append(failed, host)
+			return
+		}
+		m[host] = serverinfo
+	})
+	retried := RetryFailedInfoReq(ctx, failed, 3, budget)
 	for k, v := range retried {
 		m[k] = v
 	}
+	recordBatch(reqTypeInfo, src, len(servers), len(servers)-len(m))
 	return m
 }
 
-func batchPlayerQuery(servers []string) map[string][]models.SteamPlayerInfo {
+func batchPlayerQuery(ctx context.Context, servers []string, src querySource,
+	budget *queryBudget) map[string][]models.SteamPlayerInfo {
 	m := make(map[string][]models.SteamPlayerInfo)
-	var wg sync.WaitGroup
 	var mut sync.Mutex
 	var failed []string
 
-	for _, h := range servers {
-		wg.Add(1)
-		go func(host string) {
-			players, err := GetPlayersForServer(host, QueryTimeout)
-			if err != nil {
-				// server could just be empty
-				if err != ErrNoPlayers {
-					mut.Lock()
-					failed = append(failed, host)
-					mut.Unlock()
-					wg.Done()
-					return
-				}
-			}
-			mut.Lock()
-			m[host] = players
-			mut.Unlock()
-			wg.Done()
-		}(h)
-	}
-	wg.Wait()
-	retried := RetryFailedPlayersReq(failed, QueryRetryCount)
+	forEachHost(servers, func(host string) {
+		players, err := limitedPlayerQuery(ctx, host, budget)
+		mut.Lock()
+		defer mut.Unlock()
+		// server could just be empty
+		if err != nil && err != ErrNoPlayers {
+			failed = append(failed, host)
+			return
+		}
+		m[host] = players
+	})
+	retried := RetryFailedPlayersReq(ctx, failed, QueryRetryCount, budget)
 	for k, v := range retried {
 		m[k] = v
 	}
+	recordBatch(reqTypePlayers, src, len(servers), len(servers)-len(m))
 	return m
 }
 
-func batchRuleQuery(servers []string) map[string]map[string]string {
+func batchRuleQuery(ctx context.Context, servers []string, src querySource,
+	budget *queryBudget) map[string]map[string]string {
 	m := make(map[string]map[string]string)
-	var wg sync.WaitGroup
 	var mut sync.Mutex
 	var failed []string
-	for _, h := range servers {
-		wg.Add(1)
-		go func(host string) {
-			rules, err := GetRulesForServer(host, QueryTimeout)
-			if err != nil {
-				// server might have no rules
-				if err != ErrNoRules {
-					mut.Lock()
-					failed = append(failed, host)
-					mut.Unlock()
-					wg.Done()
-					return
-				}
-			}
-			mut.Lock()
-			m[host] = rules
-			mut.Unlock()
-			wg.Done()
-		}(h)
-	}
-	wg.Wait()
-	retried := RetryFailedRulesReq(failed, QueryRetryCount)
+	forEachHost(servers, func(host string) {
+		rules, err := limitedRulesQuery(ctx, host, budget)
+		mut.Lock()
+		defer mut.Unlock()
+		// server might have no rules
+		if err != nil && err != ErrNoRules {
+			failed = append(failed, host)
+			return
+		}
+		m[host] = rules
+	})
+	retried := RetryFailedRulesReq(ctx, failed, QueryRetryCount, budget)
 	for k, v := range retried {
 		m[k] = v
 	}
+	recordBatch(reqTypeRules, src, len(servers), len(servers)-len(m))
 	return m
 }
 
@@ -122,16 +98,24 @@ func batchRuleQuery(servers []string) map[string]map[string]string {
 // supplied host represents rests on potentially unreliable assumptions, which if
 // not true would cause games with incomplete support for all three A2S queries
 // (e.g. Reflex) to always fail. A production environment should use Query() instead.
+// Results are shared with other requests for the configured cache time.
 func DirectQuery(hosts []string) (*models.APIServerList, error) {
+	return queryCached("addr", hosts, directQuery)
+}
+
+func directQuery(hosts []string) (*models.APIServerList, error) {
 	hg := make(map[string]filters.Game, len(hosts))
 
 	// Try to account for the fact that we can't determine the game ahead of time
 	// for user-specified direct host queries -- a number of assumptions:
 	// (1) A2S_INFO for game/host, (2) extra data A2S_INFO flag & field w/ appid,
 	//(3) game has been defined in game.go with the correct AppID and A2S ignore flags
-	info := batchInfoQuery(hosts)
-	needsRules := make([]string, len(hosts))
-	needsPlayers := make([]string, len(hosts))
+	// results are shared between requests, so aren't tied to any one of them
+	ctx := context.Background()
+	budget := newQueryBudget()
+	info := batchInfoQuery(ctx, hosts, sourceDirect, budget)
+	needsRules := make([]string, 0, len(hosts))
+	needsPlayers := make([]string, 0, len(hosts))
 
 	for _, h := range hosts {
 		logger.WriteDebug("direct query for %s. will try to figure out needed queries", h)
@@ -157,10 +141,10 @@ func DirectQuery(hosts []string) (*models.APIServerList, error) {
 	data := a2sData{
 		HostsGames: hg,
 		Info:       info,
-		Rules:      batchRuleQuery(needsRules),
-		Players:    batchPlayerQuery(needsPlayers),
+		Rules:      batchRuleQuery(ctx, needsRules, sourceDirect, budget),
+		Players:    batchPlayerQuery(ctx, needsPlayers, sourceDirect, budget),
 	}
-	sl, err := buildServerList(data, true)
+	sl, err := buildServerList(data, serverDBAsync)
 	if err != nil {
 		return models.GetDefaultServerList(), logger.LogAppError(err)
 	}
@@ -170,11 +154,30 @@ func DirectQuery(hosts []string) (*models.APIServerList, error) {
 // Query retrieves the server information for a given set of host to game pairs
 // and returns it in a format that is presented to the API. It takes a map consisting
 // of host(s) and their corresponding game names (i.e: k:127.0.0.1:27960, v:"QuakeLive")
+// Results are shared with other requests for the configured cache time.
 func Query(hostsgames map[string]string) (*models.APIServerList, error) {
+	hosts := make([]string, 0, len(hostsgames))
+	for h := range hostsgames {
+		hosts = append(hosts, h)
+	}
+	return queryCached("id", hosts, func(missing []string) (*models.APIServerList,
+		error) {
+		hg := make(map[string]string, len(missing))
+		for _, h := range missing {
+			hg[h] = hostsgames[h]
+		}
+		return queryHosts(hg, sourceID)
+	})
+}
+
+// queryHosts retrieves the server information for host to game pairs, counting
+// the A2S requests towards the given source.
+func queryHosts(hostsgames map[string]string, src querySource) (*models.APIServerList,
+	error) {
 	hg := make(map[string]filters.Game, len(hostsgames))
-	needsPlayers := make([]string, len(hostsgames))
-	needsRules := make([]string, len(hostsgames))
-	needsInfo := make([]string, len(hostsgames))
+	needsPlayers := make([]string, 0, len(hostsgames))
+	needsRules := make([]string, 0, len(hostsgames))
+	needsInfo := make([]string, 0, len(hostsgames))
 
 	for host, game := range hostsgames {
 		fg := filters.GetGameByName(game)
@@ -189,14 +192,16 @@ func Query(hostsgames map[string]string) (*models.APIServerList, error) {
 			needsInfo = append(needsInfo, host)
 		}
 	}
+	ctx := context.Background()
+	budget := newQueryBudget()
 	data := a2sData{
 		HostsGames: hg,
-		Info:       batchInfoQuery(needsInfo),
-		Rules:      batchRuleQuery(needsRules),
-		Players:    batchPlayerQuery(needsPlayers),
+		Info:       batchInfoQuery(ctx, needsInfo, src, budget),
+		Rules:      batchRuleQuery(ctx, needsRules, src, budget),
+		Players:    batchPlayerQuery(ctx, needsPlayers, src, budget),
 	}
 
-	sl, err := buildServerList(data, true)
+	sl, err := buildServerList(data, serverDBAsync)
 	if err != nil {
 		return models.GetDefaultServerList(), logger.LogAppError(err)
 	}
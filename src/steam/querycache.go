@@ -0,0 +1,177 @@
+package steam
+
+// querycache.go - Short-lived cache of direct query results shared across all
+// clients, so that a server that many clients ask about at once is queried at
+// most once per cache period.
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/models"
+	"github.com/syncore/a2sapi/src/util"
+)
+
+// cachedQuery is the result of querying a server; failed is true if the server
+// did not respond.
+type cachedQuery struct {
+	srv    models.APIServer
+	failed bool
+	at     time.Time
+}
+
+// inflightQuery is a query of a host that is in progress; done is closed when
+// it finishes, after err is set to the query's error, if any.
+type inflightQuery struct {
+	done chan struct{}
+	err  error
+}
+
+// errQueryAborted is the error of a query that panicked.
+var errQueryAborted = errors.New("direct query was aborted")
+
+// directQueries holds the results of direct queries by kind and host, and the
+// hosts that are currently being queried.
+var directQueries = struct {
+	sync.Mutex
+	results  map[string]cachedQuery
+	inflight map[string]*inflightQuery
+}{
+	results:  make(map[string]cachedQuery),
+	inflight: make(map[string]*inflightQuery),
+}
+
+var directQueryStats = util.RegisterCache("directQueries", func() int {
+	directQueries.Lock()
+	defer directQueries.Unlock()
+	return len(directQueries.results)
+}, func() int {
+	directQueries.Lock()
+	defer directQueries.Unlock()
+	n := len(directQueries.results)
+	directQueries.results = make(map[string]cachedQuery)
+	return n
+})
+
+// queryCached returns the results for the hosts, querying with query only the
+// hosts that have no cached results younger than the configured cache time and
+// that are not already being queried. Results are cached by kind (the type of
+// query) and host. If the query of any of the hosts fails, its error is
+// returned, including to the requests that were waiting for it.
+func queryCached(kind string, hosts []string,
+	query func(hosts []string) (*models.APIServerList, error)) (*models.APIServerList,
+	error) {
+	ttl := time.Duration(config.Get().WebConfig.DirectQueryCacheTime) * time.Second
+	if ttl <= 0 {
+		return query(hosts)
+	}
+	now := time.Now()
+	var missing []string
+	var waits []*inflightQuery
+	directQueries.Lock()
+	for _, h := range hosts {
+		key := kind + "|" + h
+		if c, ok := directQueries.results[key]; ok && now.Sub(c.at) < ttl {
+			directQueryStats.Hit()
+			continue
+		}
+		directQueryStats.Miss()
+		if wait, ok := directQueries.inflight[key]; ok {
+			waits = append(waits, wait)
+			continue
+		}
+		directQueries.inflight[key] = &inflightQuery{done: make(chan struct{})}
+		missing = append(missing, h)
+	}
+	directQueries.Unlock()
+
+	var err error
+	if len(missing) > 0 {
+		err = queryMissing(kind, missing, query, ttl)
+	}
+	for _, wait := range waits {
+		<-wait.done
+		if err == nil {
+			err = wait.err
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sl := &models.APIServerList{
+		RetrievedAt:        now.Format("Mon Jan 2 15:04:05 2006 EST"),
+		RetrievedTimeStamp: now.Unix(),
+		Servers:            make([]models.APIServer, 0, len(hosts)),
+		FailedServers:      make([]string, 0),
+	}
+	directQueries.Lock()
+	for _, h := range hosts {
+		c, ok := directQueries.results[kind+"|"+h]
+		if !ok || c.failed {
+			sl.FailedServers = append(sl.FailedServers, h)
+			continue
+		}
+		sl.Servers = append(sl.Servers, c.srv)
+	}
+	directQueries.Unlock()
+	sl.ServerCount = len(sl.Servers)
+	sl.FailedCount = len(sl.FailedServers)
+	return sl, nil
+}
+
+// queryMissing queries the hosts and stores the results, then releases the
+// requests that are waiting for the hosts with the query's error, even if the
+// query panics.
+func queryMissing(kind string, hosts []string,
+	query func(hosts []string) (*models.APIServerList, error),
+	ttl time.Duration) (err error) {
+	err = errQueryAborted
+	defer func() { releaseInflight(kind, hosts, err) }()
+	var sl *models.APIServerList
+	sl, err = query(hosts)
+	storeCached(kind, hosts, sl, err, ttl)
+	return err
+}
+
+// storeCached stores the results of querying the hosts and removes expired
+// results.
+func storeCached(kind string, hosts []string, sl *models.APIServerList, err error,
+	ttl time.Duration) {
+	at := time.Now()
+	directQueries.Lock()
+	defer directQueries.Unlock()
+	for k, c := range directQueries.results {
+		if at.Sub(c.at) >= ttl {
+			delete(directQueries.results, k)
+		}
+	}
+	if err == nil && sl != nil {
+		responded := make(map[string]bool, len(sl.Servers))
+		for _, s := range sl.Servers {
+			responded[s.Host] = true
+			directQueries.results[kind+"|"+s.Host] = cachedQuery{srv: s, at: at}
+		}
+		for _, h := range hosts {
+			if !responded[h] {
+				directQueries.results[kind+"|"+h] = cachedQuery{failed: true, at: at}
+			}
+		}
+	}
+}
+
+// releaseInflight releases the requests that are waiting for the hosts, with
+// the error of their query.
+func releaseInflight(kind string, hosts []string, err error) {
+	directQueries.Lock()
+	defer directQueries.Unlock()
+	for _, h := range hosts {
+		if wait, ok := directQueries.inflight[kind+"|"+h]; ok {
+			wait.err = err
+			close(wait.done)
+			delete(directQueries.inflight, kind+"|"+h)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package steam
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/models"
+)
+
+// resetDirectQueries empties the direct query cache.
+func resetDirectQueries() {
+	directQueries.Lock()
+	defer directQueries.Unlock()
+	directQueries.results = make(map[string]cachedQuery)
+}
+
+func TestQueryCached(t *testing.T) {
+	resetDirectQueries()
+	t.Cleanup(resetDirectQueries)
+	prev := config.Get().WebConfig.DirectQueryCacheTime
+	defer func() { config.Get().WebConfig.DirectQueryCacheTime = prev }()
+	config.Get().WebConfig.DirectQueryCacheTime = 60
+
+	var mu sync.Mutex
+	queried := make(map[string]int)
+	release := make(chan struct{})
+	query := func(hosts []string) (*models.APIServerList, error) {
+		<-release
+		mu.Lock()
+		defer mu.Unlock()
+		sl := &models.APIServerList{}
+		for _, h := range hosts {
+			queried[h]++
+			if h != "10.0.0.9:27960" {
+				sl.Servers = append(sl.Servers, models.APIServer{Host: h})
+			}
+		}
+		return sl, nil
+	}
+	hosts := []string{"10.0.0.1:27960", "10.0.0.9:27960"}
+	var wg sync.WaitGroup
+	results := make([]*models.APIServerList, 5)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = queryCached("test", hosts, query)
+		}(i)
+	}
+	close(release)
+	wg.Wait()
+	// later request within the cache time
+	sl, _ := queryCached("test", hosts, query)
+	results = append(results, sl)
+
+	for h, n := range queried {
+		if n != 1 {
+			t.Errorf("Expected %s to be queried once, got: %d", h, n)
+		}
+	}
+	for _, sl := range results {
+		if sl.ServerCount != 1 || sl.Servers[0].Host != "10.0.0.1:27960" ||
+			sl.FailedCount != 1 || sl.FailedServers[0] != "10.0.0.9:27960" {
+			t.Fatalf("Expected 1 server and 1 failed server, got: %+v", sl)
+		}
+	}
+
+	// disabled
+	config.Get().WebConfig.DirectQueryCacheTime = 0
+	queryCached("test", hosts, query)
+	if queried["10.0.0.1:27960"] != 2 {
+		t.Fatalf("Expected uncached query, got: %d queries",
+			queried["10.0.0.1:27960"])
+	}
+}
+
+func TestQueryCachedError(t *testing.T) {
+	resetDirectQueries()
+	t.Cleanup(resetDirectQueries)
+	prev := config.Get().WebConfig.DirectQueryCacheTime
+	defer func() { config.Get().WebConfig.DirectQueryCacheTime = prev }()
+	config.Get().WebConfig.DirectQueryCacheTime = 60
+
+	queryErr := errors.New("query failed")
+	started := make(chan string, 2)
+	release := make(chan struct{})
+	query := func(hosts []string) (*models.APIServerList, error) {
+		started <- hosts[0]
+		if hosts[0] == "10.0.0.1:27960" {
+			<-release
+			return nil, queryErr
+		}
+		return &models.APIServerList{Servers: []models.APIServer{{Host: hosts[0]}}},
+			nil
+	}
+	leaderErr := make(chan error, 1)
+	go func() {
+		_, err := queryCached("test", []string{"10.0.0.1:27960"}, query)
+		leaderErr <- err
+	}()
+	<-started
+	waiterErr := make(chan error, 1)
+	go func() {
+		// waits for 10.0.0.1, which is being queried, and queries 10.0.0.2
+		_, err := queryCached("test", []string{"10.0.0.1:27960", "10.0.0.2:27960"},
+			query)
+		waiterErr <- err
+	}()
+	<-started
+	close(release)
+	if err := <-leaderErr; err != queryErr {
+		t.Fatalf("Expected the query's error, got: %v", err)
+	}
+	if err := <-waiterErr; err != queryErr {
+		t.Fatalf("Expected the waiting request to get the query's error, got: %v",
+			err)
+	}
+}
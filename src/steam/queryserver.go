@@ -0,0 +1,110 @@
+package steam
+
+// queryserver.go - Querying a single server for any combination of its info,
+// players, and rules, for programs that use the steam package directly.
+
+import (
+	"context"
+	"time"
+
+	"github.com/syncore/a2sapi/src/models"
+)
+
+// Options selects the A2S requests that QueryServer makes, and how.
+type Options struct {
+	Info    bool
+	Players bool
+	Rules   bool
+	// time allowed for each request; QueryTimeout if 0
+	Timeout time.Duration
+	// number of times to re-send a failed request
+	Retries int
+}
+
+// ServerResult is the result of QueryServer. Only the requested parts are set,
+// and players and rules are empty if the server has none.
+type ServerResult struct {
+	Host    string
+	Info    *models.SteamServerInfo
+	Players []models.SteamPlayerInfo
+	Rules   map[string]string
+}
+
+// requestTimeout returns the timeout for the next request: the given timeout
+// (or QueryTimeout if 0), limited by the context's deadline.
+func requestTimeout(ctx context.Context, timeout time.Duration) (time.Duration,
+	error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if timeout <= 0 {
+		timeout = QueryTimeout
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		left := deadline.Sub(queryClock.Now())
+		if left <= 0 {
+			return 0, context.DeadlineExceeded
+		}
+		if left < timeout {
+			timeout = left
+		}
+	}
+	return timeout, nil
+}
+
+// QueryServer requests the info, players, and/or rules of a host, as selected
+// in opts, re-sending each failed request up to opts.Retries times. No more
+// requests are made once ctx is done, and ctx's deadline limits the timeout of
+// each request. It returns what was retrieved, along with the first error if a
+// request did not succeed.
+func QueryServer(ctx context.Context, host string, opts Options) (*ServerResult,
+	error) {
+	res := &ServerResult{Host: host}
+	// request makes a request with retries; empty is the error returned for a
+	// server that responded but has nothing to report
+	request := func(do func(timeout time.Duration) error, empty error) error {
+		var err error
+		for i := 0; i <= opts.Retries; i++ {
+			timeout, cerr := requestTimeout(ctx, opts.Timeout)
+			if cerr != nil {
+				return cerr
+			}
+			if err = do(timeout); err == nil || err == empty {
+				return nil
+			}
+		}
+		return err
+	}
+	var firstErr error
+	if opts.Info {
+		err := request(func(timeout time.Duration) error {
+			info, err := GetInfoForServer(host, timeout)
+			if err == nil {
+				res.Info = &info
+			}
+			return err
+		}, ErrNoInfo)
+		firstErr = err
+	}
+	if opts.Players {
+		err := request(func(timeout time.Duration) error {
+			players, err := GetPlayersForServer(host, timeout)
+			res.Players = players
+			return err
+		}, ErrNoPlayers)
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if opts.Rules {
+		err := request(func(timeout time.Duration) error {
+			rules, err := GetRulesForServer(host, timeout)
+			res.Rules = rules
+			return err
+		}, ErrNoRules)
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return res, firstErr
+}
@@ -0,0 +1,68 @@
+package steam
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestQueryServer(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %s", err)
+	}
+	defer conn.Close()
+	info := append(append([]byte{}, expectedInfoRespHeader...), 0x11,
+		'a', 0x00, 'b', 0x00, 'c', 0x00, 'd', 0x00, 0x01, 0x00, 0x03, 0x10, 0x00,
+		'd', 'l', 0x00, 0x00, '1', 0x00, 0x00)
+	go func() {
+		buf := make([]byte, maxPacketSize)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if bytes.Equal(buf[:n], infoChallengeReq) {
+				conn.WriteTo(info, addr)
+			}
+		}
+	}()
+	host := conn.LocalAddr().String()
+
+	res, err := QueryServer(context.Background(), host,
+		Options{Info: true, Timeout: time.Second, Retries: 1})
+	if err != nil {
+		t.Fatalf("Unexpected error querying server info: %s", err)
+	}
+	if res.Info == nil || res.Info.Map != "b" || res.Info.Players != 3 {
+		t.Fatalf("Expected info with map b and 3 players, got: %+v", res.Info)
+	}
+	if res.Players != nil || res.Rules != nil {
+		t.Fatalf("Expected only info to be requested, got: %+v", res)
+	}
+
+	// the server never answers rules requests
+	ctx, cancel := context.WithTimeout(context.Background(),
+		300*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	res, err = QueryServer(ctx, host, Options{Info: true, Rules: true,
+		Timeout: time.Second, Retries: 5})
+	if err == nil {
+		t.Fatalf("Expected error for unanswered rules request")
+	}
+	if res.Info == nil {
+		t.Fatalf("Expected info to be retrieved along with the error")
+	}
+	if time.Since(start) > time.Second {
+		t.Fatalf("Expected retries to stop at the context's deadline, took: %s",
+			time.Since(start))
+	}
+
+	cancel()
+	if _, err := QueryServer(ctx, host, Options{Info: true}); err == nil {
+		t.Fatalf("Expected error for a done context")
+	}
+}
@@ -0,0 +1,195 @@
+package steam
+
+// querystats.go - Tracks which subsystem (master server retrieval, direct host
+// queries, or server ID queries) is responsible for A2S load, per cycle and in
+// total.
+
+import (
+	"expvar"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/util"
+)
+
+type querySource string
+
+const (
+	// hosts returned from the master server (or Steam Web API) by timed retrieval
+	sourceMaster querySource = "master"
+	// user-supplied hosts from the /query?hosts endpoint
+	sourceDirect querySource = "direct"
+	// hosts looked up in the server ID database from the /query?ids endpoint
+	sourceID querySource = "id"
+	// stale servers re-queried at API time before responding
+	sourceRefresh querySource = "refresh"
+)
+
+type sourceCounts struct {
+	Requests    int64   `json:"requests"`
+	Failures    int64   `json:"failures"`
+	FailureRate float64 `json:"failureRate"`
+}
+
+type queryStats struct {
+	mu    sync.Mutex
+	total map[querySource]*sourceCounts
+	cycle map[querySource]*sourceCounts
+}
+
+// cycleReport summarizes a single timed master server retrieval, along with the
+// direct and ID queries that were handled since the previous retrieval.
+type cycleReport struct {
+	Game     string                       `json:"game"`
+	CycleID  string                       `json:"cycleID"`
+	Started  time.Time                    `json:"started"`
+	Duration float64                      `json:"durationSecs"`
+	Servers  int                          `json:"servers"`
+	Sources  map[querySource]sourceCounts `json:"sources"`
+	Memory   cycleMemory                  `json:"memory"`
+	startMem memSample
+}
+
+var (
+	qstats = &queryStats{
+		total: make(map[querySource]*sourceCounts),
+		cycle: make(map[querySource]*sourceCounts),
+	}
+	lastCycles   = make(map[string]cycleReport)
+	lastCyclesMu sync.Mutex
+
+	// Prometheus metrics
+	a2sRequestsMetric = util.NewCounter("a2sapi_a2s_requests_total",
+		"A2S requests by request type and source, not including retries.",
+		"type", "source")
+	a2sFailuresMetric = util.NewCounter("a2sapi_a2s_failures_total",
+		"Hosts whose A2S requests failed after all retries, by request type and source.",
+		"type", "source")
+	a2sRetriesMetric = util.NewCounter("a2sapi_a2s_retries_total",
+		"A2S requests that were retries of failed requests, by request type.",
+		"type")
+	a2sDurationMetric = util.NewHistogram("a2sapi_a2s_request_duration_seconds",
+		"Duration of individual A2S requests, by request type.",
+		util.DurationBuckets, "type")
+	a2sQueueWaitMetric = util.NewHistogram("a2sapi_a2s_queue_wait_seconds",
+		"Time A2S requests waited for the concurrency limit before being sent.",
+		util.DurationBuckets)
+	cycleServersMetric = util.NewHistogram("a2sapi_cycle_servers_queried",
+		"Servers queried in each timed retrieval cycle, by game.",
+		[]float64{10, 100, 1000, 5000, 10000, 50000, 100000}, "game")
+	cycleDurationMetric = util.NewHistogram("a2sapi_cycle_duration_seconds",
+		"Duration of each timed retrieval cycle, by game.",
+		[]float64{1, 5, 10, 30, 60, 120, 300, 600, 1200}, "game")
+)
+
+// A2S request types, as used in the metrics.
+const (
+	reqTypeInfo    = "info"
+	reqTypePlayers = "players"
+	reqTypeRules   = "rules"
+)
+
+func init() {
+	expvar.Publish("a2sQuerySources", expvar.Func(func() interface{} {
+		return qstats.totals()
+	}))
+	expvar.Publish("a2sLastCycle", expvar.Func(func() interface{} {
+		lastCyclesMu.Lock()
+		defer lastCyclesMu.Unlock()
+		m := make(map[string]cycleReport, len(lastCycles))
+		for k, v := range lastCycles {
+			m[k] = v
+		}
+		return m
+	}))
+}
+
+func copyCounts(m map[querySource]*sourceCounts) map[querySource]sourceCounts {
+	c := make(map[querySource]sourceCounts, len(m))
+	for k, v := range m {
+		sc := *v
+		if sc.Requests > 0 {
+			sc.FailureRate = float64(sc.Failures) / float64(sc.Requests)
+		}
+		c[k] = sc
+	}
+	return c
+}
+
+// record adds the outcome of a batch of A2S requests to the counts for src.
+func (qs *queryStats) record(src querySource, requests, failures int) {
+	if requests == 0 {
+		return
+	}
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	for _, m := range []map[querySource]*sourceCounts{qs.total, qs.cycle} {
+		sc, ok := m[src]
+		if !ok {
+			sc = &sourceCounts{}
+			m[src] = sc
+		}
+		sc.Requests += int64(requests)
+		sc.Failures += int64(failures)
+	}
+}
+
+// recordBatch adds the outcome of a batch of A2S requests of the given type to
+// the counts for src and to the metrics.
+func recordBatch(reqType string, src querySource, requests, failures int) {
+	qstats.record(src, requests, failures)
+	a2sRequestsMetric.Add(float64(requests), reqType, string(src))
+	a2sFailuresMetric.Add(float64(failures), reqType, string(src))
+}
+
+// totals returns the counts for each source since startup.
+func (qs *queryStats) totals() map[querySource]sourceCounts {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	return copyCounts(qs.total)
+}
+
+// endCycle returns the counts for each source since the last call and resets them.
+func (qs *queryStats) endCycle() map[querySource]sourceCounts {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	c := copyCounts(qs.cycle)
+	qs.cycle = make(map[querySource]*sourceCounts)
+	return c
+}
+
+// finishCycle completes the report for a timed retrieval, logs it, and makes it
+// available to the metrics.
+func finishCycle(r cycleReport) {
+	r.Duration = time.Since(r.Started).Seconds()
+	r.Sources = qstats.endCycle()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	r.Memory = cycleMemoryStats(r.startMem, &ms)
+	lastCyclesMu.Lock()
+	lastCycles[r.Game] = r
+	lastCyclesMu.Unlock()
+	cycleServersMetric.Observe(float64(r.Servers), r.Game)
+	cycleDurationMetric.Observe(r.Duration, r.Game)
+	flushHostErrors()
+
+	logger.LogSteamInfo("%s retrieval cycle %s: %d servers in %.1f secs", r.Game,
+		r.CycleID, r.Servers, r.Duration)
+	logger.LogSteamInfo("%s retrieval cycle %s: %.1f MB allocated, %d GCs (%.1f ms total pause, %.1f ms max), %.1f MB heap in use",
+		r.Game, r.CycleID, float64(r.Memory.AllocatedBytes)/(1<<20), r.Memory.GCs,
+		r.Memory.GCPauseTotal, r.Memory.GCPauseMax,
+		float64(r.Memory.HeapInUseBytes)/(1<<20))
+	srcs := make([]string, 0, len(r.Sources))
+	for s := range r.Sources {
+		srcs = append(srcs, string(s))
+	}
+	sort.Strings(srcs)
+	for _, s := range srcs {
+		sc := r.Sources[querySource(s)]
+		logger.LogSteamInfo("%s retrieval cycle %s: %s queries: %d requests, %d failures (%.2f%%)",
+			r.Game, r.CycleID, s, sc.Requests, sc.Failures, sc.FailureRate*100)
+	}
+}
@@ -0,0 +1,62 @@
+package steam
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestQueryStats(t *testing.T) {
+	qs := &queryStats{
+		total: make(map[querySource]*sourceCounts),
+		cycle: make(map[querySource]*sourceCounts),
+	}
+	qs.record(sourceMaster, 100, 10)
+	qs.record(sourceMaster, 100, 30)
+	qs.record(sourceDirect, 4, 1)
+	qs.record(sourceID, 0, 0)
+
+	c := qs.endCycle()
+	if len(c) != 2 {
+		t.Fatalf("Expected 2 sources in cycle, got: %d", len(c))
+	}
+	if c[sourceMaster].Requests != 200 || c[sourceMaster].Failures != 40 {
+		t.Fatalf("Expected 200 master requests & 40 failures, got: %d & %d",
+			c[sourceMaster].Requests, c[sourceMaster].Failures)
+	}
+	if c[sourceMaster].FailureRate != 0.2 {
+		t.Fatalf("Expected master failure rate of 0.2, got: %f",
+			c[sourceMaster].FailureRate)
+	}
+	if c[sourceDirect].FailureRate != 0.25 {
+		t.Fatalf("Expected direct failure rate of 0.25, got: %f",
+			c[sourceDirect].FailureRate)
+	}
+	if len(qs.endCycle()) != 0 {
+		t.Fatalf("Expected cycle counts to be reset after the cycle ended")
+	}
+	qs.record(sourceDirect, 4, 0)
+	tot := qs.totals()
+	if tot[sourceDirect].Requests != 8 || tot[sourceMaster].Requests != 200 {
+		t.Fatalf("Expected totals to persist across cycles, got: %+v", tot)
+	}
+}
+
+func TestCycleMemoryStats(t *testing.T) {
+	start := memSample{totalAlloc: 1000, mallocs: 10, numGC: 300, pauseTotal: 5e6}
+	end := &runtime.MemStats{TotalAlloc: 5000, Mallocs: 50, NumGC: 302,
+		PauseTotalNs: 8e6, HeapInuse: 4096}
+	end.PauseNs[(301+255)%256] = 2e6
+	end.PauseNs[(302+255)%256] = 1e6
+	// pause of a collection before the cycle
+	end.PauseNs[(300+255)%256] = 9e6
+	cm := cycleMemoryStats(start, end)
+	if cm.AllocatedBytes != 4000 || cm.Allocations != 40 || cm.HeapInUseBytes != 4096 {
+		t.Errorf("Unexpected allocation stats: %+v", cm)
+	}
+	if cm.GCs != 2 || cm.GCPauseTotal != 3 || cm.GCPauseMax != 2 {
+		t.Errorf("Expected 2 GCs with 3ms total and 2ms max pause, got: %+v", cm)
+	}
+	if cm := cycleMemoryStats(sampleOf(end), end); cm.GCs != 0 || cm.GCPauseMax != 0 {
+		t.Errorf("Expected no GCs, got: %+v", cm)
+	}
+}
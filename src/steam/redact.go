@@ -0,0 +1,62 @@
+package steam
+
+// redact.go - Redaction of sensitive A2S_RULES values (i.e: leaked rcon
+// passwords) before servers are stored or returned by the API
+
+import (
+	"path"
+	"strings"
+
+	"github.com/syncore/a2sapi/src/config"
+)
+
+// redactedValue replaces the values of redacted rules.
+const redactedValue = "<redacted>"
+
+// isRedactedRule returns true if the rule key matches one of the redaction
+// entries, either exactly or as a pattern (* and ?), ignoring case.
+func isRedactedRule(key string, redacted []string) bool {
+	key = strings.ToLower(key)
+	for _, r := range redacted {
+		r = strings.ToLower(r)
+		if r == key {
+			return true
+		}
+		if strings.ContainsAny(r, "*?[") {
+			if matched, err := path.Match(r, key); err == nil && matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// redactRules returns the rules with the values of the configured redacted rules
+// replaced. The rules are copied if any are redacted, so that the A2S results
+// themselves are not modified.
+func redactRules(rules map[string]string) map[string]string {
+	var redacted []string
+	if config.Get() != nil {
+		redacted = config.Get().SteamConfig.RedactedRules
+	}
+	if len(redacted) == 0 {
+		return rules
+	}
+	var out map[string]string
+	for k := range rules {
+		if !isRedactedRule(k, redacted) {
+			continue
+		}
+		if out == nil {
+			out = make(map[string]string, len(rules))
+			for ck, cv := range rules {
+				out[ck] = cv
+			}
+		}
+		out[k] = redactedValue
+	}
+	if out == nil {
+		return rules
+	}
+	return out
+}
@@ -0,0 +1,41 @@
+package steam
+
+import (
+	"testing"
+
+	"github.com/syncore/a2sapi/src/config"
+)
+
+func TestRedactRules(t *testing.T) {
+	prev := config.Get().SteamConfig.RedactedRules
+	defer func() { config.Get().SteamConfig.RedactedRules = prev }()
+	config.Get().SteamConfig.RedactedRules = []string{"sv_privateKey",
+		"*rcon_password*"}
+
+	rules := map[string]string{
+		"g_gametype":        "4",
+		"SV_PRIVATEKEY":     "hunter2",
+		"zmq_rcon_password": "secret",
+		"sv_privatekeys":    "1",
+	}
+	redacted := redactRules(rules)
+	expected := map[string]string{
+		"g_gametype":        "4",
+		"SV_PRIVATEKEY":     redactedValue,
+		"zmq_rcon_password": redactedValue,
+		"sv_privatekeys":    "1",
+	}
+	for k, v := range expected {
+		if redacted[k] != v {
+			t.Errorf("Expected rule %s to be '%s', got: '%s'", k, v, redacted[k])
+		}
+	}
+	if rules["SV_PRIVATEKEY"] != "hunter2" {
+		t.Errorf("Expected the original rules not to be modified")
+	}
+
+	config.Get().SteamConfig.RedactedRules = nil
+	if r := redactRules(rules); r["zmq_rcon_password"] != "secret" {
+		t.Errorf("Expected no redaction without redacted rules, got: %v", r)
+	}
+}
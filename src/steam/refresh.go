@@ -0,0 +1,188 @@
+package steam
+
+// refresh.go - Re-querying, at API time, of servers whose cached data from the
+// last timed retrieval has become stale
+
+import (
+	"sync"
+	"time"
+
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/models"
+	"github.com/syncore/a2sapi/src/util"
+)
+
+// refreshedServer is a server's fresh data from a refresh query.
+type refreshedServer struct {
+	srv models.APIServer
+	at  time.Time
+}
+
+// refreshed holds the fresh data of servers that were refreshed since the last
+// timed retrieval, and the hosts that are currently being refreshed.
+var refreshed = struct {
+	sync.Mutex
+	servers  map[string]refreshedServer
+	inflight map[string]bool
+}{
+	servers:  make(map[string]refreshedServer),
+	inflight: make(map[string]bool),
+}
+
+// onDemandRefreshes holds the time that each host was last refreshed on demand,
+// for limiting how often that can happen.
+var onDemandRefreshes = struct {
+	sync.Mutex
+	last map[string]time.Time
+}{
+	last: make(map[string]time.Time),
+}
+
+var refreshedStats = util.RegisterCache("refreshedServers", func() int {
+	refreshed.Lock()
+	defer refreshed.Unlock()
+	return len(refreshed.servers)
+}, func() int {
+	refreshed.Lock()
+	defer refreshed.Unlock()
+	n := len(refreshed.servers)
+	refreshed.servers = make(map[string]refreshedServer)
+	return n
+})
+
+// mergeRefreshed returns the cached server with its A2S data replaced by the
+// refreshed data. The server's ID, alias, and location are kept.
+func mergeRefreshed(cached models.APIServer, r refreshedServer) models.APIServer {
+	s := cached
+	s.Info = r.srv.Info
+	s.Players = r.srv.Players
+	s.FilteredPlayers = r.srv.FilteredPlayers
+	s.Rules = r.srv.Rules
+	s.FreeSlots = r.srv.FreeSlots
+	s.PasswordProtected = r.srv.PasswordProtected
+	s.Spectators = r.srv.Spectators
+	s.ParseWarnings = r.srv.ParseWarnings
+	s.PartialFields = r.srv.PartialFields
+	s.Status = r.srv.Status
+	s.RefreshedTimeStamp = r.at.Unix()
+	return s
+}
+
+// refreshHosts queries the hosts (host to game name) and stores the results for
+// later requests.
+func refreshHosts(hostsgames map[string]string) map[string]refreshedServer {
+	sl, err := queryHosts(hostsgames, sourceRefresh)
+	at := time.Now()
+	fresh := make(map[string]refreshedServer, len(hostsgames))
+	refreshed.Lock()
+	defer refreshed.Unlock()
+	for host := range hostsgames {
+		delete(refreshed.inflight, host)
+	}
+	if err != nil {
+		return fresh
+	}
+	for _, s := range sl.Servers {
+		r := refreshedServer{srv: s, at: at}
+		refreshed.servers[s.Host] = r
+		fresh[s.Host] = r
+	}
+	return fresh
+}
+
+// RefreshStaleServers returns the servers with fresh A2S data merged in for any
+// whose data (from the list retrieved at listTime) is older than maxAge. It waits
+// at most budget for the fresh data; servers that cannot be refreshed in time
+// keep their cached data, but are still updated for later requests. Refreshed
+// data of the servers' games that the list supersedes is removed, including that
+// of servers that are no longer listed.
+func RefreshStaleServers(servers []models.APIServer, listTime time.Time,
+	maxAge, budget time.Duration) []models.APIServer {
+	now := time.Now()
+	out := make([]models.APIServer, len(servers))
+	copy(out, servers)
+	stale := make(map[string]string)
+	games := make(map[string]bool)
+	for _, s := range servers {
+		games[s.Game] = true
+	}
+
+	refreshed.Lock()
+	for h, r := range refreshed.servers {
+		// superseded by a newer timed retrieval
+		if games[r.srv.Game] && !r.at.After(listTime) {
+			delete(refreshed.servers, h)
+		}
+	}
+	for i, s := range out {
+		if s.Status == models.ServerStatusOffline ||
+			s.Status == models.ServerStatusTimedOut {
+			continue
+		}
+		updated := listTime
+		if r, ok := refreshed.servers[s.Host]; ok && r.at.After(listTime) {
+			out[i] = mergeRefreshed(s, r)
+			updated = r.at
+			refreshedStats.Hit()
+		}
+		if now.Sub(updated) < maxAge || refreshed.inflight[s.Host] {
+			continue
+		}
+		stale[s.Host] = s.Game
+		refreshed.inflight[s.Host] = true
+		refreshedStats.Miss()
+	}
+	refreshed.Unlock()
+
+	if len(stale) == 0 {
+		return out
+	}
+	done := make(chan map[string]refreshedServer, 1)
+	go func() {
+		done <- refreshHosts(stale)
+	}()
+	select {
+	case fresh := <-done:
+		for i, s := range servers {
+			if r, ok := fresh[s.Host]; ok {
+				out[i] = mergeRefreshed(s, r)
+			}
+		}
+	case <-time.After(budget):
+		logger.WriteDebug("Refresh of %d stale servers exceeded %s budget", len(stale),
+			budget)
+	}
+	return out
+}
+
+// RefreshServer immediately re-queries a server (i.e. from the current list),
+// and returns it with its fresh A2S data merged in; later requests also use the
+// fresh data. A host is re-queried at most once every minInterval: until then,
+// it returns the server unchanged along with the time left. If the server does
+// not respond, ErrNoResponse is returned.
+func RefreshServer(srv models.APIServer, minInterval time.Duration) (models.APIServer,
+	time.Duration, error) {
+	now := time.Now()
+	onDemandRefreshes.Lock()
+	for h, at := range onDemandRefreshes.last {
+		if now.Sub(at) >= minInterval {
+			delete(onDemandRefreshes.last, h)
+		}
+	}
+	if at, ok := onDemandRefreshes.last[srv.Host]; ok {
+		onDemandRefreshes.Unlock()
+		return srv, minInterval - now.Sub(at), nil
+	}
+	onDemandRefreshes.last[srv.Host] = now
+	onDemandRefreshes.Unlock()
+
+	refreshed.Lock()
+	refreshed.inflight[srv.Host] = true
+	refreshed.Unlock()
+	fresh := refreshHosts(map[string]string{srv.Host: srv.Game})
+	r, ok := fresh[srv.Host]
+	if !ok {
+		return srv, 0, ErrNoResponse
+	}
+	return mergeRefreshed(srv, r), 0, nil
+}
@@ -0,0 +1,92 @@
+package steam
+
+import (
+	"testing"
+	"time"
+
+	"github.com/syncore/a2sapi/src/models"
+)
+
+func TestRefreshStaleServers(t *testing.T) {
+	listTime := time.Now().Add(-10 * time.Minute)
+	servers := []models.APIServer{
+		models.APIServer{ID: 1, Alias: "cached", Host: "10.0.0.1:27960",
+			Game: "QuakeLive", Info: models.SteamServerInfo{Map: "bloodrun"}},
+	}
+	// not stale yet; returned as-is
+	out := RefreshStaleServers(servers, listTime, time.Hour, time.Millisecond)
+	if out[0].RefreshedTimeStamp != 0 || out[0].Info.Map != "bloodrun" {
+		t.Fatalf("Expected server that isn't stale to be unchanged, got: %+v", out[0])
+	}
+
+	// refreshed since the list was retrieved; merged without re-querying
+	at := time.Now()
+	refreshed.Lock()
+	refreshed.servers["10.0.0.1:27960"] = refreshedServer{at: at,
+		srv: models.APIServer{Host: "10.0.0.1:27960", Game: "QuakeLive",
+			Info: models.SteamServerInfo{Map: "campgrounds"}}}
+	// refreshed servers that are no longer listed
+	refreshed.servers["10.0.0.2:27960"] = refreshedServer{at: at,
+		srv: models.APIServer{Host: "10.0.0.2:27960", Game: "QuakeLive"}}
+	refreshed.servers["10.0.0.3:25801"] = refreshedServer{at: at,
+		srv: models.APIServer{Host: "10.0.0.3:25801", Game: "Reflex"}}
+	refreshed.Unlock()
+	defer func() {
+		refreshed.Lock()
+		for _, h := range []string{"10.0.0.1:27960", "10.0.0.2:27960",
+			"10.0.0.3:25801"} {
+			delete(refreshed.servers, h)
+		}
+		refreshed.Unlock()
+	}()
+	out = RefreshStaleServers(servers, listTime, time.Minute, time.Millisecond)
+	if out[0].Info.Map != "campgrounds" || out[0].RefreshedTimeStamp != at.Unix() {
+		t.Fatalf("Expected refreshed data to be merged, got: %+v", out[0])
+	}
+	if out[0].ID != 1 || out[0].Alias != "cached" {
+		t.Fatalf("Expected cached ID and alias to be kept, got: %+v", out[0])
+	}
+	if servers[0].Info.Map != "bloodrun" {
+		t.Fatalf("Expected cached list to not be modified")
+	}
+
+	// a newer timed retrieval supersedes the refreshed data
+	out = RefreshStaleServers(servers, time.Now().Add(time.Second), time.Hour,
+		time.Millisecond)
+	if out[0].Info.Map != "bloodrun" {
+		t.Fatalf("Expected newer list's data to be used, got: %+v", out[0])
+	}
+	refreshed.Lock()
+	defer refreshed.Unlock()
+	for h, kept := range map[string]bool{"10.0.0.1:27960": false,
+		"10.0.0.2:27960": false, "10.0.0.3:25801": true} {
+		if _, ok := refreshed.servers[h]; ok != kept {
+			t.Errorf("Expected refreshed data of %s to be kept: %v", h, kept)
+		}
+	}
+}
+
+func TestRefreshServer(t *testing.T) {
+	// the server never responds
+	useFakeNetwork(t, time.Millisecond, map[string]fakeServer{})
+	srv := models.APIServer{ID: 1, Host: "192.0.2.1:27960", Game: "QuakeLive"}
+	_, wait, err := RefreshServer(srv, time.Minute)
+	if wait != 0 || err != ErrNoResponse {
+		t.Fatalf("Expected refresh of unresponsive server to fail, got: %s %v",
+			wait, err)
+	}
+	// failed refreshes count towards the limit too
+	if _, wait, _ = RefreshServer(srv, time.Minute); wait <= 0 ||
+		wait > time.Minute {
+		t.Fatalf("Expected refresh within the interval to be limited, got: %s",
+			wait)
+	}
+	// other hosts are not limited, and neither are hosts without an interval
+	other := models.APIServer{ID: 2, Host: "192.0.2.2:27960", Game: "QuakeLive"}
+	if _, wait, _ = RefreshServer(other, 0); wait != 0 {
+		t.Fatalf("Expected refresh of another host not to be limited")
+	}
+	if _, wait, _ = RefreshServer(other, 0); wait != 0 {
+		t.Fatalf("Expected refresh without an interval not to be limited")
+	}
+}
@@ -0,0 +1,203 @@
+package steam
+
+// replay.go - Recording of the raw A2S responses received during a timed
+// retrieval, and replaying of such a recording through the normal parsing and
+// list building pipeline without network access (for reproducing parser bugs
+// and benchmarking on real data).
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/syncore/a2sapi/src/constants"
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/models"
+	"github.com/syncore/a2sapi/src/steam/filters"
+	"github.com/syncore/a2sapi/src/util"
+)
+
+type rawKind int
+
+const (
+	rawInfo rawKind = iota
+	rawPlayers
+	rawRules
+)
+
+// rawCycle represents the raw (unparsed) A2S responses for a single retrieval.
+// Byte slices are base64-encoded in the JSON representation.
+type rawCycle struct {
+	mu       sync.Mutex
+	Game     string            `json:"game"`
+	Recorded time.Time         `json:"recorded"`
+	Servers  []string          `json:"servers"`
+	Info     map[string][]byte `json:"info"`
+	Players  map[string][]byte `json:"players"`
+	Rules    map[string][]byte `json:"rules"`
+}
+
+var (
+	rawMu     sync.Mutex
+	recording *rawCycle
+	replaying *rawCycle
+)
+
+func newRawCycle(game string, servers []string) *rawCycle {
+	return &rawCycle{
+		Game:     game,
+		Recorded: time.Now(),
+		Servers:  servers,
+		Info:     make(map[string][]byte),
+		Players:  make(map[string][]byte),
+		Rules:    make(map[string][]byte),
+	}
+}
+
+func (rc *rawCycle) responses(kind rawKind) map[string][]byte {
+	switch kind {
+	case rawInfo:
+		return rc.Info
+	case rawPlayers:
+		return rc.Players
+	default:
+		return rc.Rules
+	}
+}
+
+func (rc *rawCycle) get(kind rawKind, host string) ([]byte, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	b, ok := rc.responses(kind)[host]
+	return b, ok
+}
+
+func (rc *rawCycle) put(kind rawKind, host string, b []byte) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.responses(kind)[host] = b
+}
+
+// fetchRaw returns the raw A2S response of the given kind for host. When a
+// recording is being replayed the response comes from the recording; otherwise
+// it is fetched from the network and, if a recording is in progress, recorded.
+func fetchRaw(kind rawKind, host string, fetch func() ([]byte, error)) ([]byte,
+	error) {
+	rawMu.Lock()
+	rep, rec := replaying, recording
+	rawMu.Unlock()
+	if rep != nil {
+		b, ok := rep.get(kind, host)
+		if !ok {
+			return nil, ErrHostConnection("no recorded response for " + host)
+		}
+		return b, nil
+	}
+	b, err := fetch()
+	if err == nil && rec != nil {
+		rec.put(kind, host, b)
+	}
+	return b, err
+}
+
+// startRecording starts recording the raw responses for a game's retrieval. It
+// returns false if another game's retrieval is already being recorded.
+func startRecording(game string, servers []string) bool {
+	rawMu.Lock()
+	defer rawMu.Unlock()
+	if recording != nil {
+		logger.LogAppInfo("Not recording %s retrieval; %s retrieval is being recorded",
+			game, recording.Game)
+		return false
+	}
+	recording = newRawCycle(game, servers)
+	return true
+}
+
+// stopRecording stops the recording in progress and writes it to disk.
+func stopRecording() error {
+	rawMu.Lock()
+	rc := recording
+	recording = nil
+	rawMu.Unlock()
+	if rc == nil {
+		return nil
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	j, err := json.Marshal(rc)
+	if err != nil {
+		return logger.LogAppErrorf("Error marshaling raw cycle recording: %s", err)
+	}
+	if err := util.CreateDirectory(constants.DumpDirectory); err != nil {
+		return logger.LogAppErrorf("Couldn't create '%s' dir: %s\n",
+			constants.DumpDirectory, err)
+	}
+	t := rc.Recorded
+	// Windows doesn't allow ":" in filename so use '-' separators for time
+	err = util.CreateByteFile(j, constants.DumpFileFullPath(
+		fmt.Sprintf("%s-raw-%d-%02d-%02d.%02d-%02d-%02d.json",
+			rc.Game, t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second())),
+		true)
+	if err != nil {
+		return logger.LogAppErrorf("Error creating raw cycle recording file: %s", err)
+	}
+	return nil
+}
+
+func loadRawCycle(path string) (*rawCycle, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, logger.LogAppErrorf("Unable to open raw cycle recording: %s", err)
+	}
+	defer f.Close()
+	rc := &rawCycle{}
+	if err := json.NewDecoder(bufio.NewReader(f)).Decode(rc); err != nil {
+		return nil, logger.LogAppErrorf(
+			"Unable to decode raw cycle recording as json: %s", err)
+	}
+	if rc.Info == nil || rc.Players == nil || rc.Rules == nil {
+		return nil, logger.LogAppErrorf("Raw cycle recording %s is incomplete", path)
+	}
+	return rc, nil
+}
+
+// ReplayCycle runs the recorded raw A2S responses in the file at path through
+// the parsing and list building pipeline, without network access, and publishes
+// the resulting list as the master list.
+func ReplayCycle(path string) (*models.APIServerList, error) {
+	rc, err := loadRawCycle(path)
+	if err != nil {
+		return nil, err
+	}
+	game := filters.GetGameByName(rc.Game)
+	if game == filters.GameUnspecified {
+		return nil, logger.LogAppErrorf("Unknown game '%s' in raw cycle recording",
+			rc.Game)
+	}
+	rawMu.Lock()
+	replaying = rc
+	rawMu.Unlock()
+	defer func() {
+		rawMu.Lock()
+		replaying = nil
+		rawMu.Unlock()
+	}()
+
+	start := time.Now()
+	sl, err := queryServerList(context.Background(),
+		filters.NewFilter(game, filters.SrAll, nil), rc.Servers)
+	if err != nil {
+		return nil, err
+	}
+	sl.CycleID = util.NewUUID()
+	logger.LogAppInfo("Replayed %s retrieval of %d servers (recorded %s) in %s as cycle %s",
+		rc.Game, len(rc.Servers), rc.Recorded.Format(time.RFC3339),
+		time.Since(start), sl.CycleID)
+	models.SetGameList(game.Name, sl)
+	return sl, nil
+}
@@ -0,0 +1,51 @@
+package steam
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFetchRawRecordReplay(t *testing.T) {
+	host := "127.0.0.1:27960"
+	raw := []byte{0xFF, 0xFF, 0xFF, 0xFF, 0x49}
+	fetched := 0
+	fetch := func() ([]byte, error) {
+		fetched++
+		return raw, nil
+	}
+
+	rec := newRawCycle("QuakeLive", []string{host})
+	rawMu.Lock()
+	recording = rec
+	rawMu.Unlock()
+	if _, err := fetchRaw(rawInfo, host, fetch); err != nil {
+		t.Fatalf("Unexpected error when recording: %s", err)
+	}
+	fetchRaw(rawRules, host, func() ([]byte, error) {
+		return nil, errors.New("timeout")
+	})
+	rawMu.Lock()
+	recording = nil
+	replaying = rec
+	rawMu.Unlock()
+	defer func() {
+		rawMu.Lock()
+		replaying = nil
+		rawMu.Unlock()
+	}()
+
+	b, err := fetchRaw(rawInfo, host, fetch)
+	if err != nil {
+		t.Fatalf("Unexpected error when replaying: %s", err)
+	}
+	if string(b) != string(raw) {
+		t.Fatalf("Expected replayed response %v, got: %v", raw, b)
+	}
+	if fetched != 1 {
+		t.Fatalf("Expected network fetch to be skipped during replay, fetched %d times",
+			fetched)
+	}
+	if _, err := fetchRaw(rawRules, host, fetch); err == nil {
+		t.Fatalf("Expected failed (unrecorded) response to fail during replay")
+	}
+}
@@ -0,0 +1,99 @@
+package steam
+
+// rulelimits.go - Limits on the A2S_RULES kept for each server, so that servers
+// that send enormous rule sets (by accident or on purpose) can't bloat the
+// server lists, and the memory and responses built from them.
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/models"
+	"github.com/syncore/a2sapi/src/steam/filters"
+	"github.com/syncore/a2sapi/src/util"
+)
+
+// rulesTruncatedMetric counts the servers whose rules were truncated.
+var rulesTruncatedMetric = util.NewCounter("a2sapi_rules_truncated_total",
+	"Servers whose A2S_RULES were truncated to the configured limits, by game.",
+	"game")
+
+// rulesLimits returns the most rules, and total bytes of rules, that are kept for
+// a server of the game: the game's own limits if it has them, or the configured
+// limits; 0 if there is no limit.
+func rulesLimits(game filters.Game) (count, size int) {
+	if config.Get() != nil {
+		count = config.Get().SteamConfig.MaxRulesPerServer
+		size = config.Get().SteamConfig.MaxRulesBytesPerServer
+	}
+	if game.MaxRules != 0 {
+		count = game.MaxRules
+	}
+	if game.MaxRulesBytes != 0 {
+		size = game.MaxRulesBytes
+	}
+	if count < 0 {
+		count = 0
+	}
+	if size < 0 {
+		size = 0
+	}
+	return count, size
+}
+
+// limitRules returns the rules within the game's limits, along with a warning
+// if any were dropped. Rules are kept in the order of their names, so the same
+// rules are kept each time; a rule that would exceed the size limit is dropped,
+// but the smaller rules after it may still be kept. The rules are copied if any
+// are dropped, so that the A2S results themselves are not modified.
+func limitRules(game filters.Game, rules map[string]string) (map[string]string,
+	*parseWarning) {
+	maxCount, maxSize := rulesLimits(game)
+	if maxCount == 0 && maxSize == 0 {
+		return rules, nil
+	}
+	size := 0
+	for k, v := range rules {
+		size += len(k) + len(v)
+	}
+	if (maxCount == 0 || len(rules) <= maxCount) && (maxSize == 0 ||
+		size <= maxSize) {
+		return rules, nil
+	}
+	keys := make([]string, 0, len(rules))
+	for k := range rules {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	kept := make(map[string]string)
+	keptSize := 0
+	for _, k := range keys {
+		if maxCount != 0 && len(kept) == maxCount {
+			break
+		}
+		n := len(k) + len(rules[k])
+		if maxSize != 0 && keptSize+n > maxSize {
+			continue
+		}
+		kept[k] = rules[k]
+		keptSize += n
+	}
+	rulesTruncatedMetric.Inc(game.Name)
+	return kept, &parseWarning{field: "rules", message: fmt.Sprintf(
+		"truncated to %d of %d rules (%d of %d bytes)", len(kept), len(rules),
+		keptSize, size)}
+}
+
+// markPartial adds a warning to a server, and marks the warning's field and the
+// server as partial.
+func markPartial(srv *models.APIServer, w parseWarning) {
+	srv.ParseWarnings = append(srv.ParseWarnings, w.String())
+	srv.Status = models.ServerStatusPartial
+	for _, f := range srv.PartialFields {
+		if f == w.field {
+			return
+		}
+	}
+	srv.PartialFields = append(srv.PartialFields, w.field)
+}
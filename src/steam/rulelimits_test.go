@@ -0,0 +1,72 @@
+package steam
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/models"
+	"github.com/syncore/a2sapi/src/steam/filters"
+)
+
+func TestLimitRules(t *testing.T) {
+	prevCount := config.Get().SteamConfig.MaxRulesPerServer
+	prevSize := config.Get().SteamConfig.MaxRulesBytesPerServer
+	defer func() {
+		config.Get().SteamConfig.MaxRulesPerServer = prevCount
+		config.Get().SteamConfig.MaxRulesBytesPerServer = prevSize
+	}()
+	config.Get().SteamConfig.MaxRulesPerServer = 3
+	config.Get().SteamConfig.MaxRulesBytesPerServer = 0
+
+	rules := map[string]string{"a": "1", "b": "2", "c": "3", "d": "4"}
+	kept, w := limitRules(filters.GameTF2, rules)
+	if w == nil || len(kept) != 3 || kept["d"] != "" {
+		t.Fatalf("Expected rules a-c to be kept with a warning, got: %v, %v", kept,
+			w)
+	}
+	if len(rules) != 4 {
+		t.Fatalf("Expected the A2S results not to be modified")
+	}
+
+	// rules within the limits are returned as they are
+	if kept, w := limitRules(filters.GameTF2, map[string]string{"a": "1"}); w != nil ||
+		len(kept) != 1 {
+		t.Fatalf("Expected rules within the limits to be kept, got: %v, %v", kept, w)
+	}
+
+	// a rule that would exceed the size limit is dropped, but later rules that
+	// fit are kept
+	config.Get().SteamConfig.MaxRulesPerServer = 0
+	config.Get().SteamConfig.MaxRulesBytesPerServer = 10
+	kept, w = limitRules(filters.GameTF2, map[string]string{"a": "1",
+		"b": strings.Repeat("x", 20), "c": "3"})
+	if w == nil || len(kept) != 2 || kept["a"] != "1" || kept["c"] != "3" {
+		t.Fatalf("Expected rules a and c to be kept, got: %v", kept)
+	}
+	if !strings.Contains(w.String(), "2 of 3 rules (4 of 25 bytes)") {
+		t.Errorf("Unexpected truncation warning: %s", w)
+	}
+
+	// a game's own limits override the configured ones; negative for no limit
+	game := filters.GameTF2
+	game.MaxRulesBytes = -1
+	if kept, w = limitRules(game, map[string]string{"b": strings.Repeat("x",
+		20)}); w != nil || len(kept) != 1 {
+		t.Fatalf("Expected no limit for game with negative limit, got: %v", w)
+	}
+	game.MaxRules = 1
+	if kept, _ = limitRules(game, rules); len(kept) != 1 {
+		t.Fatalf("Expected the game's limit of 1 rule, got: %v", kept)
+	}
+}
+
+func TestMarkPartial(t *testing.T) {
+	srv := models.APIServer{Status: models.ServerStatusOnline,
+		PartialFields: []string{"rules"}}
+	markPartial(&srv, parseWarning{field: "rules", message: "truncated"})
+	if srv.Status != models.ServerStatusPartial || len(srv.PartialFields) != 1 ||
+		len(srv.ParseWarnings) != 1 || srv.ParseWarnings[0] != "rules: truncated" {
+		t.Fatalf("Unexpected partial server: %+v", srv)
+	}
+}
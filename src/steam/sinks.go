@@ -0,0 +1,294 @@
+package steam
+
+// sinks.go - Output sinks that the per-game server list files are published to
+// after each retrieval: a local directory, or an S3 or GCS bucket, so that the
+// lists can be served from object storage (i.e. behind a CDN).
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/util"
+)
+
+// Environment variables holding the credentials for the object storage sinks;
+// GCS is written to through its S3-compatible XML API with HMAC keys.
+const (
+	envS3AccessKey    = "AWS_ACCESS_KEY_ID"
+	envS3SecretKey    = "AWS_SECRET_ACCESS_KEY"
+	envS3SessionToken = "AWS_SESSION_TOKEN"
+	envGCSAccessKey   = "A2SAPI_GCS_HMAC_KEY"
+	envGCSSecretKey   = "A2SAPI_GCS_HMAC_SECRET"
+	gcsEndpoint       = "https://storage.googleapis.com"
+)
+
+// outputFile is a file that is written to the output sinks.
+type outputFile struct {
+	name        string
+	contentType string
+	data        []byte
+}
+
+// OutputSink is a destination that the per-game server list files are
+// published to.
+type OutputSink interface {
+	// Write stores the data as the file with the given name, replacing any
+	// previous version.
+	Write(name, contentType string, data []byte) error
+}
+
+var (
+	outputSinks     []OutputSink
+	outputSinksOnce sync.Once
+	outputSinksMu   sync.Mutex
+	// uploads that are in progress, so that a single retrieval can wait for them
+	sinkUploads sync.WaitGroup
+	sinkClient  = &http.Client{Timeout: 60 * time.Second}
+)
+
+// NewOutputSink returns the sink for a destination: a local directory (a path
+// or a file:// URL), s3://bucket/prefix, or gcs://bucket/prefix. S3 URLs can set
+// the region (?region=) and, for S3-compatible storage, the endpoint
+// (?endpoint=).
+func NewOutputSink(dest string) (OutputSink, error) {
+	u, err := url.Parse(dest)
+	if err != nil || u.Scheme == "" {
+		return fileSink{dir: dest}, nil
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "file":
+		return fileSink{dir: u.Path}, nil
+	case "s3":
+		region := u.Query().Get("region")
+		if region == "" {
+			region = "us-east-1"
+		}
+		endpoint := u.Query().Get("endpoint")
+		if endpoint == "" {
+			endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+		}
+		return newObjectSink(u, endpoint, region, os.Getenv(envS3AccessKey),
+			os.Getenv(envS3SecretKey), os.Getenv(envS3SessionToken))
+	case "gcs", "gs":
+		return newObjectSink(u, gcsEndpoint, "auto", os.Getenv(envGCSAccessKey),
+			os.Getenv(envGCSSecretKey), "")
+	default:
+		return nil, fmt.Errorf("unknown output sink '%s'", dest)
+	}
+}
+
+// getOutputSinks returns the configured output sinks, creating them on first
+// use. Sinks that can't be created are logged and skipped.
+func getOutputSinks() []OutputSink {
+	outputSinksOnce.Do(func() {
+		for _, dest := range config.Get().OutputConfig.OutputSinks {
+			s, err := NewOutputSink(dest)
+			if err != nil {
+				logger.LogAppErrorf("Unable to create output sink: %s", err)
+				continue
+			}
+			outputSinks = append(outputSinks, s)
+		}
+	})
+	return outputSinks
+}
+
+// writeToSink writes a game's files to a sink.
+func writeToSink(s OutputSink, game string, files []outputFile) error {
+	for _, f := range files {
+		if err := s.Write(f.name, f.contentType, f.data); err != nil {
+			return logger.LogAppErrorf("Error writing %s server list %s: %s", game,
+				f.name, err)
+		}
+	}
+	return nil
+}
+
+// publishToSinks writes a game's files to the configured output sinks. Writes
+// are serialized so that slow uploads can't pile up.
+func publishToSinks(game string, files []outputFile) {
+	outputSinksMu.Lock()
+	defer outputSinksMu.Unlock()
+	for _, s := range getOutputSinks() {
+		writeToSink(s, game, files)
+	}
+}
+
+// startPublishToSinks writes a game's files to the configured output sinks in
+// the background.
+func startPublishToSinks(game string, files []outputFile) {
+	sinkUploads.Add(1)
+	go func() {
+		defer sinkUploads.Done()
+		publishToSinks(game, files)
+	}()
+}
+
+// waitForSinks waits for the uploads to the output sinks to finish.
+func waitForSinks() {
+	sinkUploads.Wait()
+}
+
+// fileSink writes files to a local directory. Each file is written to a
+// temporary file first so that readers never see a partially written file.
+type fileSink struct {
+	dir string
+}
+
+func (s fileSink) Write(name, contentType string, data []byte) error {
+	fullpath := path.Join(s.dir, name)
+	// names can include subdirectories, i.e. those of database backups
+	if err := util.CreateDirectory(path.Dir(fullpath)); err != nil {
+		return fmt.Errorf("couldn't create '%s' dir: %s", path.Dir(fullpath), err)
+	}
+	tmp := fullpath + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, fullpath); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// objectSink uploads files to an S3-compatible bucket, signing its requests
+// with AWS Signature Version 4.
+type objectSink struct {
+	endpoint     *url.URL
+	bucket       string
+	prefix       string
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+}
+
+func newObjectSink(u *url.URL, endpoint, region, accessKey, secretKey,
+	sessionToken string) (*objectSink, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("no bucket specified in output sink '%s'", u)
+	}
+	if accessKey == "" || secretKey == "" {
+		return nil, fmt.Errorf("no credentials for output sink '%s'", u)
+	}
+	ep, err := url.Parse(endpoint)
+	if err != nil || ep.Host == "" {
+		return nil, fmt.Errorf("invalid endpoint '%s' for output sink '%s'",
+			endpoint, u)
+	}
+	return &objectSink{endpoint: ep, bucket: u.Host,
+		prefix: strings.Trim(u.Path, "/"), region: region, accessKey: accessKey,
+		secretKey: secretKey, sessionToken: sessionToken}, nil
+}
+
+func (s *objectSink) Write(name, contentType string, data []byte) error {
+	key := name
+	if s.prefix != "" {
+		key = s.prefix + "/" + name
+	}
+	// path-style URLs work with S3, GCS, and S3-compatible storage
+	u := *s.endpoint
+	u.Path = "/" + s.bucket + "/" + key
+	req, err := http.NewRequest("PUT", u.String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	s.sign(req, data, time.Now().UTC())
+	resp, err := sinkClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("upload to %s returned status %d: %s", u.String(),
+			resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// sigV4Key derives the AWS Signature Version 4 signing key.
+func sigV4Key(secret, date, region, service string) []byte {
+	k := hmacSHA256([]byte("AWS4"+secret), date)
+	k = hmacSHA256(k, region)
+	k = hmacSHA256(k, service)
+	return hmacSHA256(k, "aws4_request")
+}
+
+// uriEncode encodes a path as required for the canonical request (every byte
+// other than unreserved characters and /).
+func uriEncode(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') ||
+			(c >= '0' && c <= '9') || strings.IndexByte("-_.~/", c) != -1 {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// sign adds the AWS Signature Version 4 headers to a request.
+func (s *objectSink) sign(req *http.Request, payload []byte, now time.Time) {
+	amzdate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	sum := sha256.Sum256(payload)
+	payloadHash := hex.EncodeToString(sum[:])
+	req.Header.Set("X-Amz-Date", amzdate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(v[0])
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{req.Method,
+		uriEncode(req.URL.Path), req.URL.RawQuery, canonicalHeaders.String(),
+		signedHeaders, payloadHash}, "\n")
+	crSum := sha256.Sum256([]byte(canonicalRequest))
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzdate + "\n" + scope + "\n" +
+		hex.EncodeToString(crSum[:])
+	signature := hex.EncodeToString(hmacSHA256(sigV4Key(s.secretKey, date,
+		s.region, "s3"), stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
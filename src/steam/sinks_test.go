@@ -0,0 +1,114 @@
+package steam
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestSigV4Key(t *testing.T) {
+	// example from the AWS Signature Version 4 documentation
+	key := sigV4Key("wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "20120215",
+		"us-east-1", "iam")
+	expected := "f4780e2d9f65fa895f9c67b32ce1baf0b0d8a43505a000a1a9e090d414db404d"
+	if hex.EncodeToString(key) != expected {
+		t.Fatalf("Expected signing key %s, got: %x", expected, key)
+	}
+}
+
+func TestNewOutputSink(t *testing.T) {
+	os.Setenv(envS3AccessKey, "key")
+	os.Setenv(envS3SecretKey, "secret")
+	defer os.Unsetenv(envS3AccessKey)
+	defer os.Unsetenv(envS3SecretKey)
+
+	s, err := NewOutputSink("output/lists")
+	if err != nil || s.(fileSink).dir != "output/lists" {
+		t.Fatalf("Expected file sink for output/lists, got: %+v (%v)", s, err)
+	}
+	s, err = NewOutputSink("s3://bucket/lists/?region=eu-west-1")
+	if err != nil {
+		t.Fatalf("Unexpected error creating s3 sink: %s", err)
+	}
+	os3 := s.(*objectSink)
+	if os3.bucket != "bucket" || os3.prefix != "lists" ||
+		os3.region != "eu-west-1" ||
+		os3.endpoint.Host != "s3.eu-west-1.amazonaws.com" {
+		t.Fatalf("Unexpected s3 sink: %+v", os3)
+	}
+	if _, err = NewOutputSink("gcs://bucket"); err == nil {
+		t.Fatalf("Expected error for gcs sink without credentials")
+	}
+	if _, err = NewOutputSink("ftp://host/dir"); err == nil {
+		t.Fatalf("Expected error for unknown sink")
+	}
+}
+
+func TestObjectSinkWrite(t *testing.T) {
+	var gotPath, gotType, gotAuth, gotBody string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		gotPath, gotType, gotBody = r.URL.Path, r.Header.Get("Content-Type"),
+			string(b)
+		gotAuth = r.Header.Get("Authorization")
+		if r.Method != "PUT" || r.Header.Get("X-Amz-Content-Sha256") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse("s3://bucket/lists")
+	s, err := newObjectSink(u, srv.URL, "us-east-1", "key", "secret", "")
+	if err != nil {
+		t.Fatalf("Unexpected error creating sink: %s", err)
+	}
+	if err := writeToSink(s, "QuakeLive", []outputFile{{
+		name: "servers.QuakeLive.json", contentType: "application/json",
+		data: []byte(`{"servers":[]}`)}}); err != nil {
+		t.Fatalf("Unexpected error writing to sink: %s", err)
+	}
+	if gotPath != "/bucket/lists/servers.QuakeLive.json" {
+		t.Fatalf("Unexpected upload path: %s", gotPath)
+	}
+	if gotType != "application/json" || gotBody != `{"servers":[]}` {
+		t.Fatalf("Unexpected upload: %s %s", gotType, gotBody)
+	}
+	if !strings.HasPrefix(gotAuth, "AWS4-HMAC-SHA256 Credential=key/") ||
+		!strings.Contains(gotAuth, "/us-east-1/s3/aws4_request") {
+		t.Fatalf("Unexpected authorization header: %s", gotAuth)
+	}
+
+	// errors from the storage are returned
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		http.Error(w, "AccessDenied", http.StatusForbidden)
+	})
+	if err := s.Write("servers.QuakeLive.json", "application/json",
+		[]byte("{}")); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Fatalf("Expected error with status 403, got: %v", err)
+	}
+}
+
+func TestFileSinkWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "a2sapi-sink")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	s := fileSink{dir: path.Join(dir, "lists")}
+	if err := s.Write("servers.QuakeLive.txt", "text/plain",
+		[]byte("10.0.0.1:27960\n")); err != nil {
+		t.Fatalf("Unexpected error writing file: %s", err)
+	}
+	b, err := ioutil.ReadFile(path.Join(dir, "lists", "servers.QuakeLive.txt"))
+	if err != nil || string(b) != "10.0.0.1:27960\n" {
+		t.Fatalf("Unexpected file contents: %q (%v)", b, err)
+	}
+}
@@ -0,0 +1,143 @@
+package steam
+
+// snapshot.go - Persistence of the in-memory state (the latest server lists, the
+// last retrieval cycles, and the tuned query concurrency) across restarts
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/constants"
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/models"
+	"github.com/syncore/a2sapi/src/util"
+)
+
+// stateSnapshotVersion is incremented whenever the snapshot format changes in
+// an incompatible way; snapshots of other versions are not restored.
+const stateSnapshotVersion = 1
+
+// stateSnapshot represents the in-memory state saved on shutdown.
+type stateSnapshot struct {
+	Version    int                              `json:"version"`
+	SavedAt    time.Time                        `json:"savedAt"`
+	GameLists  map[string]*models.APIServerList `json:"gameLists"`
+	LastCycles map[string]cycleReport           `json:"lastCycles"`
+	QueryLimit int                              `json:"queryLimit"`
+	// master server queries that were cut off, which are resumed after a restart
+	MasterResumes map[string]masterResume `json:"masterResumes,omitempty"`
+}
+
+// SaveState writes the in-memory state to the snapshot file, if state
+// persistence is enabled.
+func SaveState() error {
+	if !config.Get().SteamConfig.PersistState {
+		return nil
+	}
+	snap := stateSnapshot{
+		Version:       stateSnapshotVersion,
+		SavedAt:       time.Now(),
+		GameLists:     models.GameLists(),
+		LastCycles:    make(map[string]cycleReport),
+		MasterResumes: copyMasterResumes(),
+	}
+	lastCyclesMu.Lock()
+	for g, r := range lastCycles {
+		snap.LastCycles[g] = r
+	}
+	lastCyclesMu.Unlock()
+	if limiter != nil {
+		limiter.mu.Lock()
+		snap.QueryLimit = limiter.limit
+		limiter.mu.Unlock()
+	}
+	b, err := json.Marshal(snap)
+	if err != nil {
+		return logger.LogAppErrorf("Unable to encode state snapshot: %s", err)
+	}
+	file := constants.GetStateSnapshotPath()
+	if err := util.CreateDirectory(path.Dir(file)); err != nil {
+		return logger.LogAppErrorf("Unable to create state snapshot directory: %s", err)
+	}
+	// write then rename, so that an interrupted save never leaves a partial file
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return logger.LogAppErrorf("Unable to write state snapshot: %s", err)
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		return logger.LogAppErrorf("Unable to write state snapshot: %s", err)
+	}
+	logger.LogAppInfo("Saved state snapshot with %d game lists to %s",
+		len(snap.GameLists), file)
+	return nil
+}
+
+// RestoreState restores the server lists of the given games, along with the last
+// cycle reports, cut off master server queries, and tuned query concurrency,
+// from the snapshot file if state persistence is enabled and the snapshot is
+// recent enough. It returns the number of game lists that were restored.
+func RestoreState(games []string) (int, error) {
+	if !config.Get().SteamConfig.PersistState {
+		return 0, nil
+	}
+	file := constants.GetStateSnapshotPath()
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, logger.LogAppErrorf("Unable to read state snapshot: %s", err)
+	}
+	snap := stateSnapshot{}
+	if err := json.Unmarshal(b, &snap); err != nil {
+		return 0, logger.LogAppErrorf("Unable to decode state snapshot: %s", err)
+	}
+	if snap.Version != stateSnapshotVersion {
+		return 0, logger.LogAppErrorf(
+			"Not restoring state snapshot with version %d (expected %d)", snap.Version,
+			stateSnapshotVersion)
+	}
+	maxAge := time.Duration(config.Get().SteamConfig.MaxRestoredStateAge) *
+		time.Second
+	if age := time.Since(snap.SavedAt); age > maxAge {
+		logger.LogAppInfo("Not restoring state snapshot saved %s ago",
+			age-age%time.Second)
+		return 0, nil
+	}
+
+	restored := 0
+	for _, g := range games {
+		if r, ok := snap.MasterResumes[strings.ToLower(g)]; ok {
+			restoreMasterResume(g, r)
+		}
+		sl, ok := snap.GameLists[strings.ToLower(g)]
+		if !ok || sl == nil {
+			continue
+		}
+		models.SetGameList(g, sl)
+		restored++
+		for cg, r := range snap.LastCycles {
+			if strings.EqualFold(cg, g) {
+				lastCyclesMu.Lock()
+				lastCycles[cg] = r
+				lastCyclesMu.Unlock()
+			}
+		}
+	}
+	if snap.QueryLimit > 0 {
+		l := getQueryLimiter()
+		l.mu.Lock()
+		if l.autotune && snap.QueryLimit >= l.min && snap.QueryLimit <= l.max {
+			l.limit = snap.QueryLimit
+		}
+		l.mu.Unlock()
+	}
+	logger.LogAppInfo("Restored %d game lists from state snapshot saved at %s",
+		restored, snap.SavedAt.Format("Mon Jan 2 15:04:05 2006 EST"))
+	return restored, nil
+}
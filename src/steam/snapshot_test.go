@@ -0,0 +1,64 @@
+package steam
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/constants"
+	"github.com/syncore/a2sapi/src/models"
+)
+
+func TestSaveRestoreState(t *testing.T) {
+	prev := config.Get().SteamConfig
+	defer func() {
+		config.Get().SteamConfig = prev
+		models.SetGameList("SnapshotGame", nil)
+		os.Remove(constants.GetStateSnapshotPath())
+	}()
+	config.Get().SteamConfig.PersistState = true
+	config.Get().SteamConfig.MaxRestoredStateAge = 3600
+
+	sl := &models.APIServerList{CycleID: "snapshot-cycle", ServerCount: 1,
+		Servers: []models.APIServer{models.APIServer{Host: "10.0.0.1:27960",
+			Game: "SnapshotGame"}}}
+	models.SetGameList("SnapshotGame", sl)
+	if err := SaveState(); err != nil {
+		t.Fatalf("Unexpected error saving state: %s", err)
+	}
+	models.SetGameList("SnapshotGame", nil)
+
+	n, err := RestoreState([]string{"SnapshotGame", "OtherGame"})
+	if err != nil {
+		t.Fatalf("Unexpected error restoring state: %s", err)
+	}
+	if n != 1 {
+		t.Fatalf("Expected 1 restored game list, got: %d", n)
+	}
+	restored := models.GetGameList("snapshotgame")
+	if restored == nil || restored.CycleID != "snapshot-cycle" ||
+		len(restored.Servers) != 1 || restored.Servers[0].Host != "10.0.0.1:27960" {
+		t.Fatalf("Expected saved game list to be restored, got: %+v", restored)
+	}
+
+	// snapshots older than the maximum age are not restored
+	models.SetGameList("SnapshotGame", nil)
+	b, _ := ioutil.ReadFile(constants.GetStateSnapshotPath())
+	snap := stateSnapshot{}
+	json.Unmarshal(b, &snap)
+	snap.SavedAt = time.Now().Add(-2 * time.Hour)
+	b, _ = json.Marshal(snap)
+	ioutil.WriteFile(constants.GetStateSnapshotPath(), b, 0600)
+	if n, _ := RestoreState([]string{"SnapshotGame"}); n != 0 {
+		t.Fatalf("Expected old snapshot not to be restored, got: %d lists", n)
+	}
+
+	config.Get().SteamConfig.PersistState = false
+	config.Get().SteamConfig.MaxRestoredStateAge = 3 * 3600
+	if n, _ := RestoreState([]string{"SnapshotGame"}); n != 0 {
+		t.Fatalf("Expected nothing to be restored when disabled, got: %d lists", n)
+	}
+}
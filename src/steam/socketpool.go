@@ -0,0 +1,152 @@
+package steam
+
+// socketpool.go - Reuse of UDP sockets across A2S requests, so that retrieving
+// tens of thousands of hosts doesn't open (and close) a socket for each request.
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/util"
+)
+
+// a2sSocketsMetric counts the sockets that A2S requests were sent from.
+var a2sSocketsMetric = util.NewCounter("a2sapi_a2s_udp_sockets_total",
+	"UDP sockets used by A2S requests, by whether they were opened or reused.",
+	"state")
+
+// socketPool keeps idle, unconnected UDP sockets for reuse. Since a socket is
+// only in use while its request is in flight, the number of open sockets is
+// bounded by the in-flight limit plus the pool size.
+type socketPool struct {
+	idle   chan net.PacketConn
+	listen func() (net.PacketConn, error)
+}
+
+var (
+	sockets     *socketPool
+	socketsOnce sync.Once
+)
+
+func newSocketPool(size int) *socketPool {
+	return &socketPool{
+		idle: make(chan net.PacketConn, size),
+		listen: func() (net.PacketConn, error) {
+			return net.ListenPacket("udp", ":0")
+		},
+	}
+}
+
+// getSocketPool returns the application-wide UDP socket pool, creating it from
+// the configuration on first use, or nil if sockets aren't pooled.
+func getSocketPool() *socketPool {
+	socketsOnce.Do(func() {
+		if size := config.Get().SteamConfig.UDPSocketPoolSize; size > 0 {
+			sockets = newSocketPool(size)
+		}
+	})
+	return sockets
+}
+
+// dial returns a connection to host over an idle socket, or a new one if none
+// are idle. Closing the connection returns the socket to the pool, unless its
+// request failed.
+func (p *socketPool) dial(host string) (net.Conn, error) {
+	addr, err := net.ResolveUDPAddr("udp", host)
+	if err != nil {
+		return nil, err
+	}
+	var pc net.PacketConn
+	select {
+	case pc = <-p.idle:
+		a2sSocketsMetric.Inc("reused")
+	default:
+		if pc, err = p.listen(); err != nil {
+			return nil, err
+		}
+		a2sSocketsMetric.Inc("opened")
+	}
+	return &pooledConn{pc: pc, remote: addr, pool: p}, nil
+}
+
+// put returns a socket to the pool, closing it if the pool is full.
+func (p *socketPool) put(pc net.PacketConn) {
+	pc.SetDeadline(time.Time{})
+	select {
+	case p.idle <- pc:
+	default:
+		pc.Close()
+	}
+}
+
+// pooledConn is a connection to one host over a pooled socket. Unlike a
+// connected socket, the pooled socket receives datagrams from any address, so
+// those from other hosts (i.e. late responses to the socket's previous
+// requests) are discarded. Refused connections aren't reported either; those
+// requests time out instead.
+//
+// A late response from the same host can't be told apart, so a socket is only
+// returned to the pool if its last request was answered: one whose read or
+// write failed (i.e. timed out), or that was closed before the response to its
+// last write was read, is closed instead.
+type pooledConn struct {
+	pc     net.PacketConn
+	remote *net.UDPAddr
+	pool   *socketPool
+	mu     sync.Mutex
+	closed bool
+	// true if a read or write failed, or a write hasn't been answered yet
+	unclean bool
+}
+
+// setUnclean records whether the socket may still receive a response to this
+// connection's requests.
+func (c *pooledConn) setUnclean(unclean bool) {
+	c.mu.Lock()
+	c.unclean = unclean
+	c.mu.Unlock()
+}
+
+func (c *pooledConn) Read(b []byte) (int, error) {
+	for {
+		n, addr, err := c.pc.ReadFrom(b)
+		if err != nil {
+			c.setUnclean(true)
+			return n, err
+		}
+		if ua, ok := addr.(*net.UDPAddr); ok && ua.Port == c.remote.Port &&
+			ua.IP.Equal(c.remote.IP) {
+			c.setUnclean(false)
+			return n, nil
+		}
+	}
+}
+
+func (c *pooledConn) Write(b []byte) (int, error) {
+	c.setUnclean(true)
+	return c.pc.WriteTo(b, c.remote)
+}
+
+// Close returns the connection's socket to the pool, or closes it if the
+// connection's last request wasn't answered.
+func (c *pooledConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	if c.unclean {
+		return c.pc.Close()
+	}
+	c.pool.put(c.pc)
+	return nil
+}
+
+func (c *pooledConn) LocalAddr() net.Addr                { return c.pc.LocalAddr() }
+func (c *pooledConn) RemoteAddr() net.Addr               { return c.remote }
+func (c *pooledConn) SetDeadline(t time.Time) error      { return c.pc.SetDeadline(t) }
+func (c *pooledConn) SetReadDeadline(t time.Time) error  { return c.pc.SetReadDeadline(t) }
+func (c *pooledConn) SetWriteDeadline(t time.Time) error { return c.pc.SetWriteDeadline(t) }
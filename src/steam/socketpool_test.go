@@ -0,0 +1,127 @@
+package steam
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// echoServer answers each datagram it receives with the datagram itself.
+func echoServer(t *testing.T) net.PacketConn {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %s", err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	go func() {
+		b := make([]byte, 1400)
+		for {
+			n, addr, err := pc.ReadFrom(b)
+			if err != nil {
+				return
+			}
+			pc.WriteTo(b[:n], addr)
+		}
+	}()
+	return pc
+}
+
+func roundTrip(t *testing.T, c net.Conn, msg string) {
+	c.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := c.Write([]byte(msg)); err != nil {
+		t.Fatalf("Unexpected write error: %s", err)
+	}
+	b := make([]byte, 1400)
+	n, err := c.Read(b)
+	if err != nil {
+		t.Fatalf("Unexpected read error: %s", err)
+	}
+	if string(b[:n]) != msg {
+		t.Fatalf("Expected response %q, got: %q", msg, b[:n])
+	}
+}
+
+func TestSocketPool(t *testing.T) {
+	p := newSocketPool(1)
+	a, b := echoServer(t), echoServer(t)
+
+	c1, err := p.dial(a.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("Unexpected dial error: %s", err)
+	}
+	roundTrip(t, c1, "first")
+	local := c1.LocalAddr().String()
+	c1.Close()
+	// closing twice must not return the socket to the pool twice
+	c1.Close()
+	if len(p.idle) != 1 {
+		t.Fatalf("Expected 1 idle socket, got: %d", len(p.idle))
+	}
+
+	c2, err := p.dial(b.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("Unexpected dial error: %s", err)
+	}
+	if c2.LocalAddr().String() != local {
+		t.Fatalf("Expected socket %s to be reused, got: %s", local,
+			c2.LocalAddr())
+	}
+	// a late datagram from the socket's previous host is discarded
+	to := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1),
+		Port: c2.LocalAddr().(*net.UDPAddr).Port}
+	if _, err := a.WriteTo([]byte("late"), to); err != nil {
+		t.Fatalf("Unexpected write error: %s", err)
+	}
+	roundTrip(t, c2, "second")
+
+	// the pool is full, so a third socket is closed rather than kept
+	c3, err := p.dial(a.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("Unexpected dial error: %s", err)
+	}
+	c2.Close()
+	c3.Close()
+	if len(p.idle) != 1 {
+		t.Fatalf("Expected 1 idle socket, got: %d", len(p.idle))
+	}
+}
+
+// TestSocketPoolDiscardsUnanswered tests that sockets whose last request wasn't
+// answered are closed rather than returned to the pool, since a late response
+// from the same host couldn't be told apart from the next request's
+func TestSocketPoolDiscardsUnanswered(t *testing.T) {
+	p := newSocketPool(2)
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %s", err)
+	}
+	defer silent.Close()
+
+	// timed out
+	c1, err := p.dial(silent.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("Unexpected dial error: %s", err)
+	}
+	c1.SetDeadline(time.Now().Add(50 * time.Millisecond))
+	c1.Write([]byte("first"))
+	if _, err := c1.Read(make([]byte, 1400)); err == nil {
+		t.Fatalf("Expected read to time out")
+	}
+	c1.Close()
+	if len(p.idle) != 0 {
+		t.Fatalf("Expected timed out socket to be closed, got %d idle sockets",
+			len(p.idle))
+	}
+
+	// closed before the response was read
+	c2, err := p.dial(echoServer(t).LocalAddr().String())
+	if err != nil {
+		t.Fatalf("Unexpected dial error: %s", err)
+	}
+	c2.Write([]byte("second"))
+	c2.Close()
+	if len(p.idle) != 0 {
+		t.Fatalf("Expected unanswered socket to be closed, got %d idle sockets",
+			len(p.idle))
+	}
+}
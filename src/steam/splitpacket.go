@@ -0,0 +1,129 @@
+package steam
+
+// splitpacket.go - Reassembly of A2S responses that are split over multiple
+// packets, in both the Source format and the older GoldSrc format (used by i.e.
+// Half-Life Deathmatch and Counter-Strike 1.6).
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+)
+
+// maxSplitPackets is the most packets that a split response can have (the
+// Source header's total is a single byte).
+const maxSplitPackets = 255
+
+// splitFormat returns whether a split response is in the GoldSrc format, which
+// packs the packet number (upper 4 bits) and total number of packets (lower 4
+// bits) into one byte and has no size field, or the Source format. This can
+// only be told from packet 0, whose payload starts with the single-packet
+// header right after the split header of either format; ok is false for any
+// other packet.
+func splitFormat(p []byte) (goldSrc, ok bool) {
+	if len(p) >= 13 && p[8]>>4 == 0 && bytes.Equal(p[9:13], []byte(headerStr)) {
+		return true, true
+	}
+	if len(p) >= 16 && p[9] == 0 && bytes.Equal(p[12:16], []byte(headerStr)) {
+		return false, true
+	}
+	return false, false
+}
+
+// splitPacketInfo returns the number of a split packet, the total number of
+// packets, and the offset of its payload.
+func splitPacketInfo(p []byte, goldSrc bool) (num, total uint32, offset int,
+	err error) {
+	// header: 4 bytes, 0xFFFFFFFE (already verified in caller)
+	// ID: 4 bytes, signed
+	if goldSrc {
+		// packet # (upper 4 bits) and total # of packets (lower 4 bits): 1 byte
+		if len(p) < 9 {
+			return 0, 0, 0, ErrMultiPacketTransmit("packet header too short")
+		}
+		return uint32(p[8] >> 4), uint32(p[8] & 0x0F), 9, nil
+	}
+	// total # of packets: 1 byte, unsigned
+	// current packet #, starts at zero: 1 byte, unsigned
+	// size: 2 bytes, only for Orange Box Engine and Newer, signed
+	// size & CRC32 sum for bzip2 compressed packets; but no longer used since late 2005
+	// note: size won't exist for 4 ancient appids (215,17550,17700,240 w/protocol 7)
+	if len(p) < 12 {
+		return 0, 0, 0, ErrMultiPacketTransmit("packet header too short")
+	}
+	return uint32(p[9]), uint32(p[8]), 12, nil
+}
+
+// handleMultiPacketResponse reads the rest of a split response whose first
+// received packet is given, and returns the reassembled payload. Packets that
+// arrive before packet 0 are kept until it tells the response's format. Caller
+// will log errors, along with the host.
+func handleMultiPacketResponse(c net.Conn, firstReceived []byte) ([]byte,
+	error) {
+	id := int32(binary.LittleEndian.Uint32(firstReceived[4:8]))
+	var goldSrc, known bool
+	var early [][]byte
+	packets := make(map[uint32][]byte)
+	var total uint32
+	add := func(packet []byte) error {
+		num, t, offset, err := splitPacketInfo(packet, goldSrc)
+		if err != nil {
+			return err
+		}
+		if total == 0 {
+			total = t
+		}
+		if num >= total {
+			return ErrMultiPacketNumExceeded
+		}
+		if _, ok := packets[num]; ok {
+			return ErrMultiPacketDuplicate
+		}
+		packets[num] = packet[offset:]
+		return nil
+	}
+	var buf [maxPacketSize]byte
+	packet := firstReceived
+	for {
+		if len(packet) < 9 {
+			return nil, ErrMultiPacketTransmit("packet header too short")
+		}
+		if int32(binary.LittleEndian.Uint32(packet[4:8])) != id {
+			return nil, ErrMultiPacketIDMismatch
+		}
+		p := make([]byte, len(packet))
+		copy(p, packet)
+		if known {
+			if err := add(p); err != nil {
+				return nil, err
+			}
+		} else if goldSrc, known = splitFormat(p); known {
+			for _, e := range append([][]byte{p}, early...) {
+				if err := add(e); err != nil {
+					return nil, err
+				}
+			}
+		} else {
+			if len(early) >= maxSplitPackets {
+				return nil, ErrMultiPacketNumExceeded
+			}
+			early = append(early, p)
+		}
+		if known && uint32(len(packets)) == total {
+			break
+		}
+		numread, err := c.Read(buf[:maxPacketSize])
+		if err != nil {
+			return nil, ErrMultiPacketTransmit(err.Error())
+		}
+		packet = buf[:numread]
+		if !bytes.HasPrefix(packet, multiPacketRespHeader) {
+			return nil, ErrPacketHeader
+		}
+	}
+	var payload []byte
+	for n := uint32(0); n < total; n++ {
+		payload = append(payload, packets[n]...)
+	}
+	return payload, nil
+}
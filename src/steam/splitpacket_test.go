@@ -0,0 +1,90 @@
+package steam
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+// splitResponse returns the payload split into packets with the given header
+// builder, which returns the header for packet n of total.
+func splitResponse(payload []byte, total int,
+	header func(n, total int) []byte) [][]byte {
+	size := (len(payload) + total - 1) / total
+	var packets [][]byte
+	for n := 0; n < total; n++ {
+		end := (n + 1) * size
+		if end > len(payload) {
+			end = len(payload)
+		}
+		packets = append(packets, append(header(n, total), payload[n*size:end]...))
+	}
+	return packets
+}
+
+func reassemble(packets [][]byte) ([]byte, error) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		for _, p := range packets[1:] {
+			server.Write(p)
+		}
+		server.Close()
+	}()
+	return handleMultiPacketResponse(client, packets[0])
+}
+
+func TestHandleMultiPacketResponse(t *testing.T) {
+	payload := append(append([]byte{}, expectedRuleChunkHeader...),
+		bytes.Repeat([]byte("sv_cheats\x000\x00"), 30)...)
+	id := []byte{0x01, 0x02, 0x00, 0x00}
+	source := func(n, total int) []byte {
+		return append(append(append([]byte{}, multiPacketRespHeader...), id...),
+			byte(total), byte(n), 0xE0, 0x04)
+	}
+	goldSrc := func(n, total int) []byte {
+		return append(append(append([]byte{}, multiPacketRespHeader...), id...),
+			byte(n<<4|total))
+	}
+
+	packets := splitResponse(payload, 3, source)
+	if g, ok := splitFormat(packets[0]); g || !ok {
+		t.Fatalf("Expected Source split packet 0 to be detected as Source")
+	}
+	if _, ok := splitFormat(packets[1]); ok {
+		t.Fatalf("Expected the format not to be told from a later packet")
+	}
+	b, err := reassemble(packets)
+	if err != nil || !bytes.Equal(b, payload) {
+		t.Fatalf("Unable to reassemble Source response: %v", err)
+	}
+	// out of order, starting with a later packet whose total (0x12) would read
+	// as GoldSrc packet 1 of 2
+	packets = splitResponse(payload, 0x12, source)
+	b, err = reassemble(append([][]byte{packets[5]}, append(packets[:5:5],
+		packets[6:]...)...))
+	if err != nil || !bytes.Equal(b, payload) {
+		t.Fatalf("Unable to reassemble out of order Source response: %v", err)
+	}
+
+	packets = splitResponse(payload, 3, goldSrc)
+	if g, ok := splitFormat(packets[0]); !g || !ok {
+		t.Fatalf("Expected GoldSrc split packet 0 to be detected as GoldSrc")
+	}
+	// out of order, starting with a later packet
+	b, err = reassemble([][]byte{packets[2], packets[0], packets[1]})
+	if err != nil || !bytes.Equal(b, payload) {
+		t.Fatalf("Unable to reassemble GoldSrc response: %v", err)
+	}
+
+	if _, err := reassemble([][]byte{packets[0], packets[0]}); err !=
+		ErrMultiPacketDuplicate {
+		t.Fatalf("Expected duplicate packet error, got: %v", err)
+	}
+	other := append([]byte{}, packets[1]...)
+	other[4] = 0x09
+	if _, err := reassemble([][]byte{packets[0], other}); err !=
+		ErrMultiPacketIDMismatch {
+		t.Fatalf("Expected packet ID mismatch error, got: %v", err)
+	}
+}
@@ -1,16 +1,26 @@
 package steam
 
+import (
+	"net"
+	"time"
+)
+
 const (
 	headerStr     = "\xFF\xFF\xFF\xFF"
 	maxPacketSize = 1400 // specified by steam protocol
-	// QueryTimeout is the connect, read, and write timeout in seconds. It should
-	// be greater than 1.
-	QueryTimeout = 3
+	// QueryTimeout is the time allowed for each A2S request (the connect, read,
+	// and write timeout).
+	QueryTimeout = 2 * time.Second
 	// QueryRetryCount is the number of times to re-request rules, players, and info
 	// on failure.
 	QueryRetryCount = 3
 )
 
+// masterQueryTimeout is the connect timeout for the master server query, and
+// the read and write timeout for each page of it. Like QueryTimeout, it is a
+// time.Duration and must not be scaled by time.Second.
+var masterQueryTimeout = 3 * time.Second
+
 var (
 	// Multi-packet response header
 	multiPacketRespHeader = []byte{0xFE, 0xFF, 0xFF, 0xFF}
@@ -25,6 +35,9 @@ var (
 		0x00}
 	// A2S_INFO: expected challenge response header
 	expectedInfoRespHeader = []byte{0xFF, 0xFF, 0xFF, 0xFF, 0x49}
+	// A2S_INFO: challenge (S2C_CHALLENGE) that servers updated for Valve's 2020
+	// protocol change reply with; the request must be re-sent with it appended
+	expectedInfoChallengeHeader = []byte{0xFF, 0xFF, 0xFF, 0xFF, 0x41}
 
 	// A2S_PLAYER: challenge request packet
 	playerChallengeReq = []byte{0xFF, 0xFF, 0xFF, 0xFF, 0x55, 0xFF, 0xFF,
@@ -47,6 +60,36 @@ var (
 		0x66, 0x0A}
 )
 
+// udpDialer opens the UDP connections that A2S requests are sent over.
+type udpDialer interface {
+	Dial(host string, timeout time.Duration) (net.Conn, error)
+}
+
+type netDialer struct{}
+
+func (netDialer) Dial(host string, timeout time.Duration) (net.Conn, error) {
+	if p := getSocketPool(); p != nil {
+		return p.dial(host)
+	}
+	return net.DialTimeout("udp", host, timeout)
+}
+
+// queryDialer is the dialer used by the query engine; tests replace it in order
+// to simulate servers without real sockets.
+var queryDialer udpDialer = netDialer{}
+
+// dialServer opens a UDP connection to a host whose reads and writes must
+// complete within timeout.
+func dialServer(host string, timeout time.Duration) (net.Conn, error) {
+	conn, err := queryDialer.Dial(host, timeout)
+	if err != nil {
+		logHostError(host, ErrHostConnection(err.Error()))
+		return nil, ErrHostConnection(err.Error())
+	}
+	conn.SetDeadline(queryClock.Now().Add(timeout))
+	return conn, nil
+}
+
 func removeFailedHost(failed []string, host string) []string {
 	for i, v := range failed {
 		if v == host {
@@ -0,0 +1,194 @@
+package steam
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock that only moves when advanced.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Since(t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+func (c *fakeClock) advanceTo(t time.Time) {
+	c.mu.Lock()
+	if t.After(c.now) {
+		c.now = t
+	}
+	c.mu.Unlock()
+}
+
+type fakeTimeoutError struct{}
+
+func (fakeTimeoutError) Error() string   { return "i/o timeout" }
+func (fakeTimeoutError) Timeout() bool   { return true }
+func (fakeTimeoutError) Temporary() bool { return true }
+
+// fakeServer returns the packets that a simulated server replies to a request
+// with; no packets simulates a lost request or response.
+type fakeServer func(req []byte) [][]byte
+
+// fakeDialer connects to simulated servers. Each reply takes latency to
+// arrive, and reads with no reply pending time out at the connection's
+// deadline, by advancing the clock rather than sleeping.
+type fakeDialer struct {
+	clock   *fakeClock
+	latency time.Duration
+	servers map[string]fakeServer
+	mu      sync.Mutex
+	dials   int
+}
+
+func (d *fakeDialer) Dial(host string, timeout time.Duration) (net.Conn,
+	error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dials++
+	srv, ok := d.servers[host]
+	if !ok {
+		return nil, errors.New("connection refused")
+	}
+	return &fakeConn{dialer: d, server: srv}, nil
+}
+
+type fakeConn struct {
+	dialer   *fakeDialer
+	server   fakeServer
+	pending  [][]byte
+	deadline time.Time
+}
+
+func (c *fakeConn) Write(b []byte) (int, error) {
+	c.pending = append(c.pending, c.server(b)...)
+	return len(b), nil
+}
+
+func (c *fakeConn) Read(b []byte) (int, error) {
+	if len(c.pending) == 0 {
+		c.dialer.clock.advanceTo(c.deadline)
+		return 0, fakeTimeoutError{}
+	}
+	c.dialer.clock.advance(c.dialer.latency)
+	n := copy(b, c.pending[0])
+	c.pending = c.pending[1:]
+	return n, nil
+}
+
+func (c *fakeConn) Close() error                       { return nil }
+func (c *fakeConn) LocalAddr() net.Addr                { return &net.UDPAddr{} }
+func (c *fakeConn) RemoteAddr() net.Addr               { return &net.UDPAddr{} }
+func (c *fakeConn) SetDeadline(t time.Time) error      { c.deadline = t; return nil }
+func (c *fakeConn) SetReadDeadline(t time.Time) error  { c.deadline = t; return nil }
+func (c *fakeConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// useFakeNetwork replaces the query engine's clock and dialer for the duration
+// of a test. The clock starts at the current time, so that contexts' deadlines
+// (which are still checked against the real time) can be set relative to it.
+func useFakeNetwork(t *testing.T, latency time.Duration,
+	servers map[string]fakeServer) (*fakeClock, *fakeDialer) {
+	c := &fakeClock{now: time.Now()}
+	d := &fakeDialer{clock: c, latency: latency, servers: servers}
+	oldClock, oldDialer := queryClock, queryDialer
+	queryClock, queryDialer = c, d
+	t.Cleanup(func() { queryClock, queryDialer = oldClock, oldDialer })
+	return c, d
+}
+
+func TestQueryServerRetriesTimeout(t *testing.T) {
+	info := append(append([]byte{}, expectedInfoRespHeader...), 0x11,
+		'a', 0x00, 'b', 0x00, 'c', 0x00, 'd', 0x00, 0x01, 0x00, 0x03, 0x10, 0x00,
+		'd', 'l', 0x00, 0x00, '1', 0x00, 0x00)
+	requests := 0
+	c, d := useFakeNetwork(t, 40*time.Millisecond, map[string]fakeServer{
+		"192.0.2.1:27015": func(req []byte) [][]byte {
+			if !bytes.Equal(req, infoChallengeReq) {
+				return nil
+			}
+			// the first request is lost
+			if requests++; requests == 1 {
+				return nil
+			}
+			return [][]byte{info}
+		},
+	})
+	start := c.Now()
+
+	res, err := QueryServer(context.Background(), "192.0.2.1:27015",
+		Options{Info: true, Timeout: 2 * time.Second, Retries: 1})
+	if err != nil {
+		t.Fatalf("Unexpected error querying server info: %s", err)
+	}
+	if res.Info == nil || res.Info.Map != "b" {
+		t.Fatalf("Expected info with map b, got: %+v", res.Info)
+	}
+	if res.Info.Ping != 40 {
+		t.Fatalf("Expected ping of 40ms, got: %d", res.Info.Ping)
+	}
+	if d.dials != 2 {
+		t.Fatalf("Expected 2 connections (1 retry), got: %d", d.dials)
+	}
+	if elapsed := c.Since(start); elapsed != 2*time.Second+40*time.Millisecond {
+		t.Fatalf("Expected one timeout and one reply to elapse, got: %s", elapsed)
+	}
+
+	// a context deadline shorter than the timeout limits each request, and no
+	// more requests are made once it has passed on the clock
+	requests = 0
+	d.dials = 0
+	ctx, cancel := context.WithDeadline(context.Background(),
+		c.Now().Add(500*time.Millisecond))
+	defer cancel()
+	if _, err = QueryServer(ctx, "192.0.2.1:27015", Options{Info: true,
+		Timeout: 2 * time.Second, Retries: 5}); err != context.DeadlineExceeded {
+		t.Fatalf("Expected deadline exceeded error, got: %v", err)
+	}
+	if d.dials != 1 {
+		t.Fatalf("Expected 1 connection before the deadline, got: %d", d.dials)
+	}
+}
+
+func TestGetPlayerInfoPartialSplitResponse(t *testing.T) {
+	challenge := append(append([]byte{}, expectedPlayerRespHeader...),
+		0x01, 0x02, 0x03, 0x04)
+	split := func(n byte) []byte {
+		return []byte{0xFE, 0xFF, 0xFF, 0xFF, 0x01, 0x00, 0x00, 0x00, 0x02, n,
+			0xE0, 0x04, 0x00}
+	}
+	// only the first of the two packets is ever sent
+	useFakeNetwork(t, time.Millisecond, map[string]fakeServer{
+		"192.0.2.1:27015": func(req []byte) [][]byte {
+			if bytes.Equal(req, playerChallengeReq) {
+				return [][]byte{challenge}
+			}
+			return [][]byte{split(0)}
+		},
+	})
+	if _, err := getPlayerInfo("192.0.2.1:27015", time.Second); err == nil {
+		t.Fatalf("Expected error for incomplete split response")
+	}
+	if _, err := getPlayerInfo("192.0.2.2:27015", time.Second); err == nil {
+		t.Fatalf("Expected error for unreachable host")
+	}
+}
@@ -20,6 +20,11 @@ var (
 	ErrMultiPacketTransmit = func(msg string) error {
 		return fmt.Errorf("Steam: multi-packet data transmission error: %s", msg)
 	}
+	// ErrMalformedPacket is an error thrown when an A2S response does not conform
+	// to the protocol and lenient parsing is disabled.
+	ErrMalformedPacket = func(msg string) error {
+		return fmt.Errorf("Steam: malformed packet: %s", msg)
+	}
 	// ErrChallengeResponse is an error thrown for an invalid challense response
 	// header.
 	ErrChallengeResponse = errors.New("Steam: invalid challenge response header")
@@ -44,6 +49,12 @@ var (
 	ErrMultiPacketNumExceeded = errors.New(
 		"Steam: multi-packet error: packet number greater than total")
 
+	// ErrMasterListPartial is an error returned with the servers received so far
+	// when the Steam master server stops responding before the end of its list,
+	// usually due to Valve's rate limiting.
+	ErrMasterListPartial = errors.New(
+		"Steam: master server stopped responding before the end of the list")
+
 	// ErrNoPlayers is a generic error thrown when a server is empty.
 	ErrNoPlayers = errors.New("Steam: server contains no players")
 
@@ -54,4 +65,12 @@ var (
 	// ErrNoInfo is a generic error thrown when no A2S_INFO could be parsed for the
 	// given server.
 	ErrNoInfo = errors.New("Steam: no A2S_INFO for server")
+
+	// ErrQueryBudget is an error thrown when a host has used up the time allowed
+	// for its requests in the current retrieval.
+	ErrQueryBudget = errors.New("Steam: query time budget for host exhausted")
+
+	// ErrNoResponse is an error thrown when a server that is refreshed on demand
+	// does not respond.
+	ErrNoResponse = errors.New("Steam: server did not respond")
 )
@@ -4,52 +4,67 @@ package steam
 
 import (
 	"bytes"
-	"encoding/binary"
-	"net"
+	"context"
 	"sync"
 	"time"
 
 	"github.com/syncore/a2sapi/src/logger"
 	"github.com/syncore/a2sapi/src/models"
-	"github.com/syncore/a2sapi/src/util"
 )
 
-func getServerInfo(host string, timeout int) ([]byte, error) {
-	conn, err := net.DialTimeout("udp", host, time.Duration(timeout)*time.Second)
+func getServerInfo(host string, timeout time.Duration) ([]byte, error) {
+	conn, err := dialServer(host, timeout)
 	if err != nil {
-		logger.LogSteamError(ErrHostConnection(err.Error()))
-		return nil, ErrHostConnection(err.Error())
+		return nil, err
 	}
 	defer conn.Close()
-	conn.SetDeadline(time.Now().Add(time.Duration(timeout-1) * time.Second))
 
 	_, err = conn.Write(infoChallengeReq)
 	if err != nil {
-		logger.LogSteamError(ErrDataTransmit(err.Error()))
+		logHostError(host, ErrDataTransmit(err.Error()))
 		return nil, ErrDataTransmit(err.Error())
 	}
 
 	var buf [maxPacketSize]byte
 	numread, err := conn.Read(buf[:maxPacketSize])
 	if err != nil {
-		logger.LogSteamError(ErrDataTransmit(err.Error()))
+		logHostError(host, ErrDataTransmit(err.Error()))
 		return nil, ErrDataTransmit(err.Error())
 	}
+	if bytes.HasPrefix(buf[:numread], expectedInfoChallengeHeader) {
+		// challenge number follows the header
+		hl := len(expectedInfoChallengeHeader)
+		if numread < hl+4 {
+			logHostError(host, ErrChallengeResponse)
+			return nil, ErrChallengeResponse
+		}
+		request := append(append([]byte{}, infoChallengeReq...), buf[hl:hl+4]...)
+		if _, err = conn.Write(request); err != nil {
+			logHostError(host, ErrDataTransmit(err.Error()))
+			return nil, ErrDataTransmit(err.Error())
+		}
+		numread, err = conn.Read(buf[:maxPacketSize])
+		if err != nil {
+			logHostError(host, ErrDataTransmit(err.Error()))
+			return nil, ErrDataTransmit(err.Error())
+		}
+	}
 	serverInfo := make([]byte, numread)
 	copy(serverInfo, buf[:numread])
 
 	if !bytes.HasPrefix(serverInfo, expectedInfoRespHeader) {
-		logger.LogSteamError(ErrPacketHeader)
+		logHostError(host, ErrPacketHeader)
 		return nil, ErrPacketHeader
 	}
 
 	return serverInfo, nil
 }
 
-func parseServerInfo(serverinfo []byte) (models.SteamServerInfo, error) {
+func parseServerInfo(serverinfo []byte, lenient bool) (models.SteamServerInfo,
+	[]parseWarning, error) {
 	if !bytes.HasPrefix(serverinfo, expectedInfoRespHeader) {
 		logger.LogSteamError(ErrPacketHeader)
-		return models.SteamServerInfo{}, ErrPacketHeader
+		return models.SteamServerInfo{}, nil, ErrPacketHeader
 	}
 
 	serverinfo = bytes.TrimLeft(serverinfo, headerStr)
@@ -57,77 +72,63 @@ func parseServerInfo(serverinfo []byte) (models.SteamServerInfo, error) {
 	// no info (should usually not happen)
 	if len(serverinfo) <= 1 {
 		logger.LogSteamError(ErrNoInfo)
-		return models.SteamServerInfo{}, ErrNoInfo
-	}
-
-	serverinfo = serverinfo[1:] // 0x49
-	protocol := int(serverinfo[0])
-	serverinfo = serverinfo[1:]
-
-	name := util.ReadTillNul(serverinfo)
-	serverinfo = serverinfo[len(name)+1:]
-	mapname := util.ReadTillNul(serverinfo)
-	serverinfo = serverinfo[len(mapname)+1:]
-	folder := util.ReadTillNul(serverinfo)
-	serverinfo = serverinfo[len(folder)+1:]
-	game := util.ReadTillNul(serverinfo)
-	serverinfo = serverinfo[len(game)+1:]
-	id := int16(binary.LittleEndian.Uint16(serverinfo[:2]))
-	serverinfo = serverinfo[2:]
+		return models.SteamServerInfo{}, nil, ErrNoInfo
+	}
+
+	r := newPacketReader(serverinfo[1:], lenient) // 0x49
+	protocol := int(r.readByte("info.protocol"))
+	name := r.readString("info.name")
+	mapname := r.readString("info.map")
+	folder := r.readString("info.folder")
+	game := r.readString("info.game")
+	id := int16(r.readUint16("info.id"))
 	if id >= 2400 && id <= 2412 {
-		return models.SteamServerInfo{},
+		return models.SteamServerInfo{}, nil,
 			logger.LogSteamErrorf("The Ship servers are not supported")
 	}
-	players := int16(serverinfo[0])
-	serverinfo = serverinfo[1:]
-	maxplayers := int16(serverinfo[0])
-	serverinfo = serverinfo[1:]
-	bots := int16(serverinfo[0])
-	serverinfo = serverinfo[1:]
-	servertype := string(serverinfo[0])
-	serverinfo = serverinfo[1:]
-	environment := string(serverinfo[0])
-	serverinfo = serverinfo[1:]
-	visibility := int16(serverinfo[0])
-	serverinfo = serverinfo[1:]
-	vac := int16(serverinfo[0])
-	serverinfo = serverinfo[1:]
-	version := util.ReadTillNul(serverinfo)
-	serverinfo = serverinfo[len(version)+1:]
-
-	// extra data flags
+	players := int16(r.readByte("info.players"))
+	maxplayers := int16(r.readByte("info.maxPlayers"))
+	bots := int16(r.readByte("info.bots"))
+	servertype := string(r.readByte("info.serverType"))
+	environment := string(r.readByte("info.environment"))
+	visibility := int16(r.readByte("info.visibility"))
+	vac := int16(r.readByte("info.vac"))
+	version := r.readString("info.version")
+
+	// extra data flags (optional)
 	var port int16
 	var steamid uint64
 	var sourcetvport int16
 	var sourcetvname string
 	var keywords string
 	var gameid uint64
-	edf := serverinfo[0]
-	serverinfo = serverinfo[1:]
+	var edf byte
+	if len(r.b) > 0 {
+		edf = r.readByte("info.extraData")
+	}
 	if edf != 0x00 {
 		if edf&0x80 > 0 {
-			port = int16(binary.LittleEndian.Uint16(serverinfo[:2]))
-			serverinfo = serverinfo[2:]
+			port = int16(r.readUint16("info.extraData.port"))
 		}
 		if edf&0x10 > 0 {
-			steamid = binary.LittleEndian.Uint64(serverinfo[:8])
-			serverinfo = serverinfo[8:]
+			steamid = r.readUint64("info.extraData.steamID")
 		}
 		if edf&0x40 > 0 {
-			sourcetvport = int16(binary.LittleEndian.Uint16(serverinfo[:2]))
-			serverinfo = serverinfo[2:]
-			sourcetvname = util.ReadTillNul(serverinfo)
-			serverinfo = serverinfo[len(sourcetvname)+1:]
+			sourcetvport = int16(r.readUint16("info.extraData.sourceTVPort"))
+			sourcetvname = r.readString("info.extraData.sourceTVName")
 		}
 		if edf&0x20 > 0 {
-			keywords = util.ReadTillNul(serverinfo)
-			serverinfo = serverinfo[len(keywords)+1:]
+			keywords = r.readString("info.extraData.keywords")
 		}
 		if edf&0x01 > 0 {
-			gameid = binary.LittleEndian.Uint64(serverinfo[:8])
-			serverinfo = serverinfo[len(serverinfo):]
+			gameid = r.readUint64("info.extraData.gameID")
 		}
 	}
+	r.checkTrailing("info")
+	if r.err != nil {
+		logger.LogSteamError(r.err)
+		return models.SteamServerInfo{}, nil, r.err
+	}
 
 	// format a few ambiguous values
 	if environment == "l" {
@@ -172,56 +173,61 @@ func parseServerInfo(serverinfo []byte) (models.SteamServerInfo, error) {
 			Keywords:     keywords,
 			GameID:       gameid,
 		},
-	}, nil
+	}, r.warnings, nil
 }
 
 // RetryFailedInfoReq retries a failed A2S_INFO request for a specified group of
 // failed hosts for a total of retrycount times, returning a host to A2S_INFO
-// mapping for any hosts that were successfully retried.
-func RetryFailedInfoReq(failed []string,
-	retrycount int) map[string]models.SteamServerInfo {
+// mapping for any hosts that were successfully retried. Retries count
+// towards the hosts' query budget and stop once ctx is cancelled.
+func RetryFailedInfoReq(ctx context.Context, failed []string, retrycount int,
+	budget *queryBudget) map[string]models.SteamServerInfo {
 	m := make(map[string]models.SteamServerInfo)
 	var f []string
-	var wg sync.WaitGroup
 	var mut sync.Mutex
-	for i := 0; i < retrycount; i++ {
+	for i := 0; i < retrycount && ctx.Err() == nil; i++ {
 		if i == 0 {
 			f = failed
 		}
-		wg.Add(len(f))
-		for _, host := range f {
-			go func(h string) {
-				defer wg.Done()
-				r, err := GetInfoForServer(h, QueryTimeout)
-				if err != nil {
-					if err != ErrNoInfo {
-						return
-					}
-				}
-				mut.Lock()
-				m[h] = r
-				f = removeFailedHost(f, h)
-				mut.Unlock()
-			}(host)
-		}
-		wg.Wait()
+		// f shrinks as hosts succeed, so the workers are given a copy
+		forEachHost(append([]string(nil), f...), func(h string) {
+			if i >= retryCount(h, retrycount) {
+				return
+			}
+			a2sRetriesMetric.Inc(reqTypeInfo)
+			r, err := limitedInfoQuery(ctx, h, budget)
+			if err != nil && err != ErrNoInfo {
+				return
+			}
+			mut.Lock()
+			m[h] = r
+			f = removeFailedHost(f, h)
+			mut.Unlock()
+		})
 	}
 	return m
 }
 
-// GetInfoForServer requests A2S_INFO for a given host within timeout seconds.
-func GetInfoForServer(host string, timeout int) (models.SteamServerInfo, error) {
+// GetInfoForServer requests A2S_INFO for a given host within timeout.
+func GetInfoForServer(host string, timeout time.Duration) (models.SteamServerInfo,
+	error) {
 	// Caller will log. Return err instead of wrapped logger.LogSteamError so as not
 	// to interfere with custom error types that need to be analyzed when
 	// determining if retry needs to be done.
-	si, err := getServerInfo(host, timeout)
+	start := queryClock.Now()
+	si, err := fetchRaw(rawInfo, host, func() ([]byte, error) {
+		return getServerInfo(host, timeout)
+	})
 	if err != nil {
 		return models.SteamServerInfo{}, err
 	}
+	rtt := queryClock.Since(start)
 
-	serverinfo, err := parseServerInfo(si)
+	serverinfo, warnings, err := parseServerInfo(si, useLenientParsing())
 	if err != nil {
 		return models.SteamServerInfo{}, err
 	}
+	recordParseWarnings(host, "info", warnings)
+	serverinfo.Ping = int(rtt / time.Millisecond)
 	return serverinfo, nil
 }
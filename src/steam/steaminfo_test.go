@@ -1,8 +1,15 @@
 package steam
 
 import (
+	"bytes"
+	"net"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/syncore/a2sapi/src/models"
+	"github.com/syncore/a2sapi/src/steam/filters"
 )
 
 func TestParseServerInfo(t *testing.T) {
@@ -20,7 +27,7 @@ func TestParseServerInfo(t *testing.T) {
 		0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
 		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
 		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
-	sinfo, err := parseServerInfo(data)
+	sinfo, _, err := parseServerInfo(data, false)
 	if err != nil {
 		t.Fatalf("Unexpected error when parsing server info")
 	}
@@ -39,3 +46,75 @@ func TestParseServerInfo(t *testing.T) {
 		t.Fatalf("Expected server's game folder to be baseq3, got: %s", sinfo.Folder)
 	}
 }
+
+func TestGetServerTags(t *testing.T) {
+	// truncated to maxKeywordsLen in the middle of the last tag
+	long := strings.Repeat("tag,", 30) + "compe"
+	long += strings.Repeat("x", maxKeywordsLen-len(long))
+	server := func(keywords string, rules map[string]string) models.APIServer {
+		return models.APIServer{Rules: rules,
+			Info: models.SteamServerInfo{ExtraData: models.SteamExtraData{
+				Keywords: keywords}}}
+	}
+	tests := []struct {
+		game     filters.Game
+		srv      models.APIServer
+		expected []string
+	}{
+		{filters.GameQuakeLive, server("clanarena, syncore,texas,", nil),
+			[]string{"clanarena", "syncore", "texas"}},
+		{filters.GameReflex, server("ffa|texas", nil), []string{"ffa", "texas"}},
+		{filters.GameCsGo, server("empty,secure", nil), []string{"empty", "secure"}},
+		{filters.GameCsGo, server("empty,secure",
+			map[string]string{"sv_tags": "Secure,128tick,surf"}),
+			[]string{"empty", "secure", "128tick", "surf"}},
+		{filters.GameCsGo, server(long,
+			map[string]string{"SV_TAGS": "tag,competitive,128tick"}),
+			[]string{"tag", "competitive", "128tick"}},
+		// sv_tags is only merged for games that truncate their keywords
+		{filters.GameCSSource, server("alltalk",
+			map[string]string{"sv_tags": "increased_maxplayers"}),
+			[]string{"alltalk"}},
+	}
+	for _, tt := range tests {
+		if tags := getServerTags(tt.game, tt.srv); !reflect.DeepEqual(tags,
+			tt.expected) {
+			t.Errorf("%s: expected tags %v, got: %v", tt.game.Name, tt.expected, tags)
+		}
+	}
+}
+
+func TestGetServerInfoChallenge(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %s", err)
+	}
+	defer conn.Close()
+	challenge := []byte{0xFF, 0x12, 0x34, 0x56}
+	info := append(append([]byte{}, expectedInfoRespHeader...), 0x11, 0x00)
+	go func() {
+		buf := make([]byte, maxPacketSize)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if bytes.Equal(buf[:n], infoChallengeReq) {
+				conn.WriteTo(append(append([]byte{},
+					expectedInfoChallengeHeader...), challenge...), addr)
+				continue
+			}
+			if bytes.Equal(buf[:n], append(append([]byte{}, infoChallengeReq...),
+				challenge...)) {
+				conn.WriteTo(info, addr)
+			}
+		}
+	}()
+	resp, err := getServerInfo(conn.LocalAddr().String(), time.Second)
+	if err != nil {
+		t.Fatalf("Unexpected error when requesting info with challenge: %s", err)
+	}
+	if !bytes.Equal(resp, info) {
+		t.Fatalf("Expected info response after challenge, got: %v", resp)
+	}
+}
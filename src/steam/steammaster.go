@@ -10,6 +10,7 @@ package steam
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"net"
 	"time"
@@ -22,29 +23,58 @@ import (
 // MasterQuery contains the servers returned by a query to the Steam master server.
 type MasterQuery struct {
 	Servers []string
+	// true if the master server stopped responding before the end of the list
+	Partial bool
 }
 
-const masterServerHost = "hl2master.steampowered.com:27011"
+var masterServerHost = "hl2master.steampowered.com:27011"
 
-func getServers(filter filters.Filter) ([]string, error) {
-	maxHosts := config.Config.SteamConfig.MaximumHostsToReceive
+// getServers retrieves the servers for the filter from the Steam master server,
+// continuing from where the previous query left off if it was cut off. If the
+// master server stops responding before the end of the list, the servers
+// received so far are returned along with ErrMasterListPartial, and the next
+// query resumes from the last of them.
+func getServers(ctx context.Context, filter filters.Filter) ([]string, error) {
+	maxHosts := config.Get().SteamConfig.GameMaxHostsToReceive(filter.Game.Name)
 	var serverlist []string
 	var c net.Conn
 	var err error
-	retrieved := 0
 	addr := "0.0.0.0:0"
+	if r, ok := getMasterResume(filter); ok {
+		addr = r.Seed
+		serverlist = append(serverlist, r.Servers...)
+		logger.LogSteamInfo("Resuming %s master query after %s with %d hosts from the previous retrieval",
+			filter.Game.Name, addr, len(serverlist))
+	}
+	resumed := len(serverlist)
+	retrieved := resumed
+	complete := false
 
-	c, err = net.DialTimeout("udp", masterServerHost,
-		time.Duration(QueryTimeout)*time.Second)
+	d := net.Dialer{Timeout: masterQueryTimeout}
+	c, err = d.DialContext(ctx, "udp", masterServerHost)
 	if err != nil {
 		logger.LogSteamError(ErrHostConnection(err.Error()))
 		return nil, ErrHostConnection(err.Error())
 	}
 
 	defer c.Close()
-	c.SetDeadline(time.Now().Add(time.Duration(QueryTimeout) * time.Second))
+	// interrupt the read in progress if ctx is cancelled
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
 
 	for {
+		// each page gets its own timeout, so that long lists aren't cut off
+		c.SetDeadline(time.Now().Add(masterQueryTimeout))
+		if ctx.Err() != nil {
+			break
+		}
 		s, err := queryMasterServer(c, addr, filter)
 		if err != nil {
 			// usually timeout - Valve throttles >30 UDP packets (>6930 servers) per min
@@ -62,13 +92,15 @@ func getServers(filter filters.Filter) ([]string, error) {
 		if retrieved >= maxHosts {
 			logger.LogSteamInfo("Max host limit of %d reached!", maxHosts)
 			logger.WriteDebug("Max host limit of %d reached!", maxHosts)
+			complete = true
 			break
 		}
 		logger.LogSteamInfo("%d hosts retrieved so far from master.", retrieved)
 		logger.WriteDebug("%d hosts retrieved so far from master.", retrieved)
-		for _, ip := range ips {
-			serverlist = append(serverlist, ip)
+		if len(ips) == 0 {
+			break
 		}
+		serverlist = append(serverlist, ips...)
 
 		if (serverlist[len(serverlist)-1]) != "0.0.0.0:0" {
 			logger.LogSteamInfo("More hosts need to be retrieved. Last IP was: %s",
@@ -79,16 +111,30 @@ func getServers(filter filters.Filter) ([]string, error) {
 		} else {
 			logger.LogSteamInfo("IP retrieval complete!")
 			logger.WriteDebug("IP retrieval complete!")
+			complete = true
 			break
 		}
 	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	// remove 0.0.0.0:0
 	if len(serverlist) != 0 {
 		if serverlist[len(serverlist)-1] == "0.0.0.0:0" {
 			serverlist = serverlist[:len(serverlist)-1]
 		}
 	}
-	return serverlist, nil
+	if complete {
+		clearMasterResume(filter.Game.Name)
+		return serverlist, nil
+	}
+	// keep the previous state if nothing more was received, so that it expires
+	if len(serverlist) > resumed {
+		setMasterResume(filter, addr, serverlist)
+	}
+	logger.LogSteamInfo("Master server stopped responding after %d %s hosts; the next query resumes after %s",
+		len(serverlist), filter.Game.Name, addr)
+	return serverlist, ErrMasterListPartial
 }
 
 func extractHosts(hbs []byte) ([]string, int, error) {
@@ -172,13 +218,24 @@ func queryMasterServer(conn net.Conn, startaddress string,
 
 // NewMasterQuery initiates a new Steam Master server query for a given filter,
 // returning a MasterQuery struct containing the hosts retrieved in the event of
-// success or an empty struct and an error in the event of failure.
+// success or an empty struct and an error in the event of failure. If the master
+// server stopped responding before the end of the list, the hosts received so
+// far are returned as a partial list.
 func NewMasterQuery(filter filters.Filter) (MasterQuery, error) {
-	sl, err := getServers(filter)
+	sl, partial, err := partialMasterList(getServers(context.Background(), filter))
 	if err != nil {
 		return MasterQuery{}, err
 	}
 	logger.LogSteamInfo("*** Retrieved %d %s servers.", len(sl), filter.Game.Name)
 
-	return MasterQuery{Servers: sl}, nil
+	return MasterQuery{Servers: sl, Partial: partial}, nil
+}
+
+// partialMasterList accepts the servers received before the master server
+// stopped responding as a partial list, unless there are none.
+func partialMasterList(sl []string, err error) ([]string, bool, error) {
+	if err == ErrMasterListPartial && len(sl) > 0 {
+		return sl, true, nil
+	}
+	return sl, false, err
 }
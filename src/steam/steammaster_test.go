@@ -1,8 +1,16 @@
 package steam
 
 import (
+	"bytes"
+	"context"
+	"net"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/syncore/a2sapi/src/steam/filters"
 )
 
 func TestExtractHosts(t *testing.T) {
@@ -57,3 +65,92 @@ func TestParseIP(t *testing.T) {
 		t.Fatalf("Expected IP: 45.55.168.160:27960, got: %s", parsed)
 	}
 }
+
+func TestGetServersResume(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen for master queries: %s", err)
+	}
+	defer pc.Close()
+	origHost, origTimeout := masterServerHost, masterQueryTimeout
+	defer func() { masterServerHost, masterQueryTimeout = origHost, origTimeout }()
+	masterServerHost = pc.LocalAddr().String()
+	masterQueryTimeout = 200 * time.Millisecond
+
+	pages := map[string][]byte{
+		"0.0.0.0:0": {0x0A, 0x00, 0x00, 0x01, 0x69, 0x87,
+			0x0A, 0x00, 0x00, 0x02, 0x69, 0x87},
+		"10.0.0.2:27015": {0x0A, 0x00, 0x00, 0x03, 0x69, 0x87,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+	}
+	var seeds []string
+	var mu sync.Mutex
+	go func() {
+		buf := make([]byte, maxPacketSize)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			// 0x31, region, seed, 0x00, filters
+			seed := string(buf[2 : 2+bytes.IndexByte(buf[2:n], 0x00)])
+			mu.Lock()
+			seeds = append(seeds, seed)
+			cutoff := len(seeds) == 2
+			mu.Unlock()
+			// cut off the first request for the second page
+			if cutoff {
+				continue
+			}
+			pc.WriteTo(append(append([]byte(nil), expectedMasterRespHeader...),
+				pages[seed]...), addr)
+		}
+	}()
+
+	filter := filters.NewFilter(filters.Game{Name: "ResumeTest"}, filters.SrAll,
+		nil)
+	defer clearMasterResume("ResumeTest")
+	servers, err := getServers(context.Background(), filter)
+	if err != ErrMasterListPartial {
+		t.Fatalf("Expected a partial list error, got: %v", err)
+	}
+	if !reflect.DeepEqual(servers, []string{"10.0.0.1:27015", "10.0.0.2:27015"}) {
+		t.Fatalf("Expected the first page of servers, got: %v", servers)
+	}
+	if r, ok := getMasterResume(filter); !ok || r.Seed != "10.0.0.2:27015" {
+		t.Fatalf("Expected the query to resume after 10.0.0.2:27015, got: %+v", r)
+	}
+
+	servers, err = getServers(context.Background(), filter)
+	if err != nil {
+		t.Fatalf("Unexpected error resuming the query: %s", err)
+	}
+	expected := []string{"10.0.0.1:27015", "10.0.0.2:27015", "10.0.0.3:27015"}
+	if !reflect.DeepEqual(servers, expected) {
+		t.Fatalf("Expected servers %v, got: %v", expected, servers)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(seeds, []string{"0.0.0.0:0", "10.0.0.2:27015",
+		"10.0.0.2:27015"}) {
+		t.Fatalf("Expected the second query to start from its seed, got: %v", seeds)
+	}
+	if _, ok := getMasterResume(filter); ok {
+		t.Fatalf("Expected the resume state to be cleared once the list is complete")
+	}
+}
+
+func TestPartialMasterList(t *testing.T) {
+	sl, partial, err := partialMasterList([]string{"10.0.0.1:27015"},
+		ErrMasterListPartial)
+	if err != nil || !partial || len(sl) != 1 {
+		t.Fatalf("Expected a partial list, got: %v %v %v", sl, partial, err)
+	}
+	if _, _, err := partialMasterList(nil, ErrMasterListPartial); err == nil {
+		t.Fatalf("Expected an error for an empty partial list")
+	}
+	if _, partial, err := partialMasterList([]string{"10.0.0.1:27015"},
+		nil); err != nil || partial {
+		t.Fatalf("Expected a complete list, got: %v %v", partial, err)
+	}
+}
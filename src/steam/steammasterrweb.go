@@ -7,13 +7,17 @@ package steam
 // If neccessary, the old method can still be used; for more information see steammaster.go.
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/syncore/a2sapi/src/config"
 	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/models"
 	"github.com/syncore/a2sapi/src/steam/filters"
 )
 
@@ -22,44 +26,111 @@ var steamWebAPIURL = func(webAPIKey, filter string, limit int) string {
 		webAPIKey, filter, limit)
 }
 
+// webServerInfo holds the server information that the Steam Web API returned
+// with each game's last server list, by game (lower case) and host. It is used
+// for the servers that don't respond to A2S_INFO in that retrieval.
+var webServerInfo = struct {
+	sync.Mutex
+	games map[string]map[string]models.SteamServerInfo
+}{
+	games: make(map[string]map[string]models.SteamServerInfo),
+}
+
+// webGameServer represents a server in the response returned from the Steam
+// Web API, which includes most of the server's A2S_INFO as reported to Valve.
+type webGameServer struct {
+	Addr       string `json:"addr"`
+	Gameport   int    `json:"gameport"`
+	Steamid    string `json:"steamid"`
+	Name       string `json:"name"`
+	Appid      int    `json:"appid"`
+	Gamedir    string `json:"gamedir"`
+	Version    string `json:"version"`
+	Product    string `json:"product"`
+	Region     int    `json:"region"`
+	Players    int    `json:"players"`
+	MaxPlayers int    `json:"max_players"`
+	Bots       int    `json:"bots"`
+	Map        string `json:"map"`
+	Secure     bool   `json:"secure"`
+	Dedicated  bool   `json:"dedicated"`
+	Os         string `json:"os"`
+	Gametype   string `json:"gametype"`
+}
+
 // webGameServerList repersents the response returned from the Steam Web API that includes the
-// server addresses (and some extra information that we are not interested in)
+// server addresses and information
 type webGameServerList struct {
 	Response struct {
-		Servers []struct {
-			Addr       string `json:"addr"`
-			Gameport   int    `json:"gameport"`
-			Steamid    string `json:"steamid"`
-			Name       string `json:"name"`
-			Appid      int    `json:"appid"`
-			Gamedir    string `json:"gamedir"`
-			Version    string `json:"version"`
-			Product    string `json:"product"`
-			Region     int    `json:"region"`
-			Players    int    `json:"players"`
-			MaxPlayers int    `json:"maxPlayers"`
-			Bots       int    `json:"bots"`
-			Map        string `json:"map"`
-			Secure     bool   `json:"secure"`
-			Dedicated  bool   `json:"dedicated"`
-			Os         string `json:"os"`
-			Gametype   string `json:"gametype"`
-		} `json:"servers"`
+		Servers []webGameServer `json:"servers"`
 	} `json:"response"`
 }
 
-func getServersWeb(filter filters.Filter) ([]string, error) {
+// info converts a server in the Steam Web API response to its A2S_INFO form.
+func (s webGameServer) info() models.SteamServerInfo {
+	info := models.SteamServerInfo{
+		Name:        s.Name,
+		Map:         s.Map,
+		Folder:      s.Gamedir,
+		Game:        s.Product,
+		ID:          int16(s.Appid),
+		Players:     int16(s.Players),
+		MaxPlayers:  int16(s.MaxPlayers),
+		Bots:        int16(s.Bots),
+		ServerType:  "listen",
+		Environment: s.Os,
+		Version:     s.Version,
+		ExtraData: models.SteamExtraData{
+			Port:     int16(s.Gameport),
+			Keywords: s.Gametype,
+			GameID:   uint64(s.Appid),
+		},
+	}
+	if s.Dedicated {
+		info.ServerType = "dedicated"
+	}
+	switch s.Os {
+	case "l":
+		info.Environment = "Linux"
+	case "w":
+		info.Environment = "Windows"
+	case "m", "o":
+		info.Environment = "Mac"
+	}
+	if s.Secure {
+		info.VAC = 1
+	}
+	if id, err := strconv.ParseUint(s.Steamid, 10, 64); err == nil {
+		info.ExtraData.SteamID = id
+	}
+	return info
+}
+
+func getServersWeb(ctx context.Context, filter filters.Filter) ([]string, error) {
+	key := config.Get().SteamConfig.SteamWebAPIKey
+	if key == "" || strings.EqualFold(key, "none") {
+		return nil, fmt.Errorf("no steamWebAPIKey specified for the Steam Web API server list of %s",
+			filter.Game.Name)
+	}
 	var fsl []string
 	for _, f := range filter.Filters {
 		fsl = append(fsl, string(f))
 	}
 	filterStr := strings.Join(fsl, "")
-	response, err := http.Get(steamWebAPIURL(config.Config.SteamConfig.SteamWebAPIKey, filterStr,
-		config.Config.SteamConfig.MaximumHostsToReceive))
+	req, err := http.NewRequest("GET", steamWebAPIURL(key, filterStr,
+		config.Get().SteamConfig.GameMaxHostsToReceive(filter.Game.Name)), nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := http.DefaultClient.Do(req.WithContext(ctx))
 	if err != nil {
 		return nil, err
 	}
 	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Steam Web API server list returned HTTP status %d",
+			response.StatusCode)
+	}
 	var webAPIResponseModel webGameServerList
 	var servers []string
 	apiResult := json.NewDecoder(response.Body)
@@ -67,17 +138,49 @@ func getServersWeb(filter filters.Filter) ([]string, error) {
 		logger.WriteDebug("Error decoding Steam Web API response: %s", err)
 		return nil, err
 	}
+	info := make(map[string]models.SteamServerInfo,
+		len(webAPIResponseModel.Response.Servers))
 	for _, server := range webAPIResponseModel.Response.Servers {
 		servers = append(servers, server.Addr)
+		info[server.Addr] = server.info()
 	}
+	webServerInfo.Lock()
+	webServerInfo.games[strings.ToLower(filter.Game.Name)] = info
+	webServerInfo.Unlock()
 	return servers, nil
 }
 
+// mergeWebServerInfo adds the server information returned by the Steam Web API
+// with the game's server list to the A2S results, where the servers' own
+// A2S_INFO responses are missing. The information is only used for the
+// retrieval that follows the list it was returned with.
+func mergeWebServerInfo(game filters.Game, data *a2sData) {
+	key := strings.ToLower(game.Name)
+	webServerInfo.Lock()
+	info := webServerInfo.games[key]
+	delete(webServerInfo.games, key)
+	webServerInfo.Unlock()
+	if len(info) == 0 || game.IgnoreInfo {
+		return
+	}
+	if data.Info == nil {
+		data.Info = make(map[string]models.SteamServerInfo)
+	}
+	for host, i := range info {
+		if _, ok := data.HostsGames[host]; !ok {
+			continue
+		}
+		if _, ok := data.Info[host]; !ok {
+			data.Info[host] = i
+		}
+	}
+}
+
 // NewMasterWebQuery initiates a new Steam "Master" server query using the Steam Web API for a
 // given filter, returning a MasterQuery struct containing the hosts retrieved in the event of
 // success or an empty struct and an error in the event of failure.
 func NewMasterWebQuery(filter filters.Filter) (MasterQuery, error) {
-	sl, err := getServersWeb(filter)
+	sl, err := getServersWeb(context.Background(), filter)
 	if err != nil {
 		return MasterQuery{}, err
 	}
@@ -0,0 +1,87 @@
+package steam
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/models"
+	"github.com/syncore/a2sapi/src/steam/filters"
+)
+
+func TestGetServersWeb(t *testing.T) {
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		if r.URL.Query().Get("key") != "testkey" {
+			t.Errorf("Expected the configured API key, got: %s", r.URL.RawQuery)
+		}
+		w.WriteHeader(status)
+		fmt.Fprint(w, `{"response":{"servers":[
+			{"addr":"10.0.0.1:27015","gameport":27960,"steamid":"90071996842377216",
+			"name":"Test server","appid":282440,"gamedir":"baseq3","version":"1069",
+			"product":"Quake Live","players":5,"max_players":16,"bots":1,"map":"campgrounds",
+			"secure":true,"dedicated":true,"os":"l","gametype":"ca,elo"},
+			{"addr":"10.0.0.2:27015","name":"Second","map":"bloodrun"}]}}`)
+	}))
+	defer srv.Close()
+	origURL := steamWebAPIURL
+	defer func() { steamWebAPIURL = origURL }()
+	steamWebAPIURL = func(webAPIKey, filter string, limit int) string {
+		return fmt.Sprintf("%s/?key=%s&filter=%s&limit=%d", srv.URL, webAPIKey,
+			filter, limit)
+	}
+	orig := config.Get().SteamConfig
+	defer func() { config.Get().SteamConfig = orig }()
+	config.Get().SteamConfig.SteamWebAPIKey = "testkey"
+
+	game := filters.Game{Name: "QuakeLive", MasterProvider: MasterProviderSteamWeb}
+	filter := filters.NewFilter(game, filters.SrAll, nil)
+	servers, err := getServersWeb(context.Background(), filter)
+	if err != nil {
+		t.Fatalf("Unexpected error getting servers: %s", err)
+	}
+	if !reflect.DeepEqual(servers, []string{"10.0.0.1:27015", "10.0.0.2:27015"}) {
+		t.Fatalf("Expected servers from the Web API, got: %v", servers)
+	}
+
+	// the Web API's information is only used for the hosts without A2S_INFO
+	data := a2sData{
+		HostsGames: map[string]filters.Game{"10.0.0.1:27015": game,
+			"10.0.0.2:27015": game},
+		Info: map[string]models.SteamServerInfo{"10.0.0.2:27015": {Name: "A2S"}},
+	}
+	mergeWebServerInfo(game, &data)
+	expected := models.SteamServerInfo{Name: "Test server", Map: "campgrounds",
+		Folder: "baseq3", Game: "Quake Live", ID: int16(282440 & 0xffff),
+		Players: 5, MaxPlayers: 16, Bots: 1, ServerType: "dedicated",
+		Environment: "Linux", VAC: 1, Version: "1069",
+		ExtraData: models.SteamExtraData{Port: 27960, SteamID: 90071996842377216,
+			Keywords: "ca,elo", GameID: 282440}}
+	if !reflect.DeepEqual(data.Info["10.0.0.1:27015"], expected) {
+		t.Fatalf("Expected info %+v, got: %+v", expected, data.Info["10.0.0.1:27015"])
+	}
+	if data.Info["10.0.0.2:27015"].Name != "A2S" {
+		t.Fatalf("Expected the A2S_INFO response to be kept, got: %+v",
+			data.Info["10.0.0.2:27015"])
+	}
+	// and only for the retrieval that follows the list
+	data = a2sData{HostsGames: data.HostsGames}
+	mergeWebServerInfo(game, &data)
+	if len(data.Info) != 0 {
+		t.Fatalf("Expected no info from an already used list, got: %+v", data.Info)
+	}
+
+	status = http.StatusForbidden
+	if _, err := getServersWeb(context.Background(), filter); err == nil {
+		t.Fatalf("Expected an error for a rejected API key")
+	}
+	config.Get().SteamConfig.SteamWebAPIKey = "none"
+	if _, err := getServersWeb(context.Background(), filter); err == nil {
+		t.Fatalf("Expected an error without an API key")
+	}
+}
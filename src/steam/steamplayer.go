@@ -4,9 +4,10 @@ package steam
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
+	"fmt"
 	"math"
-	"net"
 	"sync"
 	"time"
 
@@ -14,30 +15,27 @@ import (
 	"github.com/syncore/a2sapi/src/models"
 )
 
-func getPlayerInfo(host string, timeout int) ([]byte, error) {
-	conn, err := net.DialTimeout("udp", host, time.Duration(timeout)*time.Second)
+func getPlayerInfo(host string, timeout time.Duration) ([]byte, error) {
+	conn, err := dialServer(host, timeout)
 	if err != nil {
-		logger.LogSteamError(ErrHostConnection(err.Error()))
-		return nil, ErrHostConnection(err.Error())
+		return nil, err
 	}
-
 	defer conn.Close()
-	conn.SetDeadline(time.Now().Add(time.Duration(timeout-1) * time.Second))
 
 	_, err = conn.Write(playerChallengeReq)
 	if err != nil {
-		logger.LogSteamError(ErrDataTransmit(err.Error()))
+		logHostError(host, ErrDataTransmit(err.Error()))
 		return nil, ErrDataTransmit(err.Error())
 	}
 
 	challengeNumResp := make([]byte, maxPacketSize)
 	_, err = conn.Read(challengeNumResp)
 	if err != nil {
-		logger.LogSteamError(ErrDataTransmit(err.Error()))
+		logHostError(host, ErrDataTransmit(err.Error()))
 		return nil, ErrDataTransmit(err.Error())
 	}
 	if !bytes.HasPrefix(challengeNumResp, expectedPlayerRespHeader) {
-		logger.LogSteamError(ErrChallengeResponse)
+		logHostError(host, ErrChallengeResponse)
 		return nil, ErrChallengeResponse
 	}
 	challengeNum := bytes.TrimLeft(challengeNumResp, headerStr)
@@ -47,61 +45,86 @@ func getPlayerInfo(host string, timeout int) ([]byte, error) {
 
 	_, err = conn.Write(request)
 	if err != nil {
-		logger.LogSteamError(ErrDataTransmit(err.Error()))
+		logHostError(host, ErrDataTransmit(err.Error()))
 		return nil, ErrDataTransmit(err.Error())
 	}
 	var buf [maxPacketSize]byte
 	numread, err := conn.Read(buf[:maxPacketSize])
 	if err != nil {
-		logger.LogSteamError(ErrDataTransmit(err.Error()))
+		logHostError(host, ErrDataTransmit(err.Error()))
 		return nil, ErrDataTransmit(err.Error())
 	}
+	if bytes.HasPrefix(buf[:numread], multiPacketRespHeader) {
+		// handle multi-packet response (i.e. GoldSrc servers with many players)
+		pi, err := handleMultiPacketResponse(conn, buf[:numread])
+		if err != nil {
+			logHostError(host, ErrDataTransmit(err.Error()))
+			return nil, ErrDataTransmit(err.Error())
+		}
+		return pi, nil
+	}
 	pi := make([]byte, numread)
 	copy(pi, buf[:numread])
 
 	return pi, nil
 }
 
-func parsePlayerInfo(unparsed []byte) ([]models.SteamPlayerInfo, error) {
+func parsePlayerInfo(unparsed []byte, lenient bool) ([]models.SteamPlayerInfo,
+	[]parseWarning, error) {
 	if !bytes.HasPrefix(unparsed, expectedPlayerChunkHeader) {
 		logger.LogSteamError(ErrPacketHeader)
-		return nil, ErrPacketHeader
+		return nil, nil, ErrPacketHeader
 	}
 	unparsed = bytes.TrimLeft(unparsed, headerStr)
-	numplayers := int(unparsed[1])
+	// index 0 = '44' | 1 = 'numplayers' byte | then for each player: index byte,
+	// name (string), score (long, 4 bytes), duration (float, 4 bytes)
+	r := newPacketReader(unparsed[1:], lenient)
+	numplayers := int(r.readByte("players.count"))
+	if r.err != nil {
+		logger.LogSteamError(r.err)
+		return nil, nil, r.err
+	}
 
 	if numplayers == 0 {
-		return nil, ErrNoPlayers
+		return nil, r.warnings, ErrNoPlayers
 	}
 
 	players := []models.SteamPlayerInfo{}
-
-	// index 0 = '44' | 1 = 'numplayers' byte | 2 = player 1 separator byte '00'
-	// | 3 = start of player 1 name; additional player start indexes are player separator + 1
-	startidx := 3
-	var b []byte
 	for i := 0; i < numplayers; i++ {
-		if i == 0 {
-			b = unparsed[startidx:]
-		} else {
-			b = b[startidx+1:]
+		if r.truncated {
+			break
+		}
+		r.readByte("players.index")
+		name := r.readString("players.name")
+		score := r.readUint32("players.score")
+		duration := r.next("players.duration", 4)
+		if r.err != nil {
+			logger.LogSteamError(r.err)
+			return nil, nil, r.err
+		}
+		if duration == nil {
+			// this player's entry was cut off; keep the complete entries
+			break
 		}
-		nul := bytes.IndexByte(b, 0x00)
-		name := b[:nul]              // string (variable length)
-		score := b[nul+1 : nul+5]    // long (4 bytes)
-		duration := b[nul+5 : nul+9] // float (4 bytes)
-		startidx = nul + 9
 
 		seconds, timeformatted := getDuration(duration)
 		players = append(players, models.SteamPlayerInfo{
-			Name:              string(name),
-			Score:             int32(binary.LittleEndian.Uint32(score)),
+			Name:              name,
+			Score:             int32(score),
 			TimeConnectedSecs: seconds,
 			TimeConnectedTot:  timeformatted,
+			TimeConnectedRaw:  int64(seconds),
+			TimeConnectedISO:  formatISO8601Duration(int64(seconds)),
+			TimeConnectedLong: formatLongDuration(int64(seconds)),
 		})
 	}
+	if len(players) < numplayers {
+		r.fail("players", fmt.Sprintf("expected %d players, got %d", numplayers,
+			len(players)))
+	}
+	r.checkTrailing("players")
 
-	return players, nil
+	return players, r.warnings, nil
 }
 
 func getDuration(bytes []byte) (float32, string) {
@@ -111,54 +134,105 @@ func getDuration(bytes []byte) (float32, string) {
 	return f, s.String()
 }
 
+// splitDuration splits a number of seconds into days, hours, minutes, and seconds.
+func splitDuration(secs int64) (days, hours, minutes, seconds int64) {
+	if secs < 0 {
+		secs = 0
+	}
+	return secs / 86400, (secs % 86400) / 3600, (secs % 3600) / 60, secs % 60
+}
+
+// formatISO8601Duration formats a number of seconds as an ISO 8601 duration,
+// i.e: 93784 seconds is P1DT2H3M4S.
+func formatISO8601Duration(secs int64) string {
+	d, h, m, s := splitDuration(secs)
+	var b bytes.Buffer
+	b.WriteString("P")
+	if d > 0 {
+		fmt.Fprintf(&b, "%dD", d)
+	}
+	if h == 0 && m == 0 && s == 0 && d > 0 {
+		return b.String()
+	}
+	b.WriteString("T")
+	if h > 0 {
+		fmt.Fprintf(&b, "%dH", h)
+	}
+	if m > 0 {
+		fmt.Fprintf(&b, "%dM", m)
+	}
+	if s > 0 || (h == 0 && m == 0) {
+		fmt.Fprintf(&b, "%dS", s)
+	}
+	return b.String()
+}
+
+// formatLongDuration formats a number of seconds in a human-readable form that
+// includes days for players on marathon servers, i.e: 93784 seconds is 1d 2h 3m 4s.
+func formatLongDuration(secs int64) string {
+	d, h, m, s := splitDuration(secs)
+	switch {
+	case d > 0:
+		return fmt.Sprintf("%dd %dh %dm %ds", d, h, m, s)
+	case h > 0:
+		return fmt.Sprintf("%dh %dm %ds", h, m, s)
+	case m > 0:
+		return fmt.Sprintf("%dm %ds", m, s)
+	default:
+		return fmt.Sprintf("%ds", s)
+	}
+}
+
 // RetryFailedPlayersReq retries a failed A2S_PLAYER request for a specified group of
 // failed hosts for a total of retrycount times, returning a host to A2S_PLAYER
-// mapping for any hosts that were successfully retried.
-func RetryFailedPlayersReq(failed []string,
-	retrycount int) map[string][]models.SteamPlayerInfo {
+// mapping for any hosts that were successfully retried. Retries count
+// towards the hosts' query budget and stop once ctx is cancelled.
+func RetryFailedPlayersReq(ctx context.Context, failed []string, retrycount int,
+	budget *queryBudget) map[string][]models.SteamPlayerInfo {
 
 	m := make(map[string][]models.SteamPlayerInfo)
 	var f []string
-	var wg sync.WaitGroup
 	var mut sync.Mutex
-	for i := 0; i < retrycount; i++ {
+	for i := 0; i < retrycount && ctx.Err() == nil; i++ {
 		if i == 0 {
 			f = failed
 		}
-		wg.Add(len(f))
-		for _, host := range f {
-			go func(h string) {
-				defer wg.Done()
-				r, err := GetPlayersForServer(h, QueryTimeout)
-				if err != nil {
-					if err != ErrNoPlayers {
-						return
-					}
-				}
-				mut.Lock()
-				m[h] = r
-				f = removeFailedHost(f, h)
-				mut.Unlock()
-			}(host)
-		}
-		wg.Wait()
+		// f shrinks as hosts succeed, so the workers are given a copy
+		forEachHost(append([]string(nil), f...), func(h string) {
+			if i >= retryCount(h, retrycount) {
+				return
+			}
+			a2sRetriesMetric.Inc(reqTypePlayers)
+			r, err := limitedPlayerQuery(ctx, h, budget)
+			if err != nil && err != ErrNoPlayers {
+				return
+			}
+			mut.Lock()
+			m[h] = r
+			f = removeFailedHost(f, h)
+			mut.Unlock()
+		})
 	}
 	return m
 }
 
-// GetPlayersForServer requests A2S_PLAYER info for a given host within timeout seconds.
-func GetPlayersForServer(host string, timeout int) ([]models.SteamPlayerInfo, error) {
+// GetPlayersForServer requests A2S_PLAYER info for a given host within timeout.
+func GetPlayersForServer(host string,
+	timeout time.Duration) ([]models.SteamPlayerInfo, error) {
 	// Caller will log. Return err instead of wrapped logger.LogSteamError so as not
 	// to interfere with custom error types that need to be analyzed when
 	// determining if retry needs to be done.
-	pi, err := getPlayerInfo(host, timeout)
+	pi, err := fetchRaw(rawPlayers, host, func() ([]byte, error) {
+		return getPlayerInfo(host, timeout)
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	players, err := parsePlayerInfo(pi)
+	players, warnings, err := parsePlayerInfo(pi, useLenientParsing())
 	if err != nil {
 		return nil, err
 	}
+	recordParseWarnings(host, "players", warnings)
 	return players, nil
 }
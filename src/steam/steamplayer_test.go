@@ -21,7 +21,7 @@ func TestParsePlayerInfo(t *testing.T) {
 		0x62, 0x65, 0x72, 0x4E, 0x69, 0x6E, 0x65, 0x00, 0x00, 0x00, 0x00, 0x00,
 		0x11, 0x91, 0xF7, 0x42}
 
-	pinfo, err := parsePlayerInfo(data)
+	pinfo, _, err := parsePlayerInfo(data, false)
 	if err != nil {
 		t.Fatalf("Unexpected error when parsing players")
 	}
@@ -63,3 +63,29 @@ func TestGetDuration(t *testing.T) {
 		t.Fatalf("Expected duration string to be 2m3s, got: %s", durstring)
 	}
 }
+
+func TestFormatDurations(t *testing.T) {
+	tests := []struct {
+		secs int64
+		iso  string
+		long string
+	}{
+		{0, "PT0S", "0s"},
+		{59, "PT59S", "59s"},
+		{123, "PT2M3S", "2m 3s"},
+		{4678, "PT1H17M58S", "1h 17m 58s"},
+		{7200, "PT2H", "2h 0m 0s"},
+		{86400, "P1D", "1d 0h 0m 0s"},
+		{93784, "P1DT2H3M4S", "1d 2h 3m 4s"},
+	}
+	for _, tt := range tests {
+		if iso := formatISO8601Duration(tt.secs); iso != tt.iso {
+			t.Fatalf("Expected ISO 8601 duration for %d secs to be %s, got: %s",
+				tt.secs, tt.iso, iso)
+		}
+		if long := formatLongDuration(tt.secs); long != tt.long {
+			t.Fatalf("Expected long duration for %d secs to be %s, got: %s",
+				tt.secs, tt.long, long)
+		}
+	}
+}
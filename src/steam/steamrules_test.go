@@ -74,7 +74,7 @@ func TestParseRuleInfo(t *testing.T) {
 		0x37, 0x20, 0x32, 0x30, 0x31, 0x35, 0x20, 0x31, 0x35, 0x3A, 0x33, 0x36,
 		0x3A, 0x34, 0x39, 0x00}
 
-	rules, err := parseRuleInfo(data)
+	rules, _, err := parseRuleInfo(data, false)
 	if err != nil {
 		t.Fatalf("Unexpected error when parsing rule info")
 	}
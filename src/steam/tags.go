@@ -0,0 +1,73 @@
+package steam
+
+// tags.go - Building of servers' unified tag list from the A2S_INFO keywords
+// and, for games that truncate their keywords, the sv_tags rule.
+
+import (
+	"strings"
+
+	"github.com/syncore/a2sapi/src/models"
+	"github.com/syncore/a2sapi/src/steam/filters"
+)
+
+// maxKeywordsLen is the length at which games with tag overflow truncate the
+// A2S_INFO keywords; a keywords string of this length likely ends with a
+// partial tag.
+const maxKeywordsLen = 127
+
+// tagOverflowGames are the games whose A2S_INFO keywords only hold as many tags
+// as fit in maxKeywordsLen, with the full set of tags in the sv_tags rule. (CS:GO
+// only sends rules if ignoreRules is set to false in the game list.)
+var tagOverflowGames = map[string]bool{
+	strings.ToLower(filters.GameCsGo.Name): true,
+}
+
+// splitTags splits a list of tags, removing spaces and empty tags.
+func splitTags(s, sep string) []string {
+	var tags []string
+	for _, t := range strings.Split(s, sep) {
+		if t = strings.TrimSpace(t); t != "" {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+// getServerTags returns the server's tags from its keywords and, for games with
+// tag overflow, its sv_tags rule, without duplicates.
+func getServerTags(game filters.Game, server models.APIServer) []string {
+	keywords := server.Info.ExtraData.Keywords
+	sep := ","
+	// Reflex versions before 0.49 use the pipe character to separate keywords
+	if strings.EqualFold(game.Name, filters.GameReflex.Name) &&
+		!strings.Contains(keywords, sep) {
+		sep = "|"
+	}
+	tags := splitTags(keywords, sep)
+	if tagOverflowGames[strings.ToLower(game.Name)] {
+		var svTags string
+		for k, v := range server.Rules {
+			if strings.EqualFold(k, "sv_tags") {
+				svTags = v
+			}
+		}
+		if svTags != "" {
+			if len(keywords) >= maxKeywordsLen && len(tags) > 0 &&
+				!strings.HasSuffix(keywords, sep) {
+				// the last keyword may have been cut off; sv_tags has it in full
+				tags = tags[:len(tags)-1]
+			}
+			tags = append(tags, splitTags(svTags, ",")...)
+		}
+	}
+	seen := make(map[string]bool, len(tags))
+	unique := make([]string, 0, len(tags))
+	for _, t := range tags {
+		if seen[strings.ToLower(t)] {
+			continue
+		}
+		seen[strings.ToLower(t)] = true
+		unique = append(unique, t)
+	}
+	return unique
+}
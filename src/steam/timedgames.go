@@ -0,0 +1,120 @@
+package steam
+
+// timedgames.go - Runs the timed retrieval of each of the configured games, and
+// starts and stops games' retrievals when the configured games change.
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/steam/filters"
+)
+
+// runningRetrieval is a game's running timed retrieval, along with its master
+// server filter (see masterFilterKey).
+type runningRetrieval struct {
+	cancel context.CancelFunc
+	filter string
+}
+
+// timedQueryFilter returns the master server filter for a game's timed query,
+// including the filter configured for the game, if any.
+func timedQueryFilter(game filters.Game) filters.Filter {
+	var sf []filters.SrvFilter
+	if f := config.Get().SteamConfig.GameMasterFilter(game.Name); f != "" {
+		sf = append(sf, filters.SrvFilter(f))
+	}
+	return filters.NewFilter(game, filters.SrAll, sf)
+}
+
+// staggeredDelay returns the initial delay, in seconds, of the i-th game whose
+// retrieval is started at once, so that their retrievals don't all start at the
+// same time.
+func staggeredDelay(i int) int {
+	return 7 + (i * 15)
+}
+
+// RunTimedRetrievals runs the timed retrieval of each of the games (see
+// StartMasterRetrieval) until ctx is cancelled. When the configuration is
+// reloaded, the retrievals of the games that were added to it are started, those
+// of the games that were removed are stopped (their last lists are still
+// served), and those whose master server filter changed are restarted; a
+// retrieval that is stopped or restarted while in progress is cancelled. It
+// returns once the requests in flight have finished.
+func RunTimedRetrievals(ctx context.Context, games []filters.Game) {
+	var wg sync.WaitGroup
+	running := make(map[string]runningRetrieval, len(games))
+	start := func(game filters.Game, initialDelay int) {
+		filter := timedQueryFilter(game)
+		gctx, cancel := context.WithCancel(ctx)
+		running[strings.ToLower(game.Name)] = runningRetrieval{cancel: cancel,
+			filter: masterFilterKey(filter)}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer cancel()
+			StartMasterRetrieval(gctx, filter, initialDelay)
+		}()
+	}
+	for i, game := range games {
+		start(game, staggeredDelay(i))
+	}
+	for {
+		reloaded := configReloaded()
+		select {
+		case <-reloaded:
+			updateTimedRetrievals(running, start)
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		}
+	}
+}
+
+// updateTimedRetrievals stops the running retrievals of the games that are no
+// longer configured for the timed retrieval, or whose master server filter
+// changed, and starts those of the configured games that aren't running.
+func updateTimedRetrievals(running map[string]runningRetrieval,
+	start func(game filters.Game, initialDelay int)) {
+	wanted := make(map[string]filters.Game)
+	var order []string
+	for _, name := range config.Get().SteamConfig.TimedQueryGames() {
+		game := filters.GetGameByName(name)
+		if game == filters.GameUnspecified {
+			logger.LogAppErrorf("Invalid game '%s' specified for automatic timed query; ignoring it",
+				name)
+			continue
+		}
+		key := strings.ToLower(game.Name)
+		if _, ok := wanted[key]; !ok {
+			wanted[key] = game
+			order = append(order, key)
+		}
+	}
+	for key, r := range running {
+		game, ok := wanted[key]
+		if ok && masterFilterKey(timedQueryFilter(game)) == r.filter {
+			continue
+		}
+		r.cancel()
+		delete(running, key)
+		if ok {
+			logger.LogAppInfo("Restarting timed retrieval of %s servers with its new master server filter",
+				game.Name)
+		} else {
+			logger.LogAppInfo("Stopped timed retrieval of %s servers", key)
+		}
+	}
+	n := 0
+	for _, key := range order {
+		if _, ok := running[key]; ok {
+			continue
+		}
+		logger.LogAppInfo("Starting timed retrieval of %s servers", wanted[key].Name)
+		start(wanted[key], staggeredDelay(n))
+		n++
+	}
+}
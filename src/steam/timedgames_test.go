@@ -0,0 +1,61 @@
+package steam
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/steam/filters"
+)
+
+func TestUpdateTimedRetrievals(t *testing.T) {
+	orig := config.Get().SteamConfig
+	defer func() { config.Get().SteamConfig = orig }()
+	config.Get().SteamConfig.AutoQueryGame = "QuakeLive"
+	config.Get().SteamConfig.AdditionalAutoQueryGames = []string{"Reflex",
+		"NoSuchGame"}
+	config.Get().SteamConfig.TimedQueryGameSettings = map[string]config.TimedQueryGame{}
+
+	var started []string
+	stopped := make(map[string]bool)
+	running := make(map[string]runningRetrieval)
+	start := func(game filters.Game, initialDelay int) {
+		if initialDelay != staggeredDelay(len(started)) {
+			t.Fatalf("Expected %s's start to be staggered, got a delay of %d",
+				game.Name, initialDelay)
+		}
+		started = append(started, game.Name)
+		key := strings.ToLower(game.Name)
+		running[key] = runningRetrieval{cancel: func() { stopped[key] = true },
+			filter: masterFilterKey(timedQueryFilter(game))}
+	}
+	// QuakeLive is already running, and Rust is no longer configured
+	start(filters.GameQuakeLive, staggeredDelay(0))
+	start(filters.GameRust, staggeredDelay(1))
+	started = nil
+
+	updateTimedRetrievals(running, start)
+	if !reflect.DeepEqual(started, []string{"Reflex"}) {
+		t.Fatalf("Expected only the added game to be started, got: %v", started)
+	}
+	if !reflect.DeepEqual(stopped, map[string]bool{"rust": true}) {
+		t.Fatalf("Expected only the removed game to be stopped, got: %v", stopped)
+	}
+	if len(running) != 2 {
+		t.Fatalf("Expected 2 running retrievals, got: %v", running)
+	}
+
+	// a changed master server filter restarts the game's retrieval
+	started = nil
+	config.Get().SteamConfig.TimedQueryGameSettings = map[string]config.TimedQueryGame{
+		"QuakeLive": {MasterFilter: `\dedicated\1`}}
+	updateTimedRetrievals(running, start)
+	if !reflect.DeepEqual(started, []string{"QuakeLive"}) || !stopped["quakelive"] {
+		t.Fatalf("Expected the game with the new filter to be restarted, got: %v",
+			started)
+	}
+	if stopped["reflex"] {
+		t.Fatalf("Expected the unchanged game to keep running")
+	}
+}
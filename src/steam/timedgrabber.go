@@ -3,8 +3,10 @@ package steam
 // timedgrabber.go - Timed retrieval of servers from the Steam Master server.
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/syncore/a2sapi/src/config"
@@ -15,26 +17,111 @@ import (
 	"github.com/syncore/a2sapi/src/util"
 )
 
-func retrieve(filter filters.Filter) (*models.APIServerList, error) {
-	var mq MasterQuery
-	var err error
-	if config.Config.SteamConfig.UseWebServerList {
-		mq, err = NewMasterWebQuery(filter)
-	} else {
-		mq, err = NewMasterQuery(filter)
-	}
-
+// retrieve retrieves the servers for the filter from the master server, queries
+// them, and writes the resulting list to the enabled outputs. If ctx is
+// cancelled, no further queries are sent and ctx's error is returned.
+func retrieve(ctx context.Context, filter filters.Filter) (*models.APIServerList,
+	error) {
+	report := cycleReport{Game: filter.Game.Name, CycleID: util.NewUUID(),
+		Started: time.Now(), startMem: readMemSample()}
+	logger.LogSteamInfo("Starting %s retrieval cycle %s", filter.Game.Name,
+		report.CycleID)
+	mq, err := NewProviderMasterQuery(ctx, filter)
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		return nil, logger.LogSteamErrorf("Master server error: %s", err)
 	}
+	if extra := getSupplementalHosts(filter.Game.Name); len(extra) > 0 {
+		n := len(mq.Servers)
+		mq.Servers = mergeHostLists(mq.Servers, extra)
+		logger.LogSteamInfo("Added %d %s servers from supplemental host lists.",
+			len(mq.Servers)-n, filter.Game.Name)
+	}
 
 	if filter.Game.IgnoreInfo && filter.Game.IgnorePlayers && filter.Game.IgnoreRules {
 		return nil, logger.LogAppErrorf("Cannot ignore all three AS2 requests!")
 	}
 
+	hosts := mq.Servers
+	var carried []models.APIServer
+	if config.Get().SteamConfig.AdaptiveQueryInterval {
+		hosts, carried = scheduleHosts(filter.Game.Name, mq.Servers, report.Started)
+	}
+
+	recorded := config.Get().DebugConfig.RecordRawCycles &&
+		startRecording(filter.Game.Name, hosts)
+	serverlist, err := queryServerList(ctx, filter, hosts)
+	latencies.prune(report.Started.Add(-latencyMaxAge))
+	if recorded {
+		if rerr := stopRecording(); rerr != nil {
+			logger.LogAppError(rerr)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	serverlist.CycleID = report.CycleID
+	if config.Get().SteamConfig.AdaptiveQueryInterval {
+		learnCadences(filter.Game.Name, serverlist.Servers, report.Started)
+		serverlist.Servers = append(serverlist.Servers, carried...)
+		serverlist.ServerCount = len(serverlist.Servers)
+	}
+	if mq.Partial {
+		// the servers after the point where the master server cut off the list
+		// aren't offline, so they aren't tracked until the list is complete
+		serverlist.PartialMasterList = true
+	} else {
+		serverlist.OfflineServers = trackServerHealth(filter.Game.Name, serverlist)
+	}
+	logger.LogSteamInfo("A2S concurrency limit at end of %s retrieval: %d",
+		filter.Game.Name, getQueryLimiter().currentLimit())
+	report.Servers = len(serverlist.Servers)
+	if config.Get().WebConfig.EnableServerClaims {
+		processClaims(filter.Game.Name, serverlist)
+	}
+	var mapChanges []models.DbMapChange
+	if config.Get().SteamConfig.DetectMapChanges ||
+		webhooksWant(filter.Game.Name, models.ServerEventMapChange) {
+		mapChanges = detectMapChanges(filter.Game.Name, serverlist)
+	}
+	if config.Get().SteamConfig.DetectMapChanges {
+		recordMapChanges(filter.Game.Name, mapChanges)
+	}
+	var events []models.DbServerEvent
+	if config.Get().WebConfig.EnableEventFeed ||
+		webhooksWant(filter.Game.Name, models.ServerEventOnline) ||
+		webhooksWant(filter.Game.Name, models.ServerEventOffline) {
+		events = serverEvents(filter.Game.Name, serverlist, time.Now().Unix())
+	}
+	if config.Get().WebConfig.EnableEventFeed {
+		recordServerEvents(filter.Game.Name, events)
+	}
+	sendWebhooks(filter.Game.Name, serverlist, events, mapChanges)
+	if config.Get().SteamConfig.RecordPlayerHistory {
+		recordPlayerHistory(filter.Game.Name, serverlist)
+	}
+	finishCycle(report)
+	writeOutputs(filter.Game.Name, serverlist)
+
+	if config.Get().DebugConfig.EnableServerDump {
+		if err := dumpServersToDisk(filter.Game.Name, serverlist); err != nil {
+			logger.LogAppError(err)
+		}
+	}
+
+	return serverlist, nil
+}
+
+// queryServerList performs the A2S queries needed for the filter's game on the
+// given servers and builds the resulting server list. If ctx is cancelled, the
+// requests in flight are finished but the partial list is discarded.
+func queryServerList(ctx context.Context, filter filters.Filter,
+	servers []string) (*models.APIServerList, error) {
 	data := a2sData{}
-	hg := make(map[string]filters.Game, len(mq.Servers))
-	for _, h := range mq.Servers {
+	hg := make(map[string]filters.Game, len(servers))
+	for _, h := range servers {
 		hg[h] = filter.Game
 	}
 	data.HostsGames = hg
@@ -45,27 +132,28 @@ func retrieve(filter filters.Filter) (*models.APIServerList, error) {
 	// 2. players (request chal #, recv chal #, req players, recv players)
 	// 3. info: just request info & receive info
 	// Note: some servers (i.e. new beta games) don't have all 3 of AS2_RULES/PLAYER/INFO
+	// Each host's requests share one time budget across all three.
+	budget := newQueryBudget()
 	if !filter.Game.IgnoreRules {
-		data.Rules = batchRuleQuery(mq.Servers)
+		data.Rules = batchRuleQuery(ctx, servers, sourceMaster, budget)
 	}
 	if !filter.Game.IgnorePlayers {
-		data.Players = batchPlayerQuery(mq.Servers)
+		data.Players = batchPlayerQuery(ctx, servers, sourceMaster, budget)
 	}
 	if !filter.Game.IgnoreInfo {
-		data.Info = batchInfoQuery(mq.Servers)
+		data.Info = batchInfoQuery(ctx, servers, sourceMaster, budget)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
+	pushed := mergeIngested(filter.Game, &data)
+	mergeWebServerInfo(filter.Game, &data)
 
-	serverlist, err := buildServerList(data, true)
+	serverlist, err := buildServerList(data, serverDBSync)
 	if err != nil {
 		return nil, logger.LogAppError(err)
 	}
-
-	if config.Config.DebugConfig.EnableServerDump {
-		if err := dumpServersToDisk(filter.Game.Name, serverlist); err != nil {
-			logger.LogAppError(err)
-		}
-	}
-
+	markPushed(serverlist, pushed)
 	return serverlist, nil
 }
 
@@ -90,47 +178,148 @@ func dumpServersToDisk(gamename string, sl *models.APIServerList) error {
 	return nil
 }
 
+// RetrieveOnce performs a single retrieval of each of the games' servers from
+// the master server, publishing each list and writing it to the enabled outputs,
+// and waits for any outputs in progress to finish. It is used instead of
+// StartMasterRetrieval for one-off runs (i.e. from cron jobs). It returns the
+// first error, after all of the games have been retrieved, or ctx's error if it
+// is cancelled first.
+func RetrieveOnce(ctx context.Context, games []filters.Game) error {
+	var firstErr error
+	for _, game := range games {
+		if ctx.Err() != nil {
+			break
+		}
+		sl, err := retrieve(ctx, filters.NewFilter(game, filters.SrAll, nil))
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		models.SetGameList(game.Name, sl)
+	}
+	waitForOutputs()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return firstErr
+}
+
+// timedRetrieval performs a retrieval, using the game's current definition, and
+// publishes the resulting list. A retrieval that is cancelled by ctx keeps the
+// previous list, so that it is the one that is saved on shutdown.
+func timedRetrieval(ctx context.Context, filter filters.Filter) {
+	filter.Game = currentGame(filter.Game)
+	sl, err := retrieve(ctx, filter)
+	if err != nil {
+		if ctx.Err() != nil {
+			logger.LogAppInfo("%s retrieval cancelled by shutdown; keeping the previous list",
+				filter.Game.Name)
+			return
+		}
+		logger.LogAppErrorf("Error when performing timed master retrieval: %s", err)
+	}
+	models.SetGameList(filter.Game.Name, sl)
+}
+
+// timeBetweenQueries returns the game's current time between retrievals, which
+// changes when the configuration is reloaded.
+func timeBetweenQueries(game string) int {
+	return config.Get().SteamConfig.GameTimeBetweenQueries(game)
+}
+
 // StartMasterRetrieval starts a timed retrieval of servers specified by a given
 // filter from the Steam Master server after an initial delay of initialDelay
-// seconds. It retrieves the list every timeBetweenQueries seconds thereafter.
-// A bool can be sent to the stop channel to cancel all timed retrievals.
-func StartMasterRetrieval(stop chan bool, filter filters.Filter,
-	initialDelay int, timeBetweenQueries int) {
-	retrticker := time.NewTicker(time.Duration(timeBetweenQueries) * time.Second)
+// seconds. It retrieves the list every time between queries configured for the
+// game thereafter; a change of that time by a reload of the configuration takes
+// effect from the next retrieval.
+// Unless there is already a list for the game, a warm-up retrieval of a reduced
+// number of servers is made during the initial delay.
+// Cancelling ctx stops the timed retrievals: no new A2S requests are sent, and
+// StartMasterRetrieval returns once the requests in flight have finished.
+func StartMasterRetrieval(ctx context.Context, filter filters.Filter,
+	initialDelay int) {
+	interval := timeBetweenQueries(filter.Game.Name)
+	retrtimer := time.NewTimer(time.Duration(interval) * time.Second)
+	defer retrtimer.Stop()
+	// when the last retrieval was scheduled, which the next one follows by the
+	// current interval
+	last := time.Now()
+	updateInterval := func() bool {
+		t := timeBetweenQueries(filter.Game.Name)
+		if t == interval {
+			return false
+		}
+		logger.LogAppInfo("Will retrieve %s servers every %d secs instead of every %d secs.",
+			filter.Game.Name, t, interval)
+		interval = t
+		return true
+	}
 
 	logger.WriteDebug(
-		"Waiting %d seconds before grabbing %s servers. Will retrieve servers every %d secs afterwards.", initialDelay, filter.Game.Name, timeBetweenQueries)
+		"Waiting %d seconds before grabbing %s servers. Will retrieve servers every %d secs afterwards.", initialDelay, filter.Game.Name, interval)
 
 	logger.LogAppInfo(
-		"Waiting %d seconds before grabbing %s servers from master. Will retrieve every %d secs afterwards.", initialDelay, filter.Game.Name, timeBetweenQueries)
+		"Waiting %d seconds before grabbing %s servers from master. Will retrieve every %d secs afterwards.", initialDelay, filter.Game.Name, interval)
 
 	firstretrieval := time.NewTimer(time.Duration(initialDelay) * time.Second)
-	<-firstretrieval.C
+	defer firstretrieval.Stop()
+	if n := config.Get().SteamConfig.WarmUpHosts; n > 0 &&
+		models.GetGameList(filter.Game.Name) == nil {
+		sl, err := warmUp(ctx, filter, n)
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.LogAppErrorf("Error when performing warm-up retrieval: %s", err)
+			}
+		} else if models.GetGameList(filter.Game.Name) == nil {
+			models.SetGameList(filter.Game.Name, sl)
+		}
+	}
+	select {
+	case <-firstretrieval.C:
+	case <-ctx.Done():
+		return
+	}
 	logger.WriteDebug("Starting first retrieval of %s servers from master.",
 		filter.Game.Name)
-	sl, err := retrieve(filter)
-	if err != nil {
-		logger.LogAppErrorf("Error when performing timed master retrieval: %s", err)
-	}
-	models.MasterList = sl
+	timedRetrieval(ctx, filter)
 
+	// retrievals that are still in progress when ctx is cancelled
+	var running sync.WaitGroup
+	defer running.Wait()
 	for {
+		reloaded := configReloaded()
 		select {
-		case <-retrticker.C:
+		case <-reloaded:
+			if updateInterval() {
+				// the next retrieval follows the last one by the new interval
+				if !retrtimer.Stop() {
+					select {
+					case <-retrtimer.C:
+					default:
+					}
+				}
+				wait := time.Until(last.Add(time.Duration(interval) * time.Second))
+				if wait < 0 {
+					wait = 0
+				}
+				retrtimer.Reset(wait)
+			}
+		case <-retrtimer.C:
+			updateInterval()
+			last = time.Now()
+			retrtimer.Reset(time.Duration(interval) * time.Second)
+			running.Add(1)
 			go func(filters.Filter) {
+				defer running.Done()
 				logger.WriteDebug("%s: Starting %s master server query", time.Now().Format(
 					"Mon Jan 2 15:04:05 2006 EST"), filter.Game.Name)
 				logger.LogAppInfo("%s: Starting %s master server query", time.Now().Format(
 					"Mon Jan 2 15:04:05 2006 EST"), filter.Game.Name)
-				sl, err := retrieve(filter)
-				if err != nil {
-					logger.LogAppErrorf("Error when performing timed master retrieval: %s",
-						err)
-				}
-				models.MasterList = sl
+				timedRetrieval(ctx, filter)
 			}(filter)
-		case <-stop:
-			retrticker.Stop()
+		case <-ctx.Done():
 			return
 		}
 	}
@@ -0,0 +1,41 @@
+package steam
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/steam/filters"
+)
+
+func TestStartMasterRetrievalCancelled(t *testing.T) {
+	prev := config.Get().SteamConfig.WarmUpHosts
+	defer func() { config.Get().SteamConfig.WarmUpHosts = prev }()
+	config.Get().SteamConfig.WarmUpHosts = 0
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		StartMasterRetrieval(ctx, filters.NewFilter(filters.Game{Name: "CancelGame"},
+			filters.SrAll, nil), 60)
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected timed retrieval to stop when cancelled")
+	}
+}
+
+func TestRetrieveOnceCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	game := filters.Game{Name: "CancelGame", MasterProvider: MasterProviderHTTP,
+		MasterAddress: "http://127.0.0.1:1/servers"}
+	if err := RetrieveOnce(ctx, []filters.Game{game}); err != context.Canceled {
+		t.Fatalf("Expected context canceled error, got: %v", err)
+	}
+}
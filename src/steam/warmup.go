@@ -0,0 +1,80 @@
+package steam
+
+// warmup.go - Quick, reduced retrieval of servers at startup, so that the API
+// has usable data before the first full retrieval completes.
+
+import (
+	"context"
+
+	"github.com/syncore/a2sapi/src/db"
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/models"
+	"github.com/syncore/a2sapi/src/steam/filters"
+)
+
+// warmUp retrieves the servers of the filter's game from the master server and
+// queries at most n of them. Servers that are already in the server database
+// are preferred, since they are more likely to respond. Unlike a full retrieval,
+// the warm-up does not update server health, claims, or outputs.
+func warmUp(ctx context.Context, filter filters.Filter, n int) (*models.APIServerList,
+	error) {
+	mq, err := NewProviderMasterQuery(ctx, filter)
+	if err != nil {
+		return nil, logger.LogSteamErrorf("Master server error: %s", err)
+	}
+	hosts := mq.Servers
+	if len(hosts) > n {
+		hosts = selectWarmUpHosts(hosts, knownHosts(filter.Game.Name, hosts), n)
+	}
+	logger.LogSteamInfo("Starting %s warm-up retrieval of %d/%d servers",
+		filter.Game.Name, len(hosts), len(mq.Servers))
+	sl, err := queryServerList(ctx, filter, hosts)
+	if err != nil {
+		return nil, err
+	}
+	sl.WarmUp = true
+	return sl, nil
+}
+
+// knownHosts returns the hosts that are in the server database for the game.
+func knownHosts(game string, hosts []string) map[string]bool {
+	known := make(map[string]bool)
+	if db.ServerDB == nil {
+		return known
+	}
+	toGet := make(map[string]string, len(hosts))
+	for _, h := range hosts {
+		toGet[h] = game
+	}
+	result := make(chan map[string]int64, 1)
+	go db.ServerDB.GetIDsForServerList(result, toGet)
+	for h, id := range <-result {
+		if id != 0 {
+			known[h] = true
+		}
+	}
+	return known
+}
+
+// selectWarmUpHosts returns at most n of the hosts, known hosts first, keeping
+// the master server's order otherwise.
+func selectWarmUpHosts(hosts []string, known map[string]bool, n int) []string {
+	selected := make([]string, 0, n)
+	for _, h := range hosts {
+		if len(selected) == n {
+			return selected
+		}
+		if known[h] {
+			selected = append(selected, h)
+		}
+	}
+	for _, h := range hosts {
+		if len(selected) == n {
+			break
+		}
+		if !known[h] {
+			selected = append(selected, h)
+		}
+	}
+	return selected
+}
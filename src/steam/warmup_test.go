@@ -0,0 +1,25 @@
+package steam
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSelectWarmUpHosts(t *testing.T) {
+	hosts := []string{"10.0.0.1:27960", "10.0.0.2:27960", "10.0.0.3:27960",
+		"10.0.0.4:27960", "10.0.0.5:27960"}
+	known := map[string]bool{"10.0.0.2:27960": true, "10.0.0.4:27960": true}
+
+	expected := []string{"10.0.0.2:27960", "10.0.0.4:27960", "10.0.0.1:27960"}
+	if s := selectWarmUpHosts(hosts, known, 3); !reflect.DeepEqual(s, expected) {
+		t.Fatalf("Expected known hosts first, got: %v", s)
+	}
+	expected = []string{"10.0.0.2:27960"}
+	if s := selectWarmUpHosts(hosts, known, 1); !reflect.DeepEqual(s, expected) {
+		t.Fatalf("Expected 1 known host, got: %v", s)
+	}
+	expected = []string{"10.0.0.1:27960", "10.0.0.2:27960"}
+	if s := selectWarmUpHosts(hosts, nil, 2); !reflect.DeepEqual(s, expected) {
+		t.Fatalf("Expected first hosts without known hosts, got: %v", s)
+	}
+}
@@ -0,0 +1,376 @@
+package steam
+
+// webhooks.go - Posting of the servers' state changes (going offline, coming
+// back online, changing maps, and crossing a player count) to the configured
+// webhooks after each timed retrieval, so that i.e. chat bots and monitoring
+// systems don't have to poll the API.
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/models"
+	"github.com/syncore/a2sapi/src/util"
+)
+
+const (
+	// attempts to deliver each webhook payload, and the wait before the first
+	// retry, which doubles for each further retry
+	webhookAttempts   = 3
+	webhookRetryDelay = 5 * time.Second
+	// header with the hex HMAC-SHA256 of the body, for webhooks with a secret
+	webhookSignatureHeader = "X-A2SAPI-Signature"
+)
+
+// webhookDeliveriesMetric counts the webhook payloads, by whether they were
+// delivered.
+var webhookDeliveriesMetric = util.NewCounter("a2sapi_webhook_deliveries_total",
+	"Payloads posted to webhooks, by result (delivered or failed).", "result")
+
+// webhookEvent is a change in a server's state, as posted to webhooks.
+type webhookEvent struct {
+	ID    int64  `json:"serverID"`
+	Host  string `json:"address"`
+	Game  string `json:"game"`
+	Event string `json:"event"`
+	// offline: the server's status; playerThreshold: "above" or "below"
+	Detail string `json:"detail,omitempty"`
+	// mapChange: the previous and new map
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+	// playerThreshold: the server's human players and the webhook's threshold
+	Players   int   `json:"players,omitempty"`
+	Threshold int   `json:"threshold,omitempty"`
+	Timestamp int64 `json:"timestamp"`
+}
+
+// webhookPayload is the body of a webhook delivery: the events of one
+// retrieval of a game that the webhook posts.
+type webhookPayload struct {
+	Game      string         `json:"game"`
+	CycleID   string         `json:"cycleID"`
+	Timestamp int64          `json:"timestamp"`
+	Events    []webhookEvent `json:"events"`
+}
+
+// webhookServer is what webhooks' server rules match: a server's tags and
+// location.
+type webhookServer struct {
+	tags    []string
+	country string
+	region  string
+}
+
+var (
+	// human players of each server (by game and host) in the previous retrieval
+	webhookPlayers   = make(map[string]int)
+	webhookPlayersMu sync.Mutex
+	// tags and location of each server (by game and host) when it was last listed
+	webhookServers   = make(map[string]webhookServer)
+	webhookServersMu sync.Mutex
+
+	webhookClient     *http.Client
+	webhookClientOnce sync.Once
+
+	// deliveries that are in progress, so that they are finished on shutdown
+	webhookDeliveries sync.WaitGroup
+	// closed on shutdown, after which failed deliveries are no longer retried
+	webhooksStopping     = make(chan struct{})
+	webhooksStoppingOnce sync.Once
+)
+
+func getWebhookClient() *http.Client {
+	webhookClientOnce.Do(func() {
+		timeout := config.Get().OutputConfig.WebhookTimeout
+		if timeout <= 0 {
+			timeout = 10
+		}
+		webhookClient = &http.Client{Timeout: time.Duration(timeout) * time.Second}
+	})
+	return webhookClient
+}
+
+// webhooksWant determines whether any of the configured webhooks posts an event
+// of a game.
+func webhooksWant(game, event string) bool {
+	for _, w := range config.Get().OutputConfig.Webhooks {
+		if w.WantsEvent(game, event) {
+			return true
+		}
+	}
+	return false
+}
+
+// webhooksRouteByServer determines whether any of the configured webhooks only
+// posts the events of some servers.
+func webhooksRouteByServer() bool {
+	for _, w := range config.Get().OutputConfig.Webhooks {
+		if w.HasServerRules() {
+			return true
+		}
+	}
+	return false
+}
+
+// observeServers records the tags and locations of the servers in a game's
+// list, and returns those of the listed servers and of the servers that went
+// offline (as when they were last listed).
+func observeServers(game string,
+	sl *models.APIServerList) map[string]webhookServer {
+	webhookServersMu.Lock()
+	defer webhookServersMu.Unlock()
+	servers := make(map[string]webhookServer, len(sl.Servers))
+	for _, s := range sl.Servers {
+		ws := webhookServer{tags: s.Tags, country: s.CountryInfo.CountryCode,
+			region: s.CountryInfo.Continent}
+		servers[s.Host] = ws
+		webhookServers[game+"|"+s.Host] = ws
+	}
+	for _, s := range sl.OfflineServers {
+		key := game + "|" + s.Host
+		if ws, ok := webhookServers[key]; ok {
+			servers[s.Host] = ws
+			delete(webhookServers, key)
+		}
+	}
+	return servers
+}
+
+// routeWebhookEvents returns the events of the servers whose events a webhook
+// posts. Servers whose tags and location aren't known (i.e. that went offline
+// before they were first listed) only match webhooks without server rules.
+func routeWebhookEvents(w config.Webhook, events []webhookEvent,
+	servers map[string]webhookServer) []webhookEvent {
+	if !w.HasServerRules() {
+		return events
+	}
+	var out []webhookEvent
+	for _, e := range events {
+		if s, ok := servers[e.Host]; ok && w.WantsServer(s.tags, s.country,
+			s.region) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// humanPlayers returns the number of players on a server that are not bots.
+func humanPlayers(s models.APIServer) int {
+	if n := int(s.Info.Players) - int(s.Info.Bots); n > 0 {
+		return n
+	}
+	return 0
+}
+
+// observePlayers records the human players of the servers in a game's list, and
+// returns each server's players in the previous retrieval (-1 for servers that
+// weren't in it).
+func observePlayers(game string, sl *models.APIServerList) map[string]int {
+	webhookPlayersMu.Lock()
+	defer webhookPlayersMu.Unlock()
+	prev := make(map[string]int, len(sl.Servers))
+	for _, s := range sl.Servers {
+		key := game + "|" + s.Host
+		p, ok := webhookPlayers[key]
+		if !ok {
+			p = -1
+		}
+		prev[s.Host] = p
+		webhookPlayers[key] = humanPlayers(s)
+	}
+	for _, s := range sl.OfflineServers {
+		delete(webhookPlayers, game+"|"+s.Host)
+	}
+	return prev
+}
+
+// thresholdEvents returns the playerThreshold events of the servers whose human
+// players went above or dropped below threshold since the previous retrieval.
+// Servers that weren't in the previous retrieval have no events.
+func thresholdEvents(game string, sl *models.APIServerList, prev map[string]int,
+	threshold int, now int64) []webhookEvent {
+	var events []webhookEvent
+	for _, s := range sl.Servers {
+		before, ok := prev[s.Host]
+		if !ok || before < 0 {
+			continue
+		}
+		after := humanPlayers(s)
+		detail := ""
+		switch {
+		case before < threshold && after >= threshold:
+			detail = "above"
+		case before >= threshold && after < threshold:
+			detail = "below"
+		default:
+			continue
+		}
+		events = append(events, webhookEvent{ID: s.ID, Host: s.Host, Game: game,
+			Event: models.ServerEventPlayerThreshold, Detail: detail,
+			Players: after, Threshold: threshold, Timestamp: now})
+	}
+	return events
+}
+
+// webhookEvents returns the events of a game's retrieval that a webhook posts.
+func webhookEvents(w config.Webhook, game string, sl *models.APIServerList,
+	events []models.DbServerEvent, mapChanges []models.DbMapChange,
+	prevPlayers map[string]int, now int64) []webhookEvent {
+	var out []webhookEvent
+	for _, e := range events {
+		if w.WantsEvent(game, e.Event) {
+			out = append(out, webhookEvent{ID: e.ID, Host: e.Host, Game: game,
+				Event: e.Event, Detail: e.Detail, Timestamp: e.Timestamp})
+		}
+	}
+	if w.WantsEvent(game, models.ServerEventMapChange) {
+		for _, c := range mapChanges {
+			out = append(out, webhookEvent{ID: c.ID, Host: c.Host, Game: game,
+				Event: models.ServerEventMapChange, From: c.FromMap, To: c.ToMap,
+				Timestamp: c.Timestamp})
+		}
+	}
+	if w.PlayerThreshold > 0 &&
+		w.WantsEvent(game, models.ServerEventPlayerThreshold) {
+		out = append(out, thresholdEvents(game, sl, prevPlayers,
+			w.PlayerThreshold, now)...)
+	}
+	return out
+}
+
+// sendWebhooks posts the events of a game's retrieval to the webhooks that post
+// any of them. Deliveries are made in the background.
+func sendWebhooks(game string, sl *models.APIServerList,
+	events []models.DbServerEvent, mapChanges []models.DbMapChange) {
+	hooks := config.Get().OutputConfig.Webhooks
+	if len(hooks) == 0 || sl == nil {
+		return
+	}
+	var prevPlayers map[string]int
+	if webhooksWant(game, models.ServerEventPlayerThreshold) {
+		prevPlayers = observePlayers(game, sl)
+	}
+	var servers map[string]webhookServer
+	if webhooksRouteByServer() {
+		servers = observeServers(game, sl)
+	}
+	now := time.Now().Unix()
+	for _, w := range hooks {
+		we := routeWebhookEvents(w, webhookEvents(w, game, sl, events, mapChanges,
+			prevPlayers, now), servers)
+		if len(we) == 0 {
+			continue
+		}
+		body, err := json.Marshal(webhookPayload{Game: game, CycleID: sl.CycleID,
+			Timestamp: now, Events: we})
+		if err != nil {
+			logger.LogAppErrorf("Error marshaling webhook payload: %s", err)
+			continue
+		}
+		startWebhookDelivery(w, body, webhookRetryDelay)
+	}
+}
+
+// startWebhookDelivery delivers a payload to a webhook in the background.
+func startWebhookDelivery(w config.Webhook, body []byte,
+	retryDelay time.Duration) {
+	webhookDeliveries.Add(1)
+	go func() {
+		defer webhookDeliveries.Done()
+		deliverWebhook(w, body, retryDelay)
+	}()
+}
+
+// waitForWebhooks waits for the webhook deliveries that are in progress to
+// finish. Their current attempts are completed, but failed deliveries are no
+// longer retried, so that shutdown isn't held up by the retry delays.
+func waitForWebhooks() {
+	webhooksStoppingOnce.Do(func() { close(webhooksStopping) })
+	webhookDeliveries.Wait()
+}
+
+// signWebhook returns the hex HMAC-SHA256 of a payload with the secret.
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliverWebhook posts a payload to a webhook, retrying failed deliveries, and
+// returns true if it was delivered. Only server errors and failed requests are
+// retried (until shutdown); a webhook that rejects a payload won't accept it
+// later.
+func deliverWebhook(w config.Webhook, body []byte, retryDelay time.Duration) bool {
+	for attempt := 1; ; attempt++ {
+		retry, err := postWebhook(w, body)
+		if err == nil {
+			webhookDeliveriesMetric.Inc("delivered")
+			return true
+		}
+		if retry && attempt < webhookAttempts {
+			select {
+			case <-time.After(retryDelay):
+				retryDelay *= 2
+				continue
+			case <-webhooksStopping:
+			}
+		}
+		// the URL itself isn't logged, since it may contain a token
+		logger.LogAppErrorf("Unable to deliver webhook payload to %s: %s",
+			webhookHost(w.URL), err)
+		webhookDeliveriesMetric.Inc("failed")
+		return false
+	}
+}
+
+// postWebhook posts a payload to a webhook once. If unsuccessful, retry is true
+// if the delivery may succeed later.
+func postWebhook(w config.Webhook, body []byte) (retry bool, err error) {
+	req, err := http.NewRequest("POST", w.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, withoutURL(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.Secret != "" {
+		req.Header.Set(webhookSignatureHeader, "sha256="+signWebhook(w.Secret, body))
+	}
+	resp, err := getWebhookClient().Do(req)
+	if err != nil {
+		return true, withoutURL(err)
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return true, fmt.Errorf("failed with status %d", resp.StatusCode)
+	case resp.StatusCode >= 300:
+		return false, fmt.Errorf("rejected with status %d", resp.StatusCode)
+	}
+	return false, nil
+}
+
+// withoutURL returns the underlying error of a request error, which would
+// otherwise include the URL.
+func withoutURL(err error) error {
+	if ue, ok := err.(*url.Error); ok {
+		return ue.Err
+	}
+	return err
+}
+
+// webhookHost returns the host of a webhook URL, for logging.
+func webhookHost(rawurl string) string {
+	if u, err := url.Parse(rawurl); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return "webhook"
+}
@@ -0,0 +1,209 @@
+package steam
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/models"
+)
+
+func TestWebhookEvents(t *testing.T) {
+	game := "QuakeLive"
+	defer func() {
+		webhookPlayersMu.Lock()
+		webhookPlayers = make(map[string]int)
+		webhookPlayersMu.Unlock()
+	}()
+	list := func(players, bots int16) *models.APIServerList {
+		return &models.APIServerList{Servers: []models.APIServer{{ID: 1,
+			Host: "10.0.0.1:27960", Info: models.SteamServerInfo{Players: players,
+				Bots: bots}}}}
+	}
+	w := config.Webhook{Events: []string{"playerThreshold", "mapchange"},
+		PlayerThreshold: 4}
+
+	// servers that weren't in the previous retrieval have no threshold events
+	sl := list(6, 0)
+	if e := webhookEvents(w, game, sl, nil, nil, observePlayers(game, sl),
+		1); len(e) != 0 {
+		t.Fatalf("Expected no events for new server, got: %v", e)
+	}
+	steps := []struct {
+		players, bots int16
+		expected      string
+	}{
+		{3, 0, "below"},
+		{6, 3, ""},
+		{5, 0, "above"},
+		{8, 0, ""},
+	}
+	for i, s := range steps {
+		sl := list(s.players, s.bots)
+		e := webhookEvents(w, game, sl, nil, nil, observePlayers(game, sl), 1)
+		if s.expected == "" {
+			if len(e) != 0 {
+				t.Errorf("Step %d: expected no events, got: %v", i, e)
+			}
+			continue
+		}
+		if len(e) != 1 || e[0].Detail != s.expected || e[0].Threshold != 4 {
+			t.Errorf("Step %d: expected %s event, got: %v", i, s.expected, e)
+		}
+	}
+
+	// only the webhook's events (and games) are posted
+	events := []models.DbServerEvent{{ID: 2, Event: models.ServerEventOffline}}
+	changes := []models.DbMapChange{{ID: 3, FromMap: "bloodrun", ToMap: "campgrounds"}}
+	e := webhookEvents(w, game, list(8, 0), events, changes, nil, 1)
+	if len(e) != 1 || e[0].Event != models.ServerEventMapChange ||
+		e[0].To != "campgrounds" {
+		t.Fatalf("Expected only the map change event, got: %v", e)
+	}
+	w.Games = []string{"Reflex"}
+	if e := webhookEvents(w, game, list(8, 0), events, changes, nil,
+		1); len(e) != 0 {
+		t.Fatalf("Expected no events for other game, got: %v", e)
+	}
+}
+
+func TestRouteWebhookEvents(t *testing.T) {
+	game := "QuakeLive"
+	defer func() {
+		webhookServersMu.Lock()
+		webhookServers = make(map[string]webhookServer)
+		webhookServersMu.Unlock()
+	}()
+	sl := &models.APIServerList{Servers: []models.APIServer{
+		{Host: "10.0.0.1:27960", Tags: []string{"EU-Duel", "vanilla"},
+			CountryInfo: models.DbCountry{CountryCode: "DE", Continent: "EU"}},
+		{Host: "10.0.0.2:27960", Tags: []string{"ca"},
+			CountryInfo: models.DbCountry{CountryCode: "US", Continent: "NA"}},
+	}}
+	events := []webhookEvent{{Host: "10.0.0.1:27960"}, {Host: "10.0.0.2:27960"},
+		{Host: "10.0.0.3:27960"}}
+	servers := observeServers(game, sl)
+	hosts := func(e []webhookEvent) []string {
+		var h []string
+		for _, ev := range e {
+			h = append(h, ev.Host)
+		}
+		return h
+	}
+	tests := []struct {
+		w        config.Webhook
+		expected []string
+	}{
+		{config.Webhook{}, []string{"10.0.0.1:27960", "10.0.0.2:27960",
+			"10.0.0.3:27960"}},
+		{config.Webhook{Tags: []string{"eu-duel"}}, []string{"10.0.0.1:27960"}},
+		{config.Webhook{Countries: []string{"us"}}, []string{"10.0.0.2:27960"}},
+		{config.Webhook{Regions: []string{"EU", "NA"}}, []string{"10.0.0.1:27960",
+			"10.0.0.2:27960"}},
+		{config.Webhook{Tags: []string{"ca"}, Regions: []string{"EU"}}, nil},
+	}
+	for i, tt := range tests {
+		if h := hosts(routeWebhookEvents(tt.w, events,
+			servers)); !reflect.DeepEqual(h, tt.expected) {
+			t.Errorf("Test %d: expected events of %v, got: %v", i, tt.expected, h)
+		}
+	}
+
+	// servers that went offline are routed as when they were last listed
+	offline := &models.APIServerList{OfflineServers: []models.APIOfflineServer{
+		{Host: "10.0.0.1:27960"}}}
+	servers = observeServers(game, offline)
+	w := config.Webhook{Tags: []string{"eu-duel"}}
+	if h := hosts(routeWebhookEvents(w, events, servers)); !reflect.DeepEqual(h,
+		[]string{"10.0.0.1:27960"}) {
+		t.Fatalf("Expected the offline server's event, got: %v", h)
+	}
+}
+
+func TestDeliverWebhook(t *testing.T) {
+	var requests int32
+	var payload webhookPayload
+	var signature string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		signature = r.Header.Get(webhookSignatureHeader)
+		if r.URL.Path == "/rejected" ||
+			signature != "sha256="+signWebhook("s3cret", body) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.Unmarshal(body, &payload)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	body, _ := json.Marshal(webhookPayload{Game: "QuakeLive",
+		Events: []webhookEvent{{ID: 1, Event: models.ServerEventOnline}}})
+	// server errors are retried
+	if !deliverWebhook(config.Webhook{URL: srv.URL, Secret: "s3cret"}, body,
+		time.Millisecond) {
+		t.Fatalf("Expected payload to be delivered after retry")
+	}
+	if requests != 2 || payload.Game != "QuakeLive" || len(payload.Events) != 1 {
+		t.Fatalf("Expected the payload in the second request, got %d requests: %+v",
+			requests, payload)
+	}
+
+	// client errors are not
+	atomic.StoreInt32(&requests, 1)
+	if deliverWebhook(config.Webhook{URL: srv.URL + "/rejected"}, body,
+		time.Millisecond) {
+		t.Fatalf("Expected rejected payload not to be delivered")
+	}
+	if requests != 2 {
+		t.Fatalf("Expected rejected payload not to be retried, got %d requests",
+			requests-1)
+	}
+}
+
+// TestWaitForWebhooks tests that the deliveries in progress are waited for on
+// shutdown, without waiting to retry the failed ones
+func TestWaitForWebhooks(t *testing.T) {
+	defer func() {
+		webhooksStopping = make(chan struct{})
+		webhooksStoppingOnce = sync.Once{}
+	}()
+	var requests int32
+	received := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		received <- struct{}{}
+	}))
+	defer srv.Close()
+
+	startWebhookDelivery(config.Webhook{URL: srv.URL}, []byte("{}"), time.Hour)
+	<-received
+	done := make(chan struct{})
+	go func() {
+		waitForWebhooks()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected waiting for webhooks not to wait for the retry")
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Fatalf("Expected failed delivery not to be retried on shutdown, got %d "+
+			"requests", n)
+	}
+}
@@ -27,7 +27,7 @@ func SetupEnvironment() {
 
 	// Dump is not in test directory and needs config access
 	deleteFiles(constants.DumpFileFullPath(
-		config.Config.DebugConfig.ServerDumpFilename))
+		config.Get().DebugConfig.ServerDumpFilename))
 }
 
 func deleteFiles(filepaths ...string) {
@@ -0,0 +1,111 @@
+package util
+
+// cachestats.go - Registry of the application's in-memory caches, which report
+// their hit/miss counts via expvar and can be purged by name
+
+import (
+	"expvar"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Cache is a named in-memory cache that records its hits and misses.
+type Cache struct {
+	name   string
+	hits   uint64
+	misses uint64
+	purges uint64
+	size   func() int
+	purge  func() int
+}
+
+// CacheInfo is a point-in-time view of a cache's statistics.
+type CacheInfo struct {
+	Hits    uint64  `json:"hits"`
+	Misses  uint64  `json:"misses"`
+	HitRate float64 `json:"hitRate"`
+	Entries int     `json:"entries"`
+	Purges  uint64  `json:"purges"`
+}
+
+var (
+	caches   = make(map[string]*Cache)
+	cachesMu sync.Mutex
+)
+
+func init() {
+	expvar.Publish("a2sCaches", expvar.Func(func() interface{} {
+		return CacheStats()
+	}))
+}
+
+// RegisterCache registers a cache under name. size returns the cache's current
+// number of entries and purge empties the cache, returning the number of
+// entries that were removed.
+func RegisterCache(name string, size, purge func() int) *Cache {
+	c := &Cache{name: name, size: size, purge: purge}
+	cachesMu.Lock()
+	caches[name] = c
+	cachesMu.Unlock()
+	return c
+}
+
+// Hit records a cache hit.
+func (c *Cache) Hit() {
+	atomic.AddUint64(&c.hits, 1)
+}
+
+// Miss records a cache miss.
+func (c *Cache) Miss() {
+	atomic.AddUint64(&c.misses, 1)
+}
+
+func (c *Cache) info() CacheInfo {
+	ci := CacheInfo{
+		Hits:    atomic.LoadUint64(&c.hits),
+		Misses:  atomic.LoadUint64(&c.misses),
+		Purges:  atomic.LoadUint64(&c.purges),
+		Entries: c.size(),
+	}
+	if total := ci.Hits + ci.Misses; total > 0 {
+		ci.HitRate = float64(ci.Hits) / float64(total)
+	}
+	return ci
+}
+
+// CacheStats returns the statistics of every registered cache by name.
+func CacheStats() map[string]CacheInfo {
+	cachesMu.Lock()
+	defer cachesMu.Unlock()
+	m := make(map[string]CacheInfo, len(caches))
+	for name, c := range caches {
+		m[name] = c.info()
+	}
+	return m
+}
+
+// CacheNames returns the names of the registered caches, sorted.
+func CacheNames() []string {
+	cachesMu.Lock()
+	defer cachesMu.Unlock()
+	names := make([]string, 0, len(caches))
+	for name := range caches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PurgeCache empties the named cache, returning the number of entries removed
+// and false if there is no such cache.
+func PurgeCache(name string) (int, bool) {
+	cachesMu.Lock()
+	c, ok := caches[name]
+	cachesMu.Unlock()
+	if !ok {
+		return 0, false
+	}
+	atomic.AddUint64(&c.purges, 1)
+	return c.purge(), true
+}
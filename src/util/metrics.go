@@ -0,0 +1,221 @@
+package util
+
+// metrics.go - Registry of the application's Prometheus metrics (counters and
+// histograms, optionally with labels), written in the Prometheus text format
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DurationBuckets are the default histogram buckets for durations in seconds.
+var DurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10,
+	30, 60}
+
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+var (
+	metrics   = make(map[string]metric)
+	metricsMu sync.Mutex
+)
+
+func registerMetric(name string, m metric) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	if _, ok := metrics[name]; ok {
+		panic(fmt.Sprintf("metric %s registered twice", name))
+	}
+	metrics[name] = m
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labelKey joins label values into a map key.
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// formatLabels formats label names and values as {name="value",...}, with extra
+// (i.e. the le label of histogram buckets) appended if not empty.
+func formatLabels(names, values []string, extra string) string {
+	if len(names) == 0 && extra == "" {
+		return ""
+	}
+	parts := make([]string, 0, len(names)+1)
+	for i, n := range names {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, n,
+			labelEscaper.Replace(values[i])))
+	}
+	if extra != "" {
+		parts = append(parts, extra)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Counter is a Prometheus counter with zero or more labels.
+type Counter struct {
+	name   string
+	help   string
+	labels []string
+	mu     sync.Mutex
+	values map[string]float64
+	// label values of each series, by key
+	series map[string][]string
+}
+
+// NewCounter registers and returns a counter with the given label names.
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{name: name, help: help, labels: labels,
+		values: make(map[string]float64), series: make(map[string][]string)}
+	registerMetric(name, c)
+	return c
+}
+
+// Add adds v to the series with the given label values.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if len(labelValues) != len(c.labels) {
+		panic(fmt.Sprintf("metric %s: wrong number of label values", c.name))
+	}
+	k := labelKey(labelValues)
+	c.mu.Lock()
+	if _, ok := c.series[k]; !ok {
+		c.series[k] = append([]string(nil), labelValues...)
+	}
+	c.values[k] += v
+	c.mu.Unlock()
+}
+
+// Inc adds 1 to the series with the given label values.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, c.series[k], ""),
+			formatFloat(c.values[k]))
+	}
+}
+
+type histogramSeries struct {
+	labels []string
+	// count of observations in each bucket (not cumulative)
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Histogram is a Prometheus histogram with zero or more labels.
+type Histogram struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+// NewHistogram registers and returns a histogram with the given (ascending)
+// bucket upper bounds and label names.
+func NewHistogram(name, help string, buckets []float64,
+	labels ...string) *Histogram {
+	h := &Histogram{name: name, help: help, labels: labels, buckets: buckets,
+		series: make(map[string]*histogramSeries)}
+	registerMetric(name, h)
+	return h
+}
+
+// Observe adds an observation to the series with the given label values.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	if len(labelValues) != len(h.labels) {
+		panic(fmt.Sprintf("metric %s: wrong number of label values", h.name))
+	}
+	k := labelKey(labelValues)
+	i := sort.SearchFloat64s(h.buckets, v)
+	h.mu.Lock()
+	s, ok := h.series[k]
+	if !ok {
+		s = &histogramSeries{labels: append([]string(nil), labelValues...),
+			counts: make([]uint64, len(h.buckets))}
+		h.series[k] = s
+	}
+	if i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += v
+	h.mu.Unlock()
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	keys := make([]string, 0, len(h.series))
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := h.series[k]
+		var cumulative uint64
+		for i, b := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, s.labels,
+				fmt.Sprintf(`le="%s"`, formatFloat(b))), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name,
+			formatLabels(h.labels, s.labels, `le="+Inf"`), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, s.labels, ""),
+			formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name,
+			formatLabels(h.labels, s.labels, ""), s.count)
+	}
+}
+
+// WriteMetrics writes all of the registered metrics, sorted by name, in the
+// Prometheus text exposition format.
+func WriteMetrics(out io.Writer) error {
+	metricsMu.Lock()
+	names := make([]string, 0, len(metrics))
+	for n := range metrics {
+		names = append(names, n)
+	}
+	ms := make(map[string]metric, len(metrics))
+	for n, m := range metrics {
+		ms[n] = m
+	}
+	metricsMu.Unlock()
+	sort.Strings(names)
+	w := bufio.NewWriter(out)
+	for _, n := range names {
+		ms[n].write(w)
+	}
+	return w.Flush()
+}
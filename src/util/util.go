@@ -4,11 +4,27 @@ package util
 
 import (
 	"bufio"
+	"context"
+	"crypto/rand"
 	"encoding/json"
 	"fmt"
+	"net"
 	"os"
 )
 
+// lanNetworks are the private (RFC 1918, RFC 4193), loopback, link-local, and
+// carrier-grade NAT ranges for which a geolocation lookup makes no sense.
+var lanNetworks = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16",
+		"127.0.0.0/8", "169.254.0.0/16", "100.64.0.0/10", "::1/128", "fc00::/7",
+		"fe80::/10"} {
+		_, n, _ := net.ParseCIDR(cidr)
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
 // FileExists returns true if the file of name exists; otherwise returns false.
 func FileExists(name string) bool {
 	if _, err := os.Stat(name); err != nil {
@@ -75,7 +91,7 @@ func CreateByteFile(data []byte, fullpath string, allowOverwrite bool) error {
 }
 
 // WriteJSONConfig takes an empty interface cfg, which is meant to be a
-// *config.Config struct or a *GameList and writes it as JSON to disk at
+// *config.Cfg struct or a *GameList and writes it as JSON to disk at
 // fullpath, overwriting any existing file.
 func WriteJSONConfig(cfg interface{}, directory, fullpath string) error {
 	if err := CreateDirectory(directory); err != nil {
@@ -105,3 +121,50 @@ func ReadTillNul(b []byte) string {
 	}
 	return string(b[:end])
 }
+
+// IsLANAddress returns true if the IP address ipstr is a private, loopback, or
+// link-local address; otherwise returns false.
+func IsLANAddress(ipstr string) bool {
+	ip := net.ParseIP(ipstr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range lanNetworks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsPublicIP returns true if the IP address can be reached over the internet,
+// i.e. it is not a LAN, unspecified, or multicast address.
+func IsPublicIP(ip net.IP) bool {
+	return !ip.IsUnspecified() && !ip.IsMulticast() && !IsLANAddress(ip.String())
+}
+
+// CheckPublicHost resolves host (a name or an IP address) and returns an error
+// if it can't be resolved or any of its addresses isn't a public address.
+func CheckPublicHost(ctx context.Context, host string) error {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("unable to resolve %s: %s", host, err)
+	}
+	for _, a := range addrs {
+		if !IsPublicIP(a.IP) {
+			return fmt.Errorf("%s resolves to non-public address %s", host, a.IP)
+		}
+	}
+	return nil
+}
+
+// NewUUID returns a random (version 4) UUID.
+func NewUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Sprintf("Unable to read random bytes for UUID: %s", err))
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
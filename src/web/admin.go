@@ -0,0 +1,230 @@
+package web
+
+// admin.go - Administrative (diagnostics and key management) listener, only
+// reachable with the admin API key or an API key with an admin scope.
+
+import (
+	"context"
+	"crypto/subtle"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/db"
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/steam"
+	"github.com/syncore/a2sapi/src/util"
+)
+
+var startTime = time.Now()
+
+// runtimeSnapshot represents a point-in-time view of the process' goroutine and
+// memory usage.
+type runtimeSnapshot struct {
+	Uptime      string `json:"uptime"`
+	Goroutines  int    `json:"goroutines"`
+	HeapAlloc   uint64 `json:"heapAllocBytes"`
+	HeapInuse   uint64 `json:"heapInuseBytes"`
+	HeapObjects uint64 `json:"heapObjects"`
+	Sys         uint64 `json:"sysBytes"`
+	TotalAlloc  uint64 `json:"totalAllocBytes"`
+	NumGC       uint32 `json:"numGC"`
+	PauseTotal  string `json:"gcPauseTotal"`
+	LastGC      string `json:"lastGC"`
+	NextGC      uint64 `json:"nextGCBytes"`
+	GoVersion   string `json:"goVersion"`
+	NumCPU      int    `json:"numCPU"`
+	GoMaxProcs  int    `json:"gomaxprocs"`
+}
+
+func newAdminMux() *http.ServeMux {
+	m := http.NewServeMux()
+	m.Handle("/debug/pprof/", requireAdminKey(http.HandlerFunc(pprof.Index)))
+	m.Handle("/debug/pprof/cmdline", requireAdminKey(http.HandlerFunc(pprof.Cmdline)))
+	m.Handle("/debug/pprof/profile", requireAdminKey(http.HandlerFunc(pprof.Profile)))
+	m.Handle("/debug/pprof/symbol", requireAdminKey(http.HandlerFunc(pprof.Symbol)))
+	m.Handle("/debug/pprof/trace", requireAdminKey(http.HandlerFunc(pprof.Trace)))
+	m.Handle("/debug/vars", requireAdminKey(expvar.Handler()))
+	m.Handle("/debug/snapshot", requireAdminKey(http.HandlerFunc(getRuntimeSnapshot)))
+	m.Handle("/metrics", requireAdminKey(http.HandlerFunc(writeMetrics)))
+	m.Handle("/admin/keys", requireAdminScope(scopeAdminKeys,
+		http.HandlerFunc(handleAPIKeys)))
+	m.Handle("/admin/keys/rotate", requireAdminScope(scopeAdminKeys,
+		http.HandlerFunc(rotateAPIKey)))
+	m.Handle("/admin/keys/revoke", requireAdminScope(scopeAdminKeys,
+		http.HandlerFunc(revokeAPIKey)))
+	m.Handle("/admin/cache/purge", requireAdminKey(http.HandlerFunc(purgeCache)))
+	m.Handle("/admin/config", requireAdminKey(http.HandlerFunc(getEffectiveConfig)))
+	m.Handle("/admin/jobs/geoip", requireAdminKey(http.HandlerFunc(handleGeoIPJob)))
+	return m
+}
+
+// requireAdminKey wraps a diagnostics handler so that it is only served when the
+// request carries the configured admin API key, or an API key with the
+// admin:debug scope, as a bearer token.
+func requireAdminKey(h http.Handler) http.Handler {
+	return requireAdminScope(scopeAdminDebug, h)
+}
+
+// requireAdminScope wraps an HTTP handler so that it is only served when the
+// request carries the configured admin API key, or an API key with the given
+// scope, as a bearer token.
+func requireAdminScope(scope string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if config.Get().AdminConfig.AdminAPIKey != "" &&
+			subtle.ConstantTimeCompare([]byte(key),
+				[]byte(config.Get().AdminConfig.AdminAPIKey)) == 1 {
+			h.ServeHTTP(w, r)
+			return
+		}
+		k, err := authenticateAPIKey(key)
+		if err != nil || k == nil {
+			logger.LogWebErrorf("Unauthorized admin request for %s from %s",
+				r.URL.Path, r.RemoteAddr)
+			writeUnauthorized(w, "Unauthorized.")
+			return
+		}
+		if !hasScope(k.Scopes, scope) {
+			logger.LogWebErrorf("API key %s lacks %s scope for admin request %s from %s",
+				k.ID, scope, r.URL.Path, r.RemoteAddr)
+			writeForbidden(w, scope)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// effectiveConfig represents the configuration in use, including the default
+// values of options that are missing from the configuration file.
+type effectiveConfig struct {
+	Config   config.Cfg `json:"config"`
+	Warnings []string   `json:"warnings"`
+}
+
+func getEffectiveConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	warnings := config.Warnings
+	if warnings == nil {
+		warnings = make([]string, 0)
+	}
+	writeJSONResponse(w, effectiveConfig{Config: config.Get().Masked(),
+		Warnings: warnings})
+}
+
+func getRuntimeSnapshot(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	lastgc := "never"
+	if ms.LastGC != 0 {
+		lastgc = time.Unix(0, int64(ms.LastGC)).Format("Mon Jan 2 15:04:05 2006 EST")
+	}
+	writeJSONResponse(w, runtimeSnapshot{
+		Uptime:      time.Since(startTime).String(),
+		Goroutines:  runtime.NumGoroutine(),
+		HeapAlloc:   ms.HeapAlloc,
+		HeapInuse:   ms.HeapInuse,
+		HeapObjects: ms.HeapObjects,
+		Sys:         ms.Sys,
+		TotalAlloc:  ms.TotalAlloc,
+		NumGC:       ms.NumGC,
+		PauseTotal:  time.Duration(ms.PauseTotalNs).String(),
+		LastGC:      lastgc,
+		NextGC:      ms.NextGC,
+		GoVersion:   runtime.Version(),
+		NumCPU:      runtime.NumCPU(),
+		GoMaxProcs:  runtime.GOMAXPROCS(0),
+	})
+}
+
+func writeAdminError(w http.ResponseWriter, code int, msg string) {
+	w.WriteHeader(code)
+	fmt.Fprintf(w, `{"error": {"code": %d,"message": "%s"}}`, code, msg)
+}
+
+// cachePurgeResult represents the result of purging a cache.
+type cachePurgeResult struct {
+	Name   string `json:"name"`
+	Purged int    `json:"purgedEntries"`
+}
+
+func purgeCache(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if r.Method != "POST" {
+		writeAdminError(w, http.StatusMethodNotAllowed, "Method not allowed.")
+		return
+	}
+	name, _ := getQStringValue(r.URL.Query(), qsCacheName)
+	n, ok := util.PurgeCache(name)
+	if !ok {
+		writeAdminError(w, http.StatusNotFound, fmt.Sprintf(
+			"Unknown cache. Available caches: %s.", strings.Join(util.CacheNames(), ", ")))
+		return
+	}
+	logger.LogAppInfo("Purged %d entries from the %s cache", n, name)
+	db.AppDB.AddAuditEntry(r.RemoteAddr, "cache.purge", "cache:"+name,
+		fmt.Sprintf("%d entries", n))
+	writeJSONResponse(w, cachePurgeResult{Name: name, Purged: n})
+}
+
+// handleGeoIPJob returns the state of the GeoIP re-enrichment job (GET), or
+// starts it (POST), i.e. after the GeoIP database files have been updated.
+func handleGeoIPJob(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	switch r.Method {
+	case "GET":
+		writeJSONResponse(w, steam.GetGeoIPJob())
+	case "POST":
+		if !steam.StartGeoIPJob() {
+			writeAdminError(w, http.StatusConflict,
+				"The GeoIP re-enrichment job is already running.")
+			return
+		}
+		db.AppDB.AddAuditEntry(r.RemoteAddr, "job.start", "job:geoip", "")
+		w.WriteHeader(http.StatusAccepted)
+		writeJSONResponse(w, steam.GetGeoIPJob())
+	default:
+		writeAdminError(w, http.StatusMethodNotAllowed, "Method not allowed.")
+	}
+}
+
+// startAdmin starts the administrative listener which exposes the pprof, expvar,
+// runtime snapshot, and effective configuration diagnostics and the API key
+// management endpoints. Unlike the API's web server, a failure to
+// start the admin listener is logged but is not fatal. The listener is shut down
+// when ctx is cancelled.
+func startAdmin(ctx context.Context, runSilent bool) {
+	if config.Get().AdminConfig.AdminAPIKey == "" &&
+		config.Get().AdminConfig.OIDCIssuer == "" {
+		logger.LogAppErrorf(
+			"Admin listener is enabled but neither adminAPIKey nor oidcIssuer is set; not starting it")
+		if !runSilent {
+			fmt.Println("Admin listener: disabled (neither adminAPIKey nor oidcIssuer is set)")
+		}
+		return
+	}
+	if !runSilent {
+		fmt.Printf("Admin listener: enabled on %s\n",
+			config.Get().AdminConfig.AdminListenAddress)
+	}
+	logger.LogAppInfo("Starting admin listener on %s",
+		config.Get().AdminConfig.AdminListenAddress)
+	srv := http.Server{
+		Addr:           config.Get().AdminConfig.AdminListenAddress,
+		Handler:        newAdminMux(),
+		ReadTimeout:    30 * time.Second,
+		WriteTimeout:   60 * time.Second, // allow for 30 second CPU profiles
+		MaxHeaderBytes: 1 << 20}
+	stopped := shutdownOnDone(ctx, &srv, "admin listener")
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		logger.LogAppErrorf("Unable to start admin listener: %s", err)
+		return
+	}
+	<-stopped
+}
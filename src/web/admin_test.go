@@ -0,0 +1,195 @@
+package web
+
+// Tests for the administrative listener
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/models"
+	"github.com/syncore/a2sapi/src/steam"
+	"github.com/syncore/a2sapi/src/util"
+)
+
+// TestRequireAdminKey tests that admin handlers are only served with the key
+func TestRequireAdminKey(t *testing.T) {
+	prev := config.Get().AdminConfig.AdminAPIKey
+	defer func() { config.Get().AdminConfig.AdminAPIKey = prev }()
+	config.Get().AdminConfig.AdminAPIKey = "secret"
+	h := requireAdminKey(http.HandlerFunc(getRuntimeSnapshot))
+
+	r1, _ := http.NewRequest("GET", formatURL("debug/snapshot"), nil)
+	w1 := newRecorder()
+	h.ServeHTTP(w1, r1)
+	if w1.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status code %v without admin key; got: %v",
+			http.StatusUnauthorized, w1.Code)
+	}
+
+	r2, _ := http.NewRequest("GET", formatURL("debug/snapshot"), nil)
+	r2.Header.Set("Authorization", "Bearer wrong")
+	w2 := newRecorder()
+	h.ServeHTTP(w2, r2)
+	if w2.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status code %v with wrong admin key; got: %v",
+			http.StatusUnauthorized, w2.Code)
+	}
+
+	r3, _ := http.NewRequest("GET", formatURL("debug/snapshot"), nil)
+	r3.Header.Set("Authorization", "Bearer secret")
+	w3 := newRecorder()
+	h.ServeHTTP(w3, r3)
+	if w3.Code != http.StatusOK {
+		t.Errorf("Expected status code %v with admin key; got: %v",
+			http.StatusOK, w3.Code)
+	}
+	m := &runtimeSnapshot{}
+	if _, ok := w3.ExpectJSON(m, m); !ok {
+		t.Errorf("getRuntimeSnapshot: expected and actual models do not match.")
+	}
+}
+
+// TestRequireAdminScope tests that admin handlers are served for API keys with
+// the required admin scope
+func TestRequireAdminScope(t *testing.T) {
+	prevLookup := lookupAPIKey
+	defer func() { lookupAPIKey = prevLookup }()
+	adminKey, _ := newAPIKey("admin")
+	listKey, _ := newAPIKey("list")
+	keys := map[string]*models.DbAPIKey{
+		"admin": &models.DbAPIKey{ID: "admin", KeyHash: hashOwnerKey(adminKey),
+			Scopes: []string{scopeAdminAll}},
+		"list": &models.DbAPIKey{ID: "list", KeyHash: hashOwnerKey(listKey),
+			Scopes: []string{scopeReadList}},
+	}
+	lookupAPIKey = func(id string) (*models.DbAPIKey, error) {
+		return keys[id], nil
+	}
+	h := requireAdminKey(http.HandlerFunc(getRuntimeSnapshot))
+
+	r1, _ := http.NewRequest("GET", formatURL("debug/snapshot"), nil)
+	r1.Header.Set("Authorization", "Bearer "+listKey)
+	w1 := newRecorder()
+	h.ServeHTTP(w1, r1)
+	if w1.Code != http.StatusForbidden {
+		t.Errorf("Expected status code %v with read-only key; got: %v",
+			http.StatusForbidden, w1.Code)
+	}
+
+	r2, _ := http.NewRequest("GET", formatURL("debug/snapshot"), nil)
+	r2.Header.Set("Authorization", "Bearer "+adminKey)
+	w2 := newRecorder()
+	h.ServeHTTP(w2, r2)
+	if w2.Code != http.StatusOK {
+		t.Errorf("Expected status code %v with admin:* key; got: %v",
+			http.StatusOK, w2.Code)
+	}
+}
+
+// TestPurgeCache tests purging a registered cache by name
+func TestPurgeCache(t *testing.T) {
+	apiKeyCache.Lock()
+	apiKeyCache.keys["purgeme"] = cachedAPIKey{}
+	apiKeyCache.Unlock()
+
+	r1, _ := http.NewRequest("POST", formatURL("admin/cache/purge?name=apiKeys"), nil)
+	w1 := newRecorder()
+	purgeCache(w1, r1)
+	if w1.Code != http.StatusOK {
+		t.Fatalf("Expected status code %v for cache purge; got: %v", http.StatusOK,
+			w1.Code)
+	}
+	if util.CacheStats()["apiKeys"].Entries != 0 {
+		t.Errorf("Expected the apiKeys cache to be empty after purging")
+	}
+
+	r2, _ := http.NewRequest("POST", formatURL("admin/cache/purge?name=nope"), nil)
+	w2 := newRecorder()
+	purgeCache(w2, r2)
+	if w2.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %v for unknown cache; got: %v",
+			http.StatusNotFound, w2.Code)
+	}
+
+	r3, _ := http.NewRequest("GET", formatURL("admin/cache/purge?name=apiKeys"), nil)
+	w3 := newRecorder()
+	purgeCache(w3, r3)
+	if w3.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status code %v for GET; got: %v",
+			http.StatusMethodNotAllowed, w3.Code)
+	}
+}
+
+// TestGetEffectiveConfig tests that secrets are masked in the effective
+// configuration
+func TestGetEffectiveConfig(t *testing.T) {
+	prevSteam := config.Get().SteamConfig.SteamWebAPIKey
+	prevAdmin := config.Get().AdminConfig.AdminAPIKey
+	defer func() {
+		config.Get().SteamConfig.SteamWebAPIKey = prevSteam
+		config.Get().AdminConfig.AdminAPIKey = prevAdmin
+	}()
+	config.Get().SteamConfig.SteamWebAPIKey = "steamkey"
+	config.Get().AdminConfig.AdminAPIKey = "secret"
+
+	r, _ := http.NewRequest("GET", formatURL("admin/config"), nil)
+	w := newRecorder()
+	getEffectiveConfig(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %v; got: %v", http.StatusOK, w.Code)
+	}
+	if strings.Contains(w.Body.String(), "steamkey") ||
+		strings.Contains(w.Body.String(), "secret") {
+		t.Fatalf("Expected secrets to be masked, got: %s", w.Body.String())
+	}
+	ec := &effectiveConfig{}
+	if err := json.Unmarshal(w.Body.Bytes(), ec); err != nil {
+		t.Fatalf("Unable to decode effective config: %s", err)
+	}
+	if ec.Config.WebConfig.APIWebPort != config.Get().WebConfig.APIWebPort ||
+		ec.Warnings == nil {
+		t.Errorf("Unexpected effective config: %+v", ec)
+	}
+	if config.Get().AdminConfig.AdminAPIKey != "secret" {
+		t.Errorf("Expected the configuration in use to be unchanged")
+	}
+}
+
+// TestHandleGeoIPJob tests starting the GeoIP re-enrichment job and reading
+// its state
+func TestHandleGeoIPJob(t *testing.T) {
+	r, _ := http.NewRequest("POST", formatURL("admin/jobs/geoip"), nil)
+	w := newRecorder()
+	handleGeoIPJob(w, r)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected status code %v when starting the job; got: %v",
+			http.StatusAccepted, w.Code)
+	}
+	for deadline := time.Now().Add(5 * time.Second); steam.GetGeoIPJob().Running; {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the GeoIP job to finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	r, _ = http.NewRequest("GET", formatURL("admin/jobs/geoip"), nil)
+	w = newRecorder()
+	handleGeoIPJob(w, r)
+	job := steam.GeoIPJob{}
+	if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil ||
+		job.Running || job.Finished == 0 {
+		t.Fatalf("Expected finished job, got: %s", w.Body)
+	}
+
+	r, _ = http.NewRequest("DELETE", formatURL("admin/jobs/geoip"), nil)
+	w = newRecorder()
+	handleGeoIPJob(w, r)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("Expected status code %v; got: %v", http.StatusMethodNotAllowed,
+			w.Code)
+	}
+}
@@ -0,0 +1,376 @@
+package web
+
+// apikeys.go - Scoped API keys: enforcement for API routes and admin endpoints
+// to create, rotate, and revoke keys
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/db"
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/models"
+	"github.com/syncore/a2sapi/src/util"
+)
+
+// API key scopes. A scope ending in :* grants every scope with that prefix.
+const (
+	scopeReadList    = "read:list"
+	scopeQueryDirect = "query:direct"
+	scopeWriteClaims = "write:claims"
+	scopeAdminKeys   = "admin:keys"
+	scopeAdminDebug  = "admin:debug"
+	scopeAdminAll    = "admin:*"
+	// reserved for remote console access; not granted by admin:*
+	scopeRCON = "rcon"
+)
+
+const (
+	apiKeyPrefix = "a2sk_"
+	// how long looked-up keys are cached before being re-read from the database
+	apiKeyCacheTime = 30 * time.Second
+)
+
+var validScopes = map[string]bool{
+	scopeReadList:    true,
+	scopeQueryDirect: true,
+	scopeWriteClaims: true,
+	scopeAdminKeys:   true,
+	scopeAdminDebug:  true,
+	scopeAdminAll:    true,
+	scopeRCON:        true,
+}
+
+// lookupAPIKey retrieves an API key by its ID; nil if there is no such key.
+var lookupAPIKey = func(id string) (*models.DbAPIKey, error) {
+	return db.AppDB.GetAPIKey(id)
+}
+
+type cachedAPIKey struct {
+	key *models.DbAPIKey
+	at  time.Time
+}
+
+var apiKeyCache = struct {
+	sync.Mutex
+	keys map[string]cachedAPIKey
+}{keys: make(map[string]cachedAPIKey)}
+
+var apiKeyStats = util.RegisterCache("apiKeys", func() int {
+	apiKeyCache.Lock()
+	defer apiKeyCache.Unlock()
+	return len(apiKeyCache.keys)
+}, func() int {
+	apiKeyCache.Lock()
+	defer apiKeyCache.Unlock()
+	n := len(apiKeyCache.keys)
+	apiKeyCache.keys = make(map[string]cachedAPIKey)
+	return n
+})
+
+// hasScope returns true if scopes grants the scope.
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+		if strings.HasSuffix(s, ":*") && strings.HasPrefix(scope,
+			strings.TrimSuffix(s, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAPIKeyID returns the ID of a key in the form a2sk_<id>_<secret>.
+func parseAPIKeyID(key string) (string, bool) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return "", false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(key, apiKeyPrefix), "_", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", false
+	}
+	return parts[0], true
+}
+
+// newAPIKey generates a new key for the key ID.
+func newAPIKey(id string) (string, error) {
+	secret, err := randomHex(24)
+	if err != nil {
+		return "", err
+	}
+	return apiKeyPrefix + id + "_" + secret, nil
+}
+
+// getRequestKey returns the key sent with a request as a bearer token or in the
+// X-API-Key header.
+func getRequestKey(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return r.Header.Get("X-API-Key")
+}
+
+// authenticateAPIKey returns the stored API key matching key, or nil if the key
+// is invalid or revoked. When enabled, JWTs from the configured identity provider
+// are accepted as keys with the scopes granted by the token.
+func authenticateAPIKey(key string) (*models.DbAPIKey, error) {
+	if isJWT(key) {
+		return authenticateJWT(key), nil
+	}
+	id, ok := parseAPIKeyID(key)
+	if !ok {
+		return nil, nil
+	}
+	apiKeyCache.Lock()
+	c, ok := apiKeyCache.keys[id]
+	apiKeyCache.Unlock()
+	if ok && time.Since(c.at) <= apiKeyCacheTime {
+		apiKeyStats.Hit()
+	} else {
+		apiKeyStats.Miss()
+		k, err := lookupAPIKey(id)
+		if err != nil {
+			return nil, err
+		}
+		if k == nil {
+			// unknown IDs aren't cached, since clients can send any number of them
+			return nil, nil
+		}
+		c = cachedAPIKey{key: k, at: time.Now()}
+		apiKeyCache.Lock()
+		apiKeyCache.keys[id] = c
+		apiKeyCache.Unlock()
+	}
+	if c.key == nil || c.key.Revoked() ||
+		subtle.ConstantTimeCompare([]byte(hashOwnerKey(key)),
+			[]byte(c.key.KeyHash)) != 1 {
+		return nil, nil
+	}
+	return c.key, nil
+}
+
+func invalidateAPIKey(id string) {
+	apiKeyCache.Lock()
+	delete(apiKeyCache.keys, id)
+	apiKeyCache.Unlock()
+}
+
+func writeUnauthorized(w http.ResponseWriter, msg string) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusUnauthorized)
+	fmt.Fprintf(w, `{"error": {"code": 401,"message": "%s"}}`, msg)
+}
+
+func writeForbidden(w http.ResponseWriter, scope string) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusForbidden)
+	fmt.Fprintf(w,
+		`{"error": {"code": 403,"message": "This API key does not have the %s scope."}}`,
+		scope)
+}
+
+// requireScope wraps an API route's handler so that, when API keys are required,
+// it is only served for requests whose key (or, without a key, the anonymous
+// scopes) grants the route's scope.
+func requireScope(h http.Handler, scope string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !config.Get().AdminConfig.RequireAPIKeys || scope == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		key := getRequestKey(r)
+		if key == "" {
+			if hasScope(config.Get().AdminConfig.AnonymousScopes, scope) {
+				h.ServeHTTP(w, r)
+				return
+			}
+			writeUnauthorized(w, fmt.Sprintf("An API key with the %s scope is required.",
+				scope))
+			return
+		}
+		k, err := authenticateAPIKey(key)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, `{"error": {"code": 500,"message": "Unable to verify API key."}}`)
+			return
+		}
+		if k == nil {
+			logger.LogWebErrorf("Invalid API key for %s from %s", r.URL.Path,
+				r.RemoteAddr)
+			writeUnauthorized(w, "Invalid API key.")
+			return
+		}
+		if !hasScope(k.Scopes, scope) {
+			logger.LogWebErrorf("API key %s lacks %s scope for %s from %s", k.ID, scope,
+				r.URL.Path, r.RemoteAddr)
+			writeForbidden(w, scope)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// parseScopes parses a comma-separated list of scopes, returning an error for
+// unknown scopes.
+func parseScopes(val string) ([]string, error) {
+	var scopes []string
+	seen := make(map[string]bool)
+	for _, s := range strings.Split(val, ",") {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if s == "" || seen[s] {
+			continue
+		}
+		if !validScopes[s] {
+			return nil, fmt.Errorf("Unknown scope: %s", s)
+		}
+		seen[s] = true
+		scopes = append(scopes, s)
+	}
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("At least one scope must be specified with the %s parameter.",
+			qsAPIKeyScopes)
+	}
+	return scopes, nil
+}
+
+// getAPIKeyID returns the key ID from an admin request; if unsuccessful, an error
+// is written to w.
+func getAPIKeyID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if r.Method != "POST" {
+		writeAdminError(w, http.StatusMethodNotAllowed, "Method not allowed.")
+		return "", false
+	}
+	id, _ := getQStringValue(r.URL.Query(), qsAPIKeyID)
+	if id == "" {
+		writeAdminError(w, http.StatusBadRequest, fmt.Sprintf(
+			"A key ID must be specified with the %s parameter.", qsAPIKeyID))
+		return "", false
+	}
+	return id, true
+}
+
+// handleAPIKeys lists the API keys (GET) or creates a new key (POST).
+func handleAPIKeys(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	switch r.Method {
+	case "GET":
+		keys, err := db.AppDB.GetAPIKeys()
+		if err != nil {
+			writeAdminError(w, http.StatusInternalServerError,
+				"Unable to retrieve API keys.")
+			return
+		}
+		if keys == nil {
+			keys = make([]models.DbAPIKey, 0)
+		}
+		writeJSONResponse(w, keys)
+	case "POST":
+		q := r.URL.Query()
+		scopesVal, _ := getQStringValue(q, qsAPIKeyScopes)
+		scopes, err := parseScopes(scopesVal)
+		if err != nil {
+			writeAdminError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		name, _ := getQStringValue(q, qsAPIKeyName)
+		var quotas [2]int
+		for i, qs := range []string{qsAPIKeyHourlyQuota, qsAPIKeyDailyQuota} {
+			val, ok := getQStringValue(q, qs)
+			if !ok || val == "" {
+				continue
+			}
+			n, err := strconv.Atoi(val)
+			if err != nil || n < -1 {
+				writeAdminError(w, http.StatusBadRequest, fmt.Sprintf(
+					"The %s parameter must be a number of requests, or -1 for no limit.", qs))
+				return
+			}
+			quotas[i] = n
+		}
+		id, err := randomHex(8)
+		if err != nil {
+			writeAdminError(w, http.StatusInternalServerError, "Unable to create API key.")
+			return
+		}
+		key, err := newAPIKey(id)
+		if err != nil {
+			writeAdminError(w, http.StatusInternalServerError, "Unable to create API key.")
+			return
+		}
+		if err := db.AppDB.CreateAPIKey(id, name, hashOwnerKey(key), scopes,
+			quotas[0], quotas[1]); err != nil {
+			writeAdminError(w, http.StatusInternalServerError, "Unable to create API key.")
+			return
+		}
+		db.AppDB.AddAuditEntry(r.RemoteAddr, "apikey.create", "apikey:"+id,
+			strings.Join(scopes, ","))
+		writeJSONResponse(w, models.APIKeyToken{ID: id, Key: key, Scopes: scopes})
+	default:
+		writeAdminError(w, http.StatusMethodNotAllowed, "Method not allowed.")
+	}
+}
+
+// rotateAPIKey replaces an API key's secret, keeping its ID and scopes.
+func rotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, ok := getAPIKeyID(w, r)
+	if !ok {
+		return
+	}
+	existing, err := db.AppDB.GetAPIKey(id)
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, "Unable to rotate API key.")
+		return
+	}
+	if existing == nil || existing.Revoked() {
+		writeAdminError(w, http.StatusNotFound, "No active API key has that ID.")
+		return
+	}
+	key, err := newAPIKey(id)
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, "Unable to rotate API key.")
+		return
+	}
+	rotated, err := db.AppDB.RotateAPIKey(id, hashOwnerKey(key))
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, "Unable to rotate API key.")
+		return
+	}
+	if !rotated {
+		writeAdminError(w, http.StatusNotFound, "No active API key has that ID.")
+		return
+	}
+	invalidateAPIKey(id)
+	db.AppDB.AddAuditEntry(r.RemoteAddr, "apikey.rotate", "apikey:"+id, "")
+	writeJSONResponse(w, models.APIKeyToken{ID: id, Key: key,
+		Scopes: existing.Scopes})
+}
+
+// revokeAPIKey permanently revokes an API key.
+func revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, ok := getAPIKeyID(w, r)
+	if !ok {
+		return
+	}
+	revoked, err := db.AppDB.RevokeAPIKey(id)
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, "Unable to revoke API key.")
+		return
+	}
+	if !revoked {
+		writeAdminError(w, http.StatusNotFound, "No active API key has that ID.")
+		return
+	}
+	invalidateAPIKey(id)
+	db.AppDB.AddAuditEntry(r.RemoteAddr, "apikey.revoke", "apikey:"+id, "")
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,133 @@
+package web
+
+// Tests for scoped API keys
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/models"
+)
+
+func TestHasScope(t *testing.T) {
+	tests := []struct {
+		scopes   []string
+		scope    string
+		expected bool
+	}{
+		{[]string{scopeReadList}, scopeReadList, true},
+		{[]string{scopeReadList}, scopeQueryDirect, false},
+		{[]string{scopeAdminAll}, scopeAdminKeys, true},
+		{[]string{scopeAdminAll}, scopeAdminDebug, true},
+		{[]string{scopeAdminAll}, scopeRCON, false},
+		{[]string{scopeAdminKeys}, scopeAdminDebug, false},
+		{nil, scopeReadList, false},
+	}
+	for _, tt := range tests {
+		if got := hasScope(tt.scopes, tt.scope); got != tt.expected {
+			t.Errorf("hasScope(%v, %s): expected %v, got: %v", tt.scopes, tt.scope,
+				tt.expected, got)
+		}
+	}
+}
+
+func TestParseScopes(t *testing.T) {
+	scopes, err := parseScopes(" read:list,QUERY:DIRECT,read:list")
+	if err != nil {
+		t.Fatalf("Unexpected error parsing scopes: %s", err)
+	}
+	if len(scopes) != 2 || scopes[0] != scopeReadList ||
+		scopes[1] != scopeQueryDirect {
+		t.Errorf("Expected [read:list query:direct], got: %v", scopes)
+	}
+	if _, err := parseScopes("read:list,write:everything"); err == nil {
+		t.Errorf("Expected error for unknown scope")
+	}
+	if _, err := parseScopes(""); err == nil {
+		t.Errorf("Expected error for no scopes")
+	}
+}
+
+// TestRequireScope tests that, when keys are required, routes are only served
+// for keys with the route's scope
+func TestRequireScope(t *testing.T) {
+	prevRequire := config.Get().AdminConfig.RequireAPIKeys
+	prevAnon := config.Get().AdminConfig.AnonymousScopes
+	prevLookup := lookupAPIKey
+	defer func() {
+		config.Get().AdminConfig.RequireAPIKeys = prevRequire
+		config.Get().AdminConfig.AnonymousScopes = prevAnon
+		lookupAPIKey = prevLookup
+	}()
+	config.Get().AdminConfig.RequireAPIKeys = true
+	config.Get().AdminConfig.AnonymousScopes = []string{scopeReadList}
+
+	readKey, _ := newAPIKey("readonly")
+	revokedKey, _ := newAPIKey("revoked")
+	keys := map[string]*models.DbAPIKey{
+		"readonly": &models.DbAPIKey{ID: "readonly", KeyHash: hashOwnerKey(readKey),
+			Scopes: []string{scopeReadList}},
+		"revoked": &models.DbAPIKey{ID: "revoked", KeyHash: hashOwnerKey(revokedKey),
+			Scopes: []string{scopeQueryDirect}, RevokedAt: 1},
+	}
+	lookupAPIKey = func(id string) (*models.DbAPIKey, error) {
+		return keys[id], nil
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		scope    string
+		key      string
+		expected int
+	}{
+		{scopeReadList, "", http.StatusOK},
+		{scopeQueryDirect, "", http.StatusUnauthorized},
+		{scopeReadList, readKey, http.StatusOK},
+		{scopeQueryDirect, readKey, http.StatusForbidden},
+		{scopeQueryDirect, revokedKey, http.StatusUnauthorized},
+		{scopeReadList, "a2sk_readonly_wrong", http.StatusUnauthorized},
+		{scopeReadList, "garbage", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		r, _ := http.NewRequest("GET", formatURL("query"), nil)
+		if tt.key != "" {
+			r.Header.Set("X-API-Key", tt.key)
+		}
+		w := newRecorder()
+		requireScope(ok, tt.scope).ServeHTTP(w, r)
+		if w.Code != tt.expected {
+			t.Errorf("Expected status code %v for scope %s with key '%s'; got: %v",
+				tt.expected, tt.scope, tt.key, w.Code)
+		}
+	}
+
+	// unknown key IDs aren't cached
+	apiKeyCacheLen := func() int {
+		apiKeyCache.Lock()
+		defer apiKeyCache.Unlock()
+		return len(apiKeyCache.keys)
+	}
+	cached := apiKeyCacheLen()
+	for _, id := range []string{"unknown1", "unknown2", "unknown3"} {
+		if k, _ := authenticateAPIKey(apiKeyPrefix + id + "_secret"); k != nil {
+			t.Fatalf("Expected unknown key %s to be rejected", id)
+		}
+	}
+	if n := apiKeyCacheLen(); n != cached {
+		t.Errorf("Expected unknown keys not to be cached, got %d cached keys (was %d)",
+			n, cached)
+	}
+
+	// not enforced unless keys are required
+	config.Get().AdminConfig.RequireAPIKeys = false
+	r, _ := http.NewRequest("GET", formatURL("query"), nil)
+	w := newRecorder()
+	requireScope(ok, scopeQueryDirect).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status code %v when keys are not required; got: %v",
+			http.StatusOK, w.Code)
+	}
+}
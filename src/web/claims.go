@@ -0,0 +1,238 @@
+package web
+
+// claims.go - Handler functions for server ownership claims
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/db"
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/models"
+	"github.com/syncore/a2sapi/src/util"
+)
+
+const (
+	claimTokenPrefix     = "a2sapi-"
+	maxClaimAliasLen     = 64
+	maxClaimDescLen      = 256
+	maxClaimNotifyURLLen = 512
+)
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// redactNotifyURL returns a notification URL without its user info, query, and
+// fragment, which may hold credentials, for the audit log.
+func redactNotifyURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "<invalid>"
+	}
+	u.User, u.RawQuery, u.ForceQuery, u.Fragment = nil, "", false, ""
+	return u.String()
+}
+
+// validateNotifyURL returns an error if a notification URL isn't an http or
+// https URL of a host with only public addresses, so that claims can't be used
+// to send requests to the API's own network.
+func validateNotifyURL(ctx context.Context, s string) error {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") ||
+		u.Hostname() == "" {
+		return errors.New("Notification URL must be an http or https URL.")
+	}
+	if err := util.CheckPublicHost(ctx, u.Hostname()); err != nil {
+		logger.LogWebErrorf("Rejected notification URL host: %s", err)
+		return errors.New("Notification URL must be on a public address.")
+	}
+	return nil
+}
+
+func hashOwnerKey(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])
+}
+
+// getClaimServerID validates that claims are enabled and returns the server ID
+// from the request; if unsuccessful, an error is written to w.
+func getClaimServerID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if !config.Get().WebConfig.EnableServerClaims {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w,
+			`{"error": {"code": 400,"message": "Server claims are disabled."}}`)
+		return 0, false
+	}
+	val, _ := getQStringValue(r.URL.Query(), qsClaimServerID)
+	id, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || id <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w,
+			`{"error": {"code": 400,"message": "A valid server ID must be specified with the %s parameter."}}`,
+			qsClaimServerID)
+		return 0, false
+	}
+	return id, true
+}
+
+func writeClaimNotFound(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusNotFound)
+	fmt.Fprintf(w, `{"error": {"code": 404,"message": "No claim exists for that server ID."}}`)
+}
+
+func startClaim(w http.ResponseWriter, r *http.Request) {
+	id, ok := getClaimServerID(w, r)
+	if !ok {
+		return
+	}
+	exists, err := db.ServerDB.ServerIDExists(id)
+	if err != nil {
+		setNotFoundAndLog(w, err)
+		fmt.Fprintf(w, `{"error": {"code": 404,"message": "Unable to start claim."}}`)
+		return
+	}
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, `{"error": {"code": 404,"message": "Unknown server ID."}}`)
+		return
+	}
+	claim, err := db.AppDB.GetClaim(id)
+	if err != nil {
+		setNotFoundAndLog(w, err)
+		fmt.Fprintf(w, `{"error": {"code": 404,"message": "Unable to start claim."}}`)
+		return
+	}
+	if claim != nil && claim.Verified {
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprintf(w,
+			`{"error": {"code": 409,"message": "This server has already been claimed."}}`)
+		return
+	}
+	token, terr := randomHex(6)
+	key, kerr := randomHex(16)
+	if terr != nil || kerr != nil {
+		logger.LogWebErrorf("Unable to generate claim token for server ID %d", id)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, `{"error": {"code": 500,"message": "Unable to start claim."}}`)
+		return
+	}
+	token = claimTokenPrefix + token
+	started, err := db.AppDB.StartClaim(id, token, hashOwnerKey(key))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, `{"error": {"code": 500,"message": "Unable to start claim."}}`)
+		return
+	}
+	if !started {
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprintf(w,
+			`{"error": {"code": 409,"message": "This server already has a pending claim."}}`)
+		return
+	}
+	db.AppDB.AddAuditEntry(r.RemoteAddr, "claim.start", db.ClaimAuditTarget(id), "")
+	writeJSONResponse(w, models.ServerClaimToken{
+		ID:       id,
+		Token:    token,
+		OwnerKey: key,
+		Instructions: fmt.Sprintf(
+			"Add %s to the server's hostname or to a server rule (cvar), then wait for the next server list retrieval. Keep the owner key; it is required to edit the server's details and is not shown again.",
+			token),
+	})
+}
+
+func getClaim(w http.ResponseWriter, r *http.Request) {
+	id, ok := getClaimServerID(w, r)
+	if !ok {
+		return
+	}
+	claim, err := db.AppDB.GetClaim(id)
+	if err != nil {
+		setNotFoundAndLog(w, err)
+		fmt.Fprintf(w, `{"error": {"code": 404,"message": "Unable to get claim."}}`)
+		return
+	}
+	if claim == nil {
+		writeClaimNotFound(w)
+		return
+	}
+	writeJSONResponse(w, claim)
+}
+
+func updateClaim(w http.ResponseWriter, r *http.Request) {
+	id, ok := getClaimServerID(w, r)
+	if !ok {
+		return
+	}
+	claim, err := db.AppDB.GetClaim(id)
+	if err != nil {
+		setNotFoundAndLog(w, err)
+		fmt.Fprintf(w, `{"error": {"code": 404,"message": "Unable to update claim."}}`)
+		return
+	}
+	if claim == nil || !claim.Verified {
+		writeClaimNotFound(w)
+		return
+	}
+	key := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(hashOwnerKey(key)),
+		[]byte(claim.OwnerKeyHash)) != 1 {
+		db.AppDB.AddAuditEntry(r.RemoteAddr, "claim.update.denied", db.ClaimAuditTarget(id),
+			"")
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprintf(w, `{"error": {"code": 401,"message": "Unauthorized."}}`)
+		return
+	}
+
+	q := r.URL.Query()
+	if v, ok := getQStringValue(q, qsClaimAlias); ok {
+		claim.Alias = v
+	}
+	if v, ok := getQStringValue(q, qsClaimDescription); ok {
+		claim.Description = v
+	}
+	if v, ok := getQStringValue(q, qsClaimNotifyURL); ok {
+		claim.NotifyURL = v
+	}
+	if utf8.RuneCountInString(claim.Alias) > maxClaimAliasLen ||
+		utf8.RuneCountInString(claim.Description) > maxClaimDescLen ||
+		len(claim.NotifyURL) > maxClaimNotifyURLLen {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w,
+			`{"error": {"code": 400,"message": "Alias, description, or notification URL is too long."}}`)
+		return
+	}
+	if claim.NotifyURL != "" {
+		if err := validateNotifyURL(r.Context(), claim.NotifyURL); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `{"error": {"code": 400,"message": "%s"}}`, err)
+			return
+		}
+	}
+	if err := db.AppDB.UpdateClaimMetadata(id, claim.Alias, claim.Description,
+		claim.NotifyURL); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, `{"error": {"code": 500,"message": "Unable to update claim."}}`)
+		return
+	}
+	db.AppDB.AddAuditEntry(r.RemoteAddr, "claim.update", db.ClaimAuditTarget(id),
+		fmt.Sprintf("alias=%q notifyURL=%q", claim.Alias,
+			redactNotifyURL(claim.NotifyURL)))
+	writeJSONResponse(w, claim)
+}
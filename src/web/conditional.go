@@ -0,0 +1,102 @@
+package web
+
+// conditional.go - ETag and Last-Modified headers for server list responses,
+// and handling of conditional requests, so that clients that poll more often
+// than the list is retrieved receive 304 (not modified) responses
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/models"
+)
+
+// serverListModified returns the time that a server list (as it is returned,
+// i.e. after refreshing and marking stale servers) last changed: when it was
+// retrieved, when a server was last refreshed, or when a server became stale.
+func serverListModified(sl *models.APIServerList) time.Time {
+	age := int64(config.Get().WebConfig.StaleServerAge)
+	modified := sl.RetrievedTimeStamp
+	for _, s := range sl.Servers {
+		updated := sl.RetrievedTimeStamp
+		if s.RefreshedTimeStamp != 0 {
+			updated = s.RefreshedTimeStamp
+		}
+		if s.Status == models.ServerStatusStale {
+			updated += age
+		}
+		if updated > modified {
+			modified = updated
+		}
+	}
+	return time.Unix(modified, 0)
+}
+
+// serverListETag returns the (weak) entity tag of a server list response: a
+// hash of the list's modification time and retrieval cycle(s), and of the
+// request's parameters and negotiated format, which select the servers and the
+// format.
+func serverListETag(sl *models.APIServerList, modified time.Time,
+	r *http.Request) string {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d\x00%s\x00%s\x00%s", modified.Unix(), sl.CycleID,
+		r.URL.RawQuery, getFormat(r))
+	games := make([]string, 0, len(sl.CycleIDs))
+	for g := range sl.CycleIDs {
+		games = append(games, g)
+	}
+	sort.Strings(games)
+	for _, g := range games {
+		fmt.Fprintf(h, "\x00%s=%s", g, sl.CycleIDs[g])
+	}
+	// weak, since the response may or may not be compressed
+	return fmt.Sprintf(`W/"%x"`, h.Sum64())
+}
+
+// etagMatches returns true if the If-None-Match header value matches etag,
+// using the weak comparison.
+func etagMatches(header, etag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == strings.TrimPrefix(etag,
+			"W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// checkNotModified sets the ETag and Last-Modified headers of a server list
+// response. If the request's conditions show that the client already has the
+// list, it writes a 304 response and returns true.
+func checkNotModified(w http.ResponseWriter, r *http.Request,
+	sl *models.APIServerList) bool {
+	if sl.RetrievedTimeStamp == 0 {
+		return false
+	}
+	modified := serverListModified(sl)
+	etag := serverListETag(sl, modified, r)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+
+	notModified := false
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		// If-Modified-Since is ignored when If-None-Match is present
+		notModified = etagMatches(inm, etag)
+	} else if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		if t, err := http.ParseTime(ims); err == nil {
+			notModified = !modified.After(t)
+		}
+	}
+	if !notModified {
+		return false
+	}
+	w.Header().Del("Content-Type")
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
@@ -0,0 +1,66 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/syncore/a2sapi/src/models"
+)
+
+func TestCheckNotModified(t *testing.T) {
+	retrieved := time.Now().Add(-time.Minute).Unix()
+	sl := &models.APIServerList{CycleID: "cycle-1", RetrievedTimeStamp: retrieved,
+		Servers: []models.APIServer{{Host: "10.0.0.1:27960",
+			Status: models.ServerStatusOnline}}}
+
+	r, _ := http.NewRequest("GET", "/servers?game=QuakeLive", nil)
+	w := httptest.NewRecorder()
+	if checkNotModified(w, r, sl) {
+		t.Fatalf("Expected unconditional request to be served")
+	}
+	etag := w.Header().Get("ETag")
+	lastModified := w.Header().Get("Last-Modified")
+	if etag == "" || lastModified == "" {
+		t.Fatalf("Expected ETag and Last-Modified headers, got: %v", w.Header())
+	}
+
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	if !checkNotModified(w, r, sl) || w.Code != http.StatusNotModified {
+		t.Fatalf("Expected 304 for matching ETag, got: %d", w.Code)
+	}
+	// other parameters are a different response
+	r2, _ := http.NewRequest("GET", "/servers?game=QuakeLive&view=compact", nil)
+	r2.Header.Set("If-None-Match", etag)
+	if checkNotModified(httptest.NewRecorder(), r2, sl) {
+		t.Fatalf("Expected ETag to differ for different parameters")
+	}
+
+	r.Header.Del("If-None-Match")
+	r.Header.Set("If-Modified-Since", lastModified)
+	if !checkNotModified(httptest.NewRecorder(), r, sl) {
+		t.Fatalf("Expected 304 for unmodified list")
+	}
+
+	// the next retrieval, or a server becoming stale, changes the list
+	next := *sl
+	next.CycleID, next.RetrievedTimeStamp = "cycle-2", retrieved+30
+	if checkNotModified(httptest.NewRecorder(), r, &next) {
+		t.Fatalf("Expected list from the next retrieval to be modified")
+	}
+	stale := *sl
+	stale.Servers = []models.APIServer{{Host: "10.0.0.1:27960",
+		Status: models.ServerStatusStale}}
+	r.Header.Del("If-Modified-Since")
+	r.Header.Set("If-None-Match", etag)
+	if checkNotModified(httptest.NewRecorder(), r, &stale) {
+		t.Fatalf("Expected list with a stale server to be modified")
+	}
+
+	// empty lists (i.e. before the first retrieval) aren't cached
+	if checkNotModified(httptest.NewRecorder(), r, &models.APIServerList{}) {
+		t.Fatalf("Expected empty list to be served")
+	}
+}
@@ -0,0 +1,50 @@
+package web
+
+// diagnose.go - Diagnosis of a server that isn't answering queries or isn't
+// listed, for server admins debugging listing problems
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/steam"
+)
+
+// diagnoseHost probes a server; see steam.DiagnoseHost.
+var diagnoseHost = steam.DiagnoseHost
+
+func diagnoseServer(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if !config.Get().WebConfig.AllowDirectUserQueries {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w,
+			`{"error": {"code": 400,"message": "Direct server queries are disabled."}}`)
+		return
+	}
+	addr, err := net.ResolveTCPAddr("tcp4", r.URL.Query().Get(qsDiagnoseHost))
+	if err != nil || addr.IP == nil || addr.Port == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w,
+			`{"error": {"code": 400,"message": "The host must be an address of the form ip:port."}}`)
+		return
+	}
+	if !isDirectQueryAllowed(addr.IP) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w,
+			`{"error": {"code": 403,"message": "The requested host is not allowed to be queried."}}`)
+		return
+	}
+	host := fmt.Sprintf("%s:%d", addr.IP, addr.Port)
+	d, err := diagnoseHost(r.Context(), host)
+	if err != nil {
+		logger.LogWebErrorf("Unable to diagnose %s: %s", host, err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w,
+			`{"error": {"code": 503,"message": "Unable to diagnose the server."}}`)
+		return
+	}
+	writeJSONResponse(w, d)
+}
@@ -0,0 +1,73 @@
+package web
+
+// Tests for the server diagnosis
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/models"
+)
+
+func TestDiagnoseServer(t *testing.T) {
+	origDiagnose := diagnoseHost
+	defer func() { diagnoseHost = origDiagnose }()
+	var diagnosed string
+	diagnoseHost = func(ctx context.Context, host string) (*models.APIDiagnosis,
+		error) {
+		diagnosed = host
+		return &models.APIDiagnosis{Host: host, Status: models.DiagnosisOK}, nil
+	}
+	origAllow := config.Get().WebConfig.AllowDirectUserQueries
+	defer func() { config.Get().WebConfig.AllowDirectUserQueries = origAllow }()
+	config.Get().WebConfig.AllowDirectUserQueries = true
+	defer func() { directQueryAllowedNets, directQueryDeniedNets = nil, nil }()
+	directQueryDeniedNets = parseNetworks([]string{"192.0.2.1"}, "test")
+
+	for _, tt := range []struct {
+		host string
+		code int
+	}{
+		{"", http.StatusBadRequest},
+		{"192.0.2.10", http.StatusBadRequest},
+		{"192.0.2.10:0", http.StatusBadRequest},
+		{"192.0.2.1:27015", http.StatusForbidden},
+		{"192.0.2.10:27015", http.StatusOK},
+	} {
+		diagnosed = ""
+		r, _ := http.NewRequest("GET", formatURL("diagnose?host="+tt.host), nil)
+		w := newRecorder()
+		diagnoseServer(w, r)
+		if w.Code != tt.code {
+			t.Fatalf("Expected status code %d for host '%s', got: %d", tt.code,
+				tt.host, w.Code)
+		}
+		if tt.code != http.StatusOK {
+			if diagnosed != "" {
+				t.Fatalf("Expected host '%s' not to be diagnosed", tt.host)
+			}
+			continue
+		}
+		var d models.APIDiagnosis
+		if err := json.Unmarshal(w.Body.Bytes(), &d); err != nil {
+			t.Fatalf("Unable to decode diagnosis: %s", err)
+		}
+		if diagnosed != tt.host || d.Host != tt.host ||
+			d.Status != models.DiagnosisOK {
+			t.Fatalf("Expected a diagnosis of %s, got: %+v", tt.host, d)
+		}
+	}
+
+	config.Get().WebConfig.AllowDirectUserQueries = false
+	r, _ := http.NewRequest("GET", formatURL("diagnose?host=192.0.2.10:27015"),
+		nil)
+	w := newRecorder()
+	diagnoseServer(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status code %d with direct queries disabled, got: %d",
+			http.StatusBadRequest, w.Code)
+	}
+}
@@ -0,0 +1,149 @@
+package web
+
+// feeds.go - Atom feed of recent server events (servers going offline, coming
+// back online, and changing maps), for communities that want feed-based
+// notifications without running a webhook receiver.
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/db"
+	"github.com/syncore/a2sapi/src/models"
+)
+
+const (
+	// defaultEventFeedSize is the number of entries in the feed if no limit is
+	// requested
+	defaultEventFeedSize = 50
+	// maxEventFeedSize is the maximum number of entries that can be requested
+	maxEventFeedSize = 500
+	atomNamespace    = "http://www.w3.org/2005/Atom"
+)
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"feed"`
+	Xmlns   string      `xml:"xmlns,attr"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	ID       string       `xml:"id"`
+	Title    string       `xml:"title"`
+	Updated  string       `xml:"updated"`
+	Category atomCategory `xml:"category"`
+	Content  atomContent  `xml:"content"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+func atomTime(ts int64) string {
+	return time.Unix(ts, 0).UTC().Format(time.RFC3339)
+}
+
+// eventTitle returns the human-readable summary of a server event.
+func eventTitle(e models.DbServerEvent) string {
+	switch e.Event {
+	case models.ServerEventOffline:
+		return fmt.Sprintf("%s server %s went offline", e.Game, e.Host)
+	case models.ServerEventOnline:
+		return fmt.Sprintf("%s server %s is back online", e.Game, e.Host)
+	case models.ServerEventMapChange:
+		return fmt.Sprintf("%s server %s changed map: %s", e.Game, e.Host, e.Detail)
+	}
+	return fmt.Sprintf("%s server %s: %s", e.Game, e.Host, e.Event)
+}
+
+// buildEventFeed builds the Atom feed of the given events, which are expected
+// to be ordered newest first.
+func buildEventFeed(game string, events []models.DbServerEvent) ([]byte, error) {
+	feed := atomFeed{
+		Xmlns:   atomNamespace,
+		ID:      "urn:a2sapi:events",
+		Title:   "a2sapi server events",
+		Updated: atomTime(time.Now().Unix()),
+		Author:  atomAuthor{Name: "a2sapi"},
+		Entries: make([]atomEntry, 0, len(events)),
+	}
+	if game != "" {
+		feed.ID += ":" + strings.ToLower(game)
+		feed.Title = game + " server events"
+	}
+	if len(events) > 0 {
+		feed.Updated = atomTime(events[0].Timestamp)
+	}
+	for _, e := range events {
+		content := fmt.Sprintf("Game: %s\nAddress: %s\nServer ID: %d\nEvent: %s",
+			e.Game, e.Host, e.ID, e.Event)
+		if e.Detail != "" {
+			content += "\nDetail: " + e.Detail
+		}
+		feed.Entries = append(feed.Entries, atomEntry{
+			ID: fmt.Sprintf("urn:a2sapi:event:%s:%s:%s:%d", strings.ToLower(e.Game),
+				e.Host, e.Event, e.Timestamp),
+			Title:    eventTitle(e),
+			Updated:  atomTime(e.Timestamp),
+			Category: atomCategory{Term: e.Event},
+			Content:  atomContent{Type: "text", Body: content},
+		})
+	}
+	b, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), b...), nil
+}
+
+func getEventFeed(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if !config.Get().WebConfig.EnableEventFeed || db.AppDB == nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w,
+			`{"error": {"code": 404,"message": "The event feed is disabled."}}`)
+		return
+	}
+	q := r.URL.Query()
+	limit, err := getQStringInt(q, qsPageLimit, defaultEventFeedSize, 1,
+		maxEventFeedSize)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"error": {"code": 400,"message": "%s"}}`, err)
+		return
+	}
+	game, _ := getQStringValue(q, qsServersGame)
+	events, err := db.AppDB.GetServerEvents(game, limit)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w,
+			`{"error": {"code": 500,"message": "Unable to retrieve events."}}`)
+		return
+	}
+	b, err := buildEventFeed(game, events)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w,
+			`{"error": {"code": 500,"message": "Unable to build event feed."}}`)
+		return
+	}
+	w.Header().Set("Content-Type", "application/atom+xml; charset=UTF-8")
+	w.Write(b)
+}
@@ -0,0 +1,48 @@
+package web
+
+// Tests for the Atom feed of server events
+
+import (
+	"encoding/xml"
+	"testing"
+
+	"github.com/syncore/a2sapi/src/models"
+)
+
+func TestBuildEventFeed(t *testing.T) {
+	events := []models.DbServerEvent{
+		{ID: 2, Host: "10.0.0.2:27960", Game: "QuakeLive",
+			Event: models.ServerEventMapChange, Detail: "bloodrun -> campgrounds",
+			Timestamp: 1500000120},
+		{ID: 1, Host: "10.0.0.1:27960", Game: "QuakeLive",
+			Event: models.ServerEventOffline, Detail: models.ServerStatusTimedOut,
+			Timestamp: 1500000060},
+	}
+	b, err := buildEventFeed("QuakeLive", events)
+	if err != nil {
+		t.Fatalf("Unable to build event feed: %s", err)
+	}
+	feed := atomFeed{}
+	if err := xml.Unmarshal(b, &feed); err != nil {
+		t.Fatalf("Unable to decode event feed: %s", err)
+	}
+	if feed.XMLName.Space != atomNamespace {
+		t.Errorf("Expected Atom namespace, got: %s", feed.XMLName.Space)
+	}
+	if feed.Updated != "2017-07-14T02:42:00Z" {
+		t.Errorf("Expected feed updated at newest event, got: %s", feed.Updated)
+	}
+	if len(feed.Entries) != 2 {
+		t.Fatalf("Expected 2 entries, got: %d", len(feed.Entries))
+	}
+	if feed.Entries[0].Title !=
+		"QuakeLive server 10.0.0.2:27960 changed map: bloodrun -> campgrounds" {
+		t.Errorf("Unexpected entry title: %s", feed.Entries[0].Title)
+	}
+	if feed.Entries[1].Category.Term != models.ServerEventOffline {
+		t.Errorf("Expected offline category, got: %s", feed.Entries[1].Category.Term)
+	}
+	if feed.Entries[0].ID == feed.Entries[1].ID {
+		t.Errorf("Expected unique entry IDs, got: %s", feed.Entries[0].ID)
+	}
+}
@@ -0,0 +1,52 @@
+package web
+
+// games.go - The games that the API knows about, with their details from Steam
+// for frontends' game selectors
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/syncore/a2sapi/src/models"
+	"github.com/syncore/a2sapi/src/steam"
+	"github.com/syncore/a2sapi/src/steam/filters"
+)
+
+// gameMetadataWait is the longest that a request waits for games' details to be
+// retrieved from Steam; details that take longer are returned by later requests.
+const gameMetadataWait = 5 * time.Second
+
+// getAppMetadata returns games' Steam details; see steam.GetAppMetadata.
+var getAppMetadata = steam.GetAppMetadata
+
+func getGames(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	games := filters.ReadGames()
+	var appids []uint64
+	for _, g := range games {
+		if g.AppID != 0 {
+			appids = append(appids, g.AppID)
+		}
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), gameMetadataWait)
+	defer cancel()
+	meta := getAppMetadata(ctx, appids)
+
+	gl := models.APIGameList{Games: make([]models.APIGame, 0, len(games))}
+	for _, g := range games {
+		game := models.APIGame{Name: g.Name, AppID: g.AppID}
+		if sl := models.GetGameList(g.Name); sl != nil {
+			game.ServerCount = len(sl.Servers)
+			for _, s := range sl.Servers {
+				game.PlayerCount += int(s.Info.Players)
+			}
+		}
+		if m, ok := meta[g.AppID]; ok && g.AppID != 0 {
+			game.Steam = &m
+		}
+		gl.Games = append(gl.Games, game)
+	}
+	gl.GameCount = len(gl.Games)
+	writeJSONResponse(w, gl)
+}
@@ -0,0 +1,58 @@
+package web
+
+// Tests for the games endpoint
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/syncore/a2sapi/src/models"
+	"github.com/syncore/a2sapi/src/steam/filters"
+)
+
+func TestGetGames(t *testing.T) {
+	origMetadata := getAppMetadata
+	defer func() { getAppMetadata = origMetadata }()
+	getAppMetadata = func(ctx context.Context,
+		appids []uint64) map[uint64]models.SteamAppMetadata {
+		m := make(map[uint64]models.SteamAppMetadata)
+		for _, id := range appids {
+			if id == filters.GameQuakeLive.AppID {
+				m[id] = models.SteamAppMetadata{StoreName: "Quake Live",
+					CurrentPlayers: 100}
+			}
+		}
+		return m
+	}
+
+	r, _ := http.NewRequest("GET", formatURL("games"), nil)
+	w := newRecorder()
+	getGames(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got: %d", http.StatusOK, w.Code)
+	}
+	var gl models.APIGameList
+	if err := json.Unmarshal(w.Body.Bytes(), &gl); err != nil {
+		t.Fatalf("Unable to decode games: %s", err)
+	}
+	if gl.GameCount == 0 || gl.GameCount != len(gl.Games) {
+		t.Fatalf("Expected the game count to match the games, got: %+v", gl)
+	}
+	found := false
+	for _, g := range gl.Games {
+		if g.AppID == filters.GameQuakeLive.AppID {
+			found = true
+			if g.Steam == nil || g.Steam.StoreName != "Quake Live" ||
+				g.Steam.CurrentPlayers != 100 {
+				t.Fatalf("Expected Quake Live's Steam details, got: %+v", g.Steam)
+			}
+		} else if g.Steam != nil {
+			t.Fatalf("Expected no Steam details for %s, got: %+v", g.Name, g.Steam)
+		}
+	}
+	if !found {
+		t.Fatalf("Expected Quake Live in the games, got: %+v", gl.Games)
+	}
+}
@@ -0,0 +1,329 @@
+package web
+
+// grpc.go - Minimal server side of gRPC over HTTP/2 without TLS, as needed to
+// serve the API to clients that prefer typed RPCs (see grpcapi.go and
+// a2sapi.proto): length-prefixed protobuf messages, unary and server-streaming
+// methods, deadlines, and statuses sent as trailers. Compressed messages are
+// not supported.
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/logger"
+)
+
+const (
+	grpcContentType = "application/grpc"
+	// largest request message accepted (the gRPC default)
+	grpcMaxRequestSize = 4 << 20
+)
+
+// gRPC status codes
+const (
+	grpcOK                 = 0
+	grpcUnknown            = 2
+	grpcInvalidArgument    = 3
+	grpcDeadlineExceeded   = 4
+	grpcNotFound           = 5
+	grpcPermissionDenied   = 7
+	grpcResourceExhausted  = 8
+	grpcFailedPrecondition = 9
+	grpcUnimplemented      = 12
+	grpcInternal           = 13
+	grpcUnavailable        = 14
+	grpcUnauthenticated    = 16
+)
+
+// grpcError is the status that a method failed with.
+type grpcError struct {
+	code    int
+	message string
+}
+
+func (e *grpcError) Error() string {
+	return fmt.Sprintf("gRPC status %d: %s", e.code, e.message)
+}
+
+// grpcStatusFromHTTP returns the gRPC status for an HTTP error response, with
+// the message of its JSON error, if it has one.
+func grpcStatusFromHTTP(code int, body []byte) *grpcError {
+	var e struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	msg := http.StatusText(code)
+	if json.Unmarshal(body, &e) == nil && e.Error.Message != "" {
+		msg = e.Error.Message
+	}
+	switch code {
+	case http.StatusBadRequest:
+		return &grpcError{grpcInvalidArgument, msg}
+	case http.StatusUnauthorized:
+		return &grpcError{grpcUnauthenticated, msg}
+	case http.StatusForbidden:
+		return &grpcError{grpcPermissionDenied, msg}
+	case http.StatusNotFound:
+		return &grpcError{grpcNotFound, msg}
+	case http.StatusGone:
+		return &grpcError{grpcFailedPrecondition, msg}
+	case http.StatusTooManyRequests:
+		return &grpcError{grpcResourceExhausted, msg}
+	case http.StatusServiceUnavailable:
+		return &grpcError{grpcUnavailable, msg}
+	}
+	if code >= 500 {
+		return &grpcError{grpcInternal, msg}
+	}
+	return &grpcError{grpcUnknown, msg}
+}
+
+// isGRPCRequest returns true if the request is a gRPC call.
+func isGRPCRequest(r *http.Request) bool {
+	ct := r.Header.Get("Content-Type")
+	return r.Method == "POST" && r.ProtoMajor == 2 &&
+		(ct == grpcContentType || strings.HasPrefix(ct, grpcContentType+"+") ||
+			strings.HasPrefix(ct, grpcContentType+";"))
+}
+
+// parseGRPCTimeout parses the grpc-timeout header: at most 8 digits followed by
+// a unit (H, M, S, m, u, or n).
+func parseGRPCTimeout(s string) (time.Duration, bool) {
+	if len(s) < 2 || len(s) > 9 {
+		return 0, false
+	}
+	units := map[byte]time.Duration{'H': time.Hour, 'M': time.Minute,
+		'S': time.Second, 'm': time.Millisecond, 'u': time.Microsecond,
+		'n': time.Nanosecond}
+	unit, ok := units[s[len(s)-1]]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
+
+// readGRPCRequest reads and decodes a call's request message.
+func readGRPCRequest(r *http.Request) (pbFields, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(r.Body, hdr[:]); err != nil {
+		return nil, &grpcError{grpcInvalidArgument, "Missing request message."}
+	}
+	if hdr[0] != 0 {
+		return nil, &grpcError{grpcUnimplemented,
+			"Compressed messages are not supported."}
+	}
+	n := binary.BigEndian.Uint32(hdr[1:])
+	if n > grpcMaxRequestSize {
+		return nil, &grpcError{grpcResourceExhausted, "Request message too large."}
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r.Body, b); err != nil {
+		return nil, &grpcError{grpcInvalidArgument, "Truncated request message."}
+	}
+	req, err := decodePB(b)
+	if err != nil {
+		return nil, &grpcError{grpcInvalidArgument, "Invalid request message."}
+	}
+	return req, nil
+}
+
+// startGRPCResponse sends the headers of a call's response.
+func startGRPCResponse(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", grpcContentType)
+	w.WriteHeader(http.StatusOK)
+}
+
+// writeGRPCMessage sends a response message.
+func writeGRPCMessage(w http.ResponseWriter, msg []byte) error {
+	var hdr [5]byte
+	binary.BigEndian.PutUint32(hdr[1:], uint32(len(msg)))
+	if _, err := w.Write(append(hdr[:], msg...)); err != nil {
+		return err
+	}
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// finishGRPCResponse sets the trailers with the call's status: OK if err is
+// nil, the status of a grpcError, or otherwise an internal error.
+func finishGRPCResponse(w http.ResponseWriter, err error) {
+	status := &grpcError{code: grpcOK}
+	if err != nil && !errors.As(err, &status) {
+		logger.LogWebErrorf("gRPC call failed: %s", err)
+		status = &grpcError{grpcInternal, "Internal error."}
+	}
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(status.code))
+	if status.message != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message",
+			encodeGRPCMessage(status.message))
+	}
+}
+
+// writeGRPCError responds to a call that failed before its response started.
+func writeGRPCError(w http.ResponseWriter, err error) {
+	startGRPCResponse(w)
+	finishGRPCResponse(w, err)
+}
+
+// encodeGRPCMessage percent-encodes a status message for the grpc-message
+// trailer.
+func encodeGRPCMessage(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		if c := msg[i]; c < 0x20 || c > 0x7E || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// grpcStreamWriter passes a streaming method's response through, but keeps the
+// HTTP error responses of the limits and API key checks in front of the method
+// so that they can be sent as gRPC statuses instead.
+type grpcStreamWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *grpcStreamWriter) WriteHeader(code int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = code
+	if code == http.StatusOK {
+		w.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (w *grpcStreamWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.status != http.StatusOK {
+		return w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *grpcStreamWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok && w.status == http.StatusOK {
+		f.Flush()
+	}
+}
+
+// grpcRecorder keeps the response to the REST request that a unary method is
+// served with.
+type grpcRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *grpcRecorder) Header() http.Header {
+	return w.header
+}
+
+func (w *grpcRecorder) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *grpcRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
+
+// ServeHTTP serves the gRPC API's calls.
+func (g *grpcServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !isGRPCRequest(r) {
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		fmt.Fprintf(w,
+			`{"error": {"code": 415,"message": "This listener only serves gRPC calls."}}`)
+		return
+	}
+	if d, ok := parseGRPCTimeout(r.Header.Get("Grpc-Timeout")); ok {
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
+	if r.URL.Path == grpcServicePath+"WatchServers" {
+		sw := &grpcStreamWriter{ResponseWriter: w}
+		g.watch.ServeHTTP(sw, r)
+		if sw.status != http.StatusOK && sw.status != 0 {
+			writeGRPCError(w, grpcStatusFromHTTP(sw.status, sw.body.Bytes()))
+		}
+		return
+	}
+	method, ok := grpcUnaryMethods[strings.TrimPrefix(r.URL.Path,
+		grpcServicePath)]
+	if !ok || !strings.HasPrefix(r.URL.Path, grpcServicePath) {
+		writeGRPCError(w, &grpcError{grpcUnimplemented,
+			"Unknown method: " + r.URL.Path})
+		return
+	}
+	req, err := readGRPCRequest(r)
+	if err != nil {
+		writeGRPCError(w, err)
+		return
+	}
+	resp, err := method(g, w, r, req)
+	if err == nil && r.Context().Err() == context.DeadlineExceeded {
+		err = &grpcError{grpcDeadlineExceeded, "Deadline exceeded."}
+	}
+	startGRPCResponse(w)
+	if err == nil {
+		err = writeGRPCMessage(w, resp)
+	}
+	finishGRPCResponse(w, err)
+}
+
+// startGRPC serves the gRPC API on the configured port, passing unary calls to
+// the API's router, until ctx is cancelled.
+func startGRPC(ctx context.Context, api http.Handler, runSilent bool) {
+	addr := net.JoinHostPort(config.Get().WebConfig.APIWebListenAddress,
+		strconv.Itoa(config.Get().WebConfig.GRPCPort))
+	if !runSilent {
+		fmt.Printf("gRPC API: enabled on %s\n", addr)
+	}
+	logger.LogAppInfo("Starting gRPC server on %s", addr)
+	// gRPC clients connect with HTTP/2 directly, without TLS
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	srv := http.Server{
+		Addr:              addr,
+		Handler:           newGRPCServer(ctx, api),
+		Protocols:         protocols,
+		ReadHeaderTimeout: 30 * time.Second,
+		MaxHeaderBytes:    1 << 20}
+	stopped := shutdownOnDone(ctx, &srv, "gRPC server")
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		logger.LogAppErrorf("Unable to start gRPC server: %s", err)
+		return
+	}
+	<-stopped
+}
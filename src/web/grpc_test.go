@@ -0,0 +1,206 @@
+package web
+
+// Tests for the gRPC API
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/models"
+)
+
+// newGRPCTestServer starts a gRPC server in front of the API's router, and
+// returns it with a client that speaks HTTP/2 without TLS.
+func newGRPCTestServer(ctx context.Context) (*httptest.Server, *http.Client) {
+	ts := httptest.NewUnstartedServer(newGRPCServer(ctx, newRouter()))
+	ts.Config.Protocols = new(http.Protocols)
+	ts.Config.Protocols.SetUnencryptedHTTP2(true)
+	ts.Start()
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	return ts, &http.Client{Transport: &http.Transport{Protocols: protocols}}
+}
+
+// startGRPCCall calls a method with the request message req.
+func startGRPCCall(t *testing.T, ts *httptest.Server, c *http.Client,
+	method string, req []byte) *http.Response {
+	var hdr [5]byte
+	binary.BigEndian.PutUint32(hdr[1:], uint32(len(req)))
+	r, _ := http.NewRequest("POST", ts.URL+grpcServicePath+method,
+		bytes.NewReader(append(hdr[:], req...)))
+	r.Header.Set("Content-Type", grpcContentType)
+	r.Header.Set("TE", "trailers")
+	resp, err := c.Do(r)
+	if err != nil {
+		t.Fatalf("Unable to call %s: %s", method, err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.ProtoMajor != 2 {
+		t.Fatalf("Expected status code %d over HTTP/2, got: %d (%s)",
+			http.StatusOK, resp.StatusCode, resp.Proto)
+	}
+	return resp
+}
+
+// readGRPCMessage reads the next response message, returning false at the end
+// of the response.
+func readGRPCMessage(t *testing.T, br *bufio.Reader) (pbFields, bool) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(br, hdr[:]); err == io.EOF {
+		return nil, false
+	} else if err != nil {
+		t.Fatalf("Unable to read message: %s", err)
+	}
+	b := make([]byte, binary.BigEndian.Uint32(hdr[1:]))
+	if _, err := io.ReadFull(br, b); err != nil {
+		t.Fatalf("Unable to read message: %s", err)
+	}
+	msg, err := decodePB(b)
+	if err != nil {
+		t.Fatalf("Unable to decode message: %s", err)
+	}
+	return msg, true
+}
+
+// callGRPC calls a unary method, returning its response message (if any) and
+// status.
+func callGRPC(t *testing.T, ts *httptest.Server, c *http.Client,
+	method string, req []byte) (pbFields, string) {
+	resp := startGRPCCall(t, ts, c, method, req)
+	defer resp.Body.Close()
+	br := bufio.NewReader(resp.Body)
+	msg, _ := readGRPCMessage(t, br)
+	io.Copy(io.Discard, br)
+	return msg, resp.Trailer.Get("Grpc-Status")
+}
+
+func TestGRPCGetServers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ts, c := newGRPCTestServer(ctx)
+	defer ts.Close()
+
+	msg, status := callGRPC(t, ts, c, "GetServers", nil)
+	if status != "0" {
+		t.Fatalf("Expected status 0, got: %q", status)
+	}
+	// the same list as the REST API's
+	r, _ := http.NewRequest("GET", "/servers", nil)
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+	sl := &models.APIServerList{}
+	if err := json.Unmarshal(w.Body.Bytes(), sl); err != nil {
+		t.Fatalf("Unable to decode REST response: %s", err)
+	}
+	if sl.ServerCount == 0 {
+		t.Fatalf("Expected servers in the test server list")
+	}
+	if n := msg.intValue(5); n != int64(sl.ServerCount) ||
+		len(msg[6]) != sl.ServerCount {
+		t.Fatalf("Expected %d servers, got: %d (%d sent)", sl.ServerCount, n,
+			len(msg[6]))
+	}
+	srv, err := decodePB(msg[6][0].b)
+	if err != nil {
+		t.Fatalf("Unable to decode server: %s", err)
+	}
+	if addr := srv.stringValue(3); addr != sl.Servers[0].Host {
+		t.Fatalf("Expected first server %s, got: %s", sl.Servers[0].Host, addr)
+	}
+
+	if _, status := callGRPC(t, ts, c, "GetServers", appendPBInt(nil, 25,
+		-1)); status != "3" {
+		t.Fatalf("Expected status 3 for an invalid limit, got: %q", status)
+	}
+	if _, status := callGRPC(t, ts, c, "NoSuchMethod", nil); status != "12" {
+		t.Fatalf("Expected status 12 for an unknown method, got: %q", status)
+	}
+}
+
+func TestGRPCRequiresAPIKey(t *testing.T) {
+	prevRequire := config.Get().AdminConfig.RequireAPIKeys
+	prevAnon := config.Get().AdminConfig.AnonymousScopes
+	defer func() {
+		config.Get().AdminConfig.RequireAPIKeys = prevRequire
+		config.Get().AdminConfig.AnonymousScopes = prevAnon
+	}()
+	config.Get().AdminConfig.RequireAPIKeys = true
+	config.Get().AdminConfig.AnonymousScopes = []string{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ts, c := newGRPCTestServer(ctx)
+	defer ts.Close()
+	for _, method := range []string{"GetServers", "WatchServers"} {
+		if _, status := callGRPC(t, ts, c, method, nil); status != "16" {
+			t.Errorf("Expected status 16 for %s without an API key, got: %q",
+				method, status)
+		}
+	}
+}
+
+func TestGRPCWatchServers(t *testing.T) {
+	game := "GRPCWatchTest"
+	srv := func(host, m string) models.APIServer {
+		return models.APIServer{Host: host, Game: game,
+			CountryInfo: models.DbCountry{CountryCode: "DE"},
+			Info:        models.SteamServerInfo{Map: m}}
+	}
+	models.SetGameList(game, &models.APIServerList{Servers: []models.APIServer{
+		srv("10.0.0.1:27960", "bloodrun"), srv("10.0.0.2:27960", "campgrounds")}})
+	defer models.SetGameList(game, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ts, c := newGRPCTestServer(ctx)
+	defer ts.Close()
+
+	var req []byte
+	req = appendPBString(req, 1, "grpcwatchtest")
+	req = appendPBString(req, 3, "DE")
+	resp := startGRPCCall(t, ts, c, "WatchServers", req)
+	defer resp.Body.Close()
+	br := bufio.NewReader(resp.Body)
+
+	msg, ok := readGRPCMessage(t, br)
+	if !ok || msg.intValue(1) != grpcUpdateSnapshot ||
+		msg.stringValue(2) != "grpcwatchtest" || len(msg[5]) != 2 {
+		t.Fatalf("Expected snapshot with 2 servers, got: %v", msg)
+	}
+
+	models.SetGameList(game, &models.APIServerList{Servers: []models.APIServer{
+		srv("10.0.0.1:27960", "campgrounds"), srv("10.0.0.2:27960", "campgrounds"),
+		srv("10.0.0.3:27960", "bloodrun")}})
+	msg, ok = readGRPCMessage(t, br)
+	if !ok || msg.intValue(1) != grpcUpdateDelta || len(msg[6]) != 1 ||
+		len(msg[7]) != 0 || len(msg[8]) != 1 {
+		t.Fatalf("Expected delta with 1 added and 1 changed server, got: %v", msg)
+	}
+
+	// the stream ends when the server shuts down
+	cancel()
+	done := make(chan bool)
+	go func() {
+		_, ok := readGRPCMessage(t, br)
+		done <- ok
+	}()
+	select {
+	case ok := <-done:
+		if ok {
+			t.Fatalf("Expected the stream to end")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for the stream to end")
+	}
+	if status := resp.Trailer.Get("Grpc-Status"); status != "14" {
+		t.Fatalf("Expected status 14 on shutdown, got: %q", status)
+	}
+}
@@ -0,0 +1,398 @@
+package web
+
+// grpcapi.go - The gRPC API's methods (see a2sapi.proto). The unary methods are
+// served by the REST API's routes, so that they have the same filters, limits,
+// quotas, and API key checks, and WatchServers by the live updates hub.
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/models"
+)
+
+// grpcServicePath is the path prefix of the service's methods.
+const grpcServicePath = "/a2sapi.v1.A2SAPI/"
+
+// grpcForwardedHeaders are the headers of a call that are passed on to the REST
+// API, for its API key checks and to identify the client.
+var grpcForwardedHeaders = []string{"Authorization", "X-API-Key",
+	"X-Forwarded-For"}
+
+// grpcResponseHeaders are the headers of the REST API's response that are
+// passed on to the client.
+var grpcResponseHeaders = []string{"Retry-After", "X-Quota-Remaining-Hour",
+	"X-Quota-Remaining-Day"}
+
+// grpcServer serves the gRPC API's calls.
+type grpcServer struct {
+	// cancelled when the server shuts down, ending the streams
+	ctx context.Context
+	// the REST API's router
+	api http.Handler
+	// WatchServers, with the limits and API key checks of its route
+	watch http.Handler
+}
+
+type grpcUnaryMethod func(g *grpcServer, w http.ResponseWriter,
+	r *http.Request, req pbFields) ([]byte, error)
+
+var grpcUnaryMethods = map[string]grpcUnaryMethod{
+	"GetServers":       (*grpcServer).getServers,
+	"GetServerIDs":     (*grpcServer).getServerIDs,
+	"QueryServerIDs":   (*grpcServer).queryServerIDs,
+	"QueryServerAddrs": (*grpcServer).queryServerAddrs,
+}
+
+func newGRPCServer(ctx context.Context, api http.Handler) *grpcServer {
+	g := &grpcServer{ctx: ctx, api: api}
+	g.watch = wrapRoute(route{
+		name:        "WatchServers",
+		method:      "POST",
+		scope:       scopeReadList,
+		handlerFunc: g.watchServers,
+		stream:      true,
+	}, parseTrustedNetworks(config.Get().AdminConfig.TrustedIPs))
+	return g
+}
+
+// callREST makes a GET request with the query q to the REST API on behalf of
+// the call r, and decodes its JSON response into v.
+func (g *grpcServer) callREST(w http.ResponseWriter, r *http.Request,
+	path string, q url.Values, v interface{}) error {
+	req, err := http.NewRequestWithContext(r.Context(), "GET",
+		path+"?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	for _, h := range grpcForwardedHeaders {
+		if val := r.Header.Get(h); val != "" {
+			req.Header.Set(h, val)
+		}
+	}
+	req.Header.Set("Accept", "application/json")
+	req.RemoteAddr = r.RemoteAddr
+	rec := &grpcRecorder{header: make(http.Header)}
+	g.api.ServeHTTP(rec, req)
+	for _, h := range grpcResponseHeaders {
+		if val := rec.header.Get(h); val != "" {
+			w.Header().Set(h, val)
+		}
+	}
+	if rec.status != http.StatusOK {
+		return grpcStatusFromHTTP(rec.status, rec.body.Bytes())
+	}
+	return json.Unmarshal(rec.body.Bytes(), v)
+}
+
+func (g *grpcServer) getServers(w http.ResponseWriter, r *http.Request,
+	req pbFields) ([]byte, error) {
+	q := make(url.Values)
+	for field, name := range map[int]string{1: qsServersGame,
+		23: qsOfflineWithin, 24: qsSortServers, 27: qsPageCursor} {
+		if v := req.stringValue(field); v != "" {
+			q.Set(name, v)
+		}
+	}
+	for field, name := range map[int]string{2: qsGetServersCountry,
+		3: qsGetServersRegion, 4: qsGetServersState, 5: qsGetServersIP,
+		6: qsGetServersName, 7: qsGetServersMap, 8: qsGetServersMapExact,
+		9: qsGetServersGame, 10: qsGetServersGameType, 11: qsGetServersType,
+		12: qsGetServersOS, 13: qsGetServersVersion, 14: qsGetServersKeywords} {
+		if v := req.stringValues(field); len(v) != 0 {
+			q.Set(name, strings.Join(v, ","))
+		}
+	}
+	for field, name := range map[int]string{15: qsGetServersIgnoreColors,
+		22: qsIncludeOffline} {
+		if v, _ := req.boolValue(field); v {
+			q.Set(name, "true")
+		}
+	}
+	// optional filters, which also match on false
+	for field, name := range map[int]string{16: qsGetServersHasPlayers,
+		17: qsGetServersHasBots, 18: qsGetServersHasPassword,
+		19: qsGetServersHasAntiCheat, 20: qsGetServersIsNotFull,
+		28: qsGetServersPasswordProtected, 29: qsGetServersHasSpectators} {
+		if v, ok := req.boolValue(field); ok {
+			q.Set(name, strconv.FormatBool(v))
+		}
+	}
+	for field, name := range map[int]string{21: qsGetServersMinPlayers,
+		25: qsPageLimit, 26: qsPageOffset, 30: qsGetServersMinFreeSlots} {
+		if v := req.intValue(field); v != 0 {
+			q.Set(name, strconv.FormatInt(v, 10))
+		}
+	}
+	var sl models.APIServerList
+	if err := g.callREST(w, r, "/servers", q, &sl); err != nil {
+		return nil, err
+	}
+	return encodeServerList(&sl), nil
+}
+
+func (g *grpcServer) getServerIDs(w http.ResponseWriter, r *http.Request,
+	req pbFields) ([]byte, error) {
+	q := url.Values{qsGetServerIDs: {strings.Join(req.stringValues(1), ",")}}
+	var ids models.DbServerID
+	if err := g.callREST(w, r, "/serverIDs", q, &ids); err != nil {
+		return nil, err
+	}
+	return encodeServerIDs(ids), nil
+}
+
+func (g *grpcServer) queryServerIDs(w http.ResponseWriter, r *http.Request,
+	req pbFields) ([]byte, error) {
+	var ids []string
+	for _, id := range req.intValues(1) {
+		ids = append(ids, strconv.FormatInt(id, 10))
+	}
+	q := url.Values{qsQueryServerIDs: {strings.Join(ids, ",")}}
+	var sl models.APIServerList
+	if err := g.callREST(w, r, "/query", q, &sl); err != nil {
+		return nil, err
+	}
+	return encodeServerList(&sl), nil
+}
+
+func (g *grpcServer) queryServerAddrs(w http.ResponseWriter, r *http.Request,
+	req pbFields) ([]byte, error) {
+	q := url.Values{qsQueryServerAddrs: {strings.Join(req.stringValues(1), ",")}}
+	var sl models.APIServerList
+	if err := g.callREST(w, r, "/query", q, &sl); err != nil {
+		return nil, err
+	}
+	return encodeServerList(&sl), nil
+}
+
+// watchServers streams a snapshot of each game's (filtered) list, then the
+// changes to it after each retrieval, as GET /servers/live does.
+func (g *grpcServer) watchServers(w http.ResponseWriter, r *http.Request) {
+	req, err := readGRPCRequest(r)
+	if err != nil {
+		writeGRPCError(w, err)
+		return
+	}
+	q := make(url.Values)
+	if v := req.stringValues(2); len(v) != 0 {
+		q.Set(qsGetServersRegion, strings.Join(v, ","))
+	}
+	if v := req.stringValues(3); len(v) != 0 {
+		q.Set(qsGetServersCountry, strings.Join(v, ","))
+	}
+	ls := newLiveSubscriber(req.stringValue(1), getSrvFilterFromQString(q,
+		liveFilterQueryStrings))
+
+	// subscribe before the snapshot so that no update is missed in between
+	updates := models.SubscribeGameLists()
+	defer models.UnsubscribeGameLists(updates)
+	startGRPCResponse(w)
+	finishGRPCResponse(w, g.streamUpdates(w, r, ls, updates))
+}
+
+func (g *grpcServer) streamUpdates(w http.ResponseWriter, r *http.Request,
+	ls *liveSubscriber, updates <-chan string) error {
+	for _, msg := range ls.snapshot(models.GameLists()) {
+		if err := writeGRPCMessage(w, encodeServerListUpdate(msg)); err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case game := <-updates:
+			msg := ls.update(game, models.GetGameList(game))
+			if msg == nil {
+				continue
+			}
+			if err := writeGRPCMessage(w, encodeServerListUpdate(*msg)); err != nil {
+				return err
+			}
+		case <-r.Context().Done():
+			if r.Context().Err() == context.DeadlineExceeded {
+				return &grpcError{grpcDeadlineExceeded, "Deadline exceeded."}
+			}
+			return nil
+		case <-g.ctx.Done():
+			return &grpcError{grpcUnavailable, "The server is shutting down."}
+		}
+	}
+}
+
+func encodeServerList(sl *models.APIServerList) []byte {
+	var b []byte
+	b = appendPBString(b, 1, sl.CycleID)
+	b = appendPBStringMap(b, 2, sl.CycleIDs)
+	b = appendPBString(b, 3, sl.RetrievedAt)
+	b = appendPBInt(b, 4, sl.RetrievedTimeStamp)
+	b = appendPBInt(b, 5, int64(sl.ServerCount))
+	for _, s := range sl.Servers {
+		b = appendPBBytes(b, 6, encodeServer(s))
+	}
+	b = appendPBInt(b, 7, int64(sl.FailedCount))
+	b = appendPBStrings(b, 8, sl.FailedServers)
+	b = appendPBInt(b, 9, int64(sl.TotalCount))
+	b = appendPBString(b, 10, sl.NextCursor)
+	return appendPBBool(b, 11, sl.WarmUp)
+}
+
+func encodeServer(s models.APIServer) []byte {
+	var b []byte
+	b = appendPBInt(b, 1, s.ID)
+	b = appendPBString(b, 2, s.Alias)
+	b = appendPBString(b, 3, s.Host)
+	b = appendPBString(b, 4, s.Game)
+	b = appendPBString(b, 5, s.IP)
+	b = appendPBInt(b, 6, int64(s.Port))
+	b = appendPBBytes(b, 7, encodeLocation(s.CountryInfo))
+	// unset if the server's info couldn't be retrieved
+	if s.Info != (models.SteamServerInfo{}) {
+		b = appendPBBytes(b, 8, encodeServerInfo(s.Info))
+	}
+	for _, p := range s.Players {
+		b = appendPBBytes(b, 9, encodePlayer(p))
+	}
+	fp := appendPBInt(nil, 1, int64(s.FilteredPlayers.FilteredPlayerCount))
+	for _, p := range s.FilteredPlayers.FilteredPlayers {
+		fp = appendPBBytes(fp, 2, encodePlayer(p))
+	}
+	b = appendPBBytes(b, 10, fp)
+	if s.Rules != nil {
+		b = appendPBBytes(b, 11, appendPBStringMap(nil, 1, s.Rules))
+	}
+	b = appendPBStrings(b, 12, s.Tags)
+	b = appendPBStrings(b, 13, s.ParseWarnings)
+	b = appendPBStrings(b, 14, s.PartialFields)
+	b = appendPBInt(b, 15, s.RefreshedTimeStamp)
+	b = appendPBString(b, 16, s.Status)
+	b = appendPBInt(b, 17, int64(s.ConsecutiveFailures))
+	b = appendPBInt(b, 18, s.LastSeenOnline)
+	b = appendPBInt(b, 19, int64(s.FreeSlots))
+	b = appendPBBool(b, 20, s.PasswordProtected)
+	b = appendPBOptionalInt(b, 21, s.Spectators)
+	return appendPBBool(b, 22, s.Pushed)
+}
+
+func encodeLocation(c models.DbCountry) []byte {
+	var b []byte
+	b = appendPBString(b, 1, c.CountryName)
+	b = appendPBString(b, 2, c.CountryCode)
+	b = appendPBString(b, 3, c.Continent)
+	b = appendPBString(b, 4, c.State)
+	b = appendPBString(b, 5, c.FlagEmoji)
+	b = appendPBString(b, 6, c.FlagURL)
+	b = appendPBString(b, 7, c.City)
+	b = appendPBDouble(b, 8, c.Latitude)
+	b = appendPBDouble(b, 9, c.Longitude)
+	b = appendPBUint(b, 10, uint64(c.ASN))
+	return appendPBString(b, 11, c.ASNOrg)
+}
+
+func encodeServerInfo(i models.SteamServerInfo) []byte {
+	var b []byte
+	b = appendPBInt(b, 1, int64(i.Protocol))
+	b = appendPBString(b, 2, i.Name)
+	b = appendPBString(b, 3, i.Map)
+	b = appendPBString(b, 4, i.Folder)
+	b = appendPBString(b, 5, i.Game)
+	b = appendPBString(b, 6, i.GameTypeShort)
+	b = appendPBString(b, 7, i.GameTypeFull)
+	b = appendPBInt(b, 8, int64(i.ID))
+	b = appendPBInt(b, 9, int64(i.Players))
+	b = appendPBInt(b, 10, int64(i.MaxPlayers))
+	b = appendPBInt(b, 11, int64(i.Bots))
+	b = appendPBString(b, 12, i.ServerType)
+	b = appendPBString(b, 13, i.Environment)
+	b = appendPBInt(b, 14, int64(i.Visibility))
+	b = appendPBInt(b, 15, int64(i.VAC))
+	b = appendPBString(b, 16, i.Version)
+	b = appendPBInt(b, 17, int64(i.Ping))
+	if e := i.ExtraData; e != (models.SteamExtraData{}) {
+		var x []byte
+		x = appendPBInt(x, 1, int64(e.Port))
+		x = appendPBUint(x, 2, e.SteamID)
+		x = appendPBInt(x, 3, int64(e.SourceTVPort))
+		x = appendPBString(x, 4, e.SourceTVName)
+		x = appendPBString(x, 5, e.Keywords)
+		x = appendPBUint(x, 6, e.GameID)
+		b = appendPBBytes(b, 18, x)
+	}
+	return b
+}
+
+func encodePlayer(p models.SteamPlayerInfo) []byte {
+	var b []byte
+	b = appendPBString(b, 1, p.Name)
+	b = appendPBInt(b, 2, int64(p.Score))
+	b = appendPBFloat(b, 3, p.TimeConnectedSecs)
+	b = appendPBString(b, 4, p.TimeConnectedTot)
+	b = appendPBInt(b, 5, p.TimeConnectedRaw)
+	b = appendPBString(b, 6, p.TimeConnectedISO)
+	b = appendPBString(b, 7, p.TimeConnectedLong)
+	b = appendPBStringMap(b, 8, p.Extra)
+	return appendPBString(b, 9, p.CleanName)
+}
+
+func encodeServerIDs(ids models.DbServerID) []byte {
+	b := appendPBInt(nil, 1, int64(ids.ServerCount))
+	for _, s := range ids.Servers {
+		var x []byte
+		x = appendPBInt(x, 1, s.ID)
+		x = appendPBString(x, 2, s.Game)
+		x = appendPBString(x, 3, s.Host)
+		b = appendPBBytes(b, 2, x)
+	}
+	return b
+}
+
+// ServerListUpdate types
+const (
+	grpcUpdateSnapshot = 1
+	grpcUpdateDelta    = 2
+)
+
+func encodeServerListUpdate(msg liveMessage) []byte {
+	var b []byte
+	if msg.Type == "snapshot" {
+		b = appendPBInt(b, 1, grpcUpdateSnapshot)
+	} else {
+		b = appendPBInt(b, 1, grpcUpdateDelta)
+	}
+	b = appendPBString(b, 2, msg.Game)
+	b = appendPBString(b, 3, msg.CycleID)
+	b = appendPBInt(b, 4, msg.RetrievedTimeStamp)
+	for _, s := range msg.Servers {
+		b = appendPBBytes(b, 5, encodeServer(s))
+	}
+	if d := msg.APIServerListDiff; d != nil {
+		for _, s := range d.Added {
+			b = appendPBBytes(b, 6, encodeServer(s))
+		}
+		for _, s := range d.Removed {
+			b = appendPBBytes(b, 7, encodeServer(s))
+		}
+		for _, c := range d.Changed {
+			b = appendPBBytes(b, 8, encodeServerChange(c))
+		}
+	}
+	return b
+}
+
+func encodeServerChange(c models.APIServerChange) []byte {
+	var b []byte
+	b = appendPBInt(b, 1, c.ID)
+	b = appendPBString(b, 2, c.Host)
+	b = appendPBString(b, 3, c.Game)
+	for _, f := range c.Fields {
+		var x []byte
+		x = appendPBString(x, 1, f.Field)
+		x = appendPBString(x, 2, f.Old)
+		x = appendPBString(x, 3, f.New)
+		b = appendPBBytes(b, 4, x)
+	}
+	return b
+}
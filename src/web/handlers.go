@@ -9,6 +9,8 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/syncore/a2sapi/src/config"
 	"github.com/syncore/a2sapi/src/constants"
@@ -40,15 +42,63 @@ func useDumpFileAsMasterList(dumppath string) *models.APIServerList {
 	return ml
 }
 
+// getCurrentServerList returns the current server list, which is the requested
+// game's own list if a game was specified; if unsuccessful, an error is written
+// to w. The list is nil during the first retrieval.
+func getCurrentServerList(w http.ResponseWriter,
+	r *http.Request) (*models.APIServerList, bool) {
+	if game, pergame := getQStringValue(r.URL.Query(), qsServersGame); pergame {
+		// the game's own cache, which is independent of other games' lists
+		asl := models.GetGameList(game)
+		if asl == nil {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w,
+				`{"error": {"code": 404,"message": "No server list is available for that game."}}`)
+			return nil, false
+		}
+		return asl, true
+	}
+	if config.Get().DebugConfig.ServerDumpFileAsMasterList {
+		return useDumpFileAsMasterList(constants.DumpFileFullPath(
+			config.Get().DebugConfig.ServerDumpFilename)), true
+	}
+	return models.GetMasterList(), true
+}
+
 func getServers(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	var asl *models.APIServerList
+	var ok bool
 
-	if config.Config.DebugConfig.ServerDumpFileAsMasterList {
-		asl = useDumpFileAsMasterList(constants.DumpFileFullPath(
-			config.Config.DebugConfig.ServerDumpFilename))
-	} else {
-		asl = models.MasterList
+	page, err := getPageRequest(r.URL.Query())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"error": {"code": 400,"message": "%s"}}`, err)
+		return
+	}
+	sortby, err := getServerSort(r.URL.Query())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"error": {"code": 400,"message": "%s"}}`, err)
+		return
+	}
+	if err := checkSrvFilterValues(r.URL.Query(), getServersQueryStrings); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"error": {"code": 400,"message": "%s"}}`, err)
+		return
+	}
+	game, _ := getQStringValue(r.URL.Query(), qsServersGame)
+	if page != nil && page.cycle != 0 {
+		// continue paging through the snapshot the cursor was issued for
+		asl = serverSnapshots.get(game, page.cycle)
+		if asl == nil {
+			w.WriteHeader(http.StatusGone)
+			fmt.Fprintf(w,
+				`{"error": {"code": 410,"message": "Cursor has expired. Start again without a cursor."}}`)
+			return
+		}
+	} else if asl, ok = getCurrentServerList(w, r); !ok {
+		return
 	}
 	// Empty (i.e. during first retrieval/startup)
 	if asl == nil {
@@ -58,7 +108,80 @@ func getServers(w http.ResponseWriter, r *http.Request) {
 	srvfilters := getSrvFilterFromQString(r.URL.Query(), getServersQueryStrings)
 	logger.WriteDebug("server list will be filtered with: %v", srvfilters)
 	list := filterServers(srvfilters, asl)
-	writeJSONResponse(w, list)
+	if err := includeOfflineServers(r.URL.Query(), srvfilters, list,
+		asl); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"error": {"code": 400,"message": "%s"}}`, err)
+		return
+	}
+	list = sortServers(list, sortby)
+	if page != nil {
+		serverSnapshots.add(game, asl)
+		list = paginateServers(list, page)
+		if list.NextCursor != "" {
+			list.NextPage = nextPageLink(r.URL, list.NextCursor)
+		}
+	}
+	list = markStaleServers(refreshStaleServers(list))
+	if checkNotModified(w, r, list) {
+		return
+	}
+	writeServerListResponse(w, list, getView(r))
+}
+
+// includeOfflineServers appends the known offline and timed out servers of the
+// retrieved list asl that match the filters to the list, if requested. If
+// offlineWithin is specified, only the offline servers that were last seen
+// online within that duration are included.
+func includeOfflineServers(m map[string][]string, srvfilters []slQueryFilter,
+	list, asl *models.APIServerList) error {
+	include, _ := getQStringValue(m, qsIncludeOffline)
+	if !strings.EqualFold(include, "true") {
+		return nil
+	}
+	var since int64
+	if within, ok := getQStringValue(m, qsOfflineWithin); ok && within != "" {
+		d, err := time.ParseDuration(within)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("The %s parameter must be a duration, i.e: 24h",
+				qsOfflineWithin)
+		}
+		since = time.Now().Add(-d).Unix()
+	}
+	var offline []models.APIServer
+	for _, o := range asl.OfflineServers {
+		if since != 0 && o.LastSeenOnline < since {
+			continue
+		}
+		offline = append(offline, o.Server())
+	}
+	for _, s := range srvfilters {
+		offline = findMatches(s, offline)
+	}
+	list.Servers = append(list.Servers, offline...)
+	list.ServerCount = len(list.Servers)
+	return nil
+}
+
+func getServerCounts(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if err := checkSrvFilterValues(r.URL.Query(), getServersQueryStrings); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"error": {"code": 400,"message": "%s"}}`, err)
+		return
+	}
+	asl, ok := getCurrentServerList(w, r)
+	if !ok {
+		return
+	}
+	// Empty (i.e. during first retrieval/startup)
+	if asl == nil {
+		writeJSONResponse(w, models.GetDefaultServerList().Counts())
+		return
+	}
+	srvfilters := getSrvFilterFromQString(r.URL.Query(), getServersQueryStrings)
+	logger.WriteDebug("server counts will be filtered with: %v", srvfilters)
+	writeJSONResponse(w, filterServers(srvfilters, asl).Counts())
 }
 
 func getServerIDs(w http.ResponseWriter, r *http.Request) {
@@ -89,18 +212,18 @@ func queryServerIDs(w http.ResponseWriter, r *http.Request) {
 		writeJSONResponse(w, models.GetDefaultServerList())
 		return
 	}
-	if len(ids) > config.Config.WebConfig.MaximumHostsPerAPIQuery {
+	if len(ids) > config.Get().WebConfig.MaximumHostsPerAPIQuery {
 		logger.WriteDebug("Maximum number of allowed API query hosts exceeded, truncating")
-		ids = ids[:config.Config.WebConfig.MaximumHostsPerAPIQuery]
+		ids = ids[:config.Get().WebConfig.MaximumHostsPerAPIQuery]
 	}
 
-	queryServerIDRetriever(w, ids)
+	queryServerIDRetriever(w, ids, getView(r))
 }
 
 func queryServerAddrs(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	if !config.Config.WebConfig.AllowDirectUserQueries {
+	if !config.Get().WebConfig.AllowDirectUserQueries {
 		w.WriteHeader(http.StatusBadRequest)
 		fmt.Fprintf(w,
 			`{"error": {"code": 400,"message": "Direct server queries are disabled. Use the %s parameter."}}`,
@@ -119,14 +242,26 @@ func queryServerAddrs(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var parsedaddresses []string
+	denied := 0
 	for _, addr := range addresses {
 		host, err := net.ResolveTCPAddr("tcp4", addr)
 		if err != nil {
 			continue
 		}
+		if !isDirectQueryAllowed(host.IP) {
+			logger.WriteDebug("queryServerAddr: %s is not allowed to be queried", addr)
+			denied++
+			continue
+		}
 		parsedaddresses = append(parsedaddresses, fmt.Sprintf("%s:%d", host.IP, host.Port))
 	}
 
+	if len(parsedaddresses) == 0 && denied > 0 {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w,
+			`{"error": {"code": 403,"message": "The requested hosts are not allowed to be queried."}}`)
+		return
+	}
 	if len(parsedaddresses) == 0 {
 		w.WriteHeader(http.StatusOK)
 		logger.WriteDebug("queryServerAddr: No valid addresses for query. Ignoring.")
@@ -134,19 +269,143 @@ func queryServerAddrs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if len(parsedaddresses) > config.Config.WebConfig.MaximumHostsPerAPIQuery {
+	if len(parsedaddresses) > config.Get().WebConfig.MaximumHostsPerAPIQuery {
 		logger.WriteDebug("Maximum number of allowed API query hosts exceeded, truncating")
-		parsedaddresses = parsedaddresses[:config.Config.WebConfig.MaximumHostsPerAPIQuery]
+		parsedaddresses = parsedaddresses[:config.Get().WebConfig.MaximumHostsPerAPIQuery]
 	}
-	queryServerAddrRetriever(w, parsedaddresses)
+	queryServerAddrRetriever(w, parsedaddresses, getView(r))
+}
+
+func queryServerIPs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	vals := getQStringValues(r.URL.Query(), qsQueryServerIPs)
+	var ips []string
+	for _, v := range vals {
+		ip := net.ParseIP(strings.TrimSpace(v)).To4()
+		if ip == nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w,
+				`{"error": {"code": 400,"message": "The %s parameter must be one or more IPv4 addresses without ports."}}`,
+				qsQueryServerIPs)
+			return
+		}
+		ips = append(ips, ip.String())
+	}
+	if len(ips) == 0 {
+		logger.WriteDebug("queryServerIP: Got empty query. Ignoring.")
+		writeJSONResponse(w, models.GetDefaultServerList())
+		return
+	}
+	queryServerIPRetriever(w, ips, getView(r))
 }
 
 // writeJSONResponse encodes data as JSON and writes it to w; if unsuccessful,
 // the error will be logged and a generic error message will be displayed to the user.
 func writeJSONResponse(w http.ResponseWriter, data interface{}) {
-	if err := json.NewEncoder(w).Encode(data); err != nil {
+	if err := encodeJSON(w, data); err != nil {
+		writeJSONEncodeError(w, err)
+	}
+}
+
+// serverListView is the shape and encoding of a server list response.
+type serverListView struct {
+	// qsViewCompact, qsViewHosts, qsViewQStat, or empty for the full list
+	name string
+	// qsFormatJSON, qsFormatCSV, or qsFormatMsgPack; ignored by the hosts and
+	// qstat views, which have their own formats
+	format string
+}
+
+// formatMediaTypes are the media types of the response formats, as accepted in
+// the Accept header, in order of preference.
+var formatMediaTypes = []struct {
+	mediaType string
+	format    string
+}{
+	{"application/json", qsFormatJSON},
+	{"application/msgpack", qsFormatMsgPack},
+	{"application/x-msgpack", qsFormatMsgPack},
+	{"application/vnd.msgpack", qsFormatMsgPack},
+	{"text/csv", qsFormatCSV},
+}
+
+// getView returns the view of the server list that the request asks for, with
+// its name in lowercase.
+func getView(r *http.Request) serverListView {
+	view, _ := getQStringValue(r.URL.Query(), qsView)
+	return serverListView{name: strings.ToLower(view), format: getFormat(r)}
+}
+
+// getFormat returns the response format that the request asks for with the
+// format query string or, without it, its Accept header: the accepted format
+// with the highest quality value, or JSON if none of the formats are accepted.
+func getFormat(r *http.Request) string {
+	if f, ok := getQStringValue(r.URL.Query(), qsFormat); ok {
+		switch f = strings.ToLower(f); f {
+		case qsFormatCSV, qsFormatMsgPack:
+			return f
+		}
+		return qsFormatJSON
+	}
+	accepted, _ := parseEncodings(r.Header.Get("Accept"))
+	format, best := qsFormatJSON, 0.0
+	for _, mt := range formatMediaTypes {
+		if q := accepted[mt.mediaType]; q > best {
+			format, best = mt.format, q
+		}
+	}
+	return format
+}
+
+// writeServerListResponse writes the server list to w in the given view: the
+// compact form, a plain list of addresses, qstat-compatible XML, or otherwise
+// the full list; encoded as JSON, CSV, or MessagePack.
+func writeServerListResponse(w http.ResponseWriter, sl *models.APIServerList,
+	view serverListView) {
+	w.Header().Add("Vary", "Accept")
+	switch {
+	case view.name == qsViewHosts:
+		w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		w.Write(sl.HostList())
+	case view.name == qsViewQStat:
+		x, err := sl.QStatXML()
+		if err != nil {
+			writeJSONEncodeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml; charset=UTF-8")
+		w.Write(x)
+	case view.format == qsFormatCSV:
+		// one row per server, so the compact view is the same list
+		c, err := sl.CSV()
+		if err != nil {
+			writeJSONEncodeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "text/csv; charset=UTF-8")
+		w.Write(c)
+	case view.name == qsViewCompact:
+		writeEncodedResponse(w, sl.Compact(), view.format)
+	default:
+		writeEncodedResponse(w, sl, view.format)
+	}
+}
+
+// writeEncodedResponse writes data to w as MessagePack if that is the format, or
+// otherwise as JSON.
+func writeEncodedResponse(w http.ResponseWriter, data interface{},
+	format string) {
+	if format != qsFormatMsgPack {
+		writeJSONResponse(w, data)
+		return
+	}
+	b, err := marshalMsgPack(data)
+	if err != nil {
 		writeJSONEncodeError(w, err)
+		return
 	}
+	w.Header().Set("Content-Type", "application/msgpack")
+	w.Write(b)
 }
 
 // setNotFoundAndLog sets the error code of the underlying writer to 404 (not found)
@@ -3,11 +3,15 @@ package web
 // Tests for handler functions for API
 
 import (
+	"encoding/csv"
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -32,7 +36,7 @@ type ResponseRecoder struct {
 
 func init() {
 	test.SetupEnvironment()
-	testURLBase = fmt.Sprintf("http://:%d", config.Config.WebConfig.APIWebPort)
+	testURLBase = fmt.Sprintf("http://:%d", config.Get().WebConfig.APIWebPort)
 	db.InitDBs()
 
 	// create dump server file
@@ -41,7 +45,7 @@ func init() {
 		panic("Unable to create dump directory used in tests")
 	}
 	err = util.CreateByteFile(constants.TestServerDumpJSON, constants.DumpFileFullPath(
-		config.Config.DebugConfig.ServerDumpFilename), true)
+		config.Get().DebugConfig.ServerDumpFilename), true)
 	if err != nil {
 		panic(fmt.Sprintf("Test dump file creation error: %s", err))
 	}
@@ -51,17 +55,17 @@ func init() {
 		r := mux.NewRouter().StrictSlash(true)
 		for _, ar := range apiRoutes {
 			var handler http.Handler
-			handler = compressGzip(ar.handlerFunc, config.Config.WebConfig.CompressResponses)
+			handler = compressGzip(ar.handlerFunc, config.Get().WebConfig.CompressResponses)
 
 			r.Methods(ar.method).
 				MatcherFunc(pathQStrToLowerMatcherFunc(r, ar.path, ar.queryStrings,
 					getRequiredQryStringCount(ar.queryStrings))).
 				Name(ar.name).
 				Handler(http.TimeoutHandler(handler,
-					time.Duration(config.Config.WebConfig.APIWebTimeout)*time.Second,
+					time.Duration(config.Get().WebConfig.APIWebTimeout)*time.Second,
 					`{"error":"Timeout"}`))
 		}
-		err := http.ListenAndServe(fmt.Sprintf(":%d", config.Config.WebConfig.APIWebPort), r)
+		err := http.ListenAndServe(fmt.Sprintf(":%d", config.Get().WebConfig.APIWebPort), r)
 		if err != nil {
 			panic("Unable to start web server")
 		}
@@ -110,6 +114,377 @@ func TestGetServers(t *testing.T) {
 	}
 }
 
+// TestGetServersCompact tests the GetServers HTTP handler's compact view
+func TestGetServersCompact(t *testing.T) {
+	r, _ := http.NewRequest("GET", formatURL("servers?view=compact"), nil)
+	w := newRecorder()
+	getServers(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status code: %v for GetServers handler; got: %v",
+			http.StatusOK, w.Code)
+	}
+	m := &models.APICompactServerList{}
+	if err := json.Unmarshal(w.Body.Bytes(), m); err != nil {
+		t.Fatalf("Unable to decode compact server list: %s", err)
+	}
+	if m.ServerCount == 0 || m.ServerCount != len(m.Servers) {
+		t.Fatalf("Expected compact server list to contain %d servers, got: %d",
+			m.ServerCount, len(m.Servers))
+	}
+	for _, s := range m.Servers {
+		if s.Name == "" || s.Address == "" {
+			t.Fatalf("Expected compact server to have a name and address, got: %+v", s)
+		}
+	}
+}
+
+// TestGetServersExportViews tests the GetServers HTTP handler's address list and
+// qstat XML views
+func TestGetServersExportViews(t *testing.T) {
+	r, _ := http.NewRequest("GET", formatURL("servers?view=hosts"), nil)
+	w := newRecorder()
+	getServers(w, r)
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Fatalf("Expected text/plain content type, got: %s", ct)
+	}
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) == 0 || !strings.Contains(lines[0], ":") {
+		t.Fatalf("Expected a list of addresses, got: %s", w.Body.String())
+	}
+
+	r, _ = http.NewRequest("GET", formatURL("servers?view=qstat"), nil)
+	w = newRecorder()
+	getServers(w, r)
+	x := struct {
+		Servers []struct {
+			Address string `xml:"address,attr"`
+			Name    string `xml:"name"`
+		} `xml:"server"`
+	}{}
+	if err := xml.Unmarshal(w.Body.Bytes(), &x); err != nil {
+		t.Fatalf("Unable to decode qstat XML: %s", err)
+	}
+	if len(x.Servers) != len(lines) || x.Servers[0].Address == "" {
+		t.Fatalf("Expected %d servers in qstat XML, got: %+v", len(lines), x.Servers)
+	}
+}
+
+// TestGetServersFormats tests the GetServers HTTP handler's CSV and MessagePack
+// formats, requested with the format query string or the Accept header
+func TestGetServersFormats(t *testing.T) {
+	for _, req := range []struct{ url, accept string }{
+		{"servers?format=csv", ""},
+		{"servers", "text/csv"},
+		{"servers", "application/json;q=0.5, text/csv"},
+	} {
+		r, _ := http.NewRequest("GET", formatURL(req.url), nil)
+		r.Header.Set("Accept", req.accept)
+		w := newRecorder()
+		getServers(w, r)
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+			t.Fatalf("Expected text/csv content type for %+v, got: %s", req, ct)
+		}
+		rows, err := csv.NewReader(w.Body).ReadAll()
+		if err != nil {
+			t.Fatalf("Unable to decode CSV server list: %s", err)
+		}
+		if len(rows) < 2 || rows[0][0] != "serverID" || rows[1][2] == "" {
+			t.Fatalf("Expected a header row and a row per server, got: %v", rows)
+		}
+	}
+
+	r, _ := http.NewRequest("GET", formatURL("servers?format=msgpack"), nil)
+	w := newRecorder()
+	getServers(w, r)
+	if ct := w.Header().Get("Content-Type"); ct != "application/msgpack" {
+		t.Fatalf("Expected application/msgpack content type, got: %s", ct)
+	}
+	// the server list object is a map of fewer than 16 fields
+	if b := w.Body.Bytes(); len(b) == 0 || b[0]&0xf0 != 0x80 {
+		t.Fatalf("Expected a MessagePack map, got: % x", b)
+	}
+
+	// browsers' Accept headers, and unknown formats, get JSON
+	r, _ = http.NewRequest("GET", formatURL("servers?format=yaml"), nil)
+	r.Header.Set("Accept", "text/html,application/xhtml+xml,*/*;q=0.8")
+	w = newRecorder()
+	getServers(w, r)
+	if err := json.Unmarshal(w.Body.Bytes(), &models.APIServerList{}); err != nil {
+		t.Fatalf("Expected JSON server list, got: %s", err)
+	}
+}
+
+// TestGetQStatRaw tests the qstat raw output HTTP handler
+func TestGetQStatRaw(t *testing.T) {
+	r, _ := http.NewRequest("GET",
+		formatURL("qstat/raw?delim=%7C&rules=true&players=true"), nil)
+	w := newRecorder()
+	getQStatRaw(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code: %v for GetQStatRaw handler; got: %v",
+			http.StatusOK, w.Code)
+	}
+	servers := strings.Split(strings.TrimSpace(w.Body.String()), "\n\n")
+	if len(servers) == 0 {
+		t.Fatalf("Expected qstat raw output, got: %s", w.Body.String())
+	}
+	fields := strings.Split(strings.Split(servers[0], "\n")[0], "|")
+	if fields[0] != "A2S" || (len(fields) != 8 && len(fields) != 3) {
+		t.Fatalf("Expected qstat raw server line, got: %v", fields)
+	}
+}
+
+// TestGetServerCounts tests the GetServerCounts HTTP handler
+func TestGetServerCounts(t *testing.T) {
+	r, _ := http.NewRequest("GET", formatURL("servers"), nil)
+	w := newRecorder()
+	getServers(w, r)
+	sl := &models.APIServerList{}
+	if err := json.Unmarshal(w.Body.Bytes(), sl); err != nil {
+		t.Fatalf("Unable to decode server list: %s", err)
+	}
+	players := 0
+	for _, s := range sl.Servers {
+		players += int(s.Info.Players)
+	}
+
+	r, _ = http.NewRequest("GET", formatURL("servers/count"), nil)
+	w = newRecorder()
+	getServerCounts(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status code: %v for GetServerCounts handler; got: %v",
+			http.StatusOK, w.Code)
+	}
+	m := &models.APIServerCount{}
+	if err := json.Unmarshal(w.Body.Bytes(), m); err != nil {
+		t.Fatalf("Unable to decode server counts: %s", err)
+	}
+	if m.ServerCount != len(sl.Servers) || m.PlayerCount != players {
+		t.Fatalf("Expected %d servers and %d players, got: %+v", len(sl.Servers),
+			players, m)
+	}
+	if strings.Contains(w.Body.String(), `"servers"`) {
+		t.Fatalf("Expected server counts response to not include servers")
+	}
+}
+
+// TestGetServersForGame tests the GetServers HTTP handler's per-game lists
+func TestGetServersForGame(t *testing.T) {
+	sl := models.GetDefaultServerList()
+	sl.Servers = append(sl.Servers, models.APIServer{ID: 1, Game: "TestGame"})
+	sl.ServerCount = len(sl.Servers)
+	models.SetGameList("TestGame", sl)
+	defer models.SetGameList("TestGame", nil)
+
+	r, _ := http.NewRequest("GET", formatURL("servers?game=testgame"), nil)
+	w := newRecorder()
+	getServers(w, r)
+	m := &models.APIServerList{}
+	if err := json.Unmarshal(w.Body.Bytes(), m); err != nil {
+		t.Fatalf("Unable to decode per-game server list: %s", err)
+	}
+	if m.ServerCount != 1 || m.Servers[0].Game != "TestGame" {
+		t.Fatalf("Expected the TestGame server list, got: %+v", m)
+	}
+
+	r, _ = http.NewRequest("GET", formatURL("servers?game=NoSuchGame"), nil)
+	w = newRecorder()
+	getServers(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected status code: %v for unknown game; got: %v",
+			http.StatusNotFound, w.Code)
+	}
+}
+
+// TestGetServersCycleIDs tests that server lists identify their retrieval cycles
+func TestGetServersCycleIDs(t *testing.T) {
+	sl := models.GetDefaultServerList()
+	sl.CycleID = util.NewUUID()
+	sl.Servers = append(sl.Servers, models.APIServer{ID: 1, Game: "TestGame"})
+	sl.ServerCount = len(sl.Servers)
+	models.SetGameList("TestGame", sl)
+	defer models.SetGameList("TestGame", nil)
+
+	r, _ := http.NewRequest("GET", formatURL("servers?game=TestGame&view=compact"),
+		nil)
+	w := newRecorder()
+	getServers(w, r)
+	c := &models.APICompactServerList{}
+	if err := json.Unmarshal(w.Body.Bytes(), c); err != nil {
+		t.Fatalf("Unable to decode compact server list: %s", err)
+	}
+	if c.CycleID != sl.CycleID {
+		t.Fatalf("Expected cycle ID %s, got: %s", sl.CycleID, c.CycleID)
+	}
+
+	r, _ = http.NewRequest("GET", formatURL("servers/count?game=TestGame"), nil)
+	w = newRecorder()
+	getServerCounts(w, r)
+	m := &models.APIServerCount{}
+	if err := json.Unmarshal(w.Body.Bytes(), m); err != nil {
+		t.Fatalf("Unable to decode server counts: %s", err)
+	}
+	if m.CycleID != sl.CycleID {
+		t.Fatalf("Expected cycle ID %s, got: %s", sl.CycleID, m.CycleID)
+	}
+}
+
+// TestGetServersIncludeOffline tests the GetServers HTTP handler's offline servers
+func TestGetServersIncludeOffline(t *testing.T) {
+	sl := models.GetDefaultServerList()
+	sl.Servers = append(sl.Servers, models.APIServer{ID: 1, Game: "TestGame",
+		Status: models.ServerStatusOnline})
+	sl.ServerCount = len(sl.Servers)
+	sl.OfflineServers = []models.APIOfflineServer{
+		{Status: models.ServerStatusTimedOut, ID: 2, Host: "10.0.0.2:27960",
+			Game: "TestGame", ConsecutiveFailures: 3,
+			LastSeenOnline: time.Now().Add(-time.Hour).Unix()},
+		{Status: models.ServerStatusOffline, ID: 3, Host: "10.0.0.3:27960",
+			Game: "TestGame", ConsecutiveFailures: 90,
+			LastSeenOnline: time.Now().Add(-72 * time.Hour).Unix()},
+	}
+	models.SetGameList("TestGame", sl)
+	defer models.SetGameList("TestGame", nil)
+
+	r, _ := http.NewRequest("GET", formatURL("servers?game=TestGame"), nil)
+	w := newRecorder()
+	getServers(w, r)
+	m := &models.APIServerList{}
+	if err := json.Unmarshal(w.Body.Bytes(), m); err != nil {
+		t.Fatalf("Unable to decode server list: %s", err)
+	}
+	if m.ServerCount != 1 {
+		t.Fatalf("Expected offline servers to be omitted unless requested, got: %+v",
+			m.Servers)
+	}
+
+	r, _ = http.NewRequest("GET",
+		formatURL("servers?game=TestGame&includeOffline=true&offlineWithin=24h"), nil)
+	w = newRecorder()
+	getServers(w, r)
+	m = &models.APIServerList{}
+	if err := json.Unmarshal(w.Body.Bytes(), m); err != nil {
+		t.Fatalf("Unable to decode server list: %s", err)
+	}
+	if m.ServerCount != 2 || m.Servers[0].Status != models.ServerStatusOnline {
+		t.Fatalf("Expected online server and 1 offline server, got: %+v", m.Servers)
+	}
+	o := m.Servers[1]
+	if o.ID != 2 || o.Status != models.ServerStatusTimedOut ||
+		o.ConsecutiveFailures != 3 || o.IP != "10.0.0.2" || o.Port != 27960 {
+		t.Fatalf("Expected recently seen offline server, got: %+v", o)
+	}
+
+	// offline servers are filtered like the online ones
+	r, _ = http.NewRequest("GET",
+		formatURL("servers?game=TestGame&includeOffline=true&ip=10.0.0.3"), nil)
+	w = newRecorder()
+	getServers(w, r)
+	m = &models.APIServerList{}
+	if err := json.Unmarshal(w.Body.Bytes(), m); err != nil {
+		t.Fatalf("Unable to decode server list: %s", err)
+	}
+	if m.ServerCount != 1 || m.Servers[0].ID != 3 {
+		t.Fatalf("Expected only the offline server matching the filter, got: %+v",
+			m.Servers)
+	}
+
+	r, _ = http.NewRequest("GET",
+		formatURL("servers?game=TestGame&includeOffline=true&offlineWithin=soon"), nil)
+	w = newRecorder()
+	getServers(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status code: %v for invalid offlineWithin; got: %v",
+			http.StatusBadRequest, w.Code)
+	}
+}
+
+// TestMarkStaleServers tests the status of servers with old data
+func TestMarkStaleServers(t *testing.T) {
+	sl := models.GetDefaultServerList()
+	sl.RetrievedTimeStamp = time.Now().Add(-time.Hour).Unix()
+	sl.Servers = []models.APIServer{
+		{ID: 1, Status: models.ServerStatusOnline},
+		{ID: 2, Status: models.ServerStatusOnline,
+			RefreshedTimeStamp: time.Now().Unix()},
+		{ID: 3, Status: models.ServerStatusPartial},
+	}
+	marked := markStaleServers(sl)
+	expected := []string{models.ServerStatusStale, models.ServerStatusOnline,
+		models.ServerStatusPartial}
+	for i, s := range marked.Servers {
+		if s.Status != expected[i] {
+			t.Fatalf("Expected server %d to be %s, got: %s", s.ID, expected[i],
+				s.Status)
+		}
+	}
+	if sl.Servers[0].Status != models.ServerStatusOnline {
+		t.Fatalf("Expected the original list to be left unchanged")
+	}
+}
+
+// TestGetServersCompactLists tests that compact game lists are served unchanged
+func TestGetServersCompactLists(t *testing.T) {
+	players := []models.SteamPlayerInfo{{Name: "player", Score: -2,
+		TimeConnectedSecs: 61.5, TimeConnectedTot: "1m1s", TimeConnectedRaw: 61,
+		TimeConnectedISO: "PT1M1S", TimeConnectedLong: "1m 1s"}}
+	sl := models.GetDefaultServerList()
+	sl.CycleID = util.NewUUID()
+	sl.Servers = append(sl.Servers, models.APIServer{ID: 1, Alias: "alias",
+		Host: "10.0.0.1:27960", Game: "TestGame", IP: "10.0.0.1", Port: 27960,
+		CountryInfo: models.DbCountry{CountryName: "United States",
+			CountryCode: "US", Continent: "North America", State: "TX",
+			FlagEmoji: "\U0001F1FA\U0001F1F8", FlagURL: "https://flags/us.png"},
+		Info: models.SteamServerInfo{Protocol: 17, Name: "server", Map: "campgrounds",
+			Folder: "baseq3", Game: "Quake Live", GameTypeShort: "CA",
+			GameTypeFull: "Clan Arena", ID: -1, Players: 1, MaxPlayers: 16,
+			Bots: 1, ServerType: "d", Environment: "l", Visibility: 1, VAC: 1,
+			Version: "1069", Ping: 30, ExtraData: models.SteamExtraData{Port: 27960,
+				SteamID: 90071996842377216, SourceTVPort: 27970, SourceTVName: "tv",
+				Keywords: "clanarena", GameID: 282440}},
+		Players: players,
+		FilteredPlayers: models.FilteredPlayerInfo{FilteredPlayerCount: 1,
+			FilteredPlayers: players},
+		Rules: map[string]string{"g_gametype": "4", "sv_tags": "clanarena"},
+		Tags:  []string{"clanarena"}, ParseWarnings: []string{"truncated"},
+		PartialFields: []string{}, RefreshedTimeStamp: 1451189294,
+		Status: models.ServerStatusPartial, ConsecutiveFailures: 1,
+		LastSeenOnline: 1451189000})
+	sl.Servers = append(sl.Servers, models.APIServer{ID: 2, Game: "TestGame"})
+	sl.ServerCount = len(sl.Servers)
+	sl.FailedServers = append(sl.FailedServers, "10.0.0.3:27960")
+	sl.FailedCount = len(sl.FailedServers)
+	sl.OfflineServers = []models.APIOfflineServer{{Status: models.ServerStatusOffline,
+		ID: 3, Host: "10.0.0.3:27960", Game: "TestGame", ConsecutiveFailures: 5,
+		LastSeenOnline: 1451180000}}
+	sl.WarmUp = true
+
+	getList := func() string {
+		r, _ := http.NewRequest("GET",
+			formatURL("servers?game=TestGame&includeOffline=true"), nil)
+		w := newRecorder()
+		getServers(w, r)
+		return w.Body.String()
+	}
+	models.SetGameList("TestGame", sl)
+	expected := getList()
+	models.SetGameList("TestGame", nil)
+
+	models.SetCompactGameLists(true)
+	defer models.SetCompactGameLists(false)
+	models.SetGameList("TestGame", sl)
+	defer models.SetGameList("TestGame", nil)
+	if got := models.GetGameList("TestGame"); !reflect.DeepEqual(got, sl) {
+		t.Fatalf("Expected compact list to be unchanged, got: %+v", got)
+	}
+	if got := getList(); got != expected {
+		t.Fatalf("Expected compact list response:\n%s\ngot:\n%s", expected, got)
+	}
+	if got := models.GetMasterList(); !reflect.DeepEqual(got, sl) {
+		t.Fatalf("Expected master list to be the compact list, got: %+v", got)
+	}
+}
+
 // TestGetServerID tests the GetServerID HTTP handler
 func TestGetServerIDs(t *testing.T) {
 	r, _ := http.NewRequest("GET", formatURL("serverIDs?hosts=127.0.0.1:65534"),
@@ -190,3 +565,56 @@ func TestQueryServerAddr(t *testing.T) {
 		t.Errorf("queryServerAddr handler body should not be empty")
 	}
 }
+
+// TestQueryServerAddrDenied tests that addresses outside of the allowed
+// networks, or in the denied networks, are not queried
+func TestQueryServerAddrDenied(t *testing.T) {
+	defer func() { directQueryAllowedNets, directQueryDeniedNets = nil, nil }()
+	directQueryAllowedNets = parseNetworks([]string{"192.0.2.0/24"}, "test")
+	directQueryDeniedNets = parseNetworks([]string{"192.0.2.1"}, "test")
+	for _, tt := range []struct {
+		ip      string
+		allowed bool
+	}{
+		{"192.0.2.10", true},
+		{"192.0.2.1", false},
+		{"127.0.0.1", false},
+	} {
+		if isDirectQueryAllowed(net.ParseIP(tt.ip)) != tt.allowed {
+			t.Errorf("Expected direct query of %s to be allowed: %v", tt.ip,
+				tt.allowed)
+		}
+	}
+
+	r, _ := http.NewRequest("GET",
+		formatURL("query?hosts=127.0.0.1:65534,192.0.2.1:27960"), nil)
+	w := newRecorder()
+	queryServerAddrs(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status code %v for denied hosts; got: %v",
+			http.StatusForbidden, w.Code)
+	}
+}
+
+// TestQueryServerIP tests the QueryServerIP handler
+func TestQueryServerIP(t *testing.T) {
+	r1, _ := http.NewRequest("GET", formatURL("query?ip=127.0.0.1"), nil)
+	w1 := newRecorder()
+	queryServerIPs(w1, r1)
+	if w1.Code != http.StatusOK {
+		t.Errorf("Expected status code %v for queryServerIP handler; got: %v",
+			http.StatusOK, w1.Code)
+	}
+	m1 := &models.APIServerList{}
+	if _, ok := w1.ExpectJSON(m1, m1); !ok {
+		t.Errorf("queryServerIP: expected and actual models do not match.")
+	}
+	// ports are not allowed
+	r2, _ := http.NewRequest("GET", formatURL("query?ip=127.0.0.1:27960"), nil)
+	w2 := newRecorder()
+	queryServerIPs(w2, r2)
+	if w2.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %v for queryServerIP handler with port; got: %v",
+			http.StatusBadRequest, w2.Code)
+	}
+}
@@ -0,0 +1,83 @@
+package web
+
+// history.go - A server's player count history, recorded after each timed
+// retrieval, as a JSON time series for graphing population trends.
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/db"
+	"github.com/syncore/a2sapi/src/models"
+)
+
+// defaultHistoryRange is the range of the history if none is requested.
+const defaultHistoryRange = "24h"
+
+// parseHistoryRange parses a history range: a number of days (i.e. 7d) or a
+// duration (i.e. 24h or 90m) of at most max (if max is not 0).
+func parseHistoryRange(val string, max time.Duration) (time.Duration, error) {
+	var d time.Duration
+	var err error
+	if strings.HasSuffix(val, "d") {
+		var days int
+		days, err = strconv.Atoi(strings.TrimSuffix(val, "d"))
+		d = time.Duration(days) * 24 * time.Hour
+	} else {
+		d, err = time.ParseDuration(val)
+	}
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf(
+			"The %s parameter must be a number of days (i.e. 7d) or hours (i.e. 24h).",
+			qsHistoryRange)
+	}
+	if max > 0 && d > max {
+		return 0, fmt.Errorf("The %s parameter can be at most %dd.", qsHistoryRange,
+			max/(24*time.Hour))
+	}
+	return d, nil
+}
+
+func getServerHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if !config.Get().SteamConfig.RecordPlayerHistory || db.AppDB == nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w,
+			`{"error": {"code": 404,"message": "Player history is disabled."}}`)
+		return
+	}
+	id, err := strconv.ParseInt(pathSegment(r.URL.Path, 1), 10, 64)
+	if err != nil || id <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w,
+			`{"error": {"code": 400,"message": "Invalid server ID."}}`)
+		return
+	}
+	rng, ok := getQStringValue(r.URL.Query(), qsHistoryRange)
+	if !ok || rng == "" {
+		rng = defaultHistoryRange
+	}
+	d, err := parseHistoryRange(rng, time.Duration(
+		config.Get().SteamConfig.PlayerHistoryDays())*24*time.Hour)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"error": {"code": 400,"message": "%s"}}`, err)
+		return
+	}
+	now := time.Now()
+	h := models.ServerHistory{ServerID: id, Range: rng,
+		From: now.Add(-d).Unix(), To: now.Unix()}
+	if h.Points, err = db.AppDB.GetServerHistory(id, h.From); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w,
+			`{"error": {"code": 500,"message": "Unable to retrieve history."}}`)
+		return
+	}
+	if err := encodeJSON(w, h); err != nil {
+		writeJSONEncodeError(w, err)
+	}
+}
@@ -0,0 +1,52 @@
+package web
+
+// Tests for the server player count history
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseHistoryRange(t *testing.T) {
+	week := 7 * 24 * time.Hour
+	valid := map[string]time.Duration{
+		"24h": 24 * time.Hour,
+		"90m": 90 * time.Minute,
+		"7d":  week,
+	}
+	for val, expected := range valid {
+		d, err := parseHistoryRange(val, week)
+		if err != nil {
+			t.Fatalf("Unexpected error for range %s: %s", val, err)
+		}
+		if d != expected {
+			t.Fatalf("Expected %s for range %s, got: %s", expected, val, d)
+		}
+	}
+	for _, val := range []string{"8d", "0h", "-1d", "d", "day", ""} {
+		if _, err := parseHistoryRange(val, week); err == nil {
+			t.Fatalf("Expected error for range '%s'", val)
+		}
+	}
+	if _, err := parseHistoryRange("30d", 0); err != nil {
+		t.Fatalf("Expected no maximum range without retention, got: %s", err)
+	}
+}
+
+func TestMatchPathPattern(t *testing.T) {
+	pattern := "/servers/{id}/history"
+	for _, p := range []string{"/servers/12/history", "/Servers/12/History/"} {
+		if !matchPathPattern(pattern, p) {
+			t.Fatalf("Expected %s to match %s", p, pattern)
+		}
+	}
+	for _, p := range []string{"/servers", "/servers//history",
+		"/servers/12/history/extra", "/servers/12/players"} {
+		if matchPathPattern(pattern, p) {
+			t.Fatalf("Expected %s not to match %s", p, pattern)
+		}
+	}
+	if pathSegment("/servers/12/history", 1) != "12" {
+		t.Fatalf("Expected server ID path segment")
+	}
+}
@@ -0,0 +1,143 @@
+package web
+
+// ingest.go - Ingestion of the state that cooperating game servers push, i.e.
+// because they are behind NAT and can't be reached by A2S queries.
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/models"
+	"github.com/syncore/a2sapi/src/steam"
+)
+
+const (
+	// maxIngestBodySize is the maximum size of a pushed state.
+	maxIngestBodySize = 64 << 10
+	// maxIngestStringLen is the maximum length of the pushed strings (the name,
+	// map, and so on, and each player name and rule).
+	maxIngestStringLen = 256
+	// maxIngestCount is the maximum number of players, and of pushed player
+	// entries, as in A2S_INFO's and A2S_PLAYER's one byte counts.
+	maxIngestCount = 255
+)
+
+// ingestServerState stores a pushed state; see steam.IngestServerState.
+var ingestServerState = steam.IngestServerState
+
+// findIngestServer returns the configured ingest server whose token is token.
+func findIngestServer(token string) (config.IngestServer, bool) {
+	if token == "" {
+		return config.IngestServer{}, false
+	}
+	for _, s := range config.Get().WebConfig.IngestServers {
+		if s.Token != "" &&
+			subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) == 1 {
+			return s, true
+		}
+	}
+	return config.IngestServer{}, false
+}
+
+// validIngestString determines whether a pushed string is valid UTF-8 of at
+// most the maximum length.
+func validIngestString(s string) bool {
+	return len(s) <= maxIngestStringLen && utf8.ValidString(s)
+}
+
+// validateIngestState returns an error describing an invalid field of a pushed
+// state, if it has one.
+func validateIngestState(st models.APIIngestState) error {
+	if st.Name == "" {
+		return errors.New("The server name is required.")
+	}
+	for field, s := range map[string]string{"name": st.Name, "map": st.Map,
+		"gameDir": st.GameDir, "version": st.Version, "keywords": st.Keywords} {
+		if !validIngestString(s) {
+			return fmt.Errorf("The %s must be valid UTF-8 of at most %d bytes.", field,
+				maxIngestStringLen)
+		}
+	}
+	for field, n := range map[string]int{"players": st.Players,
+		"maxPlayers": st.MaxPlayers, "bots": st.Bots} {
+		if n < 0 || n > maxIngestCount {
+			return fmt.Errorf("The %s must be a number from 0 to %d.", field,
+				maxIngestCount)
+		}
+	}
+	if st.Bots > st.Players {
+		return errors.New("The bots can't exceed the players.")
+	}
+	if len(st.PlayerList) > maxIngestCount {
+		return fmt.Errorf("The player list can have at most %d players.",
+			maxIngestCount)
+	}
+	for _, p := range st.PlayerList {
+		if !validIngestString(p.Name) || p.SecsConnected < 0 ||
+			math.IsNaN(float64(p.SecsConnected)) ||
+			math.IsInf(float64(p.SecsConnected), 0) {
+			return errors.New("The player list contains an invalid player.")
+		}
+	}
+	for k, v := range st.Rules {
+		if k == "" || !validIngestString(k) || !validIngestString(v) {
+			return errors.New("The rules contain an invalid rule.")
+		}
+	}
+	return nil
+}
+
+func ingestServer(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if len(config.Get().WebConfig.IngestServers) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w,
+			`{"error": {"code": 404,"message": "Ingestion is disabled."}}`)
+		return
+	}
+	srv, ok := findIngestServer(getRequestKey(r))
+	if !ok {
+		logger.LogWebErrorf("Invalid ingest token from %s", r.RemoteAddr)
+		writeUnauthorized(w, "Invalid ingest token.")
+		return
+	}
+	st := models.APIIngestState{}
+	d := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxIngestBodySize))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&st); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w,
+			`{"error": {"code": 400,"message": "The body must be a JSON server state of at most %d bytes."}}`,
+			maxIngestBodySize)
+		return
+	}
+	if err := validateIngestState(st); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"error": {"code": 400,"message": "%s"}}`, err)
+		return
+	}
+	interval := time.Duration(
+		config.Get().WebConfig.IngestMinInterval) * time.Second
+	if wait := ingestServerState(srv.Game, srv.Address, st, interval); wait > 0 {
+		w.Header().Set("Retry-After",
+			strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprintf(w,
+			`{"error": {"code": 429,"message": "The server pushed its state recently. Try again later."}}`)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	writeJSONResponse(w, struct {
+		Game    string `json:"game"`
+		Address string `json:"address"`
+	}{srv.Game, srv.Address})
+}
@@ -0,0 +1,95 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/models"
+)
+
+func TestIngestServer(t *testing.T) {
+	origIngest := ingestServerState
+	origServers := config.Get().WebConfig.IngestServers
+	defer func() {
+		ingestServerState = origIngest
+		config.Get().WebConfig.IngestServers = origServers
+	}()
+	var pushedGame, pushedHost string
+	var pushed models.APIIngestState
+	var wait time.Duration
+	ingestServerState = func(game, host string, st models.APIIngestState,
+		minInterval time.Duration) time.Duration {
+		if wait == 0 {
+			pushedGame, pushedHost, pushed = game, host, st
+		}
+		return wait
+	}
+
+	serve := func(token, body string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("POST", "/ingest", strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		ingestServer(w, r)
+		return w
+	}
+	valid := `{"name": "NAT server", "map": "bloodrun", "players": 2,
+	"maxPlayers": 8, "playerList": [{"name": "a", "score": 3, "secsConnected": 61}],
+	"rules": {"g_gametype": "1"}}`
+
+	config.Get().WebConfig.IngestServers = nil
+	if w := serve("secret", valid); w.Code != http.StatusNotFound {
+		t.Fatalf("Expected status code %d when disabled, got: %d",
+			http.StatusNotFound, w.Code)
+	}
+	config.Get().WebConfig.IngestServers = []config.IngestServer{
+		{Game: "QuakeLive", Address: "203.0.113.5:27960", Token: "secret"},
+	}
+	for _, token := range []string{"", "wrong"} {
+		if w := serve(token, valid); w.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected status code %d for token '%s', got: %d",
+				http.StatusUnauthorized, token, w.Code)
+		}
+	}
+
+	w := serve("secret", valid)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected status code %d, got: %d %s", http.StatusAccepted, w.Code,
+			w.Body)
+	}
+	if pushedGame != "QuakeLive" || pushedHost != "203.0.113.5:27960" ||
+		pushed.Name != "NAT server" || pushed.Players != 2 ||
+		len(pushed.PlayerList) != 1 || pushed.Rules["g_gametype"] != "1" {
+		t.Fatalf("Expected the state to be ingested for the token's server, got: %s %s %+v",
+			pushedGame, pushedHost, pushed)
+	}
+
+	invalid := []string{
+		`not json`,
+		`{"name": "x", "unknown": 1}`,
+		`{"map": "bloodrun"}`,
+		`{"name": "x", "players": 300}`,
+		`{"name": "x", "players": 1, "bots": 2}`,
+		`{"name": "` + strings.Repeat("x", maxIngestStringLen+1) + `"}`,
+		`{"name": "x", "rules": {"": "1"}}`,
+		`{"name": "x", "playerList": [{"name": "a", "secsConnected": -1}]}`,
+	}
+	for _, body := range invalid {
+		if w := serve("secret", body); w.Code != http.StatusBadRequest {
+			t.Fatalf("Expected status code %d for %s, got: %d",
+				http.StatusBadRequest, body, w.Code)
+		}
+	}
+
+	wait = 3500 * time.Millisecond
+	w = serve("secret", valid)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "4" {
+		t.Fatalf("Expected status code %d with Retry-After 4, got: %d %s",
+			http.StatusTooManyRequests, w.Code, w.Header().Get("Retry-After"))
+	}
+}
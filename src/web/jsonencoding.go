@@ -0,0 +1,50 @@
+package web
+
+// jsonencoding.go - Encoding of JSON responses, as configured: with or without
+// HTML escaping, and optionally with the keys of all objects in alphabetical
+// order so that responses can be diffed.
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+
+	"github.com/syncore/a2sapi/src/config"
+)
+
+// encodeJSON writes v to w as JSON, followed by a newline.
+func encodeJSON(w io.Writer, v interface{}) error {
+	b, err := marshalJSON(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// marshalJSON returns v encoded as JSON.
+func marshalJSON(v interface{}) ([]byte, error) {
+	escape := config.Get().WebConfig.JSONEscapeHTML
+	b, err := marshalJSONEscaped(v, escape)
+	if err != nil || !config.Get().WebConfig.JSONSortKeys {
+		return b, err
+	}
+	// objects decoded as maps are encoded with their keys in sorted order
+	var sorted interface{}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	if err := d.Decode(&sorted); err != nil {
+		return nil, err
+	}
+	return marshalJSONEscaped(sorted, escape)
+}
+
+func marshalJSONEscaped(v interface{}, escape bool) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(escape)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
@@ -0,0 +1,104 @@
+package web
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/models"
+)
+
+func TestEncodeJSON(t *testing.T) {
+	prev := config.Get().WebConfig
+	defer func() {
+		config.Get().WebConfig = prev
+		models.SetJSONEncoding(models.JSONEncoding{EscapeHTML: true})
+	}()
+	sl := models.APIServerList{Servers: []models.APIServer{
+		{Host: "10.0.0.1:27960", Info: models.SteamServerInfo{Name: "<Duel & FFA>"}}}}
+	encode := func() string {
+		var buf bytes.Buffer
+		if err := encodeJSON(&buf, sl); err != nil {
+			t.Fatalf("Unexpected error encoding server list: %s", err)
+		}
+		return buf.String()
+	}
+
+	config.Get().WebConfig.JSONEscapeHTML = true
+	config.Get().WebConfig.JSONSortKeys = false
+	models.SetJSONEncoding(models.JSONEncoding{EscapeHTML: true,
+		EmptyLists: models.JSONEmptyListsArray})
+	out := encode()
+	if !strings.Contains(out, `\u003cDuel \u0026 FFA\u003e`) {
+		t.Fatalf("Expected HTML characters to be escaped, got: %s", out)
+	}
+	for _, empty := range []string{`"failedServers":[]`, `"players":[]`,
+		`"rules":{}`, `"tags":[]`} {
+		if !strings.Contains(out, empty) {
+			t.Fatalf("Expected %s in: %s", empty, out)
+		}
+	}
+	if strings.Index(out, `"timestamp"`) > strings.Index(out, `"serverCount"`) {
+		t.Fatalf("Expected keys in struct order, got: %s", out)
+	}
+
+	config.Get().WebConfig.JSONEscapeHTML = false
+	config.Get().WebConfig.JSONSortKeys = true
+	models.SetJSONEncoding(models.JSONEncoding{EscapeHTML: false,
+		EmptyLists: models.JSONEmptyListsNull})
+	out = encode()
+	if !strings.Contains(out, `"<Duel & FFA>"`) {
+		t.Fatalf("Expected HTML characters not to be escaped, got: %s", out)
+	}
+	for _, empty := range []string{`"failedServers":null`, `"players":null`,
+		`"rules":null`, `"tags":null`} {
+		if !strings.Contains(out, empty) {
+			t.Fatalf("Expected %s in: %s", empty, out)
+		}
+	}
+	if strings.Index(out, `"timestamp"`) < strings.Index(out, `"serverCount"`) ||
+		!strings.HasSuffix(out, "}\n") {
+		t.Fatalf("Expected keys in alphabetical order, got: %s", out)
+	}
+
+	if err := models.SetJSONEncoding(models.JSONEncoding{
+		EmptyLists: "omit"}); err == nil {
+		t.Fatalf("Expected unknown encoding of empty lists to be rejected")
+	}
+}
+
+func TestEncodeJSONEmptyInfo(t *testing.T) {
+	defer models.SetJSONEncoding(models.JSONEncoding{EscapeHTML: true})
+	s := models.APIOfflineServer{Status: models.ServerStatusOffline, ID: 7,
+		Host: "10.0.0.1:27960"}.Server()
+	encode := func(v interface{}) string {
+		var buf bytes.Buffer
+		if err := encodeJSON(&buf, v); err != nil {
+			t.Fatalf("Unexpected error encoding server: %s", err)
+		}
+		return buf.String()
+	}
+
+	models.SetJSONEncoding(models.JSONEncoding{EscapeHTML: true,
+		EmptyInfo: models.JSONEmptyInfoObject})
+	if out := encode(s); !strings.Contains(out, `"info":{}`) {
+		t.Fatalf("Expected server without info to have an empty info object, got: %s",
+			out)
+	}
+	models.SetJSONEncoding(models.JSONEncoding{EscapeHTML: true,
+		EmptyInfo: models.JSONEmptyInfoOmit})
+	if out := encode(&s); strings.Contains(out, `"info"`) ||
+		!strings.Contains(out, `"serverID":7`) {
+		t.Fatalf("Expected server without info to have no info, got: %s", out)
+	}
+	s.Info.Name = "Duel"
+	if out := encode(s); !strings.Contains(out, `"serverName":"Duel"`) ||
+		!strings.Contains(out, `"players":0`) {
+		t.Fatalf("Expected server info to be encoded, got: %s", out)
+	}
+	if err := models.SetJSONEncoding(models.JSONEncoding{
+		EmptyInfo: "null"}); err == nil {
+		t.Fatalf("Expected unknown encoding of empty info to be rejected")
+	}
+}
@@ -0,0 +1,139 @@
+package web
+
+// limiter.go - Limits the number of requests that are handled at once, globally
+// and per-route, so that bursts (especially of direct queries, which consume
+// outbound UDP capacity) are turned away rather than queued.
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/logger"
+)
+
+// concurrencyLimiter is a counting semaphore; a nil limiter does not limit.
+type concurrencyLimiter chan struct{}
+
+func newConcurrencyLimiter(max int) concurrencyLimiter {
+	if max <= 0 {
+		return nil
+	}
+	return make(concurrencyLimiter, max)
+}
+
+// tryAcquire reserves a slot without waiting, returning false if none are free.
+func (l concurrencyLimiter) tryAcquire() bool {
+	if l == nil {
+		return true
+	}
+	select {
+	case l <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (l concurrencyLimiter) release() {
+	if l == nil {
+		return
+	}
+	<-l
+}
+
+// apiConcurrencyLimits are the API's global and per-route (by route name)
+// concurrency limiters, replaced when maxConcurrentRequests or
+// routeConcurrencyLimits is reloaded. Requests in progress release the slot of
+// the limiter they acquired it from, so they don't count towards a replacement.
+var apiConcurrencyLimits = struct {
+	sync.RWMutex
+	global      concurrencyLimiter
+	routes      map[string]concurrencyLimiter
+	max         int
+	routeMaxima map[string]int
+}{}
+
+func init() {
+	config.OnReload(func(old, cfg *config.Cfg) {
+		setAPIConcurrencyLimits(cfg.WebConfig.MaxConcurrentRequests,
+			cfg.WebConfig.RouteConcurrencyLimits)
+	})
+}
+
+// setAPIConcurrencyLimits sets the API's concurrent request limits, replacing
+// only the limiters whose limit changed.
+func setAPIConcurrencyLimits(max int, routeMaxima map[string]int) {
+	apiConcurrencyLimits.Lock()
+	defer apiConcurrencyLimits.Unlock()
+	if apiConcurrencyLimits.routes == nil || max != apiConcurrencyLimits.max {
+		apiConcurrencyLimits.global = newConcurrencyLimiter(max)
+		apiConcurrencyLimits.max = max
+	}
+	routes := make(map[string]concurrencyLimiter, len(routeMaxima))
+	maxima := make(map[string]int, len(routeMaxima))
+	for name, m := range routeMaxima {
+		if l, ok := apiConcurrencyLimits.routes[name]; ok &&
+			apiConcurrencyLimits.routeMaxima[name] == m {
+			routes[name] = l
+		} else {
+			routes[name] = newConcurrencyLimiter(m)
+		}
+		maxima[name] = m
+	}
+	apiConcurrencyLimits.routes = routes
+	apiConcurrencyLimits.routeMaxima = maxima
+}
+
+// getGlobalConcurrencyLimiter returns the API's current global limiter.
+func getGlobalConcurrencyLimiter() concurrencyLimiter {
+	apiConcurrencyLimits.RLock()
+	defer apiConcurrencyLimits.RUnlock()
+	return apiConcurrencyLimits.global
+}
+
+// routeConcurrencyLimiter returns a function that returns the current limiter
+// of the named route.
+func routeConcurrencyLimiter(name string) func() concurrencyLimiter {
+	return func() concurrencyLimiter {
+		apiConcurrencyLimits.RLock()
+		defer apiConcurrencyLimits.RUnlock()
+		return apiConcurrencyLimits.routes[name]
+	}
+}
+
+// limitConcurrency wraps an HTTP handler so that it is only served when a slot
+// is free in limiter; otherwise a 503 response with a Retry-After header is
+// returned.
+func limitConcurrency(h http.Handler, limiter concurrencyLimiter,
+	name string) http.Handler {
+	if limiter == nil {
+		return h
+	}
+	return limitConcurrencyWith(h, func() concurrencyLimiter { return limiter },
+		name)
+}
+
+// limitConcurrencyWith is limitConcurrency with the limiter returned by limiter
+// for each request, so that it can be replaced while serving.
+func limitConcurrencyWith(h http.Handler, limiter func() concurrencyLimiter,
+	name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l := limiter()
+		if !l.tryAcquire() {
+			logger.WriteDebug("%s: concurrent request limit (%d) reached", name,
+				cap(l))
+			w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+			w.Header().Set("Retry-After",
+				strconv.Itoa(config.Get().WebConfig.APIWebTimeout))
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w,
+				`{"error": {"code": 503,"message": "Too many concurrent requests. Try again later."}}`)
+			return
+		}
+		defer l.release()
+		h.ServeHTTP(w, r)
+	})
+}
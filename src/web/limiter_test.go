@@ -0,0 +1,214 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/syncore/a2sapi/src/config"
+)
+
+func TestLimitConcurrency(t *testing.T) {
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	h := limitConcurrency(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		started <- struct{}{}
+		<-finish
+	}), newConcurrencyLimiter(1), "test")
+
+	done := make(chan struct{})
+	go func() {
+		r, _ := http.NewRequest("GET", "/", nil)
+		h.ServeHTTP(httptest.NewRecorder(), r)
+		close(done)
+	}()
+	<-started
+
+	r, _ := http.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status code %d when limit is reached, got: %d",
+			http.StatusServiceUnavailable, w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Fatalf("Expected Retry-After header when limit is reached")
+	}
+	close(finish)
+	<-done
+
+	// slot was released
+	finish = make(chan struct{})
+	go func() { <-started; close(finish) }()
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d after slot was released, got: %d",
+			http.StatusOK, w.Code)
+	}
+}
+
+func TestNoConcurrencyLimit(t *testing.T) {
+	l := newConcurrencyLimiter(0)
+	if l != nil {
+		t.Fatalf("Expected a limit of 0 to disable the limiter")
+	}
+	for i := 0; i < 100; i++ {
+		if !l.tryAcquire() {
+			t.Fatalf("Expected disabled limiter to never reject")
+		}
+	}
+}
+
+func TestReloadConcurrencyLimits(t *testing.T) {
+	defer setAPIConcurrencyLimits(config.Get().WebConfig.MaxConcurrentRequests,
+		config.Get().WebConfig.RouteConcurrencyLimits)
+	setAPIConcurrencyLimits(0, map[string]int{"test": 1})
+
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	h := limitConcurrencyWith(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		started <- struct{}{}
+		<-finish
+	}), routeConcurrencyLimiter("test"), "test")
+	serve := func() int {
+		r, _ := http.NewRequest("GET", "/", nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+	done := make(chan struct{})
+	go func() {
+		serve()
+		close(done)
+	}()
+	<-started
+	if code := serve(); code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status code %d when limit is reached, got: %d",
+			http.StatusServiceUnavailable, code)
+	}
+
+	// an unchanged limit keeps its limiter
+	l := routeConcurrencyLimiter("test")()
+	setAPIConcurrencyLimits(0, map[string]int{"test": 1})
+	if routeConcurrencyLimiter("test")() != l {
+		t.Fatalf("Expected the limiter to be kept when its limit is unchanged")
+	}
+	// a raised limit applies to the next request
+	setAPIConcurrencyLimits(0, map[string]int{"test": 2})
+	second := make(chan int)
+	go func() { second <- serve() }()
+	select {
+	case <-started:
+	case code := <-second:
+		t.Fatalf("Expected request to be served after the limit was raised, got: %d",
+			code)
+	}
+	close(finish)
+	<-done
+	if code := <-second; code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got: %d", http.StatusOK, code)
+	}
+	if getGlobalConcurrencyLimiter() != nil {
+		t.Fatalf("Expected a global limit of 0 to disable the limiter")
+	}
+}
+
+func TestIsTrustedRequest(t *testing.T) {
+	trusted := parseTrustedNetworks([]string{"10.0.0.0/8", " 192.0.2.10 ",
+		"2001:db8::/32", "invalid", "192.0.2.300"})
+	if len(trusted) != 3 {
+		t.Fatalf("Expected 3 trusted networks, got: %d", len(trusted))
+	}
+	tests := []struct {
+		addr    string
+		trusted bool
+	}{
+		{"10.1.2.3:50000", true},
+		{"192.0.2.10:50000", true},
+		{"192.0.2.11:50000", false},
+		{"[2001:db8::1]:50000", true},
+		{"[::ffff:10.1.2.3]:50000", true},
+		{"@", false},
+	}
+	for _, tt := range tests {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.addr
+		if isTrustedRequest(r, trusted) != tt.trusted {
+			t.Errorf("Expected trusted=%v for %s", tt.trusted, tt.addr)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	prevProxies := trustedProxies
+	prevSocket := config.Get().WebConfig.APIWebUnixSocket
+	defer func() {
+		trustedProxies = prevProxies
+		config.Get().WebConfig.APIWebUnixSocket = prevSocket
+	}()
+	trustedProxies = parseNetworks([]string{"10.0.0.0/8"}, "trusted proxy")
+
+	tests := []struct {
+		addr      string
+		forwarded []string
+		socket    string
+		expected  string
+	}{
+		{"192.0.2.1:50000", nil, "", "192.0.2.1"},
+		// only honored from trusted proxies
+		{"192.0.2.1:50000", []string{"198.51.100.1"}, "", "192.0.2.1"},
+		{"10.0.0.1:50000", []string{"198.51.100.1"}, "", "198.51.100.1"},
+		// addresses added before an untrusted one are ignored
+		{"10.0.0.1:50000", []string{"203.0.113.9, 198.51.100.1, 10.0.0.2"}, "",
+			"198.51.100.1"},
+		{"10.0.0.1:50000", []string{"203.0.113.9", "198.51.100.1"}, "",
+			"198.51.100.1"},
+		{"10.0.0.1:50000", []string{"10.0.0.3, 10.0.0.2"}, "", "10.0.0.3"},
+		{"10.0.0.1:50000", []string{"garbage, 10.0.0.2"}, "", "10.0.0.2"},
+		{"10.0.0.1:50000", nil, "", "10.0.0.1"},
+		// unix socket connections come from the local reverse proxy
+		{"@", []string{"198.51.100.1"}, "", "@"},
+		{"@", []string{"198.51.100.1"}, "/run/a2sapi.sock", "198.51.100.1"},
+		{"@", nil, "/run/a2sapi.sock", "@"},
+	}
+	for _, tt := range tests {
+		config.Get().WebConfig.APIWebUnixSocket = tt.socket
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.addr
+		for _, f := range tt.forwarded {
+			r.Header.Add("X-Forwarded-For", f)
+		}
+		if ip := clientIP(r); ip != tt.expected {
+			t.Errorf("Expected client IP %s for %s forwarded for %v; got: %s",
+				tt.expected, tt.addr, tt.forwarded, ip)
+		}
+	}
+}
+
+// TestTrustedRequiresScope tests that requests from trusted networks are still
+// only served for keys with the route's scope
+func TestTrustedRequiresScope(t *testing.T) {
+	prevRequire := config.Get().AdminConfig.RequireAPIKeys
+	prevAnon := config.Get().AdminConfig.AnonymousScopes
+	prevTrusted := config.Get().AdminConfig.TrustedIPs
+	defer func() {
+		config.Get().AdminConfig.RequireAPIKeys = prevRequire
+		config.Get().AdminConfig.AnonymousScopes = prevAnon
+		config.Get().AdminConfig.TrustedIPs = prevTrusted
+	}()
+	config.Get().AdminConfig.RequireAPIKeys = true
+	config.Get().AdminConfig.AnonymousScopes = []string{}
+	config.Get().AdminConfig.TrustedIPs = []string{"192.0.2.10"}
+
+	r, _ := http.NewRequest("GET", formatURL("servers/count"), nil)
+	r.RemoteAddr = "192.0.2.10:50000"
+	w := newRecorder()
+	newRouter().ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status code %v for trusted request without a key; got: %v",
+			http.StatusUnauthorized, w.Code)
+	}
+}
@@ -0,0 +1,164 @@
+package web
+
+// live.go - WebSocket endpoint that pushes the changes to the server lists to
+// connected clients after each retrieval, so that clients don't need to poll.
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/models"
+)
+
+// livePingInterval is how often live clients are pinged to keep idle
+// connections (i.e. through proxies) open and to detect dead clients.
+const livePingInterval = 30 * time.Second
+
+// liveMessage is sent to live clients: a snapshot of a game's (filtered) list
+// when they connect, then the changes to it after each retrieval.
+type liveMessage struct {
+	// "snapshot" or "delta"
+	Type string `json:"type"`
+	// lower case game name
+	Game               string             `json:"game"`
+	CycleID            string             `json:"cycleID,omitempty"`
+	RetrievedTimeStamp int64              `json:"timestamp"`
+	Servers            []models.APIServer `json:"servers,omitempty"`
+	*models.APIServerListDiff
+}
+
+// liveSubscriber tracks the (filtered) lists last sent to a live client, which
+// the changes are computed against.
+type liveSubscriber struct {
+	game    string
+	filters []slQueryFilter
+	sent    map[string]*models.APIServerList
+}
+
+func newLiveSubscriber(game string, filters []slQueryFilter) *liveSubscriber {
+	return &liveSubscriber{game: strings.ToLower(game), filters: filters,
+		sent: make(map[string]*models.APIServerList)}
+}
+
+func (ls *liveSubscriber) wants(game string) bool {
+	return ls.game == "" || ls.game == game
+}
+
+// snapshot returns the snapshot messages for the games that currently have a
+// list, sorted by game.
+func (ls *liveSubscriber) snapshot(lists map[string]*models.APIServerList) []liveMessage {
+	games := make([]string, 0, len(lists))
+	for g := range lists {
+		if ls.wants(g) && lists[g] != nil {
+			games = append(games, g)
+		}
+	}
+	sort.Strings(games)
+	msgs := make([]liveMessage, 0, len(games))
+	for _, g := range games {
+		sl := filterServers(ls.filters, lists[g])
+		ls.sent[g] = sl
+		msgs = append(msgs, liveMessage{Type: "snapshot", Game: g,
+			CycleID: sl.CycleID, RetrievedTimeStamp: sl.RetrievedTimeStamp,
+			Servers: sl.Servers})
+	}
+	return msgs
+}
+
+// update returns the delta message for a game whose list was replaced by sl (or
+// removed, if sl is nil), or nil if nothing the client is interested in changed.
+func (ls *liveSubscriber) update(game string,
+	sl *models.APIServerList) *liveMessage {
+	game = strings.ToLower(game)
+	if !ls.wants(game) {
+		return nil
+	}
+	var filtered *models.APIServerList
+	if sl != nil {
+		filtered = filterServers(ls.filters, sl)
+	}
+	d := models.DiffServerLists(ls.sent[game], filtered)
+	msg := &liveMessage{Type: "delta", Game: game, APIServerListDiff: d}
+	if filtered == nil {
+		delete(ls.sent, game)
+	} else {
+		ls.sent[game] = filtered
+		msg.CycleID, msg.RetrievedTimeStamp = filtered.CycleID,
+			filtered.RetrievedTimeStamp
+	}
+	if len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0 {
+		return nil
+	}
+	return msg
+}
+
+func writeLiveMessage(c *wsConn, msg liveMessage) error {
+	b, err := marshalJSON(msg)
+	if err != nil {
+		return err
+	}
+	return c.writeText(b)
+}
+
+func getServersLive(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if !isWebSocketRequest(r) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w,
+			`{"error": {"code": 400,"message": "This endpoint requires a WebSocket connection."}}`)
+		return
+	}
+	if rejectWebSocketHandshake(w, r) {
+		return
+	}
+	q := r.URL.Query()
+	game, _ := getQStringValue(q, qsServersGame)
+	ls := newLiveSubscriber(game, getSrvFilterFromQString(q,
+		liveFilterQueryStrings))
+
+	// subscribe before the snapshot so that no update is missed in between
+	updates := models.SubscribeGameLists()
+	defer models.UnsubscribeGameLists(updates)
+	c, err := upgradeWebSocket(w, r)
+	if err != nil {
+		logger.LogWebErrorf("Unable to upgrade live connection from %s: %s",
+			r.RemoteAddr, err)
+		return
+	}
+	defer c.close()
+	done := make(chan struct{})
+	go func() {
+		c.readLoop()
+		close(done)
+	}()
+
+	for _, msg := range ls.snapshot(models.GameLists()) {
+		if err := writeLiveMessage(c, msg); err != nil {
+			return
+		}
+	}
+	ping := time.NewTicker(livePingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case g := <-updates:
+			msg := ls.update(g, models.GetGameList(g))
+			if msg == nil {
+				continue
+			}
+			if err := writeLiveMessage(c, *msg); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := c.writeFrame(wsOpPing, nil); err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package web
+
+// Tests for the live server list WebSocket endpoint
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/models"
+)
+
+// readServerFrame reads an unmasked frame sent by the server, returning its
+// opcode and payload.
+func readServerFrame(t *testing.T, br *bufio.Reader) (byte, []byte) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		t.Fatalf("Unable to read frame: %s", err)
+	}
+	if hdr[1]&0x80 != 0 {
+		t.Fatalf("Expected unmasked frame from the server")
+	}
+	n := uint64(hdr[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		io.ReadFull(br, ext[:])
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		io.ReadFull(br, ext[:])
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(br, payload); err != nil {
+		t.Fatalf("Unable to read frame payload: %s", err)
+	}
+	return hdr[0] & 0x0F, payload
+}
+
+// readServerText reads a text frame sent by the server.
+func readServerText(t *testing.T, br *bufio.Reader) []byte {
+	opcode, payload := readServerFrame(t, br)
+	if opcode != wsOpText {
+		t.Fatalf("Expected text frame, got opcode: %d", opcode)
+	}
+	return payload
+}
+
+func TestGetServersLive(t *testing.T) {
+	game := "LiveTest"
+	srv := func(host, m string) models.APIServer {
+		return models.APIServer{Host: host, Game: game,
+			CountryInfo: models.DbCountry{CountryCode: "DE"},
+			Info:        models.SteamServerInfo{Map: m}}
+	}
+	models.SetGameList(game, &models.APIServerList{Servers: []models.APIServer{
+		srv("10.0.0.1:27960", "bloodrun"), srv("10.0.0.2:27960", "campgrounds")}})
+	defer models.SetGameList(game, nil)
+
+	ts := httptest.NewServer(http.HandlerFunc(getServersLive))
+	defer ts.Close()
+
+	// plain HTTP requests are rejected
+	resp, err := http.Get(ts.URL + "/servers/live")
+	if err != nil {
+		t.Fatalf("Unable to make request: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status code %d for non-WebSocket request, got: %d",
+			http.StatusBadRequest, resp.StatusCode)
+	}
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(ts.URL, "http://"))
+	if err != nil {
+		t.Fatalf("Unable to connect: %s", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	fmt.Fprintf(conn, "GET /servers/live?game=livetest&countries=DE HTTP/1.1\r\n"+
+		"Host: localhost\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n\r\n", key)
+	br := bufio.NewReader(conn)
+	resp, err = http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("Unable to read handshake response: %s", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected status code %d, got: %d", http.StatusSwitchingProtocols,
+			resp.StatusCode)
+	}
+	if accept := resp.Header.Get("Sec-WebSocket-Accept"); accept !=
+		"s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("Unexpected Sec-WebSocket-Accept: %s", accept)
+	}
+
+	msg := liveMessage{}
+	if err := json.Unmarshal(readServerText(t, br), &msg); err != nil {
+		t.Fatalf("Unable to decode snapshot: %s", err)
+	}
+	if msg.Type != "snapshot" || msg.Game != "livetest" || len(msg.Servers) != 2 {
+		t.Fatalf("Expected snapshot with 2 servers, got: %+v", msg)
+	}
+
+	models.SetGameList(game, &models.APIServerList{Servers: []models.APIServer{
+		srv("10.0.0.1:27960", "campgrounds"), srv("10.0.0.2:27960", "campgrounds"),
+		srv("10.0.0.3:27960", "bloodrun")}})
+	msg = liveMessage{}
+	if err := json.Unmarshal(readServerText(t, br), &msg); err != nil {
+		t.Fatalf("Unable to decode delta: %s", err)
+	}
+	if msg.Type != "delta" || msg.APIServerListDiff == nil ||
+		len(msg.Added) != 1 || len(msg.Changed) != 1 || len(msg.Removed) != 0 {
+		t.Fatalf("Expected delta with 1 added and 1 changed server, got: %+v", msg)
+	}
+
+	// masked close frame from the client is echoed
+	conn.Write([]byte{0x88, 0x82, 1, 2, 3, 4, 0x03 ^ 1, 0xE8 ^ 2})
+	var hdr [2]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil || hdr[0] != 0x88 {
+		t.Fatalf("Expected close frame, got: %v (%v)", hdr, err)
+	}
+}
+
+// TestWebSocketUnmaskedFrame tests that the connection is closed with a
+// protocol error when the client sends an unmasked frame
+func TestWebSocketUnmaskedFrame(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	c := &wsConn{conn: server, br: bufio.NewReader(server)}
+	done := make(chan struct{})
+	go func() {
+		c.readLoop()
+		c.close()
+		close(done)
+	}()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// unmasked ping
+	client.Write([]byte{0x89, 0x02, 'h', 'i'})
+	opcode, payload := readServerFrame(t, bufio.NewReader(client))
+	if opcode != wsOpClose || len(payload) != 2 ||
+		binary.BigEndian.Uint16(payload) != 1002 {
+		t.Fatalf("Expected close frame with status 1002, got opcode %d: %v",
+			opcode, payload)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected connection to be closed after an unmasked frame")
+	}
+}
+
+// TestRejectWebSocketHandshake tests that handshakes with an unsupported
+// version, or from origins that aren't allowed, are rejected
+func TestRejectWebSocketHandshake(t *testing.T) {
+	prev := config.Get().WebConfig.WebSocketAllowedOrigins
+	defer func() { config.Get().WebConfig.WebSocketAllowedOrigins = prev }()
+	config.Get().WebConfig.WebSocketAllowedOrigins = []string{
+		"https://servers.example.com/"}
+	for _, tt := range []struct {
+		version, origin string
+		code            int
+	}{
+		{"13", "", 0},
+		{"13", "https://servers.example.com", 0},
+		{"13", "HTTPS://Servers.Example.com", 0},
+		{"13", "http://api.example.com", 0},
+		{"13", "http://servers.example.com", http.StatusForbidden},
+		{"13", "https://evil.example.com", http.StatusForbidden},
+		{"13", "null", http.StatusForbidden},
+		{"8", "", http.StatusBadRequest},
+		{"", "https://servers.example.com", http.StatusBadRequest},
+	} {
+		r, _ := http.NewRequest("GET", "http://api.example.com/servers/live", nil)
+		r.Header.Set("Sec-WebSocket-Version", tt.version)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		w := httptest.NewRecorder()
+		rejected := rejectWebSocketHandshake(w, r)
+		if rejected != (tt.code != 0) || (rejected && w.Code != tt.code) {
+			t.Errorf("Expected handshake (version %q, origin %q) to get %d, got: %v %d",
+				tt.version, tt.origin, tt.code, rejected, w.Code)
+		}
+		if tt.code == http.StatusBadRequest &&
+			w.Header().Get("Sec-WebSocket-Version") != "13" {
+			t.Errorf("Expected supported version in the response")
+		}
+	}
+
+	config.Get().WebConfig.WebSocketAllowedOrigins = []string{"*"}
+	r, _ := http.NewRequest("GET", "http://api.example.com/servers/live", nil)
+	r.Header.Set("Sec-WebSocket-Version", "13")
+	r.Header.Set("Origin", "https://evil.example.com")
+	if rejectWebSocketHandshake(httptest.NewRecorder(), r) {
+		t.Errorf("Expected any origin to be allowed with *")
+	}
+}
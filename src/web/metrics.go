@@ -0,0 +1,47 @@
+package web
+
+// metrics.go - Prometheus metrics endpoint, and the API request metrics
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/util"
+)
+
+var apiRequestDurationMetric = util.NewHistogram(
+	"a2sapi_http_request_duration_seconds",
+	"Duration of API requests, by route.", util.DurationBuckets, "route")
+
+// observeRequests wraps an HTTP handler so that the duration of its requests,
+// including time spent waiting on the concurrency limits, is recorded.
+func observeRequests(h http.Handler, route string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		h.ServeHTTP(w, r)
+		apiRequestDurationMetric.Observe(time.Since(start).Seconds(), route)
+	})
+}
+
+func getMetrics(w http.ResponseWriter, r *http.Request) {
+	if !config.Get().WebConfig.EnableMetrics {
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w,
+			`{"error": {"code": 404,"message": "Metrics are disabled."}}`)
+		return
+	}
+	writeMetrics(w, r)
+}
+
+// writeMetrics writes all of the application's metrics in the Prometheus text
+// format.
+func writeMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := util.WriteMetrics(w); err != nil {
+		logger.LogWebErrorf("Error writing metrics: %s", err)
+	}
+}
@@ -0,0 +1,66 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/util"
+)
+
+// Metrics can only be registered once and keep their values, so the test
+// counter is registered with the package, and each run of TestGetMetrics uses
+// its own label values.
+var (
+	testCounterMetric = util.NewCounter("a2sapi_test_total", "Test counter.",
+		"name")
+	metricsTestRuns int
+)
+
+func TestGetMetrics(t *testing.T) {
+	metricsTestRuns++
+	name := fmt.Sprintf(`quoted "name" %d`, metricsTestRuns)
+	route := fmt.Sprintf("TestRoute%d", metricsTestRuns)
+	testCounterMetric.Inc(name)
+	testCounterMetric.Add(2, name)
+	h := observeRequests(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+	}), route)
+	r, _ := http.NewRequest("GET", formatURL("metrics"), nil)
+	h.ServeHTTP(newRecorder(), r)
+
+	w := newRecorder()
+	getMetrics(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code: %v; got: %v", http.StatusOK, w.Code)
+	}
+	body := w.Body.String()
+	for _, expected := range []string{
+		"# TYPE a2sapi_test_total counter\n",
+		fmt.Sprintf(`a2sapi_test_total{name="quoted \"name\" %d"} 3`,
+			metricsTestRuns) + "\n",
+		"# TYPE a2sapi_http_request_duration_seconds histogram\n",
+		`a2sapi_http_request_duration_seconds_bucket{route="` + route +
+			`",le="0.005"} 1` + "\n",
+		`a2sapi_http_request_duration_seconds_bucket{route="` + route +
+			`",le="+Inf"} 1` + "\n",
+		`a2sapi_http_request_duration_seconds_count{route="` + route + `"} 1` + "\n",
+		"# TYPE a2sapi_db_query_duration_seconds histogram\n",
+		"# TYPE a2sapi_a2s_requests_total counter\n",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Fatalf("Expected metrics to contain %q, got:\n%s", expected, body)
+		}
+	}
+
+	config.Get().WebConfig.EnableMetrics = false
+	defer func() { config.Get().WebConfig.EnableMetrics = true }()
+	w = newRecorder()
+	getMetrics(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected status code: %v when disabled; got: %v",
+			http.StatusNotFound, w.Code)
+	}
+}
@@ -0,0 +1,154 @@
+package web
+
+// msgpack.go - Encoding of responses as MessagePack, a smaller binary form of
+// JSON for bandwidth-sensitive clients. Responses are encoded as JSON first, so
+// that they have the same fields (and are shaped by the same JSON encoding
+// options) in both formats.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// encodeMsgPack writes v to w as MessagePack.
+func encodeMsgPack(w io.Writer, v interface{}) error {
+	b, err := marshalMsgPack(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// marshalMsgPack returns v encoded as MessagePack, with the fields of its JSON
+// encoding. Objects are encoded as maps with their keys in sorted order.
+func marshalMsgPack(v interface{}) ([]byte, error) {
+	j, err := marshalJSON(v)
+	if err != nil {
+		return nil, err
+	}
+	var decoded interface{}
+	d := json.NewDecoder(bytes.NewReader(j))
+	d.UseNumber()
+	if err := d.Decode(&decoded); err != nil {
+		return nil, err
+	}
+	return appendMsgPack(nil, decoded)
+}
+
+// appendMsgPack appends the MessagePack encoding of a decoded JSON value to b.
+func appendMsgPack(b []byte, v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(b, 0xc0), nil
+	case bool:
+		if v {
+			return append(b, 0xc3), nil
+		}
+		return append(b, 0xc2), nil
+	case json.Number:
+		return appendMsgPackNumber(b, v)
+	case string:
+		return append(appendMsgPackHeader(b, len(v), 0xa0, 32, 0xd9, 0xda, 0xdb),
+			v...), nil
+	case []interface{}:
+		b = appendMsgPackHeader(b, len(v), 0x90, 16, 0, 0xdc, 0xdd)
+		var err error
+		for _, e := range v {
+			if b, err = appendMsgPack(b, e); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b = appendMsgPackHeader(b, len(v), 0x80, 16, 0, 0xde, 0xdf)
+		var err error
+		for _, k := range keys {
+			if b, err = appendMsgPack(b, k); err != nil {
+				return nil, err
+			}
+			if b, err = appendMsgPack(b, v[k]); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("unable to encode %T as MessagePack", v)
+}
+
+// appendMsgPackHeader appends the header of a string, array, or map of length n
+// to b: its fixed form if n is less than fixmax, or else the smallest of its 8
+// (if the type has one), 16, and 32-bit forms.
+func appendMsgPackHeader(b []byte, n int, fix byte, fixmax int, f8, f16,
+	f32 byte) []byte {
+	switch {
+	case n < fixmax:
+		return append(b, fix|byte(n))
+	case f8 != 0 && n <= math.MaxUint8:
+		return append(b, f8, byte(n))
+	case n <= math.MaxUint16:
+		return appendBigEndian(append(b, f16), uint64(n), 2)
+	}
+	return appendBigEndian(append(b, f32), uint64(n), 4)
+}
+
+// appendMsgPackNumber appends a JSON number to b as the smallest MessagePack
+// integer that holds it, or as a 64-bit float if it isn't an integer.
+func appendMsgPackNumber(b []byte, n json.Number) ([]byte, error) {
+	if i, err := strconv.ParseInt(string(n), 10, 64); err == nil {
+		switch {
+		case i >= 0:
+			return appendMsgPackUint(b, uint64(i)), nil
+		case i >= -32:
+			return append(b, byte(i)), nil
+		case i >= math.MinInt8:
+			return append(b, 0xd0, byte(i)), nil
+		case i >= math.MinInt16:
+			return appendBigEndian(append(b, 0xd1), uint64(i), 2), nil
+		case i >= math.MinInt32:
+			return appendBigEndian(append(b, 0xd2), uint64(i), 4), nil
+		}
+		return appendBigEndian(append(b, 0xd3), uint64(i), 8), nil
+	}
+	// i.e. Steam IDs and GameIDs, which can exceed the int64 range
+	if u, err := strconv.ParseUint(string(n), 10, 64); err == nil {
+		return appendMsgPackUint(b, u), nil
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return nil, err
+	}
+	return appendBigEndian(append(b, 0xcb), math.Float64bits(f), 8), nil
+}
+
+func appendMsgPackUint(b []byte, u uint64) []byte {
+	switch {
+	case u <= math.MaxInt8:
+		return append(b, byte(u))
+	case u <= math.MaxUint8:
+		return append(b, 0xcc, byte(u))
+	case u <= math.MaxUint16:
+		return appendBigEndian(append(b, 0xcd), u, 2)
+	case u <= math.MaxUint32:
+		return appendBigEndian(append(b, 0xce), u, 4)
+	}
+	return appendBigEndian(append(b, 0xcf), u, 8)
+}
+
+// appendBigEndian appends the low n bytes of v to b, most significant first.
+func appendBigEndian(b []byte, v uint64, n int) []byte {
+	for i := n - 1; i >= 0; i-- {
+		b = append(b, byte(v>>(8*uint(i))))
+	}
+	return b
+}
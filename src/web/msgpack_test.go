@@ -0,0 +1,48 @@
+package web
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMarshalMsgPack(t *testing.T) {
+	tests := []struct {
+		v        interface{}
+		expected []byte
+	}{
+		{nil, []byte{0xc0}},
+		{true, []byte{0xc3}},
+		{5, []byte{0x05}},
+		{-3, []byte{0xfd}},
+		{200, []byte{0xcc, 0xc8}},
+		{-200, []byte{0xd1, 0xff, 0x38}},
+		{70000, []byte{0xce, 0x00, 0x01, 0x11, 0x70}},
+		{uint64(1) << 63, []byte{0xcf, 0x80, 0, 0, 0, 0, 0, 0, 0}},
+		{1.5, []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{"abc", []byte{0xa3, 'a', 'b', 'c'}},
+		{[]int{1, 2}, []byte{0x92, 0x01, 0x02}},
+		// struct fields are encoded with their JSON names, in sorted order
+		{struct {
+			B int    `json:"b"`
+			A string `json:"a,omitempty"`
+			C bool   `json:"c"`
+		}{B: 1}, []byte{0x82, 0xa1, 'b', 0x01, 0xa1, 'c', 0xc2}},
+	}
+	for _, tt := range tests {
+		b, err := marshalMsgPack(tt.v)
+		if err != nil {
+			t.Fatalf("Unexpected error encoding %v: %s", tt.v, err)
+		}
+		if !bytes.Equal(b, tt.expected) {
+			t.Fatalf("Expected %v to be encoded as % x, got: % x", tt.v,
+				tt.expected, b)
+		}
+	}
+
+	// longer strings use the 8-bit length form
+	b, _ := marshalMsgPack(strings.Repeat("x", 40))
+	if !bytes.Equal(b[:2], []byte{0xd9, 40}) || len(b) != 42 {
+		t.Fatalf("Expected str8 header for 40-byte string, got: % x", b[:2])
+	}
+}
@@ -0,0 +1,425 @@
+package web
+
+// oidc.go - Validation of JWTs issued by an external (OpenID Connect) identity
+// provider, accepted in place of API keys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/models"
+	"github.com/syncore/a2sapi/src/util"
+)
+
+const (
+	// minimum time between signing key fetches triggered by an unknown key ID
+	minJWKSRefetchTime = time.Minute
+	// allowed clock difference with the identity provider
+	jwtClockSkew = time.Minute
+	maxJWKSSize  = 1 << 20
+)
+
+var oidcClient = &http.Client{Timeout: 10 * time.Second}
+
+// jwtHeader represents the relevant fields of a JWT's header.
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// jwtClaims represents the relevant claims of a JWT.
+type jwtClaims struct {
+	Issuer    string          `json:"iss"`
+	Subject   string          `json:"sub"`
+	Audience  json.RawMessage `json:"aud"`
+	ExpiresAt int64           `json:"exp"`
+	NotBefore int64           `json:"nbf"`
+	// space-separated scopes, as issued by most providers
+	Scope string `json:"scope"`
+	// scopes as an array, as issued by some providers (i.e: Azure AD, Okta)
+	Scp []string `json:"scp"`
+}
+
+// hasAudience returns true if the token was issued for the audience, where aud
+// may be a single string or an array.
+func (c *jwtClaims) hasAudience(audience string) bool {
+	var single string
+	if err := json.Unmarshal(c.Audience, &single); err == nil {
+		return single == audience
+	}
+	var multi []string
+	if err := json.Unmarshal(c.Audience, &multi); err != nil {
+		return false
+	}
+	for _, a := range multi {
+		if a == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// scopes returns the API scopes granted by the token; scopes that are unknown to
+// the API are ignored.
+func (c *jwtClaims) scopes() []string {
+	var scopes []string
+	for _, s := range append(strings.Fields(c.Scope), c.Scp...) {
+		if validScopes[s] {
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes
+}
+
+// jwk represents a JSON web key as served in an identity provider's key set.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// publicKey returns the RSA or EC public key represented by the JWK.
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("invalid EC key")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+	}
+}
+
+// jwksCache holds the identity provider's signing keys by key ID. While the key
+// set is being fetched, fetching is closed when it has been.
+var jwksCache = struct {
+	sync.Mutex
+	issuer   string
+	keys     map[string]crypto.PublicKey
+	fetched  time.Time
+	fetching chan struct{}
+}{}
+
+var jwksStats = util.RegisterCache("jwks", func() int {
+	jwksCache.Lock()
+	defer jwksCache.Unlock()
+	return len(jwksCache.keys)
+}, func() int {
+	jwksCache.Lock()
+	defer jwksCache.Unlock()
+	n := len(jwksCache.keys)
+	jwksCache.keys, jwksCache.fetched = nil, time.Time{}
+	return n
+})
+
+func getJSON(url string, v interface{}) error {
+	resp, err := oidcClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxJWKSSize)).Decode(v)
+}
+
+// getJWKSURL returns the configured URL of the issuer's key set or discovers it
+// from the issuer's OpenID configuration.
+func getJWKSURL(issuer string) (string, error) {
+	if config.Get().AdminConfig.OIDCJWKSURL != "" {
+		return config.Get().AdminConfig.OIDCJWKSURL, nil
+	}
+	var discovery struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := getJSON(strings.TrimSuffix(issuer, "/")+
+		"/.well-known/openid-configuration", &discovery); err != nil {
+		return "", err
+	}
+	if discovery.JWKSURI == "" {
+		return "", fmt.Errorf("no jwks_uri in OpenID configuration of %s", issuer)
+	}
+	return discovery.JWKSURI, nil
+}
+
+func fetchJWKS(issuer string) (map[string]crypto.PublicKey, error) {
+	url, err := getJWKSURL(issuer)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := getJSON(url, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pk, err := k.publicKey()
+		if err != nil {
+			logger.WriteDebug("Skipping signing key '%s': %s", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = pk
+	}
+	return keys, nil
+}
+
+// getSigningKey returns the issuer's signing key with the key ID, fetching the
+// key set if it is not cached, has expired, or does not contain the key (i.e.
+// after the provider rotated its keys). The key set is fetched without holding
+// the cache's lock, so that a slow identity provider only delays the requests
+// that need the new key set; the others use the cached keys meanwhile.
+func getSigningKey(issuer, kid string) (crypto.PublicKey, error) {
+	cacheTime := time.Duration(config.Get().AdminConfig.OIDCJWKSCacheTime) *
+		time.Second
+	jwksCache.Lock()
+	if jwksCache.issuer != issuer {
+		jwksCache.issuer, jwksCache.keys = issuer, nil
+	}
+	key, ok := jwksCache.keys[kid]
+	age := time.Since(jwksCache.fetched)
+	if jwksCache.keys != nil && age <= cacheTime &&
+		(ok || age <= minJWKSRefetchTime) {
+		jwksCache.Unlock()
+		jwksStats.Hit()
+		return signingKey(key, ok, kid)
+	}
+	if wait := jwksCache.fetching; wait != nil {
+		jwksCache.Unlock()
+		if ok {
+			return key, nil
+		}
+		<-wait
+		jwksCache.Lock()
+		key, ok = jwksCache.keys[kid]
+		jwksCache.Unlock()
+		return signingKey(key, ok, kid)
+	}
+	done := make(chan struct{})
+	jwksCache.fetching = done
+	jwksCache.Unlock()
+
+	jwksStats.Miss()
+	keys, err := fetchJWKS(issuer)
+	jwksCache.Lock()
+	defer jwksCache.Unlock()
+	jwksCache.fetching = nil
+	close(done)
+	if jwksCache.issuer != issuer {
+		// the configured issuer changed during the fetch
+		return nil, fmt.Errorf("issuer '%s' is no longer configured", issuer)
+	}
+	if err != nil {
+		if jwksCache.keys == nil {
+			return nil, logger.LogWebErrorf("Unable to fetch signing keys of %s: %s",
+				issuer, err)
+		}
+		// keep using the last good key set
+		logger.LogWebErrorf("Unable to refresh signing keys of %s: %s", issuer, err)
+	} else {
+		jwksCache.keys, jwksCache.fetched = keys, time.Now()
+	}
+	key, ok = jwksCache.keys[kid]
+	return signingKey(key, ok, kid)
+}
+
+// signingKey returns a signing key looked up in the cache, or an error if it
+// was not found.
+func signingKey(key crypto.PublicKey, ok bool, kid string) (crypto.PublicKey,
+	error) {
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key '%s'", kid)
+	}
+	return key, nil
+}
+
+// verifyJWTSignature verifies the signature of the signed part of a JWT.
+func verifyJWTSignature(alg string, key crypto.PublicKey, signed string,
+	sig []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported algorithm '%s'", alg)
+	}
+	var digest []byte
+	switch hash {
+	case crypto.SHA256:
+		d := sha256.Sum256([]byte(signed))
+		digest = d[:]
+	case crypto.SHA384:
+		d := sha512.Sum384([]byte(signed))
+		digest = d[:]
+	default:
+		d := sha512.Sum512([]byte(signed))
+		digest = d[:]
+	}
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") {
+			return fmt.Errorf("algorithm '%s' does not match RSA key", alg)
+		}
+		return rsa.VerifyPKCS1v15(k, hash, digest, sig)
+	case *ecdsa.PublicKey:
+		if !strings.HasPrefix(alg, "ES") {
+			return fmt.Errorf("algorithm '%s' does not match EC key", alg)
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return fmt.Errorf("invalid EC signature length")
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported key type")
+	}
+}
+
+// validateJWT validates a JWT's signature, issuer, audience, and lifetime,
+// returning its claims if it is valid.
+func validateJWT(token string) (*jwtClaims, error) {
+	issuer := config.Get().AdminConfig.OIDCIssuer
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+	hb, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token header")
+	}
+	header := jwtHeader{}
+	if err := json.Unmarshal(hb, &header); err != nil {
+		return nil, fmt.Errorf("malformed token header")
+	}
+	cb, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token claims")
+	}
+	claims := &jwtClaims{}
+	if err := json.Unmarshal(cb, claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature")
+	}
+	if claims.Issuer != issuer {
+		return nil, fmt.Errorf("unexpected issuer '%s'", claims.Issuer)
+	}
+	key, err := getSigningKey(issuer, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWTSignature(header.Alg, key, parts[0]+"."+parts[1],
+		sig); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if claims.ExpiresAt == 0 || now.After(time.Unix(claims.ExpiresAt, 0).Add(jwtClockSkew)) {
+		return nil, fmt.Errorf("token has expired")
+	}
+	if claims.NotBefore != 0 && now.Add(jwtClockSkew).Before(time.Unix(claims.NotBefore, 0)) {
+		return nil, fmt.Errorf("token is not yet valid")
+	}
+	// an audience is required, or tokens that the provider issued for any of
+	// its other clients would be accepted
+	aud := config.Get().AdminConfig.OIDCAudience
+	if aud == "" {
+		return nil, fmt.Errorf("no audience is configured")
+	}
+	if !claims.hasAudience(aud) {
+		return nil, fmt.Errorf("token was not issued for audience '%s'", aud)
+	}
+	return claims, nil
+}
+
+// isJWT returns true if JWT validation is enabled and the key looks like a JWT.
+func isJWT(key string) bool {
+	return config.Get().AdminConfig.OIDCIssuer != "" &&
+		strings.Count(key, ".") == 2 && !strings.HasPrefix(key, apiKeyPrefix)
+}
+
+// authenticateJWT returns the identity and scopes of a valid JWT as an API key,
+// or nil if the token is invalid.
+func authenticateJWT(token string) *models.DbAPIKey {
+	claims, err := validateJWT(token)
+	if err != nil {
+		logger.WriteDebug("Rejected JWT: %s", err)
+		return nil
+	}
+	return &models.DbAPIKey{
+		ID:     "oidc:" + claims.Subject,
+		Name:   claims.Subject,
+		Scopes: claims.scopes(),
+	}
+}
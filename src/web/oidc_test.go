@@ -0,0 +1,219 @@
+package web
+
+// Tests for validation of JWTs from an external identity provider
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+)
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func signTestJWT(t *testing.T, alg, kid string, key crypto.Signer,
+	claims map[string]interface{}) string {
+	hb, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	cb, _ := json.Marshal(claims)
+	signed := b64(hb) + "." + b64(cb)
+	digest := sha256.Sum256([]byte(signed))
+	var sig []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		s, err := rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatalf("Unable to sign token: %s", err)
+		}
+		sig = s
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatalf("Unable to sign token: %s", err)
+		}
+		sig = make([]byte, 64)
+		rb, sb := r.Bytes(), s.Bytes()
+		copy(sig[32-len(rb):32], rb)
+		copy(sig[64-len(sb):], sb)
+	}
+	return signed + "." + b64(sig)
+}
+
+func TestValidateJWT(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Unable to generate RSA key: %s", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unable to generate EC key: %s", err)
+	}
+	var issuer string
+	fetches := 0
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			fmt.Fprintf(w, `{"issuer": "%s", "jwks_uri": "%s/keys"}`, issuer, issuer)
+		case "/keys":
+			fetches++
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"keys": []map[string]string{
+					{"kty": "RSA", "kid": "rsa1", "use": "sig",
+						"n": b64(rsaKey.N.Bytes()),
+						"e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+					{"kty": "EC", "kid": "ec1", "crv": "P-256",
+						"x": b64(ecKey.X.Bytes()), "y": b64(ecKey.Y.Bytes())},
+				},
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer idp.Close()
+	issuer = idp.URL
+
+	prev := config.Get().AdminConfig
+	defer func() { config.Get().AdminConfig = prev }()
+	config.Get().AdminConfig.OIDCIssuer = issuer
+	config.Get().AdminConfig.OIDCAudience = "a2sapi"
+	config.Get().AdminConfig.OIDCJWKSURL = ""
+
+	claims := func(mod func(c map[string]interface{})) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss":   issuer,
+			"sub":   "ops@example.org",
+			"aud":   []string{"other", "a2sapi"},
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"scope": "openid admin:keys",
+		}
+		if mod != nil {
+			mod(c)
+		}
+		return c
+	}
+	tests := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"RS256", signTestJWT(t, "RS256", "rsa1", rsaKey, claims(nil)), true},
+		{"ES256", signTestJWT(t, "ES256", "ec1", ecKey, claims(nil)), true},
+		{"wrong key type", signTestJWT(t, "ES256", "rsa1", rsaKey, claims(nil)), false},
+		{"unknown key", signTestJWT(t, "RS256", "rsa2", rsaKey, claims(nil)), false},
+		{"expired", signTestJWT(t, "RS256", "rsa1", rsaKey,
+			claims(func(c map[string]interface{}) {
+				c["exp"] = time.Now().Add(-time.Hour).Unix()
+			})), false},
+		{"wrong issuer", signTestJWT(t, "RS256", "rsa1", rsaKey,
+			claims(func(c map[string]interface{}) {
+				c["iss"] = "https://evil.example.org"
+			})), false},
+		{"wrong audience", signTestJWT(t, "RS256", "rsa1", rsaKey,
+			claims(func(c map[string]interface{}) {
+				c["aud"] = "other"
+			})), false},
+		{"alg none", b64([]byte(`{"alg":"none","kid":"rsa1"}`)) + "." +
+			b64([]byte(fmt.Sprintf(`{"iss":"%s","aud":"a2sapi","exp":%d}`, issuer,
+				time.Now().Add(time.Hour).Unix()))) + ".", false},
+	}
+	for _, tt := range tests {
+		k, err := authenticateAPIKey(tt.token)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.name, err)
+		}
+		if (k != nil) != tt.valid {
+			t.Errorf("%s: expected valid=%v, got: %v", tt.name, tt.valid, k != nil)
+			continue
+		}
+		if k != nil && (len(k.Scopes) != 1 || k.Scopes[0] != scopeAdminKeys) {
+			t.Errorf("%s: expected scopes [admin:keys], got: %v", tt.name, k.Scopes)
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("Expected the signing keys to be fetched once, got: %d", fetches)
+	}
+
+	// tokens are never accepted without a configured audience
+	config.Get().AdminConfig.OIDCAudience = ""
+	if k, _ := authenticateAPIKey(tests[0].token); k != nil {
+		t.Errorf("Expected token to be rejected without a configured audience")
+	}
+}
+
+func TestGetSigningKeyDuringFetch(t *testing.T) {
+	release := make(chan struct{})
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		<-release
+		fmt.Fprint(w, `{"keys": []}`)
+	}))
+	defer idp.Close()
+	prev := config.Get().AdminConfig
+	defer func() {
+		config.Get().AdminConfig = prev
+		jwksCache.Lock()
+		jwksCache.issuer, jwksCache.keys = "", nil
+		jwksCache.Unlock()
+	}()
+	config.Get().AdminConfig.OIDCJWKSURL = idp.URL
+	config.Get().AdminConfig.OIDCJWKSCacheTime = 60
+
+	// an expired key set, whose refresh is slow
+	cached := &rsa.PublicKey{}
+	jwksCache.Lock()
+	jwksCache.issuer = idp.URL
+	jwksCache.keys = map[string]crypto.PublicKey{"cached": cached}
+	jwksCache.fetched = time.Now().Add(-time.Hour)
+	jwksCache.Unlock()
+	refreshed := make(chan error, 1)
+	go func() {
+		_, err := getSigningKey(idp.URL, "cached")
+		refreshed <- err
+	}()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		jwksCache.Lock()
+		fetching := jwksCache.fetching != nil
+		jwksCache.Unlock()
+		if fetching {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the key set to be fetched")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// other requests use the cached key while the key set is fetched
+	got := make(chan crypto.PublicKey, 1)
+	go func() {
+		k, _ := getSigningKey(idp.URL, "cached")
+		got <- k
+	}()
+	select {
+	case k := <-got:
+		if k != cached {
+			t.Fatalf("Expected the cached key, got: %v", k)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the cached key without waiting for the fetch")
+	}
+	close(release)
+	if err := <-refreshed; err == nil {
+		t.Fatalf("Expected the key to be unknown after the refresh")
+	}
+}
@@ -0,0 +1,289 @@
+package web
+
+// pagination.go - Cursor-based pagination of the server list. Cursors are bound
+// to the retrieval cycle (snapshot) that the first page was served from, so a
+// client paging through results sees a consistent view even if the master list
+// is replaced in the meantime. Also the sorting of the server list.
+
+import (
+	"encoding/base64"
+	"fmt"
+	"hash/fnv"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/syncore/a2sapi/src/models"
+)
+
+const (
+	maxServerPageSize       = 1000
+	retainedServerSnapshots = 4
+)
+
+// Server list sort orders
+const (
+	// most players first
+	sortServersPlayers = "players"
+	sortServersName    = "name"
+	sortServersCountry = "country"
+)
+
+// pageRequest represents the page of the server list requested by a client.
+type pageRequest struct {
+	cycle      int64
+	offset     int
+	limit      int
+	filterHash uint64
+}
+
+// snapshotKey identifies a served server list by the game whose cache it came
+// from (empty for the combined list) and its retrieval timestamp.
+type snapshotKey struct {
+	game  string
+	cycle int64
+}
+
+// snapshotStore retains the most recently served server lists so that cursors
+// can continue to page through them.
+type snapshotStore struct {
+	mu    sync.Mutex
+	max   int
+	order []snapshotKey
+	lists map[snapshotKey]*models.APIServerList
+}
+
+var serverSnapshots = newSnapshotStore(retainedServerSnapshots)
+
+func newSnapshotStore(max int) *snapshotStore {
+	return &snapshotStore{max: max,
+		lists: make(map[snapshotKey]*models.APIServerList)}
+}
+
+// add retains the server list, evicting the oldest list if necessary.
+func (s *snapshotStore) add(game string, sl *models.APIServerList) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := snapshotKey{game: strings.ToLower(game), cycle: sl.RetrievedTimeStamp}
+	if _, ok := s.lists[key]; ok {
+		return
+	}
+	s.lists[key] = sl
+	s.order = append(s.order, key)
+	for len(s.order) > s.max {
+		delete(s.lists, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+// get returns the retained server list for the given game and cycle, or nil if
+// it has been evicted.
+func (s *snapshotStore) get(game string, cycle int64) *models.APIServerList {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lists[snapshotKey{game: strings.ToLower(game), cycle: cycle}]
+}
+
+// getFilterHash returns a hash of the request's query string, excluding the
+// pagination parameters, which is used to tie a cursor to the filters it was
+// issued for.
+func getFilterHash(q url.Values) uint64 {
+	var params []string
+	for k, v := range q {
+		if strings.EqualFold(k, qsPageCursor) || strings.EqualFold(k, qsPageLimit) ||
+			strings.EqualFold(k, qsPageOffset) {
+			continue
+		}
+		params = append(params, strings.ToLower(k)+"="+strings.Join(v, ","))
+	}
+	sort.Strings(params)
+	h := fnv.New64a()
+	h.Write([]byte(strings.Join(params, "&")))
+	return h.Sum64()
+}
+
+func encodeCursor(p pageRequest) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d:%d:%x",
+		p.cycle, p.offset, p.limit, p.filterHash)))
+}
+
+func decodeCursor(cursor string) (pageRequest, error) {
+	p := pageRequest{}
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return p, err
+	}
+	parts := strings.Split(string(b), ":")
+	if len(parts) != 4 {
+		return p, fmt.Errorf("malformed cursor")
+	}
+	if p.cycle, err = strconv.ParseInt(parts[0], 10, 64); err != nil {
+		return p, err
+	}
+	if p.offset, err = strconv.Atoi(parts[1]); err != nil {
+		return p, err
+	}
+	if p.limit, err = strconv.Atoi(parts[2]); err != nil {
+		return p, err
+	}
+	if p.filterHash, err = strconv.ParseUint(parts[3], 16, 64); err != nil {
+		return p, err
+	}
+	if p.offset < 0 || p.limit <= 0 {
+		return p, fmt.Errorf("invalid cursor offset or limit")
+	}
+	return p, nil
+}
+
+// getPageRequest returns the page requested with the limit, offset, and cursor
+// query strings, or nil if the request is not paginated.
+func getPageRequest(q url.Values) (*pageRequest, error) {
+	limitval, haslimit := getQStringValue(q, qsPageLimit)
+	cursor, hascursor := getQStringValue(q, qsPageCursor)
+	offsetval, hasoffset := getQStringValue(q, qsPageOffset)
+	if !haslimit && !hascursor && !hasoffset {
+		return nil, nil
+	}
+	p := &pageRequest{filterHash: getFilterHash(q)}
+	if hasoffset {
+		if hascursor && cursor != "" {
+			return nil, fmt.Errorf("The %s and %s parameters cannot be combined.",
+				qsPageOffset, qsPageCursor)
+		}
+		offset, err := strconv.Atoi(offsetval)
+		if err != nil || offset < 0 {
+			return nil, fmt.Errorf("The %s parameter must be zero or a positive number.",
+				qsPageOffset)
+		}
+		p.offset = offset
+	}
+	if hascursor && cursor != "" {
+		c, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid cursor.")
+		}
+		if c.filterHash != p.filterHash {
+			return nil, fmt.Errorf("Cursor does not match the request's filters.")
+		}
+		*p = c
+	}
+	if haslimit {
+		limit, err := strconv.Atoi(limitval)
+		if err != nil || limit <= 0 {
+			return nil, fmt.Errorf("The %s parameter must be a positive number.",
+				qsPageLimit)
+		}
+		p.limit = limit
+	}
+	if p.limit == 0 || p.limit > maxServerPageSize {
+		p.limit = maxServerPageSize
+	}
+	return p, nil
+}
+
+// paginateServers returns the requested page of the (filtered) server list,
+// with the total number of servers and a cursor for the next page if there are
+// more servers.
+func paginateServers(sl *models.APIServerList, p *pageRequest) *models.APIServerList {
+	start := p.offset
+	if start > len(sl.Servers) {
+		start = len(sl.Servers)
+	}
+	end := start + p.limit
+	if end > len(sl.Servers) {
+		end = len(sl.Servers)
+	}
+	page := *sl
+	page.Servers = sl.Servers[start:end]
+	page.ServerCount = len(page.Servers)
+	page.TotalCount = len(sl.Servers)
+	page.NextCursor = ""
+	page.NextPage = ""
+	if end < len(sl.Servers) {
+		page.NextCursor = encodeCursor(pageRequest{
+			cycle:      sl.RetrievedTimeStamp,
+			offset:     end,
+			limit:      p.limit,
+			filterHash: p.filterHash,
+		})
+	}
+	return &page
+}
+
+// nextPageLink returns the link (path and query string) to the page of the
+// server list following the requested page u, given the page's next cursor.
+func nextPageLink(u *url.URL, cursor string) string {
+	q := url.Values{}
+	for k, v := range u.Query() {
+		if strings.EqualFold(k, qsPageCursor) || strings.EqualFold(k, qsPageOffset) {
+			continue
+		}
+		q[k] = v
+	}
+	q.Set(qsPageCursor, cursor)
+	return u.Path + "?" + q.Encode()
+}
+
+// getServerSort returns the sort order requested with the sort query string, or
+// an empty string if the list is to be left in its retrieved order.
+func getServerSort(q url.Values) (string, error) {
+	by, _ := getQStringValue(q, qsSortServers)
+	switch strings.ToLower(by) {
+	case "":
+		return "", nil
+	case sortServersPlayers, sortServersName, sortServersCountry:
+		return strings.ToLower(by), nil
+	}
+	return "", fmt.Errorf("The %s parameter must be one of: %s, %s, %s.",
+		qsSortServers, sortServersPlayers, sortServersName, sortServersCountry)
+}
+
+// sortServers returns a copy of the server list sorted by the given order:
+// players (most first), name, or country (then name). Ties are broken by
+// address so that pages of a sorted list are stable.
+func sortServers(sl *models.APIServerList, by string) *models.APIServerList {
+	if by == "" {
+		return sl
+	}
+	// sort the indexes of the servers, so that the names are only normalized once
+	idx := make([]int, len(sl.Servers))
+	names := make([]string, len(sl.Servers))
+	for i := range sl.Servers {
+		idx[i] = i
+		if by == sortServersPlayers {
+			continue
+		}
+		if sl.Servers[i].Alias != "" {
+			names[i] = normalizeSearch(sl.Servers[i].Alias, true)
+		} else {
+			names[i] = normalizeSearch(sl.Servers[i].Info.Name, true)
+		}
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		a, b := &sl.Servers[idx[i]], &sl.Servers[idx[j]]
+		switch by {
+		case sortServersPlayers:
+			if a.Info.Players != b.Info.Players {
+				return a.Info.Players > b.Info.Players
+			}
+		case sortServersCountry:
+			if a.CountryInfo.CountryCode != b.CountryInfo.CountryCode {
+				return a.CountryInfo.CountryCode < b.CountryInfo.CountryCode
+			}
+			fallthrough
+		case sortServersName:
+			if names[idx[i]] != names[idx[j]] {
+				return names[idx[i]] < names[idx[j]]
+			}
+		}
+		return a.Host < b.Host
+	})
+	sorted := *sl
+	sorted.Servers = make([]models.APIServer, len(idx))
+	for i, n := range idx {
+		sorted.Servers[i] = sl.Servers[n]
+	}
+	return &sorted
+}
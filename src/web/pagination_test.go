@@ -0,0 +1,201 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/syncore/a2sapi/src/models"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	p := pageRequest{cycle: 1456000000, offset: 40, limit: 20,
+		filterHash: getFilterHash(url.Values{"maps": []string{"bloodrun"}})}
+	c, err := decodeCursor(encodeCursor(p))
+	if err != nil {
+		t.Fatalf("Unexpected error decoding cursor: %s", err)
+	}
+	if c != p {
+		t.Fatalf("Expected decoded cursor %+v, got: %+v", p, c)
+	}
+	if _, err := decodeCursor("not-a-cursor"); err == nil {
+		t.Fatalf("Expected malformed cursor to be rejected")
+	}
+}
+
+func TestPaginateSnapshot(t *testing.T) {
+	store := newSnapshotStore(2)
+	sl := &models.APIServerList{RetrievedTimeStamp: 1}
+	for i := 0; i < 5; i++ {
+		sl.Servers = append(sl.Servers, models.APIServer{ID: int64(i)})
+	}
+	store.add("", sl)
+	first := paginateServers(sl, &pageRequest{limit: 2})
+	if first.ServerCount != 2 || first.NextCursor == "" {
+		t.Fatalf("Expected first page of 2 servers with a cursor, got: %+v", first)
+	}
+	// the master list changes between pages
+	store.add("", &models.APIServerList{RetrievedTimeStamp: 2})
+	next, err := decodeCursor(first.NextCursor)
+	if err != nil {
+		t.Fatalf("Unexpected error decoding cursor: %s", err)
+	}
+	snap := store.get("", next.cycle)
+	if snap == nil {
+		t.Fatalf("Expected snapshot for cycle %d to be retained", next.cycle)
+	}
+	second := paginateServers(snap, &next)
+	if second.ServerCount != 2 || second.Servers[0].ID != 2 {
+		t.Fatalf("Expected second page to start at server 2, got: %+v",
+			second.Servers)
+	}
+	store.add("", &models.APIServerList{RetrievedTimeStamp: 3})
+	if store.get("", 1) != nil {
+		t.Fatalf("Expected oldest snapshot to be evicted")
+	}
+}
+
+func TestGetServersPaginated(t *testing.T) {
+	var ids []int64
+	cursor := ""
+	for pages := 0; pages < 100; pages++ {
+		r, _ := http.NewRequest("GET",
+			formatURL("servers?limit=2&cursor="+url.QueryEscape(cursor)), nil)
+		w := newRecorder()
+		getServers(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status code: %v for paginated GetServers; got: %v",
+				http.StatusOK, w.Code)
+		}
+		m := &models.APIServerList{}
+		if err := json.Unmarshal(w.Body.Bytes(), m); err != nil {
+			t.Fatalf("Unable to decode server list page: %s", err)
+		}
+		if m.ServerCount > 2 {
+			t.Fatalf("Expected at most 2 servers per page, got: %d", m.ServerCount)
+		}
+		for _, s := range m.Servers {
+			ids = append(ids, s.ID)
+		}
+		if m.NextCursor == "" {
+			break
+		}
+		cursor = m.NextCursor
+	}
+	r, _ := http.NewRequest("GET", formatURL("servers"), nil)
+	w := newRecorder()
+	getServers(w, r)
+	all := &models.APIServerList{}
+	if err := json.Unmarshal(w.Body.Bytes(), all); err != nil {
+		t.Fatalf("Unable to decode server list: %s", err)
+	}
+	if len(ids) != len(all.Servers) {
+		t.Fatalf("Expected %d servers across all pages, got: %d", len(all.Servers),
+			len(ids))
+	}
+
+	r, _ = http.NewRequest("GET",
+		formatURL("servers?maps=bloodrun&cursor="+url.QueryEscape(cursor)), nil)
+	w = newRecorder()
+	getServers(w, r)
+	if cursor != "" && w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected cursor with different filters to be rejected, got: %v",
+			w.Code)
+	}
+}
+
+func TestSortServers(t *testing.T) {
+	sl := &models.APIServerList{Servers: []models.APIServer{
+		{Host: "10.0.0.1:27960", Info: models.SteamServerInfo{Name: "^1Zeta", Players: 4},
+			CountryInfo: models.DbCountry{CountryCode: "US"}},
+		{Host: "10.0.0.2:27960", Info: models.SteamServerInfo{Name: "Éclair", Players: 9},
+			CountryInfo: models.DbCountry{CountryCode: "DE"}},
+		{Host: "10.0.0.3:27960", Info: models.SteamServerInfo{Name: "alpha", Players: 4},
+			CountryInfo: models.DbCountry{CountryCode: "US"}},
+	}}
+	hosts := func(sl *models.APIServerList) string {
+		var h []string
+		for _, s := range sl.Servers {
+			h = append(h, s.Host[7:8])
+		}
+		return strings.Join(h, "")
+	}
+	tests := map[string]string{
+		sortServersPlayers: "213",
+		sortServersName:    "321",
+		sortServersCountry: "231",
+		"":                 "123",
+	}
+	for by, expected := range tests {
+		if got := hosts(sortServers(sl, by)); got != expected {
+			t.Fatalf("Expected servers sorted by '%s' in order %s, got: %s", by,
+				expected, got)
+		}
+	}
+	if hosts(sl) != "123" {
+		t.Fatalf("Expected the original list not to be sorted")
+	}
+	if _, err := getServerSort(url.Values{"Sort": []string{"Players"}}); err != nil {
+		t.Fatalf("Unexpected error for valid sort order: %s", err)
+	}
+	if _, err := getServerSort(url.Values{"sort": []string{"ping"}}); err == nil {
+		t.Fatalf("Expected unknown sort order to be rejected")
+	}
+}
+
+func TestGetPageRequestOffset(t *testing.T) {
+	p, err := getPageRequest(url.Values{"offset": []string{"10"}})
+	if err != nil || p == nil || p.offset != 10 || p.limit != maxServerPageSize {
+		t.Fatalf("Expected offset 10 with the maximum limit, got: %+v %v", p, err)
+	}
+	if _, err := getPageRequest(url.Values{"offset": []string{"-1"}}); err == nil {
+		t.Fatalf("Expected negative offset to be rejected")
+	}
+	cursor := encodeCursor(pageRequest{cycle: 1, offset: 2, limit: 2,
+		filterHash: getFilterHash(url.Values{})})
+	if _, err := getPageRequest(url.Values{"offset": []string{"2"},
+		"cursor": []string{cursor}}); err == nil {
+		t.Fatalf("Expected offset combined with a cursor to be rejected")
+	}
+	// the offset does not change the filters a cursor is bound to
+	if getFilterHash(url.Values{"maps": []string{"x"}, "offset": []string{"4"}}) !=
+		getFilterHash(url.Values{"maps": []string{"x"}}) {
+		t.Fatalf("Expected offset to be excluded from the filter hash")
+	}
+}
+
+func TestGetServersOffsetSorted(t *testing.T) {
+	r, _ := http.NewRequest("GET", formatURL("servers?sort=players&limit=1&offset=1"),
+		nil)
+	w := newRecorder()
+	getServers(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code: %v for sorted GetServers; got: %v",
+			http.StatusOK, w.Code)
+	}
+	m := &models.APIServerList{}
+	if err := json.Unmarshal(w.Body.Bytes(), m); err != nil {
+		t.Fatalf("Unable to decode server list page: %s", err)
+	}
+	if m.TotalCount < 2 {
+		t.Skipf("Test server list has too few servers: %d", m.TotalCount)
+	}
+	if m.ServerCount != 1 {
+		t.Fatalf("Expected 1 server, got: %d", m.ServerCount)
+	}
+	if m.TotalCount > 2 && (m.NextCursor == "" ||
+		!strings.Contains(m.NextPage, "cursor="+url.QueryEscape(m.NextCursor)) ||
+		strings.Contains(m.NextPage, "offset=")) {
+		t.Fatalf("Expected next page link with cursor, got: %s", m.NextPage)
+	}
+
+	r, _ = http.NewRequest("GET", formatURL("servers?sort=ping"), nil)
+	w = newRecorder()
+	getServers(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status code: %v for unknown sort order; got: %v",
+			http.StatusBadRequest, w.Code)
+	}
+}
@@ -0,0 +1,254 @@
+package web
+
+// protobuf.go - Minimal encoding and decoding of Protocol Buffers messages, as
+// needed by the gRPC API (see a2sapi.proto): responses are built field by field,
+// and requests are decoded into their fields by number.
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+)
+
+// Protocol Buffers wire types
+const (
+	pbVarint  = 0
+	pbFixed64 = 1
+	pbBytes   = 2
+	pbFixed32 = 5
+)
+
+var errPBTruncated = errors.New("truncated protobuf message")
+
+// appendPBVarint appends v as a base 128 varint.
+func appendPBVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func appendPBTag(b []byte, field, wire int) []byte {
+	return appendPBVarint(b, uint64(field)<<3|uint64(wire))
+}
+
+// appendPBInt appends an int32, int64, or enum field, unless it is 0. Negative
+// values take ten bytes, as in the protobuf encoding of int32.
+func appendPBInt(b []byte, field int, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	return appendPBVarint(appendPBTag(b, field, pbVarint), uint64(v))
+}
+
+// appendPBUint appends a uint32 or uint64 field, unless it is 0.
+func appendPBUint(b []byte, field int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	return appendPBVarint(appendPBTag(b, field, pbVarint), v)
+}
+
+// appendPBOptionalInt appends an optional int32 field, which is present even if
+// it is 0, unless v is nil.
+func appendPBOptionalInt(b []byte, field int, v *int) []byte {
+	if v == nil {
+		return b
+	}
+	return appendPBVarint(appendPBTag(b, field, pbVarint), uint64(int64(*v)))
+}
+
+// appendPBBool appends a bool field, unless it is false.
+func appendPBBool(b []byte, field int, v bool) []byte {
+	if !v {
+		return b
+	}
+	return append(appendPBTag(b, field, pbVarint), 1)
+}
+
+// appendPBDouble appends a double field, unless it is 0.
+func appendPBDouble(b []byte, field int, v float64) []byte {
+	if v == 0 {
+		return b
+	}
+	var f [8]byte
+	binary.LittleEndian.PutUint64(f[:], math.Float64bits(v))
+	return append(appendPBTag(b, field, pbFixed64), f[:]...)
+}
+
+// appendPBFloat appends a float field, unless it is 0.
+func appendPBFloat(b []byte, field int, v float32) []byte {
+	if v == 0 {
+		return b
+	}
+	var f [4]byte
+	binary.LittleEndian.PutUint32(f[:], math.Float32bits(v))
+	return append(appendPBTag(b, field, pbFixed32), f[:]...)
+}
+
+// appendPBBytes appends a length-delimited field (a string, bytes, or an
+// embedded message), even if it is empty.
+func appendPBBytes(b []byte, field int, v []byte) []byte {
+	b = appendPBVarint(appendPBTag(b, field, pbBytes), uint64(len(v)))
+	return append(b, v...)
+}
+
+// appendPBString appends a string field, unless it is empty.
+func appendPBString(b []byte, field int, s string) []byte {
+	if s == "" {
+		return b
+	}
+	return appendPBBytes(b, field, []byte(s))
+}
+
+// appendPBStrings appends a repeated string field.
+func appendPBStrings(b []byte, field int, ss []string) []byte {
+	for _, s := range ss {
+		b = appendPBBytes(b, field, []byte(s))
+	}
+	return b
+}
+
+// appendPBStringMap appends a map<string, string> field, with its entries in
+// key order.
+func appendPBStringMap(b []byte, field int, m map[string]string) []byte {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		b = appendPBBytes(b, field,
+			appendPBString(appendPBString(nil, 1, k), 2, m[k]))
+	}
+	return b
+}
+
+// pbField is a decoded field: varint and fixed-size values are in n, and
+// length-delimited values in b.
+type pbField struct {
+	wire int
+	n    uint64
+	b    []byte
+}
+
+// pbFields are the fields of a decoded message by field number, with repeated
+// fields in the order they appeared.
+type pbFields map[int][]pbField
+
+// readPBVarint reads a varint from the start of b, returning it and its length.
+func readPBVarint(b []byte) (uint64, int, error) {
+	var v uint64
+	for i := 0; i < len(b) && i < 10; i++ {
+		v |= uint64(b[i]&0x7F) << (7 * uint(i))
+		if b[i] < 0x80 {
+			return v, i + 1, nil
+		}
+	}
+	return 0, 0, errPBTruncated
+}
+
+// decodePB decodes the fields of a message.
+func decodePB(b []byte) (pbFields, error) {
+	fields := make(pbFields)
+	for len(b) > 0 {
+		tag, n, err := readPBVarint(b)
+		if err != nil {
+			return nil, err
+		}
+		b = b[n:]
+		f := pbField{wire: int(tag & 0x07)}
+		switch f.wire {
+		case pbVarint:
+			if f.n, n, err = readPBVarint(b); err != nil {
+				return nil, err
+			}
+		case pbFixed64:
+			if len(b) < 8 {
+				return nil, errPBTruncated
+			}
+			f.n, n = binary.LittleEndian.Uint64(b), 8
+		case pbFixed32:
+			if len(b) < 4 {
+				return nil, errPBTruncated
+			}
+			f.n, n = uint64(binary.LittleEndian.Uint32(b)), 4
+		case pbBytes:
+			size, sn, err := readPBVarint(b)
+			if err != nil || uint64(len(b)-sn) < size {
+				return nil, errPBTruncated
+			}
+			f.b, n = b[sn:sn+int(size)], sn+int(size)
+		default:
+			return nil, errors.New("unsupported protobuf wire type")
+		}
+		b = b[n:]
+		fields[int(tag>>3)] = append(fields[int(tag>>3)], f)
+	}
+	return fields, nil
+}
+
+// stringValue returns the value of a string field, or "" if it isn't set.
+func (f pbFields) stringValue(field int) string {
+	vals := f.stringValues(field)
+	if len(vals) == 0 {
+		return ""
+	}
+	return vals[len(vals)-1]
+}
+
+// stringValues returns the values of a repeated string field.
+func (f pbFields) stringValues(field int) []string {
+	var vals []string
+	for _, v := range f[field] {
+		if v.wire == pbBytes {
+			vals = append(vals, string(v.b))
+		}
+	}
+	return vals
+}
+
+// intValue returns the value of an int32 or int64 field, or 0 if it isn't set.
+func (f pbFields) intValue(field int) int64 {
+	vals := f.intValues(field)
+	if len(vals) == 0 {
+		return 0
+	}
+	return vals[len(vals)-1]
+}
+
+// intValues returns the values of a repeated int32 or int64 field, which may be
+// packed.
+func (f pbFields) intValues(field int) []int64 {
+	var vals []int64
+	for _, v := range f[field] {
+		switch v.wire {
+		case pbVarint:
+			vals = append(vals, int64(v.n))
+		case pbBytes:
+			for b := v.b; len(b) > 0; {
+				n, size, err := readPBVarint(b)
+				if err != nil {
+					break
+				}
+				vals = append(vals, int64(n))
+				b = b[size:]
+			}
+		}
+	}
+	return vals
+}
+
+// boolValue returns the value of a bool field, and whether it was set (for
+// optional fields).
+func (f pbFields) boolValue(field int) (v, ok bool) {
+	vals := f[field]
+	for i := len(vals) - 1; i >= 0; i-- {
+		if vals[i].wire == pbVarint {
+			return vals[i].n != 0, true
+		}
+	}
+	return false, false
+}
@@ -0,0 +1,82 @@
+package web
+
+// Tests for Protocol Buffers encoding and decoding
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestAppendPB(t *testing.T) {
+	one := 0
+	tests := []struct {
+		name   string
+		got    []byte
+		expect []byte
+	}{
+		{"varint", appendPBInt(nil, 1, 150), []byte{0x08, 0x96, 0x01}},
+		{"zero int omitted", appendPBInt(nil, 1, 0), nil},
+		{"negative int", appendPBInt(nil, 1, -1), []byte{0x08, 0xFF, 0xFF, 0xFF,
+			0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0x01}},
+		{"optional zero int", appendPBOptionalInt(nil, 21, &one),
+			[]byte{0xA8, 0x01, 0x00}},
+		{"nil optional int omitted", appendPBOptionalInt(nil, 21, nil), nil},
+		{"string", appendPBString(nil, 2, "testing"), []byte{0x12, 0x07, 't', 'e',
+			's', 't', 'i', 'n', 'g'}},
+		{"false bool omitted", appendPBBool(nil, 3, false), nil},
+		{"bool", appendPBBool(nil, 3, true), []byte{0x18, 0x01}},
+		{"double", appendPBDouble(nil, 8, 1), []byte{0x41, 0, 0, 0, 0, 0, 0,
+			0xF0, 0x3F}},
+		{"float", appendPBFloat(nil, 3, 1), []byte{0x1D, 0, 0, 0x80, 0x3F}},
+		{"map", appendPBStringMap(nil, 1, map[string]string{"b": "2", "a": "1"}),
+			[]byte{0x0A, 0x06, 0x0A, 0x01, 'a', 0x12, 0x01, '1',
+				0x0A, 0x06, 0x0A, 0x01, 'b', 0x12, 0x01, '2'}},
+	}
+	for _, tt := range tests {
+		if !bytes.Equal(tt.got, tt.expect) {
+			t.Errorf("%s: expected % X, got: % X", tt.name, tt.expect, tt.got)
+		}
+	}
+}
+
+func TestDecodePB(t *testing.T) {
+	var b []byte
+	b = appendPBString(b, 1, "quakelive")
+	b = appendPBStrings(b, 2, []string{"DE", "FR"})
+	b = appendPBBool(b, 16, true)
+	b = appendPBInt(b, 25, 20)
+	// packed repeated int64, as sent by protobuf encoders
+	b = appendPBBytes(b, 3, []byte{0x01, 0x96, 0x01})
+	b = appendPBInt(b, 3, 7)
+
+	f, err := decodePB(b)
+	if err != nil {
+		t.Fatalf("Unable to decode message: %s", err)
+	}
+	if v := f.stringValue(1); v != "quakelive" {
+		t.Errorf("Expected field 1 to be quakelive, got: %s", v)
+	}
+	if v := f.stringValues(2); !reflect.DeepEqual(v, []string{"DE", "FR"}) {
+		t.Errorf("Expected field 2 to be [DE FR], got: %v", v)
+	}
+	if v, ok := f.boolValue(16); !v || !ok {
+		t.Errorf("Expected field 16 to be set to true, got: %v (set: %v)", v, ok)
+	}
+	if _, ok := f.boolValue(17); ok {
+		t.Errorf("Expected field 17 not to be set")
+	}
+	if v := f.intValue(25); v != 20 {
+		t.Errorf("Expected field 25 to be 20, got: %d", v)
+	}
+	if v := f.intValues(3); !reflect.DeepEqual(v, []int64{1, 150, 7}) {
+		t.Errorf("Expected field 3 to be [1 150 7], got: %v", v)
+	}
+
+	for _, bad := range [][]byte{{0x08}, {0x0A, 0x05, 'a'}, {0x09, 0x01},
+		{0x0B}} {
+		if _, err := decodePB(bad); err == nil {
+			t.Errorf("Expected error decoding % X", bad)
+		}
+	}
+}
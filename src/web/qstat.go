@@ -0,0 +1,63 @@
+package web
+
+// qstat.go - Endpoints that output the servers list in qstat's raw and XML
+// formats, for communities with tooling built around scraping qstat.
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/syncore/a2sapi/src/models"
+)
+
+// defaultQStatDelim is the delimiter of the raw output if none is requested.
+const defaultQStatDelim = ","
+
+// getQStatServerList returns the current server list, filtered with the same
+// query strings as the servers list, or an empty list if there is none yet.
+func getQStatServerList(w http.ResponseWriter,
+	r *http.Request) (*models.APIServerList, bool) {
+	if err := checkSrvFilterValues(r.URL.Query(), getServersQueryStrings); err != nil {
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"error": {"code": 400,"message": "%s"}}`, err)
+		return nil, false
+	}
+	asl, ok := getCurrentServerList(w, r)
+	if !ok {
+		return nil, false
+	}
+	// Empty (i.e. during first retrieval/startup)
+	if asl == nil {
+		return models.GetDefaultServerList(), true
+	}
+	srvfilters := getSrvFilterFromQString(r.URL.Query(), getServersQueryStrings)
+	return filterServers(srvfilters, asl), true
+}
+
+func getQStatXML(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	sl, ok := getQStatServerList(w, r)
+	if !ok {
+		return
+	}
+	writeServerListResponse(w, sl, serverListView{name: qsViewQStat})
+}
+
+func getQStatRaw(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	sl, ok := getQStatServerList(w, r)
+	if !ok {
+		return
+	}
+	delim, _ := getQStringValue(r.URL.Query(), qsQStatDelim)
+	if delim == "" {
+		delim = defaultQStatDelim
+	}
+	rules, _ := getQStringValue(r.URL.Query(), qsQStatRules)
+	players, _ := getQStringValue(r.URL.Query(), qsQStatPlayers)
+	w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	w.Write(sl.QStatRaw(delim, strings.EqualFold(rules, "true"),
+		strings.EqualFold(players, "true")))
+}
@@ -0,0 +1,37 @@
+package web
+
+// querynets.go - Restriction of the addresses that can be directly queried
+// (/query?hosts), so that a public API can't be used to send A2S traffic to
+// arbitrary networks
+
+import (
+	"net"
+
+	"github.com/syncore/a2sapi/src/config"
+)
+
+// The networks that directly queried addresses must be in (nil for any), and
+// the networks they must not be in; set when the router is created.
+var (
+	directQueryAllowedNets []*net.IPNet
+	directQueryDeniedNets  []*net.IPNet
+)
+
+// loadDirectQueryNetworks parses the configured allowed and denied networks
+// for direct queries.
+func loadDirectQueryNetworks() {
+	directQueryAllowedNets = parseNetworks(
+		config.Get().WebConfig.DirectQueryAllowedNetworks, "direct query allowed")
+	directQueryDeniedNets = parseNetworks(
+		config.Get().WebConfig.DirectQueryDeniedNetworks, "direct query denied")
+}
+
+// isDirectQueryAllowed returns true if ip may be directly queried: it is not in
+// a denied network, and is in an allowed network if any are configured.
+func isDirectQueryAllowed(ip net.IP) bool {
+	if networksContain(directQueryDeniedNets, ip) {
+		return false
+	}
+	return directQueryAllowedNets == nil ||
+		networksContain(directQueryAllowedNets, ip)
+}
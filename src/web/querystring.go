@@ -7,13 +7,19 @@ import "strings"
 type querystring struct {
 	name     string
 	boolonly bool
+	// the value is a number; servers match if they have at least that many
+	intonly  bool
 	required bool
 }
 
 type slQueryFilter struct {
 	name      string
 	needsbool bool
+	needsint  bool
 	values    []string
+	// ignoreColors is set when text filters should match regardless of Quake
+	// color codes (i.e: ^1)
+	ignoreColors bool
 }
 
 // query string names
@@ -30,6 +36,97 @@ const (
 	// ?hosts
 	qsQueryServerAddrs = "hosts"
 
+	// /query - all known servers on an IP:
+	// ?ip=
+	qsQueryServerIPs = "ip"
+
+	// response shaping (servers & query):
+	// ?view=
+	qsView = "view"
+	// ?view=compact
+	qsViewCompact = "compact"
+	// ?view=hosts (ip:port per line)
+	qsViewHosts = "hosts"
+	// ?view=qstat (qstat -xml format)
+	qsViewQStat = "qstat"
+	// ?format= (otherwise negotiated with the Accept header)
+	qsFormat = "format"
+	// ?format=json (default)
+	qsFormatJSON = "json"
+	// ?format=csv (server lists only)
+	qsFormatCSV = "csv"
+	// ?format=msgpack
+	qsFormatMsgPack = "msgpack"
+
+	// per-game list (servers):
+	// ?game=
+	qsServersGame = "game"
+	// known servers that are offline (servers):
+	// ?includeOffline=
+	qsIncludeOffline = "includeOffline"
+	// ?offlineWithin=
+	qsOfflineWithin = "offlineWithin"
+
+	// random servers:
+	// ?count=
+	qsRandomCount = "count"
+
+	// pagination (servers):
+	// ?limit=
+	qsPageLimit = "limit"
+	// ?cursor=
+	qsPageCursor = "cursor"
+	// ?offset=
+	qsPageOffset = "offset"
+	// ?sort=
+	qsSortServers = "sort"
+
+	// server history:
+	// ?range=
+	qsHistoryRange = "range"
+
+	// snapshots:
+	// ?at=
+	qsSnapshotAt = "at"
+
+	// diagnosis:
+	// ?host=
+	qsDiagnoseHost = "host"
+
+	// claims:
+	// ?id=
+	qsClaimServerID = "id"
+	// ?alias=
+	qsClaimAlias = "alias"
+	// ?description=
+	qsClaimDescription = "description"
+	// ?notifyURL=
+	qsClaimNotifyURL = "notifyURL"
+
+	// admin API keys:
+	// ?id=
+	qsAPIKeyID = "id"
+	// ?name=
+	qsAPIKeyName = "name"
+	// ?scopes=
+	qsAPIKeyScopes = "scopes"
+	// ?hourlyQuota=
+	qsAPIKeyHourlyQuota = "hourlyQuota"
+	// ?dailyQuota=
+	qsAPIKeyDailyQuota = "dailyQuota"
+
+	// admin cache purge:
+	// ?name=
+	qsCacheName = "name"
+
+	// qstat raw output:
+	// ?delim=
+	qsQStatDelim = "delim"
+	// ?rules=
+	qsQStatRules = "rules"
+	// ?players=
+	qsQStatPlayers = "players"
+
 	// getServers:
 	// ?country=
 	qsGetServersCountry = "countries"
@@ -37,33 +134,56 @@ const (
 	qsGetServersRegion = "regions"
 	// ?state=
 	qsGetServersState = "states"
+	// ?ip=
+	qsGetServersIP = "ip"
 	// info filtering
 	// ?serverName=
 	qsGetServersName = "serverNames"
-	// ?map=
+	// ?maps=
 	qsGetServersMap = "maps"
+	// ?map= (exact map name)
+	qsGetServersMapExact = "map"
 	// ?game=
 	qsGetServersGame = "games"
 	// gametype=
 	qsGetServersGameType = "gametypes"
 	// ?serverType=
 	qsGetServersType = "serverTypes"
+	// ?type= (short form of serverTypes)
+	qsGetServersTypeShort = "type"
 	// ?serverOS=
 	qsGetServersOS = "serverOS"
+	// ?os= (short form of serverOS)
+	qsGetServersOSShort = "os"
 	// ?serverVersion=
 	qsGetServersVersion = "serverVersions"
 	// ?serverKeywords=
 	qsGetServersKeywords = "serverKeywords"
+	// ?ignoreColors= (bool, applies to the serverNames, maps and
+	// serverKeywords filters)
+	qsGetServersIgnoreColors = "ignoreColors"
 	// ?hasPlayers= (bool)
 	qsGetServersHasPlayers = "hasPlayers"
 	// ?hasBots= (bool)
 	qsGetServersHasBots = "hasBots"
+	// ?noBots= (bool)
+	qsGetServersNoBots = "noBots"
+	// ?minPlayers= (int)
+	qsGetServersMinPlayers = "minPlayers"
 	// ?hasPassword= (bool)
 	qsGetServersHasPassword = "hasPassword"
 	// ?hasAntiCheat= (bool)
 	qsGetServersHasAntiCheat = "hasAntiCheat"
+	// ?vac= (bool, short form of hasAntiCheat)
+	qsGetServersVAC = "vac"
 	// ?isNotFull= (bool)
 	qsGetServersIsNotFull = "isNotFull"
+	// ?minFreeSlots= (int)
+	qsGetServersMinFreeSlots = "minFreeSlots"
+	// ?passwordProtected= (bool)
+	qsGetServersPasswordProtected = "passwordProtected"
+	// ?hasSpectators= (bool)
+	qsGetServersHasSpectators = "hasSpectators"
 )
 
 // getServerIDs query strings
@@ -90,6 +210,97 @@ var queryServerAddrQueryStrings = []querystring{
 	},
 }
 
+// queryServerIP query strings
+var queryServerIPQueryStrings = []querystring{
+	querystring{
+		name:     qsQueryServerIPs,
+		required: true,
+	},
+}
+
+// claims query strings
+var claimQueryStrings = []querystring{
+	querystring{
+		name:     qsClaimServerID,
+		required: true,
+	},
+	querystring{
+		name: qsClaimAlias,
+	},
+	querystring{
+		name: qsClaimDescription,
+	},
+	querystring{
+		name: qsClaimNotifyURL,
+	},
+}
+
+// live servers query strings
+var liveQueryStrings = []querystring{
+	querystring{
+		name: qsServersGame,
+	},
+	querystring{
+		name: qsGetServersRegion,
+	},
+	querystring{
+		name: qsGetServersCountry,
+	},
+}
+
+// live servers filters (the game is handled separately)
+var liveFilterQueryStrings = []querystring{
+	querystring{
+		name: qsGetServersRegion,
+	},
+	querystring{
+		name: qsGetServersCountry,
+	},
+}
+
+// event feed query strings
+var eventFeedQueryStrings = []querystring{
+	querystring{
+		name: qsServersGame,
+	},
+	querystring{
+		name: qsPageLimit,
+	},
+}
+
+// server history query strings
+var serverHistoryQueryStrings = []querystring{
+	querystring{
+		name: qsHistoryRange,
+	},
+}
+
+// snapshots query strings
+var snapshotQueryStrings = []querystring{
+	querystring{
+		name:     qsSnapshotAt,
+		required: true,
+	},
+	querystring{
+		name: qsServersGame,
+	},
+}
+
+// diagnose query strings
+var diagnoseQueryStrings = []querystring{
+	querystring{
+		name:     qsDiagnoseHost,
+		required: true,
+	},
+}
+
+// virtual servers query strings
+var virtualServersQueryStrings = []querystring{
+	querystring{
+		name: qsServersGame,
+	},
+}
+
 // getServers query strings
 var getServersQueryStrings = []querystring{
 	querystring{
@@ -101,12 +312,18 @@ var getServersQueryStrings = []querystring{
 	querystring{
 		name: qsGetServersState,
 	},
+	querystring{
+		name: qsGetServersIP,
+	},
 	querystring{
 		name: qsGetServersName,
 	},
 	querystring{
 		name: qsGetServersMap,
 	},
+	querystring{
+		name: qsGetServersMapExact,
+	},
 	querystring{
 		name: qsGetServersGame,
 	},
@@ -116,9 +333,15 @@ var getServersQueryStrings = []querystring{
 	querystring{
 		name: qsGetServersType,
 	},
+	querystring{
+		name: qsGetServersTypeShort,
+	},
 	querystring{
 		name: qsGetServersOS,
 	},
+	querystring{
+		name: qsGetServersOSShort,
+	},
 	querystring{
 		name: qsGetServersVersion,
 	},
@@ -133,6 +356,14 @@ var getServersQueryStrings = []querystring{
 		name:     qsGetServersHasBots,
 		boolonly: true,
 	},
+	querystring{
+		name:     qsGetServersNoBots,
+		boolonly: true,
+	},
+	querystring{
+		name:    qsGetServersMinPlayers,
+		intonly: true,
+	},
 	querystring{
 		name:     qsGetServersHasPassword,
 		boolonly: true,
@@ -141,10 +372,30 @@ var getServersQueryStrings = []querystring{
 		name:     qsGetServersHasAntiCheat,
 		boolonly: true,
 	},
+	querystring{
+		name:     qsGetServersVAC,
+		boolonly: true,
+	},
 	querystring{
 		name:     qsGetServersIsNotFull,
 		boolonly: true,
 	},
+	querystring{
+		name:    qsGetServersMinFreeSlots,
+		intonly: true,
+	},
+	querystring{
+		name:     qsGetServersPasswordProtected,
+		boolonly: true,
+	},
+	querystring{
+		name:     qsGetServersHasSpectators,
+		boolonly: true,
+	},
+	querystring{
+		name:     qsGetServersIgnoreColors,
+		boolonly: true,
+	},
 }
 
 // getQStringValues takes the map returned by a *http.Request URL.Query(),
@@ -167,3 +418,15 @@ func getQStringValues(m map[string][]string, querystring string) []string {
 	}
 	return vals
 }
+
+// getQStringValue takes the map returned by a *http.Request URL.Query() and
+// returns the entire (unsplit) value of a key defined in that map which is
+// specified as a known querystring value to match.
+func getQStringValue(m map[string][]string, querystring string) (string, bool) {
+	for k := range m {
+		if strings.EqualFold(k, querystring) {
+			return m[k][0], true
+		}
+	}
+	return "", false
+}
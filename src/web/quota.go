@@ -0,0 +1,100 @@
+package web
+
+// quota.go - Hourly and daily quotas on direct queries, which cost upstream
+// resources, for each API key and for each anonymous client's IP address
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/db"
+)
+
+// useQuota records a request against an identity's quotas; see ADB.UseQuota.
+var useQuota = func(identity string, hour, day int64, hourLimit,
+	dayLimit int) (int, int, bool, error) {
+	return db.AppDB.UseQuota(identity, hour, day, hourLimit, dayLimit)
+}
+
+// getQuotaIdentity returns the identity whose quotas a request counts against,
+// and the identity's hourly and daily limits (0 for no limit).
+func getQuotaIdentity(r *http.Request) (string, int, int) {
+	cfg := config.Get().AdminConfig
+	if key := getRequestKey(r); key != "" {
+		if k, err := authenticateAPIKey(key); err == nil && k != nil {
+			hourly, daily := cfg.KeyHourlyQueryQuota, cfg.KeyDailyQueryQuota
+			if k.HourlyQuota != 0 {
+				hourly = k.HourlyQuota
+			}
+			if k.DailyQuota != 0 {
+				daily = k.DailyQuota
+			}
+			return "key:" + k.ID, hourly, daily
+		}
+	}
+	return "ip:" + clientIP(r), cfg.AnonymousHourlyQueryQuota, cfg.AnonymousDailyQueryQuota
+}
+
+// setQuotaRemaining sets the X-Quota-Remaining-Hour and X-Quota-Remaining-Day
+// headers for the periods that have a limit.
+func setQuotaRemaining(w http.ResponseWriter, header string, limit, used int) {
+	if limit <= 0 {
+		return
+	}
+	remaining := limit - used
+	if remaining < 0 {
+		remaining = 0
+	}
+	w.Header().Set(header, strconv.Itoa(remaining))
+}
+
+// limitQuota wraps a direct query route's handler so that it is only served
+// while the requesting API key or anonymous IP address has quota remaining;
+// otherwise a 429 response with a Retry-After header is returned. Requests are
+// served if the usage can't be recorded.
+func limitQuota(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, hourly, daily := getQuotaIdentity(r)
+		if db.AppDB == nil || (hourly <= 0 && daily <= 0) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if hourly < 0 {
+			hourly = 0
+		}
+		if daily < 0 {
+			daily = 0
+		}
+		now := time.Now().UTC()
+		hour := now.Truncate(time.Hour)
+		day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		hourUsed, dayUsed, ok, err := useQuota(identity, hour.Unix(), day.Unix(),
+			hourly, daily)
+		if err != nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		setQuotaRemaining(w, "X-Quota-Remaining-Hour", hourly, hourUsed)
+		setQuotaRemaining(w, "X-Quota-Remaining-Day", daily, dayUsed)
+		if !ok {
+			reset := hour.Add(time.Hour)
+			period := "hourly"
+			if daily > 0 && dayUsed >= daily {
+				reset = day.AddDate(0, 0, 1)
+				period = "daily"
+			}
+			w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+			w.Header().Set("Retry-After",
+				strconv.Itoa(int(reset.Sub(now)/time.Second)+1))
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprintf(w,
+				`{"error": {"code": 429,"message": "The %s direct query quota has been reached. Try again later."}}`,
+				period)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,81 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/models"
+)
+
+func TestLimitQuota(t *testing.T) {
+	prevCfg := config.Get().AdminConfig
+	prevUse, prevLookup := useQuota, lookupAPIKey
+	defer func() {
+		config.Get().AdminConfig = prevCfg
+		useQuota, lookupAPIKey = prevUse, prevLookup
+	}()
+	config.Get().AdminConfig.AnonymousHourlyQueryQuota = 2
+	config.Get().AdminConfig.KeyHourlyQueryQuota = 1
+	config.Get().AdminConfig.KeyDailyQueryQuota = 0
+
+	usage := make(map[string]int)
+	useQuota = func(identity string, hour, day int64, hourLimit,
+		dayLimit int) (int, int, bool, error) {
+		if hourLimit > 0 && usage[identity] >= hourLimit {
+			return usage[identity], usage[identity], false, nil
+		}
+		usage[identity]++
+		return usage[identity], usage[identity], true, nil
+	}
+	unlimitedKey := "a2sk_unlimited_secret"
+	lookupAPIKey = func(id string) (*models.DbAPIKey, error) {
+		if id == "unlimited" {
+			return &models.DbAPIKey{ID: id, KeyHash: hashOwnerKey(unlimitedKey),
+				HourlyQuota: -1}, nil
+		}
+		return nil, nil
+	}
+	h := limitQuota(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {}))
+	request := func(key string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("GET", "/query?hosts=10.0.0.1:27960", nil)
+		r.RemoteAddr = "192.0.2.1:50000"
+		if key != "" {
+			r.Header.Set("X-API-Key", key)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := request(""); w.Code != http.StatusOK ||
+		w.Header().Get("X-Quota-Remaining-Hour") != "1" {
+		t.Fatalf("Expected first request with 1 remaining, got: %d, %s", w.Code,
+			w.Header().Get("X-Quota-Remaining-Hour"))
+	}
+	request("")
+	w := request("")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status code %d when quota is reached, got: %d",
+			http.StatusTooManyRequests, w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" ||
+		w.Header().Get("X-Quota-Remaining-Hour") != "0" {
+		t.Fatalf("Expected Retry-After and no remaining quota, got: %v", w.Header())
+	}
+	if usage["ip:192.0.2.1"] != 2 {
+		t.Fatalf("Expected anonymous usage to be tracked by IP, got: %v", usage)
+	}
+
+	// keys with no limit of their own aren't tracked
+	for i := 0; i < 3; i++ {
+		if w := request(unlimitedKey); w.Code != http.StatusOK {
+			t.Fatalf("Expected unlimited key to be served, got: %d", w.Code)
+		}
+	}
+	if _, ok := usage["key:unlimited"]; ok {
+		t.Fatalf("Expected unlimited key's usage not to be tracked")
+	}
+}
@@ -0,0 +1,103 @@
+package web
+
+// random.go - Weighted random server picker, i.e: for "quick join" features in
+// game launchers
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/models"
+)
+
+const maxRandomServers = 25
+
+var (
+	randomMu  sync.Mutex
+	randomSrc = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// pickWeightedServers picks up to count distinct servers from servers that have
+// at least minPlayers players, at random, weighted by each server's free slots.
+// Full servers are never picked.
+func pickWeightedServers(r *rand.Rand, servers []models.APIServer, minPlayers,
+	count int) []models.APIServer {
+	var candidates []models.APIServer
+	total := 0
+	for _, s := range servers {
+		if int(s.Info.Players) < minPlayers || s.FreeSlots == 0 {
+			continue
+		}
+		candidates = append(candidates, s)
+		total += s.FreeSlots
+	}
+	picked := make([]models.APIServer, 0, count)
+	for len(picked) < count && len(candidates) > 0 {
+		n := r.Intn(total)
+		for i, s := range candidates {
+			n -= s.FreeSlots
+			if n < 0 {
+				picked = append(picked, s)
+				total -= s.FreeSlots
+				candidates = append(candidates[:i], candidates[i+1:]...)
+				break
+			}
+		}
+	}
+	return picked
+}
+
+// getQStringInt returns the integer value of a query string, def if it is not
+// present, or an error if it is not a number within [min, max].
+func getQStringInt(m map[string][]string, querystring string, def, min,
+	max int) (int, error) {
+	val, ok := getQStringValue(m, querystring)
+	if !ok || val == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil || n < min || n > max {
+		return 0, fmt.Errorf("The %s parameter must be a number from %d to %d.",
+			querystring, min, max)
+	}
+	return n, nil
+}
+
+func getRandomServers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	q := r.URL.Query()
+	count, err := getQStringInt(q, qsRandomCount, 1, 1, maxRandomServers)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"error": {"code": 400,"message": "%s"}}`, err)
+		return
+	}
+	minPlayers, err := getQStringInt(q, qsGetServersMinPlayers, 0, 0, 255)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"error": {"code": 400,"message": "%s"}}`, err)
+		return
+	}
+	asl, ok := getCurrentServerList(w, r)
+	if !ok {
+		return
+	}
+	// Empty (i.e. during first retrieval/startup)
+	if asl == nil {
+		writeJSONResponse(w, models.GetDefaultServerList())
+		return
+	}
+	srvfilters := getSrvFilterFromQString(q, getServersQueryStrings)
+	logger.WriteDebug("random servers will be filtered with: %v", srvfilters)
+	list := filterServers(srvfilters, asl)
+	randomMu.Lock()
+	list.Servers = pickWeightedServers(randomSrc, list.Servers, minPlayers, count)
+	randomMu.Unlock()
+	list.ServerCount = len(list.Servers)
+	writeServerListResponse(w, markStaleServers(list), getView(r))
+}
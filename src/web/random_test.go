@@ -0,0 +1,51 @@
+package web
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/syncore/a2sapi/src/models"
+)
+
+func newRandomTestServer(id int64, players, maxplayers int16) models.APIServer {
+	return models.APIServer{ID: id, FreeSlots: int(maxplayers - players),
+		Info: models.SteamServerInfo{Players: players, MaxPlayers: maxplayers}}
+}
+
+func TestPickWeightedServers(t *testing.T) {
+	servers := []models.APIServer{
+		newRandomTestServer(1, 8, 8),  // full
+		newRandomTestServer(2, 1, 16), // below minPlayers
+		newRandomTestServer(3, 4, 5),  // 1 free slot
+		newRandomTestServer(4, 4, 13), // 9 free slots
+	}
+	r := rand.New(rand.NewSource(1))
+	picks := make(map[int64]int)
+	for i := 0; i < 1000; i++ {
+		picked := pickWeightedServers(r, servers, 2, 1)
+		if len(picked) != 1 {
+			t.Fatalf("Expected 1 server to be picked, got: %d", len(picked))
+		}
+		picks[picked[0].ID]++
+	}
+	if picks[1] != 0 || picks[2] != 0 {
+		t.Fatalf("Expected full and too-empty servers to never be picked, got: %v",
+			picks)
+	}
+	if picks[4] < picks[3]*4 {
+		t.Fatalf("Expected server with more free slots to be picked more often, got: %v",
+			picks)
+	}
+
+	picked := pickWeightedServers(r, servers, 0, 10)
+	if len(picked) != 3 {
+		t.Fatalf("Expected all 3 non-full servers to be picked, got: %d", len(picked))
+	}
+	seen := make(map[int64]bool)
+	for _, s := range picked {
+		if seen[s.ID] {
+			t.Fatalf("Expected distinct servers, got server %d twice", s.ID)
+		}
+		seen[s.ID] = true
+	}
+}
@@ -0,0 +1,160 @@
+package web
+
+// ratelimit.go - Per-client (by IP address) token bucket rate limiting of API
+// requests, so that a single client can't monopolize a publicly exposed API.
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/logger"
+)
+
+// rateLimitSweepInterval is how often buckets that have refilled completely
+// (i.e. of clients that have gone idle) are removed.
+const rateLimitSweepInterval = time.Minute
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps a token bucket for each client IP address. Each request
+// takes a token; buckets hold up to burst tokens and are refilled at rate tokens
+// per second. A nil limiter does not limit.
+type rateLimiter struct {
+	rate      float64
+	burst     float64
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{rate: rate, burst: float64(burst),
+		buckets: make(map[string]*tokenBucket), lastSweep: time.Now()}
+}
+
+// take takes a token from the client's bucket. If the bucket is empty, it
+// returns false and the time until a token will be available.
+func (l *rateLimiter) take(client string, now time.Time) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastSweep) >= rateLimitSweepInterval {
+		l.sweep(now)
+	}
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	} else {
+		l.refill(b, now)
+	}
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+func (l *rateLimiter) refill(b *tokenBucket, now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(l.burst, b.tokens+elapsed*l.rate)
+		b.last = now
+	}
+}
+
+// sweep removes the buckets that have refilled completely, since they are
+// indistinguishable from new ones.
+func (l *rateLimiter) sweep(now time.Time) {
+	for client, b := range l.buckets {
+		l.refill(b, now)
+		if b.tokens >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+	l.lastSweep = now
+}
+
+// apiRateLimit is the API's rate limiter, which is replaced when the configured
+// rate or burst is reloaded.
+var apiRateLimit = struct {
+	sync.RWMutex
+	limiter *rateLimiter
+	rate    float64
+	burst   int
+}{}
+
+func init() {
+	config.OnReload(func(old, cfg *config.Cfg) {
+		setAPIRateLimit(cfg.WebConfig.RateLimitPerSecond,
+			cfg.WebConfig.RateLimitBurst)
+	})
+}
+
+// setAPIRateLimit sets the API's rate limit, replacing its limiter (and thereby
+// resetting the clients' buckets) if the rate or burst changed.
+func setAPIRateLimit(rate float64, burst int) {
+	apiRateLimit.Lock()
+	defer apiRateLimit.Unlock()
+	if apiRateLimit.limiter != nil && rate == apiRateLimit.rate &&
+		burst == apiRateLimit.burst {
+		return
+	}
+	apiRateLimit.limiter = newRateLimiter(rate, burst)
+	apiRateLimit.rate = rate
+	apiRateLimit.burst = burst
+}
+
+// getAPIRateLimiter returns the API's current rate limiter.
+func getAPIRateLimiter() *rateLimiter {
+	apiRateLimit.RLock()
+	defer apiRateLimit.RUnlock()
+	return apiRateLimit.limiter
+}
+
+// limitRate wraps an HTTP handler so that it is only served while the
+// requesting IP address has tokens in its bucket; otherwise a 429 response with
+// a Retry-After header is returned.
+func limitRate(h http.Handler, limiter *rateLimiter, name string) http.Handler {
+	if limiter == nil {
+		return h
+	}
+	return limitRateWith(h, func() *rateLimiter { return limiter }, name)
+}
+
+// limitRateWith is limitRate with the limiter returned by limiter for each
+// request, so that it can be replaced while serving.
+func limitRateWith(h http.Handler, limiter func() *rateLimiter,
+	name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r)
+		ok, wait := limiter().take(ip, time.Now())
+		if !ok {
+			logger.WriteDebug("%s: rate limit reached for %s", name, ip)
+			w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+			w.Header().Set("Retry-After",
+				strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprintf(w,
+				`{"error": {"code": 429,"message": "Too many requests. Try again later."}}`)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
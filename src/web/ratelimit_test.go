@@ -0,0 +1,111 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterTake(t *testing.T) {
+	l := newRateLimiter(2, 3)
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.take("10.0.0.1", now); !ok {
+			t.Fatalf("Expected request %d to be allowed within the burst", i+1)
+		}
+	}
+	ok, wait := l.take("10.0.0.1", now)
+	if ok {
+		t.Fatalf("Expected request beyond the burst to be limited")
+	}
+	if wait != 500*time.Millisecond {
+		t.Fatalf("Expected to wait 500ms for the next token, got: %s", wait)
+	}
+	// other clients have their own buckets
+	if ok, _ := l.take("10.0.0.2", now); !ok {
+		t.Fatalf("Expected another client's request to be allowed")
+	}
+	// refilled at 2 tokens per second
+	if ok, _ := l.take("10.0.0.1", now.Add(500*time.Millisecond)); !ok {
+		t.Fatalf("Expected request to be allowed after refill")
+	}
+	if ok, _ := l.take("10.0.0.1", now.Add(500*time.Millisecond)); ok {
+		t.Fatalf("Expected only one token to have been refilled")
+	}
+	// idle clients' buckets are removed
+	l.take("10.0.0.1", now.Add(rateLimitSweepInterval+time.Second))
+	if len(l.buckets) != 1 {
+		t.Fatalf("Expected idle buckets to be removed, got %d buckets",
+			len(l.buckets))
+	}
+}
+
+func TestLimitRate(t *testing.T) {
+	h := limitRate(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+	}), newRateLimiter(0.1, 1), "test")
+
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.1:50000"
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d for first request, got: %d",
+			http.StatusOK, w.Code)
+	}
+	r.RemoteAddr = "10.0.0.1:50001"
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status code %d when rate limited, got: %d",
+			http.StatusTooManyRequests, w.Code)
+	}
+	if ra := w.Header().Get("Retry-After"); ra != "10" {
+		t.Fatalf("Expected Retry-After of 10 seconds, got: %s", ra)
+	}
+
+	if newRateLimiter(0, 20) != nil {
+		t.Fatalf("Expected a rate of 0 to disable the limiter")
+	}
+}
+
+func TestAPIRateLimitReload(t *testing.T) {
+	defer setAPIRateLimit(0, 0)
+	h := limitRateWith(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+	}), getAPIRateLimiter, "test")
+	serve := func() int {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "10.0.0.1:50000"
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	setAPIRateLimit(0.1, 1)
+	if code := serve(); code != http.StatusOK {
+		t.Fatalf("Expected status code %d for first request, got: %d",
+			http.StatusOK, code)
+	}
+	// reloading the same limit keeps the clients' buckets
+	setAPIRateLimit(0.1, 1)
+	if code := serve(); code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status code %d when rate limited, got: %d",
+			http.StatusTooManyRequests, code)
+	}
+	setAPIRateLimit(0.1, 2)
+	for i := 0; i < 2; i++ {
+		if code := serve(); code != http.StatusOK {
+			t.Fatalf("Expected request %d to be allowed with the new burst, got: %d",
+				i+1, code)
+		}
+	}
+	setAPIRateLimit(0, 0)
+	for i := 0; i < 5; i++ {
+		if code := serve(); code != http.StatusOK {
+			t.Fatalf("Expected requests to be allowed with the limit disabled, got: %d",
+				code)
+		}
+	}
+}
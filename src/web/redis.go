@@ -0,0 +1,222 @@
+package web
+
+// redis.go - Minimal Redis (RESP) client for the response cache, so that
+// instances behind a load balancer can share cached responses.
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/syncore/a2sapi/src/logger"
+)
+
+const (
+	redisTimeout = time.Second
+	// idle connections kept for reuse
+	redisMaxIdle = 4
+	// prefix of the response cache's keys
+	redisResponsePrefix = "a2sapi:response:"
+)
+
+// redisConn is a connection to a Redis server.
+type redisConn struct {
+	c net.Conn
+	r *bufio.Reader
+}
+
+// redisClient sends commands to a Redis server over a small pool of
+// connections.
+type redisClient struct {
+	addr string
+	mu   sync.Mutex
+	idle []*redisConn
+}
+
+func (rc *redisClient) conn() (*redisConn, error) {
+	rc.mu.Lock()
+	if n := len(rc.idle); n > 0 {
+		c := rc.idle[n-1]
+		rc.idle = rc.idle[:n-1]
+		rc.mu.Unlock()
+		return c, nil
+	}
+	rc.mu.Unlock()
+	c, err := net.DialTimeout("tcp", rc.addr, redisTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return &redisConn{c: c, r: bufio.NewReader(c)}, nil
+}
+
+func (rc *redisClient) release(c *redisConn) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if len(rc.idle) < redisMaxIdle {
+		rc.idle = append(rc.idle, c)
+		return
+	}
+	c.c.Close()
+}
+
+// do sends a command and returns its reply: a string, []byte, int64,
+// []interface{}, or nil.
+func (rc *redisClient) do(args ...string) (interface{}, error) {
+	c, err := rc.conn()
+	if err != nil {
+		return nil, err
+	}
+	c.c.SetDeadline(time.Now().Add(redisTimeout))
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err = c.c.Write([]byte(b.String())); err != nil {
+		c.c.Close()
+		return nil, err
+	}
+	reply, err := readRedisReply(c.r)
+	if err != nil {
+		var rerr redisError
+		if !errors.As(err, &rerr) {
+			// the connection is in an unknown state
+			c.c.Close()
+			return nil, err
+		}
+	}
+	rc.release(c)
+	return reply, err
+}
+
+// redisError is an error reply from the server.
+type redisError string
+
+func (e redisError) Error() string {
+	return "Redis: " + string(e)
+}
+
+func readRedisReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("Redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = readRedisReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("Redis: unexpected reply: %q", line)
+}
+
+// redisResponseCache keeps cached responses in Redis, which expires them. A
+// response is stored as its content type, a newline, and its body.
+type redisResponseCache struct {
+	client *redisClient
+}
+
+func newRedisResponseCache(addr string) *redisResponseCache {
+	return &redisResponseCache{client: &redisClient{addr: addr}}
+}
+
+func (c *redisResponseCache) get(key string) (cachedResponse, bool) {
+	reply, err := c.client.do("GET", redisResponsePrefix+key)
+	if err != nil {
+		logger.LogWebErrorf("Unable to get cached response from Redis: %s", err)
+		return cachedResponse{}, false
+	}
+	b, ok := reply.([]byte)
+	if !ok {
+		return cachedResponse{}, false
+	}
+	i := strings.IndexByte(string(b), '\n')
+	if i < 0 {
+		return cachedResponse{}, false
+	}
+	return cachedResponse{contentType: string(b[:i]), body: b[i+1:]}, true
+}
+
+func (c *redisResponseCache) set(key string, resp cachedResponse,
+	ttl time.Duration) {
+	_, err := c.client.do("SET", redisResponsePrefix+key,
+		resp.contentType+"\n"+string(resp.body), "PX",
+		strconv.FormatInt(int64(ttl/time.Millisecond), 10))
+	if err != nil {
+		logger.LogWebErrorf("Unable to cache response in Redis: %s", err)
+	}
+}
+
+// size is not known; the responses are shared with other instances.
+func (c *redisResponseCache) size() int {
+	return 0
+}
+
+func (c *redisResponseCache) purge() int {
+	n, cursor := 0, "0"
+	for {
+		reply, err := c.client.do("SCAN", cursor, "MATCH",
+			redisResponsePrefix+"*", "COUNT", "1000")
+		if err != nil {
+			logger.LogWebErrorf("Unable to purge cached responses from Redis: %s", err)
+			return n
+		}
+		items, ok := reply.([]interface{})
+		if !ok || len(items) != 2 {
+			return n
+		}
+		next, _ := items[0].([]byte)
+		keys, _ := items[1].([]interface{})
+		if len(keys) > 0 {
+			args := []string{"DEL"}
+			for _, k := range keys {
+				if b, ok := k.([]byte); ok {
+					args = append(args, string(b))
+				}
+			}
+			if deleted, err := c.client.do(args...); err == nil {
+				if d, ok := deleted.(int64); ok {
+					n += int(d)
+				}
+			}
+		}
+		cursor = string(next)
+		if cursor == "0" || cursor == "" {
+			return n
+		}
+	}
+}
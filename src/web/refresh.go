@@ -0,0 +1,95 @@
+package web
+
+// refresh.go - On-demand refresh of a single server by ID (i.e. for frontends
+// with a refresh button), limited to once per configured interval per host
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/db"
+	"github.com/syncore/a2sapi/src/models"
+	"github.com/syncore/a2sapi/src/steam"
+)
+
+// lookupServerHost returns the host and game of a server ID; empty strings if
+// the ID is unknown.
+var lookupServerHost = func(id int64) (string, string, error) {
+	return db.ServerDB.GetHostAndGame(id)
+}
+
+// refreshServer re-queries a server; see steam.RefreshServer.
+var refreshServer = steam.RefreshServer
+
+// findListedServer returns the server with the given host from the game's
+// current list, so that its ID, alias, and location are kept when it is
+// refreshed. Servers that aren't listed are given their ID and address.
+func findListedServer(id int64, host, game string) models.APIServer {
+	sl := models.GetGameList(game)
+	if sl == nil {
+		sl = models.GetMasterList()
+	}
+	if sl != nil {
+		for _, s := range sl.Servers {
+			if s.Host == host {
+				return s
+			}
+		}
+	}
+	srv := models.APIServer{ID: id, Host: host, Game: game}
+	if ip, port, err := net.SplitHostPort(host); err == nil {
+		srv.IP = ip
+		srv.Port, _ = strconv.Atoi(port)
+	}
+	return srv
+}
+
+func refreshServerByID(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if !config.Get().WebConfig.EnableServerRefresh || db.ServerDB == nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w,
+			`{"error": {"code": 404,"message": "Server refreshes are disabled."}}`)
+		return
+	}
+	id, err := strconv.ParseInt(pathSegment(r.URL.Path, 1), 10, 64)
+	if err != nil || id <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w,
+			`{"error": {"code": 400,"message": "Invalid server ID."}}`)
+		return
+	}
+	host, game, err := lookupServerHost(id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w,
+			`{"error": {"code": 500,"message": "Unable to look up server."}}`)
+		return
+	}
+	if host == "" {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w,
+			`{"error": {"code": 404,"message": "No server with that ID."}}`)
+		return
+	}
+	interval := time.Duration(
+		config.Get().WebConfig.ServerRefreshInterval) * time.Second
+	srv, wait, err := refreshServer(findListedServer(id, host, game), interval)
+	if wait > 0 {
+		w.Header().Set("Retry-After",
+			strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprintf(w,
+			`{"error": {"code": 429,"message": "The server was refreshed recently. Try again later."}}`)
+		return
+	}
+	if err == steam.ErrNoResponse {
+		srv.Status = models.ServerStatusTimedOut
+	}
+	writeJSONResponse(w, srv)
+}
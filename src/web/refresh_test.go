@@ -0,0 +1,87 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/models"
+	"github.com/syncore/a2sapi/src/steam"
+)
+
+func TestRefreshServerByID(t *testing.T) {
+	origLookup, origRefresh := lookupServerHost, refreshServer
+	origEnabled := config.Get().WebConfig.EnableServerRefresh
+	defer func() {
+		lookupServerHost, refreshServer = origLookup, origRefresh
+		config.Get().WebConfig.EnableServerRefresh = origEnabled
+	}()
+	lookupServerHost = func(id int64) (string, string, error) {
+		if id == 5 {
+			return "10.0.0.5:27960", "QuakeLive", nil
+		}
+		return "", "", nil
+	}
+	var wait time.Duration
+	var refreshErr error
+	refreshServer = func(srv models.APIServer, minInterval time.Duration) (
+		models.APIServer, time.Duration, error) {
+		srv.Info.Map = "campgrounds"
+		srv.Status = models.ServerStatusOnline
+		return srv, wait, refreshErr
+	}
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("POST", path, nil)
+		w := httptest.NewRecorder()
+		refreshServerByID(w, r)
+		return w
+	}
+
+	config.Get().WebConfig.EnableServerRefresh = false
+	if w := serve("/servers/5/refresh"); w.Code != http.StatusNotFound {
+		t.Fatalf("Expected status code %d when disabled, got: %d",
+			http.StatusNotFound, w.Code)
+	}
+	config.Get().WebConfig.EnableServerRefresh = true
+
+	w := serve("/servers/5/refresh")
+	srv := &models.APIServer{}
+	if err := json.Unmarshal(w.Body.Bytes(), srv); err != nil ||
+		w.Code != http.StatusOK {
+		t.Fatalf("Expected server in response, got: %d %s", w.Code, w.Body)
+	}
+	if srv.ID != 5 || srv.Host != "10.0.0.5:27960" || srv.Port != 27960 ||
+		srv.Info.Map != "campgrounds" {
+		t.Fatalf("Expected refreshed server 5, got: %+v", srv)
+	}
+
+	refreshErr = steam.ErrNoResponse
+	w = serve("/servers/5/refresh")
+	srv = &models.APIServer{}
+	json.Unmarshal(w.Body.Bytes(), srv)
+	if srv.Status != models.ServerStatusTimedOut {
+		t.Fatalf("Expected unresponsive server to be timed out, got: %s",
+			srv.Status)
+	}
+
+	wait, refreshErr = 1500*time.Millisecond, nil
+	w = serve("/servers/5/refresh")
+	if w.Code != http.StatusTooManyRequests ||
+		w.Header().Get("Retry-After") != "2" {
+		t.Fatalf("Expected 429 with Retry-After of 2 seconds, got: %d %q", w.Code,
+			w.Header().Get("Retry-After"))
+	}
+
+	if w := serve("/servers/6/refresh"); w.Code != http.StatusNotFound {
+		t.Fatalf("Expected status code %d for unknown ID, got: %d",
+			http.StatusNotFound, w.Code)
+	}
+	if w := serve("/servers/abc/refresh"); w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status code %d for invalid ID, got: %d",
+			http.StatusBadRequest, w.Code)
+	}
+}
@@ -0,0 +1,213 @@
+package web
+
+// respcache.go - Cache of API responses, keyed by route and normalized query
+// string, with per-route TTLs; i.e. so that repeated identical /serverIDs
+// requests don't hit the server database every time.
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/logger"
+	"github.com/syncore/a2sapi/src/util"
+)
+
+// Response cache backends
+const (
+	responseCacheMemory = "memory"
+	responseCacheRedis  = "redis"
+)
+
+// responseCacheBackend stores cached responses (the content type and body).
+type responseCacheBackend interface {
+	get(key string) (cachedResponse, bool)
+	set(key string, resp cachedResponse, ttl time.Duration)
+	// size returns the number of cached responses, if known
+	size() int
+	// purge removes all cached responses, returning how many were removed
+	purge() int
+}
+
+// cachedResponse is a successful response to a GET request.
+type cachedResponse struct {
+	contentType string
+	body        []byte
+}
+
+var (
+	responseCacheMu sync.Mutex
+	responseCache   responseCacheBackend
+)
+
+var responseCacheStats = util.RegisterCache("responses", func() int {
+	if b := getResponseCache(); b != nil {
+		return b.size()
+	}
+	return 0
+}, func() int {
+	if b := getResponseCache(); b != nil {
+		return b.purge()
+	}
+	return 0
+})
+
+// getResponseCache returns the configured response cache backend, creating it
+// on first use.
+func getResponseCache() responseCacheBackend {
+	responseCacheMu.Lock()
+	defer responseCacheMu.Unlock()
+	if responseCache != nil {
+		return responseCache
+	}
+	cfg := config.Get().WebConfig
+	switch strings.ToLower(cfg.ResponseCacheBackend) {
+	case responseCacheRedis:
+		responseCache = newRedisResponseCache(cfg.ResponseCacheRedisAddress)
+	case "", responseCacheMemory:
+		responseCache = newMemoryResponseCache(cfg.ResponseCacheMaxEntries)
+	default:
+		logger.LogAppErrorf("Unknown response cache backend: '%s'; using memory",
+			cfg.ResponseCacheBackend)
+		responseCache = newMemoryResponseCache(cfg.ResponseCacheMaxEntries)
+	}
+	return responseCache
+}
+
+// responseCacheKey returns the cache key of a request to a route: the route's
+// name and the request's query string, with lower case parameter names in
+// sorted order.
+func responseCacheKey(name string, q url.Values) string {
+	params := make([]string, 0, len(q))
+	for k, v := range q {
+		params = append(params, strings.ToLower(k)+"="+strings.Join(v, ","))
+	}
+	sort.Strings(params)
+	return name + "?" + strings.Join(params, "&")
+}
+
+// cachingWriter passes a response through while keeping a copy of its body.
+type cachingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *cachingWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *cachingWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// cacheResponses wraps a route's handler so that its successful responses to
+// GET requests are cached for ttl. Conditional requests are not served from the
+// cache, so that the handler can answer them with 304 (not modified).
+func cacheResponses(h http.HandlerFunc, name string,
+	ttl time.Duration) http.HandlerFunc {
+	if ttl <= 0 {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" || r.Header.Get("If-None-Match") != "" ||
+			r.Header.Get("If-Modified-Since") != "" {
+			h(w, r)
+			return
+		}
+		backend := getResponseCache()
+		key := responseCacheKey(name, r.URL.Query())
+		if f := getFormat(r); f != qsFormatJSON {
+			// the format may have been negotiated with the Accept header
+			key += "#" + f
+		}
+		if c, ok := backend.get(key); ok {
+			responseCacheStats.Hit()
+			w.Header().Set("Content-Type", c.contentType)
+			w.Header().Set("X-Cache", "HIT")
+			w.Write(c.body)
+			return
+		}
+		responseCacheStats.Miss()
+		w.Header().Set("X-Cache", "MISS")
+		cw := &cachingWriter{ResponseWriter: w}
+		h(cw, r)
+		if cw.status == http.StatusOK {
+			backend.set(key, cachedResponse{
+				contentType: w.Header().Get("Content-Type"),
+				body:        cw.body.Bytes()}, ttl)
+		}
+	}
+}
+
+// memoryResponseCache keeps cached responses in memory, up to a maximum number
+// of responses; expired responses are removed when the cache is full.
+type memoryResponseCache struct {
+	mu      sync.Mutex
+	max     int
+	entries map[string]memoryCacheEntry
+}
+
+type memoryCacheEntry struct {
+	resp    cachedResponse
+	expires time.Time
+}
+
+func newMemoryResponseCache(max int) *memoryResponseCache {
+	return &memoryResponseCache{max: max,
+		entries: make(map[string]memoryCacheEntry)}
+}
+
+func (c *memoryResponseCache) get(key string) (cachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		return cachedResponse{}, false
+	}
+	return e.resp, true
+}
+
+func (c *memoryResponseCache) set(key string, resp cachedResponse,
+	ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if c.max > 0 && len(c.entries) >= c.max {
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= c.max {
+			return
+		}
+	}
+	c.entries[key] = memoryCacheEntry{resp: resp, expires: now.Add(ttl)}
+}
+
+func (c *memoryResponseCache) size() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+func (c *memoryResponseCache) purge() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := len(c.entries)
+	c.entries = make(map[string]memoryCacheEntry)
+	return n
+}
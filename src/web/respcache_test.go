@@ -0,0 +1,152 @@
+package web
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCacheResponses(t *testing.T) {
+	prev := responseCache
+	defer func() { responseCache = prev }()
+	responseCache = newMemoryResponseCache(10)
+
+	calls := 0
+	h := cacheResponses(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		if r.URL.Query().Get("hosts") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		fmt.Fprintf(w, `{"calls": %d}`, calls)
+	}, "GetServerIDs", time.Minute)
+
+	serve := func(url string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("GET", url, nil)
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w
+	}
+	w := serve("/serverIDs?hosts=10.0.0.1:27960&Game=QuakeLive")
+	if w.Header().Get("X-Cache") != "MISS" || w.Body.String() != `{"calls": 1}` {
+		t.Fatalf("Expected first response to be a cache miss, got: %s %s",
+			w.Header().Get("X-Cache"), w.Body)
+	}
+	// parameter names and order are normalized
+	w = serve("/serverIDs?game=QuakeLive&hosts=10.0.0.1:27960")
+	if w.Header().Get("X-Cache") != "HIT" || w.Body.String() != `{"calls": 1}` ||
+		w.Header().Get("Content-Type") != "application/json; charset=UTF-8" {
+		t.Fatalf("Expected identical request to be served from the cache, got: %s %s",
+			w.Header().Get("X-Cache"), w.Body)
+	}
+	if serve("/serverIDs?hosts=10.0.0.2:27960").Body.String() != `{"calls": 2}` {
+		t.Fatalf("Expected different parameters not to be served from the cache")
+	}
+
+	// errors are not cached
+	serve("/serverIDs")
+	if w = serve("/serverIDs"); w.Code != http.StatusBadRequest || calls != 4 {
+		t.Fatalf("Expected error responses not to be cached, got %d calls", calls)
+	}
+	// nor are conditional requests served from the cache
+	r, _ := http.NewRequest("GET", "/serverIDs?hosts=10.0.0.1:27960&game=QuakeLive", nil)
+	r.Header.Set("If-None-Match", `W/"abc"`)
+	h(httptest.NewRecorder(), r)
+	if calls != 5 {
+		t.Fatalf("Expected conditional request to reach the handler")
+	}
+
+	if n := responseCache.purge(); n != 2 {
+		t.Fatalf("Expected 2 cached responses to be purged, got: %d", n)
+	}
+}
+
+// fakeRedis serves GET, SET, SCAN, and DEL from a map, ignoring expiry.
+func fakeRedis(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %s", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	var mu sync.Mutex
+	data := make(map[string]string)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				r := bufio.NewReader(c)
+				for {
+					reply, err := readRedisReply(r)
+					if err != nil {
+						return
+					}
+					var args []string
+					for _, a := range reply.([]interface{}) {
+						args = append(args, string(a.([]byte)))
+					}
+					mu.Lock()
+					switch strings.ToUpper(args[0]) {
+					case "GET":
+						if v, ok := data[args[1]]; ok {
+							fmt.Fprintf(c, "$%d\r\n%s\r\n", len(v), v)
+						} else {
+							fmt.Fprint(c, "$-1\r\n")
+						}
+					case "SET":
+						data[args[1]] = args[2]
+						fmt.Fprint(c, "+OK\r\n")
+					case "SCAN":
+						fmt.Fprintf(c, "*2\r\n$1\r\n0\r\n*%d\r\n", len(data))
+						for k := range data {
+							fmt.Fprintf(c, "$%d\r\n%s\r\n", len(k), k)
+						}
+					case "DEL":
+						for _, k := range args[1:] {
+							delete(data, k)
+						}
+						fmt.Fprintf(c, ":%d\r\n", len(args)-1)
+					default:
+						fmt.Fprint(c, "-ERR unknown command\r\n")
+					}
+					mu.Unlock()
+				}
+			}(c)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestRedisResponseCache(t *testing.T) {
+	c := newRedisResponseCache(fakeRedis(t))
+	if _, ok := c.get("GetServerIDs?hosts=a"); ok {
+		t.Fatalf("Expected no cached response")
+	}
+	c.set("GetServerIDs?hosts=a", cachedResponse{contentType: "application/json",
+		body: []byte("{\n}")}, time.Minute)
+	resp, ok := c.get("GetServerIDs?hosts=a")
+	if !ok || resp.contentType != "application/json" || string(resp.body) != "{\n}" {
+		t.Fatalf("Expected cached response, got: %+v", resp)
+	}
+	if n := c.purge(); n != 1 {
+		t.Fatalf("Expected 1 cached response to be purged, got: %d", n)
+	}
+	if _, ok := c.get("GetServerIDs?hosts=a"); ok {
+		t.Fatalf("Expected purged response not to be cached")
+	}
+
+	// an unreachable server is a cache miss
+	c = newRedisResponseCache("127.0.0.1:1")
+	if _, ok := c.get("GetServerIDs?hosts=a"); ok {
+		t.Fatalf("Expected cache miss without Redis")
+	}
+}
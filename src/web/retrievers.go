@@ -3,9 +3,10 @@ package web
 // retrievers.go - Bridge between http requests and database (and potentially other) layers
 
 import (
-	"encoding/json"
 	"net/http"
+	"time"
 
+	"github.com/syncore/a2sapi/src/config"
 	"github.com/syncore/a2sapi/src/db"
 	"github.com/syncore/a2sapi/src/logger"
 	"github.com/syncore/a2sapi/src/models"
@@ -17,26 +18,27 @@ func getServerIDRetriever(w http.ResponseWriter, hosts []string) {
 	go db.ServerDB.GetIDsAPIQuery(m, hosts)
 	ids := <-m
 	if len(ids.Servers) > 0 {
-		if err := json.NewEncoder(w).Encode(ids); err != nil {
+		if err := encodeJSON(w, ids); err != nil {
 			writeJSONEncodeError(w, err)
 			return
 		}
 	} else {
 		w.WriteHeader(http.StatusOK)
-		if err := json.NewEncoder(w).Encode(models.GetDefaultServerID()); err != nil {
+		if err := encodeJSON(w, models.GetDefaultServerID()); err != nil {
 			writeJSONEncodeError(w, err)
 			return
 		}
 	}
 }
 
-func queryServerIDRetriever(w http.ResponseWriter, ids []string) {
+func queryServerIDRetriever(w http.ResponseWriter, ids []string,
+	view serverListView) {
 	s := make(chan map[string]string, len(ids))
 	db.ServerDB.GetHostsAndGameFromIDAPIQuery(s, ids)
 	hostsgames := <-s
 	if len(hostsgames) == 0 {
 		w.WriteHeader(http.StatusOK)
-		if err := json.NewEncoder(w).Encode(models.GetDefaultServerList()); err != nil {
+		if err := encodeJSON(w, models.GetDefaultServerList()); err != nil {
 			writeJSONEncodeError(w, err)
 		}
 		return
@@ -44,29 +46,113 @@ func queryServerIDRetriever(w http.ResponseWriter, ids []string) {
 	serverlist, err := steam.Query(hostsgames)
 	if err != nil {
 		setNotFoundAndLog(w, err)
-		if err := json.NewEncoder(w).Encode(models.GetDefaultServerList()); err != nil {
+		if err := encodeJSON(w, models.GetDefaultServerList()); err != nil {
 			writeJSONEncodeError(w, err)
 			return
 		}
 		return
 	}
-	if err := json.NewEncoder(w).Encode(serverlist); err != nil {
-		writeJSONEncodeError(w, err)
-		logger.LogWebError(err)
-	}
+	writeServerListResponse(w, serverlist, view)
 }
 
-func queryServerAddrRetriever(w http.ResponseWriter, addresses []string) {
+func queryServerAddrRetriever(w http.ResponseWriter, addresses []string,
+	view serverListView) {
 	serverlist, err := steam.DirectQuery(addresses)
 	if err != nil {
 		setNotFoundAndLog(w, err)
-		if err := json.NewEncoder(w).Encode(models.GetDefaultServerList()); err != nil {
+		if err := encodeJSON(w, models.GetDefaultServerList()); err != nil {
 			writeJSONEncodeError(w, err)
 			return
 		}
 		return
 	}
-	if err := json.NewEncoder(w).Encode(serverlist); err != nil {
-		writeJSONEncodeError(w, err)
+	writeServerListResponse(w, serverlist, view)
+}
+
+// queryServerIPRetriever queries all of the known servers on the IP addresses,
+// listing the ones that did not respond as timed out.
+func queryServerIPRetriever(w http.ResponseWriter, ips []string, view serverListView) {
+	c := make(chan []models.DbServer, 1)
+	go db.ServerDB.GetServersForIPsAPIQuery(c, ips)
+	known := <-c
+	if len(known) > config.Get().WebConfig.MaximumHostsPerAPIQuery {
+		logger.WriteDebug("Maximum number of allowed API query hosts exceeded, truncating")
+		known = known[:config.Get().WebConfig.MaximumHostsPerAPIQuery]
+	}
+	if len(known) == 0 {
+		writeJSONResponse(w, models.GetDefaultServerList())
+		return
+	}
+	hostsgames := make(map[string]string, len(known))
+	for _, s := range known {
+		hostsgames[s.Host] = s.Game
+	}
+	serverlist, err := steam.Query(hostsgames)
+	if err != nil {
+		setNotFoundAndLog(w, err)
+		writeJSONResponse(w, models.GetDefaultServerList())
+		return
+	}
+	responded := make(map[string]bool, len(serverlist.Servers))
+	for _, s := range serverlist.Servers {
+		responded[s.Host] = true
+	}
+	for _, s := range known {
+		if responded[s.Host] {
+			continue
+		}
+		serverlist.Servers = append(serverlist.Servers, models.APIOfflineServer{
+			Status: models.ServerStatusTimedOut,
+			ID:     s.ID,
+			Host:   s.Host,
+			Game:   s.Game,
+		}.Server())
+	}
+	serverlist.ServerCount = len(serverlist.Servers)
+	writeServerListResponse(w, serverlist, view)
+}
+
+// refreshStaleServers re-queries the servers in the list if their cached data is
+// stale and the list is small enough, waiting at most the configured budget.
+func refreshStaleServers(sl *models.APIServerList) *models.APIServerList {
+	cfg := config.Get().WebConfig
+	if !cfg.RefreshStaleServers || len(sl.Servers) == 0 ||
+		len(sl.Servers) > cfg.MaxStaleRefreshServers {
+		return sl
+	}
+	refreshed := *sl
+	refreshed.Servers = steam.RefreshStaleServers(sl.Servers,
+		time.Unix(sl.RetrievedTimeStamp, 0),
+		time.Duration(cfg.StaleServerAge)*time.Second,
+		time.Duration(cfg.StaleRefreshBudget)*time.Millisecond)
+	return &refreshed
+}
+
+// markStaleServers returns the list with the status of its online servers set to
+// stale if their data is older than the configured stale server age.
+func markStaleServers(sl *models.APIServerList) *models.APIServerList {
+	age := time.Duration(config.Get().WebConfig.StaleServerAge) * time.Second
+	cutoff := time.Now().Add(-age).Unix()
+	var marked *models.APIServerList
+	for i, s := range sl.Servers {
+		updated := sl.RetrievedTimeStamp
+		if s.RefreshedTimeStamp != 0 {
+			updated = s.RefreshedTimeStamp
+		}
+		if s.Status != models.ServerStatusOnline || updated >= cutoff {
+			continue
+		}
+		if marked == nil {
+			// the servers may be shared with the cached list
+			l := *sl
+			l.Servers = make([]models.APIServer, len(sl.Servers))
+			copy(l.Servers, sl.Servers)
+			marked = &l
+		}
+		marked.Servers[i].Status = models.ServerStatusStale
+	}
+	if marked == nil {
+		return sl
 	}
+	return marked
 }
@@ -3,6 +3,7 @@ package web
 // router.go - request router
 
 import (
+	"net"
 	"net/http"
 	"strings"
 	"time"
@@ -16,21 +17,57 @@ import (
 
 func newRouter() *mux.Router {
 	r := mux.NewRouter().StrictSlash(true)
+	trusted := parseTrustedNetworks(config.Get().AdminConfig.TrustedIPs)
+	loadTrustedProxies()
+	loadDirectQueryNetworks()
+	setAPIRateLimit(config.Get().WebConfig.RateLimitPerSecond,
+		config.Get().WebConfig.RateLimitBurst)
+	setAPIConcurrencyLimits(config.Get().WebConfig.MaxConcurrentRequests,
+		config.Get().WebConfig.RouteConcurrencyLimits)
 	for _, ar := range apiRoutes {
-		handler := http.TimeoutHandler(compressGzip(ar.handlerFunc, config.Config.WebConfig.CompressResponses),
-			time.Duration(config.Config.WebConfig.APIWebTimeout)*time.Second,
-			`{"error": {"code": 503,"message": "Request timeout."}}`)
-		handler = logger.LogWebRequest(handler, ar.name)
-
 		r.Methods(ar.method).
 			MatcherFunc(pathQStrToLowerMatcherFunc(r, ar.path, ar.queryStrings,
 				getRequiredQryStringCount(ar.queryStrings))).
 			Name(ar.name).
-			Handler(handler)
+			Handler(wrapRoute(ar, trusted))
 	}
 	return r
 }
 
+// wrapRoute wraps a route's handler with the response timeout, compression, and
+// cache (unless it is a stream), and with the concurrency limits, quotas, API
+// key scope, rate limit, and logging that apply to it.
+func wrapRoute(ar route, trusted []*net.IPNet) http.Handler {
+	var inner http.Handler = ar.handlerFunc
+	if !ar.stream {
+		hf := cacheResponses(ar.handlerFunc, ar.name, time.Duration(
+			config.Get().WebConfig.ResponseCacheTTLs[ar.name])*time.Second)
+		inner = http.TimeoutHandler(compressGzip(hf, config.Get().WebConfig.CompressResponses),
+			time.Duration(config.Get().WebConfig.APIWebTimeout)*time.Second,
+			`{"error": {"code": 503,"message": "Request timeout."}}`)
+	}
+	handler := limitConcurrencyWith(inner, routeConcurrencyLimiter(ar.name),
+		ar.name)
+	if !ar.stream {
+		handler = limitConcurrencyWith(handler, getGlobalConcurrencyLimiter,
+			ar.name)
+	}
+	if ar.scope == scopeQueryDirect {
+		handler = limitQuota(handler)
+	}
+	handler = requireScope(handler, ar.scope)
+	handler = limitRateWith(handler, getAPIRateLimiter, ar.name)
+	handler = logger.LogWebRequest(handler, ar.name)
+	if trusted != nil {
+		handler = exemptTrusted(handler, logger.LogWebRequest(
+			requireScope(inner, ar.scope), ar.name+" (trusted)"), trusted)
+	}
+	if !ar.stream {
+		handler = observeRequests(handler, ar.name)
+	}
+	return handler
+}
+
 // Provide case-insensitive matching for URL paths and query strings
 func pathQStrToLowerMatcherFunc(router *mux.Router,
 	routepath string, querystrings []querystring,
@@ -38,11 +75,16 @@ func pathQStrToLowerMatcherFunc(router *mux.Router,
 	rt *mux.RouteMatch) bool {
 	return func(req *http.Request, rt *mux.RouteMatch) bool {
 		pathok, qstrok := false, false
-		// case-insensitive paths
-		if strings.HasPrefix(strings.ToLower(req.URL.Path), strings.ToLower(routepath)) {
-			logger.WriteDebug("PATH: %s matches route path: %s", req.URL.Path, routepath)
+		// case-insensitive paths; paths with {placeholders} must match exactly
+		if strings.Contains(routepath, "{") {
+			pathok = matchPathPattern(routepath, req.URL.Path)
+		} else if strings.HasPrefix(strings.ToLower(req.URL.Path),
+			strings.ToLower(routepath)) {
 			pathok = true
 		}
+		if pathok {
+			logger.WriteDebug("PATH: %s matches route path: %s", req.URL.Path, routepath)
+		}
 		//case-insensitive query strings
 		// not all API routes will make use of query strings
 		if len(querystrings) == 0 {
@@ -68,6 +110,38 @@ func pathQStrToLowerMatcherFunc(router *mux.Router,
 	}
 }
 
+// matchPathPattern returns true if the path matches the pattern, in which each
+// {placeholder} segment matches any non-empty segment. Other segments are
+// case-insensitive.
+func matchPathPattern(pattern, path string) bool {
+	ps := strings.Split(strings.Trim(pattern, "/"), "/")
+	s := strings.Split(strings.Trim(path, "/"), "/")
+	if len(ps) != len(s) {
+		return false
+	}
+	for i := range ps {
+		if strings.HasPrefix(ps[i], "{") {
+			if s[i] == "" {
+				return false
+			}
+			continue
+		}
+		if !strings.EqualFold(ps[i], s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// pathSegment returns the nth (from 0) segment of a path, or an empty string.
+func pathSegment(path string, n int) string {
+	s := strings.Split(strings.Trim(path, "/"), "/")
+	if n >= len(s) {
+		return ""
+	}
+	return s[n]
+}
+
 func getRequiredQryStringCount(querystrings []querystring) int {
 	reqcount := 0
 	for _, q := range querystrings {
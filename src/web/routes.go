@@ -9,24 +9,135 @@ type route struct {
 	method       string
 	path         string
 	queryStrings []querystring
+	scope        string
 	handlerFunc  http.HandlerFunc
+	// long-lived connections (i.e. WebSockets) that are not subject to the
+	// response timeout, compression, or global concurrent request limit
+	stream bool
 }
 
 var apiRoutes = []route{
+	// servers - counts only (must precede /servers, which matches as a prefix)
+	route{
+		name:         "GetServerCounts",
+		method:       "GET",
+		path:         "/servers/count",
+		queryStrings: getServersQueryStrings,
+		scope:        scopeReadList,
+		handlerFunc:  getServerCounts,
+	},
+	// servers - live updates over a WebSocket (must precede /servers)
+	route{
+		name:         "GetServersLive",
+		method:       "GET",
+		path:         "/servers/live",
+		queryStrings: liveQueryStrings,
+		scope:        scopeReadList,
+		handlerFunc:  getServersLive,
+		stream:       true,
+	},
+	// servers - virtual servers made up of several hosts (must precede /servers)
+	route{
+		name:         "GetVirtualServers",
+		method:       "GET",
+		path:         "/servers/virtual",
+		queryStrings: virtualServersQueryStrings,
+		scope:        scopeReadList,
+		handlerFunc:  getVirtualServers,
+	},
+	// servers - a server's player count history (must precede /servers)
+	route{
+		name:         "GetServerHistory",
+		method:       "GET",
+		path:         "/servers/{id}/history",
+		queryStrings: serverHistoryQueryStrings,
+		scope:        scopeReadList,
+		handlerFunc:  getServerHistory,
+	},
+	// servers - re-query a server on demand (must precede /servers)
+	route{
+		name:        "RefreshServer",
+		method:      "POST",
+		path:        "/servers/{id}/refresh",
+		scope:       scopeQueryDirect,
+		handlerFunc: refreshServerByID,
+	},
+	// servers - weighted random pick (must precede /servers)
+	route{
+		name:         "GetRandomServers",
+		method:       "GET",
+		path:         "/servers/random",
+		queryStrings: getServersQueryStrings,
+		scope:        scopeReadList,
+		handlerFunc:  getRandomServers,
+	},
 	// servers
 	route{
 		name:         "GetServers",
 		method:       "GET",
 		path:         "/servers",
 		queryStrings: getServersQueryStrings,
+		scope:        scopeReadList,
 		handlerFunc:  getServers,
 	},
+	// qstat-compatible output of the servers list
+	route{
+		name:         "GetQStatXML",
+		method:       "GET",
+		path:         "/qstat/xml",
+		queryStrings: getServersQueryStrings,
+		scope:        scopeReadList,
+		handlerFunc:  getQStatXML,
+	},
+	route{
+		name:         "GetQStatRaw",
+		method:       "GET",
+		path:         "/qstat/raw",
+		queryStrings: getServersQueryStrings,
+		scope:        scopeReadList,
+		handlerFunc:  getQStatRaw,
+	},
+	// snapshots - the server list at a point in time
+	route{
+		name:         "GetSnapshot",
+		method:       "GET",
+		path:         "/snapshots",
+		queryStrings: snapshotQueryStrings,
+		scope:        scopeReadList,
+		handlerFunc:  getCycleSnapshot,
+	},
+	// games - the known games, with their details from Steam
+	route{
+		name:        "GetGames",
+		method:      "GET",
+		path:        "/games",
+		scope:       scopeReadList,
+		handlerFunc: getGames,
+	},
+	// Atom feed of server events
+	route{
+		name:         "GetEventFeed",
+		method:       "GET",
+		path:         "/feeds/events.atom",
+		queryStrings: eventFeedQueryStrings,
+		scope:        scopeReadList,
+		handlerFunc:  getEventFeed,
+	},
+	// Prometheus metrics
+	route{
+		name:        "GetMetrics",
+		method:      "GET",
+		path:        "/metrics",
+		scope:       scopeAdminDebug,
+		handlerFunc: getMetrics,
+	},
 	// serverID
 	route{
 		name:         "GetServerIDs",
 		method:       "GET",
 		path:         "/serverIDs",
 		queryStrings: getServerIDsQueryStrings,
+		scope:        scopeReadList,
 		handlerFunc:  getServerIDs,
 	},
 	// query - by ID
@@ -35,6 +146,7 @@ var apiRoutes = []route{
 		method:       "GET",
 		path:         "/query",
 		queryStrings: queryServerIDQueryStrings,
+		scope:        scopeQueryDirect,
 		handlerFunc:  queryServerIDs,
 	},
 	// query - by address
@@ -43,6 +155,57 @@ var apiRoutes = []route{
 		method:       "GET",
 		path:         "/query",
 		queryStrings: queryServerAddrQueryStrings,
+		scope:        scopeQueryDirect,
 		handlerFunc:  queryServerAddrs,
 	},
+	// query - all known servers on an IP
+	route{
+		name:         "QueryServerIP",
+		method:       "GET",
+		path:         "/query",
+		queryStrings: queryServerIPQueryStrings,
+		scope:        scopeQueryDirect,
+		handlerFunc:  queryServerIPs,
+	},
+	// diagnose - probe a server to explain why it isn't answering or listed
+	route{
+		name:         "DiagnoseServer",
+		method:       "GET",
+		path:         "/diagnose",
+		queryStrings: diagnoseQueryStrings,
+		scope:        scopeQueryDirect,
+		handlerFunc:  diagnoseServer,
+	},
+	// ingest - state pushed by game servers (authenticated by their own tokens)
+	route{
+		name:        "IngestServer",
+		method:      "POST",
+		path:        "/ingest",
+		handlerFunc: ingestServer,
+	},
+	// claims
+	route{
+		name:         "StartClaim",
+		method:       "POST",
+		path:         "/claims",
+		queryStrings: claimQueryStrings,
+		scope:        scopeWriteClaims,
+		handlerFunc:  startClaim,
+	},
+	route{
+		name:         "GetClaim",
+		method:       "GET",
+		path:         "/claims",
+		queryStrings: claimQueryStrings,
+		scope:        scopeReadList,
+		handlerFunc:  getClaim,
+	},
+	route{
+		name:         "UpdateClaim",
+		method:       "PUT",
+		path:         "/claims",
+		queryStrings: claimQueryStrings,
+		scope:        scopeWriteClaims,
+		handlerFunc:  updateClaim,
+	},
 }
@@ -0,0 +1,91 @@
+package web
+
+// search.go - Normalization of text (server names, maps, and keywords) so that
+// text filters match regardless of case, diacritics, and optionally Quake color
+// codes; i.e: "uber" matches "Über" and "^1PRO^7" matches "^3pro"
+
+import (
+	"strings"
+	"unicode"
+)
+
+// diacriticFolds are the base letters of the lower case Latin letters with
+// diacritics (and ligatures) that are commonly used in server names.
+var diacriticFolds = map[string]string{
+	"àáâãäåāăą":  "a",
+	"çćĉċč":      "c",
+	"ďđð":        "d",
+	"èéêëēĕėęě":  "e",
+	"ĝğġģ":       "g",
+	"ĥħ":         "h",
+	"ìíîïĩīĭįı":  "i",
+	"ĵ":          "j",
+	"ķ":          "k",
+	"ĺļľŀł":      "l",
+	"ñńņňŉ":      "n",
+	"òóôõöøōŏő":  "o",
+	"ŕŗř":        "r",
+	"śŝşšș":      "s",
+	"ţťŧț":       "t",
+	"ùúûüũūŭůűų": "u",
+	"ŵ":          "w",
+	"ýÿŷ":        "y",
+	"źżž":        "z",
+	"æ":          "ae",
+	"œ":          "oe",
+	"ß":          "ss",
+	"þ":          "th",
+}
+
+var diacriticFoldRunes = buildDiacriticFoldRunes()
+
+func buildDiacriticFoldRunes() map[rune]string {
+	m := make(map[rune]string)
+	for letters, base := range diacriticFolds {
+		for _, r := range letters {
+			m[r] = base
+		}
+	}
+	return m
+}
+
+// stripColorCodes removes Quake color codes (a caret followed by a digit) from
+// a string.
+func stripColorCodes(s string) string {
+	if !strings.Contains(s, "^") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '^' && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '9' {
+			i++
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// normalizeSearch returns the form of a string that text filters are matched
+// on: case folded, without diacritics or combining marks, and without color
+// codes if ignoreColors is set.
+func normalizeSearch(s string, ignoreColors bool) string {
+	if ignoreColors {
+		s = stripColorCodes(s)
+	}
+	s = strings.ToLower(s)
+	var b strings.Builder
+	b.Grow(len(s))
+	for _, r := range s {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if base, ok := diacriticFoldRunes[r]; ok {
+			b.WriteString(base)
+			continue
+		}
+		// case fold letters with several lower case forms (i.e: the final sigma)
+		b.WriteRune(unicode.ToLower(unicode.ToUpper(r)))
+	}
+	return b.String()
+}
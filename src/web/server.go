@@ -3,38 +3,117 @@ package web
 // server.go - Web server for API
 
 import (
+	"context"
 	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/syncore/a2sapi/src/config"
 	"github.com/syncore/a2sapi/src/logger"
 )
 
+// shutdownTimeout is how long the requests in progress are given to finish when
+// the web server is shut down.
+const shutdownTimeout = 10 * time.Second
+
 // Start listening for and responding to HTTP requests via the web server. Panics
-// if unable to start.
-func Start(runSilent bool) {
+// if unable to start. When ctx is cancelled, the server stops accepting requests
+// and Start returns once the requests in progress have finished.
+func Start(ctx context.Context, runSilent bool) {
 	r := newRouter()
 
 	if !runSilent {
 		printStartInfo()
 	}
-
-	logger.LogAppInfo("Starting HTTP server on port %d",
-		config.Config.WebConfig.APIWebPort)
+	var listeners sync.WaitGroup
+	defer listeners.Wait()
+	if config.Get().AdminConfig.EnableAdminListener {
+		listeners.Add(1)
+		go func() {
+			defer listeners.Done()
+			startAdmin(ctx, runSilent)
+		}()
+	}
+	if config.Get().WebConfig.GRPCPort > 0 {
+		listeners.Add(1)
+		go func() {
+			defer listeners.Done()
+			startGRPC(ctx, r, runSilent)
+		}()
+	}
 
 	srv := http.Server{
-		Addr:           fmt.Sprintf(":%d", config.Config.WebConfig.APIWebPort),
+		Addr: net.JoinHostPort(config.Get().WebConfig.APIWebListenAddress,
+			strconv.Itoa(config.Get().WebConfig.APIWebPort)),
 		Handler:        r,
 		ReadTimeout:    30 * time.Second,
 		WriteTimeout:   30 * time.Second,
 		MaxHeaderBytes: 1 << 20}
+	stopped := shutdownOnDone(ctx, &srv, "HTTP server")
 
-	err := srv.ListenAndServe()
-	if err != nil {
+	var err error
+	if config.Get().WebConfig.APIWebUnixSocket != "" {
+		logger.LogAppInfo("Starting HTTP server on unix socket %s",
+			config.Get().WebConfig.APIWebUnixSocket)
+		err = listenAndServeUnix(&srv, config.Get().WebConfig.APIWebUnixSocket,
+			config.Get().WebConfig.UnixSocketFileMode())
+	} else {
+		logger.LogAppInfo("Starting HTTP server on port %d",
+			config.Get().WebConfig.APIWebPort)
+		err = srv.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
 		logger.LogAppError(err)
 		panic(fmt.Sprintf("Unable to start HTTP server, error: %s\n", err))
 	}
+	<-stopped
+}
+
+// shutdownOnDone gracefully shuts down the server when ctx is cancelled, closing
+// any connections that are still active after shutdownTimeout. The returned
+// channel is closed once the server has been shut down.
+func shutdownOnDone(ctx context.Context, srv *http.Server, name string) <-chan struct{} {
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		logger.LogAppInfo("Shutting down %s", name)
+		sctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(sctx); err != nil {
+			logger.LogAppErrorf("Error shutting down %s: %s", name, err)
+			srv.Close()
+		}
+	}()
+	return stopped
+}
+
+// listenAndServeUnix listens on the unix domain socket at sockpath, which is
+// given the specified file permissions, and then serves HTTP requests on it. A
+// stale socket file left behind by a previous run is removed before listening.
+func listenAndServeUnix(srv *http.Server, sockpath string, mode os.FileMode) error {
+	if fi, err := os.Stat(sockpath); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return fmt.Errorf("Unable to use %s as unix socket: file exists", sockpath)
+		}
+		if err := os.Remove(sockpath); err != nil {
+			return fmt.Errorf("Unable to remove stale unix socket %s: %s", sockpath, err)
+		}
+	}
+	l, err := net.Listen("unix", sockpath)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	if err := os.Chmod(sockpath, mode); err != nil {
+		return fmt.Errorf("Unable to set permissions on unix socket %s: %s",
+			sockpath, err)
+	}
+	return srv.Serve(l)
 }
 
 func printStartInfo() {
@@ -42,17 +121,28 @@ func printStartInfo() {
 	for _, e := range apiRoutes {
 		endpoints = append(endpoints, fmt.Sprintf("%s  ", e.path))
 	}
-	fmt.Printf("Starting HTTP server on port %d\n", config.Config.WebConfig.APIWebPort)
+	if config.Get().WebConfig.APIWebUnixSocket != "" {
+		fmt.Printf("Starting HTTP server on unix socket %s (permissions: %s)\n",
+			config.Get().WebConfig.APIWebUnixSocket,
+			config.Get().WebConfig.UnixSocketFileMode())
+	} else if config.Get().WebConfig.APIWebListenAddress != "" {
+		fmt.Printf("Starting HTTP server on %s port %d\n",
+			config.Get().WebConfig.APIWebListenAddress,
+			config.Get().WebConfig.APIWebPort)
+	} else {
+		fmt.Printf("Starting HTTP server on port %d\n",
+			config.Get().WebConfig.APIWebPort)
+	}
 	fmt.Printf("Available endpoints: %s\n", endpoints)
 
-	if config.Config.WebConfig.AllowDirectUserQueries {
+	if config.Get().WebConfig.AllowDirectUserQueries {
 		fmt.Println("Direct (non-ID based) server API queries: enabled")
 	} else {
 		fmt.Println("Direct (non-ID based) server API queries: disabled")
 	}
 
 	fmt.Printf("HTTP request timeout: %d seconds\n",
-		config.Config.WebConfig.APIWebTimeout)
+		config.Get().WebConfig.APIWebTimeout)
 	fmt.Printf("Maximum servers allowed per user API call: %d servers\n",
-		config.Config.WebConfig.MaximumHostsPerAPIQuery)
+		config.Get().WebConfig.MaximumHostsPerAPIQuery)
 }
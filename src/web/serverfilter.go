@@ -4,6 +4,8 @@ package web
 // string data.
 
 import (
+	"math"
+	"strconv"
 	"strings"
 
 	"github.com/syncore/a2sapi/src/models"
@@ -13,25 +15,55 @@ import (
 //  builds a filter that will be used on the server list.
 func getSrvFilterFromQString(m map[string][]string, qs []querystring) []slQueryFilter {
 	var qfilters []slQueryFilter
+	ignoreColors := false
 	for key := range m {
 		for _, q := range qs {
 			if strings.EqualFold(key, q.name) {
 				vals := getQStringValues(m, key)
-				if len(vals) > 0 {
-					qfilters = append(qfilters, slQueryFilter{name: q.name,
-						needsbool: q.boolonly, values: vals})
+				if len(vals) == 0 {
+					continue
 				}
+				// not a filter itself, but an option of the text filters
+				if q.name == qsGetServersIgnoreColors {
+					ignoreColors = strings.EqualFold(vals[0], "true")
+					continue
+				}
+				qfilters = append(qfilters, slQueryFilter{name: q.name,
+					needsbool: q.boolonly, needsint: q.intonly, values: vals})
 			}
 		}
 	}
+	for i := range qfilters {
+		qfilters[i].ignoreColors = ignoreColors
+	}
 	return qfilters
 }
 
+// checkSrvFilterValues returns an error if a numeric filter of the query string
+// does not have a valid number.
+func checkSrvFilterValues(m map[string][]string, qs []querystring) error {
+	for _, q := range qs {
+		if !q.intonly {
+			continue
+		}
+		if _, err := getQStringInt(m, q.name, 0, 0, math.MaxInt16); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func findMatches(sqf slQueryFilter,
 	servers []models.APIServer) []models.APIServer {
 	var matched []models.APIServer
 	var ssearch string
+	var isearch int
 	bsearcht, bsearchf, useContains := false, false, false
+	// numeric filters are checked beforehand by checkSrvFilterValues
+	imin := 0
+	if sqf.needsint {
+		imin, _ = strconv.Atoi(sqf.values[0])
+	}
 
 	for _, srv := range servers {
 		switch sqf.name {
@@ -42,6 +74,8 @@ func findMatches(sqf slQueryFilter,
 			ssearch = srv.CountryInfo.CountryCode
 		case qsGetServersState:
 			ssearch = srv.CountryInfo.State
+		case qsGetServersIP:
+			ssearch = srv.IP
 		// info-based
 		case qsGetServersName:
 			useContains = true
@@ -49,19 +83,21 @@ func findMatches(sqf slQueryFilter,
 		case qsGetServersMap:
 			useContains = true
 			ssearch = srv.Info.Map
+		case qsGetServersMapExact:
+			ssearch = srv.Info.Map
 		case qsGetServersGame:
 			ssearch = srv.Info.Game
 		case qsGetServersGameType:
 			ssearch = srv.Info.GameTypeShort
-		case qsGetServersType:
+		case qsGetServersType, qsGetServersTypeShort:
 			ssearch = srv.Info.ServerType
-		case qsGetServersOS:
+		case qsGetServersOS, qsGetServersOSShort:
 			ssearch = srv.Info.Environment
 		case qsGetServersVersion:
 			ssearch = srv.Info.Version
 		case qsGetServersKeywords:
 			useContains = true
-			ssearch = srv.Info.ExtraData.Keywords
+			ssearch = srv.Info.ExtraData.Keywords + "," + strings.Join(srv.Tags, ",")
 		case qsGetServersIsNotFull:
 			if strings.EqualFold(sqf.values[0], "true") {
 				bsearcht = srv.Info.Players != srv.Info.MaxPlayers
@@ -80,13 +116,36 @@ func findMatches(sqf slQueryFilter,
 			} else {
 				bsearchf = srv.Info.Bots == 0
 			}
+		case qsGetServersNoBots:
+			if strings.EqualFold(sqf.values[0], "true") {
+				bsearcht = srv.Info.Bots == 0
+			} else {
+				bsearchf = srv.Info.Bots > 0
+			}
+		case qsGetServersMinPlayers:
+			isearch = int(srv.Info.Players)
 		case qsGetServersHasPassword:
 			if strings.EqualFold(sqf.values[0], "true") {
 				bsearcht = srv.Info.Visibility == 1
 			} else {
 				bsearchf = srv.Info.Visibility == 0
 			}
-		case qsGetServersHasAntiCheat:
+		case qsGetServersMinFreeSlots:
+			isearch = srv.FreeSlots
+		case qsGetServersPasswordProtected:
+			if strings.EqualFold(sqf.values[0], "true") {
+				bsearcht = srv.PasswordProtected
+			} else {
+				bsearchf = !srv.PasswordProtected
+			}
+		case qsGetServersHasSpectators:
+			// servers whose spectators are unknown match neither value
+			if strings.EqualFold(sqf.values[0], "true") {
+				bsearcht = srv.Spectators != nil && *srv.Spectators > 0
+			} else {
+				bsearchf = srv.Spectators != nil && *srv.Spectators == 0
+			}
+		case qsGetServersHasAntiCheat, qsGetServersVAC:
 			if strings.EqualFold(sqf.values[0], "true") {
 				bsearcht = srv.Info.VAC == 1
 			} else {
@@ -99,11 +158,15 @@ func findMatches(sqf slQueryFilter,
 			} else if strings.EqualFold(sqf.values[0], "false") && bsearchf {
 				matched = append(matched, srv)
 			}
+		} else if sqf.needsint {
+			if isearch >= imin {
+				matched = append(matched, srv)
+			}
 		} else {
 			for _, val := range sqf.values {
 				if useContains {
-					val, ssearch = strings.ToLower(val), strings.ToLower(ssearch)
-					if strings.Contains(ssearch, val) {
+					if strings.Contains(normalizeSearch(ssearch, sqf.ignoreColors),
+						normalizeSearch(val, sqf.ignoreColors)) {
 						matched = append(matched, srv)
 					}
 				} else {
@@ -135,6 +198,10 @@ func filterServers(sqf []slQueryFilter,
 		filtered = make([]models.APIServer, 0)
 	}
 	return &models.APIServerList{
+		CycleID:            a.CycleID,
+		CycleIDs:           a.CycleIDs,
+		WarmUp:             a.WarmUp,
+		PartialMasterList:  a.PartialMasterList,
 		RetrievedAt:        a.RetrievedAt,
 		RetrievedTimeStamp: a.RetrievedTimeStamp,
 		Servers:            filtered,
@@ -4,6 +4,7 @@ package web
 
 import (
 	"encoding/json"
+	"net/url"
 	"strings"
 	"testing"
 
@@ -78,6 +79,11 @@ func TestFindMatches(t *testing.T) {
 		needsbool: false,
 		values:    []string{"TX", "NY"},
 	}
+	ipFilter := slQueryFilter{
+		name:      qsGetServersIP,
+		needsbool: false,
+		values:    []string{"45.55.168.160", "54.172.5.6"},
+	}
 	src := &models.APIServerList{}
 	err := json.Unmarshal(constants.TestServerDumpJSON, src)
 	if err != nil {
@@ -110,6 +116,12 @@ func TestFindMatches(t *testing.T) {
 	if len(matches) != expected {
 		t.Fatalf("Expected %d match(es), got: %d", expected, len(matches))
 	}
+	// ?ip=45.55.168.160,54.172.5.6 (exact matches only)
+	matches = findMatches(ipFilter, src.Servers)
+	expected = 1
+	if len(matches) != expected {
+		t.Fatalf("Expected %d match(es), got: %d", expected, len(matches))
+	}
 }
 
 func TestFilterServers(t *testing.T) {
@@ -149,3 +161,107 @@ func TestFilterServers(t *testing.T) {
 		}
 	}
 }
+
+func TestFindMatchesNormalized(t *testing.T) {
+	servers := []models.APIServer{
+		models.APIServer{Host: "10.0.0.1:27960",
+			Info: models.SteamServerInfo{Name: "Über Duel", Map: "campgrounds"}},
+		models.APIServer{Host: "10.0.0.2:27960",
+			Info: models.SteamServerInfo{Name: "^3pro^7 ca", Map: "bloodrun"}},
+	}
+	nameFilter := slQueryFilter{name: qsGetServersName, values: []string{"uber"}}
+	matches := findMatches(nameFilter, servers)
+	if len(matches) != 1 || matches[0].Host != "10.0.0.1:27960" {
+		t.Fatalf("Expected diacritics to be ignored, got: %v", matches)
+	}
+	nameFilter.values = []string{"ÜBER"}
+	if matches = findMatches(nameFilter, servers); len(matches) != 1 {
+		t.Fatalf("Expected case to be ignored, got: %v", matches)
+	}
+
+	nameFilter.values = []string{"^1PRO^7"}
+	if matches = findMatches(nameFilter, servers); len(matches) != 0 {
+		t.Fatalf("Expected color codes to be matched, got: %v", matches)
+	}
+	nameFilter.ignoreColors = true
+	matches = findMatches(nameFilter, servers)
+	if len(matches) != 1 || matches[0].Host != "10.0.0.2:27960" {
+		t.Fatalf("Expected color codes to be ignored, got: %v", matches)
+	}
+
+	// the option applies to all of the request's filters
+	query := map[string][]string{"serverNames": []string{"pro"},
+		"maps": []string{"blood"}, "ignoreColors": []string{"true"}}
+	sqf := getSrvFilterFromQString(query, getServersQueryStrings)
+	if len(sqf) != 2 || !sqf[0].ignoreColors || !sqf[1].ignoreColors {
+		t.Fatalf("Expected 2 filters that ignore colors, got: %+v", sqf)
+	}
+}
+
+func TestFindMatchesA2SAttributes(t *testing.T) {
+	none, two := 0, 2
+	servers := []models.APIServer{
+		{Host: "a", Info: models.SteamServerInfo{Map: "de_dust2", Players: 5, Bots: 2,
+			ServerType: "dedicated", Environment: "Linux", VAC: 1}, FreeSlots: 7,
+			Spectators: &two},
+		{Host: "b", Info: models.SteamServerInfo{Map: "de_dust2_night", Players: 2,
+			ServerType: "listen", Environment: "Windows"}, FreeSlots: 2,
+			PasswordProtected: true},
+		{Host: "c", Info: models.SteamServerInfo{Map: "DE_DUST2", Players: 0,
+			ServerType: "dedicated", Environment: "Linux", VAC: 1}, FreeSlots: 10,
+			Spectators: &none},
+	}
+	hosts := func(matched []models.APIServer) string {
+		s := ""
+		for _, m := range matched {
+			s += m.Host
+		}
+		return s
+	}
+	tests := []struct {
+		query    url.Values
+		expected string
+	}{
+		// exact, case-insensitive map names, unlike maps
+		{url.Values{"map": {"de_dust2"}}, "ac"},
+		{url.Values{"maps": {"de_dust2"}}, "abc"},
+		{url.Values{"minPlayers": {"2"}}, "ab"},
+		{url.Values{"noBots": {"true"}}, "bc"},
+		{url.Values{"noBots": {"false"}}, "a"},
+		{url.Values{"Map": {"de_dust2"}, "minPlayers": {"1"}, "noBots": {"true"}}, ""},
+		{url.Values{"map": {"de_dust2,de_dust2_night"}, "minPlayers": {"2"}}, "ab"},
+		// short forms of serverOS, serverTypes, and hasAntiCheat
+		{url.Values{"os": {"linux"}}, "ac"},
+		{url.Values{"os": {"windows,mac"}}, "b"},
+		{url.Values{"type": {"dedicated"}}, "ac"},
+		{url.Values{"vac": {"true"}}, "ac"},
+		{url.Values{"vac": {"false"}}, "b"},
+		{url.Values{"os": {"linux"}, "vac": {"true"}, "type": {"listen"}}, ""},
+		{url.Values{"serverOS": {"Windows"}, "type": {"listen"}}, "b"},
+		// derived fields; servers with unknown spectators match neither value
+		{url.Values{"minFreeSlots": {"7"}}, "ac"},
+		{url.Values{"passwordProtected": {"true"}}, "b"},
+		{url.Values{"passwordProtected": {"false"}}, "ac"},
+		{url.Values{"hasSpectators": {"true"}}, "a"},
+		{url.Values{"hasSpectators": {"false"}}, "c"},
+	}
+	for _, tt := range tests {
+		if err := checkSrvFilterValues(tt.query, getServersQueryStrings); err != nil {
+			t.Fatalf("Unexpected error for %v: %s", tt.query, err)
+		}
+		matched := servers
+		for _, f := range getSrvFilterFromQString(tt.query, getServersQueryStrings) {
+			matched = findMatches(f, matched)
+		}
+		if got := hosts(matched); got != tt.expected {
+			t.Fatalf("Expected %v to match servers '%s', got: '%s'", tt.query,
+				tt.expected, got)
+		}
+	}
+	for _, invalid := range []string{"two", "-1", "100000"} {
+		if err := checkSrvFilterValues(url.Values{"minPlayers": {invalid}},
+			getServersQueryStrings); err == nil {
+			t.Fatalf("Expected minPlayers=%s to be rejected", invalid)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package web
+
+// snapshots.go - The server list as it was at a point in time, from the server
+// lists of past retrieval cycles stored in the app DB.
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/db"
+	"github.com/syncore/a2sapi/src/models"
+)
+
+// parseSnapshotTime parses a point in time: a Unix timestamp (i.e. 1451703845)
+// or an RFC 3339 date and time (i.e. 2016-01-02T03:04:05Z).
+func parseSnapshotTime(val string) (int64, error) {
+	if at, err := strconv.ParseInt(val, 10, 64); err == nil && at > 0 {
+		return at, nil
+	}
+	if t, err := time.Parse(time.RFC3339, val); err == nil {
+		return t.Unix(), nil
+	}
+	return 0, fmt.Errorf(
+		"The %s parameter must be a Unix timestamp or an RFC 3339 date and time.",
+		qsSnapshotAt)
+}
+
+func getCycleSnapshot(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if !config.Get().OutputConfig.EnableCycleSnapshots || db.AppDB == nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w,
+			`{"error": {"code": 404,"message": "Server list snapshots are disabled."}}`)
+		return
+	}
+	val, _ := getQStringValue(r.URL.Query(), qsSnapshotAt)
+	at, err := parseSnapshotTime(val)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"error": {"code": 400,"message": "%s"}}`, err)
+		return
+	}
+	game, _ := getQStringValue(r.URL.Query(), qsServersGame)
+	lists, err := db.AppDB.GetCycleSnapshots(game, at)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w,
+			`{"error": {"code": 500,"message": "Unable to retrieve the snapshot."}}`)
+		return
+	}
+	sl := models.CombineGameLists(lists)
+	if sl == nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w,
+			`{"error": {"code": 404,"message": "No server list is stored for that time."}}`)
+		return
+	}
+	writeJSONResponse(w, sl)
+}
@@ -0,0 +1,27 @@
+package web
+
+// Tests for the server list snapshots
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSnapshotTime(t *testing.T) {
+	expected := time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC).Unix()
+	for _, val := range []string{"1451703845", "2016-01-02T03:04:05Z",
+		"2016-01-02T04:04:05+01:00"} {
+		at, err := parseSnapshotTime(val)
+		if err != nil {
+			t.Fatalf("Unexpected error for time %s: %s", val, err)
+		}
+		if at != expected {
+			t.Fatalf("Expected %d for time %s, got: %d", expected, val, at)
+		}
+	}
+	for _, val := range []string{"", "0", "-5", "yesterday", "2016-01-02"} {
+		if _, err := parseSnapshotTime(val); err == nil {
+			t.Fatalf("Expected error for time '%s'", val)
+		}
+	}
+}
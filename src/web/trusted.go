@@ -0,0 +1,131 @@
+package web
+
+// trusted.go - Exemption of trusted clients (i.e. load balancer health checks
+// and internal monitors) from the request limits and quotas, and identification
+// of the clients of requests that come through trusted reverse proxies
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/logger"
+)
+
+// The networks of the reverse proxies whose X-Forwarded-For headers are
+// honored; set when the router is created.
+var trustedProxies []*net.IPNet
+
+// loadTrustedProxies parses the configured trusted proxies.
+func loadTrustedProxies() {
+	trustedProxies = parseNetworks(config.Get().AdminConfig.TrustedProxies,
+		"trusted proxy")
+}
+
+// parseTrustedNetworks parses the configured trusted IP addresses and CIDR
+// ranges, skipping (and logging) invalid entries. It returns nil if there are
+// no valid entries.
+func parseTrustedNetworks(entries []string) []*net.IPNet {
+	return parseNetworks(entries, "trusted")
+}
+
+// parseNetworks parses IP addresses and CIDR ranges, skipping (and logging,
+// as the kind of entry described by what) invalid entries. It returns nil if
+// there are no valid entries.
+func parseNetworks(entries []string, what string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, e := range entries {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+		if !strings.Contains(e, "/") {
+			ip := net.ParseIP(e)
+			if ip == nil {
+				logger.LogAppErrorf("Ignoring invalid %s IP: %s", what, e)
+				continue
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(e)
+		if err != nil {
+			logger.LogAppErrorf("Ignoring invalid %s IP range: %s", what, e)
+			continue
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+// clientIP returns the IP address of the client that made the request. This is
+// the remote address, unless the request came from a trusted proxy (or over the
+// unix socket, which only a local reverse proxy can reach), in which case it is
+// the nearest address in X-Forwarded-For that isn't a trusted proxy. Addresses
+// that were added before an untrusted one are ignored, since the client could
+// have sent them.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil && config.Get().WebConfig.APIWebUnixSocket == "" {
+		return host
+	}
+	if ip != nil && !networksContain(trustedProxies, ip) {
+		return ip.String()
+	}
+	hops := strings.Split(strings.Join(r.Header["X-Forwarded-For"], ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !networksContain(trustedProxies, hop) {
+			break
+		}
+	}
+	if ip == nil {
+		return host
+	}
+	return ip.String()
+}
+
+// isTrustedRequest returns true if the request was made from a trusted network.
+func isTrustedRequest(r *http.Request, trusted []*net.IPNet) bool {
+	ip := net.ParseIP(clientIP(r))
+	if ip == nil {
+		return false
+	}
+	return networksContain(trusted, ip)
+}
+
+// networksContain returns true if ip is in any of the networks.
+func networksContain(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// exemptTrusted wraps an HTTP handler so that requests from trusted networks
+// are served by the exempt handler instead. The exempt handler must still
+// require the route's scope: trusted networks are only exempt from the limits.
+func exemptTrusted(h, exempt http.Handler, trusted []*net.IPNet) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isTrustedRequest(r, trusted) {
+			exempt.ServeHTTP(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,69 @@
+package web
+
+// virtual.go - Virtual servers: logical servers made up of several hosts, whose
+// stats are merged
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/models"
+)
+
+// buildVirtualServers merges the servers in the list that make up each of the
+// configured virtual servers. If game is not empty, only that game's virtual
+// servers are included.
+func buildVirtualServers(sl *models.APIServerList,
+	game string) *models.APIVirtualServerList {
+	byHost := make(map[string]*models.APIServer, len(sl.Servers))
+	for i := range sl.Servers {
+		byHost[sl.Servers[i].Host] = &sl.Servers[i]
+	}
+	offline := make(map[string]string, len(sl.OfflineServers))
+	for _, o := range sl.OfflineServers {
+		offline[o.Host] = o.Status
+	}
+	vl := &models.APIVirtualServerList{
+		RetrievedAt:        sl.RetrievedAt,
+		RetrievedTimeStamp: sl.RetrievedTimeStamp,
+		VirtualServers:     make([]models.APIVirtualServer, 0),
+	}
+	for _, def := range config.Get().SteamConfig.VirtualServers {
+		if game != "" && !strings.EqualFold(def.Game, game) {
+			continue
+		}
+		vs := models.NewAPIVirtualServer(def.Name, def.Game)
+		for _, h := range def.Hosts {
+			if s, ok := byHost[h]; ok {
+				vs.AddServer(s)
+			} else {
+				vs.AddMissingHost(h, offline[h])
+			}
+		}
+		vl.VirtualServers = append(vl.VirtualServers, *vs)
+	}
+	vl.VirtualServerCount = len(vl.VirtualServers)
+	return vl
+}
+
+func getVirtualServers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if len(config.Get().SteamConfig.VirtualServers) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w,
+			`{"error": {"code": 404,"message": "No virtual servers are configured."}}`)
+		return
+	}
+	asl, ok := getCurrentServerList(w, r)
+	if !ok {
+		return
+	}
+	// Empty (i.e. during first retrieval/startup)
+	if asl == nil {
+		asl = models.GetDefaultServerList()
+	}
+	game, _ := getQStringValue(r.URL.Query(), qsServersGame)
+	writeJSONResponse(w, buildVirtualServers(markStaleServers(asl), game))
+}
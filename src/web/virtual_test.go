@@ -0,0 +1,72 @@
+package web
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/syncore/a2sapi/src/config"
+	"github.com/syncore/a2sapi/src/models"
+)
+
+func TestBuildVirtualServers(t *testing.T) {
+	prev := config.Get().SteamConfig.VirtualServers
+	defer func() { config.Get().SteamConfig.VirtualServers = prev }()
+	config.Get().SteamConfig.VirtualServers = []config.VirtualServer{
+		{Name: "Cluster", Game: "TestGame",
+			Hosts: []string{"10.0.0.1:27015", "10.0.0.1:27016", "10.0.0.1:27017"}},
+		{Name: "Other", Game: "OtherGame", Hosts: []string{"10.0.0.2:27015"}},
+	}
+	sl := models.GetDefaultServerList()
+	sl.Servers = []models.APIServer{
+		{ID: 1, Host: "10.0.0.1:27015", Game: "TestGame",
+			Status: models.ServerStatusOnline,
+			Info: models.SteamServerInfo{Map: "lobby", Players: 3, MaxPlayers: 8,
+				Bots: 1},
+			Players: []models.SteamPlayerInfo{{Name: "a"}, {Name: "b"}, {Name: "c"}}},
+		{ID: 2, Host: "10.0.0.1:27016", Game: "TestGame",
+			Status:  models.ServerStatusOnline,
+			Info:    models.SteamServerInfo{Map: "arena", Players: 2, MaxPlayers: 16},
+			Players: []models.SteamPlayerInfo{{Name: "d"}, {Name: "e"}}},
+	}
+	sl.OfflineServers = []models.APIOfflineServer{{Host: "10.0.0.1:27017",
+		Status: models.ServerStatusTimedOut}}
+
+	vl := buildVirtualServers(sl, "testgame")
+	if vl.VirtualServerCount != 1 {
+		t.Fatalf("Expected 1 virtual server for the game, got: %d",
+			vl.VirtualServerCount)
+	}
+	vs := vl.VirtualServers[0]
+	if vs.Status != models.ServerStatusPartial || vs.OnlineCount != 2 {
+		t.Fatalf("Expected partial status with 2 of 3 hosts online, got: %s (%d)",
+			vs.Status, vs.OnlineCount)
+	}
+	if vs.PlayerCount != 5 || vs.MaxPlayerCount != 24 || vs.BotCount != 1 ||
+		len(vs.Players) != 5 {
+		t.Fatalf("Expected merged player counts, got: %+v", vs)
+	}
+	if len(vs.Members) != 3 || vs.Members[1].Map != "arena" ||
+		vs.Members[2].Status != models.ServerStatusTimedOut {
+		t.Fatalf("Unexpected members: %+v", vs.Members)
+	}
+
+	vl = buildVirtualServers(sl, "")
+	if vl.VirtualServerCount != 2 ||
+		vl.VirtualServers[1].Status != models.ServerStatusOffline {
+		t.Fatalf("Expected offline virtual server with no hosts listed, got: %+v",
+			vl.VirtualServers)
+	}
+}
+
+func TestGetVirtualServersDisabled(t *testing.T) {
+	prev := config.Get().SteamConfig.VirtualServers
+	defer func() { config.Get().SteamConfig.VirtualServers = prev }()
+	config.Get().SteamConfig.VirtualServers = nil
+
+	r, _ := http.NewRequest("GET", formatURL("servers/virtual"), nil)
+	w := newRecorder()
+	getVirtualServers(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected 404 without virtual servers, got: %d", w.Code)
+	}
+}
@@ -0,0 +1,254 @@
+package web
+
+// websocket.go - Minimal server side of the WebSocket protocol (RFC 6455), as
+// needed to push messages to clients: the opening handshake, unfragmented
+// messages from the server, and handling of the client's control frames.
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/syncore/a2sapi/src/config"
+)
+
+const (
+	wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	// maximum payload of frames read from clients, which only send control frames
+	// and (ignored) small messages
+	wsMaxReadPayload = 4096
+	wsWriteTimeout   = 10 * time.Second
+)
+
+// WebSocket opcodes
+const (
+	wsOpText  = 0x1
+	wsOpClose = 0x8
+	wsOpPing  = 0x9
+	wsOpPong  = 0xA
+)
+
+var (
+	errWSFrameTooLarge = errors.New("WebSocket frame too large")
+	errWSFrameUnmasked = errors.New("WebSocket frame from client is not masked")
+)
+
+// wsConn is a WebSocket connection; writes may be made from multiple goroutines.
+type wsConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+	mu   sync.Mutex
+}
+
+// headerContainsToken returns true if the comma-separated header contains the
+// token, ignoring case.
+func headerContainsToken(h http.Header, name, token string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isWebSocketRequest returns true if the request is a WebSocket opening
+// handshake.
+func isWebSocketRequest(r *http.Request) bool {
+	return r.Method == "GET" && headerContainsToken(r.Header, "Connection", "upgrade") &&
+		headerContainsToken(r.Header, "Upgrade", "websocket") &&
+		r.Header.Get("Sec-WebSocket-Key") != ""
+}
+
+// isWebSocketOriginAllowed returns true if the request has no Origin, i.e. is
+// not from a browser, or its Origin is the API's own host or one of the allowed
+// origins ("*" for any).
+func isWebSocketOriginAllowed(r *http.Request, allowed []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, a := range allowed {
+		if a == "*" || strings.EqualFold(strings.TrimSuffix(a, "/"),
+			u.Scheme+"://"+u.Host) {
+			return true
+		}
+	}
+	return false
+}
+
+// rejectWebSocketHandshake writes an error response and returns true if a
+// WebSocket opening handshake can't be accepted: its version is not 13, or it
+// is from a web page whose origin is not allowed.
+func rejectWebSocketHandshake(w http.ResponseWriter, r *http.Request) bool {
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w,
+			`{"error": {"code": 400,"message": "Unsupported WebSocket version."}}`)
+		return true
+	}
+	if !isWebSocketOriginAllowed(r, config.Get().WebConfig.WebSocketAllowedOrigins) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w,
+			`{"error": {"code": 403,"message": "WebSocket connections from this origin are not allowed."}}`)
+		return true
+	}
+	return false
+}
+
+// wsAcceptKey returns the Sec-WebSocket-Accept value for a client's key.
+func wsAcceptKey(key string) string {
+	h := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// upgradeWebSocket completes the opening handshake of a (version 13) WebSocket
+// request and takes over its connection.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, errors.New("WebSocket connections are not supported")
+	}
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	// clear the deadlines set by the HTTP server for the request
+	conn.SetDeadline(time.Time{})
+	resp := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\n" +
+		"Connection: Upgrade\r\nSec-WebSocket-Accept: " +
+		wsAcceptKey(r.Header.Get("Sec-WebSocket-Key")) + "\r\n\r\n"
+	conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if _, err := conn.Write([]byte(resp)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, br: brw.Reader}, nil
+}
+
+// writeFrame writes an unfragmented, unmasked frame.
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	hdr := make([]byte, 2, 10)
+	hdr[0] = 0x80 | opcode
+	switch n := len(payload); {
+	case n <= 125:
+		hdr[1] = byte(n)
+	case n <= 0xFFFF:
+		hdr[1] = 126
+		hdr = hdr[:4]
+		binary.BigEndian.PutUint16(hdr[2:], uint16(n))
+	default:
+		hdr[1] = 127
+		hdr = hdr[:10]
+		binary.BigEndian.PutUint64(hdr[2:], uint64(n))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if _, err := c.conn.Write(append(hdr, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// writeText sends a text message.
+func (c *wsConn) writeText(b []byte) error {
+	return c.writeFrame(wsOpText, b)
+}
+
+// readFrame reads a frame of at most max bytes from the client, unmasking its
+// payload; clients must mask every frame.
+func (c *wsConn) readFrame(max uint64) (byte, []byte, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.br, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode := hdr[0] & 0x0F
+	if hdr[1]&0x80 == 0 {
+		return 0, nil, errWSFrameUnmasked
+	}
+	n := uint64(hdr[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > max {
+		return 0, nil, errWSFrameTooLarge
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
+
+// readLoop handles the client's frames until the connection is closed, replying
+// to pings and close frames; messages from the client are ignored.
+func (c *wsConn) readLoop() {
+	for {
+		opcode, payload, err := c.readFrame(wsMaxReadPayload)
+		if err != nil {
+			switch err {
+			case errWSFrameTooLarge:
+				// 1009: message too big
+				c.writeFrame(wsOpClose, []byte{0x03, 0xF1})
+			case errWSFrameUnmasked:
+				// 1002: protocol error
+				c.writeFrame(wsOpClose, []byte{0x03, 0xEA})
+			}
+			return
+		}
+		switch opcode {
+		case wsOpPing:
+			if c.writeFrame(wsOpPong, payload) != nil {
+				return
+			}
+		case wsOpClose:
+			if len(payload) > 2 {
+				payload = payload[:2]
+			}
+			c.writeFrame(wsOpClose, payload)
+			return
+		}
+	}
+}
+
+func (c *wsConn) close() error {
+	return c.conn.Close()
+}